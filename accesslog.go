@@ -0,0 +1,201 @@
+package fedbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// accessLogEntry contains the fields we log for every request
+type accessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Status    int           `json:"status"`
+	Bytes     int           `json:"bytes"`
+	Duration  time.Duration `json:"-"`
+	Remote    string        `json:"remote"`
+	Actor     vocab.IRI     `json:"actor,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+}
+
+// MarshalJSON adds the duration in milliseconds, which is easier to process than the nanoseconds
+func (e accessLogEntry) MarshalJSON() ([]byte, error) {
+	type entry accessLogEntry
+	return json.Marshal(struct {
+		entry
+		DurationMs float64 `json:"duration_ms"`
+	}{entry: entry(e), DurationMs: float64(e.Duration) / float64(time.Millisecond)})
+}
+
+// String returns the human readable line of the e entry, with the missing values shown as "-"
+func (e accessLogEntry) String() string {
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	return fmt.Sprintf("%s %s %s \"%s %s\" %d %d %s %s", e.Time.Format(time.RFC3339), orDash(e.Remote),
+		orDash(e.Actor.String()), e.Method, e.Path, e.Status, e.Bytes, e.Duration, orDash(e.RequestID))
+}
+
+// requestActorKey is the request context key of the actor that has authorized the request
+type requestActorKey struct{}
+
+// requestActor keeps the actor loaded from the credentials of a request, so they are verified only once for
+// each request, and the access logger can show it after the request has been served.
+type requestActor struct {
+	m      sync.Mutex
+	act    vocab.Actor
+	loaded bool
+}
+
+// requestActorFrom returns the place of the actor in the r request context, or nil when it doesn't have one
+func requestActorFrom(r *http.Request) *requestActor {
+	a, _ := r.Context().Value(requestActorKey{}).(*requestActor)
+	return a
+}
+
+// withRequestActor returns the r request with a place for its actor in the context, if it didn't have one already
+func withRequestActor(r *http.Request) (*http.Request, *requestActor) {
+	if a := requestActorFrom(r); a != nil {
+		return r, a
+	}
+	a := new(requestActor)
+	return r.WithContext(context.WithValue(r.Context(), requestActorKey{}, a)), a
+}
+
+// RequestActorMiddleware adds to the requests a place for the actor that has authorized them
+func RequestActorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = withRequestActor(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// load returns the actor of the request, loading it with loadFn only the first time
+func (a *requestActor) load(loadFn func() vocab.Actor) vocab.Actor {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if !a.loaded {
+		a.act = loadFn()
+		a.loaded = true
+	}
+	return a.act
+}
+
+// iri returns the IRI of the actor, if it has been loaded during the request and it's not anonymous
+func (a *requestActor) iri() vocab.IRI {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if !a.loaded || isAnonymous(a.act) {
+		return ""
+	}
+	return a.act.GetLink()
+}
+
+// accessLogger writes a line for every request it serves to out, in the human or JSON format
+type accessLogger struct {
+	format config.AccessLogFormat
+	m      sync.Mutex
+	out    io.Writer
+}
+
+func newAccessLogger(format config.AccessLogFormat, out io.Writer) *accessLogger {
+	return &accessLogger{format: format, out: out}
+}
+
+// logWriter writes the access log lines through the application logger
+type logWriter LogFn
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w("%s", bytes.TrimRight(p, "\n"))
+	return len(p), nil
+}
+
+// openAccessLog returns the access logger writing to the sink: the standard output, the standard error, the
+// application logFn logger, or the file at the sink path, where the lines are appended.
+// It returns nil when the access log is turned off.
+func openAccessLog(format config.AccessLogFormat, sink string, logFn LogFn) (*accessLogger, error) {
+	switch sink {
+	case config.AccessLogOff:
+		return nil, nil
+	case config.AccessLogLogger:
+		return newAccessLogger(format, logWriter(logFn)), nil
+	case "", config.AccessLogStdout:
+		return newAccessLogger(format, os.Stdout), nil
+	case config.AccessLogStderr:
+		return newAccessLogger(format, os.Stderr), nil
+	}
+	f, err := os.OpenFile(sink, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to open the access log %s", sink)
+	}
+	return newAccessLogger(format, f), nil
+}
+
+// Close closes the file the access log is written to, the standard output and error are left open
+func (l *accessLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	if f, ok := l.out.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		return f.Close()
+	}
+	return nil
+}
+
+func (l *accessLogger) write(e accessLogEntry) {
+	var line []byte
+	if l.format == config.AccessLogJSON {
+		line, _ = json.Marshal(e)
+	} else {
+		line = []byte(e.String())
+	}
+	line = append(line, '\n')
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.out.Write(line)
+}
+
+// Middleware logs the method, path, status, size and duration of the requests, together with the address
+// of the client, the authorized actor and the request id.
+// The actor is the one loaded by the handlers while serving the request, the logger doesn't verify
+// the credentials of the request itself.
+func (l *accessLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, act := withRequestActor(r)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		l.write(accessLogEntry{
+			Time:      start.UTC(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    status,
+			Bytes:     ww.BytesWritten(),
+			Duration:  time.Since(start),
+			Remote:    remoteIP(r),
+			Actor:     act.iri(),
+			RequestID: middleware.GetReqID(r.Context()),
+		})
+	})
+}
@@ -0,0 +1,144 @@
+package fedbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestAccessLogger(t *testing.T) {
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	loads := 0
+	actorFn := func() vocab.Actor {
+		loads++
+		return johnDoe
+	}
+	handler := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			// NOTE(marius): the handlers load the actor of the request as many times as they need it
+			requestActorFrom(r).load(actorFn)
+			requestActorFrom(r).load(actorFn)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/actors/johndoe/outbox?page=1", nil)
+		r.RemoteAddr = "192.0.2.1:54321"
+		r.Header.Set("Authorization", "Bearer token")
+		return r
+	}
+
+	t.Run("json", func(t *testing.T) {
+		out := bytes.Buffer{}
+		loads = 0
+		l := newAccessLogger(config.AccessLogJSON, &out)
+		middleware.RequestID(l.Middleware(handler)).ServeHTTP(httptest.NewRecorder(), newRequest())
+		if loads != 1 {
+			t.Errorf("the actor of the request has been loaded %d times, expected once", loads)
+		}
+
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+			t.Fatalf("unable to unmarshal the log line %q: %s", out.String(), err)
+		}
+		expected := map[string]interface{}{
+			"method": http.MethodPost,
+			"path":   "/actors/johndoe/outbox",
+			"status": float64(http.StatusCreated),
+			"bytes":  float64(len("created")),
+			"remote": "192.0.2.1",
+			"actor":  johnDoe.ID.String(),
+		}
+		for k, v := range expected {
+			if fields[k] != v {
+				t.Errorf("the %s field is %v, expected %v", k, fields[k], v)
+			}
+		}
+		for _, k := range []string{"time", "duration_ms", "request_id"} {
+			if _, ok := fields[k]; !ok {
+				t.Errorf("missing the %s field in %q", k, out.String())
+			}
+		}
+		if id, _ := fields["request_id"].(string); id == "" {
+			t.Errorf("empty request id in %q", out.String())
+		}
+	})
+	t.Run("human", func(t *testing.T) {
+		out := bytes.Buffer{}
+		l := newAccessLogger(config.AccessLogHuman, &out)
+		middleware.RequestID(l.Middleware(handler)).ServeHTTP(httptest.NewRecorder(), newRequest())
+
+		line := out.String()
+		if strings.Count(line, "\n") != 1 {
+			t.Fatalf("expected a single log line, got %q", line)
+		}
+		for _, s := range []string{"192.0.2.1 ", johnDoe.ID.String(), `"POST /actors/johndoe/outbox"`, " 201 7 "} {
+			if !strings.Contains(line, s) {
+				t.Errorf("the log line %q doesn't contain %q", line, s)
+			}
+		}
+	})
+	t.Run("anonymous", func(t *testing.T) {
+		out := bytes.Buffer{}
+		loads = 0
+		l := newAccessLogger(config.AccessLogJSON, &out)
+		r := newRequest()
+		r.Header.Del("Authorization")
+		l.Middleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+		if loads != 0 {
+			t.Errorf("the access logger has loaded the actor of the request")
+		}
+		if strings.Contains(out.String(), `"actor"`) {
+			t.Errorf("the log line %q contains an actor for an anonymous request", out.String())
+		}
+	})
+}
+
+func TestOpenAccessLog(t *testing.T) {
+	l, err := openAccessLog(config.AccessLogHuman, config.AccessLogOff, nil)
+	if err != nil || l != nil {
+		t.Errorf("openAccessLog(%q) = %v, %v, expected no access logger", config.AccessLogOff, l, err)
+	}
+	if l, _ = openAccessLog(config.AccessLogHuman, "", nil); l == nil || l.out != os.Stdout {
+		t.Errorf("openAccessLog() without a sink should write to the standard output")
+	}
+
+	var logged []string
+	logFn := func(s string, p ...interface{}) { logged = append(logged, fmt.Sprintf(s, p...)) }
+	if l, _ = openAccessLog(config.AccessLogHuman, config.AccessLogLogger, logFn); l == nil {
+		t.Fatalf("openAccessLog(%q) should return an access logger", config.AccessLogLogger)
+	}
+	l.write(accessLogEntry{Method: http.MethodGet, Path: "/", Status: http.StatusOK})
+	if len(logged) != 1 || !strings.Contains(logged[0], `"GET /" 200`) || strings.HasSuffix(logged[0], "\n") {
+		t.Errorf("the application logger received %q, expected a single access log line", logged)
+	}
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	for i := 0; i < 2; i++ {
+		if l, err = openAccessLog(config.AccessLogHuman, path, nil); err != nil {
+			t.Fatalf("openAccessLog(%q) returned error %s", path, err)
+		}
+		l.write(accessLogEntry{Method: http.MethodGet, Path: "/", Status: http.StatusOK})
+		if err = l.Close(); err != nil {
+			t.Errorf("Close() returned error %s", err)
+		}
+	}
+	data, _ := os.ReadFile(path)
+	if cnt := strings.Count(string(data), "\n"); cnt != 2 {
+		t.Errorf("the access log file has %d lines, expected the 2 lines to be appended", cnt)
+	}
+	if _, err = openAccessLog(config.AccessLogHuman, filepath.Join(path, "invalid"), nil); err == nil {
+		t.Errorf("openAccessLog() of an invalid path should return an error")
+	}
+}
@@ -0,0 +1,91 @@
+package fedbox
+
+import (
+	"net/http"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+	"github.com/go-chi/chi/v5"
+)
+
+const activityPath = "activity"
+
+// buildIRIs returns the IRIs where an item with the hash can be stored in the col collection of the base service.
+// For the activities collection, besides the global one, we look in the outboxes of the local actors, as that's
+// where GenerateID puts the activities which are not public.
+func buildIRIs(db processing.ReadStore, base vocab.IRI, col vocab.CollectionPath, hash string) vocab.IRIs {
+	iris := vocab.IRIs{col.IRI(base).AddPath(hash)}
+	if col != filters.ActivitiesType {
+		return iris
+	}
+	actors, err := db.Load(filters.ActorsType.IRI(base))
+	if err != nil {
+		return iris
+	}
+	vocab.OnCollectionIntf(actors, func(c vocab.CollectionInterface) error {
+		for _, act := range c.Collection() {
+			if vocab.IsNil(act) || !act.GetLink().Contains(base, false) {
+				continue
+			}
+			iri := vocab.Outbox.IRI(act).AddPath(hash)
+			if !iris.Contains(iri) {
+				iris = append(iris, iri)
+			}
+		}
+		return nil
+	})
+	return iris
+}
+
+// loadActivityByID returns the activity with the hash, regardless of which actor's outbox it lives in,
+// if the "by" actor is allowed to see it.
+func loadActivityByID(db processing.ReadStore, base vocab.IRI, hash string, by vocab.Actor) (vocab.Item, error) {
+	if len(hash) == 0 || strings.ContainsAny(hash, "/?#") {
+		return nil, errors.BadRequestf("invalid activity id %q", hash)
+	}
+	for _, iri := range buildIRIs(db, base, filters.ActivitiesType, hash) {
+		it, err := db.Load(iri)
+		if err != nil {
+			continue
+		}
+		// NOTE(marius): the storage can return a collection for an IRI it doesn't have, so we make sure
+		// that what we loaded is the activity itself
+		it = firstItem(it)
+		if vocab.IsNil(it) || !it.GetLink().Equals(iri, false) || !isActivityType(it.GetType()) {
+			continue
+		}
+		if !isVisibleTo(it, by) {
+			break
+		}
+		return it, nil
+	}
+	return nil, errors.NotFoundf("activity %s not found", hash)
+}
+
+// isActivityType checks if typ is one of the activity types, including the intransitive ones
+func isActivityType(typ vocab.ActivityVocabularyType) bool {
+	return vocab.ActivityTypes.Contains(typ) || vocab.IntransitiveActivityTypes.Contains(typ)
+}
+
+func handleActivityByID(db processing.ReadStore, base vocab.IRI, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		it, err := loadActivityByID(db, base, chi.URLParam(r, "hash"), actorFn(r))
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if s, ok := it.(vocab.HasRecipients); ok {
+			s.Clean()
+		}
+		writeItem(w, r, http.StatusOK, it)
+	}
+}
+
+// HandleActivityByID serves the activity identified only by its hash, looking it up in the activities
+// collection, so the tools which don't know the actor which has published it can still load it.
+func HandleActivityByID(fb FedBOX) http.HandlerFunc {
+	return handleActivityByID(fb.storage, vocab.IRI(fb.Config().BaseURL), fb.actorFromRequest)
+}
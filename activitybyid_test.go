@@ -0,0 +1,80 @@
+package fedbox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestHandleActivityByID(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	public := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/public-hash",
+		Type:   vocab.CreateType,
+		Actor:  johnDoe.ID,
+		Object: vocab.IRI("https://fedbox.local/objects/1"),
+		To:     vocab.ItemCollection{vocab.PublicNS},
+	}
+	private := &vocab.Activity{
+		ID:     "https://fedbox.local/actors/johndoe/outbox/private-hash",
+		Type:   vocab.LikeType,
+		Actor:  johnDoe.ID,
+		Object: vocab.IRI("https://fedbox.local/objects/1"),
+		To:     vocab.ItemCollection{johnDoe.ID},
+	}
+	note := &vocab.Object{ID: "https://fedbox.local/activities/note-hash", Type: vocab.NoteType}
+
+	db := mockStore{}
+	db.Save(&vocab.OrderedCollection{
+		ID:           "https://fedbox.local/actors",
+		Type:         vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{johnDoe.ID},
+	})
+	db.Save(johnDoe)
+	db.Save(public)
+	db.Save(private)
+	db.Save(note)
+
+	tests := []struct {
+		name   string
+		hash   string
+		by     vocab.Actor
+		status int
+		iri    vocab.IRI
+	}{
+		{name: "public activity", hash: "public-hash", status: http.StatusOK, iri: public.ID},
+		{name: "activity in outbox", hash: "private-hash", by: *johnDoe, status: http.StatusOK, iri: private.ID},
+		{name: "private activity for anonymous", hash: "private-hash", status: http.StatusNotFound},
+		{name: "not an activity", hash: "note-hash", status: http.StatusNotFound},
+		{name: "missing activity", hash: "missing-hash", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("hash", tt.hash)
+			r := httptest.NewRequest(http.MethodGet, "/"+activityPath+"/"+tt.hash, nil)
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+			w := httptest.NewRecorder()
+
+			handleActivityByID(db, base, func(*http.Request) vocab.Actor { return tt.by }).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("loading %s returned status %d, expected %d", tt.hash, w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			it, err := vocab.UnmarshalJSON(w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("unable to unmarshal response: %s", err)
+			}
+			if !it.GetLink().Equals(tt.iri, false) {
+				t.Errorf("loaded %s, expected %s", it.GetLink(), tt.iri)
+			}
+		})
+	}
+}
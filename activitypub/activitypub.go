@@ -8,7 +8,6 @@ import (
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/filters"
 	"github.com/go-ap/processing"
-	"github.com/pborman/uuid"
 )
 
 const developer = vocab.IRI("https://github.com/mariusor")
@@ -58,10 +57,18 @@ func LoadActor(st processing.ReadStore, iri vocab.IRI) (vocab.Actor, error) {
 	return act, err
 }
 
-// GenerateID generates an unique identifier for the it ActivityPub Object.
+// GenerateID generates an unique identifier for the it ActivityPub Object, using a random UUID.
 func GenerateID(it vocab.Item, partOf vocab.IRI, by vocab.Item) (vocab.ID, error) {
-	uuid := uuid.New()
-	id := partOf.GetLink().AddPath(uuid)
+	return GenerateIDWith(UUIDGenerator{}, it, partOf, by)
+}
+
+// GenerateIDWith generates an unique identifier for the it ActivityPub Object, using the gen IDGenerator.
+func GenerateIDWith(gen IDGenerator, it vocab.Item, partOf vocab.IRI, by vocab.Item) (vocab.ID, error) {
+	name, err := gen.NewID(it)
+	if err != nil {
+		return "", err
+	}
+	id := partOf.GetLink().AddPath(name)
 	typ := it.GetType()
 	if vocab.ActivityTypes.Contains(typ) || vocab.IntransitiveActivityTypes.Contains(typ) {
 		err := vocab.OnActivity(it, func(a *vocab.Activity) error {
@@ -73,7 +80,7 @@ func GenerateID(it vocab.Item, partOf vocab.IRI, by vocab.Item) (vocab.ID, error
 				if !vocab.IsNil(by) {
 					// if it's not a public activity, save it to it's actor outbox instead of global activities collection
 					outbox := vocab.Outbox.IRI(by)
-					id = vocab.ID(fmt.Sprintf("%s/%s", outbox, uuid))
+					id = vocab.ID(fmt.Sprintf("%s/%s", outbox, name))
 				}
 			}
 			a.ID = id
@@ -0,0 +1,134 @@
+package activitypub
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/pborman/uuid"
+)
+
+const (
+	// IDGeneratorUUID generates random UUIDs, the default
+	IDGeneratorUUID = "uuid"
+	// IDGeneratorULID generates ULIDs, which sort in the order they have been created
+	IDGeneratorULID = "ulid"
+	// IDGeneratorHash generates hashes of the content of the items
+	IDGeneratorHash = "hash"
+)
+
+// IDGenerator generates the unique last path segment of the IDs of the new items
+type IDGenerator interface {
+	NewID(it vocab.Item) (string, error)
+}
+
+// NewIDGenerator returns the IDGenerator for the strategy, which can be one of "uuid", "ulid" or "hash".
+// An empty strategy returns the UUID generator.
+func NewIDGenerator(strategy string) (IDGenerator, error) {
+	switch strings.ToLower(strategy) {
+	case IDGeneratorUUID, "":
+		return UUIDGenerator{}, nil
+	case IDGeneratorULID:
+		return NewULIDGenerator(), nil
+	case IDGeneratorHash:
+		return HashGenerator{}, nil
+	}
+	return nil, errors.Newf("invalid ID generator %q, it must be one of %s, %s or %s", strategy, IDGeneratorUUID, IDGeneratorULID, IDGeneratorHash)
+}
+
+// UUIDGenerator generates random UUIDs
+type UUIDGenerator struct{}
+
+func (UUIDGenerator) NewID(vocab.Item) (string, error) {
+	return uuid.New(), nil
+}
+
+// crockford is the Crockford's base32 alphabet used by the ULIDs, whose order matches the one of the encoded values
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator generates ULIDs: a millisecond timestamp followed by 80 random bits, encoded so the IDs created
+// later sort after the ones created earlier. Like that the storage backends keeping their keys in order, like
+// boltdb, keep the items in chronological order.
+// The IDs generated in the same millisecond increment the random part of the previous one, so they keep their order.
+type ULIDGenerator struct {
+	m       sync.Mutex
+	now     func() time.Time
+	entropy io.Reader
+	lastMs  uint64
+	last    [10]byte
+}
+
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{now: time.Now, entropy: rand.Reader}
+}
+
+func (g *ULIDGenerator) NewID(vocab.Item) (string, error) {
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	ms := uint64(g.now().UnixMilli())
+	if ms == g.lastMs {
+		// NOTE(marius): increment the 80 bit random part as a big endian number
+		i := len(g.last) - 1
+		for ; i >= 0; i-- {
+			if g.last[i]++; g.last[i] != 0 {
+				break
+			}
+		}
+		if i < 0 {
+			return "", errors.Newf("too many ULIDs generated in the same millisecond")
+		}
+	} else {
+		if _, err := io.ReadFull(g.entropy, g.last[:]); err != nil {
+			return "", errors.Annotatef(err, "unable to read the random part of the ULID")
+		}
+	}
+	g.lastMs = ms
+	return encodeULID(ms, g.last), nil
+}
+
+// encodeULID encodes the 48 bit timestamp and the 80 bit random part into the 26 characters of the ULID
+func encodeULID(ms uint64, random [10]byte) string {
+	var raw [16]byte
+	binary.BigEndian.PutUint16(raw[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(raw[2:6], uint32(ms))
+	copy(raw[6:], random[:])
+
+	hi := binary.BigEndian.Uint64(raw[0:8])
+	lo := binary.BigEndian.Uint64(raw[8:16])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// HashGenerator generates the hex encoded SHA-256 hash of the content of the items, together with the time of
+// their creation, so the identical items created at different times don't get the same ID.
+type HashGenerator struct {
+	now func() time.Time
+}
+
+func (g HashGenerator) NewID(it vocab.Item) (string, error) {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to hash the item")
+	}
+	now := time.Now
+	if g.now != nil {
+		now = g.now
+	}
+	h := sha256.New()
+	h.Write(raw)
+	binary.Write(h, binary.BigEndian, now().UnixNano())
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
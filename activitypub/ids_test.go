@@ -0,0 +1,75 @@
+package activitypub
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestNewIDGenerator(t *testing.T) {
+	partOf := vocab.IRI("http://example.com/objects")
+	for _, strategy := range []string{"", IDGeneratorUUID, IDGeneratorULID, IDGeneratorHash} {
+		t.Run(strategy, func(t *testing.T) {
+			gen, err := NewIDGenerator(strategy)
+			if err != nil {
+				t.Fatalf("NewIDGenerator(%q) returned error %s", strategy, err)
+			}
+			seen := make(map[vocab.ID]bool)
+			for i := 0; i < 1000; i++ {
+				it := &vocab.Object{Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguageValue("same content")}
+				id, err := GenerateIDWith(gen, it, partOf, nil)
+				if err != nil {
+					t.Fatalf("GenerateIDWith() returned error %s", err)
+				}
+				if !strings.HasPrefix(id.String(), partOf.String()+"/") || len(id) == len(partOf)+1 {
+					t.Fatalf("invalid ID %s, it should be under %s", id, partOf)
+				}
+				if _, err := id.URL(); err != nil {
+					t.Fatalf("invalid ID %s: %s", id, err)
+				}
+				if it.ID != id {
+					t.Fatalf("the ID of the item is %s, expected %s", it.ID, id)
+				}
+				if seen[id] {
+					t.Fatalf("the %s ID has been generated twice", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+	if _, err := NewIDGenerator("sequential"); err == nil {
+		t.Errorf("NewIDGenerator() should return an error for an invalid strategy")
+	}
+}
+
+func TestULIDGenerator(t *testing.T) {
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	gen := NewULIDGenerator()
+	gen.now = func() time.Time { return now }
+
+	ids := make([]string, 0)
+	for i := 0; i < 100; i++ {
+		// NOTE(marius): a few IDs are generated in the same millisecond
+		if i%3 == 0 {
+			now = now.Add(time.Duration(i) * time.Millisecond)
+		}
+		id, err := gen.NewID(nil)
+		if err != nil {
+			t.Fatalf("NewID() returned error %s", err)
+		}
+		if len(id) != 26 {
+			t.Fatalf("invalid ULID %s, it should have 26 characters", id)
+		}
+		ids = append(ids, id)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("the ULIDs should sort in the order they have been generated: %v", ids)
+	}
+	if got, want := encodeULID(uint64(start.UnixMilli()), [10]byte{}), "01GNPCC6800000000000000000"; got != want {
+		t.Errorf("the ULID of %s is %s, expected %s", start, got, want)
+	}
+}
@@ -0,0 +1,18 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// checkAdmin verifies that the act actor is allowed to use the administrative end-points.
+// Currently only the instance's self service actor is considered an administrator.
+func checkAdmin(act vocab.Actor, self vocab.Item) error {
+	if isAnonymous(act) {
+		return errors.Unauthorizedf("authorization required")
+	}
+	if vocab.IsNil(self) || !act.GetLink().Equals(self.GetLink(), false) {
+		return errors.Forbiddenf("%s is not an administrator", act.GetLink())
+	}
+	return nil
+}
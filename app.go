@@ -2,10 +2,14 @@ package fedbox
 
 import (
 	"context"
+	"crypto/tls"
+	"io"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"git.sr.ht/~mariusor/lw"
 	w "git.sr.ht/~mariusor/wrapper"
@@ -38,6 +42,19 @@ type FedBOX struct {
 	storage      FullStorage
 	ver          string
 	caches       cache.CanStore
+	metrics      *metrics
+	idempotency  *idempotencyKeys
+	keys         *keyCache
+	deliveries   *deliveryQueue
+	remote       *remoteFetcher
+	search       *searchIndex
+	streams      *streamHub
+	sanitizer    htmlPolicy
+	idGenerator  ap.IDGenerator
+	readOnly     *readOnlyMode
+	collections  *st.CollectionLocks
+	certs        *certReloader
+	accessLog    *accessLogger
 	OAuth        authService
 	keyGenerator func(act *vocab.Actor) error
 	stopFn       func()
@@ -78,13 +95,14 @@ func New(l lw.Logger, ver string, conf config.Options, db FullStorage) (*FedBOX,
 		return nil, errors.Newf("invalid empty BaseURL config")
 	}
 	app := FedBOX{
-		ver:     ver,
-		conf:    conf,
-		R:       chi.NewRouter(),
-		storage: db,
-		stopFn:  emptyStopFn,
-		logger:  l,
-		caches:  cache.New(conf.RequestCache),
+		ver:         ver,
+		conf:        conf,
+		R:           chi.NewRouter(),
+		storage:     db,
+		stopFn:      emptyStopFn,
+		logger:      l,
+		caches:      newRequestCache(conf, l),
+		collections: st.NewCollectionLocks(),
 	}
 
 	if metaSaver, ok := db.(st.MetadataTyper); ok {
@@ -94,9 +112,21 @@ func New(l lw.Logger, ver string, conf config.Options, db FullStorage) (*FedBOX,
 		}
 
 		l.Infof("Setting actor key generator %T[%s]", metaSaver, keysType)
-		app.keyGenerator = AddKeyToPerson(metaSaver, keysType)
+		app.keyGenerator = AddKeyToPerson(metaSaver, keysType, conf.PublicKeyEncoding)
 	}
 
+	db, err := withStorageOptions(db, conf)
+	if err != nil {
+		l.Errorf("Unable to open the storage: %s", err)
+		return nil, err
+	}
+	app.storage = withSlowLog(db, conf.StorageSlowThreshold, func(ctx lw.Ctx, s string, p ...interface{}) {
+		l.WithContext(ctx).Warnf(s, p...)
+	})
+	// NOTE(marius): the activities, the handlers and the background tasks can change the same collections
+	// at the same time, so we serialize the changes to each of them
+	app.storage = withSerializedCollections(app.storage, app.collections)
+
 	errors.IncludeBacktrace = conf.LogLevel == lw.TraceLevel
 
 	selfIRI := ap.DefaultServiceIRI(conf.BaseURL)
@@ -115,10 +145,15 @@ func New(l lw.Logger, ver string, conf config.Options, db FullStorage) (*FedBOX,
 			keysType = KeyTypeRSA
 		}
 		if saver, ok := db.(st.MetadataTyper); ok {
-			if err := AddKeyToPerson(saver, keysType)(&app.self); err != nil {
+			if err := AddKeyToPerson(saver, keysType, conf.PublicKeyEncoding)(&app.self); err != nil {
 				app.errFn("unable to save the instance's self service public key: %s", err)
 			}
 		}
+	} else if metaLoader, ok := db.(st.MetadataTyper); ok && refreshPublicKey(metaLoader, &app.self, conf.PublicKeyEncoding) {
+		app.infFn("updating the encoding of the instance's self service public key to %s", conf.PublicKeyEncoding)
+		if _, err := db.Save(&app.self); err != nil {
+			app.errFn("unable to save the instance's self service public key: %s", err)
+		}
 	}
 
 	app.client = *client.New(
@@ -126,10 +161,14 @@ func New(l lw.Logger, ver string, conf config.Options, db FullStorage) (*FedBOX,
 		client.SkipTLSValidation(!conf.Env.IsProd()),
 	)
 
+	app.keys = newKeyCache(&app.client, conf.PublicKeyCacheSize, conf.PublicKeyCacheTTL)
+	app.deliveries = newDeliveryQueue(&app.client, conf.DeliveryMaxAttempts, conf.DeliveryRetryInterval, conf.DeliveryMarkUnreachable)
+	app.remote = newRemoteFetcher(&app.client, app.storage, vocab.IRI(conf.BaseURL), conf.FetchTimeout)
+
 	as, err := auth.New(
 		auth.WithURL(conf.BaseURL),
 		auth.WithStorage(app.storage),
-		auth.WithClient(&app.client),
+		auth.WithClient(app.keys),
 		auth.WithLogger(l.WithContext(lw.Ctx{"log": "osin"})),
 	)
 	if err != nil {
@@ -137,16 +176,53 @@ func New(l lw.Logger, ver string, conf config.Options, db FullStorage) (*FedBOX,
 		return nil, err
 	}
 
+	configureOAuth2Server(as, conf.OAuth2AccessExpiration)
+
+	app.metrics = newMetrics(db, selfIRI)
+	app.idempotency = newIdempotencyKeys(conf.IdempotencyKeyTTL)
+	app.search = newSearchIndex(vocab.IRI(conf.BaseURL))
+	app.streams = newStreamHub()
+	app.sanitizer = parseHTMLPolicy(conf.HTMLAllowedTags)
+	if app.idGenerator, err = ap.NewIDGenerator(conf.IDGenerator); err != nil {
+		l.Warnf(err.Error())
+		return nil, err
+	}
+	app.readOnly = newReadOnlyMode(conf.MaintenanceMode)
+
+	limiter, err := newRateLimiter(conf.RateLimitRead, conf.RateLimitWrite, conf.RateLimitAllow)
+	if err != nil {
+		l.Warnf(err.Error())
+		return nil, err
+	}
+	proxies, err := newTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		l.Warnf(err.Error())
+		return nil, err
+	}
+
+	if app.accessLog, err = openAccessLog(conf.AccessLogFormat, conf.AccessLog, l.WithContext(lw.Ctx{"log": "access"}).Infof); err != nil {
+		l.Warnf(err.Error())
+		return nil, err
+	}
+
 	app.R.Use(middleware.RequestID)
-	app.R.Use(lw.Middlewares(l)...)
+	app.R.Use(app.metrics.Middleware)
+	app.R.Use(proxies.Middleware)
+	app.R.Use(RequestActorMiddleware)
+	if app.accessLog != nil {
+		app.R.Use(app.accessLog.Middleware)
+	}
+	app.R.Use(limiter.Middleware)
+	app.R.Use(app.readOnly.Middleware)
 
 	baseIRI := app.self.GetLink()
 	app.OAuth = authService{
-		baseIRI: baseIRI,
-		auth:    *as,
-		genID:   GenerateID(baseIRI),
-		storage: app.storage,
-		logger:  l.WithContext(lw.Ctx{"log": "auth-service"}),
+		baseIRI:           baseIRI,
+		auth:              *as,
+		genID:             GenerateIDWith(baseIRI, app.idGenerator),
+		storage:           app.storage,
+		refreshExpiration: conf.OAuth2RefreshExpiration,
+		logger:            l.WithContext(lw.Ctx{"log": "auth-service"}),
 	}
 
 	app.R.Group(app.Routes())
@@ -154,6 +230,19 @@ func New(l lw.Logger, ver string, conf config.Options, db FullStorage) (*FedBOX,
 	return &app, err
 }
 
+// newRequestCache returns the cache for the requests handlers, using the backend in the conf configuration.
+// When the Redis server is unreachable we fall back to the in-memory cache, so the instance can still start.
+func newRequestCache(conf config.Options, l lw.Logger) cache.CanStore {
+	if conf.RequestCache && conf.CacheBackend == config.CacheRedis {
+		c, err := cache.NewRedis(conf.CacheURL, conf.RequestCacheTTL)
+		if err == nil {
+			return c
+		}
+		l.Warnf("Unable to use the Redis cache, falling back to the in-memory one: %+s", err)
+	}
+	return cache.New(conf.RequestCache, conf.RequestCacheSize, conf.RequestCacheTTL)
+}
+
 func (f *FedBOX) Config() config.Options {
 	return f.conf
 }
@@ -167,18 +256,50 @@ func (f *FedBOX) Stop() {
 	if st, ok := f.storage.(osin.Storage); ok {
 		st.Close()
 	}
+	if c, ok := f.caches.(io.Closer); ok {
+		c.Close()
+	}
+	f.accessLog.Close()
 	f.stopFn()
 }
 
 func (f *FedBOX) reload() (err error) {
-	f.conf, err = config.LoadFromEnv(f.conf.Env, f.conf.TimeOut)
+	var conf config.Options
+	if len(f.conf.ConfigFile) > 0 {
+		conf, err = config.LoadFromFile(f.conf.ConfigFile, f.conf.Env, f.conf.TimeOut)
+	} else {
+		conf, err = config.LoadFromEnv(f.conf.Env, f.conf.TimeOut)
+	}
+	if err != nil {
+		// NOTE(marius): we keep running with the current configuration
+		return err
+	}
+	f.conf = conf
 	f.caches.Remove()
+	f.readOnly.set(f.conf.MaintenanceMode)
+	if f.certs != nil && f.conf.Secure {
+		if cErr := f.certs.reload(f.conf.CertPath, f.conf.KeyPath); cErr != nil {
+			f.errFn("keeping the current TLS certificate: %+s", cErr)
+		}
+	}
 	return err
 }
 
+// actorFromRequest returns the actor that has authorized the r request. The credentials are verified only
+// the first time for each request, the actor is kept in the request context for the following calls.
 func (f *FedBOX) actorFromRequest(r *http.Request) vocab.Actor {
+	if a := requestActorFrom(r); a != nil {
+		return a.load(func() vocab.Actor { return f.loadActorFromRequest(r) })
+	}
+	return f.loadActorFromRequest(r)
+}
+
+func (f *FedBOX) loadActorFromRequest(r *http.Request) vocab.Actor {
 	act, err := f.OAuth.auth.LoadActorFromAuthHeader(r)
 	if err != nil {
+		if prev, ok := actorSignedWithPreviousKey(f.storage, vocab.IRI(f.conf.BaseURL), r, time.Now().UTC()); ok {
+			return prev
+		}
 		f.logger.Errorf("unable to load an authorized Actor from request: %+s", err)
 	}
 	return act
@@ -186,55 +307,76 @@ func (f *FedBOX) actorFromRequest(r *http.Request) vocab.Actor {
 
 // Run is the wrapper for starting the web-server and handling signals
 func (f *FedBOX) Run(c context.Context) error {
-	// Create a deadline to wait for.
-	ctx, cancelFn := context.WithTimeout(c, f.conf.TimeOut)
-	defer cancelFn()
-
-	sockType := ""
-	setters := []w.SetFn{w.Handler(f.R)}
-
+	var tlsConf *tls.Config
 	if f.conf.Secure {
 		if len(f.conf.CertPath)+len(f.conf.KeyPath) > 0 {
-			setters = append(setters, w.WithTLSCert(f.conf.CertPath, f.conf.KeyPath))
+			// NOTE: we serve the certificate through a reloader, so a renewed certificate
+			// gets picked up on SIGHUP without restarting the server
+			certs, err := newCertReloader(f.conf.CertPath, f.conf.KeyPath)
+			if err != nil {
+				return err
+			}
+			f.certs = certs
+			tlsConf = certs.TLSConfig()
 		} else {
 			f.conf.Secure = false
 		}
 	}
 
-	if f.conf.Listen == "systemd" {
-		sockType = "Systemd"
-		setters = append(setters, w.OnSystemd())
-	} else if filepath.IsAbs(f.conf.Listen) {
-		dir := filepath.Dir(f.conf.Listen)
-		if _, err := os.Stat(dir); err == nil {
-			sockType = "socket"
-			setters = append(setters, w.OnSocket(f.conf.Listen))
-			defer func() { os.RemoveAll(f.conf.Listen) }()
+	listeners := make([]net.Listener, 0)
+	listenOn := make([]string, 0)
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.Close()
 		}
-	} else {
-		sockType = "TCP"
-		setters = append(setters, w.OnTCP(f.conf.Listen))
+	}
+	for _, spec := range f.conf.ListenSpecs() {
+		l, sockType, err := listen(spec, f.conf.Secure)
+		if err != nil {
+			closeListeners()
+			return errors.Annotatef(err, "unable to listen on %s", spec)
+		}
+		if sockType == "socket" {
+			defer os.RemoveAll(spec)
+			// NOTE: the socket is created with the permissions allowed by the umask, which usually makes it
+			// accessible to everybody, so we restrict them before serving any requests
+			if err = setSocketPermissions(spec, f.conf.SocketMode, f.conf.SocketGroup); err != nil {
+				l.Close()
+				closeListeners()
+				return err
+			}
+		}
+		listeners = append(listeners, l)
+		listenOn = append(listenOn, spec+"["+sockType+"]")
 	}
 	logCtx := lw.Ctx{
 		"URL":      f.conf.BaseURL,
 		"version":  f.ver,
-		"listenOn": f.conf.Listen,
+		"listenOn": strings.Join(listenOn, ", "),
 		"TLS":      f.conf.Secure,
 	}
-	if sockType != "" {
-		logCtx["listenOn"] = f.conf.Listen + "[" + sockType + "]"
-	}
 
-	// Get start/stop functions for the http server
-	srvRun, srvStop := w.HttpServer(setters...)
+	// Get start/stop functions for the http servers
+	srvRun, srvStop := serveListeners(f.R, tlsConf, listeners...)
 	logger := f.logger.WithContext(logCtx)
 	logger.Infof("Started")
+
+	sweepCtx, stopSweep := context.WithCancel(c)
+	defer stopSweep()
+	go f.sweep(sweepCtx)
+	go f.subscribeToRelays()
+	go f.retryDeliveries(sweepCtx)
+	go f.indexObjects()
 	f.stopFn = func() {
-		if err := srvStop(ctx); err != nil {
-			logger.Errorf(err.Error())
+		// Create a deadline to wait for.
+		ctx, cancelFn := context.WithTimeout(context.Background(), f.conf.TimeOut)
+		defer cancelFn()
+		if err := srvStop(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			logger.Errorf("%+s", err)
 		}
 	}
 
+	srvErr := make(chan error, 1)
 	exit := w.RegisterSignalHandlers(w.SignalHandlers{
 		syscall.SIGHUP: func(_ chan int) {
 			logger.Infof("SIGHUP received, reloading configuration")
@@ -242,6 +384,10 @@ func (f *FedBOX) Run(c context.Context) error {
 				logger.Errorf("Failed: %+s", err.Error())
 			}
 		},
+		syscall.SIGUSR1: func(_ chan int) {
+			logger.Infof("SIGUSR1 received, dumping statistics")
+			f.logStats()
+		},
 		syscall.SIGINT: func(exit chan int) {
 			logger.Infof("SIGINT received, stopping")
 			exit <- 0
@@ -255,24 +401,32 @@ func (f *FedBOX) Run(c context.Context) error {
 			exit <- 0
 		},
 	}).Exec(func() error {
-		if err := srvRun(); err != nil {
-			logger.Errorf(err.Error())
-			return err
+		err := srvRun()
+		srvErr <- err
+		if err == nil {
+			// NOTE: the signal handler returns only when the function results in an error,
+			// so we need one even when the server stopped cleanly
+			err = http.ErrServerClosed
 		}
-		var err error
-		// Doesn't block if no connections, but will otherwise wait until the timeout deadline.
-		go func(e error) {
-			logger.Errorf(err.Error())
-			f.stopFn()
-		}(err)
 		return err
 	})
 	if exit == 0 {
 		logger.Infof("Shutting down")
 	}
+	// Doesn't block if no connections, but will otherwise wait until the timeout deadline.
+	f.stopFn()
+	if err := <-srvErr; err != nil && !isServerClosed(err) {
+		logger.Errorf("%+s", err)
+		return err
+	}
 	return nil
 }
 
+// isServerClosed checks if the err returned by the server's start function is caused by the server being stopped.
+func isServerClosed(err error) bool {
+	return errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed)
+}
+
 func (f *FedBOX) infFn(s string, p ...any) {
 	if f.logger != nil {
 		f.logger.Infof(s, p...)
@@ -1,11 +1,18 @@
 package fedbox
 
 import (
+	"context"
+	"net"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"git.sr.ht/~mariusor/lw"
+	"github.com/alicebob/miniredis/v2"
+	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/fedbox/internal/config"
 	fs "github.com/go-ap/storage-fs"
+	"github.com/go-chi/chi/v5"
 )
 
 var defaultConfig = config.Options{
@@ -30,10 +37,120 @@ func TestFedbox_Config(t *testing.T) {
 	t.Skipf("TODO")
 }
 
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to find a free port: %s", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
 func TestFedbox_Run(t *testing.T) {
-	t.Skipf("TODO")
+	t.Run("start and stop", func(t *testing.T) {
+		addr := freeAddr(t)
+		f := FedBOX{
+			R:      chi.NewRouter(),
+			conf:   config.Options{Listen: addr, TimeOut: 10 * time.Millisecond},
+			stopFn: emptyStopFn,
+			logger: lw.Dev(),
+		}
+
+		errCh := make(chan error, 1)
+		go func() { errCh <- f.Run(context.Background()) }()
+
+		started := false
+		for i := 0; i < 100 && !started; i++ {
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				conn.Close()
+				started = true
+			} else {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		if !started {
+			t.Fatalf("server did not start listening on %s", addr)
+		}
+
+		f.Stop()
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Errorf("Run() returned error %s after a clean stop", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Run() did not return after the server was stopped")
+		}
+	})
+	t.Run("listen error", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %s", err)
+		}
+		defer l.Close()
+
+		f := FedBOX{
+			R:      chi.NewRouter(),
+			conf:   config.Options{Listen: l.Addr().String(), TimeOut: 10 * time.Millisecond},
+			stopFn: emptyStopFn,
+			logger: lw.Dev(),
+		}
+		if err = f.Run(context.Background()); err == nil {
+			t.Errorf("Run() should return an error when the address is already in use")
+		}
+	})
+}
+
+func TestFedbox_reload(t *testing.T) {
+	conf := config.Options{
+		BaseURL:         "https://fedbox.local",
+		ConfigFile:      filepath.Join(t.TempDir(), "missing.conf"),
+		MaintenanceMode: true,
+	}
+	f := FedBOX{conf: conf, caches: newRequestCache(conf, lw.Dev()), readOnly: newReadOnlyMode(true)}
+	if err := f.reload(); err == nil {
+		t.Fatalf("reload() should have returned an error for a missing configuration file")
+	}
+	if f.conf.BaseURL != conf.BaseURL || f.conf.ConfigFile != conf.ConfigFile {
+		t.Errorf("the failed reload replaced the configuration with %#v", f.conf)
+	}
+	if !f.readOnly.enabled() {
+		t.Errorf("the failed reload disabled the maintenance mode")
+	}
 }
 
 func TestFedbox_Stop(t *testing.T) {
 	t.Skipf("TODO")
 }
+
+func Test_newRequestCache(t *testing.T) {
+	iri := vocab.IRI("https://example.com/objects/1")
+	note := &vocab.Object{ID: iri, Type: vocab.NoteType}
+
+	t.Run("shared Redis", func(t *testing.T) {
+		srv := miniredis.RunT(t)
+		conf := config.Options{RequestCache: true, CacheBackend: config.CacheRedis, CacheURL: "redis://" + srv.Addr()}
+
+		// NOTE(marius): the two caches stand in for two FedBOX processes
+		first, second := newRequestCache(conf, lw.Dev()), newRequestCache(conf, lw.Dev())
+		first.Set(iri, note)
+		if vocab.IsNil(second.Get(iri)) {
+			t.Errorf("the item cached by one process is not visible in the other")
+		}
+	})
+	t.Run("unreachable Redis falls back to memory", func(t *testing.T) {
+		srv := miniredis.RunT(t)
+		addr := srv.Addr()
+		srv.Close()
+		conf := config.Options{RequestCache: true, CacheBackend: config.CacheRedis, CacheURL: "redis://" + addr}
+
+		c := newRequestCache(conf, lw.Dev())
+		if c == nil {
+			t.Fatalf("newRequestCache() returned nil")
+		}
+		c.Set(iri, note)
+		if vocab.IsNil(c.Get(iri)) {
+			t.Errorf("the in-memory fallback cache didn't store the item")
+		}
+	})
+}
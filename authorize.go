@@ -0,0 +1,85 @@
+package fedbox
+
+import (
+	"net/url"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// sameHost checks if the a and b IRIs belong to the same server
+func sameHost(a, b vocab.IRI) bool {
+	ua, err := url.Parse(a.String())
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b.String())
+	if err != nil {
+		return false
+	}
+	return ua.Host == ub.Host
+}
+
+// canModify checks if the actor is allowed to modify the ob item: it is the actor itself, or an object or activity
+// the actor has created. When ob isn't found in the db storage we can only check that it belongs to the same
+// server as the actor.
+func canModify(db processing.ReadStore, actor vocab.IRI, ob vocab.Item) bool {
+	iri := ob.GetLink()
+	if iri.Equals(actor, false) {
+		return true
+	}
+	// NOTE(marius): we check the stored item, as the embedded one can claim to be attributed to anyone
+	it, err := db.Load(iri)
+	if it = firstItem(it); err != nil || vocab.IsNil(it) || !it.GetLink().Equals(iri, false) {
+		return sameHost(iri, actor)
+	}
+	if it.GetType() == vocab.TombstoneType {
+		return sameHost(iri, actor)
+	}
+	return createdBy(it, actor)
+}
+
+// ValidateInboxActivity checks that the a activity received in an inbox can be persisted: its actor must be the
+// signer of the request, and, for the activities which modify existing resources, the actor must own them.
+// A Delete, Update or Undo can only have as object the actor itself, or the items it created, and an Add or Remove
+// can't have as target a collection of the base service, which belong to the local actors.
+func ValidateInboxActivity(db processing.ReadStore, base vocab.IRI, a *vocab.Activity, signer vocab.Actor) error {
+	if a == nil {
+		return errors.BadRequestf("invalid nil activity")
+	}
+	if isAnonymous(signer) {
+		return errors.Forbiddenf("the inbox accepts only signed requests")
+	}
+	if vocab.IsNil(a.Actor) || !a.Actor.GetLink().Equals(signer.GetLink(), false) {
+		return errors.Forbiddenf("the activity's actor is not the signer of the request %s", signer.GetLink())
+	}
+	actor := a.Actor.GetLink()
+
+	switch a.GetType() {
+	case vocab.DeleteType, vocab.UpdateType, vocab.UndoType:
+		if vocab.IsNil(a.Object) {
+			return nil
+		}
+		obs := vocab.ItemCollection{a.Object}
+		if vocab.IsItemCollection(a.Object) {
+			vocab.OnCollectionIntf(a.Object, func(c vocab.CollectionInterface) error {
+				obs = c.Collection()
+				return nil
+			})
+		}
+		for _, ob := range obs {
+			if !vocab.IsNil(ob) && !canModify(db, actor, ob) {
+				return errors.Forbiddenf("%s is not allowed to %s %s", actor, a.GetType(), ob.GetLink())
+			}
+		}
+	case vocab.AddType, vocab.RemoveType:
+		if vocab.IsNil(a.Target) {
+			return nil
+		}
+		if target := a.Target.GetLink(); target.Contains(base, false) && !target.Contains(actor, false) {
+			return errors.Forbiddenf("%s is not allowed to %s items to %s", actor, a.GetType(), target)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package fedbox
+
+import (
+	"net/http"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestValidateInboxActivity(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	bob := vocab.Actor{ID: "https://example.com/users/bob", Type: vocab.PersonType}
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	localNote := &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType, AttributedTo: johnDoe}
+	bobsNote := &vocab.Object{ID: "https://example.com/notes/1", Type: vocab.NoteType, AttributedTo: bob.ID}
+	db := mockStore{localNote.ID: localNote, bobsNote.ID: bobsNote}
+
+	tests := []struct {
+		name   string
+		act    *vocab.Activity
+		signer vocab.Actor
+		status int
+	}{
+		{
+			name:   "Create by the signer",
+			act:    &vocab.Activity{Type: vocab.CreateType, Actor: bob.ID, Object: bobsNote},
+			signer: bob,
+		},
+		{
+			name:   "unsigned Create",
+			act:    &vocab.Activity{Type: vocab.CreateType, Actor: bob.ID, Object: bobsNote},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "spoofed actor Create",
+			act:    &vocab.Activity{Type: vocab.CreateType, Actor: vocab.IRI("https://example.com/users/alice"), Object: bobsNote},
+			signer: bob,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "Delete of an owned object",
+			act:    &vocab.Activity{Type: vocab.DeleteType, Actor: bob.ID, Object: bobsNote.ID},
+			signer: bob,
+		},
+		{
+			name:   "Delete of the actor itself",
+			act:    &vocab.Activity{Type: vocab.DeleteType, Actor: bob.ID, Object: bob.ID},
+			signer: bob,
+		},
+		{
+			name:   "Delete of a local object",
+			act:    &vocab.Activity{Type: vocab.DeleteType, Actor: bob.ID, Object: localNote.ID},
+			signer: bob,
+			status: http.StatusForbidden,
+		},
+		{
+			name: "Update claiming the ownership of a local object",
+			act: &vocab.Activity{
+				Type:   vocab.UpdateType,
+				Actor:  bob.ID,
+				Object: &vocab.Object{ID: localNote.ID, Type: vocab.NoteType, AttributedTo: bob.ID},
+			},
+			signer: bob,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "Delete of an unknown object from another server",
+			act:    &vocab.Activity{Type: vocab.DeleteType, Actor: bob.ID, Object: vocab.IRI("https://social.example.org/notes/1")},
+			signer: bob,
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "Add to a local collection",
+			act:    &vocab.Activity{Type: vocab.AddType, Actor: bob.ID, Object: bobsNote.ID, Target: vocab.IRI("https://fedbox.local/actors/johndoe/featured")},
+			signer: bob,
+			status: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateInboxActivity(db, base, tt.act, tt.signer)
+			if tt.status == 0 {
+				if err != nil {
+					t.Errorf("ValidateInboxActivity() returned error %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("ValidateInboxActivity() expected error")
+			}
+			if status := errors.HttpStatus(err); status != tt.status {
+				t.Errorf("ValidateInboxActivity() error status %d, expected %d: %s", status, tt.status, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package fedbox
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// The severities of the Mastodon domain blocks
+const (
+	severitySuspend = "suspend"
+	severitySilence = "silence"
+	severityNoop    = "noop"
+)
+
+// domainBlock is an entry of a Mastodon domain blocks export
+type domainBlock struct {
+	Domain      string `json:"domain"`
+	Severity    string `json:"severity"`
+	RejectMedia bool   `json:"reject_media"`
+	Comment     string `json:"comment"`
+}
+
+// blocklistImport is the summary of a domain blocks import
+type blocklistImport struct {
+	Suspended int `json:"suspended"`
+	Silenced  int `json:"silenced"`
+	Skipped   int `json:"skipped"`
+}
+
+// parseDomainBlocksCSV parses the CSV export of the Mastodon domain blocks.
+// The exports have a header, with the names of the columns prefixed by "#": "#domain,#severity,#reject_media,...",
+// when it's missing we expect the domain and the severity as the first columns.
+func parseDomainBlocksCSV(r io.Reader) ([]domainBlock, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.NewBadRequest(err, "invalid CSV domain blocks")
+	}
+	columns := map[string]int{"domain": 0, "severity": 1, "reject_media": 2, "public_comment": 4}
+	if len(records) > 0 && len(records[0]) > 0 && strings.HasPrefix(records[0][0], "#") {
+		columns = make(map[string]int)
+		for i, name := range records[0] {
+			columns[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))] = i
+		}
+		records = records[1:]
+	}
+	if _, ok := columns["domain"]; !ok {
+		return nil, errors.BadRequestf("missing the domain column of the CSV domain blocks")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	blocks := make([]domainBlock, 0, len(records))
+	for _, rec := range records {
+		b := domainBlock{
+			Domain:   field(rec, "domain"),
+			Severity: field(rec, "severity"),
+			Comment:  field(rec, "public_comment"),
+		}
+		b.RejectMedia, _ = strconv.ParseBool(field(rec, "reject_media"))
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// parseDomainBlocksJSON parses the JSON form of the Mastodon domain blocks, as returned by the
+// domain_blocks API end-points.
+func parseDomainBlocksJSON(data []byte) ([]domainBlock, error) {
+	blocks := make([]domainBlock, 0)
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, errors.NewBadRequest(err, "invalid JSON domain blocks")
+	}
+	return blocks, nil
+}
+
+// parseDomainBlocks parses the data domain blocks in the CSV or JSON format, depending on the contentType,
+// or on the first character of data when it's not conclusive.
+func parseDomainBlocks(data []byte, contentType string) ([]domainBlock, error) {
+	typ, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasSuffix(typ, "json"):
+		return parseDomainBlocksJSON(data)
+	case typ == "text/csv":
+		return parseDomainBlocksCSV(bytes.NewReader(data))
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return parseDomainBlocksJSON(data)
+	}
+	return parseDomainBlocksCSV(bytes.NewReader(data))
+}
+
+// domainIRI returns the IRI we store in the blocklists for the domain
+func domainIRI(domain string) vocab.IRI {
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if domain == "" || strings.ContainsAny(domain, "*/:@ ") {
+		return ""
+	}
+	return vocab.IRI("https://" + domain)
+}
+
+// setMembership adds the iri to the col collection, or removes it when in is false, creating the collection
+// when needed. It reports if the collection has been modified.
+func setMembership(db collectionStore, col vocab.IRI, iri vocab.IRI, in bool) (bool, error) {
+	if collectionContains(db, col, iri) == in {
+		return false, nil
+	}
+	if !in {
+		if err := db.RemoveFrom(col, iri); err != nil {
+			return false, errors.Annotatef(err, "unable to remove %s from %s", iri, col)
+		}
+		return true, nil
+	}
+	if _, err := db.Load(col); errors.IsNotFound(err) {
+		if _, err = db.Create(&vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType}); err != nil {
+			return false, errors.Annotatef(err, "unable to create %s", col)
+		}
+	}
+	if err := db.AddTo(col, iri); err != nil {
+		return false, errors.Annotatef(err, "unable to add %s to %s", iri, col)
+	}
+	return true, nil
+}
+
+// importDomainBlocks merges the blocks into the blocklists of the base service: the suspended domains are added to
+// the blocked collection, and the silenced ones, which are only hidden from the public timelines, to the ignored one.
+// A domain is kept only in the collection of its latest severity, so importing the same list again changes nothing.
+// The obfuscated domains, and the ones without any action, are skipped.
+// The function returns the summary of the import and the IRIs of the collections that have been modified.
+func importDomainBlocks(db collectionStore, base vocab.IRI, blocks []domainBlock) (blocklistImport, vocab.IRIs, error) {
+	blocked := filters.BlockedType.IRI(base)
+	ignored := filters.IgnoredType.IRI(base)
+
+	res := blocklistImport{}
+	modified := make(vocab.IRIs, 0)
+	for _, b := range blocks {
+		iri := domainIRI(b.Domain)
+		severity := strings.ToLower(b.Severity)
+		if severity == "" {
+			severity = severitySuspend
+		}
+		if iri == "" || (severity != severitySuspend && severity != severitySilence) {
+			res.Skipped++
+			continue
+		}
+		suspend := severity == severitySuspend
+		for col, in := range map[vocab.IRI]bool{blocked: suspend, ignored: !suspend} {
+			changed, err := setMembership(db, col, iri, in)
+			if err != nil {
+				return res, modified, err
+			}
+			if changed && !modified.Contains(col) {
+				modified = append(modified, col)
+			}
+		}
+		if suspend {
+			res.Suspended++
+		} else {
+			res.Silenced++
+		}
+	}
+	return res, modified, nil
+}
+
+// HandleBlocklistImport serves the administrative end-point which imports a Mastodon domain blocks export,
+// in the CSV or the JSON format, into the blocklists of the instance.
+func HandleBlocklistImport(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(fb.actorFromRequest(r), fb.self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		db, ok := fb.storage.(collectionStore)
+		if !ok {
+			errors.HandleError(errors.NotImplementedf("blocklists are not supported by the storage")).ServeHTTP(w, r)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			errors.HandleError(errors.NewBadRequest(err, "unable to read request body")).ServeHTTP(w, r)
+			return
+		}
+		blocks, err := parseDomainBlocks(data, r.Header.Get("Content-Type"))
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		res, modified, err := importDomainBlocks(db, vocab.IRI(fb.Config().BaseURL), blocks)
+		if len(modified) > 0 {
+			fb.caches.Remove(modified...)
+		}
+		if err != nil {
+			fb.errFn("unable to import the domain blocks: %+s", err)
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		fb.infFn("imported domain blocks: %d suspended, %d silenced, %d skipped", res.Suspended, res.Silenced, res.Skipped)
+		data, _ = json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
@@ -0,0 +1,124 @@
+package fedbox
+
+import (
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/filters"
+)
+
+const mastodonBlocksCSV = `#domain,#severity,#reject_media,#reject_reports,#public_comment,#obfuscate
+spam.example,suspend,true,true,Spam,false
+noisy.example,silence,false,false,,false
+bad*.example,suspend,false,false,,true
+meh.example,noop,true,false,,false
+`
+
+const mastodonBlocksJSON = `[
+	{"domain": "spam.example", "digest": "1f2e", "severity": "suspend", "comment": "Spam"},
+	{"domain": "noisy.example", "digest": "3d4c", "severity": "silence", "comment": null}
+]`
+
+func TestParseDomainBlocks(t *testing.T) {
+	want := []domainBlock{
+		{Domain: "spam.example", Severity: severitySuspend, RejectMedia: true, Comment: "Spam"},
+		{Domain: "noisy.example", Severity: severitySilence},
+	}
+	tests := []struct {
+		name        string
+		data        string
+		contentType string
+		want        []domainBlock
+	}{
+		{name: "csv", data: mastodonBlocksCSV, contentType: "text/csv", want: want},
+		{name: "csv without content type", data: mastodonBlocksCSV, want: want},
+		{name: "csv without header", data: "spam.example,suspend,true\nnoisy.example,silence\n", want: []domainBlock{
+			{Domain: "spam.example", Severity: severitySuspend, RejectMedia: true},
+			{Domain: "noisy.example", Severity: severitySilence},
+		}},
+		{name: "json", data: mastodonBlocksJSON, contentType: "application/json", want: []domainBlock{
+			{Domain: "spam.example", Severity: severitySuspend, Comment: "Spam"},
+			{Domain: "noisy.example", Severity: severitySilence},
+		}},
+		{name: "json without content type", data: mastodonBlocksJSON, want: []domainBlock{
+			{Domain: "spam.example", Severity: severitySuspend, Comment: "Spam"},
+			{Domain: "noisy.example", Severity: severitySilence},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDomainBlocks([]byte(tt.data), tt.contentType)
+			if err != nil {
+				t.Fatalf("parseDomainBlocks() returned error %s", err)
+			}
+			if len(got) < len(tt.want) {
+				t.Fatalf("parseDomainBlocks() returned %d blocks, expected at least %d", len(got), len(tt.want))
+			}
+			for i, b := range tt.want {
+				if got[i] != b {
+					t.Errorf("parseDomainBlocks()[%d] = %+v, expected %+v", i, got[i], b)
+				}
+			}
+		})
+	}
+
+	if _, err := parseDomainBlocks([]byte(`[{"domain": `), "application/json"); err == nil {
+		t.Errorf("parseDomainBlocks() should fail for invalid JSON")
+	}
+}
+
+func TestImportDomainBlocks(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	blocked := filters.BlockedType.IRI(base)
+	ignored := filters.IgnoredType.IRI(base)
+	db := mockCollectionStore{mockStore{}}
+
+	blocks, err := parseDomainBlocksCSV(strings.NewReader(mastodonBlocksCSV))
+	if err != nil {
+		t.Fatalf("parseDomainBlocksCSV() returned error %s", err)
+	}
+	res, modified, err := importDomainBlocks(db, base, blocks)
+	if err != nil {
+		t.Fatalf("importDomainBlocks() returned error %s", err)
+	}
+	if want := (blocklistImport{Suspended: 1, Silenced: 1, Skipped: 2}); res != want {
+		t.Errorf("importDomainBlocks() = %+v, expected %+v", res, want)
+	}
+	if len(modified) != 2 {
+		t.Errorf("importDomainBlocks() modified %v, expected the blocked and ignored collections", modified)
+	}
+	if !collectionContains(db, blocked, vocab.IRI("https://spam.example")) {
+		t.Errorf("the suspended domain should be in %s", blocked)
+	}
+	if !collectionContains(db, ignored, vocab.IRI("https://noisy.example")) {
+		t.Errorf("the silenced domain should be in %s", ignored)
+	}
+	if collectionContains(db, blocked, vocab.IRI("https://meh.example")) || collectionContains(db, ignored, vocab.IRI("https://meh.example")) {
+		t.Errorf("the domain without any action should not be imported")
+	}
+
+	if _, modified, err = importDomainBlocks(db, base, blocks); err != nil {
+		t.Fatalf("importDomainBlocks() returned error %s", err)
+	}
+	if len(modified) != 0 {
+		t.Errorf("importing the same blocks again should not modify anything, modified %v", modified)
+	}
+	for _, col := range []vocab.IRI{blocked, ignored} {
+		it, _ := db.Load(col)
+		vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+			if c.Count() != 1 {
+				t.Errorf("%s has %d items after importing again, expected 1", col, c.Count())
+			}
+			return nil
+		})
+	}
+
+	escalated := []domainBlock{{Domain: "noisy.example", Severity: severitySuspend}}
+	if _, _, err = importDomainBlocks(db, base, escalated); err != nil {
+		t.Fatalf("importDomainBlocks() returned error %s", err)
+	}
+	if !collectionContains(db, blocked, vocab.IRI("https://noisy.example")) || collectionContains(db, ignored, vocab.IRI("https://noisy.example")) {
+		t.Errorf("the domain should be moved to %s when its severity changes", blocked)
+	}
+}
@@ -0,0 +1,114 @@
+package fedbox
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+// defaultTrustedProxies are the proxies trusted when none are configured, for the reverse proxies running on
+// the same machine
+var defaultTrustedProxies = []string{"127.0.0.1", "::1"}
+
+// clientIPKey is the request context key of the client IP address
+type clientIPKey struct{}
+
+// parseIPNet parses the addr IP address, or CIDR range, into the network containing it
+func parseIPNet(addr string) (*net.IPNet, error) {
+	if !strings.Contains(addr, "/") {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, errors.Newf("invalid IP address %q", addr)
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}, nil
+	}
+	_, n, err := net.ParseCIDR(addr)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid IP range %q", addr)
+	}
+	return n, nil
+}
+
+// trustedProxies are the networks of the reverse proxies whose X-Forwarded-For and X-Real-IP headers we honor
+type trustedProxies []*net.IPNet
+
+func newTrustedProxies(addrs []string) (trustedProxies, error) {
+	if len(addrs) == 0 {
+		addrs = defaultTrustedProxies
+	}
+	p := make(trustedProxies, 0, len(addrs))
+	for _, addr := range addrs {
+		n, err := parseIPNet(addr)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid trusted proxy")
+		}
+		p = append(p, n)
+	}
+	return p, nil
+}
+
+func (p trustedProxies) trusts(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the IP address of the socket peer of the r request
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the IP address of the client which has sent the r request. The X-Forwarded-For and
+// X-Real-IP headers are honored only when the socket peer is a trusted proxy, and in the X-Forwarded-For
+// chain we skip from the right the addresses of the trusted proxies, as the ones to their left can be spoofed.
+// The peers connected through the unix domain sockets, which don't have an IP address, are trusted, as they
+// need to run on the same machine.
+func (p trustedProxies) clientIP(r *http.Request) string {
+	peer := peerIP(r)
+	if ip := net.ParseIP(peer); ip != nil && !p.trusts(ip) {
+		return peer
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			client = ip.String()
+			if !p.trusts(ip) {
+				break
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
+
+// Middleware sets the IP address of the client on the request context, where the rate limiting and the access
+// logging load it from.
+func (p trustedProxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, p.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
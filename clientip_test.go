@@ -0,0 +1,106 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := newTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatalf("newTrustedProxies() returned error %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "no proxy",
+			remote: "203.0.113.7:51234",
+			want:   "203.0.113.7",
+		},
+		{
+			name:    "spoofed X-Forwarded-For from an untrusted peer",
+			remote:  "203.0.113.7:51234",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "spoofed X-Real-IP from an untrusted peer",
+			remote:  "203.0.113.7:51234",
+			headers: map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "X-Forwarded-For from a trusted proxy",
+			remote:  "10.0.0.1:443",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "X-Real-IP from a trusted proxy",
+			remote:  "192.168.1.10:443",
+			headers: map[string]string{"X-Real-IP": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "chain of trusted proxies",
+			remote:  "10.0.0.1:443",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 192.168.1.10"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "spoofed hop in front of the client",
+			remote:  "10.0.0.1:443",
+			headers: map[string]string{"X-Forwarded-For": "127.0.0.1, 198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "invalid X-Forwarded-For from a trusted proxy",
+			remote:  "10.0.0.1:443",
+			headers: map[string]string{"X-Forwarded-For": "not-an-ip"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "unix domain socket peer",
+			remote:  "@",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			var got string
+			proxies.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = remoteIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("the client IP is %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTrustedProxies(t *testing.T) {
+	p, err := newTrustedProxies(nil)
+	if err != nil {
+		t.Fatalf("newTrustedProxies() returned error %s", err)
+	}
+	if len(p) != len(defaultTrustedProxies) {
+		t.Errorf("newTrustedProxies() without addresses should trust the loopback addresses, got %v", p)
+	}
+	for _, addr := range []string{"not-an-ip", "10.0.0.0/33"} {
+		if _, err := newTrustedProxies([]string{addr}); err == nil {
+			t.Errorf("newTrustedProxies() should return an error for %q", addr)
+		}
+	}
+}
@@ -52,6 +52,7 @@ func main() {
 		cmd.BootstrapCmd,
 		cmd.AccountsCmd,
 		cmd.FixStorageCollectionsCmd,
+		cmd.StorageCmd,
 	}
 
 	if err := app.Run(os.Args); err != nil {
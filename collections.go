@@ -0,0 +1,36 @@
+package fedbox
+
+import (
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/processing"
+)
+
+// touchCollections sets the updated timestamp of the cols collections to now, after their members have changed,
+// so the clients can use it to decide when to fetch them again. The IRIs which are not of collections are ignored.
+// It returns the IRIs of the collections which have been updated.
+//
+// NOTE(marius): the storage keeps the members of the collections separately from their properties, so saving
+// the collection we loaded doesn't change its members.
+func touchCollections(db processing.Store, now time.Time, cols ...vocab.IRI) vocab.IRIs {
+	touched := make(vocab.IRIs, 0)
+	for _, iri := range cols {
+		if !vocab.ValidCollectionIRI(iri) || touched.Contains(iri) {
+			continue
+		}
+		it, err := db.Load(iri)
+		if err != nil || vocab.IsNil(it) || !it.IsCollection() || !it.GetLink().Equals(iri, false) {
+			continue
+		}
+		vocab.OnObject(it, func(o *vocab.Object) error {
+			o.Updated = now
+			return nil
+		})
+		if _, err = db.Save(it); err != nil {
+			continue
+		}
+		touched = append(touched, iri)
+	}
+	return touched
+}
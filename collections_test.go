@@ -0,0 +1,74 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/cache"
+)
+
+func TestTouchCollections(t *testing.T) {
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	janeDoe := vocab.IRI("https://fedbox.local/actors/janedoe")
+	followers := vocab.Followers.IRI(johnDoe)
+
+	db := mockCollectionStore{mockStore{johnDoe.ID: johnDoe}}
+	db.Create(&vocab.OrderedCollection{ID: followers, Type: vocab.OrderedCollectionType})
+
+	updated := func() time.Time {
+		it, _ := db.Load(followers)
+		var t time.Time
+		vocab.OnObject(it, func(o *vocab.Object) error {
+			t = o.Updated
+			return nil
+		})
+		return t
+	}
+
+	first := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	db.AddTo(followers, janeDoe)
+	touched := touchCollections(db, first, followers, johnDoe.ID, followers)
+	if len(touched) != 1 || touched[0] != followers {
+		t.Errorf("touchCollections() = %v, expected only %s", touched, followers)
+	}
+	if !updated().Equal(first) {
+		t.Errorf("the updated timestamp of %s is %s, expected %s", followers, updated(), first)
+	}
+	if !collectionContains(db, followers, janeDoe) {
+		t.Errorf("touchCollections() should not change the members of %s", followers)
+	}
+
+	second := first.Add(time.Minute)
+	db.RemoveFrom(followers, janeDoe)
+	touchCollections(db, second, followers)
+	if !updated().After(first) {
+		t.Errorf("the updated timestamp of %s should advance after a membership change, is %s", followers, updated())
+	}
+
+	if touched = touchCollections(db, second, vocab.Following.IRI(johnDoe)); len(touched) != 0 {
+		t.Errorf("touchCollections() = %v, expected to ignore the missing collections", touched)
+	}
+}
+
+func TestTouchCollectionsForActivity(t *testing.T) {
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	outbox := vocab.Outbox.IRI(johnDoe)
+	db := mockCollectionStore{mockStore{}}
+	db.Create(&vocab.OrderedCollection{ID: outbox, Type: vocab.OrderedCollectionType})
+
+	create := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/1",
+		Type:   vocab.CreateType,
+		Actor:  johnDoe,
+		Object: &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType},
+	}
+	modified, err := cache.ActivityIRIs(create, outbox)
+	if err != nil {
+		t.Fatalf("ActivityIRIs() returned error %s", err)
+	}
+	now := time.Now().UTC()
+	if touched := touchCollections(db, now, modified...); !touched.Contains(outbox) {
+		t.Errorf("touchCollections() = %v, expected to contain %s", touched, outbox)
+	}
+}
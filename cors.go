@@ -0,0 +1,81 @@
+package fedbox
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+const corsMaxAge = 24 * 60 * 60
+
+var (
+	corsAllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodOptions}
+	corsAllowedHeaders = []string{"Authorization", "Content-Type", "Accept"}
+	corsExposedHeaders = []string{"Location"}
+)
+
+// corsOrigins returns the origins allowed to make cross-origin requests.
+// The "*" wildcard is accepted only for development environments, where it's also the default value.
+func corsOrigins(conf config.Options) []string {
+	origins := make([]string, 0, len(conf.CORSAllowedOrigins))
+	for _, o := range conf.CORSAllowedOrigins {
+		if o == "*" && !conf.Env.IsDev() {
+			continue
+		}
+		origins = append(origins, strings.TrimSuffix(o, "/"))
+	}
+	if len(conf.CORSAllowedOrigins) == 0 && conf.Env.IsDev() {
+		origins = append(origins, "*")
+	}
+	return origins
+}
+
+func originAllowed(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0
+}
+
+// CORS returns the middleware which sets the Access-Control-Allow-* headers for requests coming from the allowed
+// origins, which allows browser based ActivityPub clients to use the C2S API.
+// The preflight requests are answered directly, with a 204 status for the allowed origins, and 403 otherwise.
+func CORS(origins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if len(origin) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			if !originAllowed(origins, origin) {
+				if isPreflight(r) {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				// NOTE(marius): without the CORS headers the browser refuses to pass the response to the client
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if !isPreflight(r) {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
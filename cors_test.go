@@ -0,0 +1,87 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/env"
+)
+
+func corsRequest(method, origin string, preflight bool) *http.Request {
+	r := httptest.NewRequest(method, "/actors/johndoe/outbox", nil)
+	r.Header.Set("Origin", origin)
+	if preflight {
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	}
+	return r
+}
+
+func TestCORS(t *testing.T) {
+	const allowed = "https://client.example.com"
+	h := CORS([]string{allowed})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	t.Run("preflight", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, corsRequest(http.MethodOptions, allowed, true))
+		if w.Code != http.StatusNoContent {
+			t.Errorf("preflight returned status %d, expected %d", w.Code, http.StatusNoContent)
+		}
+		if o := w.Header().Get("Access-Control-Allow-Origin"); o != allowed {
+			t.Errorf("Access-Control-Allow-Origin %q, expected %q", o, allowed)
+		}
+		headers := w.Header().Get("Access-Control-Allow-Headers")
+		for _, hdr := range []string{"Authorization", "Content-Type"} {
+			if !strings.Contains(headers, hdr) {
+				t.Errorf("Access-Control-Allow-Headers %q should contain %q", headers, hdr)
+			}
+		}
+		if methods := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(methods, http.MethodPost) {
+			t.Errorf("Access-Control-Allow-Methods %q should contain %q", methods, http.MethodPost)
+		}
+	})
+	t.Run("allowed origin", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, corsRequest(http.MethodGet, allowed, false))
+		if w.Code != http.StatusOK {
+			t.Errorf("request returned status %d, expected %d", w.Code, http.StatusOK)
+		}
+		if o := w.Header().Get("Access-Control-Allow-Origin"); o != allowed {
+			t.Errorf("Access-Control-Allow-Origin %q, expected %q", o, allowed)
+		}
+	})
+	t.Run("disallowed origin", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, corsRequest(http.MethodOptions, "https://evil.example.com", true))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("preflight for a disallowed origin returned status %d, expected %d", w.Code, http.StatusForbidden)
+		}
+		if o := w.Header().Get("Access-Control-Allow-Origin"); o != "" {
+			t.Errorf("Access-Control-Allow-Origin %q for a disallowed origin, expected none", o)
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, corsRequest(http.MethodGet, "https://evil.example.com", false))
+		if o := w.Header().Get("Access-Control-Allow-Origin"); o != "" {
+			t.Errorf("Access-Control-Allow-Origin %q for a disallowed origin, expected none", o)
+		}
+	})
+}
+
+func TestCorsOrigins(t *testing.T) {
+	if o := corsOrigins(config.Options{Env: env.DEV}); len(o) != 1 || o[0] != "*" {
+		t.Errorf("corsOrigins() = %v for dev, expected the wildcard", o)
+	}
+	if o := corsOrigins(config.Options{Env: env.PROD, CORSAllowedOrigins: []string{"*"}}); len(o) != 0 {
+		t.Errorf("corsOrigins() = %v for prod, expected the wildcard to be ignored", o)
+	}
+	o := corsOrigins(config.Options{Env: env.PROD, CORSAllowedOrigins: []string{"https://client.example.com/"}})
+	if len(o) != 1 || o[0] != "https://client.example.com" {
+		t.Errorf("corsOrigins() = %v, expected %v", o, []string{"https://client.example.com"})
+	}
+}
@@ -0,0 +1,153 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+)
+
+// InteractionCounts holds the number of likes, shares and replies of an object
+type InteractionCounts struct {
+	ID      vocab.IRI `json:"id"`
+	Likes   uint      `json:"likes"`
+	Shares  uint      `json:"shares"`
+	Replies uint      `json:"replies"`
+}
+
+// storedCount returns the number of items in the col collection, if the storage backend can count them without
+// loading the collection. A missing collection has no items.
+func storedCount(db processing.ReadStore, col vocab.IRI) (uint, error) {
+	cnt, err := st.CountItems(db, col)
+	if errors.IsNotFound(err) {
+		return 0, nil
+	}
+	return cnt, err
+}
+
+// countItems returns the number of items in the col collection, using the cheap count of the storage backend
+// when available. A missing collection has no items.
+func countItems(db processing.ReadStore, col vocab.IRI) (uint, error) {
+	if cnt, err := storedCount(db, col); !errors.IsNotImplemented(err) {
+		return cnt, err
+	}
+	it, err := db.Load(col)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var cnt uint
+	vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+		cnt = c.Count()
+		return nil
+	})
+	return cnt, nil
+}
+
+// cappedTotalItems returns the number of items of the col collection that we publish, which is at most max.
+// For unfiltered collections we use the count maintained by the storage backend, when available, instead of the
+// number of loaded items, which the backend might have limited. A zero max returns the loaded count unchanged.
+func cappedTotalItems(db processing.ReadStore, col vocab.IRI, loaded, max uint, filtered bool) uint {
+	if max == 0 {
+		return loaded
+	}
+	cnt := loaded
+	if !filtered {
+		if stored, err := st.CountItems(db, col); err == nil {
+			cnt = stored
+		}
+	}
+	if cnt > max {
+		return max
+	}
+	return cnt
+}
+
+// LoadInteractionCounts returns the counts of the likes, shares and replies collections of the ob object
+func LoadInteractionCounts(db processing.ReadStore, ob vocab.IRI) (InteractionCounts, error) {
+	return loadInteractionCounts(db, ob, countItems)
+}
+
+func loadInteractionCounts(db processing.ReadStore, ob vocab.IRI, count func(processing.ReadStore, vocab.IRI) (uint, error)) (InteractionCounts, error) {
+	counts := InteractionCounts{ID: ob}
+	var err error
+	if counts.Likes, err = count(db, vocab.Likes.IRI(ob)); err != nil {
+		return counts, err
+	}
+	if counts.Shares, err = count(db, vocab.Shares.IRI(ob)); err != nil {
+		return counts, err
+	}
+	if counts.Replies, err = count(db, vocab.Replies.IRI(ob)); err != nil {
+		return counts, err
+	}
+	return counts, nil
+}
+
+const countsPath = "counts"
+
+// HandleInteractionCounts serves the aggregated likes, shares and replies counts of an object
+func HandleInteractionCounts(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := url.Parse(reqURL(r, fb.Config().Secure))
+		if err != nil {
+			errors.HandleError(errors.NewBadRequest(err, "invalid request URL")).ServeHTTP(w, r)
+			return
+		}
+		u.RawQuery = ""
+		ob := vocab.IRI(strings.TrimSuffix(strings.TrimSuffix(u.String(), "/"), "/"+countsPath))
+
+		it, err := fb.storage.Load(ob)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if vocab.IsNil(it) {
+			errors.HandleError(errors.NotFoundf("%s not found", ob)).ServeHTTP(w, r)
+			return
+		}
+		counts, err := LoadInteractionCounts(fb.storage, ob)
+		if err != nil {
+			fb.errFn("unable to load interaction counts for %s: %+s", ob, err)
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(counts)
+	}
+}
+
+// withInteractionCounts returns a copy of the it object, whose likes, shares and replies are replaced with
+// collections which contain only their totalItems, so the clients don't need to load them separately for
+// showing the counts. The object itself is left unchanged, as it can be shared with the other requests through
+// the cache.
+// The actors, activities and collections, and the object types with their own properties, like the places
+// and the tombstones, are returned unchanged.
+// The counts are added only for the storage backends which can count the items without loading the collections,
+// for the other ones loading the three collections for every object would be too expensive, and the clients can
+// use the counts end-point instead.
+func withInteractionCounts(db processing.ReadStore, it vocab.Item) vocab.Item {
+	ob, ok := it.(*vocab.Object)
+	if !ok || ob == nil || !vocab.ObjectTypes.Contains(ob.GetType()) {
+		return it
+	}
+	counts, err := loadInteractionCounts(db, ob.GetLink(), storedCount)
+	if err != nil {
+		return it
+	}
+	stub := func(col vocab.IRI, cnt uint) vocab.Item {
+		return &vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType, TotalItems: cnt}
+	}
+	cp := *ob
+	cp.Likes = stub(vocab.Likes.IRI(ob), counts.Likes)
+	cp.Shares = stub(vocab.Shares.IRI(ob), counts.Shares)
+	cp.Replies = stub(vocab.Replies.IRI(ob), counts.Replies)
+	return &cp
+}
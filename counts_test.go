@@ -0,0 +1,194 @@
+package fedbox
+
+import (
+	"fmt"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/processing"
+)
+
+type mockCounter struct {
+	mockCollectionStore
+	counted vocab.IRIs
+}
+
+func (m *mockCounter) CountItems(col vocab.IRI) (uint, error) {
+	m.counted = append(m.counted, col)
+	if c, ok := m.mockStore[col].(vocab.CollectionInterface); ok {
+		return c.Count(), nil
+	}
+	return 0, nil
+}
+
+func TestLoadInteractionCounts(t *testing.T) {
+	ob := vocab.IRI("https://fedbox.local/objects/note")
+	likes := vocab.Likes.IRI(ob)
+	shares := vocab.Shares.IRI(ob)
+	replies := vocab.Replies.IRI(ob)
+
+	db := mockCollectionStore{mockStore{}}
+	for _, col := range []vocab.IRI{likes, shares, replies} {
+		db.Create(&vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType})
+	}
+
+	counts, err := LoadInteractionCounts(db, ob)
+	if err != nil {
+		t.Fatalf("LoadInteractionCounts() returned error %s", err)
+	}
+	if counts.Likes != 0 || counts.Shares != 0 || counts.Replies != 0 {
+		t.Errorf("LoadInteractionCounts() = %+v, expected no interactions", counts)
+	}
+
+	db.AddTo(likes, vocab.IRI("https://fedbox.local/activities/like-1"))
+	db.AddTo(likes, vocab.IRI("https://fedbox.local/activities/like-2"))
+	db.AddTo(shares, vocab.IRI("https://fedbox.local/activities/announce-1"))
+	db.AddTo(replies, vocab.IRI("https://fedbox.local/objects/reply-1"))
+	db.AddTo(replies, vocab.IRI("https://fedbox.local/objects/reply-2"))
+	db.AddTo(replies, vocab.IRI("https://fedbox.local/objects/reply-3"))
+
+	want := InteractionCounts{ID: ob, Likes: 2, Shares: 1, Replies: 3}
+	if counts, err = LoadInteractionCounts(db, ob); err != nil {
+		t.Fatalf("LoadInteractionCounts() returned error %s", err)
+	}
+	if counts != want {
+		t.Errorf("LoadInteractionCounts() = %+v, expected %+v", counts, want)
+	}
+
+	counter := &mockCounter{mockCollectionStore: db}
+	if counts, err = LoadInteractionCounts(counter, ob); err != nil {
+		t.Fatalf("LoadInteractionCounts() returned error %s", err)
+	}
+	if counts != want {
+		t.Errorf("LoadInteractionCounts() = %+v, expected %+v", counts, want)
+	}
+	if len(counter.counted) != 3 {
+		t.Errorf("LoadInteractionCounts() should use the storage count, counted %v", counter.counted)
+	}
+}
+
+func TestCappedTotalItems(t *testing.T) {
+	huge := vocab.IRI("https://fedbox.local/actors/johndoe/followers")
+	small := vocab.IRI("https://fedbox.local/actors/janedoe/followers")
+
+	db := mockCollectionStore{mockStore{}}
+	db.Create(&vocab.OrderedCollection{ID: huge, Type: vocab.OrderedCollectionType})
+	db.Create(&vocab.OrderedCollection{ID: small, Type: vocab.OrderedCollectionType})
+	for i := 0; i < 150; i++ {
+		db.AddTo(huge, vocab.IRI(fmt.Sprintf("https://remote.example.com/actors/%d", i)))
+	}
+	db.AddTo(small, vocab.IRI("https://remote.example.com/actors/1"))
+	counter := &mockCounter{mockCollectionStore: db}
+
+	tests := []struct {
+		name     string
+		db       processing.ReadStore
+		col      vocab.IRI
+		loaded   uint
+		max      uint
+		filtered bool
+		want     uint
+	}{
+		{
+			name:   "no cap",
+			db:     counter,
+			col:    huge,
+			loaded: 100,
+			want:   100,
+		},
+		{
+			name:   "huge collection, loaded count",
+			db:     db,
+			col:    huge,
+			loaded: 150,
+			max:    100,
+			want:   100,
+		},
+		{
+			name:   "huge collection, maintained count",
+			db:     counter,
+			col:    huge,
+			loaded: 50,
+			max:    100,
+			want:   100,
+		},
+		{
+			name:   "small collection, maintained count",
+			db:     counter,
+			col:    small,
+			loaded: 1,
+			max:    100,
+			want:   1,
+		},
+		{
+			name:     "filtered collection ignores the maintained count",
+			db:       counter,
+			col:      huge,
+			loaded:   20,
+			max:      100,
+			filtered: true,
+			want:     20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cappedTotalItems(tt.db, tt.col, tt.loaded, tt.max, tt.filtered); got != tt.want {
+				t.Errorf("cappedTotalItems() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithInteractionCounts(t *testing.T) {
+	ob := vocab.IRI("https://fedbox.local/objects/note")
+	likes := vocab.Likes.IRI(ob)
+
+	db := &mockCounter{mockCollectionStore: mockCollectionStore{mockStore{}}}
+	note := &vocab.Object{ID: ob, Type: vocab.NoteType, Likes: likes}
+	db.Save(note)
+	db.Create(&vocab.OrderedCollection{ID: likes, Type: vocab.OrderedCollectionType})
+	db.AddTo(likes, vocab.IRI("https://fedbox.local/activities/like-1"))
+	db.AddTo(likes, vocab.IRI("https://fedbox.local/activities/like-2"))
+
+	if it := withInteractionCounts(db.mockCollectionStore, note); it != note {
+		t.Errorf("the counts should be added only for the storage backends which can count the items cheaply")
+	}
+	it := withInteractionCounts(db, note)
+	data, err := vocab.MarshalJSON(it)
+	if err != nil {
+		t.Fatalf("unable to marshal the rendered object: %s", err)
+	}
+	rendered, err := vocab.UnmarshalJSON(data)
+	if err != nil {
+		t.Fatalf("unable to unmarshal the rendered object: %s", err)
+	}
+	vocab.OnObject(rendered, func(o *vocab.Object) error {
+		for name, col := range map[string]vocab.Item{"likes": o.Likes, "shares": o.Shares, "replies": o.Replies} {
+			if vocab.IsIRI(col) {
+				t.Errorf("%s should be rendered as a collection, received %s", name, col)
+			}
+		}
+		var total uint
+		vocab.OnOrderedCollection(o.Likes, func(c *vocab.OrderedCollection) error {
+			total = c.TotalItems
+			return nil
+		})
+		if total != 2 {
+			t.Errorf("likes.totalItems = %d, expected 2", total)
+		}
+		if !o.Likes.GetLink().Equals(likes, false) {
+			t.Errorf("likes id = %s, expected %s", o.Likes.GetLink(), likes)
+		}
+		return nil
+	})
+
+	if it == note || !vocab.IsIRI(note.Likes) {
+		t.Errorf("the object should be copied before adding the counts, likes is %v", note.Likes)
+	}
+
+	actor := &vocab.Actor{ID: "https://fedbox.local/actors/jdoe", Type: vocab.PersonType, Likes: vocab.IRI("https://fedbox.local/actors/jdoe/likes")}
+	withInteractionCounts(db, actor)
+	if !vocab.IsIRI(actor.Likes) {
+		t.Errorf("the actors should not be modified, likes is %v", actor.Likes)
+	}
+}
@@ -0,0 +1,189 @@
+package fedbox
+
+import (
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+)
+
+// deletedActor returns the IRI of the remote actor that the del Delete activity removes.
+// Only an actor can delete itself, which is how the servers announce an account deletion, so we return
+// an empty IRI when the object is a different one, or when the actor is local to the base service.
+func deletedActor(del *vocab.Activity, base vocab.IRI) vocab.IRI {
+	if del == nil || del.GetType() != vocab.DeleteType || vocab.IsNil(del.Actor) || vocab.IsNil(del.Object) {
+		return ""
+	}
+	actor := del.Actor.GetLink()
+	if !del.Object.GetLink().Equals(actor, false) || actor.Contains(base, false) {
+		return ""
+	}
+	return actor
+}
+
+// tombstoneFor returns the Tombstone that replaces the it item
+func tombstoneFor(it vocab.Item, deleted time.Time) *vocab.Tombstone {
+	return &vocab.Tombstone{
+		ID:         it.GetLink(),
+		Type:       vocab.TombstoneType,
+		FormerType: it.GetType(),
+		Deleted:    deleted,
+	}
+}
+
+// createdBy checks if the it item was created by the actor, being either attributed to it, or an activity it has sent
+func createdBy(it vocab.Item, actor vocab.IRI) bool {
+	found := false
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		found = !vocab.IsNil(o.AttributedTo) && o.AttributedTo.GetLink().Equals(actor, false)
+		return nil
+	})
+	if found || !vocab.ActivityTypes.Contains(it.GetType()) {
+		return found
+	}
+	vocab.OnActivity(it, func(a *vocab.Activity) error {
+		found = !vocab.IsNil(a.Actor) && a.Actor.GetLink().Equals(actor, false)
+		return nil
+	})
+	return found
+}
+
+// tombstoneCreatedBy replaces with Tombstones the items in the col collection which have been created by the actor.
+// As the Tombstones are no longer attributed to the actor, we load the collection until no more items are found,
+// in case the storage returns them paginated.
+func tombstoneCreatedBy(db processing.Store, col *filters.Filters, actor vocab.IRI, now time.Time) (vocab.IRIs, error) {
+	tombstoned := make(vocab.IRIs, 0)
+	for {
+		loaded, err := db.Load(col.GetLink())
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return tombstoned, nil
+			}
+			return tombstoned, err
+		}
+		toRemove := make(vocab.ItemCollection, 0)
+		vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+			for _, it := range c.Collection() {
+				if it.GetType() != vocab.TombstoneType && createdBy(it, actor) {
+					toRemove = append(toRemove, it)
+				}
+			}
+			return nil
+		})
+		if len(toRemove) == 0 {
+			return tombstoned, nil
+		}
+		for _, it := range toRemove {
+			if _, err = db.Save(tombstoneFor(it, now)); err != nil {
+				return tombstoned, errors.Annotatef(err, "unable to replace %s with a Tombstone", it.GetLink())
+			}
+			tombstoned = append(tombstoned, it.GetLink())
+		}
+	}
+}
+
+// removeRelationships removes the actor from the followers and following collections of the local actors
+// of the base service. It returns the IRIs of the collections that have been modified.
+func removeRelationships(db collectionStore, base vocab.IRI, actor vocab.IRI) (vocab.IRIs, error) {
+	actors, err := db.Load(filters.ActorsType.IRI(base))
+	if err != nil {
+		return nil, err
+	}
+	modified := make(vocab.IRIs, 0)
+	err = vocab.OnCollectionIntf(actors, func(c vocab.CollectionInterface) error {
+		for _, local := range c.Collection() {
+			if !vocab.ActorTypes.Contains(local.GetType()) || !local.GetLink().Contains(base, false) {
+				continue
+			}
+			for _, col := range []vocab.IRI{vocab.Followers.IRI(local), vocab.Following.IRI(local)} {
+				if !collectionContains(db, col, actor) {
+					continue
+				}
+				if err := db.RemoveFrom(col, actor); err != nil {
+					return errors.Annotatef(err, "unable to remove %s from %s", actor, col)
+				}
+				modified = append(modified, col)
+			}
+		}
+		return nil
+	})
+	return modified, err
+}
+
+// deleteRemoteActor handles a Delete activity of a remote actor, which is how other servers announce that an account
+// has been removed. The objects attributed to the actor and the activities it has sent, which we have stored locally,
+// are replaced with Tombstones, together with the actor itself, and it gets removed from the followers and following
+// collections of our local actors.
+//
+// The function returns the IRIs of the items and collections that have been modified.
+func deleteRemoteActor(db collectionStore, base vocab.IRI, del *vocab.Activity, now time.Time) (vocab.IRIs, error) {
+	actor := deletedActor(del, base)
+	if actor == "" {
+		return nil, nil
+	}
+	modified := make(vocab.IRIs, 0)
+
+	objects := filters.FiltersNew(filters.IRI(filters.ObjectsType.IRI(base)))
+	tombstoned, err := tombstoneCreatedBy(db, objects, actor, now)
+	modified = append(modified, tombstoned...)
+	if err != nil {
+		return modified, err
+	}
+	activities := filters.FiltersNew(filters.IRI(filters.ActivitiesType.IRI(base)))
+	tombstoned, err = tombstoneCreatedBy(db, activities, actor, now)
+	modified = append(modified, tombstoned...)
+	if err != nil {
+		return modified, err
+	}
+
+	if it, err := db.Load(actor); err == nil && !vocab.IsNil(it) && it.GetType() != vocab.TombstoneType {
+		if _, err = db.Save(tombstoneFor(it, now)); err != nil {
+			return modified, errors.Annotatef(err, "unable to replace %s with a Tombstone", actor)
+		}
+		modified = append(modified, actor)
+	}
+
+	cols, err := removeRelationships(db, base, actor)
+	modified = append(modified, cols...)
+	return modified, err
+}
+
+// checkInboxOwner returns a Gone error when the owner of the inbox collection is a local actor which has
+// been deleted, so we don't store activities for it anymore.
+func checkInboxOwner(db processing.ReadStore, inbox vocab.IRI) error {
+	owner, typ := vocab.Split(inbox)
+	if typ != vocab.Inbox || owner == "" {
+		return nil
+	}
+	it, err := db.Load(owner)
+	if it = firstItem(it); err != nil || vocab.IsNil(it) || !it.GetLink().Equals(owner, false) {
+		return nil
+	}
+	if it.GetType() == vocab.TombstoneType {
+		return errors.Gonef("%s has been deleted", owner)
+	}
+	return nil
+}
+
+// ValidateCreateObject checks that the object of the a Create activity is not a Tombstone, as creating
+// an already deleted object is either a malformed or a malicious activity.
+func ValidateCreateObject(a *vocab.Activity) error {
+	if a == nil || a.GetType() != vocab.CreateType || vocab.IsNil(a.Object) {
+		return nil
+	}
+	obs := vocab.ItemCollection{a.Object}
+	if vocab.IsItemCollection(a.Object) {
+		vocab.OnCollectionIntf(a.Object, func(c vocab.CollectionInterface) error {
+			obs = c.Collection()
+			return nil
+		})
+	}
+	for _, ob := range obs {
+		if !vocab.IsNil(ob) && ob.GetType() == vocab.TombstoneType {
+			return errors.BadRequestf("unable to create %s, which is a %s", ob.GetLink(), vocab.TombstoneType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package fedbox
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestDeletedActor(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	remote := vocab.IRI("https://remote.example.com/users/jdoe")
+	tests := []struct {
+		name string
+		del  *vocab.Activity
+		want vocab.IRI
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name: "remote actor deleting itself",
+			del:  &vocab.Activity{Type: vocab.DeleteType, Actor: remote, Object: remote},
+			want: remote,
+		},
+		{
+			name: "remote actor deleting an object",
+			del:  &vocab.Activity{Type: vocab.DeleteType, Actor: remote, Object: vocab.IRI("https://remote.example.com/notes/1")},
+		},
+		{
+			name: "local actor deleting itself",
+			del:  &vocab.Activity{Type: vocab.DeleteType, Actor: base.AddPath("actors", "jdoe"), Object: base.AddPath("actors", "jdoe")},
+		},
+		{
+			name: "not a Delete",
+			del:  &vocab.Activity{Type: vocab.UpdateType, Actor: remote, Object: remote},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deletedActor(tt.del, base); got != tt.want {
+				t.Errorf("deletedActor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteRemoteActor(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	now := time.Now().UTC()
+
+	remote := &vocab.Actor{ID: "https://remote.example.com/users/jdoe", Type: vocab.PersonType}
+	local := &vocab.Actor{ID: base.AddPath("actors", "janedoe"), Type: vocab.PersonType}
+	other := &vocab.Actor{ID: base.AddPath("actors", "johndoe"), Type: vocab.PersonType}
+	note := &vocab.Object{ID: "https://remote.example.com/notes/1", Type: vocab.NoteType, AttributedTo: remote.ID}
+	create := &vocab.Activity{ID: "https://remote.example.com/activities/1", Type: vocab.CreateType, Actor: remote.ID, Object: note.ID}
+	localNote := &vocab.Object{ID: base.AddPath("objects", "1"), Type: vocab.NoteType, AttributedTo: local.ID}
+	del := &vocab.Activity{ID: "https://remote.example.com/activities/2", Type: vocab.DeleteType, Actor: remote.ID, Object: remote.ID}
+
+	db := mockCollectionStore{mockStore{}}
+	for _, it := range []vocab.Item{remote, local, other, note, create, localNote} {
+		db.Save(it)
+	}
+	for _, act := range []vocab.Item{local, other} {
+		db.Create(&vocab.OrderedCollection{ID: vocab.Followers.IRI(act), Type: vocab.OrderedCollectionType})
+		db.Create(&vocab.OrderedCollection{ID: vocab.Following.IRI(act), Type: vocab.OrderedCollectionType})
+	}
+	followers := vocab.Followers.IRI(local)
+	following := vocab.Following.IRI(local)
+	db.AddTo(followers, remote)
+	db.AddTo(following, remote)
+	db.AddTo(following, other)
+
+	modified, err := deleteRemoteActor(db, base, del, now)
+	if err != nil {
+		t.Fatalf("deleteRemoteActor() returned error %s", err)
+	}
+
+	for _, it := range []vocab.Item{remote, note, create} {
+		if !modified.Contains(it.GetLink()) {
+			t.Errorf("deleteRemoteActor() modified %v, missing %s", modified, it.GetLink())
+		}
+		tomb, ok := db.mockStore[it.GetLink()].(*vocab.Tombstone)
+		if !ok {
+			t.Errorf("%s should have been replaced with a Tombstone, found %T", it.GetLink(), db.mockStore[it.GetLink()])
+			continue
+		}
+		if tomb.FormerType != it.GetType() || !tomb.Deleted.Equal(now) {
+			t.Errorf("Tombstone %s has former type %s deleted at %s, expected %s and %s", tomb.ID, tomb.FormerType, tomb.Deleted, it.GetType(), now)
+		}
+	}
+	if typ := db.mockStore[localNote.ID].GetType(); typ != vocab.NoteType {
+		t.Errorf("local object %s has been modified to %s", localNote.ID, typ)
+	}
+
+	for _, col := range []vocab.IRI{followers, following} {
+		if !modified.Contains(col) {
+			t.Errorf("deleteRemoteActor() modified %v, missing %s", modified, col)
+		}
+		if collectionContains(db, col, remote.ID) {
+			t.Errorf("%s should not contain the deleted %s", col, remote.ID)
+		}
+	}
+	if !collectionContains(db, following, other.ID) {
+		t.Errorf("%s should still contain %s", following, other.ID)
+	}
+
+	t.Run("not an actor Delete", func(t *testing.T) {
+		del := &vocab.Activity{Type: vocab.DeleteType, Actor: remote.ID, Object: note.ID}
+		if modified, err := deleteRemoteActor(db, base, del, now); err != nil || len(modified) > 0 {
+			t.Errorf("deleteRemoteActor() = %v, %v, expected nothing to be modified", modified, err)
+		}
+	})
+}
+
+func TestCheckInboxOwner(t *testing.T) {
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	deleted := tombstoneFor(&vocab.Actor{ID: "https://fedbox.local/actors/janedoe", Type: vocab.PersonType}, time.Now())
+	db := mockStore{johnDoe.ID: johnDoe, deleted.ID: deleted}
+
+	if err := checkInboxOwner(db, vocab.Inbox.IRI(johnDoe)); err != nil {
+		t.Errorf("checkInboxOwner() returned error %s for an existing actor", err)
+	}
+	err := checkInboxOwner(db, vocab.Inbox.IRI(deleted))
+	if err == nil {
+		t.Fatalf("checkInboxOwner() expected error for a deleted actor")
+	}
+	if status := errors.HttpStatus(err); status != http.StatusGone {
+		t.Errorf("checkInboxOwner() error status %d, expected %d", status, http.StatusGone)
+	}
+	if err = checkInboxOwner(db, vocab.Outbox.IRI(deleted)); err != nil {
+		t.Errorf("checkInboxOwner() returned error %s for an outbox", err)
+	}
+}
+
+func TestValidateCreateObject(t *testing.T) {
+	actor := vocab.IRI("https://example.com/users/jdoe")
+	note := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+	deleted := tombstoneFor(&vocab.Object{ID: "https://example.com/objects/2", Type: vocab.NoteType}, time.Now())
+
+	tests := []struct {
+		name    string
+		act     *vocab.Activity
+		wantErr bool
+	}{
+		{
+			name: "create of an object",
+			act:  &vocab.Activity{Type: vocab.CreateType, Actor: actor, Object: note},
+		},
+		{
+			name:    "create of a tombstone",
+			act:     &vocab.Activity{Type: vocab.CreateType, Actor: actor, Object: deleted},
+			wantErr: true,
+		},
+		{
+			name:    "create of a tombstone among other objects",
+			act:     &vocab.Activity{Type: vocab.CreateType, Actor: actor, Object: vocab.ItemCollection{note, deleted}},
+			wantErr: true,
+		},
+		{
+			name: "create of an IRI",
+			act:  &vocab.Activity{Type: vocab.CreateType, Actor: actor, Object: deleted.ID},
+		},
+		{
+			name: "update with a tombstone",
+			act:  &vocab.Activity{Type: vocab.UpdateType, Actor: actor, Object: deleted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCreateObject(tt.act)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateCreateObject() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && errors.HttpStatus(err) != http.StatusBadRequest {
+				t.Errorf("ValidateCreateObject() error status %d, expected %d", errors.HttpStatus(err), http.StatusBadRequest)
+			}
+		})
+	}
+}
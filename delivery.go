@@ -0,0 +1,296 @@
+package fedbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// maxDeliveryBackoff is the longest we wait between two attempts of a delivery
+const maxDeliveryBackoff = 6 * time.Hour
+
+// inboxPoster is the interface for posting raw requests to remote inboxes, it's usually implemented by
+// the client.C type. We use it when available, as the ToCollection method doesn't expose the response headers.
+type inboxPoster interface {
+	Post(url, contentType string, body io.Reader) (*http.Response, error)
+}
+
+// deliveryStatusError is the error of a delivery refused by the remote inbox with the HTTP status, together with
+// the delay it asked for in the Retry-After header of the response
+type deliveryStatusError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e deliveryStatusError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, http.StatusText(e.status))
+}
+
+// delivery is an activity waiting to be delivered again to a remote inbox
+type delivery struct {
+	inbox    vocab.IRI
+	it       vocab.Item
+	attempts int
+	next     time.Time
+}
+
+// deliveryQueue keeps in memory the failed deliveries of activities to remote inboxes, and retries them with
+// an exponential backoff starting at interval, until they succeed or they have been attempted maxAttempts times.
+// When markUnreachable is set, the inboxes which respond with 410 Gone are not delivered to anymore.
+type deliveryQueue struct {
+	cl              activityDeliverer
+	maxAttempts     int
+	interval        time.Duration
+	markUnreachable bool
+	m               sync.Mutex
+	pending         []*delivery
+	unreachable     map[vocab.IRI]struct{}
+}
+
+// newDeliveryQueue returns a deliveryQueue using the cl client.
+// A maxAttempts lower than 2 means the failed deliveries are not retried.
+func newDeliveryQueue(cl activityDeliverer, maxAttempts int, interval time.Duration, markUnreachable bool) *deliveryQueue {
+	return &deliveryQueue{
+		cl:              cl,
+		maxAttempts:     maxAttempts,
+		interval:        interval,
+		markUnreachable: markUnreachable,
+		pending:         make([]*delivery, 0),
+		unreachable:     make(map[vocab.IRI]struct{}),
+	}
+}
+
+// deliver posts the it activity to the inbox, and queues it for retrying when it fails
+func (q *deliveryQueue) deliver(inbox vocab.IRI, it vocab.Item, now time.Time) error {
+	if q.isUnreachable(inbox) {
+		return errors.Gonef("unable to deliver %s to %s, the inbox is unreachable", it.GetLink(), inbox)
+	}
+	d := &delivery{inbox: inbox, it: it}
+	return q.attempt(d, now)
+}
+
+// isUnreachable checks if the inbox has been marked as unreachable
+func (q *deliveryQueue) isUnreachable(inbox vocab.IRI) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	_, ok := q.unreachable[inbox]
+	return ok
+}
+
+// retryAfter parses the value of a Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// send posts the activity of the d delivery to its inbox
+func (q *deliveryQueue) send(d *delivery, now time.Time) error {
+	p, ok := q.cl.(inboxPoster)
+	if !ok {
+		_, _, err := q.cl.ToCollection(d.inbox, d.it)
+		return err
+	}
+	body, err := vocab.MarshalJSON(d.it)
+	if err != nil {
+		return err
+	}
+	resp, err := p.Post(d.inbox.String(), client.ContentTypeActivityJson, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if resp.Body != nil {
+		defer resp.Body.Close()
+	}
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	return deliveryStatusError{status: resp.StatusCode, retryAfter: retryAfter(resp.Header.Get("Retry-After"), now)}
+}
+
+// isPermanentFailure checks if the err delivery error means that retrying the delivery is pointless:
+// the inbox is gone, or it refused the activity for other reasons than the rate limits or a timeout.
+func isPermanentFailure(err error) bool {
+	if errors.IsGone(err) {
+		return true
+	}
+	var se deliveryStatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return se.status >= http.StatusBadRequest && se.status < http.StatusInternalServerError
+}
+
+// isGone checks if the err delivery error means that the inbox doesn't exist anymore
+func isGone(err error) bool {
+	var se deliveryStatusError
+	return errors.IsGone(err) || (errors.As(err, &se) && se.status == http.StatusGone)
+}
+
+// backoff returns when the d delivery which has failed with the err error should be attempted again:
+// the delay doubles with every attempt, but it's never shorter than the one asked by the inbox in the
+// Retry-After header of a 429 or 503 response.
+func (q *deliveryQueue) backoff(d *delivery, err error, now time.Time) time.Time {
+	delay := q.interval
+	for i := 1; i < d.attempts && delay < maxDeliveryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxDeliveryBackoff {
+		delay = maxDeliveryBackoff
+	}
+	var se deliveryStatusError
+	if errors.As(err, &se) && (se.status == http.StatusTooManyRequests || se.status == http.StatusServiceUnavailable) {
+		if se.retryAfter > delay {
+			delay = se.retryAfter
+		}
+	}
+	return now.Add(delay)
+}
+
+// attempt posts the activity of the d delivery, queuing it again when it fails and it has attempts left.
+// The deliveries which failed permanently are dropped.
+func (q *deliveryQueue) attempt(d *delivery, now time.Time) error {
+	d.attempts++
+	err := q.send(d, now)
+	if err == nil {
+		return nil
+	}
+	if isPermanentFailure(err) {
+		if q.markUnreachable && isGone(err) {
+			q.m.Lock()
+			q.unreachable[d.inbox] = struct{}{}
+			q.m.Unlock()
+		}
+		return errors.Annotatef(err, "unable to deliver %s to %s, giving up", d.it.GetLink(), d.inbox)
+	}
+	if d.attempts < q.maxAttempts {
+		d.next = q.backoff(d, err, now)
+		q.m.Lock()
+		q.pending = append(q.pending, d)
+		q.m.Unlock()
+	}
+	return errors.Annotatef(err, "unable to deliver %s to %s, attempt %d of %d", d.it.GetLink(), d.inbox, d.attempts, q.maxAttempts)
+}
+
+// retry attempts again the queued deliveries which are due at now.
+// It returns the number of deliveries that have succeeded, and the errors of the failed ones.
+func (q *deliveryQueue) retry(now time.Time) (int, []error) {
+	q.m.Lock()
+	due := make([]*delivery, 0)
+	waiting := make([]*delivery, 0, len(q.pending))
+	for _, d := range q.pending {
+		if now.Before(d.next) {
+			waiting = append(waiting, d)
+		} else {
+			due = append(due, d)
+		}
+	}
+	q.pending = waiting
+	q.m.Unlock()
+
+	delivered := 0
+	var errs []error
+	for _, d := range due {
+		if err := q.attempt(d, now); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered++
+	}
+	return delivered, errs
+}
+
+// len returns the number of deliveries waiting to be retried
+func (q *deliveryQueue) len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.pending)
+}
+
+// retryDeliveries retries the failed deliveries until the ctx context is done
+func (f *FedBOX) retryDeliveries(ctx context.Context) {
+	if f.deliveries == nil || f.deliveries.interval <= 0 {
+		return
+	}
+	t := time.NewTicker(f.deliveries.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			delivered, errs := f.deliveries.retry(now.UTC())
+			for _, err := range errs {
+				f.errFn("%+s", err)
+			}
+			if delivered > 0 {
+				f.infFn("delivered %d activities on retry", delivered)
+			}
+		}
+	}
+}
+
+// followResponseInbox returns the inbox of the remote actor which has sent the Follow that the a Accept or Reject
+// activity responds to. It returns an empty IRI when a is not the response to a Follow, or when the follower is
+// a local actor of the base service, which the activity processing takes care of.
+func followResponseInbox(db processing.ReadStore, cl iriLoader, base vocab.IRI, a *vocab.Activity) (vocab.IRI, error) {
+	if a == nil || (a.GetType() != vocab.AcceptType && a.GetType() != vocab.RejectType) || vocab.IsNil(a.Object) {
+		return "", nil
+	}
+	follow, err := loadFollow(db, a.Object)
+	if err != nil || vocab.IsNil(follow.Actor) {
+		return "", nil
+	}
+	follower := follow.Actor.GetLink()
+	if follower.Contains(base, false) {
+		return "", nil
+	}
+	it := follow.Actor
+	if vocab.IsIRI(it) {
+		if it, err = cl.LoadIRI(follower); err != nil {
+			return "", errors.Annotatef(err, "unable to load the follower %s", follower)
+		}
+	}
+	inbox := inboxOf(it)
+	if inbox == "" {
+		return "", errors.NotFoundf("no inbox found for the follower %s", follower)
+	}
+	return inbox, nil
+}
+
+// deliverFollowResponse delivers the a Accept or Reject of a Follow to the inbox of the remote actor which has
+// sent the Follow, queuing it for retrying when it fails. It returns the inbox it has been delivered to.
+//
+// NOTE(marius): the follower might be one of the recipients of the activity too, in which case it receives the
+// activity twice, but the servers discard the activities they have already received.
+func deliverFollowResponse(q *deliveryQueue, db processing.ReadStore, base vocab.IRI, a *vocab.Activity) (vocab.IRI, error) {
+	inbox, err := followResponseInbox(db, q.cl, base, a)
+	if err != nil || inbox == "" {
+		return "", err
+	}
+	if err = q.deliver(inbox, a, time.Now().UTC()); err != nil {
+		return "", err
+	}
+	return inbox, nil
+}
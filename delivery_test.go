@@ -0,0 +1,212 @@
+package fedbox
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// flakyDeliverer fails the first failures deliveries to each inbox
+type flakyDeliverer struct {
+	mockDeliverer
+	failures int
+	attempts map[vocab.IRI]int
+}
+
+func (f *flakyDeliverer) ToCollection(inbox vocab.IRI, it vocab.Item) (vocab.IRI, vocab.Item, error) {
+	f.attempts[inbox]++
+	if f.attempts[inbox] <= f.failures {
+		return "", nil, errors.Newf("%s is temporarily unavailable", inbox)
+	}
+	return f.mockDeliverer.ToCollection(inbox, it)
+}
+
+// flappingInbox responds to the posts to each inbox with the statuses, in order, and with 202 Accepted
+// after it runs out of them
+type flappingInbox struct {
+	mockDeliverer
+	statuses   []int
+	retryAfter string
+	posts      map[string]int
+}
+
+func (f *flappingInbox) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	status := http.StatusAccepted
+	if n := f.posts[url]; n < len(f.statuses) {
+		status = f.statuses[n]
+	}
+	f.posts[url]++
+	h := make(http.Header)
+	if f.retryAfter != "" {
+		h.Set("Retry-After", f.retryAfter)
+	}
+	return &http.Response{StatusCode: status, Header: h, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestDeliveryQueueBackoff(t *testing.T) {
+	inbox := vocab.IRI("https://example.com/users/bob/inbox")
+	create := &vocab.Activity{ID: "https://fedbox.local/activities/create", Type: vocab.CreateType}
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	newInbox := func(retryAfter string, statuses ...int) *flappingInbox {
+		return &flappingInbox{
+			mockDeliverer: mockDeliverer{delivered: make(map[vocab.IRI]vocab.IRIs)},
+			statuses:      statuses,
+			retryAfter:    retryAfter,
+			posts:         make(map[string]int),
+		}
+	}
+
+	t.Run("succeeds on the third attempt", func(t *testing.T) {
+		cl := newInbox("", http.StatusBadGateway, http.StatusInternalServerError)
+		q := newDeliveryQueue(cl, 5, time.Minute, false)
+		if err := q.deliver(inbox, create, now); err == nil {
+			t.Fatalf("deliver() should fail on the first attempt")
+		}
+		if delivered, errs := q.retry(now.Add(time.Minute)); delivered != 0 || len(errs) != 1 {
+			t.Fatalf("retry() = %d, %v, expected the second attempt to fail", delivered, errs)
+		}
+		if delivered, _ := q.retry(now.Add(2 * time.Minute)); delivered != 0 || cl.posts[inbox.String()] != 2 {
+			t.Errorf("retry() should back off after the second attempt, posted %d times", cl.posts[inbox.String()])
+		}
+		if delivered, errs := q.retry(now.Add(3 * time.Minute)); delivered != 1 || len(errs) != 0 {
+			t.Errorf("retry() = %d, %v, expected the third attempt to succeed", delivered, errs)
+		}
+		if q.len() != 0 {
+			t.Errorf("the queue should be empty after the delivery, has %d", q.len())
+		}
+	})
+	t.Run("honors Retry-After", func(t *testing.T) {
+		cl := newInbox("600", http.StatusTooManyRequests)
+		q := newDeliveryQueue(cl, 5, time.Minute, false)
+		q.deliver(inbox, create, now)
+		if delivered, _ := q.retry(now.Add(5 * time.Minute)); delivered != 0 || cl.posts[inbox.String()] != 1 {
+			t.Errorf("retry() should wait for the Retry-After delay, posted %d times", cl.posts[inbox.String()])
+		}
+		if delivered, errs := q.retry(now.Add(10 * time.Minute)); delivered != 1 || len(errs) != 0 {
+			t.Errorf("retry() = %d, %v, expected the delivery after the Retry-After delay", delivered, errs)
+		}
+	})
+	t.Run("drops Gone inboxes", func(t *testing.T) {
+		cl := newInbox("", http.StatusGone)
+		q := newDeliveryQueue(cl, 5, time.Minute, true)
+		if err := q.deliver(inbox, create, now); err == nil {
+			t.Fatalf("deliver() should fail for a Gone inbox")
+		}
+		if q.len() != 0 {
+			t.Errorf("the delivery to a Gone inbox should not be retried, queue has %d", q.len())
+		}
+		if err := q.deliver(inbox, create, now); !errors.IsGone(err) || cl.posts[inbox.String()] != 1 {
+			t.Errorf("deliver() = %v, the inbox should be marked unreachable, posted %d times", err, cl.posts[inbox.String()])
+		}
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"invalid":                       0,
+		"Sat, 01 Jul 2023 12:30:00 GMT": 30 * time.Minute,
+		"Sat, 01 Jul 2023 11:30:00 GMT": 0,
+	}
+	for v, want := range tests {
+		if got := retryAfter(v, now); got != want {
+			t.Errorf("retryAfter(%q) = %s, expected %s", v, got, want)
+		}
+	}
+}
+
+func TestDeliverFollowResponse(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	bob := &vocab.Actor{
+		ID:    "https://example.com/users/bob",
+		Type:  vocab.PersonType,
+		Inbox: vocab.IRI("https://example.com/users/bob/inbox"),
+	}
+	follow := &vocab.Activity{ID: "https://example.com/activities/follow", Type: vocab.FollowType, Actor: bob.ID, Object: johnDoe}
+	localFollow := &vocab.Activity{ID: "https://fedbox.local/activities/follow", Type: vocab.FollowType, Actor: vocab.IRI("https://fedbox.local/actors/janedoe"), Object: johnDoe}
+	db := mockStore{follow.ID: follow, localFollow.ID: localFollow}
+
+	newClient := func(failures int) *flakyDeliverer {
+		return &flakyDeliverer{
+			mockDeliverer: mockDeliverer{mockLoader: mockLoader{bob.ID: bob}, delivered: make(map[vocab.IRI]vocab.IRIs)},
+			failures:      failures,
+			attempts:      make(map[vocab.IRI]int),
+		}
+	}
+
+	accept := &vocab.Activity{ID: "https://fedbox.local/activities/accept", Type: vocab.AcceptType, Actor: johnDoe, Object: follow.ID}
+	cl := newClient(0)
+	q := newDeliveryQueue(cl, 3, time.Minute, false)
+	inbox, err := deliverFollowResponse(q, db, base, accept)
+	if err != nil {
+		t.Fatalf("deliverFollowResponse() returned error %s", err)
+	}
+	if inbox != bob.Inbox.GetLink() {
+		t.Errorf("deliverFollowResponse() delivered to %s, expected %s", inbox, bob.Inbox.GetLink())
+	}
+	if !cl.delivered[bob.Inbox.GetLink()].Contains(accept.ID) {
+		t.Errorf("the Accept should be delivered to the follower's inbox, delivered %v", cl.delivered)
+	}
+
+	reject := &vocab.Activity{ID: "https://fedbox.local/activities/reject", Type: vocab.RejectType, Actor: johnDoe, Object: localFollow.ID}
+	if inbox, err = deliverFollowResponse(q, db, base, reject); err != nil || inbox != "" {
+		t.Errorf("deliverFollowResponse() = %q, %v, expected no delivery for a local follower", inbox, err)
+	}
+	like := &vocab.Activity{ID: "https://fedbox.local/activities/like", Type: vocab.LikeType, Actor: johnDoe, Object: follow.ID}
+	if inbox, err = deliverFollowResponse(q, db, base, like); err != nil || inbox != "" {
+		t.Errorf("deliverFollowResponse() = %q, %v, expected no delivery for a Like", inbox, err)
+	}
+
+	t.Run("retried on transient failure", func(t *testing.T) {
+		cl := newClient(2)
+		q := newDeliveryQueue(cl, 3, time.Minute, false)
+		now := time.Now().UTC()
+		if err := q.deliver(bob.Inbox.GetLink(), accept, now); err == nil {
+			t.Fatalf("deliver() should fail on the first attempt")
+		}
+		if q.len() != 1 {
+			t.Fatalf("the failed delivery should be queued, queue has %d", q.len())
+		}
+		if delivered, _ := q.retry(now.Add(time.Second)); delivered != 0 || cl.attempts[bob.Inbox.GetLink()] != 1 {
+			t.Errorf("retry() should wait for the retry interval, attempts %d", cl.attempts[bob.Inbox.GetLink()])
+		}
+		if delivered, errs := q.retry(now.Add(time.Minute)); delivered != 0 || len(errs) != 1 {
+			t.Errorf("retry() = %d, %v, expected the second attempt to fail", delivered, errs)
+		}
+		if delivered, _ := q.retry(now.Add(2 * time.Minute)); delivered != 0 || cl.attempts[bob.Inbox.GetLink()] != 2 {
+			t.Errorf("retry() should wait twice the retry interval after the second attempt, attempts %d", cl.attempts[bob.Inbox.GetLink()])
+		}
+		if delivered, errs := q.retry(now.Add(3 * time.Minute)); delivered != 1 || len(errs) != 0 {
+			t.Errorf("retry() = %d, %v, expected the third attempt to succeed", delivered, errs)
+		}
+		if !cl.delivered[bob.Inbox.GetLink()].Contains(accept.ID) {
+			t.Errorf("the Accept should be delivered on retry, delivered %v", cl.delivered)
+		}
+		if q.len() != 0 {
+			t.Errorf("the queue should be empty after the delivery, has %d", q.len())
+		}
+	})
+
+	t.Run("dropped after the max attempts", func(t *testing.T) {
+		cl := newClient(5)
+		q := newDeliveryQueue(cl, 2, time.Minute, false)
+		now := time.Now().UTC()
+		q.deliver(bob.Inbox.GetLink(), accept, now)
+		q.retry(now.Add(time.Minute))
+		if q.len() != 0 {
+			t.Errorf("the delivery should be dropped after %d attempts, queue has %d", 2, q.len())
+		}
+		if cl.attempts[bob.Inbox.GetLink()] != 2 {
+			t.Errorf("the delivery was attempted %d times, expected 2", cl.attempts[bob.Inbox.GetLink()])
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/cache"
+)
+
+// iriLoader is the interface for dereferencing remote IRIs, it's usually implemented by the client.C type.
+type iriLoader interface {
+	LoadIRI(vocab.IRI) (vocab.Item, error)
+}
+
+// shouldEmbedRemote checks if the collections of type typ should have their remote references embedded
+func shouldEmbedRemote(embedFor []string, typ vocab.CollectionPath) bool {
+	for _, t := range embedFor {
+		if vocab.CollectionPath(t) == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// embedRemoteItems replaces the items that are IRIs pointing outside of the base IRI with the objects
+// they reference. The objects are loaded using the cl loader and are stored in the c cache.
+// If an object fails to load, we leave its IRI in place.
+func embedRemoteItems(items vocab.ItemCollection, base vocab.IRI, cl iriLoader, c cache.CanStore) vocab.ItemCollection {
+	for i, it := range items {
+		if vocab.IsNil(it) || !vocab.IsIRI(it) {
+			continue
+		}
+		iri := it.GetLink()
+		if iri.Contains(base, false) {
+			continue
+		}
+		ob := c.Get(iri)
+		if vocab.IsNil(ob) {
+			var err error
+			if ob, err = cl.LoadIRI(iri); err != nil || vocab.IsNil(ob) {
+				continue
+			}
+			c.Set(iri, ob)
+		}
+		items[i] = ob
+	}
+	return items
+}
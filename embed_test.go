@@ -0,0 +1,87 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/cache"
+)
+
+type mockLoader map[vocab.IRI]vocab.Item
+
+func (m mockLoader) LoadIRI(iri vocab.IRI) (vocab.Item, error) {
+	if it, ok := m[iri]; ok {
+		return it, nil
+	}
+	return nil, errors.NotFoundf("%s not found", iri)
+}
+
+func TestEmbedRemoteItems(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	local := vocab.IRI("https://fedbox.local/objects/1")
+	remote := vocab.IRI("https://example.com/objects/1")
+	missing := vocab.IRI("https://example.com/objects/666")
+	remoteOb := &vocab.Object{ID: remote, Type: vocab.NoteType}
+
+	loader := mockLoader{remote: remoteOb}
+
+	tests := []struct {
+		name     string
+		embedFor []string
+		typ      vocab.CollectionPath
+		want     vocab.ItemCollection
+	}{
+		{
+			name:     "embed when configured",
+			embedFor: []string{"inbox"},
+			typ:      vocab.Inbox,
+			want:     vocab.ItemCollection{local, remoteOb, missing},
+		},
+		{
+			name:     "links when not configured",
+			embedFor: nil,
+			typ:      vocab.Inbox,
+			want:     vocab.ItemCollection{local, remote, missing},
+		},
+		{
+			name:     "links when configured for other collection",
+			embedFor: []string{"outbox"},
+			typ:      vocab.Inbox,
+			want:     vocab.ItemCollection{local, remote, missing},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := vocab.ItemCollection{local, remote, missing}
+			if shouldEmbedRemote(tt.embedFor, tt.typ) {
+				items = embedRemoteItems(items, base, loader, cache.New(true, 0, 0))
+			}
+			if len(items) != len(tt.want) {
+				t.Fatalf("invalid number of items %d, expected %d", len(items), len(tt.want))
+			}
+			for i, it := range items {
+				if !vocab.ItemsEqual(it, tt.want[i]) {
+					t.Errorf("invalid item at position %d %v, expected %v", i, it, tt.want[i])
+				}
+				if vocab.IsIRI(it) != vocab.IsIRI(tt.want[i]) {
+					t.Errorf("item at position %d should have been embedded: %v", i, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedRemoteItemsUsesCache(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	remote := vocab.IRI("https://example.com/objects/1")
+	remoteOb := &vocab.Object{ID: remote, Type: vocab.NoteType}
+
+	c := cache.New(true, 0, 0)
+	c.Set(remote, remoteOb)
+
+	items := embedRemoteItems(vocab.ItemCollection{remote}, base, mockLoader{}, c)
+	if vocab.IsIRI(items.First()) {
+		t.Errorf("item should have been loaded from the cache: %v", items.First())
+	}
+}
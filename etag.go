@@ -0,0 +1,60 @@
+package fedbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// itemETag builds the entity tag of an item from its serialized representation, so it changes every time the item
+// is saved with a different content.
+func itemETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches checks if the etag is one of the entity tags in the value of an If-None-Match header.
+// As required for If-None-Match, the tags are compared with the weak comparison function.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ConditionalGet is a middleware which adds an ETag to the successful responses of the GET requests, and responds
+// with a 304 Not Modified status, without a body, when it matches the If-None-Match header of the request.
+func ConditionalGet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(&bw, r)
+
+		data := bw.buf.Bytes()
+		if bw.status == http.StatusOK {
+			etag := itemETag(data)
+			w.Header().Set("ETag", etag)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Type")
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(bw.status)
+		w.Write(data)
+	})
+}
@@ -0,0 +1,72 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestConditionalGet(t *testing.T) {
+	iri := vocab.IRI("https://fedbox.local/objects/1")
+	db := mockStore{iri: &vocab.Object{
+		ID:      iri,
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguageValue("Hello"),
+	}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		it, err := db.Load(iri)
+		if err != nil {
+			t.Fatalf("unable to load %s: %s", iri, err)
+		}
+		writeItem(w, r, http.StatusOK, it)
+	})
+	serve := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/objects/1", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		ConditionalGet(next).ServeHTTP(w, r)
+		return w
+	}
+
+	first := serve("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("the first request returned status %d and ETag %q", first.Code, etag)
+	}
+	if again := serve(""); again.Header().Get("ETag") != etag {
+		t.Errorf("the ETag of the same object changed from %q to %q", etag, again.Header().Get("ETag"))
+	}
+
+	cached := serve(etag)
+	if cached.Code != http.StatusNotModified {
+		t.Errorf("the request with a matching If-None-Match returned status %d, expected %d", cached.Code, http.StatusNotModified)
+	}
+	if cached.Body.Len() > 0 {
+		t.Errorf("the 304 response has a body %s", cached.Body.String())
+	}
+	if weak := serve(`"other", W/` + etag); weak.Code != http.StatusNotModified {
+		t.Errorf("the request with a list containing the weak ETag returned status %d, expected %d", weak.Code, http.StatusNotModified)
+	}
+
+	if _, err := db.Save(&vocab.Object{
+		ID:      iri,
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguageValue("Hello, world"),
+	}); err != nil {
+		t.Fatalf("unable to update %s: %s", iri, err)
+	}
+	updated := serve(etag)
+	if updated.Code != http.StatusOK {
+		t.Errorf("the request for the updated object returned status %d, expected %d", updated.Code, http.StatusOK)
+	}
+	if newETag := updated.Header().Get("ETag"); newETag == "" || newETag == etag {
+		t.Errorf("the ETag of the updated object is %q, expected a new one instead of %q", newETag, etag)
+	}
+	if updated.Body.Len() == 0 {
+		t.Errorf("the response for the updated object has no body")
+	}
+}
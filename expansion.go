@@ -0,0 +1,241 @@
+package fedbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-ap/errors"
+)
+
+const (
+	formatKey = "format"
+
+	formatCompact  = "compact"
+	formatExpanded = "expanded"
+
+	// expandedProfile is the JSON-LD profile of the expanded documents
+	expandedProfile = "http://www.w3.org/ns/json-ld#expanded"
+)
+
+// The namespaces of the terms in the contexts we serve
+const (
+	nsActivityStreams = "https://www.w3.org/ns/activitystreams#"
+	nsSecurity        = "https://w3id.org/security#"
+	nsLDP             = "http://www.w3.org/ns/ldp#"
+	nsXSD             = "http://www.w3.org/2001/XMLSchema#"
+)
+
+// ldPrefixes are the compact IRI prefixes defined by the contexts we serve
+var ldPrefixes = map[string]string{
+	"as":  nsActivityStreams,
+	"sec": nsSecurity,
+	"ldp": nsLDP,
+	"xsd": nsXSD,
+}
+
+// ldTerm is the definition of a term of the contexts we serve: the IRI it expands to, and the type of its values,
+// which is "@id" for the values that are references to other nodes.
+type ldTerm struct {
+	iri  string
+	typ  string
+	list bool
+}
+
+// ldTerms is the subset of the ActivityStreams and security contexts for the properties that the vocab package
+// serializes. The terms which are not defined here are dropped by the expansion, like the JSON-LD processors do.
+var ldTerms = func() map[string]ldTerm {
+	terms := make(map[string]ldTerm)
+	for _, t := range []string{
+		"actor", "anyOf", "attachment", "attributedTo", "audience", "bcc", "bto", "cc", "context", "current",
+		"describes", "endpoints", "first", "followers", "following", "generator", "icon", "image", "inReplyTo",
+		"instrument", "items", "last", "liked", "likes", "location", "next", "object", "oneOf", "origin",
+		"outbox", "partOf", "prev", "preview", "relationship", "replies", "result", "shares", "streams",
+		"subject", "tag", "target", "to", "url", "href", "alsoKnownAs", "movedTo", "sharedInbox",
+	} {
+		terms[t] = ldTerm{iri: nsActivityStreams + t, typ: "@id"}
+	}
+	for _, t := range []string{
+		"name", "summary", "content", "mediaType", "preferredUsername", "rel", "hreflang", "units", "source",
+		"closed", "formerType", "sensitive", "manuallyApprovesFollowers",
+	} {
+		terms[t] = ldTerm{iri: nsActivityStreams + t}
+	}
+	for t, typ := range map[string]string{
+		"published": "dateTime", "updated": "dateTime", "startTime": "dateTime", "endTime": "dateTime",
+		"deleted": "dateTime", "duration": "duration", "totalItems": "nonNegativeInteger",
+		"startIndex": "nonNegativeInteger", "width": "nonNegativeInteger", "height": "nonNegativeInteger",
+		"altitude": "float", "latitude": "float", "longitude": "float", "radius": "float", "accuracy": "float",
+	} {
+		terms[t] = ldTerm{iri: nsActivityStreams + t, typ: nsXSD + typ}
+	}
+	terms["orderedItems"] = ldTerm{iri: nsActivityStreams + "items", typ: "@id", list: true}
+	terms["inbox"] = ldTerm{iri: nsLDP + "inbox", typ: "@id"}
+	terms["publicKey"] = ldTerm{iri: nsSecurity + "publicKey", typ: "@id"}
+	terms["owner"] = ldTerm{iri: nsSecurity + "owner", typ: "@id"}
+	terms["publicKeyPem"] = ldTerm{iri: nsSecurity + "publicKeyPem"}
+	return terms
+}()
+
+// ldLanguageMaps are the language maps of the natural language properties
+var ldLanguageMaps = map[string]string{"nameMap": "name", "summaryMap": "summary", "contentMap": "content"}
+
+// expandIRI returns the absolute IRI for the s term, compact IRI, or IRI
+func expandIRI(s string) (string, bool) {
+	if t, ok := ldTerms[s]; ok {
+		return t.iri, true
+	}
+	if i := strings.Index(s, ":"); i > 0 {
+		if ns, ok := ldPrefixes[s[:i]]; ok {
+			return ns + s[i+1:], true
+		}
+		if strings.HasPrefix(s[i+1:], "//") {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// expandType returns the absolute IRI of the typ type, which is an ActivityStreams type when it's not an IRI
+func expandType(typ string) string {
+	if !strings.Contains(typ, ":") {
+		return nsActivityStreams + typ
+	}
+	if iri, ok := expandIRI(typ); ok {
+		return iri
+	}
+	return typ
+}
+
+// expandValue returns the expanded form of the v value of the t term
+func expandValue(v interface{}, t ldTerm) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return expandNode(val)
+	case string:
+		if t.typ == "@id" {
+			return map[string]interface{}{"@id": val}
+		}
+	}
+	if t.typ != "" && t.typ != "@id" {
+		return map[string]interface{}{"@type": t.typ, "@value": v}
+	}
+	return map[string]interface{}{"@value": v}
+}
+
+// expandValues returns the expanded values of the t term, which are always an array
+func expandValues(v interface{}, t ldTerm) []interface{} {
+	values, ok := v.([]interface{})
+	if !ok {
+		values = []interface{}{v}
+	}
+	result := make([]interface{}, 0, len(values))
+	for _, val := range values {
+		if val == nil {
+			continue
+		}
+		result = append(result, expandValue(val, t))
+	}
+	return result
+}
+
+// expandNode returns the expanded form of the node JSON object
+func expandNode(node map[string]interface{}) map[string]interface{} {
+	if v, ok := node["@value"]; ok {
+		return map[string]interface{}{"@value": v}
+	}
+	result := make(map[string]interface{})
+	for k, v := range node {
+		if v == nil {
+			continue
+		}
+		switch k {
+		case "@context":
+			continue
+		case "id", "@id":
+			if s, ok := v.(string); ok {
+				result["@id"] = s
+			}
+			continue
+		case "type", "@type":
+			types := make([]interface{}, 0)
+			for _, typ := range expandValues(v, ldTerm{}) {
+				if s, ok := typ.(map[string]interface{})["@value"].(string); ok {
+					types = append(types, expandType(s))
+				}
+			}
+			result["@type"] = types
+			continue
+		}
+		if prop, ok := ldLanguageMaps[k]; ok {
+			langs, _ := v.(map[string]interface{})
+			values, _ := result[ldTerms[prop].iri].([]interface{})
+			for lang, val := range langs {
+				values = append(values, map[string]interface{}{"@value": val, "@language": lang})
+			}
+			result[ldTerms[prop].iri] = values
+			continue
+		}
+		iri, ok := expandIRI(k)
+		if !ok {
+			continue
+		}
+		t, ok := ldTerms[k]
+		if !ok {
+			t = ldTerm{iri: iri}
+		}
+		values := expandValues(v, t)
+		if t.list {
+			result[iri] = []interface{}{map[string]interface{}{"@list": values}}
+			continue
+		}
+		if existing, ok := result[iri].([]interface{}); ok {
+			values = append(existing, values...)
+		}
+		result[iri] = values
+	}
+	return result
+}
+
+// expandJSONLD returns the expanded JSON-LD form of the data compact ActivityStreams document
+func expandJSONLD(data []byte) ([]byte, error) {
+	node := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&node); err != nil {
+		return nil, err
+	}
+	return json.Marshal([]interface{}{expandNode(node)})
+}
+
+// JSONLDFormat is a middleware which allows the clients to request the expanded JSON-LD form of the items, by using
+// the format query parameter: ?format=expanded. The default is the compact ActivityStreams form we always serve.
+func JSONLDFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := strings.ToLower(r.URL.Query().Get(formatKey))
+		if r.Method != http.MethodGet || format == "" || format == formatCompact {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if format != formatExpanded {
+			errors.HandleError(errors.BadRequestf("invalid %q parameter %q", formatKey, format)).ServeHTTP(w, r)
+			return
+		}
+
+		bw := bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(&bw, r)
+
+		data := bw.buf.Bytes()
+		if bw.status == http.StatusOK && isActivityPubContentType(w.Header().Get("Content-Type")) {
+			if exp, err := expandJSONLD(data); err == nil {
+				data = exp
+				w.Header().Set("Content-Type", "application/ld+json; profile=\""+expandedProfile+"\"")
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(bw.status)
+		w.Write(data)
+	})
+}
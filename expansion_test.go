@@ -0,0 +1,114 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+)
+
+func TestExpandJSONLD(t *testing.T) {
+	compact := []byte(`{
+		"@context": "https://www.w3.org/ns/activitystreams",
+		"id": "https://fedbox.local/objects/1",
+		"type": "Note",
+		"attributedTo": "https://fedbox.local/actors/johndoe",
+		"to": ["https://www.w3.org/ns/activitystreams#Public"],
+		"contentMap": {"en": "Hello"},
+		"published": "2022-10-30T09:14:49Z",
+		"unknownTerm": "dropped",
+		"tag": [{"type": "Hashtag", "name": "#fedbox", "href": "https://fedbox.local/tags/fedbox"}]
+	}`)
+	expected := []interface{}{
+		map[string]interface{}{
+			"@id":                              "https://fedbox.local/objects/1",
+			"@type":                            []interface{}{nsActivityStreams + "Note"},
+			nsActivityStreams + "attributedTo": []interface{}{map[string]interface{}{"@id": "https://fedbox.local/actors/johndoe"}},
+			nsActivityStreams + "to":           []interface{}{map[string]interface{}{"@id": "https://www.w3.org/ns/activitystreams#Public"}},
+			nsActivityStreams + "content":      []interface{}{map[string]interface{}{"@value": "Hello", "@language": "en"}},
+			nsActivityStreams + "published": []interface{}{
+				map[string]interface{}{"@type": nsXSD + "dateTime", "@value": "2022-10-30T09:14:49Z"},
+			},
+			nsActivityStreams + "tag": []interface{}{
+				map[string]interface{}{
+					"@type":                    []interface{}{nsActivityStreams + "Hashtag"},
+					nsActivityStreams + "name": []interface{}{map[string]interface{}{"@value": "#fedbox"}},
+					nsActivityStreams + "href": []interface{}{map[string]interface{}{"@id": "https://fedbox.local/tags/fedbox"}},
+				},
+			},
+		},
+	}
+
+	data, err := expandJSONLD(compact)
+	if err != nil {
+		t.Fatalf("unable to expand: %s", err)
+	}
+	var expanded interface{}
+	if err = json.Unmarshal(data, &expanded); err != nil {
+		t.Fatalf("unable to unmarshal the expanded document: %s", err)
+	}
+	if !reflect.DeepEqual(expanded, expected) {
+		t.Errorf("expanded document\n%s\nexpected\n%#v", data, expected)
+	}
+}
+
+func TestJSONLDFormat(t *testing.T) {
+	ob := &vocab.Object{
+		ID:           "https://fedbox.local/objects/1",
+		Type:         vocab.NoteType,
+		AttributedTo: vocab.IRI("https://fedbox.local/actors/johndoe"),
+		Content:      vocab.DefaultNaturalLanguageValue("Hello"),
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeItem(w, r, http.StatusOK, ob)
+	})
+	serve := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		JSONLDFormat(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/objects/1"+query, nil))
+		return w
+	}
+
+	compact := serve("")
+	same := serve("?" + formatKey + "=" + formatCompact)
+	if compact.Body.String() != same.Body.String() {
+		t.Errorf("the compact format %s differs from the default one %s", same.Body.String(), compact.Body.String())
+	}
+	if ct := compact.Header().Get("Content-Type"); ct != client.ContentTypeActivityJson {
+		t.Errorf("the compact Content-Type is %q, expected %q", ct, client.ContentTypeActivityJson)
+	}
+	compactProps := make(map[string]interface{})
+	if err := json.Unmarshal(compact.Body.Bytes(), &compactProps); err != nil {
+		t.Fatalf("unable to unmarshal the compact document: %s", err)
+	}
+
+	expanded := serve("?" + formatKey + "=" + formatExpanded)
+	if expanded.Code != http.StatusOK {
+		t.Fatalf("the expanded format returned status %d", expanded.Code)
+	}
+	if ct := expanded.Header().Get("Content-Type"); ct != `application/ld+json; profile="`+expandedProfile+`"` {
+		t.Errorf("the expanded Content-Type is %q", ct)
+	}
+	nodes := make([]map[string]interface{}, 0)
+	if err := json.Unmarshal(expanded.Body.Bytes(), &nodes); err != nil || len(nodes) != 1 {
+		t.Fatalf("unable to unmarshal the expanded document %s: %v", expanded.Body.String(), err)
+	}
+	node := nodes[0]
+	if node["@id"] != compactProps["id"] {
+		t.Errorf("the expanded @id is %v, the compact id is %v", node["@id"], compactProps["id"])
+	}
+	if _, ok := node["@context"]; ok {
+		t.Errorf("the expanded document has a @context")
+	}
+	attributedTo, _ := node[nsActivityStreams+"attributedTo"].([]interface{})
+	if len(attributedTo) != 1 || attributedTo[0].(map[string]interface{})["@id"] != compactProps["attributedTo"] {
+		t.Errorf("the expanded attributedTo is %v, the compact one is %v", attributedTo, compactProps["attributedTo"])
+	}
+
+	if invalid := serve("?" + formatKey + "=flattened"); invalid.Code != http.StatusBadRequest {
+		t.Errorf("an invalid format returned status %d, expected %d", invalid.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,236 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+const featuredTagsPath = "featuredTags"
+
+// normalizeTag returns the name of the tag hashtag without the leading "#", in lower case
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// objectTags returns the normalized names of the hashtags of the it object
+func objectTags(it vocab.Item) []string {
+	tags := make([]string, 0)
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		if vocab.IsNil(o.Tag) {
+			return nil
+		}
+		for _, t := range o.Tag {
+			if vocab.IsNil(t) {
+				continue
+			}
+			var name string
+			vocab.OnLink(t, func(l *vocab.Link) error {
+				name = l.Name.First().String()
+				return nil
+			})
+			if name == "" {
+				vocab.OnObject(t, func(ob *vocab.Object) error {
+					name = ob.Name.First().String()
+					return nil
+				})
+			}
+			if strings.HasPrefix(name, "#") {
+				tags = append(tags, normalizeTag(name))
+			}
+		}
+		return nil
+	})
+	return tags
+}
+
+// tagUsage counts the objects created by the actor, from its outbox, which are tagged with each of the hashtags
+func tagUsage(db processing.ReadStore, actor vocab.Item) map[string]uint {
+	counts := make(map[string]uint)
+	outbox, err := db.Load(vocab.Outbox.IRI(actor))
+	if err != nil {
+		return counts
+	}
+	vocab.OnCollectionIntf(outbox, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if it.GetType() != vocab.CreateType {
+				continue
+			}
+			vocab.OnActivity(it, func(create *vocab.Activity) error {
+				ob := create.Object
+				if vocab.IsIRI(ob) {
+					if ob, err = db.Load(ob.GetLink()); err != nil {
+						return nil
+					}
+					ob = firstItem(ob)
+				}
+				for _, tag := range objectTags(ob) {
+					counts[tag]++
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return counts
+}
+
+// featuredTags returns the featured hashtags of the actor, as collections named after the hashtag, with the
+// number of the actor's objects tagged with it.
+func featuredTags(db processing.ReadStore, actor vocab.Item) vocab.ItemCollection {
+	col := vocab.IRI(actor.GetLink()).AddPath(featuredTagsPath)
+	tags := make([]string, 0)
+	if loaded, err := db.Load(col); err == nil {
+		vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+			for _, it := range c.Collection() {
+				if iri := it.GetLink(); iri.Contains(col, false) && iri != col {
+					tags = append(tags, path.Base(iri.String()))
+				}
+			}
+			return nil
+		})
+	}
+	sort.Strings(tags)
+
+	counts := tagUsage(db, actor)
+	items := make(vocab.ItemCollection, 0, len(tags))
+	for _, tag := range tags {
+		items = append(items, &vocab.OrderedCollection{
+			ID:         col.AddPath(tag),
+			Type:       vocab.OrderedCollectionType,
+			Name:       vocab.DefaultNaturalLanguageValue("#" + tag),
+			TotalItems: counts[tag],
+		})
+	}
+	return items
+}
+
+// pinTag adds the tag hashtag to the featured tags of the actor, or removes it from them when pin is false
+func pinTag(db collectionStore, actor vocab.Item, tag string, pin bool) error {
+	if tag = normalizeTag(tag); tag == "" || strings.ContainsAny(tag, "/?# ") {
+		return errors.BadRequestf("invalid hashtag %q", tag)
+	}
+	col := vocab.IRI(actor.GetLink()).AddPath(featuredTagsPath)
+	iri := col.AddPath(tag)
+	pinned := collectionContains(db, col, iri)
+	if pinned == pin {
+		return nil
+	}
+	if !pin {
+		if err := db.RemoveFrom(col, iri); err != nil {
+			return errors.Annotatef(err, "unable to remove #%s from %s", tag, col)
+		}
+		return nil
+	}
+	if _, err := db.Load(col); errors.IsNotFound(err) {
+		if _, err = db.Create(&vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType}); err != nil {
+			return errors.Annotatef(err, "unable to create %s", col)
+		}
+	}
+	if err := db.AddTo(col, iri); err != nil {
+		return errors.Annotatef(err, "unable to add #%s to %s", tag, col)
+	}
+	return nil
+}
+
+// featuredTagsActor returns the IRI of the actor whose featured tags collection the r request is for
+func featuredTagsActor(r *http.Request, secure bool) (vocab.IRI, error) {
+	u, err := url.Parse(reqURL(r, secure))
+	if err != nil {
+		return "", errors.NewBadRequest(err, "invalid request URL")
+	}
+	u.RawQuery = ""
+	p := strings.TrimSuffix(u.String(), "/")
+	if i := strings.LastIndex(p, "/"+featuredTagsPath); i > 0 {
+		p = p[:i]
+	}
+	return vocab.IRI(p), nil
+}
+
+func loadActorItem(db processing.ReadStore, iri vocab.IRI) (vocab.Item, error) {
+	it, err := db.Load(iri)
+	if err != nil {
+		return nil, err
+	}
+	if it = firstItem(it); vocab.IsNil(it) || !vocab.ActorTypes.Contains(it.GetType()) || !it.GetLink().Equals(iri, false) {
+		return nil, errors.NotFoundf("%s not found", iri)
+	}
+	return it, nil
+}
+
+func handleFeaturedTags(db processing.ReadStore, secure bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		iri, err := featuredTagsActor(r, secure)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		actor, err := loadActorItem(db, iri)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		items := featuredTags(db, actor)
+		col := vocab.OrderedCollection{
+			ID:           iri.AddPath(featuredTagsPath),
+			Type:         vocab.OrderedCollectionType,
+			OrderedItems: items,
+			TotalItems:   uint(len(items)),
+		}
+		data, err := vocab.MarshalJSON(&col)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+func handlePinTag(db collectionStore, secure bool, pin bool, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		iri, err := featuredTagsActor(r, secure)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		actor, err := loadActorItem(db, iri)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if by := actorFn(r); isAnonymous(by) || !by.GetLink().Equals(actor.GetLink(), false) {
+			errors.HandleError(errors.Unauthorizedf("only %s can change its featured tags", actor.GetLink())).ServeHTTP(w, r)
+			return
+		}
+		if err = pinTag(db, actor, path.Base(r.URL.Path), pin); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleFeaturedTags serves the collection of an actor's featured hashtags, with the number of its objects
+// tagged with each of them.
+func HandleFeaturedTags(fb FedBOX) http.HandlerFunc {
+	return handleFeaturedTags(fb.storage, fb.Config().Secure)
+}
+
+// HandlePinTag adds the hashtag in the request path to the actor's featured tags, or removes it when pin is false.
+// Only the actor itself can change its featured tags.
+func HandlePinTag(fb FedBOX, pin bool) http.HandlerFunc {
+	db, ok := fb.storage.(collectionStore)
+	if !ok {
+		return errors.HandleError(errors.NotImplementedf("featured tags are not supported by the storage")).ServeHTTP
+	}
+	return handlePinTag(db, fb.Config().Secure, pin, fb.actorFromRequest)
+}
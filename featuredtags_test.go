@@ -0,0 +1,92 @@
+package fedbox
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestFeaturedTags(t *testing.T) {
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	janeDoe := vocab.Actor{ID: "https://fedbox.local/actors/janedoe", Type: vocab.PersonType}
+	hashtag := func(name string) vocab.Item {
+		return &vocab.Link{Type: "Hashtag", Name: vocab.DefaultNaturalLanguageValue(name), Href: vocab.IRI("https://fedbox.local/tags/" + name[1:])}
+	}
+	note1 := &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType, Tag: vocab.ItemCollection{hashtag("#Go"), hashtag("#fediverse")}}
+	note2 := &vocab.Object{ID: "https://fedbox.local/objects/2", Type: vocab.NoteType, Tag: vocab.ItemCollection{hashtag("#go")}}
+	outbox := &vocab.OrderedCollection{
+		ID:   vocab.Outbox.IRI(johnDoe),
+		Type: vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{
+			&vocab.Activity{ID: "https://fedbox.local/activities/1", Type: vocab.CreateType, Actor: johnDoe.ID, Object: note1.ID},
+			&vocab.Activity{ID: "https://fedbox.local/activities/2", Type: vocab.CreateType, Actor: johnDoe.ID, Object: note2},
+		},
+	}
+	db := mockCollectionStore{mockStore: mockStore{johnDoe.ID: johnDoe, note1.ID: note1, outbox.ID: outbox}}
+	db.Create(&vocab.OrderedCollection{ID: johnDoe.ID.AddPath(featuredTagsPath), Type: vocab.OrderedCollectionType})
+
+	request := func(method, path string, by vocab.Actor, h func(vocab.Actor) http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Host = "fedbox.local"
+		w := httptest.NewRecorder()
+		h(by).ServeHTTP(w, r)
+		return w
+	}
+	pin := func(pin bool) func(vocab.Actor) http.HandlerFunc {
+		return func(by vocab.Actor) http.HandlerFunc {
+			return handlePinTag(db, true, pin, func(*http.Request) vocab.Actor { return by })
+		}
+	}
+	get := func(vocab.Actor) http.HandlerFunc { return handleFeaturedTags(db, true) }
+	loadTags := func() vocab.ItemCollection {
+		w := request(http.MethodGet, "/actors/johndoe/"+featuredTagsPath, vocab.Actor{}, get)
+		if w.Code != http.StatusOK {
+			t.Fatalf("featured tags returned status %d, expected %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+		body, _ := io.ReadAll(w.Body)
+		it, err := vocab.UnmarshalJSON(body)
+		if err != nil {
+			t.Fatalf("unable to unmarshal the featured tags: %s", err)
+		}
+		var tags vocab.ItemCollection
+		vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+			tags = c.Collection()
+			return nil
+		})
+		return tags
+	}
+
+	if w := request(http.MethodPut, "/actors/johndoe/"+featuredTagsPath+"/go", janeDoe, pin(true)); w.Code != http.StatusUnauthorized {
+		t.Errorf("pinning a tag for another actor returned status %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := request(http.MethodPut, "/actors/johndoe/"+featuredTagsPath+"/Go", *johnDoe, pin(true)); w.Code != http.StatusNoContent {
+		t.Fatalf("pinning a tag returned status %d, expected %d: %s", w.Code, http.StatusNoContent, w.Body.String())
+	}
+
+	tags := loadTags()
+	if len(tags) != 1 {
+		t.Fatalf("featured tags has %d items, expected 1", len(tags))
+	}
+	vocab.OnOrderedCollection(tags[0], func(c *vocab.OrderedCollection) error {
+		if c.TotalItems != 2 {
+			t.Errorf("featured tag has %d uses, expected 2", c.TotalItems)
+		}
+		return nil
+	})
+	vocab.OnObject(tags[0], func(o *vocab.Object) error {
+		if name := o.Name.First().String(); name != "#go" {
+			t.Errorf("featured tag is named %q, expected %q", name, "#go")
+		}
+		return nil
+	})
+
+	if w := request(http.MethodDelete, "/actors/johndoe/"+featuredTagsPath+"/go", *johnDoe, pin(false)); w.Code != http.StatusNoContent {
+		t.Fatalf("unpinning a tag returned status %d, expected %d", w.Code, http.StatusNoContent)
+	}
+	if tags = loadTags(); len(tags) != 0 {
+		t.Errorf("featured tags has %d items after unpinning, expected 0", len(tags))
+	}
+}
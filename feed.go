@@ -0,0 +1,179 @@
+package fedbox
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"golang.org/x/net/html"
+)
+
+const (
+	contentTypeRSS = "application/rss+xml"
+	// feedSuffix is the suffix of the outbox path that the feed readers, which can't set the Accept header,
+	// use for requesting the RSS feed
+	feedSuffix = ".rss"
+	// feedTitleLength is the maximum length of the titles which we make from the content of the notes
+	feedTitleLength = 80
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate,omitempty"`
+	Description string `xml:"description"`
+}
+
+// isOutboxPath checks if the p request path is the one of an outbox
+func isOutboxPath(p string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(p, "/"), "/"+string(vocab.Outbox))
+}
+
+// FeedSuffix serves the RSS feed of the outboxes to the requests for their path with the ".rss" suffix, like the
+// ones with the RSS Accept header.
+func FeedSuffix(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := strings.TrimSuffix(r.URL.Path, feedSuffix); p != r.URL.Path && isOutboxPath(p) {
+			r.URL.Path = p
+			r.URL.RawPath = ""
+			r.Header.Set("Accept", contentTypeRSS)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// plainText returns the text of the s HTML fragment, without the markup
+func plainText(s string) string {
+	t := html.NewTokenizer(strings.NewReader(s))
+	b := strings.Builder{}
+	for {
+		switch t.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.TextToken:
+			b.Write(t.Text())
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			b.WriteByte(' ')
+		}
+	}
+}
+
+// feedTitle returns the title of the feed entry for the ob object: its name, its summary, or the start of its content
+func feedTitle(ob *vocab.Object) string {
+	for _, s := range []vocab.NaturalLanguageValues{ob.Name, ob.Summary, ob.Content} {
+		title := plainText(s.First().Value.String())
+		if len(title) == 0 {
+			continue
+		}
+		if utf8.RuneCountInString(title) > feedTitleLength {
+			title = string([]rune(title)[:feedTitleLength-1]) + "…"
+		}
+		return title
+	}
+	return ob.ID.String()
+}
+
+// feedItem returns the feed entry for the it activity, which is only for the public Creates of the Notes
+func feedItem(it vocab.Item) (rssItem, bool) {
+	entry := rssItem{}
+	ok := false
+	vocab.OnActivity(it, func(a *vocab.Activity) error {
+		if a.GetType() != vocab.CreateType || !isPublic(a) || vocab.IsNil(a.Object) || a.Object.GetType() != vocab.NoteType {
+			return nil
+		}
+		return vocab.OnObject(a.Object, func(ob *vocab.Object) error {
+			entry.Title = feedTitle(ob)
+			entry.GUID = ob.ID.String()
+			entry.Link = ob.ID.String()
+			if !vocab.IsNil(ob.URL) {
+				entry.Link = ob.URL.GetLink().String()
+			}
+			published := ob.Published
+			if published.IsZero() {
+				published = a.Published
+			}
+			if !published.IsZero() {
+				entry.PubDate = published.UTC().Format(time.RFC1123Z)
+			}
+			entry.Description = ob.Content.First().Value.String()
+			ok = true
+			return nil
+		})
+	})
+	return entry, ok
+}
+
+// newFeed returns the RSS feed with the public notes created by the actor in its outbox col collection
+func newFeed(col vocab.Item) rssFeed {
+	owner, _ := vocab.Split(col.GetLink())
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       owner.String(),
+			Link:        owner.String(),
+			Description: "The public notes of " + owner.String(),
+			Items:       make([]rssItem, 0),
+		},
+	}
+	add := func(items vocab.ItemCollection) {
+		for _, it := range items {
+			if entry, ok := feedItem(it); ok {
+				feed.Channel.Items = append(feed.Channel.Items, entry)
+			}
+		}
+	}
+	vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+		if items := c.Collection(); len(items) > 0 {
+			add(items)
+			return nil
+		}
+		// NOTE(marius): when EmbedFirstPage is enabled the items are in the first page of the collection
+		if oc, ok := c.(*vocab.OrderedCollection); ok && !vocab.IsNil(oc.First) && !vocab.IsIRI(oc.First) {
+			vocab.OnCollectionIntf(oc.First, func(first vocab.CollectionInterface) error {
+				add(first.Collection())
+				return nil
+			})
+		}
+		return nil
+	})
+	return feed
+}
+
+// renderRSS writes the RSS feed for the outbox collection found in the data JSON document
+func renderRSS(w http.ResponseWriter, data []byte) error {
+	it, err := vocab.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	if vocab.IsNil(it) || !it.IsCollection() {
+		return errors.NotValidf("unable to render a feed for a non collection item")
+	}
+	raw, err := xml.Marshal(newFeed(it))
+	if err != nil {
+		return err
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", contentTypeRSS+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	_, err = w.Write(raw)
+	return err
+}
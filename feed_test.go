@@ -0,0 +1,108 @@
+package fedbox
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+)
+
+func TestOutboxFeed(t *testing.T) {
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	outbox := vocab.Outbox.IRI(johnDoe)
+	published := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	note := func(id, content string) *vocab.Object {
+		return &vocab.Object{
+			ID:        vocab.IRI("https://fedbox.local/objects/" + id),
+			Type:      vocab.NoteType,
+			Content:   vocab.NaturalLanguageValues{{Ref: vocab.NilLangRef, Value: vocab.Content(content)}},
+			Published: published,
+		}
+	}
+	create := func(id string, ob vocab.Item, to ...vocab.Item) *vocab.Activity {
+		return &vocab.Activity{
+			ID:     vocab.IRI("https://fedbox.local/activities/" + id),
+			Type:   vocab.CreateType,
+			Actor:  johnDoe,
+			To:     to,
+			Object: ob,
+		}
+	}
+	col := &vocab.OrderedCollection{
+		ID:   outbox,
+		Type: vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{
+			create("1", note("1", "<p>Hello <b>world</b></p>"), vocab.PublicNS),
+			create("2", note("2", "second public note"), vocab.PublicNS),
+			create("3", note("3", "followers only"), vocab.Followers.IRI(johnDoe)),
+			create("4", &vocab.Object{ID: "https://fedbox.local/objects/4", Type: vocab.ArticleType}, vocab.PublicNS),
+			&vocab.Activity{ID: "https://fedbox.local/activities/5", Type: vocab.LikeType, To: vocab.ItemCollection{vocab.PublicNS}, Object: note("5", "liked")},
+		},
+	}
+	data, _ := vocab.MarshalJSON(col)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/actors/johndoe/outbox" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	})
+	h := FeedSuffix(contentNegotiation("")(next))
+
+	feedRequest := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if len(accept) > 0 {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	for _, req := range []struct{ path, accept string }{
+		{"/actors/johndoe/outbox", contentTypeRSS},
+		{"/actors/johndoe/outbox.rss", ""},
+	} {
+		w := feedRequest(req.path, req.accept)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s with Accept %q returned status %d, expected %d", req.path, req.accept, w.Code, http.StatusOK)
+		}
+		if typ := w.Header().Get("Content-Type"); !strings.HasPrefix(typ, contentTypeRSS) {
+			t.Errorf("GET %s returned Content-Type %q, expected %q", req.path, typ, contentTypeRSS)
+		}
+		feed := rssFeed{}
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("GET %s returned an invalid RSS document: %s\n%s", req.path, err, w.Body.String())
+		}
+		if feed.Version != "2.0" || feed.Channel.Link != johnDoe.String() {
+			t.Errorf("invalid RSS channel %+v", feed.Channel)
+		}
+		if len(feed.Channel.Items) != 2 {
+			t.Fatalf("the feed has %d items, expected the 2 public notes: %+v", len(feed.Channel.Items), feed.Channel.Items)
+		}
+		first := feed.Channel.Items[0]
+		if first.Title != "Hello world" {
+			t.Errorf("the title of the feed entry is %q, expected %q", first.Title, "Hello world")
+		}
+		if first.Link != "https://fedbox.local/objects/1" {
+			t.Errorf("the link of the feed entry is %q, expected %q", first.Link, "https://fedbox.local/objects/1")
+		}
+		if pub, err := time.Parse(time.RFC1123Z, first.PubDate); err != nil || !pub.Equal(published) {
+			t.Errorf("the pubDate of the feed entry is %q, expected %s", first.PubDate, published.Format(time.RFC1123Z))
+		}
+	}
+
+	if w := feedRequest("/actors/johndoe/outbox", ""); w.Body.String() != string(data) {
+		t.Errorf("the outbox without the RSS Accept header should be the JSON-LD document")
+	}
+	if w := feedRequest("/actors/johndoe/inbox.rss", ""); w.Code != http.StatusNotFound {
+		t.Errorf("the inbox shouldn't have a feed, got status %d", w.Code)
+	}
+}
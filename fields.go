@@ -0,0 +1,94 @@
+package fedbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+const fieldsKey = "fields"
+
+// alwaysSelectedFields are the properties that get serialized regardless of the requested field selection
+var alwaysSelectedFields = []string{"@context", "id"}
+
+// parseFields returns the list of the properties requested in the comma separated fields query parameter
+func parseFields(s string) []string {
+	fields := make([]string, 0)
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); len(f) > 0 {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// selectFields removes from the data JSON representation of an actor all the top level properties which are not
+// part of the fields list. The "id" and "@context" properties are always kept.
+// If data does not represent an actor it is returned unchanged.
+func selectFields(data []byte, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+	props := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &props); err != nil {
+		return nil, err
+	}
+	var typ vocab.ActivityVocabularyType
+	if t, ok := props["type"]; ok {
+		json.Unmarshal(t, &typ)
+	}
+	if !vocab.ActorTypes.Contains(typ) {
+		return data, nil
+	}
+
+	selected := make(map[string]json.RawMessage)
+	for _, f := range append(fields, alwaysSelectedFields...) {
+		if v, ok := props[f]; ok {
+			selected[f] = v
+		}
+	}
+	return json.Marshal(selected)
+}
+
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// FieldSelection is a middleware which allows the clients to request partial actor representations, by using
+// the fields query parameter with a comma separated list of properties: ?fields=id,preferredUsername,inbox
+func FieldSelection(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := parseFields(r.URL.Query().Get(fieldsKey))
+		if r.Method != http.MethodGet || len(fields) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(&bw, r)
+
+		data := bw.buf.Bytes()
+		if bw.status == http.StatusOK {
+			if sel, err := selectFields(data, fields); err == nil {
+				data = sel
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(bw.status)
+		w.Write(data)
+	})
+}
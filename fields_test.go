@@ -0,0 +1,97 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestFieldSelection(t *testing.T) {
+	actor := vocab.Actor{
+		ID:                "https://fedbox.local/actors/johndoe",
+		Type:              vocab.PersonType,
+		PreferredUsername: vocab.DefaultNaturalLanguageValue("johndoe"),
+		Summary:           vocab.DefaultNaturalLanguageValue("Generic actor"),
+		Inbox:             vocab.Inbox.IRI(vocab.IRI("https://fedbox.local/actors/johndoe")),
+		Outbox:            vocab.Outbox.IRI(vocab.IRI("https://fedbox.local/actors/johndoe")),
+	}
+	note := vocab.Object{
+		ID:      "https://fedbox.local/objects/note",
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguageValue("Hello"),
+	}
+
+	serve := func(it vocab.Item) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := vocab.MarshalJSON(it)
+			w.Header().Set("Content-Type", "application/activity+json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
+		})
+	}
+
+	tests := []struct {
+		name string
+		it   vocab.Item
+		url  string
+		want []string
+	}{
+		{
+			name: "actor subset",
+			it:   actor,
+			url:  "/actors/johndoe?fields=preferredUsername,inbox",
+			want: []string{"id", "preferredUsername", "inbox"},
+		},
+		{
+			name: "id is always present",
+			it:   actor,
+			url:  "/actors/johndoe?fields=summary",
+			want: []string{"id", "summary"},
+		},
+		{
+			name: "no selection",
+			it:   actor,
+			url:  "/actors/johndoe",
+			want: []string{"id", "type", "preferredUsername", "summary", "inbox", "outbox"},
+		},
+		{
+			name: "not an actor",
+			it:   note,
+			url:  "/objects/note?fields=id",
+			want: []string{"id", "type", "content"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			FieldSelection(serve(tt.it)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("FieldSelection() returned status %d, expected %d", w.Code, http.StatusOK)
+			}
+			props := make(map[string]json.RawMessage)
+			if err := json.Unmarshal(w.Body.Bytes(), &props); err != nil {
+				t.Fatalf("unable to decode the response body: %s", err)
+			}
+			delete(props, "@context")
+			if len(props) != len(tt.want) {
+				t.Errorf("FieldSelection() returned properties %v, expected %v", keys(props), tt.want)
+			}
+			for _, f := range tt.want {
+				if _, ok := props[f]; !ok {
+					t.Errorf("FieldSelection() response is missing property %q", f)
+				}
+			}
+		})
+	}
+}
+
+func keys(m map[string]json.RawMessage) []string {
+	k := make([]string, 0, len(m))
+	for key := range m {
+		k = append(k, key)
+	}
+	return k
+}
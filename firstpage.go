@@ -0,0 +1,51 @@
+package fedbox
+
+import (
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/filters"
+)
+
+// embedFirstPage replaces the link to the first page of the col top level collection with the page itself,
+// built from the full collection the same way as when it's requested separately using its IRI.
+// The items of the top level collection are removed, as they're part of the embedded page.
+func embedFirstPage(col vocab.CollectionInterface, full vocab.OrderedCollection, r *http.Request, baseURL string, act vocab.Actor) (vocab.CollectionInterface, error) {
+	if col.GetType() != vocab.OrderedCollectionType {
+		// NOTE(marius): the requests for collection pages return the page, we embed only in the top level collection
+		return col, nil
+	}
+	oc, err := vocab.ToOrderedCollection(col)
+	if err != nil || vocab.IsNil(oc.First) {
+		return col, nil
+	}
+	u, err := oc.First.GetLink().URL()
+	if err != nil {
+		return col, nil
+	}
+	fr := r.Clone(r.Context())
+	fr.URL.RawQuery = u.RawQuery
+
+	f := filters.FromRequest(fr, baseURL)
+	filters.LoadCollectionFilters(f, act)
+	page, err := ap.PaginateCollection(&full, f)
+	if err != nil {
+		return nil, err
+	}
+	oc.First = page
+	oc.OrderedItems = nil
+	return oc, nil
+}
+
+// collectionItems returns the items of col, including the ones of its embedded first page
+func collectionItems(col vocab.CollectionInterface) vocab.ItemCollection {
+	items := col.Collection()
+	if oc, ok := col.(*vocab.OrderedCollection); ok && !vocab.IsNil(oc.First) && !oc.First.IsLink() {
+		vocab.OnCollectionIntf(oc.First, func(page vocab.CollectionInterface) error {
+			items = append(items, page.Collection()...)
+			return nil
+		})
+	}
+	return items
+}
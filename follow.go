@@ -0,0 +1,92 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+)
+
+// collectionContains checks if the collection identified by the col IRI contains the it item
+func collectionContains(db processing.ReadStore, col vocab.IRI, it vocab.Item) bool {
+	// NOTE(marius): the storage backends supporting it check the membership without loading all the items
+	if found, err := st.CollectionContains(db, col, it.GetLink()); !errors.IsNotImplemented(err) {
+		return err == nil && found
+	}
+	loaded, err := db.Load(col)
+	if err != nil || vocab.IsNil(loaded) {
+		return false
+	}
+	found := false
+	vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+		found = c.Contains(it.GetLink())
+		return nil
+	})
+	return found
+}
+
+// loadFollow returns the Follow activity that the it item represents, loading it from storage when needed
+func loadFollow(db processing.ReadStore, it vocab.Item) (*vocab.Activity, error) {
+	if vocab.IsNil(it) {
+		return nil, errors.NotValidf("nil Follow")
+	}
+	if vocab.IsIRI(it) {
+		var err error
+		if it, err = db.Load(it.GetLink()); err != nil {
+			return nil, err
+		}
+	}
+	var follow *vocab.Activity
+	err := vocab.OnActivity(it, func(a *vocab.Activity) error {
+		if a.GetType() != vocab.FollowType {
+			return errors.NotValidf("%s is not a Follow activity", a.GetLink())
+		}
+		follow = a
+		return nil
+	})
+	return follow, err
+}
+
+// rejectAcceptedFollow handles a Reject activity for a Follow that had been previously accepted, which is
+// how the target of the Follow can remove an existing follower. The follower gets removed from the target's
+// followers collection, and the target from the follower's following collection.
+//
+// For a Reject of a pending Follow the follower relationship doesn't exist yet, so the collections are
+// left unchanged. The function returns the IRIs of the collections that have been modified.
+func rejectAcceptedFollow(db collectionStore, reject *vocab.Activity) (vocab.IRIs, error) {
+	if reject == nil || reject.GetType() != vocab.RejectType {
+		return nil, nil
+	}
+	follow, err := loadFollow(db, reject.Object)
+	if err != nil {
+		return nil, err
+	}
+	if vocab.IsNil(follow.Actor) || vocab.IsNil(follow.Object) {
+		return nil, errors.NotValidf("invalid Follow %s", follow.GetLink())
+	}
+	follower := follow.Actor.GetLink()
+	followed := follow.Object.GetLink()
+	if !followed.Equals(reject.Actor.GetLink(), false) {
+		return nil, errors.Forbiddenf("only %s can reject the Follow %s", followed, follow.GetLink())
+	}
+
+	followers := vocab.Followers.IRI(followed)
+	if !collectionContains(db, followers, follower) {
+		// NOTE: this is the Reject of a pending Follow
+		return nil, nil
+	}
+	modified := make(vocab.IRIs, 0, 2)
+	if err = db.RemoveFrom(followers, follower); err != nil {
+		return modified, errors.Annotatef(err, "unable to remove %s from %s", follower, followers)
+	}
+	modified = append(modified, followers)
+
+	following := vocab.Following.IRI(follower)
+	if collectionContains(db, following, followed) {
+		if err = db.RemoveFrom(following, followed); err != nil {
+			return modified, errors.Annotatef(err, "unable to remove %s from %s", followed, following)
+		}
+		modified = append(modified, following)
+	}
+	return modified, nil
+}
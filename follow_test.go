@@ -0,0 +1,178 @@
+package fedbox
+
+import (
+	"fmt"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+)
+
+type mockCollectionStore struct {
+	mockStore
+}
+
+func (m mockCollectionStore) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	m.mockStore[col.GetLink()] = col
+	return col, nil
+}
+
+func (m mockCollectionStore) AddTo(col vocab.IRI, it vocab.Item) error {
+	c, ok := m.mockStore[col].(*vocab.OrderedCollection)
+	if !ok {
+		c = &vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType}
+		m.mockStore[col] = c
+	}
+	return c.Append(it.GetLink())
+}
+
+func (m mockCollectionStore) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	c, ok := m.mockStore[col].(*vocab.OrderedCollection)
+	if !ok {
+		return errors.NotFoundf("%s not found", col)
+	}
+	c.OrderedItems.Remove(it.GetLink())
+	c.TotalItems = uint(len(c.OrderedItems))
+	return nil
+}
+
+func TestRejectAcceptedFollow(t *testing.T) {
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	janeDoe := vocab.IRI("https://fedbox.local/actors/janedoe")
+	follow := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/follow",
+		Type:   vocab.FollowType,
+		Actor:  johnDoe,
+		Object: janeDoe,
+	}
+	reject := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/reject",
+		Type:   vocab.RejectType,
+		Actor:  janeDoe,
+		Object: follow.ID,
+	}
+	followers := vocab.Followers.IRI(janeDoe)
+	following := vocab.Following.IRI(johnDoe)
+
+	t.Run("pending Follow", func(t *testing.T) {
+		db := mockCollectionStore{mockStore{follow.ID: follow}}
+		db.Create(&vocab.OrderedCollection{ID: followers, Type: vocab.OrderedCollectionType})
+		db.Create(&vocab.OrderedCollection{ID: following, Type: vocab.OrderedCollectionType})
+
+		modified, err := rejectAcceptedFollow(db, reject)
+		if err != nil {
+			t.Fatalf("rejectAcceptedFollow() returned error %s", err)
+		}
+		if len(modified) > 0 {
+			t.Errorf("rejectAcceptedFollow() for a pending Follow should not modify collections, modified %v", modified)
+		}
+		if collectionContains(db, followers, johnDoe) || collectionContains(db, following, janeDoe) {
+			t.Errorf("the follower relationship should not exist for a pending Follow")
+		}
+	})
+	t.Run("accepted Follow", func(t *testing.T) {
+		db := mockCollectionStore{mockStore{follow.ID: follow}}
+		db.AddTo(followers, johnDoe)
+		db.AddTo(following, janeDoe)
+
+		modified, err := rejectAcceptedFollow(db, reject)
+		if err != nil {
+			t.Fatalf("rejectAcceptedFollow() returned error %s", err)
+		}
+		if len(modified) != 2 || !modified.Contains(followers) || !modified.Contains(following) {
+			t.Errorf("rejectAcceptedFollow() modified %v, expected %s and %s", modified, followers, following)
+		}
+		if collectionContains(db, followers, johnDoe) {
+			t.Errorf("%s should have been removed from %s", johnDoe, followers)
+		}
+		if collectionContains(db, following, janeDoe) {
+			t.Errorf("%s should have been removed from %s", janeDoe, following)
+		}
+	})
+	t.Run("Reject by a different actor", func(t *testing.T) {
+		db := mockCollectionStore{mockStore{follow.ID: follow}}
+		db.AddTo(followers, johnDoe)
+
+		r := *reject
+		r.Actor = vocab.IRI("https://fedbox.local/actors/mallory")
+		if _, err := rejectAcceptedFollow(db, &r); !errors.IsForbidden(err) {
+			t.Errorf("rejectAcceptedFollow() should have returned a forbidden error, received %v", err)
+		}
+		if !collectionContains(db, followers, johnDoe) {
+			t.Errorf("%s should not have been removed from %s", johnDoe, followers)
+		}
+	})
+}
+
+// mockMembershipStore checks the membership without loading the collections, which fails the test
+type mockMembershipStore struct {
+	mockCollectionStore
+	t       *testing.T
+	members map[vocab.IRI]vocab.IRIs
+}
+
+func (m mockMembershipStore) Load(iri vocab.IRI) (vocab.Item, error) {
+	if _, ok := m.members[iri]; ok {
+		m.t.Errorf("the %s collection should not be loaded for checking its members", iri)
+	}
+	return m.mockCollectionStore.Load(iri)
+}
+
+func (m mockMembershipStore) CollectionContains(col vocab.IRI, member vocab.IRI) (bool, error) {
+	return m.members[col].Contains(member), nil
+}
+
+// mockFullMembershipStore is a mockMembershipStore usable in place of the storage of the instance
+type mockFullMembershipStore struct {
+	FullStorage
+	mockMembershipStore
+}
+
+func (m mockFullMembershipStore) Load(iri vocab.IRI) (vocab.Item, error) {
+	return m.mockMembershipStore.Load(iri)
+}
+
+func (m mockFullMembershipStore) Save(it vocab.Item) (vocab.Item, error) {
+	return m.mockMembershipStore.Save(it)
+}
+
+func (m mockFullMembershipStore) Delete(it vocab.Item) error {
+	return m.mockMembershipStore.Delete(it)
+}
+
+func TestCollectionContains(t *testing.T) {
+	followers := vocab.IRI("https://fedbox.local/actors/johndoe/followers")
+	col := &vocab.OrderedCollection{ID: followers, Type: vocab.OrderedCollectionType}
+	members := make(vocab.IRIs, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		member := vocab.IRI(fmt.Sprintf("https://example.com/actors/%d", i))
+		members = append(members, member)
+		col.OrderedItems = append(col.OrderedItems, member)
+	}
+	present := vocab.IRI("https://example.com/actors/9999")
+	absent := vocab.IRI("https://example.com/actors/10000")
+
+	check := func(t *testing.T, db processing.ReadStore) {
+		if !collectionContains(db, followers, present) {
+			t.Errorf("%s should be in %s", present, followers)
+		}
+		if collectionContains(db, followers, absent) {
+			t.Errorf("%s should not be in %s", absent, followers)
+		}
+	}
+	t.Run("loading the items", func(t *testing.T) {
+		check(t, mockCollectionStore{mockStore{followers: col}})
+	})
+	t.Run("membership check", func(t *testing.T) {
+		db := mockMembershipStore{
+			mockCollectionStore: mockCollectionStore{mockStore{followers: col}},
+			t:                   t,
+			members:             map[vocab.IRI]vocab.IRIs{followers: members},
+		}
+		check(t, db)
+		check(t, st.Serialize(db, st.NewCollectionLocks()))
+		check(t, withStreams(mockFullMembershipStore{mockMembershipStore: db}, newStreamHub()))
+	})
+}
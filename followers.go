@@ -0,0 +1,218 @@
+package fedbox
+
+import (
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// activityDeliverer is the client functionality needed for delivering activities to remote inboxes
+type activityDeliverer interface {
+	iriLoader
+	ToCollection(vocab.IRI, vocab.Item) (vocab.IRI, vocab.Item, error)
+}
+
+// remoteFollowersCollections returns the followers collections of remote actors that the a activity is addressed to
+func remoteFollowersCollections(a *vocab.Activity, base vocab.IRI) vocab.IRIs {
+	cols := make(vocab.IRIs, 0)
+	for _, rec := range a.Recipients() {
+		if vocab.IsNil(rec) {
+			continue
+		}
+		iri := rec.GetLink()
+		if iri.Contains(base, false) || iri.Equals(vocab.PublicNS, false) {
+			continue
+		}
+		if _, typ := vocab.Split(iri); typ != vocab.Followers {
+			continue
+		}
+		if !cols.Contains(iri) {
+			cols = append(cols, iri)
+		}
+	}
+	return cols
+}
+
+// followersInbox resolves the inbox where an activity addressed to the col remote followers collection gets delivered,
+// which is the shared inbox of the collection's owner when it has one, or its inbox. The owner's server takes
+// care of distributing the activity to the followers.
+func followersInbox(cl iriLoader, col vocab.IRI) (vocab.IRI, error) {
+	owner, _ := vocab.Split(col)
+	it, err := cl.LoadIRI(owner)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to load the owner of %s", col)
+	}
+	var inbox vocab.IRI
+	err = vocab.OnActor(it, func(act *vocab.Actor) error {
+		if vocab.IsNil(act.Followers) || !act.Followers.GetLink().Equals(col, false) {
+			return errors.NotValidf("%s is not the followers collection of %s", col, act.GetLink())
+		}
+		if act.Endpoints != nil && !vocab.IsNil(act.Endpoints.SharedInbox) {
+			inbox = act.Endpoints.SharedInbox.GetLink()
+		} else if !vocab.IsNil(act.Inbox) {
+			inbox = act.Inbox.GetLink()
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if inbox == "" {
+		return "", errors.NotFoundf("no inbox found for the owner of %s", col)
+	}
+	return inbox, nil
+}
+
+// deliverToRemoteFollowers delivers the a activity to the remote followers collections it's addressed to,
+// by posting it once to each of the inboxes resolved for them.
+// It returns the inboxes it has been delivered to.
+func deliverToRemoteFollowers(cl activityDeliverer, base vocab.IRI, a *vocab.Activity) (vocab.IRIs, error) {
+	inboxes := make(vocab.IRIs, 0)
+	var errs []error
+	for _, col := range remoteFollowersCollections(a, base) {
+		inbox, err := followersInbox(cl, col)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if inboxes.Contains(inbox) {
+			continue
+		}
+		if _, _, err = cl.ToCollection(inbox, a); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to deliver %s to %s", a.GetLink(), inbox))
+			continue
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	if len(errs) > 0 {
+		return inboxes, errors.Annotatef(errs[0], "%d of the deliveries to remote followers failed", len(errs))
+	}
+	return inboxes, nil
+}
+
+// localFollowersCollections returns the followers collections of the local actors of the base service that
+// the a activity is addressed to
+func localFollowersCollections(a *vocab.Activity, base vocab.IRI) vocab.IRIs {
+	cols := make(vocab.IRIs, 0)
+	for _, rec := range a.Recipients() {
+		if vocab.IsNil(rec) {
+			continue
+		}
+		iri := rec.GetLink()
+		if !iri.Contains(base, false) {
+			continue
+		}
+		if _, typ := vocab.Split(iri); typ != vocab.Followers {
+			continue
+		}
+		if !cols.Contains(iri) {
+			cols = append(cols, iri)
+		}
+	}
+	return cols
+}
+
+// loadFollower returns the follower actor, from storage when we have it, or from its server
+func loadFollower(db processing.ReadStore, cl iriLoader, follower vocab.Item) (vocab.Item, error) {
+	if !vocab.IsIRI(follower) {
+		return follower, nil
+	}
+	iri := follower.GetLink()
+	if it, err := db.Load(iri); err == nil {
+		if it = firstItem(it); !vocab.IsNil(it) && it.GetLink().Equals(iri, false) {
+			return it, nil
+		}
+	}
+	return cl.LoadIRI(iri)
+}
+
+// followersInboxes expands the col followers collection of a local actor of the base service into the inboxes
+// of its members. The inboxes of the local followers are returned separately from the ones of the remote
+// followers, for which we prefer the shared inboxes.
+func followersInboxes(db processing.ReadStore, cl iriLoader, base vocab.IRI, col vocab.IRI) (vocab.IRIs, vocab.IRIs, error) {
+	local := make(vocab.IRIs, 0)
+	remote := make(vocab.IRIs, 0)
+	loaded, err := db.Load(col)
+	if err != nil {
+		return local, remote, errors.Annotatef(err, "unable to load %s", col)
+	}
+	var errs []error
+	err = vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+		for _, follower := range c.Collection() {
+			if vocab.IsNil(follower) {
+				continue
+			}
+			if follower.GetLink().Contains(base, false) {
+				if inbox := vocab.Inbox.IRI(follower); !local.Contains(inbox) {
+					local = append(local, inbox)
+				}
+				continue
+			}
+			it, err := loadFollower(db, cl, follower)
+			if err != nil {
+				errs = append(errs, errors.Annotatef(err, "unable to load the follower %s", follower.GetLink()))
+				continue
+			}
+			inbox := inboxOf(it)
+			if inbox == "" {
+				errs = append(errs, errors.NotFoundf("no inbox found for the follower %s", follower.GetLink()))
+				continue
+			}
+			if !remote.Contains(inbox) {
+				remote = append(remote, inbox)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return local, remote, err
+	}
+	if len(errs) > 0 {
+		return local, remote, errors.Annotatef(errs[0], "%d of the followers of %s can't be resolved", len(errs), col)
+	}
+	return local, remote, nil
+}
+
+// deliverToFollowers delivers the a activity to the members of the followers collections of the local actors
+// of the base service that it's addressed to: it gets added to the inboxes of the local followers, and posted
+// to the inboxes of the remote ones, queuing it for retrying when that fails.
+// It returns the local inboxes that have been modified.
+//
+// NOTE(marius): we skip the local inboxes that already contain the activity, in case the processing of the
+// activity has delivered it already.
+func deliverToFollowers(db collectionStore, q *deliveryQueue, base vocab.IRI, a *vocab.Activity, now time.Time) (vocab.IRIs, error) {
+	modified := make(vocab.IRIs, 0)
+	delivered := make(vocab.IRIs, 0)
+	var errs []error
+	for _, col := range localFollowersCollections(a, base) {
+		local, remote, err := followersInboxes(db, q.cl, base, col)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, inbox := range local {
+			if modified.Contains(inbox) || collectionContains(db, inbox, a) {
+				continue
+			}
+			if err := db.AddTo(inbox, a.GetLink()); err != nil {
+				errs = append(errs, errors.Annotatef(err, "unable to add %s to %s", a.GetLink(), inbox))
+				continue
+			}
+			modified = append(modified, inbox)
+		}
+		for _, inbox := range remote {
+			if delivered.Contains(inbox) {
+				continue
+			}
+			delivered = append(delivered, inbox)
+			if err := q.deliver(inbox, a, now); err != nil {
+				errs = append(errs, errors.Annotatef(err, "unable to deliver %s to %s", a.GetLink(), inbox))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return modified, errors.Annotatef(errs[0], "%d of the deliveries to followers failed", len(errs))
+	}
+	return modified, nil
+}
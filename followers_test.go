@@ -0,0 +1,186 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+type mockDeliverer struct {
+	mockLoader
+	delivered map[vocab.IRI]vocab.IRIs
+}
+
+func (m *mockDeliverer) ToCollection(inbox vocab.IRI, it vocab.Item) (vocab.IRI, vocab.Item, error) {
+	if inbox.Contains("https://down.example.com", false) {
+		return "", nil, errors.Newf("unable to connect to %s", inbox)
+	}
+	m.delivered[inbox] = append(m.delivered[inbox], it.GetLink())
+	return it.GetLink(), it, nil
+}
+
+func TestDeliverToRemoteFollowers(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+
+	bob := &vocab.Actor{
+		ID:        "https://example.com/users/bob",
+		Type:      vocab.PersonType,
+		Inbox:     vocab.IRI("https://example.com/users/bob/inbox"),
+		Followers: vocab.IRI("https://example.com/users/bob/followers"),
+		Endpoints: &vocab.Endpoints{SharedInbox: vocab.IRI("https://example.com/inbox")},
+	}
+	alice := &vocab.Actor{
+		ID:        "https://example.com/users/alice",
+		Type:      vocab.PersonType,
+		Inbox:     vocab.IRI("https://example.com/users/alice/inbox"),
+		Followers: vocab.IRI("https://example.com/users/alice/followers"),
+		Endpoints: &vocab.Endpoints{SharedInbox: vocab.IRI("https://example.com/inbox")},
+	}
+	carol := &vocab.Actor{
+		ID:        "https://social.example.org/carol",
+		Type:      vocab.PersonType,
+		Inbox:     vocab.IRI("https://social.example.org/carol/inbox"),
+		Followers: vocab.IRI("https://social.example.org/carol/followers"),
+	}
+	local := vocab.IRI("https://fedbox.local/actors/johndoe/followers")
+
+	cl := &mockDeliverer{
+		mockLoader: mockLoader{bob.ID: bob, alice.ID: alice, carol.ID: carol},
+		delivered:  make(map[vocab.IRI]vocab.IRIs),
+	}
+	create := &vocab.Activity{
+		ID:    "https://fedbox.local/activities/1",
+		Type:  vocab.CreateType,
+		Actor: vocab.IRI("https://fedbox.local/actors/johndoe"),
+		To:    vocab.ItemCollection{vocab.PublicNS, bob.Followers, carol.Followers},
+		CC:    vocab.ItemCollection{local, alice.Followers, bob.ID},
+	}
+
+	inboxes, err := deliverToRemoteFollowers(cl, base, create)
+	if err != nil {
+		t.Fatalf("deliverToRemoteFollowers() returned error %s", err)
+	}
+	// NOTE(marius): bob and alice share the same inbox, which receives the activity only once
+	want := vocab.IRIs{"https://example.com/inbox", carol.Inbox.GetLink()}
+	if len(inboxes) != len(want) {
+		t.Fatalf("deliverToRemoteFollowers() delivered to %v, expected %v", inboxes, want)
+	}
+	for _, inbox := range want {
+		if !inboxes.Contains(inbox) {
+			t.Errorf("deliverToRemoteFollowers() delivered to %v, missing %s", inboxes, inbox)
+		}
+		if got := cl.delivered[inbox]; len(got) != 1 || !got.Contains(create.ID) {
+			t.Errorf("%s received %v, expected %s once", inbox, got, create.ID)
+		}
+	}
+	if _, ok := cl.delivered[local]; ok {
+		t.Errorf("the local followers collection shouldn't be delivered by the client")
+	}
+
+	t.Run("unresolvable followers", func(t *testing.T) {
+		mallory := vocab.IRI("https://evil.example.com/users/mallory/followers")
+		down := &vocab.Actor{
+			ID:        "https://down.example.com/users/dave",
+			Type:      vocab.PersonType,
+			Inbox:     vocab.IRI("https://down.example.com/users/dave/inbox"),
+			Followers: vocab.IRI("https://down.example.com/users/dave/followers"),
+		}
+		cl.mockLoader[down.ID] = down
+		a := &vocab.Activity{ID: "https://fedbox.local/activities/2", Type: vocab.CreateType, To: vocab.ItemCollection{mallory, down.Followers, carol.Followers}}
+		inboxes, err := deliverToRemoteFollowers(cl, base, a)
+		if err == nil {
+			t.Errorf("deliverToRemoteFollowers() expected error for unresolvable followers")
+		}
+		if len(inboxes) != 1 || !inboxes.Contains(carol.Inbox.GetLink()) {
+			t.Errorf("deliverToRemoteFollowers() delivered to %v, expected only %s", inboxes, carol.Inbox.GetLink())
+		}
+	})
+}
+
+func TestDeliverToFollowers(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	now := time.Now().UTC()
+
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	janeDoe := &vocab.Actor{ID: "https://fedbox.local/actors/janedoe", Type: vocab.PersonType}
+	bob := &vocab.Actor{
+		ID:        "https://example.com/users/bob",
+		Type:      vocab.PersonType,
+		Inbox:     vocab.IRI("https://example.com/users/bob/inbox"),
+		Endpoints: &vocab.Endpoints{SharedInbox: vocab.IRI("https://example.com/inbox")},
+	}
+	alice := &vocab.Actor{
+		ID:        "https://example.com/users/alice",
+		Type:      vocab.PersonType,
+		Inbox:     vocab.IRI("https://example.com/users/alice/inbox"),
+		Endpoints: &vocab.Endpoints{SharedInbox: vocab.IRI("https://example.com/inbox")},
+	}
+	carol := &vocab.Actor{
+		ID:    "https://social.example.org/carol",
+		Type:  vocab.PersonType,
+		Inbox: vocab.IRI("https://social.example.org/carol/inbox"),
+	}
+	dave := &vocab.Actor{
+		ID:    "https://down.example.com/users/dave",
+		Type:  vocab.PersonType,
+		Inbox: vocab.IRI("https://down.example.com/users/dave/inbox"),
+	}
+	followers := vocab.Followers.IRI(johnDoe)
+	janeInbox := vocab.Inbox.IRI(janeDoe)
+
+	create := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/1",
+		Type:   vocab.CreateType,
+		Actor:  johnDoe.ID,
+		Object: vocab.IRI("https://fedbox.local/objects/1"),
+		To:     vocab.ItemCollection{vocab.PublicNS},
+		CC:     vocab.ItemCollection{followers},
+	}
+
+	// NOTE(marius): bob is stored locally, while alice, carol and dave are loaded from their servers
+	db := mockCollectionStore{mockStore{johnDoe.ID: johnDoe, janeDoe.ID: janeDoe, bob.ID: bob, create.ID: create}}
+	db.Create(&vocab.OrderedCollection{ID: janeInbox, Type: vocab.OrderedCollectionType})
+	for _, follower := range []vocab.Item{janeDoe.ID, bob.ID, alice.ID, carol.ID, dave.ID} {
+		db.AddTo(followers, follower)
+	}
+	cl := &mockDeliverer{
+		mockLoader: mockLoader{alice.ID: alice, carol.ID: carol, dave.ID: dave},
+		delivered:  make(map[vocab.IRI]vocab.IRIs),
+	}
+	q := newDeliveryQueue(cl, 3, time.Minute, false)
+
+	modified, err := deliverToFollowers(db, q, base, create, now)
+	if err == nil {
+		t.Errorf("deliverToFollowers() expected error for the unreachable follower %s", dave.ID)
+	}
+	if len(modified) != 1 || !modified.Contains(janeInbox) {
+		t.Errorf("deliverToFollowers() modified %v, expected %s", modified, janeInbox)
+	}
+	if !collectionContains(db, janeInbox, create) {
+		t.Errorf("%s should have been added to the local follower's inbox %s", create.ID, janeInbox)
+	}
+	// NOTE(marius): bob and alice share the same inbox, which receives the activity only once
+	for _, inbox := range []vocab.IRI{"https://example.com/inbox", carol.Inbox.GetLink()} {
+		if got := cl.delivered[inbox]; len(got) != 1 || !got.Contains(create.ID) {
+			t.Errorf("%s received %v, expected %s once", inbox, got, create.ID)
+		}
+	}
+	if _, ok := cl.delivered[janeInbox]; ok {
+		t.Errorf("the local follower's inbox shouldn't be delivered by the client")
+	}
+	if q.len() != 1 {
+		t.Errorf("the failed delivery to %s should have been queued, %d pending", dave.Inbox.GetLink(), q.len())
+	}
+
+	modified, _ = deliverToFollowers(db, q, base, create, now)
+	if len(modified) != 0 {
+		t.Errorf("deliverToFollowers() should skip the local inboxes which already contain %s, modified %v", create.ID, modified)
+	}
+
+	private := &vocab.Activity{ID: "https://fedbox.local/activities/2", Type: vocab.CreateType, Actor: johnDoe.ID, To: vocab.ItemCollection{janeDoe.ID}}
+	if modified, err = deliverToFollowers(db, q, base, private, now); err != nil || len(modified) != 0 {
+		t.Errorf("deliverToFollowers() for an activity not addressed to the followers modified %v: %v", modified, err)
+	}
+}
@@ -0,0 +1,249 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	followRequestsPath = "follow-requests"
+	lockedActorsPath   = "locked"
+	followKey          = "follow"
+)
+
+// followRequests returns the IRI of the collection with the Follow requests waiting for the actor's approval
+func followRequests(actor vocab.IRI) vocab.IRI {
+	return actor.AddPath(followRequestsPath)
+}
+
+// lockedActors returns the IRI of the collection of the local actors which approve their followers manually.
+//
+// NOTE(marius): the vocab package doesn't have the manuallyApprovesFollowers property, so we keep the actors
+// which set it in a collection of the self service.
+func lockedActors(self vocab.Item) vocab.IRI {
+	return self.GetLink().AddPath(lockedActorsPath)
+}
+
+// isLocked checks if the actor approves its followers manually
+func isLocked(db processing.ReadStore, self vocab.Item, actor vocab.IRI) bool {
+	return collectionContains(db, lockedActors(self), actor)
+}
+
+// manuallyApprovesFollowers returns the actor updated by the raw Update activity, and the value of its
+// manuallyApprovesFollowers property. The last value is false when the Update doesn't change the property.
+func manuallyApprovesFollowers(raw []byte) (vocab.IRI, bool, bool) {
+	update := struct {
+		Type   vocab.ActivityVocabularyType `json:"type"`
+		Object struct {
+			ID     vocab.IRI `json:"id"`
+			Locked *bool     `json:"manuallyApprovesFollowers"`
+		} `json:"object"`
+	}{}
+	if err := json.Unmarshal(raw, &update); err != nil || update.Type != vocab.UpdateType {
+		return "", false, false
+	}
+	if update.Object.Locked == nil || len(update.Object.ID) == 0 {
+		return "", false, false
+	}
+	return update.Object.ID, *update.Object.Locked, true
+}
+
+// setLocked records if the actor approves its followers manually, from the raw Update activity it has sent.
+// It returns the IRIs of the collections that have been modified.
+func setLocked(db collectionStore, self vocab.Item, update *vocab.Activity, raw []byte) (vocab.IRIs, error) {
+	actor, locked, ok := manuallyApprovesFollowers(raw)
+	if !ok || update == nil || vocab.IsNil(update.Actor) || !actor.Equals(update.Actor.GetLink(), false) {
+		return nil, nil
+	}
+	col := lockedActors(self)
+	changed, err := setMembership(db, col, actor, locked)
+	if err != nil || !changed {
+		return nil, err
+	}
+	return vocab.IRIs{col, actor}, nil
+}
+
+// respondToFollow publishes the Accept or Reject, depending on accept, of the follow activity on behalf of the
+// followed actor, and removes the follow from the pending requests. When accepted, the follower is added to the
+// followers collection, and, if it's local, the followed actor to its following collection.
+// The response is delivered to the remote followers using the q delivery queue.
+// It returns the IRIs of the collections that have been modified.
+func respondToFollow(db collectionStore, q *deliveryQueue, base vocab.IRI, follow *vocab.Activity, accept bool, now time.Time) (vocab.IRIs, error) {
+	follower := follow.Actor.GetLink()
+	followed := follow.Object.GetLink()
+
+	typ := vocab.RejectType
+	if accept {
+		typ = vocab.AcceptType
+	}
+	response := &vocab.Activity{
+		Type:      typ,
+		Actor:     followed,
+		Object:    follow.GetLink(),
+		To:        vocab.ItemCollection{follower},
+		Published: now,
+	}
+	if err := publishAs(db, base, followed, response); err != nil {
+		return nil, err
+	}
+	modified := vocab.IRIs{vocab.Outbox.IRI(followed)}
+	requests := followRequests(followed)
+	if removed, err := removeIfContains(db, requests, follow.GetLink()); err != nil {
+		return modified, err
+	} else if removed {
+		modified = append(modified, requests)
+	}
+	if accept {
+		for col, it := range map[vocab.IRI]vocab.IRI{vocab.Followers.IRI(followed): follower, vocab.Following.IRI(follower): followed} {
+			if !col.Contains(base, false) {
+				continue
+			}
+			if changed, err := setMembership(db, col, it, true); err != nil {
+				return modified, err
+			} else if changed {
+				modified = append(modified, col)
+			}
+		}
+	}
+	if q != nil {
+		if _, err := deliverFollowResponse(q, db, base, response); err != nil {
+			return modified, err
+		}
+	}
+	return modified, nil
+}
+
+// receiveFollow handles a Follow of a local actor received in its inbox: when the actor approves its followers
+// manually, the Follow is queued in its follow requests, otherwise it's accepted right away.
+// It returns the IRIs of the collections that have been modified.
+func receiveFollow(db collectionStore, q *deliveryQueue, base vocab.IRI, self vocab.Item, follow *vocab.Activity, now time.Time) (vocab.IRIs, error) {
+	if follow == nil || follow.GetType() != vocab.FollowType || vocab.IsNil(follow.Actor) || vocab.IsNil(follow.Object) {
+		return nil, nil
+	}
+	followed := follow.Object.GetLink()
+	if !followed.Contains(base, false) || followed.Equals(self.GetLink(), false) {
+		return nil, nil
+	}
+	if _, err := loadActorItem(db, followed); err != nil {
+		return nil, nil
+	}
+	if collectionContains(db, vocab.Followers.IRI(followed), follow.Actor.GetLink()) {
+		return nil, nil
+	}
+	if !isLocked(db, self, followed) {
+		return respondToFollow(db, q, base, follow, true, now)
+	}
+	requests := followRequests(followed)
+	changed, err := setMembership(db, requests, follow.GetLink(), true)
+	if err != nil || !changed {
+		return nil, err
+	}
+	return vocab.IRIs{requests}, nil
+}
+
+// answerFollowRequest handles an Accept or Reject that the followed actor sends from its outbox, for one of its
+// pending follow requests, which gets removed from them.
+func answerFollowRequest(db collectionStore, a *vocab.Activity) (vocab.IRIs, error) {
+	if a == nil || (a.GetType() != vocab.AcceptType && a.GetType() != vocab.RejectType) || vocab.IsNil(a.Object) || vocab.IsNil(a.Actor) {
+		return nil, nil
+	}
+	requests := followRequests(a.Actor.GetLink())
+	removed, err := removeIfContains(db, requests, a.Object.GetLink())
+	if err != nil || !removed {
+		return nil, err
+	}
+	return vocab.IRIs{requests}, nil
+}
+
+// followRequestsActor returns the IRI of the actor whose follow requests are in the r request path
+func followRequestsActor(r *http.Request, secure bool) (vocab.IRI, error) {
+	u, err := url.Parse(reqURL(r, secure))
+	if err != nil {
+		return "", errors.NewBadRequest(err, "invalid request URL")
+	}
+	u.RawQuery = ""
+	p := strings.TrimSuffix(u.String(), "/")
+	if i := strings.LastIndex(p, "/"+followRequestsPath); i > 0 {
+		p = p[:i]
+	}
+	return vocab.IRI(p), nil
+}
+
+func handleFollowRequests(db collectionStore, q *deliveryQueue, base vocab.IRI, secure bool, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		iri, err := followRequestsActor(r, secure)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		actor, err := loadActorItem(db, iri)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if by := actorFn(r); isAnonymous(by) || !by.GetLink().Equals(actor.GetLink(), false) {
+			errors.HandleError(errors.Unauthorizedf("only %s can see its follow requests", actor.GetLink())).ServeHTTP(w, r)
+			return
+		}
+		requests := followRequests(actor.GetLink())
+		action := chi.URLParam(r, "action")
+		if r.Method == http.MethodGet && action == "" {
+			items := make(vocab.ItemCollection, 0)
+			if loaded, err := db.Load(requests); err == nil {
+				vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+					for _, it := range c.Collection() {
+						if follow, err := loadFollow(db, it.GetLink()); err == nil {
+							items = append(items, follow)
+						}
+					}
+					return nil
+				})
+			}
+			writeItem(w, r, http.StatusOK, &vocab.OrderedCollection{
+				ID:           requests,
+				Type:         vocab.OrderedCollectionType,
+				OrderedItems: items,
+				TotalItems:   uint(len(items)),
+			})
+			return
+		}
+		if r.Method != http.MethodPost || (action != "accept" && action != "reject") {
+			errors.HandleError(errors.MethodNotAllowedf("method not allowed")).ServeHTTP(w, r)
+			return
+		}
+		followIRI := vocab.IRI(r.FormValue(followKey))
+		if len(followIRI) == 0 || !collectionContains(db, requests, followIRI) {
+			errors.HandleError(errors.NotFoundf("follow request %s not found", followIRI)).ServeHTTP(w, r)
+			return
+		}
+		follow, err := loadFollow(db, followIRI)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if _, err = respondToFollow(db, q, base, follow, action == "accept", time.Now().UTC()); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleFollowRequests serves the Follow requests waiting for the approval of an actor which approves its followers
+// manually, and allows the actor to accept or reject them with a POST to the "accept" or "reject" end-points,
+// with the IRI of the Follow in the "follow" parameter.
+func HandleFollowRequests(fb FedBOX) http.HandlerFunc {
+	db, ok := fb.storage.(collectionStore)
+	if !ok {
+		return errors.HandleError(errors.NotImplementedf("follow requests are not supported by the storage")).ServeHTTP
+	}
+	return handleFollowRequests(db, fb.deliveries, vocab.IRI(fb.Config().BaseURL), fb.Config().Secure, fb.actorFromRequest)
+}
@@ -0,0 +1,190 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// publishedOfType returns the activity of the typ type in the outbox
+func publishedOfType(db mockCollectionStore, outbox vocab.IRI, typ vocab.ActivityVocabularyType) vocab.Item {
+	var found vocab.Item
+	vocab.OnCollectionIntf(db.mockStore[outbox], func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if a, ok := db.mockStore[it.GetLink()]; ok && a.GetType() == typ {
+				found = a
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+func TestReceiveFollow(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	self := &vocab.Service{ID: base, Type: vocab.ServiceType}
+	now := time.Now().UTC()
+
+	janeDoe := &vocab.Actor{ID: "https://fedbox.local/actors/janedoe", Type: vocab.PersonType}
+	bob := &vocab.Actor{
+		ID:    "https://example.com/users/bob",
+		Type:  vocab.PersonType,
+		Inbox: vocab.IRI("https://example.com/users/bob/inbox"),
+	}
+	follow := &vocab.Activity{
+		ID:     "https://example.com/activities/follow",
+		Type:   vocab.FollowType,
+		Actor:  bob.ID,
+		Object: janeDoe.ID,
+	}
+	followers := vocab.Followers.IRI(janeDoe)
+	requests := followRequests(janeDoe.ID)
+	outbox := vocab.Outbox.IRI(janeDoe)
+
+	setup := func(locked bool) (mockCollectionStore, *mockDeliverer) {
+		db := mockCollectionStore{mockStore{janeDoe.ID: janeDoe, follow.ID: follow}}
+		// NOTE(marius): the mock storage returns all its items for the IRIs it doesn't have
+		for _, col := range (vocab.IRIs{followers, requests, outbox, lockedActors(self)}) {
+			db.Create(&vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType})
+		}
+		if locked {
+			db.AddTo(lockedActors(self), janeDoe.ID)
+		}
+		cl := &mockDeliverer{mockLoader: mockLoader{bob.ID: bob}, delivered: make(map[vocab.IRI]vocab.IRIs)}
+		return db, cl
+	}
+
+	t.Run("locked actor", func(t *testing.T) {
+		db, cl := setup(true)
+		q := newDeliveryQueue(cl, 1, time.Minute, false)
+
+		modified, err := receiveFollow(db, q, base, self, follow, now)
+		if err != nil {
+			t.Fatalf("receiveFollow() returned error %s", err)
+		}
+		if !modified.Contains(requests) {
+			t.Errorf("receiveFollow() should have modified %s, modified %v", requests, modified)
+		}
+		if !collectionContains(db, requests, follow.ID) {
+			t.Errorf("the Follow should be pending in %s", requests)
+		}
+		if collectionContains(db, followers, bob.ID) {
+			t.Errorf("%s should not be in %s before the Follow is accepted", bob.ID, followers)
+		}
+		if len(cl.delivered) > 0 {
+			t.Errorf("nothing should have been delivered, delivered %v", cl.delivered)
+		}
+
+		if _, err = respondToFollow(db, q, base, follow, true, now); err != nil {
+			t.Fatalf("respondToFollow() returned error %s", err)
+		}
+		if collectionContains(db, requests, follow.ID) {
+			t.Errorf("the accepted Follow should not be pending anymore")
+		}
+		if !collectionContains(db, followers, bob.ID) {
+			t.Errorf("%s should be in %s after the Follow is accepted", bob.ID, followers)
+		}
+		if len(cl.delivered[bob.Inbox.GetLink()]) != 1 {
+			t.Errorf("the Accept should have been delivered to %s, delivered %v", bob.Inbox, cl.delivered)
+		}
+	})
+	t.Run("rejected by locked actor", func(t *testing.T) {
+		db, cl := setup(true)
+		q := newDeliveryQueue(cl, 1, time.Minute, false)
+
+		if _, err := receiveFollow(db, q, base, self, follow, now); err != nil {
+			t.Fatalf("receiveFollow() returned error %s", err)
+		}
+		if _, err := respondToFollow(db, q, base, follow, false, now); err != nil {
+			t.Fatalf("respondToFollow() returned error %s", err)
+		}
+		if collectionContains(db, requests, follow.ID) {
+			t.Errorf("the rejected Follow should not be pending anymore")
+		}
+		if collectionContains(db, followers, bob.ID) {
+			t.Errorf("%s should not be in %s after the Follow is rejected", bob.ID, followers)
+		}
+		if vocab.IsNil(publishedOfType(db, outbox, vocab.RejectType)) {
+			t.Errorf("a Reject should have been published in %s", outbox)
+		}
+	})
+	t.Run("unlocked actor", func(t *testing.T) {
+		db, cl := setup(false)
+		q := newDeliveryQueue(cl, 1, time.Minute, false)
+
+		modified, err := receiveFollow(db, q, base, self, follow, now)
+		if err != nil {
+			t.Fatalf("receiveFollow() returned error %s", err)
+		}
+		if !modified.Contains(followers) {
+			t.Errorf("receiveFollow() should have modified %s, modified %v", followers, modified)
+		}
+		if collectionContains(db, requests, follow.ID) {
+			t.Errorf("the Follow of an unlocked actor should not be pending")
+		}
+		if !collectionContains(db, followers, bob.ID) {
+			t.Errorf("%s should be in %s", bob.ID, followers)
+		}
+		accept := publishedOfType(db, outbox, vocab.AcceptType)
+		if vocab.IsNil(accept) {
+			t.Fatalf("an Accept should have been published in %s", outbox)
+		}
+		if got := cl.delivered[bob.Inbox.GetLink()]; !got.Contains(accept.GetLink()) {
+			t.Errorf("the Accept should have been delivered to %s, delivered %v", bob.Inbox, cl.delivered)
+		}
+	})
+	t.Run("already a follower", func(t *testing.T) {
+		db, cl := setup(false)
+		db.AddTo(followers, bob.ID)
+		q := newDeliveryQueue(cl, 1, time.Minute, false)
+
+		modified, err := receiveFollow(db, q, base, self, follow, now)
+		if err != nil {
+			t.Fatalf("receiveFollow() returned error %s", err)
+		}
+		if len(modified) > 0 || len(cl.delivered) > 0 {
+			t.Errorf("receiveFollow() should not do anything for an existing follower, modified %v, delivered %v", modified, cl.delivered)
+		}
+	})
+}
+
+func TestManuallyApprovesFollowers(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		actor  vocab.IRI
+		locked bool
+		ok     bool
+	}{
+		{
+			name:   "locking",
+			raw:    `{"type":"Update","object":{"id":"https://fedbox.local/actors/janedoe","manuallyApprovesFollowers":true}}`,
+			actor:  "https://fedbox.local/actors/janedoe",
+			locked: true,
+			ok:     true,
+		},
+		{
+			name:  "unlocking",
+			raw:   `{"type":"Update","object":{"id":"https://fedbox.local/actors/janedoe","manuallyApprovesFollowers":false}}`,
+			actor: "https://fedbox.local/actors/janedoe",
+			ok:    true,
+		},
+		{
+			name: "without the property",
+			raw:  `{"type":"Update","object":{"id":"https://fedbox.local/actors/janedoe","name":"Jane"}}`,
+		},
+		{
+			name: "not an Update",
+			raw:  `{"type":"Create","object":{"id":"https://fedbox.local/actors/janedoe","manuallyApprovesFollowers":true}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actor, locked, ok := manuallyApprovesFollowers([]byte(tt.raw))
+			if actor != tt.actor || locked != tt.locked || ok != tt.ok {
+				t.Errorf("manuallyApprovesFollowers() = %s, %t, %t, want %s, %t, %t", actor, locked, ok, tt.actor, tt.locked, tt.ok)
+			}
+		})
+	}
+}
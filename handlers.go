@@ -7,7 +7,9 @@ import (
 	"net/url"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"git.sr.ht/~mariusor/lw"
 	vocab "github.com/go-ap/activitypub"
@@ -15,6 +17,7 @@ import (
 	"github.com/go-ap/errors"
 	ap "github.com/go-ap/fedbox/activitypub"
 	"github.com/go-ap/fedbox/internal/cache"
+	"github.com/go-ap/fedbox/internal/config"
 	st "github.com/go-ap/fedbox/storage"
 	"github.com/go-ap/filters"
 	"github.com/go-ap/processing"
@@ -51,13 +54,177 @@ func reqURL(r *http.Request, secure bool) string {
 	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
 }
 
-func orderItems(col vocab.ItemCollection) vocab.ItemCollection {
+// orderItems sorts the items with the most recently published or updated first. The items with the same
+// timestamp are ordered by their IRIs, according to the tieBreak value, so the order is the same for every load.
+func orderItems(items vocab.ItemCollection, tieBreak config.OrderTieBreak) vocab.ItemCollection {
+	// NOTE(marius): the items can belong to a collection from the cache, which we must not modify in place
+	col := make(vocab.ItemCollection, len(items))
+	copy(col, items)
 	sort.SliceStable(col, func(i, j int) bool {
-		return vocab.ItemOrderTimestamp(col[i], col[j])
+		if vocab.ItemOrderTimestamp(col[i], col[j]) {
+			return true
+		}
+		if vocab.ItemOrderTimestamp(col[j], col[i]) {
+			return false
+		}
+		switch tieBreak {
+		case config.OrderTieBreakAsc:
+			return col[i].GetLink() < col[j].GetLink()
+		case config.OrderTieBreakDesc:
+			return col[i].GetLink() > col[j].GetLink()
+		}
+		return false
 	})
 	return col
 }
 
+// orderKey is the query parameter used for requesting the items of a collection in ascending order,
+// with the oldest first, using the "asc" value. By default the most recent items come first.
+const orderKey = "order"
+
+const orderAscending = "asc"
+
+// orderItemsFor sorts the items in the direction requested in the query of the r request
+func orderItemsFor(r *http.Request, col vocab.ItemCollection, tieBreak config.OrderTieBreak) vocab.ItemCollection {
+	col = orderItems(col, tieBreak)
+	if !strings.EqualFold(r.URL.Query().Get(orderKey), orderAscending) {
+		return col
+	}
+	for i, j := 0, len(col)-1; i < j; i, j = i+1, j-1 {
+		col[i], col[j] = col[j], col[i]
+	}
+	return col
+}
+
+// withOrderParam adds the ascending order query parameter to the pagination links of the col collection,
+// when the r request contains it, so the following pages keep the same order.
+func withOrderParam(r *http.Request, col vocab.CollectionInterface) {
+	if !strings.EqualFold(r.URL.Query().Get(orderKey), orderAscending) {
+		return
+	}
+	addParam := func(it vocab.Item) vocab.Item {
+		if vocab.IsNil(it) || !vocab.IsIRI(it) {
+			return it
+		}
+		u, err := it.GetLink().URL()
+		if err != nil {
+			return it
+		}
+		q := u.Query()
+		q.Set(orderKey, orderAscending)
+		u.RawQuery = q.Encode()
+		return vocab.IRI(u.String())
+	}
+	switch c := col.(type) {
+	case *vocab.OrderedCollection:
+		if first, ok := c.First.(*vocab.OrderedCollectionPage); ok {
+			// NOTE(marius): the first page is embedded when EmbedFirstPage is enabled
+			withOrderParam(r, first)
+		}
+		c.First = addParam(c.First)
+		c.Last = addParam(c.Last)
+	case *vocab.OrderedCollectionPage:
+		c.First = addParam(c.First)
+		c.Last = addParam(c.Last)
+		c.Next = addParam(c.Next)
+		c.Prev = addParam(c.Prev)
+	}
+}
+
+// maxItemsKey is the query parameter the clients use for requesting the number of items in a collection page
+const maxItemsKey = "maxItems"
+
+// pageSize returns the number of items of the collection page requested by r, which is the def size when the
+// client doesn't request one, and is clamped to max when the client requests more.
+func pageSize(r *http.Request, def, max int) int {
+	size, err := strconv.Atoi(r.URL.Query().Get(maxItemsKey))
+	if err != nil || size <= 0 {
+		size = def
+	}
+	if max > 0 && size > max {
+		size = max
+	}
+	return size
+}
+
+const (
+	// typeFilterKey is the query parameter used for filtering the items of a collection by their type
+	typeFilterKey = "type"
+	// objectTypeFilterKey is the query parameter used for filtering the activities of a collection by the type
+	// of their object
+	objectTypeFilterKey = "object.type"
+)
+
+// typeFilter is a filter for the item types: the items match when their type is one of the included ones,
+// if there are any, and it's not one of the excluded ones.
+type typeFilter struct {
+	include vocab.ActivityVocabularyTypes
+	exclude vocab.ActivityVocabularyTypes
+}
+
+// parseTypeFilter returns the filter for the types, which can be comma separated lists.
+// A type prefixed with "!" is excluded.
+func parseTypeFilter(types []string) typeFilter {
+	tf := typeFilter{
+		include: make(vocab.ActivityVocabularyTypes, 0),
+		exclude: make(vocab.ActivityVocabularyTypes, 0),
+	}
+	for _, typ := range types {
+		for _, t := range strings.Split(typ, ",") {
+			if t = strings.TrimSpace(t); strings.HasPrefix(t, "!") {
+				tf.exclude = append(tf.exclude, vocab.ActivityVocabularyType(strings.TrimPrefix(t, "!")))
+			} else if len(t) > 0 {
+				tf.include = append(tf.include, vocab.ActivityVocabularyType(t))
+			}
+		}
+	}
+	return tf
+}
+
+func (tf typeFilter) empty() bool {
+	return len(tf.include)+len(tf.exclude) == 0
+}
+
+// matches checks if the it item passes the filter.
+// The items which are only links, and for which we don't know the type, don't.
+func (tf typeFilter) matches(it vocab.Item) bool {
+	if vocab.IsNil(it) || it.IsLink() {
+		return false
+	}
+	typ := it.GetType()
+	return !tf.exclude.Contains(typ) && (len(tf.include) == 0 || tf.include.Contains(typ))
+}
+
+// filterItems returns the items which match the types, and, for the activities, whose object matches the
+// objectTypes. The types in the same list are alternatives, while the items must match both lists.
+// A type prefixed with "!" excludes the items of that type. The items which are only links, and for which we
+// don't know the type, are excluded too, as are the activities whose object is only a link.
+func filterItems(items vocab.ItemCollection, types, objectTypes []string) vocab.ItemCollection {
+	tf := parseTypeFilter(types)
+	of := parseTypeFilter(objectTypes)
+	if tf.empty() && of.empty() {
+		return items
+	}
+	result := make(vocab.ItemCollection, 0, len(items))
+	for _, it := range items {
+		if !tf.empty() && !tf.matches(it) {
+			continue
+		}
+		if !of.empty() {
+			matches := false
+			vocab.OnActivity(it, func(a *vocab.Activity) error {
+				matches = of.matches(a.Object)
+				return nil
+			})
+			if !matches {
+				continue
+			}
+		}
+		result = append(result, it)
+	}
+	return result
+}
+
 // HandleCollection serves content from the generic collection end-points
 // that return ActivityPub objects or activities
 func HandleCollection(fb FedBOX) processing.CollectionHandlerFn {
@@ -71,7 +238,9 @@ func HandleCollection(fb FedBOX) processing.CollectionHandlerFn {
 		}
 
 		f := filters.FromRequest(r, fb.Config().BaseURL)
-		filters.LoadCollectionFilters(f, fb.actorFromRequest(r))
+		f.MaxItems = pageSize(r, fb.Config().CollectionPageSize, fb.Config().MaxCollectionPageSize)
+		act := fb.actorFromRequest(r)
+		filters.LoadCollectionFilters(f, act)
 
 		cacheKey := filters.CacheKey(f)
 		it := fb.caches.Get(cacheKey)
@@ -86,15 +255,29 @@ func HandleCollection(fb FedBOX) processing.CollectionHandlerFn {
 		if vocab.IsNil(it) || !it.IsCollection() {
 			return nil, errors.NotFoundf("collection '%s' not found", f.Collection)
 		}
+		if err = checkCollectionAccess(repo, it, act, !fb.Config().PrivateLiked); err != nil {
+			return nil, err
+		}
 
 		c := new(vocab.OrderedCollection)
 		c.Type = vocab.OrderedCollectionType
+		vocab.OnObject(it, func(o *vocab.Object) error {
+			// NOTE: the time the members of the collection have last changed
+			c.Updated = o.Updated
+			return nil
+		})
 		err = vocab.OnCollectionIntf(it, func(items vocab.CollectionInterface) error {
 			ff := *f
 			ff.Authenticated = nil
 			c.ID = ff.GetLink()
-			c.OrderedItems = orderItems(items.Collection())
-			c.TotalItems = c.OrderedItems.Count()
+			col := items.Collection()
+			if typ == vocab.Outbox || typ == vocab.Inbox {
+				// NOTE(marius): clients can request only the activities of a type, eg: the Creates for an actor's posts,
+				// or only the ones with an object of a type, eg: the activities of Notes
+				col = filterItems(col, r.URL.Query()[typeFilterKey], r.URL.Query()[objectTypeFilterKey])
+			}
+			c.OrderedItems = orderItemsFor(r, col, fb.Config().OrderTieBreak)
+			c.TotalItems = cappedTotalItems(repo, items.GetLink(), c.OrderedItems.Count(), fb.Config().TotalItemsCap, r.URL.RawQuery != "")
 			return nil
 		})
 		if err != nil {
@@ -105,6 +288,9 @@ func HandleCollection(fb FedBOX) processing.CollectionHandlerFn {
 		if !fromCache && c.Count() > 0 {
 			toStore = *c
 		}
+		// NOTE(marius): the items are filtered after caching the collection, as the cache is shared by all the actors
+		hideInvisibleItems(repo, c, act)
+		full := *c
 		var col vocab.CollectionInterface = c
 		if col, err = ap.PaginateCollection(col, f); err != nil {
 			return nil, err
@@ -112,7 +298,19 @@ func HandleCollection(fb FedBOX) processing.CollectionHandlerFn {
 		if !fromCache && toStore.Collection() != nil {
 			fb.caches.Set(cacheKey, toStore)
 		}
-		for _, it := range col.Collection() {
+		if fb.Config().EmbedFirstPage {
+			if col, err = embedFirstPage(col, full, r, fb.Config().BaseURL, act); err != nil {
+				return nil, err
+			}
+		}
+		withOrderParam(r, col)
+		items := collectionItems(col)
+		if shouldEmbedRemote(fb.Config().EmbedRemoteCollections, typ) {
+			remote, cancel := fb.remote.forRequest(r)
+			embedRemoteItems(items, vocab.IRI(fb.Config().BaseURL), remote, fb.caches)
+			cancel()
+		}
+		for _, it := range items {
 			// Remove bcc and bto - probably should be moved to a different place
 			// TODO(marius): move this to the go-ap/activtiypub helpers: CleanRecipients(Item)
 			if s, ok := it.(vocab.HasRecipients); ok {
@@ -155,6 +353,15 @@ func ValidateRequest(r *http.Request) (bool, error) {
 // GenerateID creates an IRI that can be used to uniquely identify the "it" item, based on the collection "col" and
 // its creator "by"
 func GenerateID(base vocab.IRI) func(it vocab.Item, col vocab.Item, by vocab.Item) (vocab.ID, error) {
+	return GenerateIDWith(base, ap.UUIDGenerator{})
+}
+
+// GenerateIDWith is like GenerateID, but the last path segment of the IRIs is generated by the gen IDGenerator.
+// A nil gen generates random UUIDs.
+func GenerateIDWith(base vocab.IRI, gen ap.IDGenerator) func(it vocab.Item, col vocab.Item, by vocab.Item) (vocab.ID, error) {
+	if gen == nil {
+		gen = ap.UUIDGenerator{}
+	}
 	return func(it vocab.Item, col vocab.Item, by vocab.Item) (vocab.ID, error) {
 		typ := it.GetType()
 
@@ -166,14 +373,13 @@ func GenerateID(base vocab.IRI) func(it vocab.Item, col vocab.Item, by vocab.Ite
 		} else {
 			partOf = filters.ObjectsType.IRI(base)
 		}
-		return ap.GenerateID(it, partOf, by)
+		return ap.GenerateIDWith(gen, it, partOf, by)
 	}
 }
 
 // HandleActivity handles POST requests to an ActivityPub actor's inbox/outbox, based on the CollectionType
 func HandleActivity(fb FedBOX) processing.ActivityHandlerFn {
 	return func(receivedIn vocab.IRI, r *http.Request) (vocab.Item, int, error) {
-		repo := fb.storage
 		var it vocab.Item
 		fb.infFn("received req %s: %s", r.Method, r.RequestURI)
 
@@ -189,19 +395,30 @@ func HandleActivity(fb FedBOX) processing.ActivityHandlerFn {
 			fb.errFn("failed loading body: %+s", err)
 			return it, http.StatusInternalServerError, errors.NewNotValid(err, "unable to read request body")
 		}
-		if it, err = vocab.UnmarshalJSON(body); err != nil {
+		if fb.Config().SelfInboxFederationOnly && isSelfInbox(receivedIn, fb.self) {
+			if it, err = selfInboxActivity(r, body, fb.actorFromRequest(r)); err != nil {
+				fb.errFn("invalid request to the service's inbox: %+s", err)
+				return it, errors.HttpStatus(err), err
+			}
+		} else if it, err = vocab.UnmarshalJSON(body); err != nil {
 			fb.errFn("failed unmarshaling jsonld body: %+s", err)
 			return it, http.StatusInternalServerError, errors.NewNotValid(err, "unable to unmarshal JSON request")
 		}
 
 		l := fb.logger.WithContext(lw.Ctx{"log": "processing"})
 		baseIRI := vocab.IRI(fb.Config().BaseURL)
+		db := withStreams(fb.storage, fb.streams)
+		if processing.Typer.Type(r) == vocab.Outbox {
+			db = newOutboxStore(db, baseIRI, fb.idGenerator, f.Authenticated)
+		}
+		// NOTE(marius): the changes to the collections are serialized by the storage of the instance
+		var repo processing.Store = db
 		processor, err := processing.New(
 			processing.WithIRI(baseIRI, InternalIRI),
 			processing.WithClient(&fb.client),
 			processing.WithStorage(repo),
 			processing.WithLogger(l),
-			processing.WithIDGenerator(GenerateID(baseIRI)),
+			processing.WithIDGenerator(GenerateIDWith(baseIRI, fb.idGenerator)),
 			processing.WithLocalIRIChecker(st.IsLocalIRI(repo)),
 		)
 		if err != nil {
@@ -220,16 +437,254 @@ func HandleActivity(fb FedBOX) processing.ActivityHandlerFn {
 			}
 			return nil
 		})
+		if fb.Config().RejectTombstoneCreate {
+			if err = vocab.OnActivity(it, ValidateCreateObject); err != nil {
+				fb.errFn("invalid activity: %+s", err)
+				return it, errors.HttpStatus(err), err
+			}
+		}
+		if processing.Typer.Type(r) == vocab.Outbox {
+			err = vocab.OnActivity(it, func(a *vocab.Activity) error {
+				if fb.Config().DedupeRecipients {
+					DedupeRecipients(a)
+				}
+				if err := ValidateProfileFields(a, fb.Config().MaxProfileFields); err != nil {
+					return err
+				}
+				return ValidateFollowersOnlyAddressing(a, fb.Config().FollowersOnlyPublic)
+			})
+			if err != nil {
+				fb.errFn("invalid activity: %+s", err)
+				return it, errors.HttpStatus(err), err
+			}
+			if prev, ok := fb.idempotency.idempotentActivity(repo, r, fb.actorFromRequest(r)); ok {
+				fb.infFn("returning %s created by a request with the same %s", prev.GetLink(), idempotencyKeyHeader)
+				return prev, http.StatusCreated, nil
+			}
+		}
+		if processing.Typer.Type(r) == vocab.Inbox && fb.Config().RejectDeletedActorInbox {
+			if err = checkInboxOwner(repo, receivedIn); err != nil {
+				fb.errFn("rejected activity: %+s", err)
+				return it, errors.HttpStatus(err), err
+			}
+		}
+		if processing.Typer.Type(r) == vocab.Inbox {
+			err = vocab.OnActivity(it, func(a *vocab.Activity) error {
+				return ValidateInboxActivity(repo, baseIRI, a, fb.actorFromRequest(r))
+			})
+			if err != nil {
+				fb.errFn("rejected activity: %+s", err)
+				return it, errors.HttpStatus(err), err
+			}
+			if isReplayed(repo, receivedIn, it) {
+				fb.infFn("activity %s has already been received in %s", it.GetLink(), receivedIn)
+				return it, http.StatusAccepted, nil
+			}
+		}
+		if it.GetType() == vocab.UpdateType {
+			err = vocab.OnActivity(it, func(update *vocab.Activity) error {
+				return mergeUpdate(repo, update, body, time.Now().UTC())
+			})
+			if err != nil {
+				fb.errFn("invalid update: %+s", err)
+				return it, errors.HttpStatus(err), err
+			}
+		}
+		// NOTE(marius): the HTML in the content of the items gets sanitized before it reaches the storage
+		sanitizeItem(fb.sanitizer, it)
+		if it.GetType() == vocab.FlagType {
+			// NOTE(marius): the reports don't get processed, so they don't reach the inboxes of the reported
+			// actors, they are stored only in the reports collection visible to the administrators
+			return fb.receiveReport(repo, processing.Typer.Type(r), it, fb.actorFromRequest(r))
+		}
+		var prevProfile *vocab.Actor
+		if fb.Config().ProfileHistory && it.GetType() == vocab.UpdateType {
+			prevProfile = loadProfile(repo, it)
+		}
 		if it, err = processor.ProcessActivity(it, receivedIn); err != nil {
 			fb.errFn("failed processing activity: %+s", err)
 			return it, errors.HttpStatus(err), errors.Annotatef(err, "Can't save activity %s to %s", it.GetType(), f.Collection)
 		}
+		fb.metrics.activityReceived(processing.Typer.Type(r), it)
+		if processing.Typer.Type(r) == vocab.Outbox {
+			if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+				fb.idempotency.set(fb.actorFromRequest(r).GetLink(), key, it.GetLink(), time.Now())
+			}
+			vocab.OnActivity(it, func(a *vocab.Activity) error {
+				if _, err := deliverToRemoteFollowers(&fb.client, baseIRI, a); err != nil {
+					fb.errFn("unable to deliver to the remote followers: %+s", err)
+				}
+				if db, ok := repo.(collectionStore); ok {
+					modified, err := deliverToFollowers(db, fb.deliveries, baseIRI, a, time.Now().UTC())
+					if err != nil {
+						fb.errFn("unable to deliver to the followers: %+s", err)
+					}
+					if len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+				}
+				if _, err := forwardToRelays(&fb.client, repo, &fb.self, a); err != nil {
+					fb.errFn("unable to forward to the relays: %+s", err)
+				}
+				if fb.Config().DeliverFollowResponses {
+					if _, err := deliverFollowResponse(fb.deliveries, repo, baseIRI, a); err != nil {
+						fb.errFn("unable to deliver the response to the Follow: %+s", err)
+					}
+				}
+				return nil
+			})
+		}
+		if isSelfInbox(receivedIn, fb.self) && len(fb.Config().Relays) > 0 {
+			if db, ok := repo.(collectionStore); ok {
+				vocab.OnActivity(it, func(a *vocab.Activity) error {
+					relays := relayIRIs(fb.Config().Relays)
+					modified, err := acceptRelay(db, &fb.client, &fb.self, relays, a)
+					if err != nil {
+						fb.errFn("unable to accept the relay subscription: %+s", err)
+					}
+					ingested, err := ingestRelayAnnounce(db, &fb.client, &fb.self, a)
+					if err != nil {
+						fb.errFn("unable to ingest the activity announced by the relay: %+s", err)
+					}
+					if modified = append(modified, ingested...); len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+					return nil
+				})
+			}
+		}
 		err = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			fb.search.update(repo, act)
+			if modified, err := cache.ActivityIRIs(act, receivedIn); err == nil {
+				touchCollections(repo, time.Now().UTC(), modified...)
+			}
 			return cache.ActivityPurge(fb.caches, act, receivedIn)
 		})
 		if err != nil {
 			fb.errFn("unable to purge cache: %+s", err)
 		}
+		if prevProfile != nil {
+			if db, ok := repo.(collectionStore); ok {
+				vocab.OnActivity(it, func(update *vocab.Activity) error {
+					modified, err := recordProfileChange(db, prevProfile, update, time.Now().UTC())
+					if err != nil {
+						fb.errFn("unable to record the profile change: %+s", err)
+					}
+					if len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+					return nil
+				})
+			}
+		}
+		if it.GetType() == vocab.MoveType {
+			// NOTE: the cached representation of the moved actor contains the movedTo property
+			vocab.OnActivity(it, func(move *vocab.Activity) error {
+				fb.caches.Remove(move.Actor.GetLink())
+				return nil
+			})
+			if db, ok := repo.(collectionStore); ok && processing.Typer.Type(r) == vocab.Inbox {
+				vocab.OnActivity(it, func(move *vocab.Activity) error {
+					modified, err := migrateFollowers(db, fb.remote, fb.deliveries, baseIRI, move, time.Now().UTC())
+					if err != nil {
+						fb.errFn("unable to migrate the followers of %s: %+s", move.Actor.GetLink(), err)
+					}
+					if len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+					return nil
+				})
+			}
+		}
+		if processing.Typer.Type(r) == vocab.Inbox && (it.GetType() == vocab.UpdateType || it.GetType() == vocab.DeleteType) {
+			// NOTE: the actor might have rotated its keys, so we don't verify its signatures with the cached ones
+			vocab.OnActivity(it, func(a *vocab.Activity) error {
+				if !vocab.IsNil(a.Actor) {
+					fb.keys.invalidate(a.Actor.GetLink())
+				}
+				return nil
+			})
+		}
+		if fb.Config().RejectAcceptedFollow && it.GetType() == vocab.RejectType {
+			if db, ok := repo.(collectionStore); ok {
+				vocab.OnActivity(it, func(reject *vocab.Activity) error {
+					modified, err := rejectAcceptedFollow(db, reject)
+					if err != nil {
+						fb.errFn("unable to remove follower relationship: %+s", err)
+					}
+					if len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+					return nil
+				})
+			}
+		}
+		if it.GetType() == vocab.UndoType {
+			if db, ok := repo.(collectionStore); ok {
+				vocab.OnActivity(it, func(undo *vocab.Activity) error {
+					modified, err := undoSideEffects(db, undo)
+					if err != nil {
+						fb.errFn("unable to reverse the undone activity: %+s", err)
+					}
+					if len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+					return nil
+				})
+			}
+		}
+		if db, ok := repo.(collectionStore); ok {
+			vocab.OnActivity(it, func(a *vocab.Activity) error {
+				var modified vocab.IRIs
+				var err error
+				switch {
+				case a.GetType() == vocab.FollowType:
+					// NOTE: the Follows of local actors sent by other local actors don't go through their inbox
+					modified, err = receiveFollow(db, fb.deliveries, baseIRI, &fb.self, a, time.Now().UTC())
+				case processing.Typer.Type(r) != vocab.Outbox:
+					return nil
+				case a.GetType() == vocab.UpdateType:
+					modified, err = setLocked(db, &fb.self, a, body)
+				default:
+					modified, err = answerFollowRequest(db, a)
+				}
+				if err != nil {
+					fb.errFn("unable to handle the follow request: %+s", err)
+				}
+				if len(modified) > 0 {
+					fb.caches.Remove(modified...)
+				}
+				return nil
+			})
+		}
+		if fb.Config().DisableReplies && it.GetType() == vocab.CreateType {
+			if db, ok := repo.(processing.CollectionStore); ok {
+				vocab.OnActivity(it, func(create *vocab.Activity) error {
+					modified, err := unlinkReplies(db, create)
+					if err != nil {
+						fb.errFn("unable to remove the reply from the replies collections: %+s", err)
+					}
+					if len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+					return nil
+				})
+			}
+		}
+		if fb.Config().CascadeActorDelete && it.GetType() == vocab.DeleteType {
+			if db, ok := repo.(collectionStore); ok {
+				vocab.OnActivity(it, func(del *vocab.Activity) error {
+					modified, err := deleteRemoteActor(db, baseIRI, del, time.Now().UTC())
+					if err != nil {
+						fb.errFn("unable to clean up after the deleted actor: %+s", err)
+					}
+					if len(modified) > 0 {
+						fb.caches.Remove(modified...)
+					}
+					return nil
+				})
+			}
+		}
 
 		status := http.StatusCreated
 		if it.GetType() == vocab.DeleteType {
@@ -251,7 +706,8 @@ func HandleItem(fb FedBOX) processing.ItemHandlerFn {
 			return nil, errors.NotFoundf("%s not found", r.URL.Path)
 		}
 
-		filters.LoadItemFilters(f, fb.actorFromRequest(r))
+		act := fb.actorFromRequest(r)
+		filters.LoadItemFilters(f, act)
 
 		cacheKey := filters.CacheKey(f)
 		it := fb.caches.Get(cacheKey)
@@ -304,10 +760,21 @@ func HandleItem(fb FedBOX) processing.ItemHandlerFn {
 		if it, err = loadItem(items, f, reqURL(r, fb.Config().Secure)); err != nil {
 			return nil, errors.NotFoundf("%snot found", what)
 		}
+		if err = checkAuthorizedFetch(it, act, fb.Config().AuthorizedFetch); err != nil {
+			return nil, err
+		}
+		if err = checkItemVisibility(repo, it, act); err != nil {
+			return nil, err
+		}
+		if !fromCache {
+			it = withMovedTo(repo, it)
+		}
 
 		if !fromCache {
 			fb.caches.Set(cacheKey, it)
 		}
+		// NOTE(marius): the counts change with every Like, Announce or reply, so we don't keep them in the cache
+		it = withInteractionCounts(repo, it)
 
 		if s, ok := it.(vocab.HasRecipients); ok {
 			// Remove bcc and bto - probably should be moved to a different place
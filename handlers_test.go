@@ -1,6 +1,15 @@
 package fedbox
 
-import "testing"
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+)
 
 func TestHandleCollection(t *testing.T) {
 	t.Skipf("TODO")
@@ -13,3 +22,240 @@ func TestHandleItem(t *testing.T) {
 func TestHandleRequest(t *testing.T) {
 	t.Skipf("TODO")
 }
+
+func TestFilterByType(t *testing.T) {
+	create := &vocab.Activity{ID: "https://fedbox.local/activities/create", Type: vocab.CreateType}
+	like := &vocab.Activity{ID: "https://fedbox.local/activities/like", Type: vocab.LikeType}
+	announce := &vocab.Activity{ID: "https://fedbox.local/activities/announce", Type: vocab.AnnounceType}
+	link := vocab.IRI("https://fedbox.local/activities/unknown")
+	outbox := vocab.ItemCollection{create, like, announce, link}
+
+	tests := map[string]struct {
+		types []string
+		want  vocab.IRIs
+	}{
+		"no filter": {
+			types: nil,
+			want:  vocab.IRIs{create.ID, like.ID, announce.ID, link},
+		},
+		"only creates": {
+			types: []string{"Create"},
+			want:  vocab.IRIs{create.ID},
+		},
+		"multiple types": {
+			types: []string{"Create", "Announce"},
+			want:  vocab.IRIs{create.ID, announce.ID},
+		},
+		"comma separated types": {
+			types: []string{"Create,Like"},
+			want:  vocab.IRIs{create.ID, like.ID},
+		},
+		"excluded type": {
+			types: []string{"!Like"},
+			want:  vocab.IRIs{create.ID, announce.ID},
+		},
+		"unknown type": {
+			types: []string{"Follow"},
+			want:  vocab.IRIs{},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := filterItems(outbox, tt.types, nil)
+			if len(got) != len(tt.want) {
+				t.Fatalf("filterItems() returned %d items, expected %d", len(got), len(tt.want))
+			}
+			for i, it := range got {
+				if !it.GetLink().Equals(tt.want[i], false) {
+					t.Errorf("item %d is %s, expected %s", i, it.GetLink(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFilterItems(t *testing.T) {
+	note := &vocab.Object{ID: "https://fedbox.local/objects/note", Type: vocab.NoteType}
+	article := &vocab.Object{ID: "https://fedbox.local/objects/article", Type: vocab.ArticleType}
+	createNote := &vocab.Activity{ID: "https://fedbox.local/activities/create-note", Type: vocab.CreateType, Object: note}
+	createArticle := &vocab.Activity{ID: "https://fedbox.local/activities/create-article", Type: vocab.CreateType, Object: article}
+	announceNote := &vocab.Activity{ID: "https://fedbox.local/activities/announce-note", Type: vocab.AnnounceType, Object: note}
+	likeLink := &vocab.Activity{ID: "https://fedbox.local/activities/like", Type: vocab.LikeType, Object: vocab.IRI("https://example.com/objects/1")}
+	outbox := vocab.ItemCollection{createNote, createArticle, announceNote, likeLink}
+
+	tests := map[string]struct {
+		query string
+		want  vocab.IRIs
+	}{
+		"no filter": {
+			query: "",
+			want:  vocab.IRIs{createNote.ID, createArticle.ID, announceNote.ID, likeLink.ID},
+		},
+		"single type": {
+			query: "type=Create",
+			want:  vocab.IRIs{createNote.ID, createArticle.ID},
+		},
+		"multiple types": {
+			query: "type=Create,Like",
+			want:  vocab.IRIs{createNote.ID, createArticle.ID, likeLink.ID},
+		},
+		"object type": {
+			query: "object.type=Note",
+			want:  vocab.IRIs{createNote.ID, announceNote.ID},
+		},
+		"multiple object types": {
+			query: "object.type=Note&object.type=Article",
+			want:  vocab.IRIs{createNote.ID, createArticle.ID, announceNote.ID},
+		},
+		"type and object type": {
+			query: "type=Create&object.type=Note",
+			want:  vocab.IRIs{createNote.ID},
+		},
+		"types and object types": {
+			query: "type=Create,Announce&object.type=Article",
+			want:  vocab.IRIs{createArticle.ID},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/actors/johndoe/outbox?"+tt.query, nil)
+			q := r.URL.Query()
+			got := filterItems(outbox, q[typeFilterKey], q[objectTypeFilterKey])
+			if len(got) != len(tt.want) {
+				t.Fatalf("filterItems() returned %d items, expected %d", len(got), len(tt.want))
+			}
+			for i, it := range got {
+				if !it.GetLink().Equals(tt.want[i], false) {
+					t.Errorf("item %d is %s, expected %s", i, it.GetLink(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOrderItems(t *testing.T) {
+	now := time.Now().UTC()
+	items := vocab.ItemCollection{
+		&vocab.Activity{ID: "https://fedbox.local/activities/a", Type: vocab.CreateType, Published: now},
+		&vocab.Activity{ID: "https://fedbox.local/activities/b", Type: vocab.CreateType, Published: now},
+		&vocab.Activity{ID: "https://fedbox.local/activities/c", Type: vocab.CreateType, Published: now},
+		&vocab.Activity{ID: "https://fedbox.local/activities/d", Type: vocab.CreateType, Published: now.Add(time.Second)},
+		&vocab.Activity{ID: "https://fedbox.local/activities/e", Type: vocab.CreateType, Published: now.Add(-time.Second)},
+	}
+	tests := map[config.OrderTieBreak]vocab.IRIs{
+		config.OrderTieBreakDesc: {
+			"https://fedbox.local/activities/d",
+			"https://fedbox.local/activities/c",
+			"https://fedbox.local/activities/b",
+			"https://fedbox.local/activities/a",
+			"https://fedbox.local/activities/e",
+		},
+		config.OrderTieBreakAsc: {
+			"https://fedbox.local/activities/d",
+			"https://fedbox.local/activities/a",
+			"https://fedbox.local/activities/b",
+			"https://fedbox.local/activities/c",
+			"https://fedbox.local/activities/e",
+		},
+	}
+	for tieBreak, want := range tests {
+		t.Run(string(tieBreak), func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				// NOTE(marius): every load can return the items in a different order
+				col := make(vocab.ItemCollection, len(items))
+				copy(col, items)
+				rand.Shuffle(len(col), func(i, j int) { col[i], col[j] = col[j], col[i] })
+
+				got := orderItems(col, tieBreak)
+				for j, it := range got {
+					if !it.GetLink().Equals(want[j], false) {
+						t.Fatalf("load %d: item %d is %s, expected %s", i, j, it.GetLink(), want[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOrderItemsFor(t *testing.T) {
+	now := time.Now().UTC()
+	items := vocab.ItemCollection{
+		&vocab.Activity{ID: "https://fedbox.local/activities/a", Type: vocab.CreateType, Published: now.Add(-time.Hour)},
+		&vocab.Activity{ID: "https://fedbox.local/activities/b", Type: vocab.CreateType, Published: now},
+		&vocab.Activity{ID: "https://fedbox.local/activities/c", Type: vocab.CreateType, Published: now.Add(time.Hour)},
+	}
+	tests := map[string]vocab.IRIs{
+		"":            {"https://fedbox.local/activities/c", "https://fedbox.local/activities/b", "https://fedbox.local/activities/a"},
+		"?order=desc": {"https://fedbox.local/activities/c", "https://fedbox.local/activities/b", "https://fedbox.local/activities/a"},
+		"?order=asc":  {"https://fedbox.local/activities/a", "https://fedbox.local/activities/b", "https://fedbox.local/activities/c"},
+	}
+	for query, want := range tests {
+		t.Run(query, func(t *testing.T) {
+			col := make(vocab.ItemCollection, len(items))
+			copy(col, items)
+			rand.Shuffle(len(col), func(i, j int) { col[i], col[j] = col[j], col[i] })
+			before := make(vocab.ItemCollection, len(col))
+			copy(before, col)
+
+			r := httptest.NewRequest(http.MethodGet, "/outbox"+query, nil)
+			got := orderItemsFor(r, col, config.OrderTieBreakDesc)
+			for j, it := range got {
+				if !it.GetLink().Equals(want[j], false) {
+					t.Errorf("item %d is %s, expected %s", j, it.GetLink(), want[j])
+				}
+			}
+			// NOTE(marius): the items can be the ones of a cached collection, shared with the other requests
+			for j, it := range col {
+				if it != before[j] {
+					t.Errorf("orderItemsFor() modified the items it received, item %d is %s, was %s", j, it.GetLink(), before[j].GetLink())
+				}
+			}
+		})
+	}
+}
+
+func TestWithOrderParam(t *testing.T) {
+	page := &vocab.OrderedCollectionPage{
+		ID:   "https://fedbox.local/outbox?maxItems=2",
+		Type: vocab.OrderedCollectionPageType,
+		Next: vocab.IRI("https://fedbox.local/outbox?after=b&maxItems=2"),
+	}
+	col := &vocab.OrderedCollection{
+		ID:    "https://fedbox.local/outbox",
+		Type:  vocab.OrderedCollectionType,
+		First: page,
+	}
+	withOrderParam(httptest.NewRequest(http.MethodGet, "/outbox?order=asc", nil), col)
+	if next := page.Next.GetLink(); next != "https://fedbox.local/outbox?after=b&maxItems=2&order=asc" {
+		t.Errorf("next page is %s, expected it to keep the ascending order", next)
+	}
+
+	desc := &vocab.OrderedCollection{ID: "https://fedbox.local/outbox", Type: vocab.OrderedCollectionType, First: vocab.IRI("https://fedbox.local/outbox?maxItems=2")}
+	withOrderParam(httptest.NewRequest(http.MethodGet, "/outbox", nil), desc)
+	if first := desc.First.GetLink(); first != "https://fedbox.local/outbox?maxItems=2" {
+		t.Errorf("first page is %s, expected it to be unchanged", first)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "default", query: "", want: 100},
+		{name: "invalid", query: "?maxItems=many", want: 100},
+		{name: "zero", query: "?maxItems=0", want: 100},
+		{name: "under the cap", query: "?maxItems=20", want: 20},
+		{name: "at the cap", query: "?maxItems=500", want: 500},
+		{name: "over the cap", query: "?maxItems=10000", want: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/outbox"+tt.query, nil)
+			if got := pageSize(r, 100, 500); got != tt.want {
+				t.Errorf("pageSize() = %d, expected %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func writeHealthStatus(w http.ResponseWriter, status int, s healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(s)
+}
+
+// checkReady verifies that the storage is reachable, by loading the self actor of the instance
+func checkReady(db processing.ReadStore, self vocab.IRI) error {
+	if len(self) == 0 {
+		return errors.Newf("the instance's self service is not loaded")
+	}
+	if db == nil {
+		return errors.Newf("the storage is not initialized")
+	}
+	it, err := db.Load(self)
+	if err != nil {
+		return errors.Annotatef(err, "storage is unreachable")
+	}
+	if vocab.IsNil(it) {
+		return errors.Newf("the instance's self service %s could not be found in storage", self)
+	}
+	return nil
+}
+
+// HandleHealthz serves the liveness probe, which succeeds whenever the process is up
+func HandleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeHealthStatus(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+func handleReadyz(db processing.ReadStore, self vocab.IRI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkReady(db, self); err != nil {
+			writeHealthStatus(w, http.StatusServiceUnavailable, healthStatus{Status: "unavailable", Error: err.Error()})
+			return
+		}
+		writeHealthStatus(w, http.StatusOK, healthStatus{Status: "ok"})
+	}
+}
+
+// HandleReadyz serves the readiness probe, which succeeds only when the storage is reachable
+// and the instance's self service has been loaded
+func HandleReadyz(fb FedBOX) http.HandlerFunc {
+	return handleReadyz(fb.storage, fb.self.GetLink())
+}
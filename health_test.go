@@ -0,0 +1,72 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+type mockFailingStore struct{}
+
+func (mockFailingStore) Load(iri vocab.IRI) (vocab.Item, error) {
+	return nil, errors.Newf("connection refused")
+}
+
+func TestHandleHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("HandleHealthz() returned status %d, expected %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestHandleReadyz(t *testing.T) {
+	self := &vocab.Actor{ID: "https://fedbox.local/", Type: vocab.ServiceType}
+
+	tests := []struct {
+		name       string
+		db         processing.ReadStore
+		self       vocab.IRI
+		wantStatus int
+	}{
+		{
+			name:       "healthy",
+			db:         mockStore{self.ID: self},
+			self:       self.ID,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "storage failure",
+			db:         mockFailingStore{},
+			self:       self.ID,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "self not loaded",
+			db:         mockStore{self.ID: self},
+			self:       "",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleReadyz(tt.db, tt.self)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("handleReadyz() returned status %d, expected %d", w.Code, tt.wantStatus)
+			}
+			s := healthStatus{}
+			if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+				t.Fatalf("unable to decode the response body: %s", err)
+			}
+			if tt.wantStatus != http.StatusOK && len(s.Error) == 0 {
+				t.Errorf("handleReadyz() response should contain the error for the degraded state")
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package fedbox
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/processing"
+)
+
+// idempotencyKeyHeader is the header the C2S clients use for identifying the requests they retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+type idempotentResult struct {
+	iri     vocab.IRI
+	expires time.Time
+}
+
+// idempotencyKeys keeps the IRIs of the activities created by the outbox requests with an Idempotency-Key header,
+// so a retried request returns the activity created by the first one, instead of creating it again.
+// The keys are scoped to the actor which sent the request, and they expire after the ttl duration.
+type idempotencyKeys struct {
+	m         sync.Mutex
+	ttl       time.Duration
+	results   map[string]idempotentResult
+	lastPrune time.Time
+}
+
+func newIdempotencyKeys(ttl time.Duration) *idempotencyKeys {
+	if ttl <= 0 {
+		return nil
+	}
+	return &idempotencyKeys{ttl: ttl, results: make(map[string]idempotentResult)}
+}
+
+func idempotencyScope(actor vocab.IRI, key string) string {
+	return actor.String() + " " + key
+}
+
+// get returns the IRI of the activity created by the actor's request with the key Idempotency-Key
+func (i *idempotencyKeys) get(actor vocab.IRI, key string, now time.Time) (vocab.IRI, bool) {
+	if i == nil || key == "" {
+		return "", false
+	}
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	res, ok := i.results[idempotencyScope(actor, key)]
+	if !ok || now.After(res.expires) {
+		return "", false
+	}
+	return res.iri, true
+}
+
+// set stores the IRI of the activity created by the actor's request with the key Idempotency-Key
+func (i *idempotencyKeys) set(actor vocab.IRI, key string, iri vocab.IRI, now time.Time) {
+	if i == nil || key == "" {
+		return
+	}
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	i.prune(now)
+	i.results[idempotencyScope(actor, key)] = idempotentResult{iri: iri, expires: now.Add(i.ttl)}
+}
+
+// prune removes the expired keys
+func (i *idempotencyKeys) prune(now time.Time) {
+	if now.Sub(i.lastPrune) < i.ttl {
+		return
+	}
+	for key, res := range i.results {
+		if now.After(res.expires) {
+			delete(i.results, key)
+		}
+	}
+	i.lastPrune = now
+}
+
+// idempotentActivity returns the activity created by a previous request of the actor with the same
+// Idempotency-Key header as the r request.
+func (i *idempotencyKeys) idempotentActivity(db processing.ReadStore, r *http.Request, actor vocab.Actor) (vocab.Item, bool) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if isAnonymous(actor) {
+		return nil, false
+	}
+	iri, ok := i.get(actor.GetLink(), key, time.Now())
+	if !ok {
+		return nil, false
+	}
+	it, err := db.Load(iri)
+	if it = firstItem(it); err != nil || vocab.IsNil(it) || !it.GetLink().Equals(iri, false) {
+		return nil, false
+	}
+	return it, true
+}
@@ -0,0 +1,60 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	janeDoe := vocab.Actor{ID: "https://fedbox.local/actors/janedoe", Type: vocab.PersonType}
+	create := &vocab.Activity{ID: "https://fedbox.local/activities/1", Type: vocab.CreateType, Actor: johnDoe.ID}
+	db := mockStore{create.ID: create}
+
+	keys := newIdempotencyKeys(time.Hour)
+	req := func(key string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/actors/johndoe/outbox", nil)
+		r.Header.Set(idempotencyKeyHeader, key)
+		return r
+	}
+
+	if _, ok := keys.idempotentActivity(db, req("first"), johnDoe); ok {
+		t.Fatalf("idempotentActivity() found an activity for an unused key")
+	}
+	keys.set(johnDoe.ID, "first", create.ID, time.Now())
+
+	it, ok := keys.idempotentActivity(db, req("first"), johnDoe)
+	if !ok {
+		t.Fatalf("idempotentActivity() didn't find the activity of the duplicate submission")
+	}
+	if !it.GetLink().Equals(create.ID, false) {
+		t.Errorf("idempotentActivity() returned %s, expected %s", it.GetLink(), create.ID)
+	}
+	if _, ok = keys.idempotentActivity(db, req("first"), janeDoe); ok {
+		t.Errorf("idempotentActivity() returned the activity of another actor")
+	}
+	if _, ok = keys.idempotentActivity(db, req("second"), johnDoe); ok {
+		t.Errorf("idempotentActivity() returned an activity for a different key")
+	}
+	if _, ok = keys.idempotentActivity(db, req(""), johnDoe); ok {
+		t.Errorf("idempotentActivity() returned an activity for a request without key")
+	}
+
+	if _, ok = keys.get(johnDoe.ID, "first", time.Now().Add(2*time.Hour)); ok {
+		t.Errorf("get() returned an expired key")
+	}
+	keys.set(janeDoe.ID, "other", create.ID, time.Now().Add(3*time.Hour))
+	if _, ok = keys.results[idempotencyScope(johnDoe.ID, "first")]; ok {
+		t.Errorf("the expired key has not been pruned")
+	}
+
+	disabled := newIdempotencyKeys(0)
+	disabled.set(johnDoe.ID, "first", create.ID, time.Now())
+	if _, ok = disabled.idempotentActivity(db, req("first"), johnDoe); ok {
+		t.Errorf("idempotentActivity() returned an activity with the keys disabled")
+	}
+}
@@ -0,0 +1,72 @@
+package fedbox
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+)
+
+// instanceActorPath is the stable path where we publish the self service, as the instance actor which signs
+// the requests made on behalf of the server
+const instanceActorPath = "/actor"
+
+// serviceKey returns the private key of the self service, as saved in its metadata.
+// It is the key matching the public key published by the instance actor.
+func serviceKey(meta storage.MetadataTyper, self vocab.IRI) (crypto.PrivateKey, error) {
+	m, err := meta.LoadMetadata(self)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load the metadata of %s", self)
+	}
+	if m == nil || len(m.PrivateKey) == 0 {
+		return nil, errors.NotFoundf("no private key found for %s", self)
+	}
+	b, _ := pem.Decode(m.PrivateKey)
+	if b == nil {
+		return nil, errors.NotValidf("invalid private key PEM for %s", self)
+	}
+	if b.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(b.Bytes)
+	}
+	prv, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "invalid private key for %s", self)
+	}
+	return prv, nil
+}
+
+func handleInstanceActor(db processing.ReadStore, self vocab.IRI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		act, err := ap.LoadActor(db, self)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if !act.ID.Equals(self, false) {
+			errors.HandleError(errors.NotFoundf("instance actor not found")).ServeHTTP(w, r)
+			return
+		}
+		act.Clean()
+		data, err := vocab.MarshalJSON(&act)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// HandleInstanceActor serves the self service with its public key, which the remote servers use for verifying
+// the requests signed by the instance.
+func HandleInstanceActor(fb FedBOX) http.HandlerFunc {
+	return handleInstanceActor(fb.storage, fb.self.GetLink())
+}
@@ -0,0 +1,58 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+func TestHandleInstanceActor(t *testing.T) {
+	for _, typ := range []string{KeyTypeRSA, KeyTypeED25519} {
+		t.Run(typ, func(t *testing.T) {
+			selfIRI := vocab.IRI("https://fedbox.local/")
+			self := ap.Self(selfIRI)
+			meta := make(mockMetadata)
+			if err := AddKeyToPerson(meta, typ, config.KeyEncodingPKIX)(&self); err != nil {
+				t.Fatalf("AddKeyToPerson() error = %s", err)
+			}
+			db := mockStore{self.ID: &self}
+
+			w := httptest.NewRecorder()
+			handleInstanceActor(db, selfIRI)(w, httptest.NewRequest(http.MethodGet, instanceActorPath, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("handleInstanceActor() status %d, expected %d", w.Code, http.StatusOK)
+			}
+			it, err := vocab.UnmarshalJSON(w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("unable to unmarshal the instance actor: %s", err)
+			}
+			act, err := vocab.ToActor(it)
+			if err != nil {
+				t.Fatalf("the instance actor is not an actor: %s", err)
+			}
+			if !act.ID.Equals(selfIRI, false) || act.Type != vocab.ServiceType {
+				t.Errorf("handleInstanceActor() returned %s %s, expected the %s service", act.Type, act.ID, selfIRI)
+			}
+			if act.PublicKey.PublicKeyPem == "" {
+				t.Fatalf("the instance actor should publish its public key")
+			}
+
+			prv, err := serviceKey(meta, selfIRI)
+			if err != nil {
+				t.Fatalf("serviceKey() returned error %s", err)
+			}
+			_, pub := parsePublicKey(t, act.PublicKey.PublicKeyPem)
+			verifySignature(t, prv, pub)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handleInstanceActor(mockStore{}, "https://fedbox.local/")(w, httptest.NewRequest(http.MethodGet, instanceActorPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("handleInstanceActor() status %d for a missing service, expected %d", w.Code, http.StatusNotFound)
+	}
+}
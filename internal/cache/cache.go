@@ -1,18 +1,37 @@
 package cache
 
 import (
+	"container/list"
 	"path"
+	"strings"
 	"sync"
+	"time"
 
 	vocab "github.com/go-ap/activitypub"
 )
 
 type (
 	iriMap map[vocab.IRI]vocab.Item
-	store  struct {
+	// entry is the bookkeeping for an item in the least recently used list.
+	// The deps are the IRIs of the members of a cached collection, which invalidate it when they change.
+	entry struct {
+		iri     vocab.IRI
+		expires time.Time
+		deps    vocab.IRIs
+	}
+	store struct {
 		enabled bool
+		size    int
+		ttl     time.Duration
 		w       sync.RWMutex
 		c       iriMap
+		lru     *list.List
+		el      map[vocab.IRI]*list.Element
+		// deps maps the IRIs of the collection members to the keys of the cached collections containing them
+		deps map[vocab.IRI]map[vocab.IRI]struct{}
+		// hits and misses count the lookups, they're guarded by the write lock
+		hits   uint64
+		misses uint64
 	}
 	CanStore interface {
 		Set(iri vocab.IRI, it vocab.Item)
@@ -21,20 +40,55 @@ type (
 	}
 )
 
-func New(enabled bool) *store {
-	return &store{enabled: enabled, c: make(iriMap)}
+// now is used for computing the expiration of the entries, it can be replaced in tests
+var now = time.Now
+
+// New returns a cache holding at most size entries, evicting the least recently used ones when full,
+// and expiring them after ttl. A zero size or ttl means no limit.
+func New(enabled bool, size int, ttl time.Duration) *store {
+	return &store{
+		enabled: enabled,
+		size:    size,
+		ttl:     ttl,
+		c:       make(iriMap),
+		lru:     list.New(),
+		el:      make(map[vocab.IRI]*list.Element),
+		deps:    make(map[vocab.IRI]map[vocab.IRI]struct{}),
+	}
 }
 
 func (r *store) Get(iri vocab.IRI) vocab.Item {
 	if r == nil || !r.enabled {
 		return nil
 	}
+	// NOTE(marius): we need a write lock, as reading moves the entry to the front of the LRU list
+	r.w.Lock()
+	defer r.w.Unlock()
+	it, ok := r.c[iri]
+	if !ok {
+		r.misses++
+		return nil
+	}
+	if el, ok := r.el[iri]; ok {
+		if e := el.Value.(*entry); !e.expires.IsZero() && now().After(e.expires) {
+			r.remove(iri)
+			r.misses++
+			return nil
+		}
+		r.lru.MoveToFront(el)
+	}
+	r.hits++
+	return it
+}
+
+// Stats returns the number of lookups which have found an entry in the cache, and of the ones that haven't
+func (r *store) Stats() (uint64, uint64) {
+	if r == nil {
+		return 0, 0
+	}
 	r.w.RLock()
 	defer r.w.RUnlock()
-	if it, ok := r.c[iri]; ok {
-		return it
-	}
-	return nil
+	return r.hits, r.misses
 }
 
 func (r *store) Set(iri vocab.IRI, it vocab.Item) {
@@ -46,7 +100,98 @@ func (r *store) Set(iri vocab.IRI, it vocab.Item) {
 	if r.c == nil {
 		r.c = make(map[vocab.IRI]vocab.Item)
 	}
+	if r.lru == nil {
+		r.lru = list.New()
+		r.el = make(map[vocab.IRI]*list.Element)
+	}
+	if r.deps == nil {
+		r.deps = make(map[vocab.IRI]map[vocab.IRI]struct{})
+	}
+	if _, ok := r.el[iri]; ok {
+		r.remove(iri)
+	}
 	r.c[iri] = it
+
+	e := &entry{iri: iri, deps: members(it)}
+	if r.ttl > 0 {
+		e.expires = now().Add(r.ttl)
+	}
+	r.el[iri] = r.lru.PushFront(e)
+	for _, dep := range e.deps {
+		if r.deps[dep] == nil {
+			r.deps[dep] = make(map[vocab.IRI]struct{})
+		}
+		r.deps[dep][iri] = struct{}{}
+	}
+	for r.size > 0 && r.lru.Len() > r.size {
+		r.remove(r.lru.Back().Value.(*entry).iri)
+	}
+}
+
+// members returns the IRIs of the items contained in it, if it's a collection
+func members(it vocab.Item) vocab.IRIs {
+	if vocab.IsNil(it) || !it.IsCollection() {
+		return nil
+	}
+	var deps vocab.IRIs
+	vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		deps = make(vocab.IRIs, 0, col.Count())
+		for _, m := range col.Collection() {
+			if !vocab.IsNil(m) {
+				deps = append(deps, m.GetLink())
+			}
+		}
+		return nil
+	})
+	return deps
+}
+
+// remove deletes the iri entry, it must be called with the write lock held
+func (r *store) remove(iri vocab.IRI) {
+	delete(r.c, iri)
+	el, ok := r.el[iri]
+	if !ok {
+		return
+	}
+	for _, dep := range el.Value.(*entry).deps {
+		if keys, ok := r.deps[dep]; ok {
+			delete(keys, iri)
+			if len(keys) == 0 {
+				delete(r.deps, dep)
+			}
+		}
+	}
+	r.lru.Remove(el)
+	delete(r.el, iri)
+}
+
+// withoutQuery returns the iri without its query string, which the cache keys of the filtered collections contain
+func withoutQuery(iri vocab.IRI) vocab.IRI {
+	if i := strings.IndexByte(iri.String(), '?'); i >= 0 {
+		return iri[:i]
+	}
+	return iri
+}
+
+// under checks if the key cache entry is the iri item, or is one of its sub-collections
+func under(key, iri vocab.IRI) bool {
+	key = withoutQuery(key)
+	base := strings.TrimRight(withoutQuery(iri).String(), "/")
+	return key.String() == base || strings.HasPrefix(key.String(), base+"/")
+}
+
+// parent returns the IRI of the collection the iri item is saved under, eg: the objects collection for an object
+func parent(iri vocab.IRI) vocab.IRI {
+	u, err := iri.URL()
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	u.RawQuery = ""
+	u.Path = path.Dir(strings.TrimRight(u.Path, "/"))
+	if u.Path == "/" || u.Path == "." {
+		return ""
+	}
+	return vocab.IRI(u.String())
 }
 
 func (r *store) Clear() {
@@ -60,31 +205,41 @@ func (r *store) Remove(iris ...vocab.IRI) bool {
 		return true
 	}
 	if len(iris) == 0 {
+		r.w.Lock()
+		defer r.w.Unlock()
 		for key := range r.c {
-			delete(r.c, key)
+			r.remove(key)
 		}
 		return true
 	}
-	toInvalidate := vocab.IRIs(iris)
+	r.w.Lock()
+	defer r.w.Unlock()
+	toInvalidate := make(map[vocab.IRI]struct{})
 	for _, iri := range iris {
+		for key := range r.c {
+			if under(key, iri) {
+				toInvalidate[key] = struct{}{}
+			}
+		}
+		// NOTE(marius): the cached collections which contain the item
+		for key := range r.deps[iri] {
+			toInvalidate[key] = struct{}{}
+		}
 		if vocab.ValidCollectionIRI(iri) {
 			continue
 		}
-		c := vocab.IRI(path.Dir(iri.String()))
-		if !toInvalidate.Contains(c) {
-			toInvalidate = append(toInvalidate, c)
-		}
-	}
-	r.w.Lock()
-	defer r.w.Unlock()
-	for _, iri := range toInvalidate {
-		for key := range r.c {
-			// TODO(marius): I need to play around with this a bit
-			if key.Contains(iri, false) {
-				delete(r.c, key)
+		// NOTE(marius): the collection the item is saved under, which might not contain it yet
+		if p := parent(iri); p != "" {
+			for key := range r.c {
+				if withoutQuery(key) == p {
+					toInvalidate[key] = struct{}{}
+				}
 			}
 		}
 	}
+	for key := range toInvalidate {
+		r.remove(key)
+	}
 	return true
 }
 
@@ -151,18 +306,24 @@ func aggregateActivityIRIs(toRemove *vocab.IRIs, a *vocab.Activity, typ vocab.Co
 
 	withSideEffects := vocab.ActivityVocabularyTypes{vocab.UpdateType, vocab.UndoType, vocab.DeleteType}
 	if withSideEffects.Contains(a.GetType()) {
-		base := path.Dir(a.Object.GetLink().String())
-		*toRemove = append(*toRemove, vocab.IRI(base))
+		// NOTE(marius): removing the object invalidates the collections it's part of too
 		*toRemove = append(*toRemove, a.Object.GetLink())
 	}
 
 	return aggregateItemIRIs(toRemove, a.Object)
 }
 
-func ActivityPurge(cache CanStore, a *vocab.Activity, iri vocab.IRI) error {
-	toRemove := make(vocab.IRIs, 0)
+// ActivityIRIs returns the IRIs of the items and collections which are modified by the a activity
+// received in the iri collection.
+func ActivityIRIs(a *vocab.Activity, iri vocab.IRI) (vocab.IRIs, error) {
+	modified := make(vocab.IRIs, 0)
 	_, typ := vocab.Split(iri)
-	err := aggregateActivityIRIs(&toRemove, a, typ)
+	err := aggregateActivityIRIs(&modified, a, typ)
+	return modified, err
+}
+
+func ActivityPurge(cache CanStore, a *vocab.Activity, iri vocab.IRI) error {
+	toRemove, err := ActivityIRIs(a, iri)
 	if err != nil {
 		return err
 	}
@@ -1,8 +1,10 @@
 package cache
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	vocab "github.com/go-ap/activitypub"
 )
@@ -138,3 +140,145 @@ func Test_reqCache_set(t *testing.T) {
 		})
 	}
 }
+
+func Test_store_evictsLeastRecentlyUsed(t *testing.T) {
+	r := New(true, 2, 0)
+
+	r.Set("https://example.com/1", vocab.IRI("https://example.com/1"))
+	r.Set("https://example.com/2", vocab.IRI("https://example.com/2"))
+	// NOTE(marius): reading the first entry makes the second one the least recently used
+	if r.Get("https://example.com/1") == nil {
+		t.Fatalf("Get() returned nil for an existing entry")
+	}
+	r.Set("https://example.com/3", vocab.IRI("https://example.com/3"))
+
+	if r.Get("https://example.com/2") != nil {
+		t.Errorf("Get() returned the least recently used entry, which should have been evicted")
+	}
+	for _, iri := range []vocab.IRI{"https://example.com/1", "https://example.com/3"} {
+		if r.Get(iri) == nil {
+			t.Errorf("Get() returned nil for %s, which should still be cached", iri)
+		}
+	}
+	if len(r.c) != 2 || r.lru.Len() != 2 {
+		t.Errorf("cache has %d items and %d LRU entries, expected 2", len(r.c), r.lru.Len())
+	}
+
+	// NOTE(marius): setting an existing entry doesn't evict anything
+	r.Set("https://example.com/1", vocab.IRI("https://example.com/1"))
+	if len(r.c) != 2 || r.Get("https://example.com/3") == nil {
+		t.Errorf("Set() of an existing entry evicted another one")
+	}
+}
+
+func Test_store_expires(t *testing.T) {
+	defer func() { now = time.Now }()
+
+	cur := time.Now()
+	now = func() time.Time { return cur }
+
+	r := New(true, 0, time.Minute)
+	r.Set("https://example.com/1", vocab.IRI("https://example.com/1"))
+
+	cur = cur.Add(30 * time.Second)
+	r.Set("https://example.com/2", vocab.IRI("https://example.com/2"))
+	if r.Get("https://example.com/1") == nil {
+		t.Errorf("Get() returned nil for an entry which didn't expire")
+	}
+
+	cur = cur.Add(31 * time.Second)
+	if r.Get("https://example.com/1") != nil {
+		t.Errorf("Get() returned an expired entry")
+	}
+	if _, ok := r.c["https://example.com/1"]; ok {
+		t.Errorf("the expired entry was not removed from the cache")
+	}
+	if r.Get("https://example.com/2") == nil {
+		t.Errorf("Get() returned nil for an entry which didn't expire")
+	}
+}
+
+func Test_store_removeAll(t *testing.T) {
+	r := New(true, 10, time.Minute)
+	for i := 0; i < 5; i++ {
+		iri := vocab.IRI(fmt.Sprintf("https://example.com/%d", i))
+		r.Set(iri, iri)
+	}
+	r.Remove()
+	if len(r.c) != 0 || r.lru.Len() != 0 || len(r.el) != 0 {
+		t.Errorf("Remove() left %d items and %d LRU entries", len(r.c), r.lru.Len())
+	}
+}
+
+func Benchmark_store_concurrent(b *testing.B) {
+	r := New(true, 1000, time.Minute)
+	iris := make([]vocab.IRI, 2000)
+	for i := range iris {
+		iris[i] = vocab.IRI(fmt.Sprintf("https://example.com/objects/%d", i))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			iri := iris[i%len(iris)]
+			if i%4 == 0 {
+				r.Set(iri, iri)
+			} else {
+				r.Get(iri)
+			}
+			i++
+		}
+	})
+}
+
+func Test_store_removeTargeted(t *testing.T) {
+	var (
+		note1     = vocab.IRI("https://example.com/objects/1")
+		note2     = vocab.IRI("https://example.com/objects/2")
+		note10    = vocab.IRI("https://example.com/objects/10")
+		objects   = vocab.IRI("https://example.com/objects")
+		filtered  = vocab.IRI("https://example.com/objects?type=Note")
+		outbox    = vocab.IRI("https://example.com/actors/jdoe/outbox")
+		inbox     = vocab.IRI("https://example.com/actors/jdoe/inbox")
+		followers = vocab.IRI("https://example.com/actors/jdoe/followers")
+		actor     = vocab.IRI("https://example.com/actors/jdoe")
+	)
+
+	r := New(true, 0, 0)
+	for _, iri := range []vocab.IRI{note1, note2, note10, actor} {
+		r.Set(iri, &vocab.Object{ID: iri, Type: vocab.NoteType})
+	}
+	r.Set(objects, vocab.OrderedCollection{ID: objects, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{note1, note2, note10}})
+	r.Set(filtered, &vocab.OrderedCollection{ID: filtered, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{note2}})
+	r.Set(outbox, &vocab.OrderedCollection{ID: outbox, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{note1}})
+	r.Set(inbox, &vocab.OrderedCollection{ID: inbox, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{note2}})
+	r.Set(followers, &vocab.OrderedCollection{ID: followers, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{vocab.IRI("https://remote.example.com/actors/1")}})
+
+	r.Remove(note1)
+
+	for _, iri := range []vocab.IRI{note1, objects, filtered, outbox} {
+		if r.Get(iri) != nil {
+			t.Errorf("%s should have been invalidated", iri)
+		}
+	}
+	for _, iri := range []vocab.IRI{note2, note10, actor, inbox, followers} {
+		if r.Get(iri) == nil {
+			t.Errorf("%s is unrelated to %s and should still be cached", iri, note1)
+		}
+	}
+	if _, ok := r.deps[note1]; ok {
+		t.Errorf("the dependencies of the invalidated collections should have been removed")
+	}
+
+	t.Run("sub-collections", func(t *testing.T) {
+		r.Remove(actor)
+		for _, iri := range []vocab.IRI{actor, inbox, followers} {
+			if r.Get(iri) != nil {
+				t.Errorf("%s should have been invalidated together with %s", iri, actor)
+			}
+		}
+		if r.Get(note2) == nil {
+			t.Errorf("%s is unrelated to %s and should still be cached", note2, actor)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultRedisNamespace is the prefix of the keys we store in Redis, so the flushes don't touch other data
+	DefaultRedisNamespace = "fedbox"
+
+	// redisTimeout is the maximum duration of the Redis operations
+	redisTimeout = time.Second
+	// redisScanCount is the number of keys we request in a SCAN iteration
+	redisScanCount = 1000
+)
+
+// redisStore is a cache shared between multiple FedBOX processes, which stores the items serialized as JSON-LD
+// under their IRI. The collections members are kept in separate sets, to invalidate the collections when
+// one of their members changes.
+type redisStore struct {
+	ns  string
+	ttl time.Duration
+	c   *redis.Client
+}
+
+// NewRedis returns a cache backed by the Redis server at the url connection URL, eg: "redis://localhost:6379/0",
+// with the entries expiring after ttl. A zero ttl means no expiration.
+// It returns an error if the server is unreachable.
+func NewRedis(url string, ttl time.Duration) (*redisStore, error) {
+	opt, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid Redis URL")
+	}
+	r := redisStore{ns: DefaultRedisNamespace, ttl: ttl, c: redis.NewClient(opt)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err = r.c.Ping(ctx).Err(); err != nil {
+		r.c.Close()
+		return nil, errors.Annotatef(err, "unable to connect to Redis at %s", opt.Addr)
+	}
+	return &r, nil
+}
+
+func (r *redisStore) key(iri vocab.IRI) string {
+	return r.ns + ":item:" + iri.String()
+}
+
+func (r *redisStore) depsKey(iri vocab.IRI) string {
+	return r.ns + ":deps:" + iri.String()
+}
+
+func (r *redisStore) Get(iri vocab.IRI) vocab.Item {
+	if r == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	raw, err := r.c.Get(ctx, r.key(iri)).Bytes()
+	if err != nil {
+		return nil
+	}
+	it, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		return nil
+	}
+	return it
+}
+
+func (r *redisStore) Set(iri vocab.IRI, it vocab.Item) {
+	if r == nil || vocab.IsNil(it) {
+		return
+	}
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_, _ = r.c.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, r.key(iri), raw, r.ttl)
+		for _, dep := range members(it) {
+			p.SAdd(ctx, r.depsKey(dep), iri.String())
+			if r.ttl > 0 {
+				p.Expire(ctx, r.depsKey(dep), r.ttl)
+			}
+		}
+		return nil
+	})
+}
+
+// scan returns the keys matching the pattern
+func (r *redisStore) scan(ctx context.Context, pattern string) []string {
+	keys := make([]string, 0)
+	it := r.c.Scan(ctx, 0, pattern, redisScanCount).Iterator()
+	for it.Next(ctx) {
+		keys = append(keys, it.Val())
+	}
+	return keys
+}
+
+// Remove invalidates the iris items, the collections containing them and their sub-collections,
+// using the same rules as the in-memory cache. Without any IRIs, it removes all the keys in our namespace.
+func (r *redisStore) Remove(iris ...vocab.IRI) bool {
+	if r == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	toInvalidate := make([]string, 0)
+	if len(iris) == 0 {
+		toInvalidate = r.scan(ctx, r.ns+":*")
+	}
+	for _, iri := range iris {
+		base := withoutQuery(iri)
+		toInvalidate = append(toInvalidate, r.key(base))
+		toInvalidate = append(toInvalidate, r.scan(ctx, redisEscape(r.key(base))+"[/?]*")...)
+		for _, col := range r.c.SMembers(ctx, r.depsKey(iri)).Val() {
+			toInvalidate = append(toInvalidate, r.key(vocab.IRI(col)))
+		}
+		toInvalidate = append(toInvalidate, r.depsKey(iri))
+		if vocab.ValidCollectionIRI(iri) {
+			continue
+		}
+		if p := parent(iri); p != "" {
+			toInvalidate = append(toInvalidate, r.key(p))
+			toInvalidate = append(toInvalidate, r.scan(ctx, redisEscape(r.key(p))+"[?]*")...)
+		}
+	}
+	if len(toInvalidate) == 0 {
+		return true
+	}
+	return r.c.Del(ctx, toInvalidate...).Err() == nil
+}
+
+// Close closes the connection to the Redis server
+func (r *redisStore) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// redisEscape escapes the glob special characters of the Redis SCAN patterns
+func redisEscape(s string) string {
+	esc := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			esc = append(esc, '\\')
+		}
+		esc = append(esc, s[i])
+	}
+	return string(esc)
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	vocab "github.com/go-ap/activitypub"
+)
+
+func testRedis(t *testing.T, ttl time.Duration) (*miniredis.Miniredis, *redisStore) {
+	srv := miniredis.RunT(t)
+	r, err := NewRedis("redis://"+srv.Addr()+"/0", ttl)
+	if err != nil {
+		t.Fatalf("NewRedis() error = %s", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return srv, r
+}
+
+func Test_redisStore_SetGet(t *testing.T) {
+	_, r := testRedis(t, 0)
+
+	note := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguageValue("test")}
+	r.Set(note.ID, note)
+
+	got := r.Get(note.ID)
+	if vocab.IsNil(got) {
+		t.Fatalf("Get() returned nil for %s", note.ID)
+	}
+	if got.GetType() != vocab.NoteType || !got.GetLink().Equals(note.ID, false) {
+		t.Errorf("Get() = %s %s, expected %s %s", got.GetType(), got.GetLink(), note.Type, note.ID)
+	}
+	if r.Get("https://example.com/objects/2") != nil {
+		t.Errorf("Get() returned an item for a missing IRI")
+	}
+}
+
+func Test_redisStore_TTL(t *testing.T) {
+	srv, r := testRedis(t, time.Minute)
+
+	iri := vocab.IRI("https://example.com/objects/1")
+	r.Set(iri, &vocab.Object{ID: iri, Type: vocab.NoteType})
+	if ttl := srv.TTL(r.key(iri)); ttl != time.Minute {
+		t.Errorf("the key has TTL %s, expected %s", ttl, time.Minute)
+	}
+	srv.FastForward(2 * time.Minute)
+	if r.Get(iri) != nil {
+		t.Errorf("Get() returned an expired entry")
+	}
+}
+
+func Test_redisStore_Remove(t *testing.T) {
+	srv, r := testRedis(t, 0)
+	// NOTE(marius): other applications' keys must survive our flushes
+	srv.Set("other:key", "value")
+
+	var (
+		note1   = vocab.IRI("https://example.com/objects/1")
+		note2   = vocab.IRI("https://example.com/objects/2")
+		note10  = vocab.IRI("https://example.com/objects/10")
+		objects = vocab.IRI("https://example.com/objects?type=Note")
+		outbox  = vocab.IRI("https://example.com/actors/jdoe/outbox")
+		inbox   = vocab.IRI("https://example.com/actors/jdoe/inbox")
+	)
+	for _, iri := range []vocab.IRI{note1, note2, note10} {
+		r.Set(iri, &vocab.Object{ID: iri, Type: vocab.NoteType})
+	}
+	r.Set(objects, &vocab.OrderedCollection{ID: objects, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{note2, note10}})
+	r.Set(outbox, &vocab.OrderedCollection{ID: outbox, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{note1}})
+	r.Set(inbox, &vocab.OrderedCollection{ID: inbox, Type: vocab.OrderedCollectionType, OrderedItems: vocab.ItemCollection{note2}})
+
+	r.Remove(note1)
+	for _, iri := range []vocab.IRI{note1, objects, outbox} {
+		if r.Get(iri) != nil {
+			t.Errorf("%s should have been invalidated", iri)
+		}
+	}
+	for _, iri := range []vocab.IRI{note2, note10, inbox} {
+		if r.Get(iri) == nil {
+			t.Errorf("%s is unrelated to %s and should still be cached", iri, note1)
+		}
+	}
+
+	r.Remove()
+	for _, key := range srv.Keys() {
+		if key != "other:key" {
+			t.Errorf("Remove() left key %s", key)
+		}
+	}
+	if !srv.Exists("other:key") {
+		t.Errorf("Remove() deleted a key outside the %s namespace", r.ns)
+	}
+}
+
+func TestNewRedis_unreachable(t *testing.T) {
+	srv := miniredis.RunT(t)
+	addr := srv.Addr()
+	srv.Close()
+
+	if _, err := NewRedis("redis://"+addr+"/0", 0); err == nil {
+		t.Errorf("NewRedis() expected error for unreachable server")
+	}
+	if _, err := NewRedis("invalid://", 0); err == nil {
+		t.Errorf("NewRedis() expected error for invalid URL")
+	}
+}
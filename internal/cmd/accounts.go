@@ -22,6 +22,7 @@ var AccountsCmd = &cli.Command{
 		exportAccountsMetadataCmd,
 		importAccountsMetadataCmd,
 		generateKeysCmd,
+		likedVisibilityCmd,
 	},
 }
 
@@ -164,10 +165,16 @@ var generateKeysCmd = &cli.Command{
 }
 
 func AddKeyToItem(metaSaver storage.MetadataTyper, it vocab.Item, typ string) error {
-	if err := vocab.OnActor(it, fedbox.AddKeyToPerson(metaSaver, typ)); err != nil {
+	return ctl.AddKey(metaSaver, it, typ)
+}
+
+// AddKey generates a key pair of the typ type for the it actor, saving the private key in its metadata
+// and the public one in the actor.
+func (c *Control) AddKey(metaSaver storage.MetadataTyper, it vocab.Item, typ string) error {
+	if err := vocab.OnActor(it, fedbox.AddKeyToPerson(metaSaver, typ, c.Conf.PublicKeyEncoding)); err != nil {
 		return errors.Annotatef(err, "failed to process actor: %s", it.GetID())
 	}
-	if _, err := ctl.Storage.Save(it); err != nil {
+	if _, err := c.Storage.Save(it); err != nil {
 		return errors.Annotatef(err, "failed to save actor: %s", it.GetID())
 	}
 	return nil
@@ -225,3 +232,49 @@ func generateKeys(ctl *Control) cli.ActionFunc {
 		return nil
 	}
 }
+
+var likedVisibilityCmd = &cli.Command{
+	Name:  "liked",
+	Usage: "Sets the visibility of the liked collection for actors",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "private",
+			Usage: "Hide the liked collection from everybody except its owner",
+		},
+	},
+	ArgsUsage: "IRI...",
+	Action:    setLikedVisibility(&ctl),
+}
+
+func setLikedVisibility(ctl *Control) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		private := c.Bool("private")
+		for i := 0; i < c.Args().Len(); i++ {
+			iri := vocab.IRI(c.Args().Get(i))
+			if err := ctl.SetLikedVisibility(iri, private); err != nil {
+				Errf("Error: %s\n", err)
+				continue
+			}
+			fmt.Printf("Updated liked collection for: %s\n", iri)
+		}
+		return nil
+	}
+}
+
+// SetLikedVisibility saves in the metadata of the actor identified by iri if its liked collection is private,
+// visible only to its owner, or public.
+func (c *Control) SetLikedVisibility(iri vocab.IRI, private bool) error {
+	metaSaver, ok := c.Storage.(storage.MetadataTyper)
+	if !ok {
+		return errors.Newf("storage doesn't support saving metadata")
+	}
+	it, err := c.Storage.Load(iri)
+	if err != nil {
+		return err
+	}
+	act, err := vocab.ToActor(it)
+	if err != nil {
+		return errors.Annotatef(err, "%s is not a valid actor", iri)
+	}
+	return fedbox.SetCollectionVisibility(metaSaver, act.GetLink(), vocab.Liked, private)
+}
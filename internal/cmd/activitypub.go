@@ -3,6 +3,7 @@ package cmd
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"sort"
@@ -41,14 +42,159 @@ var actorsCmd = &cli.Command{
 	Usage: "Actor management helper",
 	Subcommands: []*cli.Command{
 		addActor,
+		delActor,
+		passwdCmd,
+		rotateKeyCmd,
 	},
 }
 
+var passwdCmd = &cli.Command{
+	Name:  "passwd",
+	Usage: "Sets the password of an actor",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "Read the password from the standard input, instead of prompting for it",
+		},
+	},
+	ArgsUsage: "IRI",
+	Action:    passwdAct(&ctl),
+}
+
+func passwdAct(ctl *Control) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.Args().Len() != 1 {
+			return errors.Errorf("Missing the IRI of the actor")
+		}
+		iri := vocab.IRI(c.Args().First())
+		var pw []byte
+		var err error
+		if c.Bool("password-stdin") {
+			pw, err = io.ReadAll(os.Stdin)
+			pw = bytes.TrimRight(pw, "\r\n")
+		} else {
+			pw, err = loadPwFromStdin(true, "%s's", iri)
+		}
+		if err != nil {
+			return err
+		}
+		if err = ctl.SetPassword(iri, pw); err != nil {
+			return err
+		}
+		fmt.Printf("Changed the password of %s\n", iri)
+		return nil
+	}
+}
+
+// SetPassword sets the pw password of the local actor with the iri
+func (c *Control) SetPassword(iri vocab.IRI, pw []byte) error {
+	if c.Storage == nil {
+		return errors.Errorf("invalid storage backend")
+	}
+	if len(pw) == 0 {
+		return errors.NotValidf("empty password")
+	}
+	if !iri.Contains(vocab.IRI(c.Conf.BaseURL), false) {
+		return errors.NotValidf("%s is not a local actor", iri)
+	}
+	it, err := c.Storage.Load(iri)
+	if err != nil {
+		return errors.NewNotFound(err, "unable to load actor %s", iri)
+	}
+	var act vocab.Item
+	vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		it = col.Collection().First()
+		return nil
+	})
+	if !vocab.IsNil(it) && it.GetLink().Equals(iri, false) && vocab.ActorTypes.Contains(it.GetType()) {
+		act = it
+	}
+	if vocab.IsNil(act) {
+		return errors.NotValidf("%s is not an actor", iri)
+	}
+	return c.Storage.PasswordSet(act, pw)
+}
+
+var rotateKeyCmd = &cli.Command{
+	Name:  "rotate-key",
+	Usage: "Replaces the key pair of actors",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "key-type",
+			Usage: fmt.Sprintf("Type of keys to generate: %v", []string{fedbox.KeyTypeED25519, fedbox.KeyTypeRSA}),
+			Value: fedbox.KeyTypeED25519,
+		},
+		&cli.BoolFlag{
+			Name:  "update",
+			Usage: "Send an Update activity to the followers of the actor, so they refresh its public key",
+		},
+	},
+	ArgsUsage: "IRI...",
+	Action:    rotateKeyAct(&ctl),
+}
+
+func rotateKeyAct(ctl *Control) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.Args().Len() == 0 {
+			return errors.Errorf("Missing the actor IRI")
+		}
+		for _, iri := range c.Args().Slice() {
+			if err := ctl.RotateKey(vocab.IRI(iri), c.String("key-type"), c.Bool("update")); err != nil {
+				Errf("Error: %s\n", err)
+				continue
+			}
+			fmt.Printf("Rotated the key of: %s\n", iri)
+		}
+		return nil
+	}
+}
+
+// RotateKey replaces the key pair of the actor identified by iri with a new one of the typ type.
+// When update is set, an Update of the actor is sent to its followers, so they load the new public key.
+func (c *Control) RotateKey(iri vocab.IRI, typ string, update bool) error {
+	metaSaver, ok := c.Storage.(s.MetadataTyper)
+	if !ok {
+		return errors.Newf("storage doesn't support saving key")
+	}
+	act, err := ap.LoadActor(c.Storage, iri)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if err = fedbox.RotateKey(metaSaver, &act, typ, c.Conf.PublicKeyEncoding, now); err != nil {
+		return err
+	}
+	act.Updated = now
+	if _, err = c.Storage.Save(&act); err != nil {
+		return errors.Annotatef(err, "failed to save actor: %s", act.GetID())
+	}
+	if !update {
+		return nil
+	}
+	u := new(vocab.Update)
+	u.Type = vocab.UpdateType
+	u.Actor = act.GetLink()
+	u.Object = &act
+	u.To = vocab.ItemCollection{vocab.PublicNS}
+	u.CC = vocab.ItemCollection{vocab.Followers.IRI(act)}
+	u.Published = now
+	_, err = c.Saver.ProcessClientActivity(u, vocab.Outbox.Of(act).GetLink())
+	return err
+}
+
 var addActor = &cli.Command{
 	Name:    "add",
 	Aliases: []string{"new"},
 	Usage:   "Adds an ActivityPub actor",
 	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "username",
+			Usage: "The username of the actor, it can also be passed as an argument",
+		},
+		&cli.BoolFlag{
+			Name:  "no-password",
+			Usage: "Don't ask for a password, the actor will not be able to log in",
+		},
 		&cli.StringFlag{
 			Name:  "type",
 			Usage: fmt.Sprintf("The type of activitypub actor to add"),
@@ -72,8 +218,11 @@ var addActor = &cli.Command{
 
 func addActorAct(ctl *Control) cli.ActionFunc {
 	return func(c *cli.Context) error {
-		keyType := c.String("keyType")
+		keyType := c.String("key-type")
 		names := c.Args().Slice()
+		if username := c.String("username"); username != "" {
+			names = append(names, username)
+		}
 		if len(names) == 0 {
 			name, err := loadFromStdin("Enter the actor's name")
 			if err != nil {
@@ -115,9 +264,11 @@ func addActorAct(ctl *Control) cli.ActionFunc {
 
 		var actors = make(vocab.ItemCollection, 0)
 		for _, name := range names {
-			pw, err := loadPwFromStdin(true, "%s's", name)
-			if err != nil {
-				return err
+			var pw []byte
+			if !c.Bool("no-password") {
+				if pw, err = loadPwFromStdin(true, "%s's", name); err != nil {
+					return err
+				}
 			}
 			typ := vocab.ActivityVocabularyType(c.String("type"))
 			if !vocab.ActorTypes.Contains(typ) {
@@ -146,12 +297,12 @@ func addActorAct(ctl *Control) cli.ActionFunc {
 				//Errf("Error adding %s: %s\n", name, err)
 				return err
 			}
-			fmt.Printf("Added %q [%s]: %s\n", typ, name, p.GetLink())
 			if metaSaver, ok := ctl.Storage.(s.MetadataTyper); ok {
-				if err := AddKeyToItem(metaSaver, p, keyType); err != nil {
+				if err := ctl.AddKey(metaSaver, p, keyType); err != nil {
 					Errf("Error saving metadata for %s: %s", name, err)
 				}
 			}
+			fmt.Printf("Added %q [%s]: %s\n", typ, name, p.GetLink())
 			actors = append(actors, p)
 		}
 		return nil
@@ -229,6 +380,9 @@ func (c *Control) AddActor(p *vocab.Person, pw []byte, author *vocab.Actor) (*vo
 		return nil, errors.NotFoundf("unable to load current's instance Application actor: %s", c.Conf.BaseURL)
 	}
 
+	for _, col := range actorCollections {
+		setActorCollection(p, col)
+	}
 	create, err := wrapObjectInCreate(p, author)
 	if err != nil {
 		return nil, errors.Annotatef(err, "unable to wrap Actor in Create activity")
@@ -242,6 +396,9 @@ func (c *Control) AddActor(p *vocab.Person, pw []byte, author *vocab.Actor) (*vo
 	if _, err := c.Saver.ProcessClientActivity(create, outbox.GetLink()); err != nil {
 		return nil, err
 	}
+	if err = c.createActorCollections(p); err != nil {
+		return nil, err
+	}
 
 	if pwManager, ok := c.Storage.(s.PasswordChanger); ok && pw != nil {
 		err = pwManager.PasswordSet(p.GetLink(), pw)
@@ -249,6 +406,119 @@ func (c *Control) AddActor(p *vocab.Person, pw []byte, author *vocab.Actor) (*vo
 	return p, err
 }
 
+// actorCollections are the collections that the actors added by us own
+var actorCollections = vocab.CollectionPaths{vocab.Inbox, vocab.Outbox, vocab.Followers, vocab.Following, vocab.Liked}
+
+// setActorCollection sets the IRI of the col collection of the p actor, if it doesn't have one already
+func setActorCollection(p *vocab.Actor, col vocab.CollectionPath) {
+	iri := col.IRI(p)
+	switch col {
+	case vocab.Inbox:
+		if vocab.IsNil(p.Inbox) {
+			p.Inbox = iri
+		}
+	case vocab.Outbox:
+		if vocab.IsNil(p.Outbox) {
+			p.Outbox = iri
+		}
+	case vocab.Followers:
+		if vocab.IsNil(p.Followers) {
+			p.Followers = iri
+		}
+	case vocab.Following:
+		if vocab.IsNil(p.Following) {
+			p.Following = iri
+		}
+	case vocab.Liked:
+		if vocab.IsNil(p.Liked) {
+			p.Liked = iri
+		}
+	}
+}
+
+// createActorCollections creates the collections of the p actor which are missing from the storage
+func (c *Control) createActorCollections(p *vocab.Actor) error {
+	colSaver, ok := c.Storage.(processing.CollectionStore)
+	if !ok {
+		return nil
+	}
+	for _, col := range actorCollections {
+		iri := col.IRI(p)
+		if _, err := c.Storage.Load(iri); err == nil || !errors.IsNotFound(err) {
+			continue
+		}
+		owned := &vocab.OrderedCollection{
+			ID:           iri,
+			Type:         vocab.OrderedCollectionType,
+			AttributedTo: p.GetLink(),
+			Published:    p.Published,
+		}
+		if _, err := colSaver.Create(owned); err != nil {
+			return errors.Annotatef(err, "unable to create the %s collection of %s", col, p.GetLink())
+		}
+	}
+	return nil
+}
+
+var delActor = &cli.Command{
+	Name:      "delete",
+	Aliases:   []string{"del", "rm"},
+	Usage:     "Removes ActivityPub actors, together with their collections",
+	ArgsUsage: "IRI...",
+	Action:    delActorAct(&ctl),
+}
+
+func delActorAct(ctl *Control) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.Args().Len() == 0 {
+			return errors.Errorf("Missing the IRI of the actor to remove")
+		}
+		for _, iri := range c.Args().Slice() {
+			if err := ctl.DeleteActor(vocab.IRI(iri)); err != nil {
+				return err
+			}
+			fmt.Printf("Removed %s\n", iri)
+		}
+		return nil
+	}
+}
+
+// DeleteActor removes the local actor with the iri from the storage, together with its collections.
+// Unlike the deletion with a Delete activity, which keeps a Tombstone in its place, nothing of the actor remains.
+func (c *Control) DeleteActor(iri vocab.IRI) error {
+	if c.Storage == nil {
+		return errors.Errorf("invalid storage backend")
+	}
+	if !iri.Contains(vocab.IRI(c.Conf.BaseURL), false) {
+		return errors.NotValidf("%s is not a local actor", iri)
+	}
+	act, err := ap.LoadActor(c.Storage, iri)
+	if err != nil {
+		return errors.NewNotFound(err, "unable to load actor %s", iri)
+	}
+	if !act.GetLink().Equals(iri, false) || !vocab.ActorTypes.Contains(act.GetType()) {
+		return errors.NotFoundf("actor %s not found", iri)
+	}
+	if act.GetLink().Equals(c.Service.GetLink(), false) {
+		return errors.NotValidf("unable to remove the service actor %s", iri)
+	}
+	for _, col := range append(actorCollections, vocab.Likes, vocab.Shares) {
+		if err = c.Storage.Delete(col.IRI(&act)); err != nil && !errors.IsNotFound(err) {
+			return errors.Annotatef(err, "unable to remove the %s collection of %s", col, iri)
+		}
+	}
+	if colSaver, ok := c.Storage.(processing.CollectionStore); ok {
+		actors := filters.ActorsType.IRI(vocab.IRI(c.Conf.BaseURL))
+		if err = colSaver.RemoveFrom(actors, iri); err != nil && !errors.IsNotFound(err) {
+			return errors.Annotatef(err, "unable to remove %s from %s", iri, actors)
+		}
+	}
+	if err = c.Storage.Delete(&act); err != nil {
+		return errors.Annotatef(err, "unable to remove actor %s", iri)
+	}
+	return nil
+}
+
 var ValidGenericTypes = vocab.ActivityVocabularyTypes{vocab.ObjectType, vocab.ActorType}
 
 var delObjectsCmd = &cli.Command{
@@ -80,12 +80,13 @@ func bootstrapAct(c *Control) cli.ActionFunc {
 type storageConf struct {
 	Storage     config.StorageType
 	Path        string
+	DSN         string
 	BaseURL     string
 	CacheEnable bool
 }
 
 func confFn(opt config.Options) storageConf {
-	return storageConf{Path: opt.BaseStoragePath(), CacheEnable: opt.StorageCache, BaseURL: opt.BaseURL, Storage: opt.Storage}
+	return storageConf{Path: opt.BaseStoragePath(), CacheEnable: opt.StorageCache, BaseURL: opt.BaseURL, Storage: opt.Storage, DSN: opt.DB.DSN()}
 }
 
 func Bootstrap(conf config.Options, service vocab.Item) error {
@@ -1,4 +1,4 @@
-//go:build storage_all || (!storage_boltdb && !storage_fs && !storage_badger && !storage_sqlite)
+//go:build storage_all || (!storage_boltdb && !storage_fs && !storage_badger && !storage_sqlite && !storage_pgx)
 
 package cmd
 
@@ -6,6 +6,7 @@ import (
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/storage/pgx"
 	"github.com/go-ap/storage-badger"
 	"github.com/go-ap/storage-boltdb"
 	"github.com/go-ap/storage-fs"
@@ -31,6 +32,9 @@ var (
 			return sqlite.Bootstrap(c, service)
 
 		}
+		if conf.Storage == config.StoragePostgres {
+			return pgx.Bootstrap(pgx.Config{DSN: conf.DSN}, service)
+		}
 		return errors.NotImplementedf("Invalid storage type %s", conf.Storage)
 	}
 	cleanFn = func(conf storageConf) error {
@@ -50,6 +54,9 @@ var (
 			conf := sqlite.Config{Path: conf.Path, CacheEnable: conf.CacheEnable}
 			return sqlite.Clean(conf)
 		}
+		if conf.Storage == config.StoragePostgres {
+			return pgx.Clean(pgx.Config{DSN: conf.DSN})
+		}
 		return errors.NotImplementedf("Invalid storage type %s", conf.Storage)
 	}
 )
@@ -0,0 +1,17 @@
+//go:build storage_pgx
+
+package cmd
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/storage/pgx"
+)
+
+var (
+	bootstrapFn = func(conf storageConf, service vocab.Item) error {
+		return pgx.Bootstrap(pgx.Config{DSN: conf.DSN}, service)
+	}
+	cleanFn = func(conf storageConf) error {
+		return pgx.Clean(pgx.Config{DSN: conf.DSN})
+	}
+)
@@ -31,11 +31,16 @@ type Control struct {
 
 func New(db fedbox.FullStorage, conf config.Options, l lw.Logger) *Control {
 	baseIRI := vocab.IRI(conf.BaseURL)
+	gen, err := ap.NewIDGenerator(conf.IDGenerator)
+	if err != nil {
+		l.Warnf("%s, using the default one", err)
+		gen = ap.UUIDGenerator{}
+	}
 
 	p, _ := processing.New(
 		processing.WithIRI(baseIRI),
 		processing.WithStorage(db),
-		processing.WithIDGenerator(fedbox.GenerateID(baseIRI)),
+		processing.WithIDGenerator(fedbox.GenerateIDWith(baseIRI, gen)),
 		processing.WithClient(c.New(
 			c.WithLogger(l.WithContext(lw.Ctx{"log": "processing"})),
 			c.SkipTLSValidation(!conf.Env.IsProd()),
@@ -91,27 +96,33 @@ func setup(c *cli.Context, l lw.Logger) (*Control, error) {
 	if conf.Storage == config.StoragePostgres {
 		host := c.String("host")
 		if host == "" {
-			host = "localhost"
+			host = conf.DB.Host
 		}
 		port := c.Int64("port")
 		if port == 0 {
-			host = path
+			port = conf.DB.Port
 		}
 		user := c.String("user")
 		if user == "" {
-			user = "fedbox"
+			user = conf.DB.User
+		}
+		pw := []byte(conf.DB.Pw)
+		if len(pw) == 0 {
+			if pw, err = loadPwFromStdin(false, "%s@%s's", user, host); err != nil {
+				return nil, err
+			}
 		}
-		pw, err := loadPwFromStdin(true, "%s@%s's", user, host)
-		if err != nil {
-			return nil, err
+		name := conf.DB.Name
+		if name == "" {
+			name = user
 		}
-		_ = config.BackendConfig{
-			Enabled: false,
+		conf.DB = config.BackendConfig{
+			Enabled: true,
 			Host:    host,
 			Port:    port,
 			User:    user,
 			Pw:      string(pw),
-			Name:    user,
+			Name:    name,
 		}
 	}
 	db, err := fedbox.Storage(conf, l)
@@ -33,6 +33,11 @@ func NewApp(version string) *cli.App {
 				Usage: fmt.Sprintf("the environment to use. Possible values: %q, %q, %q", env.DEV, env.QA, env.PROD),
 				Value: "",
 			},
+			&cli.StringFlag{
+				Name:  "config",
+				Usage: "the path of a YAML configuration file, the environment variables take precedence over its values",
+				Value: "",
+			},
 			&cli.BoolFlag{
 				Name:   "profile",
 				Hidden: true,
@@ -48,7 +53,13 @@ func run(version string) cli.ActionFunc {
 		w := c.Duration("wait")
 		e := c.String("env")
 
-		conf, err := config.LoadFromEnv(env.Type(e), w)
+		var conf config.Options
+		var err error
+		if path := c.String("config"); len(path) > 0 {
+			conf, err = config.LoadFromFile(path, env.Type(e), w)
+		} else {
+			conf, err = config.LoadFromEnv(env.Type(e), w)
+		}
 		conf.Profile = c.Bool("profile")
 		conf.Secure = conf.Secure && !conf.Profile
 
@@ -0,0 +1,380 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox"
+	s "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/openshift/osin"
+	"github.com/urfave/cli/v2"
+)
+
+var storageExportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "Writes all the stored objects, activities, actors and collections to stdout as newline delimited JSON-LD",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "with-secrets",
+			Usage: "Include the actors' metadata, containing their private keys and password hashes, and the OAuth2 clients",
+		},
+	},
+	Action: storageExportAct(&ctl),
+}
+
+var storageImportCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Saves the items from a newline delimited JSON-LD export, read from the file argument or from stdin",
+	ArgsUsage: "[file]",
+	Action:    storageImportAct(&ctl),
+}
+
+func storageExportAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		w := bufio.NewWriter(os.Stdout)
+		stats, err := Export(c.Storage, &c.Service, w, ctx.Bool("with-secrets"))
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+		c.Logger.Infof("Exported %s", stats)
+		return err
+	}
+}
+
+func storageImportAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		var r io.Reader = os.Stdin
+		if name := ctx.Args().First(); name != "" && name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return errors.Annotatef(err, "unable to open %s", name)
+			}
+			defer f.Close()
+			r = f
+		}
+		stats, err := Import(c.Storage, r, c.Logger.Infof)
+		c.Logger.Infof("Imported %s", stats)
+		return err
+	}
+}
+
+// exportMetadata is the line containing the metadata of an actor in the export
+type exportMetadata struct {
+	IRI      vocab.IRI           `json:"iri"`
+	Metadata processing.Metadata `json:"metadata"`
+}
+
+// exportClient is the line containing an OAuth2 client in the export
+type exportClient struct {
+	Client struct {
+		ID          string      `json:"id"`
+		Secret      string      `json:"secret"`
+		RedirectURI string      `json:"redirectUri"`
+		UserData    interface{} `json:"userData,omitempty"`
+	} `json:"client"`
+}
+
+type exporter struct {
+	src         fedbox.FullStorage
+	enc         *json.Encoder
+	withSecrets bool
+	stats       MigrateStats
+	done        map[vocab.IRI]struct{}
+	collections vocab.IRIs
+}
+
+// Export writes every item reachable from the self service actor in the src storage to w, one JSON-LD document
+// per line: first the items in the service's streams, then the collections belonging to them, having the IRIs
+// of their members as items. When withSecrets is true, the actors' metadata and the OAuth2 clients follow.
+func Export(src fedbox.FullStorage, self vocab.Item, w io.Writer, withSecrets bool) (MigrateStats, error) {
+	e := exporter{src: src, enc: json.NewEncoder(w), withSecrets: withSecrets, done: make(map[vocab.IRI]struct{})}
+	if vocab.IsNil(self) {
+		return e.stats, errors.NotValidf("invalid nil service actor")
+	}
+
+	metadata := vocab.IRIs{self.GetLink()}
+	if err := e.writeItem(self); err != nil {
+		return e.stats, err
+	}
+	err := vocab.OnActor(self, func(service *vocab.Actor) error {
+		for _, stream := range service.Streams {
+			items, err := src.Load(stream.GetLink())
+			if err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return errors.Annotatef(err, "unable to load %s", stream.GetLink())
+			}
+			err = vocab.OnCollectionIntf(items, func(col vocab.CollectionInterface) error {
+				for _, it := range col.Collection() {
+					if vocab.ActorTypes.Contains(it.GetType()) {
+						metadata = append(metadata, it.GetLink())
+					}
+					if err := e.writeItem(it); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return e.stats, err
+	}
+	for _, col := range e.collections {
+		if err = e.writeCollection(col); err != nil {
+			return e.stats, err
+		}
+	}
+	if !withSecrets {
+		return e.stats, nil
+	}
+	if err = e.writeMetadata(metadata); err != nil {
+		return e.stats, err
+	}
+	return e.stats, e.writeClients()
+}
+
+func (e *exporter) writeItem(it vocab.Item) error {
+	if vocab.IsNil(it) || it.IsLink() {
+		return nil
+	}
+	if _, ok := e.done[it.GetLink()]; ok {
+		return nil
+	}
+	e.done[it.GetLink()] = struct{}{}
+
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		e.stats.Errors++
+		return nil
+	}
+	if err = e.enc.Encode(json.RawMessage(raw)); err != nil {
+		return err
+	}
+	e.stats.Items++
+
+	e.collections = append(e.collections, getObjectCollections(it)...)
+	if vocab.ActorTypes.Contains(it.GetType()) {
+		e.collections = append(e.collections, getActorCollections(it)...)
+	}
+	return nil
+}
+
+// writeCollection writes the col collection, with its members replaced by their IRIs
+func (e *exporter) writeCollection(col vocab.IRI) error {
+	it, err := e.src.Load(col)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Annotatef(err, "unable to load %s", col)
+	}
+	members := make(vocab.ItemCollection, 0)
+	vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+		for _, member := range c.Collection() {
+			members = append(members, member.GetLink())
+		}
+		return nil
+	})
+	out := vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType}
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		out.AttributedTo = o.AttributedTo
+		out.Generator = o.Generator
+		out.Published = o.Published
+		out.Updated = o.Updated
+		return nil
+	})
+	out.OrderedItems = members
+	out.TotalItems = uint(len(members))
+
+	raw, err := vocab.MarshalJSON(&out)
+	if err != nil {
+		e.stats.Errors++
+		return nil
+	}
+	if err = e.enc.Encode(json.RawMessage(raw)); err != nil {
+		return err
+	}
+	e.stats.Members += len(members)
+	return nil
+}
+
+func (e *exporter) writeMetadata(iris vocab.IRIs) error {
+	metaLoader, ok := e.src.(s.MetadataTyper)
+	if !ok {
+		return nil
+	}
+	for _, iri := range iris {
+		m, err := metaLoader.LoadMetadata(iri)
+		if err != nil || m == nil {
+			continue
+		}
+		if err = e.enc.Encode(exportMetadata{IRI: iri, Metadata: *m}); err != nil {
+			return err
+		}
+		e.stats.Metadata++
+	}
+	return nil
+}
+
+func (e *exporter) writeClients() error {
+	clients, err := e.src.ListClients()
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the OAuth2 clients")
+	}
+	for _, cl := range clients {
+		line := exportClient{}
+		line.Client.ID = cl.GetId()
+		line.Client.Secret = cl.GetSecret()
+		line.Client.RedirectURI = cl.GetRedirectUri()
+		line.Client.UserData = cl.GetUserData()
+		if err = e.enc.Encode(line); err != nil {
+			return err
+		}
+		e.stats.Clients++
+	}
+	return nil
+}
+
+// maxImportLine is the maximum size of a line we accept when importing
+const maxImportLine = 16 * 1024 * 1024
+
+// Import saves the items from the newline delimited JSON-LD export read from r, in the dst storage.
+// The members of the collections are added to the existing collections, the ones already present being skipped,
+// so the same export can be imported multiple times.
+func Import(dst fedbox.FullStorage, r io.Reader, logFn func(string, ...interface{})) (MigrateStats, error) {
+	stats := MigrateStats{}
+	if logFn == nil {
+		logFn = func(string, ...interface{}) {}
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+
+	line := 0
+	for sc.Scan() {
+		line++
+		data := sc.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+		keys := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return stats, errors.NewNotValid(err, "invalid JSON on line %d", line)
+		}
+		var err error
+		switch {
+		case keys["metadata"] != nil:
+			err = importMetadata(dst, data, &stats)
+		case keys["client"] != nil:
+			err = importClient(dst, data, &stats)
+		default:
+			err = importItem(dst, data, &stats)
+		}
+		if err != nil {
+			logFn("Unable to import line %d: %+s", line, err)
+			stats.Errors++
+		}
+		if line%progressEvery == 0 {
+			logFn("Imported %s", stats)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return stats, errors.Annotatef(err, "unable to read line %d", line+1)
+	}
+	return stats, nil
+}
+
+func importItem(dst fedbox.FullStorage, data []byte, stats *MigrateStats) error {
+	it, err := vocab.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	if vocab.IsNil(it) || len(it.GetLink()) == 0 {
+		return errors.NotValidf("item without an id")
+	}
+	if !vocab.CollectionTypes.Contains(it.GetType()) {
+		if _, err = dst.Save(it); err != nil {
+			return err
+		}
+		stats.Items++
+		return nil
+	}
+
+	colStore, ok := dst.(processing.CollectionStore)
+	if !ok {
+		return errors.Newf("invalid storage type %T, unable to handle collection operations", dst)
+	}
+	members := make(vocab.ItemCollection, 0)
+	vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		members = append(members, col.Collection()...)
+		return nil
+	})
+	existing := make(map[vocab.IRI]struct{})
+	if loaded, err := dst.Load(it.GetLink()); err == nil && !vocab.IsNil(loaded) {
+		vocab.OnCollectionIntf(loaded, func(col vocab.CollectionInterface) error {
+			for _, member := range col.Collection() {
+				existing[member.GetLink()] = struct{}{}
+			}
+			return nil
+		})
+	} else if _, err = colStore.Create(newOrderedCollection(it.GetLink())); err != nil {
+		return errors.Annotatef(err, "unable to create collection %s", it.GetLink())
+	}
+	for _, member := range members {
+		if _, ok := existing[member.GetLink()]; ok {
+			continue
+		}
+		if err = colStore.AddTo(it.GetLink(), member.GetLink()); err != nil {
+			return errors.Annotatef(err, "unable to add %s to %s", member.GetLink(), it.GetLink())
+		}
+		stats.Members++
+	}
+	return nil
+}
+
+func importMetadata(dst fedbox.FullStorage, data []byte, stats *MigrateStats) error {
+	metaSaver, ok := dst.(s.MetadataTyper)
+	if !ok {
+		return errors.Newf("invalid storage type %T, unable to save metadata", dst)
+	}
+	m := exportMetadata{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if err := metaSaver.SaveMetadata(m.Metadata, m.IRI); err != nil {
+		return err
+	}
+	stats.Metadata++
+	return nil
+}
+
+func importClient(dst fedbox.FullStorage, data []byte, stats *MigrateStats) error {
+	c := exportClient{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	if existing, err := dst.GetClient(c.Client.ID); err == nil && existing != nil {
+		return nil
+	}
+	cl := osin.DefaultClient{
+		Id:          c.Client.ID,
+		Secret:      c.Client.Secret,
+		RedirectUri: c.Client.RedirectURI,
+		UserData:    c.Client.UserData,
+	}
+	if err := dst.CreateClient(&cl); err != nil {
+		return err
+	}
+	stats.Clients++
+	return nil
+}
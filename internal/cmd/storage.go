@@ -0,0 +1,360 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+	s "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/urfave/cli/v2"
+)
+
+var StorageCmd = &cli.Command{
+	Name:  "storage",
+	Usage: "Storage helper",
+	Subcommands: []*cli.Command{
+		migrateCmd,
+		storageExportCmd,
+		storageImportCmd,
+		repairCmd,
+	},
+}
+
+var repairCmd = &cli.Command{
+	Name:  "repair",
+	Usage: "Recovers the readable data of a corrupted boltdb storage file into a new one",
+	Description: "The corrupted file is moved to a \"corrupted-<timestamp>\" folder in the storage path, and the items, " +
+		"collections, metadata and OAuth2 clients which can still be read from it are copied into a new storage file.",
+	Action: repairAct(&ctl),
+}
+
+func repairAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		if c.Conf.Storage != config.StorageBoltDB {
+			return errors.Newf("only the %s storage can be repaired, not %s", config.StorageBoltDB, c.Conf.Storage)
+		}
+		path := fedbox.BoltDBStorageFile(c.Conf)
+		if err := s.CheckBoltDBFile(path); err == nil {
+			fmt.Fprintf(os.Stdout, "The storage file %s is not corrupted\n", path)
+			return nil
+		} else if !s.IsCorrupted(err) {
+			return err
+		}
+		c.Storage.Close()
+
+		// NOTE(marius): we open the corrupted file from its new location as a storage of the same type, read-only
+		srcConf := c.Conf
+		srcConf.StoragePath = filepath.Join(c.Conf.StoragePath, fmt.Sprintf("corrupted-%d", time.Now().Unix()))
+		corrupted := fedbox.BoltDBStorageFile(srcConf)
+		if err := os.Rename(path, corrupted); err != nil {
+			return errors.Annotatef(err, "unable to move the corrupted storage file %s", path)
+		}
+		c.Logger.Infof("Moved the corrupted storage file to %s", corrupted)
+
+		db, err := fedbox.Storage(srcConf, c.Logger)
+		if err != nil {
+			return errors.Annotatef(err, "unable to open the corrupted storage file %s", corrupted)
+		}
+		defer db.Close()
+		src := fedbox.RecoveryStorage(db)
+
+		self, err := src.Load(ap.DefaultServiceIRI(c.Conf.BaseURL))
+		if err != nil || vocab.IsNil(self) {
+			c.Logger.Warnf("Unable to load the service actor from the corrupted storage, creating a new one: %s", err)
+			service := ap.Self(ap.DefaultServiceIRI(c.Conf.BaseURL))
+			self = &service
+		}
+		if err = Bootstrap(c.Conf, self); err != nil {
+			return err
+		}
+		dst, err := fedbox.Storage(c.Conf, c.Logger)
+		if err != nil {
+			return errors.Annotatef(err, "unable to open the new storage")
+		}
+		defer dst.Close()
+
+		stats, err := MigrateStorage(src, dst, self, c.Logger.Infof)
+		fmt.Fprintf(os.Stdout, "Recovered from %s: %s\n", corrupted, stats)
+		return err
+	}
+}
+
+var migrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "Copies all the objects, collections, metadata and OAuth2 clients from one storage backend to another",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: fmt.Sprintf("Type of the source backend. Possible values: %q", []config.StorageType{config.StorageBoltDB, config.StorageBadger, config.StorageFS, config.StorageSqlite, config.StoragePostgres}),
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    fmt.Sprintf("Type of the destination backend. Possible values: %q", []config.StorageType{config.StorageBoltDB, config.StorageBadger, config.StorageFS, config.StorageSqlite, config.StoragePostgres}),
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "to-path",
+			Usage: "The path for the destination storage folder, it defaults to the source one",
+		},
+	},
+	Action: migrateAct(&ctl),
+}
+
+func migrateAct(c *Control) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		srcConf := c.Conf
+		if from := ctx.String("from"); from != "" {
+			srcConf.Storage = config.StorageType(from)
+		}
+		dstConf := c.Conf
+		dstConf.Storage = config.StorageType(ctx.String("to"))
+		if toPath := ctx.String("to-path"); toPath != "" {
+			dstConf.StoragePath = toPath
+		}
+		if srcConf.Storage == dstConf.Storage && srcConf.BaseStoragePath() == dstConf.BaseStoragePath() {
+			return errors.Newf("the source and destination storage are the same: %s %s", srcConf.Storage, srcConf.BaseStoragePath())
+		}
+
+		src := c.Storage
+		if srcConf.Storage != c.Conf.Storage {
+			db, err := fedbox.Storage(srcConf, c.Logger)
+			if err != nil {
+				return errors.Annotatef(err, "unable to open the %s source storage", srcConf.Storage)
+			}
+			defer db.Close()
+			src = db
+		}
+		self, err := src.Load(c.Service.GetLink())
+		if err != nil || vocab.IsNil(self) {
+			return errors.Annotatef(err, "unable to load the service actor from the %s source storage", srcConf.Storage)
+		}
+
+		if err = Bootstrap(dstConf, self); err != nil {
+			return err
+		}
+		dst, err := fedbox.Storage(dstConf, c.Logger)
+		if err != nil {
+			return errors.Annotatef(err, "unable to open the %s destination storage", dstConf.Storage)
+		}
+		defer dst.Close()
+
+		stats, err := MigrateStorage(src, dst, self, c.Logger.Infof)
+		fmt.Fprintf(os.Stdout, "Migrated from %s to %s: %s\n", srcConf.Storage, dstConf.Storage, stats)
+		return err
+	}
+}
+
+// MigrateStats holds the number of elements copied by MigrateStorage
+type MigrateStats struct {
+	Items    int
+	Skipped  int
+	Members  int
+	Metadata int
+	Clients  int
+	Errors   int
+}
+
+func (m MigrateStats) String() string {
+	return fmt.Sprintf("%d items (%d already present), %d collection members, %d metadata, %d clients, %d errors",
+		m.Items, m.Skipped, m.Members, m.Metadata, m.Clients, m.Errors)
+}
+
+// progressEvery is the number of items after which MigrateStorage reports its progress
+const progressEvery = 100
+
+type migration struct {
+	src   fedbox.FullStorage
+	dst   fedbox.FullStorage
+	stats MigrateStats
+	logFn func(string, ...interface{})
+	done  map[vocab.IRI]struct{}
+}
+
+// MigrateStorage copies everything reachable from the self service actor in the src storage to the dst one:
+// the items in the service's streams, the collections belonging to them, and their metadata, which contains
+// the private keys and the password hashes. The OAuth2 clients are copied too.
+//
+// The items which already exist in dst are not saved again and the collection members are added only when missing,
+// so an interrupted migration can be resumed by running it again.
+func MigrateStorage(src, dst fedbox.FullStorage, self vocab.Item, logFn func(string, ...interface{})) (MigrateStats, error) {
+	m := migration{src: src, dst: dst, logFn: logFn, done: make(map[vocab.IRI]struct{})}
+	if m.logFn == nil {
+		m.logFn = func(string, ...interface{}) {}
+	}
+	if vocab.IsNil(self) {
+		return m.stats, errors.NotValidf("invalid nil service actor")
+	}
+
+	m.copyItem(self)
+	err := vocab.OnActor(self, func(service *vocab.Actor) error {
+		for _, stream := range service.Streams {
+			m.copyCollection(stream.GetLink(), true)
+		}
+		return nil
+	})
+	if err != nil {
+		return m.stats, err
+	}
+	if err = m.copyClients(); err != nil {
+		return m.stats, err
+	}
+	return m.stats, nil
+}
+
+func (m *migration) progress() {
+	if processed := m.stats.Items + m.stats.Skipped; processed%progressEvery == 0 {
+		m.logFn("Migrated %s", m.stats)
+	}
+}
+
+func (m *migration) copyItem(it vocab.Item) {
+	if vocab.IsNil(it) {
+		return
+	}
+	if _, ok := m.done[it.GetLink()]; ok {
+		return
+	}
+	m.done[it.GetLink()] = struct{}{}
+
+	if m.exists(it.GetLink()) {
+		m.stats.Skipped++
+	} else {
+		var err error
+		if it.IsLink() {
+			if it, err = m.src.Load(it.GetLink()); err != nil || vocab.IsNil(it) {
+				// NOTE(marius): the item doesn't exist in the source storage, probably being a remote one
+				return
+			}
+		}
+		if _, err = m.dst.Save(it); err != nil {
+			m.logFn("Unable to save %s: %+s", it.GetLink(), err)
+			m.stats.Errors++
+			return
+		}
+		m.stats.Items++
+	}
+	m.copyMetadata(it.GetLink())
+	m.progress()
+
+	collections := getObjectCollections(it)
+	if vocab.ActorTypes.Contains(it.GetType()) {
+		collections = append(getActorCollections(it), collections...)
+	}
+	for _, col := range collections {
+		m.copyCollection(col, false)
+	}
+}
+
+// exists checks if the iri item has already been saved in the destination storage
+func (m *migration) exists(iri vocab.IRI) bool {
+	it, err := m.dst.Load(iri)
+	if err != nil || vocab.IsNil(it) {
+		return false
+	}
+	if vocab.IsItemCollection(it) {
+		cnt := 0
+		vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+			cnt = int(col.Count())
+			return nil
+		})
+		return cnt > 0
+	}
+	return true
+}
+
+// copyCollection adds the members of the col collection from the source storage to the destination one.
+// If the recursive parameter is true, the members themselves are copied instead, which we do for the service's streams,
+// as their members are the items saved under their IRI.
+func (m *migration) copyCollection(col vocab.IRI, recursive bool) {
+	it, err := m.src.Load(col)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			m.logFn("Unable to load collection %s: %+s", col, err)
+			m.stats.Errors++
+		}
+		return
+	}
+	if recursive {
+		vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+			for _, member := range c.Collection() {
+				m.copyItem(member)
+			}
+			return nil
+		})
+		return
+	}
+	colStore, ok := m.dst.(processing.CollectionStore)
+	if !ok {
+		return
+	}
+	existing := make(map[vocab.IRI]struct{})
+	if dstCol, err := m.dst.Load(col); err == nil {
+		vocab.OnCollectionIntf(dstCol, func(c vocab.CollectionInterface) error {
+			for _, member := range c.Collection() {
+				existing[member.GetLink()] = struct{}{}
+			}
+			return nil
+		})
+	}
+	vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+		for _, member := range c.Collection() {
+			if _, ok := existing[member.GetLink()]; ok {
+				continue
+			}
+			if err := colStore.AddTo(col, member.GetLink()); err != nil {
+				m.logFn("Unable to add %s to %s: %+s", member.GetLink(), col, err)
+				m.stats.Errors++
+				continue
+			}
+			m.stats.Members++
+		}
+		return nil
+	})
+}
+
+func (m *migration) copyMetadata(iri vocab.IRI) {
+	srcMeta, ok := m.src.(s.MetadataTyper)
+	if !ok {
+		return
+	}
+	dstMeta, ok := m.dst.(s.MetadataTyper)
+	if !ok {
+		return
+	}
+	meta, err := srcMeta.LoadMetadata(iri)
+	if err != nil || meta == nil {
+		return
+	}
+	if err = dstMeta.SaveMetadata(*meta, iri); err != nil {
+		m.logFn("Unable to save metadata for %s: %+s", iri, err)
+		m.stats.Errors++
+		return
+	}
+	m.stats.Metadata++
+}
+
+func (m *migration) copyClients() error {
+	clients, err := m.src.ListClients()
+	if err != nil {
+		return errors.Annotatef(err, "unable to load the OAuth2 clients")
+	}
+	for _, cl := range clients {
+		if existing, err := m.dst.GetClient(cl.GetId()); err == nil && existing != nil {
+			continue
+		}
+		if err = m.dst.CreateClient(cl); err != nil {
+			m.logFn("Unable to save OAuth2 client %s: %+s", cl.GetId(), err)
+			m.stats.Errors++
+			continue
+		}
+		m.stats.Clients++
+	}
+	return nil
+}
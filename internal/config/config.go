@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -26,56 +28,320 @@ type BackendConfig struct {
 	Name    string
 }
 
+// DSN returns the PostgreSQL connection string corresponding to the backend configuration
+func (b BackendConfig) DSN() string {
+	u := url.URL{Scheme: "postgres", Host: b.Host, Path: "/" + b.Name}
+	if b.Port > 0 {
+		u.Host = net.JoinHostPort(b.Host, strconv.FormatInt(b.Port, 10))
+	}
+	if len(b.User) > 0 {
+		u.User = url.UserPassword(b.User, b.Pw)
+		if len(b.Pw) == 0 {
+			u.User = url.User(b.User)
+		}
+	}
+	return u.String()
+}
+
 type Options struct {
-	Env                env.Type
-	LogLevel           lw.Level
-	LogOutput          string
-	TimeOut            time.Duration
-	Secure             bool
-	CertPath           string
-	KeyPath            string
-	Host               string
-	Listen             string
-	BaseURL            string
-	Storage            StorageType
-	StoragePath        string
-	StorageCache       bool
-	RequestCache       bool
-	Profile            bool
-	MastodonCompatible bool
+	Env                     env.Type
+	LogLevel                lw.Level
+	LogOutput               string
+	TimeOut                 time.Duration
+	Secure                  bool
+	CertPath                string
+	KeyPath                 string
+	Host                    string
+	Listen                  string
+	BaseURL                 string
+	Storage                 StorageType
+	StoragePath             string
+	DB                      BackendConfig
+	StorageCache            bool
+	RequestCache            bool
+	RequestCacheSize        int
+	RequestCacheTTL         time.Duration
+	CacheBackend            CacheBackend
+	CacheURL                string
+	Profile                 bool
+	MastodonCompatible      bool
+	PrivateLiked            bool
+	EmbedRemoteCollections  []string
+	OAuth2AccessExpiration  time.Duration
+	OAuth2RefreshExpiration time.Duration
+	MaintenanceInterval     time.Duration
+	TombstoneRetention      time.Duration
+	RejectAcceptedFollow    bool
+	CascadeActorDelete      bool
+	DisableReplies          bool
+	TotalItemsCap           uint
+	MediaPath               string
+	MediaMaxSize            int64
+	MediaTypes              []string
+	SelfInboxFederationOnly bool
+	Relays                  []string
+	ProfileHistory          bool
+	DedupeRecipients        bool
+	MaxProfileFields        int
+	IdempotencyKeyTTL       time.Duration
+	RejectDeletedActorInbox bool
+	PublicKeyCacheSize      int
+	PublicKeyCacheTTL       time.Duration
+	RejectTombstoneCreate   bool
+	CollectionPageSize      int
+	MaxCollectionPageSize   int
+	DeliverFollowResponses  bool
+	DeliveryMaxAttempts     int
+	DeliveryRetryInterval   time.Duration
+	DeliveryMarkUnreachable bool
+	MaintenanceMode         bool
+	AuthorizedFetch         bool
+	RegistrationMode        RegistrationMode
+	AccessLogFormat         AccessLogFormat
+	AccessLog               string
+	MaxInboxBody            int64
+	SocketMode              os.FileMode
+	SocketGroup             string
+	StorageReadOnly         bool
+	StorageOpenTimeout      time.Duration
+	HTMLAllowedTags         []string
+	RequestTimeout          time.Duration
+	TrustedProxies          []string
+	NotifyReports           bool
+	IDGenerator             string
+	StorageSlowThreshold    time.Duration
+	FetchTimeout            time.Duration
+	FollowersOnlyPublic     PublicAddressingMode
+	MetricsToken            string
+	RedirectMovedActors     bool
+	ConfigFile              string
+	RateLimitRead           RateLimit
+	RateLimitWrite          RateLimit
+	RateLimitAllow          []string
+	ThreadMaxDepth          int
+	RejectCircularThreads   bool
+	CORSAllowedOrigins      []string
+	OrderTieBreak           OrderTieBreak
+	FrontendURL             string
+	PublicKeyEncoding       KeyEncoding
+	EmbedFirstPage          bool
 }
 
 type StorageType string
 
+// RateLimit represents the number of Requests a client is allowed to make in the Window duration.
+// A zero value disables the rate limiting.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// OrderTieBreak represents how we order the collection items which have the same published or updated timestamp
+type OrderTieBreak string
+
+// KeyEncoding represents the PEM encoding of the public keys we publish for the actors
+type KeyEncoding string
+
+// CacheBackend represents where the requests cache is stored
+type CacheBackend string
+
+// PublicAddressingMode represents how we handle the Public collection addressed by a followers-only activity
+type PublicAddressingMode string
+
+// RegistrationMode represents who can register a new account on the instance
+type RegistrationMode string
+
+// AccessLogFormat represents the format of the lines of the access log
+type AccessLogFormat string
+
+const (
+	KeyENV                     = "ENV"
+	KeyTimeOut                 = "TIME_OUT"
+	KeyLogLevel                = "LOG_LEVEL"
+	KeyLogOutput               = "LOG_OUTPUT"
+	KeyHostname                = "HOSTNAME"
+	KeyHTTPS                   = "HTTPS"
+	KeyCertPath                = "CERT_PATH"
+	KeyKeyPath                 = "KEY_PATH"
+	KeyListen                  = "LISTEN"
+	KeyDBHost                  = "DB_HOST"
+	KeyDBPort                  = "DB_PORT"
+	KeyDBName                  = "DB_NAME"
+	KeyDBUser                  = "DB_USER"
+	KeyDBPw                    = "DB_PASSWORD"
+	KeyStorage                 = "STORAGE"
+	KeyStoragePath             = "STORAGE_PATH"
+	KeyCacheDisable            = "DISABLE_CACHE"
+	KeyStorageCacheDisable     = "DISABLE_STORAGE_CACHE"
+	KeyRequestCacheDisable     = "DISABLE_REQUEST_CACHE"
+	KeyRequestCacheSize        = "REQUEST_CACHE_SIZE"
+	KeyRequestCacheTTL         = "REQUEST_CACHE_TTL"
+	KeyCacheBackend            = "CACHE_BACKEND"
+	KeyCacheURL                = "CACHE_URL"
+	KeyPrivateLiked            = "PRIVATE_LIKED"
+	KeyEmbedRemote             = "EMBED_REMOTE_COLLECTIONS"
+	KeyOAuth2AccessExpiration  = "OAUTH2_ACCESS_EXPIRATION"
+	KeyOAuth2RefreshExpiration = "OAUTH2_REFRESH_EXPIRATION"
+	KeyMaintenanceInterval     = "MAINTENANCE_INTERVAL"
+	KeyTombstoneRetention      = "TOMBSTONE_RETENTION"
+	KeyRejectAcceptedFollow    = "REJECT_ACCEPTED_FOLLOW"
+	KeyCascadeActorDelete      = "CASCADE_ACTOR_DELETE"
+	KeyDisableReplies          = "DISABLE_REPLIES"
+	KeyTotalItemsCap           = "TOTAL_ITEMS_CAP"
+	KeyMediaPath               = "MEDIA_PATH"
+	KeyMediaMaxSize            = "MEDIA_MAX_SIZE"
+	KeyMediaTypes              = "MEDIA_TYPES"
+	KeySelfInboxFederation     = "SELF_INBOX_FEDERATION_ONLY"
+	KeyRelays                  = "RELAYS"
+	KeyProfileHistory          = "PROFILE_HISTORY"
+	KeyDedupeRecipients        = "DEDUPE_RECIPIENTS"
+	KeyMaxProfileFields        = "MAX_PROFILE_FIELDS"
+	KeyIdempotencyKeyTTL       = "IDEMPOTENCY_KEY_TTL"
+	KeyRejectDeletedInbox      = "REJECT_DELETED_ACTOR_INBOX"
+	KeyPublicKeyCacheSize      = "PUBLIC_KEY_CACHE_SIZE"
+	KeyPublicKeyCacheTTL       = "PUBLIC_KEY_CACHE_TTL"
+	KeyRejectTombstoneCreate   = "REJECT_TOMBSTONE_CREATE"
+	KeyCollectionPageSize      = "COLLECTION_PAGE_SIZE"
+	KeyMaxCollectionPageSize   = "MAX_COLLECTION_PAGE_SIZE"
+	KeyDeliverFollowResponses  = "DELIVER_FOLLOW_RESPONSES"
+	KeyDeliveryMaxAttempts     = "DELIVERY_MAX_ATTEMPTS"
+	KeyDeliveryRetryInterval   = "DELIVERY_RETRY_INTERVAL"
+	KeyDeliveryMarkUnreachable = "DELIVERY_MARK_UNREACHABLE"
+	KeyMaintenanceMode         = "MAINTENANCE_MODE"
+	KeyAuthorizedFetch         = "AUTHORIZED_FETCH"
+	KeyRegistrationMode        = "REGISTRATION_MODE"
+	KeyAccessLogFormat         = "ACCESS_LOG_FORMAT"
+	KeyAccessLog               = "ACCESS_LOG"
+	KeyMaxInboxBody            = "MAX_INBOX_BODY"
+	KeySocketMode              = "SOCKET_MODE"
+	KeySocketGroup             = "SOCKET_GROUP"
+	KeyStorageReadOnly         = "STORAGE_READ_ONLY"
+	KeyStorageOpenTimeout      = "STORAGE_OPEN_TIMEOUT"
+	KeyHTMLAllowedTags         = "HTML_ALLOWED_TAGS"
+	KeyRequestTimeout          = "REQUEST_TIMEOUT"
+	KeyTrustedProxies          = "TRUSTED_PROXIES"
+	KeyNotifyReports           = "NOTIFY_REPORTS"
+	KeyIDGenerator             = "ID_GENERATOR"
+	KeyStorageSlowThreshold    = "STORAGE_SLOW_THRESHOLD"
+	KeyFetchTimeout            = "FETCH_TIMEOUT"
+	KeyFollowersOnlyPublic     = "FOLLOWERS_ONLY_PUBLIC"
+	KeyMetricsToken            = "METRICS_TOKEN"
+	KeyRedirectMovedActors     = "REDIRECT_MOVED_ACTORS"
+	KeyRateLimitRead           = "RATE_LIMIT_READ"
+	KeyRateLimitWrite          = "RATE_LIMIT_WRITE"
+	KeyRateLimitAllow          = "RATE_LIMIT_ALLOW"
+	KeyThreadMaxDepth          = "THREAD_MAX_DEPTH"
+	KeyRejectCircularThreads   = "REJECT_CIRCULAR_THREADS"
+	KeyCORSAllowedOrigins      = "CORS_ALLOWED_ORIGINS"
+	KeyOrderTieBreak           = "ORDER_TIE_BREAK"
+	KeyFrontendURL             = "FRONTEND_URL"
+	KeyPublicKeyEncoding       = "PUBLIC_KEY_ENCODING"
+	KeyEmbedFirstPage          = "EMBED_FIRST_PAGE"
+	StorageBoltDB              = StorageType("boltdb")
+	StorageFS                  = StorageType("fs")
+	StorageBadger              = StorageType("badger")
+	StoragePostgres            = StorageType("postgres")
+	StorageSqlite              = StorageType("sqlite")
+)
+
+const (
+	// PublicAddressingAllow leaves the addressing of followers-only activities unchanged
+	PublicAddressingAllow = PublicAddressingMode("allow")
+	// PublicAddressingStrip removes the stray Public recipients from followers-only activities
+	PublicAddressingStrip = PublicAddressingMode("strip")
+	// PublicAddressingReject refuses followers-only activities which also address the Public collection
+	PublicAddressingReject = PublicAddressingMode("reject")
+)
+
+const (
+	// RegistrationOpen allows anyone to register an account
+	RegistrationOpen = RegistrationMode("open")
+	// RegistrationApproval keeps the registrations pending until an administrator approves them
+	RegistrationApproval = RegistrationMode("approval")
+	// RegistrationClosed rejects all the registrations
+	RegistrationClosed = RegistrationMode("closed")
+)
+
+const (
+	// AccessLogHuman logs the requests as lines of space separated values, easy to read in a terminal
+	AccessLogHuman = AccessLogFormat("human")
+	// AccessLogJSON logs the requests as JSON objects, one per line, for the log processing tools
+	AccessLogJSON = AccessLogFormat("json")
+)
+
 const (
-	KeyENV                 = "ENV"
-	KeyTimeOut             = "TIME_OUT"
-	KeyLogLevel            = "LOG_LEVEL"
-	KeyLogOutput           = "LOG_OUTPUT"
-	KeyHostname            = "HOSTNAME"
-	KeyHTTPS               = "HTTPS"
-	KeyCertPath            = "CERT_PATH"
-	KeyKeyPath             = "KEY_PATH"
-	KeyListen              = "LISTEN"
-	KeyDBHost              = "DB_HOST"
-	KeyDBPort              = "DB_PORT"
-	KeyDBName              = "DB_NAME"
-	KeyDBUser              = "DB_USER"
-	KeyDBPw                = "DB_PASSWORD"
-	KeyStorage             = "STORAGE"
-	KeyStoragePath         = "STORAGE_PATH"
-	KeyCacheDisable        = "DISABLE_CACHE"
-	KeyStorageCacheDisable = "DISABLE_STORAGE_CACHE"
-	KeyRequestCacheDisable = "DISABLE_REQUEST_CACHE"
-	StorageBoltDB          = StorageType("boltdb")
-	StorageFS              = StorageType("fs")
-	StorageBadger          = StorageType("badger")
-	StoragePostgres        = StorageType("postgres")
-	StorageSqlite          = StorageType("sqlite")
+	// AccessLogStdout writes the access log to the standard output
+	AccessLogStdout = "stdout"
+	// AccessLogStderr writes the access log to the standard error
+	AccessLogStderr = "stderr"
+	// AccessLogLogger writes the access log through the application logger, together with its other messages
+	AccessLogLogger = "log"
+	// AccessLogOff turns off the access log
+	AccessLogOff = "off"
+)
+
+const (
+	// OrderTieBreakDesc orders the items with the same timestamp descending by their IRI
+	OrderTieBreakDesc = OrderTieBreak("desc")
+	// OrderTieBreakAsc orders the items with the same timestamp ascending by their IRI
+	OrderTieBreakAsc = OrderTieBreak("asc")
+	// OrderTieBreakNone keeps the items with the same timestamp in the order the storage returned them
+	OrderTieBreakNone = OrderTieBreak("none")
+)
+
+const (
+	// KeyEncodingPKIX encodes the public keys as "PUBLIC KEY" PEM blocks, containing the PKIX SubjectPublicKeyInfo
+	KeyEncodingPKIX = KeyEncoding("pkix")
+	// KeyEncodingPKCS1 encodes the RSA public keys as "RSA PUBLIC KEY" PEM blocks, the other key types
+	// don't have a PKCS#1 form, so they're still encoded as PKIX
+	KeyEncodingPKCS1 = KeyEncoding("pkcs1")
+)
+
+const (
+	// CacheMemory keeps the requests cache in the memory of the process
+	CacheMemory = CacheBackend("memory")
+	// CacheRedis keeps the requests cache in a Redis server, shared between multiple FedBOX processes
+	CacheRedis = CacheBackend("redis")
 )
 
 const defaultDirPerm = os.ModeDir | os.ModePerm | 0700
 
+const (
+	DefaultOAuth2AccessExpiration  = 24 * time.Hour
+	DefaultOAuth2RefreshExpiration = 30 * 24 * time.Hour
+	DefaultMaintenanceInterval     = time.Hour
+	DefaultThreadMaxDepth          = 100
+	DefaultRequestCacheSize        = 10000
+	DefaultMediaMaxSize            = 10 << 20
+	DefaultMediaTypes              = "image/jpeg,image/png,image/gif,image/webp"
+	DefaultMaxProfileFields        = 4
+	DefaultIdempotencyKeyTTL       = 24 * time.Hour
+	DefaultPublicKeyCacheSize      = 1000
+	DefaultPublicKeyCacheTTL       = time.Hour
+	DefaultCollectionPageSize      = 100
+	DefaultMaxCollectionPageSize   = 500
+	DefaultDeliveryMaxAttempts     = 5
+	DefaultDeliveryRetryInterval   = time.Minute
+	DefaultMaxInboxBody            = 1 << 20
+	DefaultSocketMode              = 0660
+)
+
+// ListenSpecs returns the addresses the instance listens on, from the comma separated list in the Listen option.
+// Each of them can be a TCP address, the path of a unix domain socket, or "systemd" for the socket activation.
+func (o Options) ListenSpecs() []string {
+	specs := make([]string, 0)
+	for _, spec := range strings.Split(o.Listen, ",") {
+		if spec = strings.TrimSpace(spec); len(spec) > 0 {
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		// NOTE: an empty address listens on the default HTTP or HTTPS port
+		specs = append(specs, "")
+	}
+	return specs
+}
+
 func (o Options) BaseStoragePath() string {
 	if !filepath.IsAbs(o.StoragePath) {
 		o.StoragePath, _ = filepath.Abs(o.StoragePath)
@@ -122,9 +388,15 @@ func Getval(name, def string) string {
 }
 
 func LoadFromEnv(e env.Type, timeOut time.Duration) (Options, error) {
+	return loadFromValues(e, timeOut, nil)
+}
+
+// loadFromValues loads the configuration from the environment variables, falling back to the v values for the ones
+// which are not set.
+func loadFromValues(e env.Type, timeOut time.Duration, v values) (Options, error) {
 	conf := Options{}
 	if !env.ValidType(e) {
-		e = env.Type(Getval(KeyENV, ""))
+		e = env.Type(v.get(KeyENV, ""))
 	}
 	configs := []string{
 		".env",
@@ -146,7 +418,7 @@ func LoadFromEnv(e env.Type, timeOut time.Duration) (Options, error) {
 		godotenv.Load(f)
 	}
 
-	lvl := Getval(KeyLogLevel, "")
+	lvl := v.get(KeyLogLevel, "")
 	switch strings.ToLower(lvl) {
 	case "none":
 		conf.LogLevel = lw.NoLevel
@@ -163,49 +435,262 @@ func LoadFromEnv(e env.Type, timeOut time.Duration) (Options, error) {
 	default:
 		conf.LogLevel = lw.InfoLevel
 	}
-	conf.LogOutput = Getval(KeyLogOutput, "")
+	conf.LogOutput = v.get(KeyLogOutput, "")
 
 	if !env.ValidType(e) {
-		e = env.Type(Getval(KeyENV, "dev"))
+		e = env.Type(v.get(KeyENV, "dev"))
 	}
 	conf.Env = e
 	if conf.Host == "" {
-		conf.Host = Getval(KeyHostname, conf.Host)
+		conf.Host = v.get(KeyHostname, conf.Host)
 	}
 	conf.TimeOut = timeOut
-	if to, _ := time.ParseDuration(Getval(KeyTimeOut, "")); to > 0 {
+	if to, _ := time.ParseDuration(v.get(KeyTimeOut, "")); to > 0 {
 		conf.TimeOut = to
 	}
-	conf.Secure, _ = strconv.ParseBool(Getval(KeyHTTPS, "false"))
+	conf.Secure, _ = strconv.ParseBool(v.get(KeyHTTPS, "false"))
 	if conf.Secure {
 		conf.BaseURL = fmt.Sprintf("https://%s", conf.Host)
 	} else {
 		conf.BaseURL = fmt.Sprintf("http://%s", conf.Host)
 	}
-	conf.KeyPath = Getval(KeyKeyPath, "")
-	conf.CertPath = Getval(KeyCertPath, "")
+	conf.KeyPath = v.get(KeyKeyPath, "")
+	conf.CertPath = v.get(KeyCertPath, "")
 
-	conf.Listen = Getval(KeyListen, "")
-	envStorage := Getval(KeyStorage, string(DefaultStorage))
+	conf.Listen = v.get(KeyListen, "")
+	envStorage := v.get(KeyStorage, string(DefaultStorage))
 	if len(DefaultStorage) > 0 {
 		envStorage = string(DefaultStorage)
 	}
 	conf.Storage = StorageType(strings.ToLower(envStorage))
-	conf.StoragePath = Getval(KeyStoragePath, "")
+	conf.StoragePath = v.get(KeyStoragePath, "")
 	if conf.StoragePath == "" {
 		conf.StoragePath = os.TempDir()
 	}
 	conf.StoragePath = path.Clean(conf.StoragePath)
+	if conf.Storage == StoragePostgres {
+		conf.DB.Enabled = true
+		conf.DB.Host = v.get(KeyDBHost, "localhost")
+		conf.DB.Port, _ = strconv.ParseInt(v.get(KeyDBPort, "5432"), 10, 64)
+		conf.DB.User = v.get(KeyDBUser, "fedbox")
+		conf.DB.Pw = v.get(KeyDBPw, "")
+		conf.DB.Name = v.get(KeyDBName, conf.DB.User)
+	}
 
-	disableCache, _ := strconv.ParseBool(Getval(KeyCacheDisable, "false"))
+	disableCache, _ := strconv.ParseBool(v.get(KeyCacheDisable, "false"))
 	conf.StorageCache = !disableCache
 	conf.RequestCache = !disableCache
-	if disableStorageCache, err := strconv.ParseBool(Getval(KeyStorageCacheDisable, "false")); err == nil {
+	if disableStorageCache, err := strconv.ParseBool(v.get(KeyStorageCacheDisable, "false")); err == nil {
 		conf.StorageCache = !disableStorageCache
 	}
-	if disableRequestCache, err := strconv.ParseBool(Getval(KeyRequestCacheDisable, "false")); err == nil {
+	if disableRequestCache, err := strconv.ParseBool(v.get(KeyRequestCacheDisable, "false")); err == nil {
 		conf.RequestCache = !disableRequestCache
 	}
+	conf.RequestCacheSize = DefaultRequestCacheSize
+	if size, err := strconv.Atoi(v.get(KeyRequestCacheSize, "")); err == nil && size >= 0 {
+		conf.RequestCacheSize = size
+	}
+	if ttl, err := time.ParseDuration(v.get(KeyRequestCacheTTL, "")); err == nil && ttl > 0 {
+		conf.RequestCacheTTL = ttl
+	}
+	switch backend := CacheBackend(strings.ToLower(v.get(KeyCacheBackend, ""))); backend {
+	case CacheRedis:
+		conf.CacheBackend = backend
+	default:
+		conf.CacheBackend = CacheMemory
+	}
+	conf.CacheURL = v.get(KeyCacheURL, "")
+	conf.PrivateLiked, _ = strconv.ParseBool(v.get(KeyPrivateLiked, "false"))
+	for _, typ := range strings.Split(v.get(KeyEmbedRemote, ""), ",") {
+		if typ = strings.ToLower(strings.TrimSpace(typ)); len(typ) > 0 {
+			conf.EmbedRemoteCollections = append(conf.EmbedRemoteCollections, typ)
+		}
+	}
+	conf.OAuth2AccessExpiration = DefaultOAuth2AccessExpiration
+	if exp, err := time.ParseDuration(v.get(KeyOAuth2AccessExpiration, "")); err == nil && exp > 0 {
+		conf.OAuth2AccessExpiration = exp
+	}
+	conf.OAuth2RefreshExpiration = DefaultOAuth2RefreshExpiration
+	if exp, err := time.ParseDuration(v.get(KeyOAuth2RefreshExpiration, "")); err == nil && exp > 0 {
+		conf.OAuth2RefreshExpiration = exp
+	}
+	conf.MaintenanceInterval = DefaultMaintenanceInterval
+	if interval, err := time.ParseDuration(v.get(KeyMaintenanceInterval, "")); err == nil {
+		conf.MaintenanceInterval = interval
+	}
+	conf.TombstoneRetention, _ = time.ParseDuration(v.get(KeyTombstoneRetention, ""))
+	conf.RejectAcceptedFollow, _ = strconv.ParseBool(v.get(KeyRejectAcceptedFollow, "true"))
+	conf.CascadeActorDelete, _ = strconv.ParseBool(v.get(KeyCascadeActorDelete, "true"))
+	conf.DisableReplies, _ = strconv.ParseBool(v.get(KeyDisableReplies, "false"))
+	if max, err := strconv.ParseUint(v.get(KeyTotalItemsCap, "0"), 10, 32); err == nil {
+		conf.TotalItemsCap = uint(max)
+	}
+	conf.MediaPath = v.get(KeyMediaPath, "")
+	if conf.MediaPath == "" {
+		conf.MediaPath = filepath.Join(conf.StoragePath, "media")
+	}
+	conf.MediaMaxSize = DefaultMediaMaxSize
+	if size, err := strconv.ParseInt(v.get(KeyMediaMaxSize, ""), 10, 64); err == nil && size > 0 {
+		conf.MediaMaxSize = size
+	}
+	for _, typ := range strings.Split(v.get(KeyMediaTypes, DefaultMediaTypes), ",") {
+		if typ = strings.ToLower(strings.TrimSpace(typ)); len(typ) > 0 {
+			conf.MediaTypes = append(conf.MediaTypes, typ)
+		}
+	}
+	conf.SelfInboxFederationOnly, _ = strconv.ParseBool(v.get(KeySelfInboxFederation, "true"))
+	for _, inbox := range strings.Split(v.get(KeyRelays, ""), ",") {
+		if inbox = strings.TrimSpace(inbox); len(inbox) > 0 {
+			conf.Relays = append(conf.Relays, inbox)
+		}
+	}
+	conf.ProfileHistory, _ = strconv.ParseBool(v.get(KeyProfileHistory, "false"))
+	conf.DedupeRecipients, _ = strconv.ParseBool(v.get(KeyDedupeRecipients, "true"))
+	conf.MaxProfileFields = DefaultMaxProfileFields
+	if max, err := strconv.Atoi(v.get(KeyMaxProfileFields, "")); err == nil && max >= 0 {
+		conf.MaxProfileFields = max
+	}
+	conf.IdempotencyKeyTTL = DefaultIdempotencyKeyTTL
+	if ttl, err := time.ParseDuration(v.get(KeyIdempotencyKeyTTL, "")); err == nil && ttl >= 0 {
+		conf.IdempotencyKeyTTL = ttl
+	}
+	conf.RejectDeletedActorInbox, _ = strconv.ParseBool(v.get(KeyRejectDeletedInbox, "true"))
+	conf.PublicKeyCacheSize = DefaultPublicKeyCacheSize
+	if size, err := strconv.Atoi(v.get(KeyPublicKeyCacheSize, "")); err == nil && size >= 0 {
+		conf.PublicKeyCacheSize = size
+	}
+	conf.PublicKeyCacheTTL = DefaultPublicKeyCacheTTL
+	if ttl, err := time.ParseDuration(v.get(KeyPublicKeyCacheTTL, "")); err == nil && ttl >= 0 {
+		conf.PublicKeyCacheTTL = ttl
+	}
+	conf.RejectTombstoneCreate, _ = strconv.ParseBool(v.get(KeyRejectTombstoneCreate, "true"))
+	conf.MaxCollectionPageSize = DefaultMaxCollectionPageSize
+	if max, err := strconv.Atoi(v.get(KeyMaxCollectionPageSize, "")); err == nil && max > 0 {
+		conf.MaxCollectionPageSize = max
+	}
+	conf.CollectionPageSize = DefaultCollectionPageSize
+	if size, err := strconv.Atoi(v.get(KeyCollectionPageSize, "")); err == nil && size > 0 {
+		conf.CollectionPageSize = size
+	}
+	if conf.CollectionPageSize > conf.MaxCollectionPageSize {
+		conf.CollectionPageSize = conf.MaxCollectionPageSize
+	}
+	conf.DeliverFollowResponses, _ = strconv.ParseBool(v.get(KeyDeliverFollowResponses, "true"))
+	conf.DeliveryMaxAttempts = DefaultDeliveryMaxAttempts
+	if max, err := strconv.Atoi(v.get(KeyDeliveryMaxAttempts, "")); err == nil && max > 0 {
+		conf.DeliveryMaxAttempts = max
+	}
+	conf.DeliveryRetryInterval = DefaultDeliveryRetryInterval
+	if interval, err := time.ParseDuration(v.get(KeyDeliveryRetryInterval, "")); err == nil && interval > 0 {
+		conf.DeliveryRetryInterval = interval
+	}
+	conf.DeliveryMarkUnreachable, _ = strconv.ParseBool(v.get(KeyDeliveryMarkUnreachable, "false"))
+	conf.MaintenanceMode, _ = strconv.ParseBool(v.get(KeyMaintenanceMode, "false"))
+	conf.AuthorizedFetch, _ = strconv.ParseBool(v.get(KeyAuthorizedFetch, "false"))
+	switch mode := RegistrationMode(strings.ToLower(v.get(KeyRegistrationMode, ""))); mode {
+	case RegistrationOpen, RegistrationApproval:
+		conf.RegistrationMode = mode
+	default:
+		conf.RegistrationMode = RegistrationClosed
+	}
+	switch format := AccessLogFormat(strings.ToLower(v.get(KeyAccessLogFormat, ""))); format {
+	case AccessLogJSON:
+		conf.AccessLogFormat = format
+	default:
+		conf.AccessLogFormat = AccessLogHuman
+	}
+	conf.AccessLog = v.get(KeyAccessLog, AccessLogStdout)
+	conf.MaxInboxBody = DefaultMaxInboxBody
+	if size, err := strconv.ParseInt(v.get(KeyMaxInboxBody, ""), 10, 64); err == nil && size > 0 {
+		conf.MaxInboxBody = size
+	}
+	conf.SocketMode = DefaultSocketMode
+	if mode, err := strconv.ParseUint(v.get(KeySocketMode, ""), 8, 32); err == nil && mode > 0 {
+		conf.SocketMode = os.FileMode(mode) & os.ModePerm
+	}
+	conf.SocketGroup = v.get(KeySocketGroup, "")
+	conf.StorageReadOnly, _ = strconv.ParseBool(v.get(KeyStorageReadOnly, "false"))
+	if timeout, err := time.ParseDuration(v.get(KeyStorageOpenTimeout, "")); err == nil && timeout > 0 {
+		conf.StorageOpenTimeout = timeout
+	}
+	for _, tag := range strings.Split(v.get(KeyHTMLAllowedTags, ""), ",") {
+		if tag = strings.TrimSpace(tag); len(tag) > 0 {
+			conf.HTMLAllowedTags = append(conf.HTMLAllowedTags, tag)
+		}
+	}
+	if timeout, err := time.ParseDuration(v.get(KeyRequestTimeout, "")); err == nil && timeout > 0 {
+		conf.RequestTimeout = timeout
+	}
+	for _, addr := range strings.Split(v.get(KeyTrustedProxies, ""), ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			conf.TrustedProxies = append(conf.TrustedProxies, addr)
+		}
+	}
+	conf.NotifyReports, _ = strconv.ParseBool(v.get(KeyNotifyReports, "false"))
+	conf.IDGenerator = strings.ToLower(v.get(KeyIDGenerator, "uuid"))
+	if threshold, err := time.ParseDuration(v.get(KeyStorageSlowThreshold, "")); err == nil && threshold > 0 {
+		conf.StorageSlowThreshold = threshold
+	}
+	if timeout, err := time.ParseDuration(v.get(KeyFetchTimeout, "10s")); err == nil && timeout > 0 {
+		conf.FetchTimeout = timeout
+	}
+	switch mode := PublicAddressingMode(strings.ToLower(v.get(KeyFollowersOnlyPublic, ""))); mode {
+	case PublicAddressingStrip, PublicAddressingReject:
+		conf.FollowersOnlyPublic = mode
+	default:
+		conf.FollowersOnlyPublic = PublicAddressingAllow
+	}
+	conf.MetricsToken = v.get(KeyMetricsToken, "")
+	conf.RedirectMovedActors, _ = strconv.ParseBool(v.get(KeyRedirectMovedActors, "false"))
+	conf.RateLimitRead = parseRateLimit(v.get(KeyRateLimitRead, ""))
+	conf.RateLimitWrite = parseRateLimit(v.get(KeyRateLimitWrite, ""))
+	for _, addr := range strings.Split(v.get(KeyRateLimitAllow, ""), ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			conf.RateLimitAllow = append(conf.RateLimitAllow, addr)
+		}
+	}
+	conf.ThreadMaxDepth = DefaultThreadMaxDepth
+	if depth, err := strconv.Atoi(v.get(KeyThreadMaxDepth, "")); err == nil && depth > 0 {
+		conf.ThreadMaxDepth = depth
+	}
+	conf.RejectCircularThreads, _ = strconv.ParseBool(v.get(KeyRejectCircularThreads, "false"))
+	for _, origin := range strings.Split(v.get(KeyCORSAllowedOrigins, ""), ",") {
+		if origin = strings.TrimSpace(origin); len(origin) > 0 {
+			conf.CORSAllowedOrigins = append(conf.CORSAllowedOrigins, origin)
+		}
+	}
+	switch tieBreak := OrderTieBreak(strings.ToLower(v.get(KeyOrderTieBreak, ""))); tieBreak {
+	case OrderTieBreakAsc, OrderTieBreakNone:
+		conf.OrderTieBreak = tieBreak
+	default:
+		conf.OrderTieBreak = OrderTieBreakDesc
+	}
+	conf.FrontendURL = v.get(KeyFrontendURL, "")
+	switch enc := KeyEncoding(strings.ToLower(v.get(KeyPublicKeyEncoding, ""))); enc {
+	case KeyEncodingPKCS1:
+		conf.PublicKeyEncoding = enc
+	default:
+		conf.PublicKeyEncoding = KeyEncodingPKIX
+	}
+	conf.EmbedFirstPage, _ = strconv.ParseBool(v.get(KeyEmbedFirstPage, "false"))
 
 	return conf, nil
 }
+
+// parseRateLimit parses values of the form "requests/window", eg: "300/1m".
+// Invalid values result in an empty RateLimit which disables the rate limiting.
+func parseRateLimit(s string) RateLimit {
+	req, win, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return RateLimit{}
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(req))
+	if err != nil || requests <= 0 {
+		return RateLimit{}
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(win))
+	if err != nil || window <= 0 {
+		return RateLimit{}
+	}
+	return RateLimit{Requests: requests, Window: window}
+}
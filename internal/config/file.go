@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/env"
+	"gopkg.in/yaml.v3"
+)
+
+// WarnFn is used for reporting the issues found when loading the configuration, which are not fatal
+var WarnFn = func(s string, p ...interface{}) {
+	fmt.Fprintf(os.Stderr, "WARNING: "+s+"\n", p...)
+}
+
+// values holds the configuration values loaded from a file, indexed by their environment variable name
+type values map[string]string
+
+// get returns the value of the environment variable name, falling back to the value loaded from the file,
+// and then to the def default value
+func (v values) get(name, def string) string {
+	if val, ok := v[name]; ok && len(val) > 0 {
+		def = val
+	}
+	return Getval(name, def)
+}
+
+// normalizeKey converts the keys from the configuration file to the format of the environment variables,
+// eg: "storage-path" and "fedbox_storage_path" become "STORAGE_PATH"
+func normalizeKey(k string) string {
+	k = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(strings.TrimSpace(k)))
+	if Prefix != "" {
+		k = strings.TrimPrefix(k, strings.ToUpper(Prefix)+"_")
+	}
+	return k
+}
+
+func valueToString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []interface{}:
+		s := make([]string, 0, len(val))
+		for _, vv := range val {
+			s = append(s, valueToString(vv))
+		}
+		return strings.Join(s, ",")
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// parseFile parses the YAML data into the configuration values
+func parseFile(data []byte) (values, error) {
+	raw := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	v := make(values, len(raw))
+	for k, val := range raw {
+		v[normalizeKey(k)] = valueToString(val)
+	}
+	return v, nil
+}
+
+// knownKeys are the configuration keys we load
+var knownKeys = []string{
+	KeyENV, KeyTimeOut, KeyLogLevel, KeyLogOutput, KeyHostname, KeyHTTPS, KeyCertPath, KeyKeyPath, KeyListen,
+	KeyDBHost, KeyDBPort, KeyDBName, KeyDBUser, KeyDBPw, KeyStorage, KeyStoragePath, KeyCacheDisable,
+	KeyStorageCacheDisable, KeyRequestCacheDisable, KeyPrivateLiked, KeyEmbedRemote, KeyOAuth2AccessExpiration,
+	KeyOAuth2RefreshExpiration, KeyMaintenanceInterval, KeyTombstoneRetention, KeyRejectAcceptedFollow,
+	KeyFollowersOnlyPublic, KeyMetricsToken, KeyRedirectMovedActors, KeyRateLimitRead,
+	KeyRateLimitWrite, KeyRateLimitAllow, KeyThreadMaxDepth, KeyRejectCircularThreads,
+	KeyCORSAllowedOrigins, KeyOrderTieBreak, KeyFrontendURL, KeyPublicKeyEncoding, KeyEmbedFirstPage,
+	KeyRequestCacheSize, KeyRequestCacheTTL, KeyCascadeActorDelete, KeyDisableReplies,
+	KeyCacheBackend, KeyCacheURL, KeyTotalItemsCap, KeyMediaPath, KeyMediaMaxSize, KeyMediaTypes,
+	KeySelfInboxFederation, KeyRelays, KeyProfileHistory, KeyDedupeRecipients, KeyMaxProfileFields,
+	KeyIdempotencyKeyTTL, KeyRejectDeletedInbox, KeyPublicKeyCacheSize, KeyPublicKeyCacheTTL,
+	KeyRejectTombstoneCreate, KeyCollectionPageSize, KeyMaxCollectionPageSize, KeyDeliverFollowResponses,
+	KeyDeliveryMaxAttempts, KeyDeliveryRetryInterval, KeyMaintenanceMode, KeyAuthorizedFetch,
+	KeyRegistrationMode, KeyDeliveryMarkUnreachable,
+	KeyAccessLogFormat, KeyAccessLog, KeyMaxInboxBody, KeySocketMode, KeySocketGroup, KeyStorageReadOnly,
+	KeyStorageOpenTimeout,
+	KeyHTMLAllowedTags, KeyRequestTimeout, KeyTrustedProxies, KeyNotifyReports,
+	KeyIDGenerator, KeyStorageSlowThreshold, KeyFetchTimeout,
+}
+
+func isKnownKey(k string) bool {
+	for _, known := range knownKeys {
+		if k == known {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFromFile loads the configuration from the YAML file found at path.
+// The environment variables, including the ones from the .env files, take precedence over the values in the file.
+// Unknown keys in the file are reported through WarnFn, and are otherwise ignored.
+func LoadFromFile(path string, e env.Type, timeOut time.Duration) (Options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Options{}, errors.Annotatef(err, "unable to read configuration file %s", path)
+	}
+	v, err := parseFile(data)
+	if err != nil {
+		return Options{}, errors.Annotatef(err, "unable to parse configuration file %s", path)
+	}
+	for k := range v {
+		if !isKnownKey(k) {
+			WarnFn("unknown key %q in configuration file %s", k, path)
+		}
+	}
+	conf, err := loadFromValues(e, timeOut, v)
+	conf.ConfigFile = path
+	return conf, err
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ap/fedbox/internal/env"
+)
+
+const testConfigFile = `
+hostname: file.git
+https: true
+listen: 127.0.0.4:4499
+storage-path: /tmp/fedbox-file
+embed_remote_collections:
+  - inbox
+  - followers
+fedbox_oauth2_access_expiration: 1h
+unknown-key: 42
+`
+
+func TestLoadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fedbox.yaml")
+	if err := os.WriteFile(path, []byte(testConfigFile), 0600); err != nil {
+		t.Fatalf("unable to write configuration file: %s", err)
+	}
+
+	var warnings []string
+	oldWarnFn := WarnFn
+	WarnFn = func(s string, p ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(s, p...))
+	}
+	defer func() { WarnFn = oldWarnFn }()
+
+	for _, k := range []string{KeyHostname, KeyHTTPS, KeyListen, KeyStoragePath, KeyEmbedRemote, KeyOAuth2AccessExpiration} {
+		os.Unsetenv(k)
+		os.Unsetenv(prefKey(k))
+	}
+
+	t.Run("values from file", func(t *testing.T) {
+		warnings = warnings[:0]
+		c, err := LoadFromFile(path, env.TEST, time.Second)
+		if err != nil {
+			t.Fatalf("LoadFromFile() returned error %s", err)
+		}
+		if c.Host != "file.git" {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyHostname, c.Host, "file.git")
+		}
+		if c.BaseURL != "https://file.git" {
+			t.Errorf("Invalid loaded BaseURL value: %s, expected %s", c.BaseURL, "https://file.git")
+		}
+		if c.Listen != "127.0.0.4:4499" {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyListen, c.Listen, "127.0.0.4:4499")
+		}
+		if c.StoragePath != "/tmp/fedbox-file" {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyStoragePath, c.StoragePath, "/tmp/fedbox-file")
+		}
+		if len(c.EmbedRemoteCollections) != 2 || c.EmbedRemoteCollections[0] != "inbox" || c.EmbedRemoteCollections[1] != "followers" {
+			t.Errorf("Invalid loaded value for %s: %v, expected %v", KeyEmbedRemote, c.EmbedRemoteCollections, []string{"inbox", "followers"})
+		}
+		if c.OAuth2AccessExpiration != time.Hour {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyOAuth2AccessExpiration, c.OAuth2AccessExpiration, time.Hour)
+		}
+		if c.ConfigFile != path {
+			t.Errorf("Invalid configuration file path: %s, expected %s", c.ConfigFile, path)
+		}
+		if len(warnings) != 1 {
+			t.Errorf("Expected a warning for the unknown key, received %v", warnings)
+		}
+	})
+	t.Run("env overrides file", func(t *testing.T) {
+		os.Setenv(KeyHostname, "env.git")
+		os.Setenv(KeyListen, "127.0.0.5:5599")
+		defer func() {
+			os.Unsetenv(KeyHostname)
+			os.Unsetenv(KeyListen)
+		}()
+
+		c, err := LoadFromFile(path, env.TEST, time.Second)
+		if err != nil {
+			t.Fatalf("LoadFromFile() returned error %s", err)
+		}
+		if c.Host != "env.git" {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyHostname, c.Host, "env.git")
+		}
+		if c.Listen != "127.0.0.5:5599" {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyListen, c.Listen, "127.0.0.5:5599")
+		}
+		if c.StoragePath != "/tmp/fedbox-file" {
+			t.Errorf("Invalid loaded value for %s: %s, expected %s", KeyStoragePath, c.StoragePath, "/tmp/fedbox-file")
+		}
+	})
+	t.Run("invalid file", func(t *testing.T) {
+		if _, err := LoadFromFile(filepath.Join(t.TempDir(), "missing.yaml"), env.TEST, time.Second); err == nil {
+			t.Errorf("LoadFromFile() should have returned an error for a missing file")
+		}
+	})
+}
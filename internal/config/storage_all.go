@@ -1,4 +1,4 @@
-//go:build storage_all || (!storage_fs && !storage_boltdb && !storage_badger && !storage_sqlite)
+//go:build storage_all || (!storage_fs && !storage_boltdb && !storage_badger && !storage_sqlite && !storage_pgx)
 
 package config
 
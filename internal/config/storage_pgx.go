@@ -0,0 +1,5 @@
+//go:build storage_pgx
+
+package config
+
+const DefaultStorage = StoragePostgres
@@ -0,0 +1,65 @@
+package fedbox
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/fedbox/internal/cache"
+)
+
+// keyCache is the client used for loading the remote actors when verifying the HTTP signatures of the requests.
+// It keeps the recently loaded actors in memory, so the repeated verifications for the same keyId don't need
+// to fetch them again.
+type keyCache struct {
+	client.Basic
+	keys cache.CanStore
+}
+
+// newKeyCache returns a keyCache holding at most size actors, each for the ttl duration.
+// A zero size disables the caching.
+func newKeyCache(cl client.Basic, size int, ttl time.Duration) *keyCache {
+	return &keyCache{
+		Basic: cl,
+		keys:  cache.New(size > 0, size, ttl),
+	}
+}
+
+// keyOwner returns the IRI of the document containing the key with the keyID IRI, which is usually
+// a fragment of the actor's IRI: https://example.com/actors/jdoe#main-key
+func keyOwner(keyID vocab.IRI) vocab.IRI {
+	if i := strings.IndexByte(keyID.String(), '#'); i >= 0 {
+		return keyID[:i]
+	}
+	return keyID
+}
+
+func (k *keyCache) LoadIRI(iri vocab.IRI) (vocab.Item, error) {
+	return k.CtxLoadIRI(context.Background(), iri)
+}
+
+func (k *keyCache) CtxLoadIRI(ctx context.Context, iri vocab.IRI) (vocab.Item, error) {
+	owner := keyOwner(iri)
+	if it := k.keys.Get(owner); !vocab.IsNil(it) {
+		return it, nil
+	}
+	it, err := k.Basic.CtxLoadIRI(ctx, iri)
+	if err != nil {
+		return it, err
+	}
+	if !vocab.IsNil(it) && vocab.ActorTypes.Contains(it.GetType()) && it.GetLink().Equals(owner, false) {
+		k.keys.Set(owner, it)
+	}
+	return it, nil
+}
+
+// invalidate removes the actor from the cache, after it has been updated, which can mean a rotation
+// of its keys, or deleted.
+func (k *keyCache) invalidate(actor vocab.IRI) {
+	if k == nil || actor == "" {
+		return
+	}
+	k.keys.Remove(keyOwner(actor))
+}
@@ -0,0 +1,84 @@
+package fedbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// countingClient is a client.Basic which records the IRIs it has loaded
+type countingClient struct {
+	mockLoader
+	loaded vocab.IRIs
+}
+
+func (c *countingClient) LoadIRI(iri vocab.IRI) (vocab.Item, error) {
+	return c.CtxLoadIRI(context.Background(), iri)
+}
+
+func (c *countingClient) CtxLoadIRI(_ context.Context, iri vocab.IRI) (vocab.Item, error) {
+	c.loaded = append(c.loaded, iri)
+	return c.mockLoader.LoadIRI(keyOwner(iri))
+}
+
+func (c *countingClient) ToCollection(col vocab.IRI, it vocab.Item) (vocab.IRI, vocab.Item, error) {
+	return c.CtxToCollection(context.Background(), col, it)
+}
+
+func (c *countingClient) CtxToCollection(_ context.Context, col vocab.IRI, it vocab.Item) (vocab.IRI, vocab.Item, error) {
+	return col, it, nil
+}
+
+func TestKeyCache(t *testing.T) {
+	actor := &vocab.Actor{
+		ID:        "https://example.com/actors/jdoe",
+		Type:      vocab.PersonType,
+		PublicKey: vocab.PublicKey{ID: "https://example.com/actors/jdoe#main-key", Owner: "https://example.com/actors/jdoe"},
+	}
+	keyID := actor.PublicKey.ID
+	cl := &countingClient{mockLoader: mockLoader{actor.ID: actor}}
+
+	keys := newKeyCache(cl, 10, time.Hour)
+	for i := 0; i < 3; i++ {
+		it, err := keys.LoadIRI(keyID)
+		if err != nil {
+			t.Fatalf("LoadIRI(%s) returned error %s", keyID, err)
+		}
+		if !it.GetLink().Equals(actor.ID, false) {
+			t.Errorf("LoadIRI(%s) = %s, expected %s", keyID, it.GetLink(), actor.ID)
+		}
+	}
+	if _, err := keys.LoadIRI(actor.ID); err != nil {
+		t.Fatalf("LoadIRI(%s) returned error %s", actor.ID, err)
+	}
+	if len(cl.loaded) != 1 {
+		t.Errorf("the repeated verifications for %s should use the cached actor, loaded %v", keyID, cl.loaded)
+	}
+
+	keys.invalidate(actor.ID)
+	if _, err := keys.LoadIRI(keyID); err != nil {
+		t.Fatalf("LoadIRI(%s) returned error %s", keyID, err)
+	}
+	if len(cl.loaded) != 2 {
+		t.Errorf("the actor should be loaded again after the invalidation, loaded %v", cl.loaded)
+	}
+
+	missing := vocab.IRI("https://example.com/actors/missing#main-key")
+	for i := 0; i < 2; i++ {
+		if _, err := keys.LoadIRI(missing); err == nil {
+			t.Errorf("LoadIRI(%s) should fail", missing)
+		}
+	}
+	if len(cl.loaded) != 4 {
+		t.Errorf("the failed loads should not be cached, loaded %v", cl.loaded)
+	}
+
+	disabled := newKeyCache(cl, 0, time.Hour)
+	disabled.LoadIRI(keyID)
+	disabled.LoadIRI(keyID)
+	if len(cl.loaded) != 6 {
+		t.Errorf("a zero sized cache should be disabled, loaded %v", cl.loaded)
+	}
+}
@@ -0,0 +1,102 @@
+package fedbox
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-ap/errors"
+)
+
+// listen opens the listener for the spec, which can be the magic string "systemd" for the socket activation,
+// a path on disk for a unix domain socket, or a TCP address. It returns the listener and the type of the socket.
+func listen(spec string, secure bool) (net.Listener, string, error) {
+	switch {
+	case spec == "systemd":
+		if fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); fds == 0 {
+			return nil, "", errors.Newf("it appears that we're not expected to wait for a systemd socket connection")
+		}
+		l, err := net.FileListener(os.NewFile(3, "systemd listen fd"))
+		return l, "Systemd", err
+	case filepath.IsAbs(spec):
+		if _, err := os.Stat(filepath.Dir(spec)); err != nil {
+			return nil, "", errors.Annotatef(err, "invalid socket path %s", spec)
+		}
+		l, err := net.Listen("unix", spec)
+		return l, "socket", err
+	default:
+		if spec == "" {
+			spec = ":http"
+			if secure {
+				spec = ":https"
+			}
+		}
+		l, err := net.Listen("tcp", spec)
+		return l, "TCP", err
+	}
+}
+
+// setSocketPermissions changes the mode of the unix domain socket at path, and its group when it's not empty.
+// The group can be either a name or a numeric id.
+func setSocketPermissions(path string, mode os.FileMode, group string) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return errors.Annotatef(err, "unable to change the mode of the socket %s", path)
+	}
+	if group == "" {
+		return nil
+	}
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return errors.Annotatef(err, "unknown socket group %s", group)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return errors.Annotatef(err, "invalid id of the socket group %s", group)
+		}
+	}
+	if err = os.Chown(path, -1, gid); err != nil {
+		return errors.Annotatef(err, "unable to change the group of the socket %s", path)
+	}
+	return nil
+}
+
+// serveListeners returns the start and stop functions for the servers using the h handler on each of the listeners.
+// When tlsConf is not nil the servers use HTTPS. The start function returns when the first of the servers stops,
+// and the stop function shuts down all of them.
+func serveListeners(h http.Handler, tlsConf *tls.Config, listeners ...net.Listener) (func() error, func(context.Context) error) {
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, l := range listeners {
+		servers = append(servers, &http.Server{Addr: l.Addr().String(), Handler: h, TLSConfig: tlsConf})
+	}
+	start := func() error {
+		if len(listeners) == 0 {
+			return errors.Newf("no listeners have been configured")
+		}
+		errs := make(chan error, len(listeners))
+		for i, l := range listeners {
+			if tlsConf != nil {
+				l = tls.NewListener(l, tlsConf)
+			}
+			go func(srv *http.Server, l net.Listener) {
+				errs <- srv.Serve(l)
+			}(servers[i], l)
+		}
+		return <-errs
+	}
+	stop := func(ctx context.Context) error {
+		var err error
+		for _, srv := range servers {
+			if sErr := srv.Shutdown(ctx); sErr != nil && err == nil {
+				err = sErr
+			}
+		}
+		return err
+	}
+	return start, stop
+}
@@ -0,0 +1,111 @@
+package fedbox
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServeListeners(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fedbox")
+	if err != nil {
+		t.Fatalf("unable to create the socket directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "fedbox.sock")
+
+	tcp, typ, err := listen("127.0.0.1:0", false)
+	if err != nil || typ != "TCP" {
+		t.Fatalf("listen() on TCP returned %q, %v", typ, err)
+	}
+	unix, typ, err := listen(sock, false)
+	if err != nil || typ != "socket" {
+		t.Fatalf("listen() on %s returned %q, %v", sock, typ, err)
+	}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	start, stop := serveListeners(h, nil, tcp, unix)
+	stopped := make(chan error, 1)
+	go func() { stopped <- start() }()
+
+	clients := map[string]*http.Client{
+		"TCP": {Timeout: time.Second},
+		"socket": {
+			Timeout: time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+				},
+			},
+		},
+	}
+	urls := map[string]string{"TCP": "http://" + tcp.Addr().String() + "/", "socket": "http://fedbox/"}
+	for name, cl := range clients {
+		resp, err := cl.Get(urls[name])
+		if err != nil {
+			t.Fatalf("request on the %s listener failed: %s", name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+			t.Errorf("the %s listener returned %d %q", name, resp.StatusCode, body)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = stop(ctx); err != nil {
+		t.Fatalf("stop() error = %s", err)
+	}
+	if err = <-stopped; !isServerClosed(err) {
+		t.Errorf("start() returned %v, expected the server closed error", err)
+	}
+	for name, cl := range clients {
+		if resp, err := cl.Get(urls[name]); err == nil {
+			resp.Body.Close()
+			t.Errorf("the %s listener still serves requests after stop()", name)
+		}
+	}
+}
+
+func TestSetSocketPermissions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fedbox")
+	if err != nil {
+		t.Fatalf("unable to create the socket directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "fedbox.sock")
+
+	l, _, err := listen(sock, false)
+	if err != nil {
+		t.Fatalf("listen() on %s error = %s", sock, err)
+	}
+	defer l.Close()
+
+	for _, mode := range []os.FileMode{0660, 0600} {
+		if err = setSocketPermissions(sock, mode, strconv.Itoa(os.Getgid())); err != nil {
+			t.Fatalf("setSocketPermissions(%o) error = %s", mode, err)
+		}
+		fi, err := os.Stat(sock)
+		if err != nil {
+			t.Fatalf("unable to stat the socket: %s", err)
+		}
+		if fi.Mode()&os.ModeSocket == 0 {
+			t.Errorf("%s is not a socket", sock)
+		}
+		if perm := fi.Mode().Perm(); perm != mode {
+			t.Errorf("the socket has the mode %o, expected %o", perm, mode)
+		}
+	}
+	if err = setSocketPermissions(sock, 0660, "fedbox-missing-group"); err == nil {
+		t.Errorf("setSocketPermissions() didn't fail for a missing group")
+	}
+}
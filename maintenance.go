@@ -0,0 +1,95 @@
+package fedbox
+
+import (
+	"context"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+)
+
+// tombstoneDeletedAt returns the time when the t Tombstone was created.
+// We fall back to the updated and published times for tombstones that don't have the deleted property set.
+func tombstoneDeletedAt(t *vocab.Tombstone) time.Time {
+	if !t.Deleted.IsZero() {
+		return t.Deleted
+	}
+	if !t.Updated.IsZero() {
+		return t.Updated
+	}
+	return t.Published
+}
+
+// PurgeTombstones hard deletes from the db storage the Tombstones found in the objects collection of the
+// base IRI service, which are older than the retention duration.
+// It returns the IRIs of the removed Tombstones.
+func PurgeTombstones(db processing.Store, base vocab.IRI, retention time.Duration, now time.Time) (vocab.IRIs, error) {
+	if retention <= 0 {
+		return nil, nil
+	}
+	f := filters.FiltersNew(
+		filters.IRI(filters.ObjectsType.IRI(base)),
+		filters.Type(vocab.TombstoneType),
+	)
+	col, err := db.Load(f.GetLink())
+	if err != nil {
+		return nil, err
+	}
+	var toRemove vocab.ItemCollection
+	err = vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if it.GetType() != vocab.TombstoneType {
+				continue
+			}
+			vocab.OnTombstone(it, func(t *vocab.Tombstone) error {
+				if deleted := tombstoneDeletedAt(t); !deleted.IsZero() && now.Sub(deleted) > retention {
+					toRemove = append(toRemove, t)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(vocab.IRIs, 0, len(toRemove))
+	for _, it := range toRemove {
+		if err = db.Delete(it); err != nil {
+			return removed, errors.Annotatef(err, "unable to remove Tombstone %s", it.GetLink())
+		}
+		removed = append(removed, it.GetLink())
+	}
+	return removed, nil
+}
+
+// sweep runs periodically the maintenance tasks until the ctx context gets cancelled
+func (f *FedBOX) sweep(ctx context.Context) {
+	if f.conf.MaintenanceInterval <= 0 {
+		return
+	}
+	t := time.NewTicker(f.conf.MaintenanceInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			f.runMaintenance(now.UTC())
+		}
+	}
+}
+
+func (f *FedBOX) runMaintenance(now time.Time) {
+	removed, err := PurgeTombstones(f.storage, f.self.GetLink(), f.conf.TombstoneRetention, now)
+	if err != nil {
+		f.errFn("unable to purge old Tombstones: %+s", err)
+	}
+	if len(removed) > 0 {
+		f.caches.Remove(removed...)
+		f.infFn("removed %d Tombstones older than %s", len(removed), f.conf.TombstoneRetention)
+	}
+}
@@ -0,0 +1,97 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+type mockStore map[vocab.IRI]vocab.Item
+
+func (m mockStore) Load(iri vocab.IRI) (vocab.Item, error) {
+	if it, ok := m[iri]; ok {
+		return it, nil
+	}
+	col := make(vocab.ItemCollection, 0)
+	for _, it := range m {
+		col = append(col, it)
+	}
+	return &col, nil
+}
+
+func (m mockStore) Save(it vocab.Item) (vocab.Item, error) {
+	m[it.GetLink()] = it
+	return it, nil
+}
+
+func (m mockStore) Delete(it vocab.Item) error {
+	if _, ok := m[it.GetLink()]; !ok {
+		return errors.NotFoundf("%s not found", it.GetLink())
+	}
+	delete(m, it.GetLink())
+	return nil
+}
+
+func TestPurgeTombstones(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	now := time.Now().UTC()
+	retention := 24 * time.Hour
+
+	old := &vocab.Tombstone{
+		ID:      "https://fedbox.local/objects/old",
+		Type:    vocab.TombstoneType,
+		Deleted: now.Add(-2 * retention),
+	}
+	recent := &vocab.Tombstone{
+		ID:      "https://fedbox.local/objects/recent",
+		Type:    vocab.TombstoneType,
+		Deleted: now.Add(-time.Hour),
+	}
+	note := &vocab.Object{
+		ID:        "https://fedbox.local/objects/note",
+		Type:      vocab.NoteType,
+		Published: now.Add(-2 * retention),
+	}
+
+	db := mockStore{}
+	for _, it := range []vocab.Item{old, recent, note} {
+		db.Save(it)
+	}
+
+	removed, err := PurgeTombstones(db, base, retention, now)
+	if err != nil {
+		t.Fatalf("PurgeTombstones() returned error %s", err)
+	}
+	if len(removed) != 1 || !removed.Contains(old.ID) {
+		t.Errorf("PurgeTombstones() removed %v, expected only %s", removed, old.ID)
+	}
+	if _, ok := db[old.ID]; ok {
+		t.Errorf("old Tombstone %s should have been removed", old.ID)
+	}
+	if _, ok := db[recent.ID]; !ok {
+		t.Errorf("recent Tombstone %s should have been retained", recent.ID)
+	}
+	if _, ok := db[note.ID]; !ok {
+		t.Errorf("object %s should have been retained", note.ID)
+	}
+}
+
+func TestPurgeTombstonesDisabled(t *testing.T) {
+	now := time.Now().UTC()
+	old := &vocab.Tombstone{
+		ID:      "https://fedbox.local/objects/old",
+		Type:    vocab.TombstoneType,
+		Deleted: now.Add(-365 * 24 * time.Hour),
+	}
+	db := mockStore{old.ID: old}
+
+	removed, err := PurgeTombstones(db, "https://fedbox.local", 0, now)
+	if err != nil {
+		t.Fatalf("PurgeTombstones() returned error %s", err)
+	}
+	if len(removed) > 0 {
+		t.Errorf("PurgeTombstones() with no retention should not remove anything, removed %v", removed)
+	}
+}
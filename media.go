@@ -0,0 +1,150 @@
+package fedbox
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+	"github.com/go-chi/chi/v5"
+)
+
+// mediaMaxAge is the duration for which the clients can cache the media files.
+// The files never change, as they are stored under a new name for every upload, but they can be deleted.
+const mediaMaxAge = 24 * time.Hour
+
+// mediaServer serves the files stored by the mediaUploader
+type mediaServer struct {
+	db   processing.ReadStore
+	base vocab.IRI
+	dir  string
+}
+
+// object loads the object describing the name media file, which has the same UUID as the file.
+func (m mediaServer) object(name string) (vocab.Item, error) {
+	iri := filters.ObjectsType.IRI(m.base).AddPath(strings.TrimSuffix(name, filepath.Ext(name)))
+	it, err := m.db.Load(iri)
+	if err != nil {
+		return nil, err
+	}
+	var ob vocab.Item
+	if vocab.IsItemCollection(it) {
+		vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+			for _, it := range c.Collection() {
+				if it.GetLink().Equals(iri, false) {
+					ob = it
+				}
+			}
+			return nil
+		})
+	} else if !vocab.IsNil(it) && it.GetLink().Equals(iri, false) {
+		ob = it
+	}
+	if vocab.IsNil(ob) {
+		return nil, errors.NotFoundf("media %s not found", name)
+	}
+	return ob, nil
+}
+
+// mediaType returns the MIME type of the media file, as recorded in the it object, falling back to the one
+// corresponding to the name's extension.
+func mediaType(it vocab.Item, name string) string {
+	typ := ""
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		typ = string(o.MediaType)
+		return nil
+	})
+	if typ == "" {
+		typ = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if typ == "" {
+		typ = "application/octet-stream"
+	}
+	return typ
+}
+
+// isInlineMedia checks if the browsers can display the files of the typ media type in place, without the risk of
+// running their content in the context of the instance. Only the images, videos and audio files can, except the
+// SVG images, which can contain scripts.
+func isInlineMedia(typ string) bool {
+	mt, _, err := mime.ParseMediaType(typ)
+	if err != nil || mt == "image/svg+xml" {
+		return false
+	}
+	return strings.HasPrefix(mt, "image/") || strings.HasPrefix(mt, "video/") || strings.HasPrefix(mt, "audio/")
+}
+
+// mediaETag builds the entity tag of a media file from its name, size and modification time
+func mediaETag(name string, fi os.FileInfo) string {
+	return fmt.Sprintf(`"%s-%x-%x"`, strings.TrimSuffix(name, filepath.Ext(name)), fi.Size(), fi.ModTime().UnixNano())
+}
+
+func handleMedia(m mediaServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "file")
+		if name == "" {
+			name = path.Base(r.URL.Path)
+		}
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			errors.HandleError(errors.NotFoundf("media %s not found", name)).ServeHTTP(w, r)
+			return
+		}
+		it, err := m.object(name)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if it.GetType() == vocab.TombstoneType {
+			http.Error(w, fmt.Sprintf("media %s has been deleted", name), http.StatusGone)
+			return
+		}
+
+		f, err := os.Open(filepath.Join(m.dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = errors.NewNotFound(err, "media %s not found", name)
+			}
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+
+		typ := mediaType(it, name)
+		w.Header().Set("Content-Type", typ)
+		// NOTE(marius): the browsers must not guess a different type for the files, and the ones we can't
+		// show in place are downloaded, so an uploaded HTML document can't run scripts on the instance's origin
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if !isInlineMedia(typ) {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		}
+		w.Header().Set("ETag", mediaETag(name, fi))
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(mediaMaxAge.Seconds())))
+		// NOTE(marius): ServeContent handles the Range requests, the Last-Modified header,
+		// and the If-None-Match and If-Modified-Since conditional requests
+		http.ServeContent(w, r, name, fi.ModTime(), f)
+	}
+}
+
+// HandleMedia serves the media files uploaded by the clients, with the Content-Type from the mediaType
+// of the object describing them. It supports conditional and Range requests.
+func HandleMedia(fb FedBOX) http.HandlerFunc {
+	m := mediaServer{
+		db:   fb.storage,
+		base: vocab.IRI(fb.Config().BaseURL),
+		dir:  fb.Config().MediaPath,
+	}
+	return handleMedia(m)
+}
@@ -0,0 +1,161 @@
+package fedbox
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestHandleMedia(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	img := testPNG(t)
+
+	db := mockStore{}
+	dir := t.TempDir()
+	u := mediaUploader{db: db, base: base, dir: dir, maxSize: 1 << 20, types: []string{"image/png"}}
+	it, err := u.save(bytes.NewReader(img), "cat.png", johnDoe)
+	if err != nil {
+		t.Fatalf("unable to save the test media: %s", err)
+	}
+	var file string
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		file = path.Base(o.URL.GetLink().String())
+		return nil
+	})
+	deleted, err := u.save(bytes.NewReader(img), "deleted.png", johnDoe)
+	if err != nil {
+		t.Fatalf("unable to save the test media: %s", err)
+	}
+	var deletedFile string
+	vocab.OnObject(deleted, func(o *vocab.Object) error {
+		deletedFile = path.Base(o.URL.GetLink().String())
+		return nil
+	})
+	db.Save(tombstoneFor(deleted, time.Now().UTC()))
+
+	m := mediaServer{db: db, base: base, dir: dir}
+	serve := func(file string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/"+mediaPath+"/"+file, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handleMedia(m).ServeHTTP(w, r)
+		return w
+	}
+
+	full := serve(file, nil)
+	if full.Code != http.StatusOK {
+		t.Fatalf("GET returned status %d, expected %d: %s", full.Code, http.StatusOK, full.Body.String())
+	}
+	if ct := full.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q, expected %q", ct, "image/png")
+	}
+	if nosniff := full.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, expected %q", nosniff, "nosniff")
+	}
+	if cd := full.Header().Get("Content-Disposition"); cd != "" {
+		t.Errorf("Content-Disposition = %q, expected the image to be shown in place", cd)
+	}
+	if !bytes.Equal(full.Body.Bytes(), img) {
+		t.Errorf("GET returned %d bytes different from the uploaded ones", full.Body.Len())
+	}
+	etag := full.Header().Get("ETag")
+	lastModified := full.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("GET is missing the ETag %q or Last-Modified %q headers", etag, lastModified)
+	}
+
+	t.Run("range", func(t *testing.T) {
+		w := serve(file, map[string]string{"Range": "bytes=8-15"})
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("Range GET returned status %d, expected %d", w.Code, http.StatusPartialContent)
+		}
+		if cr, want := w.Header().Get("Content-Range"), fmt.Sprintf("bytes 8-15/%d", len(img)); cr != want {
+			t.Errorf("Content-Range = %q, expected %q", cr, want)
+		}
+		if !bytes.Equal(w.Body.Bytes(), img[8:16]) {
+			t.Errorf("Range GET returned %v, expected %v", w.Body.Bytes(), img[8:16])
+		}
+	})
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		w := serve(file, map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(img)+10)})
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("Range GET returned status %d, expected %d", w.Code, http.StatusRequestedRangeNotSatisfiable)
+		}
+	})
+	t.Run("if-none-match", func(t *testing.T) {
+		if w := serve(file, map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+			t.Errorf("conditional GET returned status %d, expected %d", w.Code, http.StatusNotModified)
+		}
+		if w := serve(file, map[string]string{"If-None-Match": `"stale"`}); w.Code != http.StatusOK {
+			t.Errorf("conditional GET with a stale ETag returned status %d, expected %d", w.Code, http.StatusOK)
+		}
+	})
+	t.Run("if-modified-since", func(t *testing.T) {
+		if w := serve(file, map[string]string{"If-Modified-Since": lastModified}); w.Code != http.StatusNotModified {
+			t.Errorf("conditional GET returned status %d, expected %d", w.Code, http.StatusNotModified)
+		}
+		old := time.Now().Add(-24 * time.Hour).UTC().Format(http.TimeFormat)
+		if w := serve(file, map[string]string{"If-Modified-Since": old}); w.Code != http.StatusOK {
+			t.Errorf("conditional GET with an old date returned status %d, expected %d", w.Code, http.StatusOK)
+		}
+	})
+	t.Run("unknown", func(t *testing.T) {
+		if w := serve("00000000-0000-0000-0000-000000000000.png", nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET returned status %d, expected %d", w.Code, http.StatusNotFound)
+		}
+	})
+	t.Run("document", func(t *testing.T) {
+		doc := &vocab.Object{ID: "https://fedbox.local/objects/11111111-1111-1111-1111-111111111111", Type: vocab.DocumentType, MediaType: "text/html"}
+		db.Save(doc)
+		name := "11111111-1111-1111-1111-111111111111.html"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("<script>alert(1)</script>"), 0600); err != nil {
+			t.Fatalf("unable to write the test document: %s", err)
+		}
+		w := serve(name, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET returned status %d, expected %d", w.Code, http.StatusOK)
+		}
+		if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") || !strings.Contains(cd, name) {
+			t.Errorf("Content-Disposition = %q, expected the document to be downloaded as %s", cd, name)
+		}
+		if nosniff := w.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+			t.Errorf("X-Content-Type-Options = %q, expected %q", nosniff, "nosniff")
+		}
+	})
+	t.Run("deleted", func(t *testing.T) {
+		if w := serve(deletedFile, nil); w.Code != http.StatusGone {
+			t.Errorf("GET returned status %d, expected %d", w.Code, http.StatusGone)
+		}
+	})
+}
+
+func TestIsInlineMedia(t *testing.T) {
+	tests := map[string]bool{
+		"image/png":                true,
+		"image/jpeg":               true,
+		"video/mp4":                true,
+		"audio/ogg; codecs=opus":   true,
+		"image/svg+xml":            false,
+		"text/html; charset=utf-8": false,
+		"application/pdf":          false,
+		"application/octet-stream": false,
+		"":                         false,
+	}
+	for typ, want := range tests {
+		if got := isInlineMedia(typ); got != want {
+			t.Errorf("isInlineMedia(%q) = %t, expected %t", typ, got, want)
+		}
+	}
+}
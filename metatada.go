@@ -8,11 +8,15 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"net/http"
+	"time"
 
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
-	"github.com/go-ap/processing"
+	"github.com/go-ap/fedbox/internal/config"
 	"github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/go-fed/httpsig"
 	"golang.org/x/crypto/ed25519"
 )
 
@@ -22,7 +26,7 @@ const (
 	KeyTypeRSA     = "RSA"
 )
 
-func AddKeyToPerson(metaSaver storage.MetadataTyper, typ string) func(act *vocab.Actor) error {
+func AddKeyToPerson(metaSaver storage.MetadataTyper, typ string, enc config.KeyEncoding) func(act *vocab.Actor) error {
 	// TODO(marius): add a way to pass if we should overwrite the keys
 	//  for now we'll assume that if we're calling this, we want to do it
 	overwriteKeys := true
@@ -35,20 +39,19 @@ func AddKeyToPerson(metaSaver storage.MetadataTyper, typ string) func(act *vocab
 		if m == nil {
 			m = new(processing.Metadata)
 		}
-		var pubB, prvB pem.Block
 		if m.PrivateKey == nil || overwriteKeys {
+			var prvB pem.Block
 			if typ == KeyTypeED25519 {
-				pubB, prvB = GenerateECKeyPair()
+				_, prvB = GenerateECKeyPair()
 			} else {
-				pubB, prvB = GenerateRSAKeyPair()
+				_, prvB = GenerateRSAKeyPair()
 			}
 			m.PrivateKey = pem.EncodeToMemory(&prvB)
 			if err := metaSaver.SaveMetadata(*m, act.ID); err != nil {
 				return errors.Annotatef(err, "failed saving metadata for actor: %s", act.ID)
 			}
-		} else {
-			pubB = publicKeyFrom(m.PrivateKey)
 		}
+		pubB := publicKeyFrom(m.PrivateKey, enc)
 		if len(pubB.Bytes) > 0 {
 			act.PublicKey = vocab.PublicKey{
 				ID:           vocab.IRI(fmt.Sprintf("%s#main", act.ID)),
@@ -60,27 +63,190 @@ func AddKeyToPerson(metaSaver storage.MetadataTyper, typ string) func(act *vocab
 	}
 }
 
-func publicKeyFrom(prvBytes []byte) pem.Block {
+// PreviousKeyRetention is how long the private key replaced by a rotation is kept, so the signatures made with it
+// shortly before the rotation can still be verified.
+const PreviousKeyRetention = 24 * time.Hour
+
+// rotatedHeader is the PEM header of the previous private key which records when it has been replaced
+const rotatedHeader = "Rotated"
+
+// actorMetadataIRI returns the IRI under which we keep the name metadata of the actor, separately from the
+// actor's own metadata.
+//
+// NOTE(marius): the storage backends keep the metadata in the bucket, or the folder, of the path of the IRI,
+// so a fragment of the actor's IRI would overwrite the actor's metadata, with its private key and password.
+func actorMetadataIRI(actor vocab.IRI, name string) vocab.IRI {
+	return actor.AddPath(name)
+}
+
+// previousKeyIRI returns the IRI under which we keep the metadata with the previous private key of the actor
+func previousKeyIRI(actor vocab.IRI) vocab.IRI {
+	return actorMetadataIRI(actor, "previous-key")
+}
+
+// RotateKey replaces the key pair of the act actor with a new one of the typ type, and publishes the new public key,
+// encoded with enc. The previous private key is kept together with the time of the rotation.
+// The caller needs to save the act actor afterwards.
+func RotateKey(metaSaver storage.MetadataTyper, act *vocab.Actor, typ string, enc config.KeyEncoding, now time.Time) error {
+	if m, _ := metaSaver.LoadMetadata(act.ID); m != nil && len(m.PrivateKey) > 0 {
+		if prv, _ := pem.Decode(m.PrivateKey); prv != nil {
+			if prv.Headers == nil {
+				prv.Headers = make(map[string]string)
+			}
+			prv.Headers[rotatedHeader] = now.UTC().Format(time.RFC3339)
+			previous := processing.Metadata{PrivateKey: pem.EncodeToMemory(prv)}
+			if err := metaSaver.SaveMetadata(previous, previousKeyIRI(act.ID)); err != nil {
+				return errors.Annotatef(err, "failed saving the previous key of actor: %s", act.ID)
+			}
+		}
+	}
+	return AddKeyToPerson(metaSaver, typ, enc)(act)
+}
+
+// previousKey returns the PEM encoded private key of the actor which has been replaced by a rotation, if that
+// happened less than PreviousKeyRetention ago.
+func previousKey(metaLoader storage.MetadataTyper, actor vocab.IRI, now time.Time) []byte {
+	m, _ := metaLoader.LoadMetadata(previousKeyIRI(actor))
+	if m == nil || len(m.PrivateKey) == 0 {
+		return nil
+	}
+	prv, _ := pem.Decode(m.PrivateKey)
+	if prv == nil {
+		return nil
+	}
+	rotated, err := time.Parse(time.RFC3339, prv.Headers[rotatedHeader])
+	if err != nil || now.Sub(rotated) > PreviousKeyRetention {
+		return nil
+	}
+	return m.PrivateKey
+}
+
+// PreviousPublicKey returns the PEM encoded public key corresponding to the private key of the actor which has been
+// replaced by a rotation, if that happened less than PreviousKeyRetention ago.
+func PreviousPublicKey(metaLoader storage.MetadataTyper, actor vocab.IRI, enc config.KeyEncoding, now time.Time) string {
+	pubB := publicKeyFrom(previousKey(metaLoader, actor, now), enc)
+	if len(pubB.Bytes) == 0 {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pubB))
+}
+
+// actorSignedWithPreviousKey returns the local actor which signed the r request with its previous key. The requests
+// signed shortly before a rotation can't be verified with the new key, so we accept them during PreviousKeyRetention.
+func actorSignedWithPreviousKey(db processing.ReadStore, base vocab.IRI, r *http.Request, now time.Time) (vocab.Actor, bool) {
+	m, ok := db.(storage.MetadataTyper)
+	if !ok {
+		return vocab.Actor{}, false
+	}
+	v, err := httpsig.NewVerifier(r)
+	if err != nil {
+		return vocab.Actor{}, false
+	}
+	actor := keyOwner(vocab.IRI(v.KeyId()))
+	if !actor.Contains(base, false) {
+		return vocab.Actor{}, false
+	}
+	pubB := publicKeyFrom(previousKey(m, actor, now), config.KeyEncodingPKIX)
+	pub, err := x509.ParsePKIXPublicKey(pubB.Bytes)
+	if err != nil {
+		return vocab.Actor{}, false
+	}
+	verified := false
+	for _, algo := range signatureAlgorithms(pub) {
+		if verified = v.Verify(pub, algo) == nil; verified {
+			break
+		}
+	}
+	if !verified {
+		return vocab.Actor{}, false
+	}
+	var act vocab.Actor
+	if it, err := db.Load(actor); err == nil {
+		vocab.OnActor(firstItem(it), func(a *vocab.Actor) error {
+			act = *a
+			return nil
+		})
+	}
+	return act, act.ID.Equals(actor, false)
+}
+
+// signatureAlgorithms returns the HTTP signature algorithms which can be verified with the pub key
+func signatureAlgorithms(pub crypto.PublicKey) []httpsig.Algorithm {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return []httpsig.Algorithm{httpsig.RSA_SHA256, httpsig.RSA_SHA512}
+	case *ecdsa.PublicKey:
+		return []httpsig.Algorithm{httpsig.ECDSA_SHA512, httpsig.ECDSA_SHA256}
+	case ed25519.PublicKey:
+		return []httpsig.Algorithm{httpsig.ED25519}
+	}
+	return nil
+}
+
+// refreshPublicKey sets the public key of the act actor to the one corresponding to its private key, encoded
+// with enc. It returns true if the act's public key was changed.
+func refreshPublicKey(metaLoader storage.MetadataTyper, act *vocab.Actor, enc config.KeyEncoding) bool {
+	m, _ := metaLoader.LoadMetadata(act.ID)
+	if m == nil || len(m.PrivateKey) == 0 {
+		return false
+	}
+	pubB := publicKeyFrom(m.PrivateKey, enc)
+	if len(pubB.Bytes) == 0 {
+		return false
+	}
+	pubPem := string(pem.EncodeToMemory(&pubB))
+	if act.PublicKey.PublicKeyPem == pubPem {
+		return false
+	}
+	act.PublicKey = vocab.PublicKey{
+		ID:           vocab.IRI(fmt.Sprintf("%s#main", act.ID)),
+		Owner:        act.ID,
+		PublicKeyPem: pubPem,
+	}
+	return true
+}
+
+// publicKeyFrom returns the PEM block of the public key corresponding to the PEM encoded prvBytes private key
+func publicKeyFrom(prvBytes []byte, enc config.KeyEncoding) pem.Block {
 	prv, _ := pem.Decode(prvBytes)
+	if prv == nil {
+		return pem.Block{}
+	}
 	var pubKey crypto.PublicKey
-	if key, _ := x509.ParseECPrivateKey(prvBytes); key != nil {
-		pubKey = key.PublicKey
+	if key, _ := x509.ParseECPrivateKey(prv.Bytes); key != nil {
+		pubKey = &key.PublicKey
 	}
 	if key, _ := x509.ParsePKCS8PrivateKey(prv.Bytes); pubKey == nil && key != nil {
 		switch k := key.(type) {
 		case *rsa.PrivateKey:
-			pubKey = k.PublicKey
+			pubKey = &k.PublicKey
 		case *ecdsa.PrivateKey:
-			pubKey = k.PublicKey
+			pubKey = &k.PublicKey
 		case ed25519.PrivateKey:
 			pubKey = k.Public()
 		}
 	}
-	pubEnc, err := x509.MarshalPKIXPublicKey(pubKey)
+	if pubKey == nil {
+		return pem.Block{}
+	}
+	pubB, err := encodePublicKey(pubKey, enc)
 	if err != nil {
 		return pem.Block{}
 	}
-	return pem.Block{Type: "PUBLIC KEY", Bytes: pubEnc}
+	return pubB
+}
+
+// encodePublicKey returns the PEM block of the pub key, using the enc encoding for the RSA keys.
+// The other key types are always encoded as PKIX, as PKCS#1 is specific to RSA.
+func encodePublicKey(pub crypto.PublicKey, enc config.KeyEncoding) (pem.Block, error) {
+	if rsaPub, ok := pub.(*rsa.PublicKey); ok && enc == config.KeyEncodingPKCS1 {
+		return pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(rsaPub)}, nil
+	}
+	pubEnc, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return pem.Block{}, err
+	}
+	return pem.Block{Type: "PUBLIC KEY", Bytes: pubEnc}, nil
 }
 
 func GenerateRSAKeyPair() (pem.Block, pem.Block) {
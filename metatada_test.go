@@ -0,0 +1,286 @@
+package fedbox
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/processing"
+	"github.com/go-fed/httpsig"
+	"golang.org/x/crypto/ed25519"
+)
+
+// mockMetadata keeps the metadata by the IRIs without their fragment, like the storage backends, which keep it
+// in the bucket, or the folder, of the path of the IRI
+type mockMetadata map[vocab.IRI]processing.Metadata
+
+func metadataKey(iri vocab.IRI) vocab.IRI {
+	u, err := iri.URL()
+	if err != nil {
+		return iri
+	}
+	u.Fragment = ""
+	return vocab.IRI(u.String())
+}
+
+func (m mockMetadata) LoadMetadata(iri vocab.IRI) (*processing.Metadata, error) {
+	meta, ok := m[metadataKey(iri)]
+	if !ok {
+		return nil, errors.NotFoundf("%s not found", iri)
+	}
+	return &meta, nil
+}
+
+func (m mockMetadata) SaveMetadata(meta processing.Metadata, iri vocab.IRI) error {
+	m[metadataKey(iri)] = meta
+	return nil
+}
+
+// parsePublicKey parses the PEM encoded public key, accepting only the format corresponding to its block type
+func parsePublicKey(t *testing.T, pubPem string) (string, crypto.PublicKey) {
+	b, _ := pem.Decode([]byte(pubPem))
+	if b == nil {
+		t.Fatalf("unable to decode the public key PEM %q", pubPem)
+	}
+	var pub crypto.PublicKey
+	var err error
+	switch b.Type {
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(b.Bytes)
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(b.Bytes)
+	default:
+		t.Fatalf("invalid public key PEM block type %q", b.Type)
+	}
+	if err != nil {
+		t.Fatalf("unable to parse %q public key: %s", b.Type, err)
+	}
+	return b.Type, pub
+}
+
+func loadPrivateKey(t *testing.T, prvPem []byte) crypto.PrivateKey {
+	b, _ := pem.Decode(prvPem)
+	if b == nil {
+		t.Fatalf("unable to decode the private key PEM")
+	}
+	prv, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		t.Fatalf("unable to parse the private key: %s", err)
+	}
+	return prv
+}
+
+// verifySignature signs a message with the prv key and verifies it with the pub one
+func verifySignature(t *testing.T, prv crypto.PrivateKey, pub crypto.PublicKey) {
+	msg := []byte("(request-target): post /inbox")
+	switch k := prv.(type) {
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256(msg)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		if err != nil {
+			t.Fatalf("unable to sign: %s", err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			t.Fatalf("public key is %T, expected %T", pub, rsaPub)
+		}
+		if err = rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, sum[:], sig); err != nil {
+			t.Errorf("unable to verify the signature with the published key: %s", err)
+		}
+	case ed25519.PrivateKey:
+		edPub, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			t.Fatalf("public key is %T, expected %T", pub, edPub)
+		}
+		if !ed25519.Verify(edPub, msg, ed25519.Sign(k, msg)) {
+			t.Errorf("unable to verify the signature with the published key")
+		}
+	default:
+		t.Fatalf("unexpected private key type %T", prv)
+	}
+}
+
+func TestAddKeyToPerson(t *testing.T) {
+	tests := []struct {
+		name     string
+		typ      string
+		enc      config.KeyEncoding
+		wantType string
+	}{
+		{
+			name:     "RSA PKIX",
+			typ:      KeyTypeRSA,
+			enc:      config.KeyEncodingPKIX,
+			wantType: "PUBLIC KEY",
+		},
+		{
+			name:     "RSA PKCS#1",
+			typ:      KeyTypeRSA,
+			enc:      config.KeyEncodingPKCS1,
+			wantType: "RSA PUBLIC KEY",
+		},
+		{
+			name:     "ED25519 PKIX",
+			typ:      KeyTypeED25519,
+			enc:      config.KeyEncodingPKIX,
+			wantType: "PUBLIC KEY",
+		},
+		{
+			name:     "ED25519 ignores PKCS#1",
+			typ:      KeyTypeED25519,
+			enc:      config.KeyEncodingPKCS1,
+			wantType: "PUBLIC KEY",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := make(mockMetadata)
+			act := vocab.Actor{ID: "https://example.com/actors/jdoe", Type: vocab.PersonType}
+			if err := AddKeyToPerson(meta, tt.typ, tt.enc)(&act); err != nil {
+				t.Fatalf("AddKeyToPerson() error = %s", err)
+			}
+			typ, pub := parsePublicKey(t, act.PublicKey.PublicKeyPem)
+			if typ != tt.wantType {
+				t.Errorf("published key PEM type = %q, expected %q", typ, tt.wantType)
+			}
+			verifySignature(t, loadPrivateKey(t, meta[act.ID].PrivateKey), pub)
+		})
+	}
+}
+
+func TestRefreshPublicKey(t *testing.T) {
+	meta := make(mockMetadata)
+	self := vocab.Actor{ID: "https://example.com", Type: vocab.ServiceType}
+	if err := AddKeyToPerson(meta, KeyTypeRSA, config.KeyEncodingPKIX)(&self); err != nil {
+		t.Fatalf("AddKeyToPerson() error = %s", err)
+	}
+	if refreshPublicKey(meta, &self, config.KeyEncodingPKIX) {
+		t.Errorf("refreshPublicKey() changed a key which was already PKIX encoded")
+	}
+	if !refreshPublicKey(meta, &self, config.KeyEncodingPKCS1) {
+		t.Fatalf("refreshPublicKey() didn't change the PKIX key to PKCS#1")
+	}
+	typ, pub := parsePublicKey(t, self.PublicKey.PublicKeyPem)
+	if typ != "RSA PUBLIC KEY" {
+		t.Errorf("published key PEM type = %q, expected %q", typ, "RSA PUBLIC KEY")
+	}
+	verifySignature(t, loadPrivateKey(t, meta[self.ID].PrivateKey), pub)
+
+	unknown := vocab.Actor{ID: "https://example.com/actors/unknown", Type: vocab.PersonType}
+	if refreshPublicKey(meta, &unknown, config.KeyEncodingPKIX) {
+		t.Errorf("refreshPublicKey() changed the key of an actor without metadata")
+	}
+}
+
+// mockKeyLoader loads the private keys of the actors from their metadata, like the storage backends do
+type mockKeyLoader struct {
+	mockMetadata
+}
+
+func (m mockKeyLoader) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
+	meta, err := m.LoadMetadata(iri)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := pem.Decode(meta.PrivateKey)
+	if b == nil {
+		return nil, errors.NotFoundf("no private key for %s", iri)
+	}
+	return x509.ParsePKCS8PrivateKey(b.Bytes)
+}
+
+func TestRotateKey(t *testing.T) {
+	db := mockKeyLoader{mockMetadata: make(mockMetadata)}
+	act := vocab.Actor{ID: "https://example.com/actors/jdoe", Type: vocab.PersonType}
+	if err := AddKeyToPerson(db, KeyTypeRSA, config.KeyEncodingPKIX)(&act); err != nil {
+		t.Fatalf("AddKeyToPerson() error = %s", err)
+	}
+	oldKey, _ := db.LoadKey(act.ID)
+	oldPem := act.PublicKey.PublicKeyPem
+	meta := db.mockMetadata[act.ID]
+	meta.Pw = []byte("password hash")
+	db.mockMetadata[act.ID] = meta
+
+	now := time.Now().UTC()
+	if err := RotateKey(db, &act, KeyTypeRSA, config.KeyEncodingPKIX, now); err != nil {
+		t.Fatalf("RotateKey() error = %s", err)
+	}
+	newKey, err := db.LoadKey(act.ID)
+	if err != nil {
+		t.Fatalf("LoadKey() error = %s", err)
+	}
+	if newKey.(*rsa.PrivateKey).Equal(oldKey) {
+		t.Errorf("LoadKey() returned the key from before the rotation")
+	}
+	if act.PublicKey.PublicKeyPem == oldPem {
+		t.Errorf("the published public key didn't change")
+	}
+	_, pub := parsePublicKey(t, act.PublicKey.PublicKeyPem)
+	if !newKey.(*rsa.PrivateKey).PublicKey.Equal(pub) {
+		t.Errorf("the published public key doesn't match the new private key")
+	}
+	verifySignature(t, newKey, pub)
+	if pw := string(db.mockMetadata[act.ID].Pw); pw != "password hash" {
+		t.Errorf("RotateKey() should keep the password of the actor, got %q", pw)
+	}
+
+	if prev := PreviousPublicKey(db, act.ID, config.KeyEncodingPKIX, now.Add(time.Hour)); prev != oldPem {
+		t.Errorf("PreviousPublicKey() = %q, expected the key from before the rotation %q", prev, oldPem)
+	}
+	if prev := PreviousPublicKey(db, act.ID, config.KeyEncodingPKIX, now.Add(PreviousKeyRetention+time.Minute)); prev != "" {
+		t.Errorf("PreviousPublicKey() returned a key after the retention")
+	}
+}
+
+func TestActorSignedWithPreviousKey(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	act := &vocab.Actor{ID: "https://fedbox.local/actors/jdoe", Type: vocab.PersonType}
+	db := mockMetadataStore{mockStore: mockStore{act.ID: act}, mockMetadata: mockMetadata{}}
+	if err := AddKeyToPerson(db, KeyTypeRSA, config.KeyEncodingPKIX)(act); err != nil {
+		t.Fatalf("AddKeyToPerson() error = %s", err)
+	}
+	oldKey := loadPrivateKey(t, db.mockMetadata[act.ID].PrivateKey)
+	now := time.Now().UTC()
+	if err := RotateKey(db, act, KeyTypeRSA, config.KeyEncodingPKIX, now); err != nil {
+		t.Fatalf("RotateKey() error = %s", err)
+	}
+
+	signed := func(key crypto.PrivateKey, keyID vocab.IRI) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://fedbox.local/actors/jdoe/inbox", nil)
+		r.Header.Set("Host", r.Host)
+		r.Header.Set("Date", now.Format(http.TimeFormat))
+		signer, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, httpsig.DigestSha256, []string{httpsig.RequestTarget, "Host", "Date"}, httpsig.Signature, 60)
+		if err != nil {
+			t.Fatalf("unable to create the HTTP signer: %s", err)
+		}
+		if err = signer.SignRequest(key, keyID.String(), r, nil); err != nil {
+			t.Fatalf("unable to sign the request: %s", err)
+		}
+		return r
+	}
+
+	keyID := act.ID + "#main-key"
+	if got, ok := actorSignedWithPreviousKey(db, base, signed(oldKey, keyID), now.Add(time.Hour)); !ok || got.ID != act.ID {
+		t.Errorf("the request signed with the previous key should be verified for %s, got %s", act.ID, got.ID)
+	}
+	if _, ok := actorSignedWithPreviousKey(db, base, signed(oldKey, keyID), now.Add(PreviousKeyRetention+time.Minute)); ok {
+		t.Errorf("the request signed with the previous key shouldn't be verified after the retention")
+	}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if _, ok := actorSignedWithPreviousKey(db, base, signed(other, keyID), now.Add(time.Hour)); ok {
+		t.Errorf("the request signed with another key shouldn't be verified")
+	}
+	if _, ok := actorSignedWithPreviousKey(db, "https://example.com", signed(oldKey, keyID), now.Add(time.Hour)); ok {
+		t.Errorf("the previous keys should be used only for the local actors")
+	}
+}
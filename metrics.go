@@ -0,0 +1,134 @@
+package fedbox
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "fedbox"
+
+type metrics struct {
+	registry            *prometheus.Registry
+	activitiesReceived  *prometheus.CounterVec
+	activitiesDelivered *prometheus.CounterVec
+	requestDuration     *prometheus.HistogramVec
+}
+
+// newMetrics creates the Prometheus registry with the collectors for the FedBOX instance identified by the self IRI
+func newMetrics(db processing.ReadStore, self vocab.IRI) *metrics {
+	m := metrics{
+		registry: prometheus.NewRegistry(),
+		activitiesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "activities_received_total",
+			Help:      "The number of activities received in the inboxes and outboxes of the local actors.",
+		}, []string{"collection", "type"}),
+		activitiesDelivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "activities_delivered_total",
+			Help:      "The number of activities processed for delivery from the outboxes of the local actors.",
+		}, []string{"type"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "The latency of the HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.activitiesReceived,
+		m.activitiesDelivered,
+		m.requestDuration,
+	)
+	if db == nil {
+		return &m
+	}
+	for _, col := range []vocab.CollectionPath{filters.ActorsType, filters.ActivitiesType, filters.ObjectsType} {
+		iri := col.IRI(self)
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "storage_items",
+			Help:        "The number of items in the storage collections.",
+			ConstLabels: prometheus.Labels{"collection": string(col)},
+		}, func() float64 {
+			cnt, _ := countItems(db, iri)
+			return float64(cnt)
+		}))
+	}
+	return &m
+}
+
+// activityReceived records an activity processed successfully in the col collection
+func (m *metrics) activityReceived(col vocab.CollectionPath, it vocab.Item) {
+	if m == nil || vocab.IsNil(it) {
+		return
+	}
+	typ := string(it.GetType())
+	m.activitiesReceived.WithLabelValues(string(col), typ).Inc()
+	if col == vocab.Outbox {
+		m.activitiesDelivered.WithLabelValues(typ).Inc()
+	}
+}
+
+// Middleware records the latency of the HTTP requests
+func (m *metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requestDuration.WithLabelValues(r.Method, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+func validMetricsToken(r *http.Request, token string) bool {
+	if len(token) == 0 {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+func handleMetrics(m *metrics, token string) http.HandlerFunc {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validMetricsToken(r, token) {
+			errors.HandleError(errors.Unauthorizedf("invalid metrics token")).ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// HandleMetrics serves the Prometheus metrics, optionally guarded by the bearer token set in the configuration
+func HandleMetrics(fb FedBOX) http.HandlerFunc {
+	if fb.metrics == nil {
+		return errors.NotFound.ServeHTTP
+	}
+	return handleMetrics(fb.metrics, fb.Config().MetricsToken)
+}
@@ -0,0 +1,58 @@
+package fedbox
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/filters"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	self := vocab.IRI("https://fedbox.local")
+	db := mockCollectionStore{mockStore{}}
+	db.AddTo(filters.ActorsType.IRI(self), vocab.IRI("https://fedbox.local/actors/johndoe"))
+	db.Create(&vocab.OrderedCollection{ID: filters.ActivitiesType.IRI(self), Type: vocab.OrderedCollectionType})
+	db.Create(&vocab.OrderedCollection{ID: filters.ObjectsType.IRI(self), Type: vocab.OrderedCollectionType})
+
+	m := newMetrics(db, self)
+	m.activityReceived(vocab.Outbox, &vocab.Activity{Type: vocab.CreateType})
+
+	// NOTE: record a request through the middleware, so the latency histogram gets exported
+	m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	h := handleMetrics(m, "s3cr3t")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("scraping without a token returned status %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer s3cr3t")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("scraping with a valid token returned status %d, expected %d", w.Code, http.StatusOK)
+	}
+	body, _ := io.ReadAll(w.Body)
+	expected := []string{
+		"fedbox_activities_received_total",
+		"fedbox_activities_delivered_total",
+		"fedbox_http_request_duration_seconds",
+		`fedbox_storage_items{collection="actors"} 1`,
+		`fedbox_storage_items{collection="activities"} 0`,
+		"go_goroutines",
+	}
+	for _, name := range expected {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("metric %q is missing from the scraped metrics", name)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package fedbox
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"net/http"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -24,3 +29,58 @@ func CleanRequestPath(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// MaxBodySize responds with a 413 Request Entity Too Large status to the requests with a body larger than limit.
+// The body is read, up to the limit, before calling the next handler, so the oversized ones are refused without
+// loading them into memory, even when they don't have a Content-Length.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tooLarge := fmt.Sprintf("the request body is larger than the maximum allowed size of %d bytes", limit)
+			if r.ContentLength > limit {
+				http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+			if err != nil {
+				// NOTE(marius): the error returned by the http.MaxBytesReader doesn't have its own type in go1.18
+				if strings.Contains(err.Error(), "request body too large") {
+					http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "unable to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestTimeout responds with a 503 Service Unavailable status to the requests which are not served in the timeout
+// duration, and cancels their context, so the storage queries and the outbound fetches using it are stopped.
+// The long-lived requests, like the ones for the streams, can be excluded by their paths.
+//
+// NOTE(marius): we don't use the chi middleware.Timeout, which responds with 504 Gateway Timeout, and only after
+// the handler returns, which the handlers not watching the context can delay indefinitely.
+func RequestTimeout(timeout time.Duration, skip ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		withTimeout := http.TimeoutHandler(next, timeout, "the request took too long to process")
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := path.Clean(r.URL.Path)
+			for _, s := range skip {
+				if p == s {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			withTimeout.ServeHTTP(w, r)
+		})
+	}
+}
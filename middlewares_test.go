@@ -1,6 +1,13 @@
 package fedbox
 
-import "testing"
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestActorFromAuthHeader(t *testing.T) {
 	t.Skipf("TODO")
@@ -13,3 +20,104 @@ func TestRepo(t *testing.T) {
 func TestValidator(t *testing.T) {
 	t.Skipf("TODO")
 }
+
+func TestMaxBodySize(t *testing.T) {
+	const limit = 64
+	activity := `{"type":"Like","actor":"https://example.com/actors/jdoe","object":"https://fedbox.local/objects/1"}`
+
+	tests := []struct {
+		name          string
+		body          string
+		unknownLength bool
+		status        int
+	}{
+		{name: "under the limit", body: activity[:limit], status: http.StatusAccepted},
+		{name: "over the limit", body: activity, status: http.StatusRequestEntityTooLarge},
+		{name: "over the limit without content length", body: activity, unknownLength: true, status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received = string(body)
+				w.WriteHeader(http.StatusAccepted)
+			})
+			r := httptest.NewRequest(http.MethodPost, "/inbox", strings.NewReader(tt.body))
+			if tt.unknownLength {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			MaxBodySize(limit)(next).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("POST of %d bytes returned status %d, expected %d", len(tt.body), w.Code, tt.status)
+			}
+			if tt.status == http.StatusAccepted && received != tt.body {
+				t.Errorf("the handler received %q, expected %q", received, tt.body)
+			}
+			if tt.status != http.StatusAccepted && received != "" {
+				t.Errorf("the handler has been called for an oversized body")
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+
+	release := make(chan struct{})
+	defer close(release)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		timeout time.Duration
+		path    string
+		status  int
+	}{
+		{name: "fast handler", handler: fast, timeout: timeout, path: "/actors", status: http.StatusOK},
+		{name: "slow handler", handler: slow, timeout: timeout, path: "/actors", status: http.StatusServiceUnavailable},
+		{name: "disabled timeout", handler: fast, timeout: 0, path: "/actors", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			start := time.Now()
+			RequestTimeout(tt.timeout, "/stream")(tt.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("GET %s returned status %d, expected %d", tt.path, w.Code, tt.status)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("GET %s returned after %s", tt.path, elapsed)
+			}
+		})
+	}
+
+	t.Run("excluded path", func(t *testing.T) {
+		done := make(chan int)
+		go func() {
+			w := httptest.NewRecorder()
+			RequestTimeout(timeout, "/stream")(slow).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+			done <- w.Code
+		}()
+		select {
+		case status := <-done:
+			t.Fatalf("the excluded path returned status %d before the handler finished", status)
+		case <-time.After(5 * timeout):
+		}
+		release <- struct{}{}
+		if status := <-done; status != http.StatusOK {
+			t.Errorf("the excluded path returned status %d, expected %d", status, http.StatusOK)
+		}
+	})
+}
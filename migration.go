@@ -0,0 +1,226 @@
+package fedbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+)
+
+const alsoKnownAsKey = "alsoKnownAs"
+
+// jsonFetcher is the interface for fetching the raw representation of remote objects, it's implemented by the
+// remoteFetcher, which refuses the hosts that resolve to the addresses of the instance's network.
+type jsonFetcher interface {
+	fetchJSON(context.Context, vocab.IRI) ([]byte, error)
+}
+
+// migrationClient is the functionality needed for loading the accounts of a migration
+type migrationClient interface {
+	iriLoader
+	jsonFetcher
+}
+
+// loadAliases returns the alsoKnownAs property of the iri actor.
+// We load it from the raw JSON representation of the actor, as the vocabulary package doesn't decode it.
+func loadAliases(cl jsonFetcher, iri vocab.IRI) (vocab.IRIs, error) {
+	data, err := cl.fetchJSON(context.Background(), iri)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load %s", iri)
+	}
+	props := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(data, &props); err != nil {
+		return nil, errors.NewNotValid(err, "invalid actor %s", iri)
+	}
+	raw, ok := props[alsoKnownAsKey]
+	if !ok {
+		return nil, nil
+	}
+	aliases := make([]string, 0)
+	if err = json.Unmarshal(raw, &aliases); err != nil {
+		var alias string
+		if err = json.Unmarshal(raw, &alias); err != nil {
+			return nil, errors.NewNotValid(err, "invalid %s of %s", alsoKnownAsKey, iri)
+		}
+		aliases = append(aliases, alias)
+	}
+	iris := make(vocab.IRIs, 0, len(aliases))
+	for _, alias := range aliases {
+		iris = append(iris, vocab.IRI(alias))
+	}
+	return iris, nil
+}
+
+// validateMove checks that the move activity migrates its actor, the origin account, to the account in its target,
+// and that the two accounts are linked both ways: the origin points to the target through the Move it has signed,
+// and the target lists the origin in its alsoKnownAs aliases.
+// It returns the origin and the target actors.
+func validateMove(cl migrationClient, move *vocab.Activity) (vocab.Item, vocab.Item, error) {
+	if vocab.IsNil(move.Actor) || vocab.IsNil(move.Target) {
+		return nil, nil, errors.NotValidf("invalid Move %s", move.GetLink())
+	}
+	originIRI := move.Actor.GetLink()
+	if !vocab.IsNil(move.Object) && !move.Object.GetLink().Equals(originIRI, false) {
+		return nil, nil, errors.Forbiddenf("%s can only move its own account", originIRI)
+	}
+	targetIRI := move.Target.GetLink()
+	if targetIRI.Equals(originIRI, false) {
+		return nil, nil, errors.NotValidf("%s can't move to itself", originIRI)
+	}
+	aliases, err := loadAliases(cl, targetIRI)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !aliases.Contains(originIRI) {
+		return nil, nil, errors.Forbiddenf("%s is not an alias of %s", originIRI, targetIRI)
+	}
+	origin, err := cl.LoadIRI(originIRI)
+	if err != nil {
+		return nil, nil, errors.Annotatef(err, "unable to load the origin account %s", originIRI)
+	}
+	target, err := cl.LoadIRI(targetIRI)
+	if err != nil {
+		return nil, nil, errors.Annotatef(err, "unable to load the target account %s", targetIRI)
+	}
+	return origin, target, nil
+}
+
+// localFollowers returns the IRIs of the local actors of the base service which follow the actor
+func localFollowers(db processing.ReadStore, base vocab.IRI, actor vocab.IRI) vocab.IRIs {
+	followers := make(vocab.IRIs, 0)
+	actors, err := db.Load(filters.ActorsType.IRI(base))
+	if err != nil {
+		return followers
+	}
+	vocab.OnCollectionIntf(actors, func(c vocab.CollectionInterface) error {
+		for _, local := range c.Collection() {
+			if vocab.IsNil(local) || !local.GetLink().Contains(base, false) {
+				continue
+			}
+			if collectionContains(db, vocab.Following.IRI(local), actor) {
+				followers = append(followers, local.GetLink())
+			}
+		}
+		return nil
+	})
+	return followers
+}
+
+// findFollow returns the IRI of the most recent Follow of the object published by the actor in its outbox, or
+// an empty IRI if there isn't one.
+func findFollow(db processing.ReadStore, actor, object vocab.IRI) vocab.IRI {
+	outbox, err := db.Load(vocab.Outbox.IRI(actor))
+	if err != nil || vocab.IsNil(outbox) {
+		return ""
+	}
+	var found *vocab.Activity
+	vocab.OnCollectionIntf(outbox, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			follow, err := loadFollow(db, it)
+			if err != nil || follow == nil || vocab.IsNil(follow.Actor) || vocab.IsNil(follow.Object) {
+				continue
+			}
+			if !follow.Actor.GetLink().Equals(actor, false) || !follow.Object.GetLink().Equals(object, false) {
+				continue
+			}
+			if found == nil || follow.Published.After(found.Published) {
+				found = follow
+			}
+		}
+		return nil
+	})
+	if found == nil {
+		return ""
+	}
+	return found.GetLink()
+}
+
+// publishAs generates the ID of the a activity of the local actor, and saves it to the actor's outbox
+func publishAs(db collectionStore, base vocab.IRI, actor vocab.IRI, a *vocab.Activity) error {
+	outbox := vocab.Outbox.IRI(actor)
+	if _, err := GenerateID(base)(a, outbox, actor); err != nil {
+		return err
+	}
+	if _, err := db.Save(a); err != nil {
+		return errors.Annotatef(err, "unable to save the %s of %s", a.GetType(), actor)
+	}
+	if err := db.AddTo(outbox, a.GetLink()); err != nil {
+		return errors.Annotatef(err, "unable to add %s to %s", a.GetLink(), outbox)
+	}
+	return nil
+}
+
+// migrateFollowers handles a Move activity received from a remote actor which migrates to a new account.
+// On behalf of each of the local actors following the origin account, it sends a Follow to the target account and
+// an Undo of the Follow to the origin, and removes the origin from the actor's following collection. The target
+// is added to it when it accepts the Follow.
+// The Moves which fail the validation are ignored.
+//
+// The function returns the IRIs of the collections that have been modified.
+func migrateFollowers(db collectionStore, cl migrationClient, q *deliveryQueue, base vocab.IRI, move *vocab.Activity, now time.Time) (vocab.IRIs, error) {
+	if move == nil || move.GetType() != vocab.MoveType || vocab.IsNil(move.Actor) || move.Actor.GetLink().Contains(base, false) {
+		return nil, nil
+	}
+	origin, target, err := validateMove(cl, move)
+	if err != nil {
+		return nil, err
+	}
+	originInbox, targetInbox := inboxOf(origin), inboxOf(target)
+	if targetInbox == "" {
+		return nil, errors.NotFoundf("no inbox found for the target account %s", target.GetLink())
+	}
+
+	modified := make(vocab.IRIs, 0)
+	var errs []error
+	for _, actor := range localFollowers(db, base, origin.GetLink()) {
+		follow := &vocab.Activity{
+			Type:      vocab.FollowType,
+			Actor:     actor,
+			Object:    target.GetLink(),
+			To:        vocab.ItemCollection{target.GetLink()},
+			Published: now,
+		}
+		// NOTE(marius): without the original Follow, eg: for the ones created before we kept the outboxes,
+		// the remote servers can still find the relationship from the actor and object of the embedded Follow
+		var undone vocab.Item = &vocab.Activity{Type: vocab.FollowType, Actor: actor, Object: origin.GetLink()}
+		if prev := findFollow(db, actor, origin.GetLink()); prev != "" {
+			undone = prev
+		}
+		undo := &vocab.Activity{
+			Type:      vocab.UndoType,
+			Actor:     actor,
+			Object:    undone,
+			To:        vocab.ItemCollection{origin.GetLink()},
+			Published: now,
+		}
+		for _, a := range []*vocab.Activity{follow, undo} {
+			if err = publishAs(db, base, actor, a); err != nil {
+				return modified, err
+			}
+		}
+		modified = append(modified, vocab.Outbox.IRI(actor))
+
+		following := vocab.Following.IRI(actor)
+		if err = db.RemoveFrom(following, origin.GetLink()); err != nil {
+			return modified, errors.Annotatef(err, "unable to remove %s from %s", origin.GetLink(), following)
+		}
+		modified = append(modified, following)
+
+		if err = q.deliver(targetInbox, follow, now); err != nil {
+			errs = append(errs, err)
+		}
+		if originInbox != "" {
+			if err = q.deliver(originInbox, undo, now); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return modified, errors.Annotatef(errs[0], "%d of the deliveries for the Move failed", len(errs))
+	}
+	return modified, nil
+}
@@ -0,0 +1,184 @@
+package fedbox
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+type mockMigrationClient struct {
+	*mockDeliverer
+	raw map[string]string
+}
+
+func (m mockMigrationClient) fetchJSON(_ context.Context, iri vocab.IRI) ([]byte, error) {
+	data, ok := m.raw[iri.String()]
+	if !ok {
+		return nil, errors.NotFoundf("%s not found", iri)
+	}
+	return []byte(data), nil
+}
+
+func TestMigrateFollowers(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	janeDoe := vocab.IRI("https://fedbox.local/actors/janedoe")
+
+	oldBob := &vocab.Actor{ID: "https://old.example.com/users/bob", Type: vocab.PersonType, Inbox: vocab.IRI("https://old.example.com/users/bob/inbox")}
+	newBob := &vocab.Actor{ID: "https://new.example.com/users/bob", Type: vocab.PersonType, Inbox: vocab.IRI("https://new.example.com/users/bob/inbox")}
+	move := &vocab.Activity{
+		ID:     "https://old.example.com/users/bob/move",
+		Type:   vocab.MoveType,
+		Actor:  oldBob.ID,
+		Object: oldBob.ID,
+		Target: newBob.ID,
+	}
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	setup := func(aliases string) (mockCollectionStore, mockMigrationClient, *deliveryQueue) {
+		db := mockCollectionStore{mockStore{}}
+		db.AddTo(filters.ActorsType.IRI(base), johnDoe)
+		db.AddTo(filters.ActorsType.IRI(base), janeDoe)
+		db.AddTo(vocab.Following.IRI(johnDoe), oldBob.ID)
+		// NOTE(marius): the Follow of the origin account that johndoe has sent, next to an older one, already undone
+		for _, f := range []*vocab.Activity{
+			{ID: "https://fedbox.local/activities/old-follow", Published: now.Add(-48 * time.Hour)},
+			{ID: "https://fedbox.local/activities/follow", Published: now.Add(-24 * time.Hour)},
+		} {
+			f.Type, f.Actor, f.Object = vocab.FollowType, johnDoe, oldBob.ID
+			db.Save(f)
+			db.AddTo(vocab.Outbox.IRI(johnDoe), f.ID)
+		}
+		cl := mockMigrationClient{
+			mockDeliverer: &mockDeliverer{
+				mockLoader: mockLoader{oldBob.ID: oldBob, newBob.ID: newBob},
+				delivered:  make(map[vocab.IRI]vocab.IRIs),
+			},
+			raw: map[string]string{
+				newBob.ID.String(): `{"id":"https://new.example.com/users/bob","type":"Person",` + aliases + `}`,
+			},
+		}
+		return db, cl, newDeliveryQueue(cl, 1, time.Minute, false)
+	}
+
+	t.Run("valid Move", func(t *testing.T) {
+		db, cl, q := setup(`"alsoKnownAs":["https://old.example.com/users/bob"]`)
+		modified, err := migrateFollowers(db, cl, q, base, move, now)
+		if err != nil {
+			t.Fatalf("migrateFollowers() returned error %s", err)
+		}
+		if !modified.Contains(vocab.Following.IRI(johnDoe)) {
+			t.Errorf("migrateFollowers() modified %v, expected the following collection of %s", modified, johnDoe)
+		}
+		if collectionContains(db, vocab.Following.IRI(johnDoe), oldBob.ID) {
+			t.Errorf("%s should not follow the origin account anymore", johnDoe)
+		}
+		follows := cl.delivered[newBob.Inbox.GetLink()]
+		if len(follows) != 1 {
+			t.Fatalf("expected a Follow delivered to %s, got %v", newBob.Inbox, follows)
+		}
+		follow, err := loadFollow(db, follows[0])
+		if err != nil {
+			t.Fatalf("unable to load the delivered Follow: %s", err)
+		}
+		if !follow.Actor.GetLink().Equals(johnDoe, false) || !follow.Object.GetLink().Equals(newBob.ID, false) {
+			t.Errorf("the Follow should be from %s to %s, got %s to %s", johnDoe, newBob.ID, follow.Actor.GetLink(), follow.Object.GetLink())
+		}
+		undos := cl.delivered[oldBob.Inbox.GetLink()]
+		if len(undos) != 1 {
+			t.Fatalf("expected an Undo delivered to %s, got %v", oldBob.Inbox, undos)
+		}
+		undo, _ := db.Load(undos[0])
+		if vocab.IsNil(undo) || undo.GetType() != vocab.UndoType {
+			t.Fatalf("the activity delivered to %s should be an Undo", oldBob.Inbox)
+		}
+		vocab.OnActivity(undo, func(u *vocab.Activity) error {
+			if !vocab.IsIRI(u.Object) || !u.Object.GetLink().Equals("https://fedbox.local/activities/follow", false) {
+				t.Errorf("the Undo should reference the most recent Follow of %s, got %v", oldBob.ID, u.Object)
+			}
+			return nil
+		})
+		if len(cl.delivered) != 2 {
+			t.Errorf("only the followers of the origin account should move, delivered %v", cl.delivered)
+		}
+	})
+	t.Run("without the original Follow", func(t *testing.T) {
+		db, cl, q := setup(`"alsoKnownAs":["https://old.example.com/users/bob"]`)
+		db.Delete(&vocab.Activity{ID: "https://fedbox.local/activities/old-follow"})
+		db.Delete(&vocab.Activity{ID: "https://fedbox.local/activities/follow"})
+		if _, err := migrateFollowers(db, cl, q, base, move, now); err != nil {
+			t.Fatalf("migrateFollowers() returned error %s", err)
+		}
+		undos := cl.delivered[oldBob.Inbox.GetLink()]
+		if len(undos) != 1 {
+			t.Fatalf("expected an Undo delivered to %s, got %v", oldBob.Inbox, undos)
+		}
+		undo, _ := db.Load(undos[0])
+		vocab.OnActivity(undo, func(u *vocab.Activity) error {
+			follow, err := loadFollow(db, u.Object)
+			if err != nil || !follow.Actor.GetLink().Equals(johnDoe, false) || !follow.Object.GetLink().Equals(oldBob.ID, false) {
+				t.Errorf("the Undo should embed the Follow of %s by %s, got %v", oldBob.ID, johnDoe, u.Object)
+			}
+			return nil
+		})
+	})
+	t.Run("missing back-reference", func(t *testing.T) {
+		db, cl, q := setup(`"alsoKnownAs":["https://other.example.com/users/bob"]`)
+		modified, err := migrateFollowers(db, cl, q, base, move, now)
+		if !errors.IsForbidden(err) {
+			t.Errorf("migrateFollowers() returned error %v, expected Forbidden", err)
+		}
+		if len(modified) > 0 || len(cl.delivered) > 0 {
+			t.Errorf("the Move should be ignored, modified %v, delivered %v", modified, cl.delivered)
+		}
+		if !collectionContains(db, vocab.Following.IRI(johnDoe), oldBob.ID) {
+			t.Errorf("%s should still follow the origin account", johnDoe)
+		}
+	})
+}
+
+func TestLoadAliases(t *testing.T) {
+	iri := vocab.IRI("https://example.com/users/bob")
+	tests := map[string]struct {
+		raw  string
+		want vocab.IRIs
+	}{
+		"missing": {raw: `{"id":"https://example.com/users/bob"}`, want: nil},
+		"string":  {raw: `{"alsoKnownAs":"https://old.example.com/bob"}`, want: vocab.IRIs{"https://old.example.com/bob"}},
+		"array":   {raw: `{"alsoKnownAs":["https://a.example.com/bob","https://b.example.com/bob"]}`, want: vocab.IRIs{"https://a.example.com/bob", "https://b.example.com/bob"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			cl := mockMigrationClient{raw: map[string]string{iri.String(): tt.raw}}
+			got, err := loadAliases(cl, iri)
+			if err != nil {
+				t.Fatalf("loadAliases() returned error %s", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("loadAliases() = %v, expected %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("loadAliases() = %v, expected %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadAliasesPrivateHost(t *testing.T) {
+	f := newRemoteFetcher(nil, mockStore{}, "https://fedbox.local", time.Second)
+	f.lookupIP = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	}
+	for _, iri := range []vocab.IRI{"http://internal.example/users/bob", "http://127.0.0.1/users/bob"} {
+		if _, err := loadAliases(f, iri); !errors.IsForbidden(err) {
+			t.Errorf("loadAliases(%s) returned error %v, expected Forbidden", iri, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+)
+
+const movedToKey = "movedTo"
+
+// movedActor is an actor which has migrated to a different account.
+// Its JSON representation contains the movedTo property pointing to the new account.
+type movedActor struct {
+	*vocab.Actor
+	MovedTo vocab.IRI
+}
+
+func (m movedActor) MarshalJSON() ([]byte, error) {
+	data, err := m.Actor.MarshalJSON()
+	if err != nil || len(m.MovedTo) == 0 {
+		return data, err
+	}
+	props := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(data, &props); err != nil {
+		return nil, err
+	}
+	if props[movedToKey], err = json.Marshal(m.MovedTo); err != nil {
+		return nil, err
+	}
+	return json.Marshal(props)
+}
+
+// loadMovedTo returns the IRI of the account that the actor has migrated to, based on the most recent Move
+// activity from its outbox. It returns an empty IRI if the actor didn't migrate.
+func loadMovedTo(db processing.ReadStore, actor vocab.Item) vocab.IRI {
+	if vocab.IsNil(actor) {
+		return ""
+	}
+	f := filters.FiltersNew(
+		filters.IRI(vocab.Outbox.IRI(actor)),
+		filters.Type(vocab.MoveType),
+	)
+	col, err := db.Load(f.GetLink())
+	if err != nil || vocab.IsNil(col) {
+		return ""
+	}
+	var last *vocab.Activity
+	vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if it.GetType() != vocab.MoveType {
+				continue
+			}
+			vocab.OnActivity(it, func(move *vocab.Activity) error {
+				if vocab.IsNil(move.Target) || !move.Actor.GetLink().Equals(actor.GetLink(), false) {
+					return nil
+				}
+				if !vocab.IsNil(move.Object) && !move.Object.GetLink().Equals(actor.GetLink(), false) {
+					return nil
+				}
+				if last == nil || move.Published.After(last.Published) {
+					last = move
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if last == nil {
+		return ""
+	}
+	return last.Target.GetLink()
+}
+
+// withMovedTo returns the it item with the movedTo property set, if it's an actor which has migrated
+// to a different account.
+func withMovedTo(db processing.ReadStore, it vocab.Item) vocab.Item {
+	if vocab.IsNil(it) || !vocab.ActorTypes.Contains(it.GetType()) {
+		return it
+	}
+	act, err := vocab.ToActor(it)
+	if err != nil {
+		return it
+	}
+	if movedTo := loadMovedTo(db, act); len(movedTo) > 0 {
+		return movedActor{Actor: act, MovedTo: movedTo}
+	}
+	return it
+}
+
+// isInteractiveRequest checks if the r request was made by a browser, rather than an ActivityPub client
+func isInteractiveRequest(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, client.ContentTypeActivityJson) || strings.Contains(accept, "application/ld+json") {
+		return false
+	}
+	return strings.Contains(accept, "text/html")
+}
+
+func redirectMovedActors(db processing.ReadStore, secure bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || !isInteractiveRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			iri := vocab.IRI(reqURL(r, secure))
+			if u, err := iri.URL(); err == nil {
+				u.RawQuery = ""
+				iri = vocab.IRI(u.String())
+			}
+			if it, err := db.Load(iri); err == nil && !vocab.IsNil(it) && vocab.ActorTypes.Contains(it.GetType()) {
+				if movedTo := loadMovedTo(db, it); len(movedTo) > 0 {
+					http.Redirect(w, r, movedTo.String(), http.StatusMovedPermanently)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RedirectMovedActors is a middleware which redirects the browser requests for an actor that has migrated to
+// its new account. The ActivityPub representation of the actor is served as usual for federation.
+func RedirectMovedActors(fb FedBOX) func(http.Handler) http.Handler {
+	if !fb.Config().RedirectMovedActors {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	return redirectMovedActors(fb.storage, fb.Config().Secure)
+}
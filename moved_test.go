@@ -0,0 +1,113 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func movedActorStore() (mockStore, *vocab.Actor, vocab.IRI) {
+	actor := &vocab.Actor{
+		ID:   "http://fedbox.local/actors/johndoe",
+		Type: vocab.PersonType,
+	}
+	newAccount := vocab.IRI("https://example.com/users/johndoe")
+	now := time.Now().UTC()
+	move := &vocab.Activity{
+		ID:        "http://fedbox.local/activities/move",
+		Type:      vocab.MoveType,
+		Actor:     actor.ID,
+		Object:    actor.ID,
+		Target:    newAccount,
+		Published: now,
+	}
+	oldMove := &vocab.Activity{
+		ID:        "http://fedbox.local/activities/old-move",
+		Type:      vocab.MoveType,
+		Actor:     actor.ID,
+		Object:    actor.ID,
+		Target:    vocab.IRI("https://example.com/users/old-johndoe"),
+		Published: now.Add(-time.Hour),
+	}
+	return mockStore{actor.ID: actor, move.ID: move, oldMove.ID: oldMove}, actor, newAccount
+}
+
+func TestWithMovedTo(t *testing.T) {
+	db, actor, newAccount := movedActorStore()
+
+	it := withMovedTo(db, actor)
+	data, err := json.Marshal(it)
+	if err != nil {
+		t.Fatalf("unable to marshal the actor: %s", err)
+	}
+	props := make(map[string]interface{})
+	if err = json.Unmarshal(data, &props); err != nil {
+		t.Fatalf("unable to unmarshal the actor: %s", err)
+	}
+	if props[movedToKey] != newAccount.String() {
+		t.Errorf("the actor's movedTo is %v, expected %s", props[movedToKey], newAccount)
+	}
+	if props["id"] != actor.ID.String() {
+		t.Errorf("the actor's id is %v, expected %s", props["id"], actor.ID)
+	}
+
+	notMoved := &vocab.Actor{ID: "http://fedbox.local/actors/janedoe", Type: vocab.PersonType}
+	if it = withMovedTo(db, notMoved); it != vocab.Item(notMoved) {
+		t.Errorf("an actor which didn't migrate should not be changed")
+	}
+}
+
+func TestRedirectMovedActors(t *testing.T) {
+	db, actor, newAccount := movedActorStore()
+
+	served := false
+	h := redirectMovedActors(db, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		accept     string
+		wantStatus int
+	}{
+		{
+			name:       "browser request",
+			accept:     "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			wantStatus: http.StatusMovedPermanently,
+		},
+		{
+			name:       "federation request",
+			accept:     "application/activity+json",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = false
+			u, _ := actor.ID.URL()
+			r := httptest.NewRequest(http.MethodGet, u.Path, nil)
+			r.Host = u.Host
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("request returned status %d, expected %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusMovedPermanently {
+				if loc := w.Header().Get("Location"); loc != newAccount.String() {
+					t.Errorf("request was redirected to %q, expected %q", loc, newAccount.String())
+				}
+				if served {
+					t.Errorf("the actor should not have been served for a browser request")
+				}
+			} else if !served {
+				t.Errorf("the actor should have been served for a federation request")
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package fedbox
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+)
+
+const contentTypeHTML = "text/html"
+
+// offeredContentTypes are the representations we can serve for the ActivityPub items, the first one is the default
+var offeredContentTypes = []string{client.ContentTypeActivityJson, client.ContentTypeJsonLD, contentTypeHTML}
+
+// negotiateContentType returns the content type from the offered list that best matches the accept header.
+// When the header is missing or it doesn't match any of the offered types, it returns the first one.
+func negotiateContentType(accept string, offered []string) string {
+	best := offered[0]
+	if len(accept) == 0 {
+		return best
+	}
+	bestQ := -1.0
+	bestSpecificity := -1
+	for _, rng := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(rng))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qv, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qv, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		for _, typ := range offered {
+			specificity := 0
+			switch {
+			case mediaType == typ || strings.HasPrefix(typ, mediaType+";"):
+				specificity = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(typ, strings.TrimSuffix(mediaType, "*")):
+				specificity = 1
+			case mediaType == "*/*":
+				specificity = 0
+			default:
+				continue
+			}
+			if q > bestQ || (q == bestQ && specificity > bestSpecificity) {
+				best, bestQ, bestSpecificity = typ, q, specificity
+			}
+		}
+	}
+	return best
+}
+
+// itemPage is the model for the minimal HTML representation of an ActivityPub item
+type itemPage struct {
+	ID      vocab.IRI
+	Type    vocab.ActivityVocabularyType
+	Name    string
+	Summary string
+	Content string
+	Items   vocab.IRIs
+}
+
+func (i itemPage) Title() string {
+	if len(i.Name) > 0 {
+		return i.Name
+	}
+	return i.ID.String()
+}
+
+func newItemPage(it vocab.Item) itemPage {
+	p := itemPage{ID: it.GetLink(), Type: it.GetType()}
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		p.Name = o.Name.First().String()
+		p.Summary = o.Summary.First().String()
+		p.Content = o.Content.First().String()
+		return nil
+	})
+	if vocab.ActorTypes.Contains(p.Type) {
+		vocab.OnActor(it, func(a *vocab.Actor) error {
+			if a.PreferredUsername != nil {
+				p.Name = a.PreferredUsername.First().String()
+			}
+			return nil
+		})
+	}
+	if it.IsCollection() {
+		vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+			for _, ob := range col.Collection() {
+				p.Items = append(p.Items, ob.GetLink())
+			}
+			return nil
+		})
+	}
+	return p
+}
+
+// renderHTML writes the minimal HTML representation of the ActivityPub item found in the data JSON document.
+func renderHTML(w http.ResponseWriter, data []byte) error {
+	it, err := vocab.UnmarshalJSON(data)
+	if err != nil {
+		return err
+	}
+	if vocab.IsNil(it) {
+		return errors.NotValidf("unable to render an empty item")
+	}
+	w.Header().Del("Content-Length")
+	return ren.HTML(w, http.StatusOK, "item", newItemPage(it))
+}
+
+// isActivityPubContentType checks if the typ content type is one of the ActivityPub JSON-LD representations
+func isActivityPubContentType(typ string) bool {
+	mediaType, _, err := mime.ParseMediaType(typ)
+	return err == nil && (mediaType == client.ContentTypeActivityJson || mediaType == "application/ld+json")
+}
+
+// contentTypeWriter replaces the ActivityPub Content-Type of the response with the typ one, which the client asked for.
+// Both are representations of the same ActivityStreams document.
+type contentTypeWriter struct {
+	http.ResponseWriter
+	typ         string
+	wroteHeader bool
+}
+
+func (c *contentTypeWriter) WriteHeader(status int) {
+	if !c.wroteHeader && isActivityPubContentType(c.Header().Get("Content-Type")) {
+		c.Header().Set("Content-Type", c.typ)
+	}
+	c.wroteHeader = true
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *contentTypeWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+func contentNegotiation(frontend string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			offered := offeredContentTypes
+			if isOutboxPath(r.URL.Path) {
+				offered = append(offered[:len(offered):len(offered)], contentTypeRSS)
+			}
+			typ := negotiateContentType(r.Header.Get("Accept"), offered)
+			if typ == contentTypeRSS && r.Method == http.MethodGet {
+				bw := bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(&bw, r)
+
+				if bw.status == http.StatusOK {
+					if err := renderRSS(w, bw.buf.Bytes()); err == nil {
+						return
+					}
+				}
+				w.WriteHeader(bw.status)
+				w.Write(bw.buf.Bytes())
+				return
+			}
+			if typ != contentTypeHTML || r.Method == http.MethodHead {
+				// NOTE(marius): the clients asking for application/ld+json, with or without the ActivityStreams
+				// profile, receive the ActivityStreams representation, so we tell them it has the profile
+				if typ == contentTypeHTML || typ == contentTypeRSS {
+					typ = offeredContentTypes[0]
+				}
+				next.ServeHTTP(&contentTypeWriter{ResponseWriter: w, typ: typ}, r)
+				return
+			}
+			if len(frontend) > 0 {
+				http.Redirect(w, r, strings.TrimSuffix(frontend, "/")+r.URL.RequestURI(), http.StatusSeeOther)
+				return
+			}
+
+			bw := bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(&bw, r)
+
+			if bw.status == http.StatusOK {
+				if err := renderHTML(w, bw.buf.Bytes()); err == nil {
+					return
+				}
+			}
+			w.WriteHeader(bw.status)
+			w.Write(bw.buf.Bytes())
+		})
+	}
+}
+
+// ContentNegotiation is a middleware which serves a minimal HTML representation of the ActivityPub items to the
+// clients which prefer text/html, like browsers, or redirects them to the configured front-end. The clients which
+// prefer application/rss+xml receive the public notes of the outboxes as an RSS feed.
+// The clients accepting application/activity+json or application/ld+json receive the JSON-LD representation, with
+// the Content-Type they asked for. For application/ld+json it includes the ActivityStreams profile.
+func ContentNegotiation(fb FedBOX) func(http.Handler) http.Handler {
+	return contentNegotiation(fb.Config().FrontendURL)
+}
@@ -0,0 +1,103 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	tests := map[string]string{
+		"":                          client.ContentTypeActivityJson,
+		"*/*":                       client.ContentTypeActivityJson,
+		"application/activity+json": client.ContentTypeActivityJson,
+		`application/ld+json; profile="https://www.w3.org/ns/activitystreams"`: client.ContentTypeJsonLD,
+		"application/ld+json": client.ContentTypeJsonLD,
+		"text/html":           contentTypeHTML,
+		"text/*":              contentTypeHTML,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": contentTypeHTML,
+		"text/html;q=0.5, application/activity+json":                      client.ContentTypeActivityJson,
+		"application/activity+json;q=0.1, text/html;q=0.9":                contentTypeHTML,
+		"image/png": client.ContentTypeActivityJson,
+	}
+	for accept, want := range tests {
+		if got := negotiateContentType(accept, offeredContentTypes); got != want {
+			t.Errorf("negotiateContentType(%q) = %q, expected %q", accept, got, want)
+		}
+	}
+}
+
+func negotiatedRequest(h http.Handler, accept string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/actors/johndoe", nil)
+	if len(accept) > 0 {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestContentNegotiation(t *testing.T) {
+	johnDoe := &vocab.Actor{
+		ID:                "https://fedbox.local/actors/johndoe",
+		Type:              vocab.PersonType,
+		PreferredUsername: vocab.NaturalLanguageValues{{Value: vocab.Content("johndoe")}},
+	}
+	data, _ := vocab.MarshalJSON(johnDoe)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	})
+
+	h := contentNegotiation("")(next)
+	accepted := map[string]string{
+		"":                             client.ContentTypeActivityJson,
+		client.ContentTypeActivityJson: client.ContentTypeActivityJson,
+		client.ContentTypeJsonLD:       client.ContentTypeJsonLD,
+		"application/ld+json":          client.ContentTypeJsonLD,
+		`application/ld+json;profile="https://www.w3.org/ns/activitystreams", text/html;q=0.1`: client.ContentTypeJsonLD,
+	}
+	for accept, want := range accepted {
+		w := negotiatedRequest(h, accept)
+		if w.Code != http.StatusOK {
+			t.Errorf("Accept %q returned status %d, expected %d", accept, w.Code, http.StatusOK)
+		}
+		if typ := w.Header().Get("Content-Type"); typ != want {
+			t.Errorf("Accept %q returned Content-Type %q, expected %q", accept, typ, want)
+		}
+		if w.Body.String() != string(data) {
+			t.Errorf("Accept %q returned a different body than the JSON-LD document", accept)
+		}
+	}
+
+	w := negotiatedRequest(h, "text/html,application/xhtml+xml,*/*;q=0.8")
+	if w.Code != http.StatusOK {
+		t.Errorf("Accept text/html returned status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if typ := w.Header().Get("Content-Type"); !strings.HasPrefix(typ, contentTypeHTML) {
+		t.Errorf("Accept text/html returned Content-Type %q, expected %q", typ, contentTypeHTML)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "johndoe") || !strings.Contains(body, johnDoe.ID.String()) {
+		t.Errorf("HTML representation doesn't contain the actor's handle and IRI: %s", body)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("Vary header %q, expected %q", vary, "Accept")
+	}
+
+	h = contentNegotiation("https://front.example.com/")(next)
+	w = negotiatedRequest(h, "text/html")
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("Accept text/html with a front-end returned status %d, expected %d", w.Code, http.StatusSeeOther)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://front.example.com/actors/johndoe" {
+		t.Errorf("Location header %q, expected %q", loc, "https://front.example.com/actors/johndoe")
+	}
+	if w = negotiatedRequest(h, client.ContentTypeActivityJson); w.Code != http.StatusOK {
+		t.Errorf("Accept %q with a front-end returned status %d, expected %d", client.ContentTypeActivityJson, w.Code, http.StatusOK)
+	}
+}
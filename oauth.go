@@ -1,12 +1,15 @@
 package fedbox
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"time"
 
 	"git.sr.ht/~mariusor/lw"
@@ -63,11 +66,52 @@ func (a *account) FromActor(p *vocab.Actor) {
 }
 
 type authService struct {
-	baseIRI vocab.IRI
-	genID   processing.IDGenerator
-	storage FullStorage
-	auth    auth.Server
-	logger  lw.Logger
+	baseIRI           vocab.IRI
+	genID             processing.IDGenerator
+	storage           FullStorage
+	auth              auth.Server
+	refreshExpiration time.Duration
+	logger            lw.Logger
+}
+
+// configureOAuth2Server sets up the OAuth2 server to issue access tokens valid for the accessExpiration duration,
+// to rotate the refresh tokens, invalidating the old ones when a new access token gets minted, and to require
+// PKCE for the public clients.
+func configureOAuth2Server(s *auth.Server, accessExpiration time.Duration) {
+	if s == nil || s.Server == nil || s.Config == nil {
+		return
+	}
+	if accessExpiration > 0 {
+		s.Config.AccessExpiration = int32(accessExpiration.Seconds())
+	}
+	if !s.Config.AllowedAccessTypes.Exists(osin.REFRESH_TOKEN) {
+		s.Config.AllowedAccessTypes = append(s.Config.AllowedAccessTypes, osin.REFRESH_TOKEN)
+	}
+	s.Config.RetainTokenAfterRefresh = false
+	// NOTE(marius): the OAuth2 server verifies the code_verifier of the exchanges for the authorization codes
+	// which have a code_challenge, this makes the challenge mandatory for the clients without a secret
+	s.Config.RequirePKCEForPublicClients = true
+}
+
+// userDataIRI returns the IRI of the actor we store as user data in the OAuth2 authorization and access records
+func userDataIRI(d interface{}) vocab.IRI {
+	switch u := d.(type) {
+	case vocab.IRI:
+		return u
+	case string:
+		return vocab.IRI(u)
+	case []byte:
+		return vocab.IRI(strings.Trim(string(u), `"`))
+	}
+	return ""
+}
+
+// refreshExpired checks if the refresh token of the ad access data is older than the configured duration
+func (i *authService) refreshExpired(ad *osin.AccessData) bool {
+	if ad == nil || i.refreshExpiration <= 0 {
+		return false
+	}
+	return ad.CreatedAt.Add(i.refreshExpiration).Before(time.Now().UTC())
 }
 
 const (
@@ -357,6 +401,18 @@ func (i *authService) Token(w http.ResponseWriter, r *http.Request) {
 			if iri, ok := ar.UserData.(string); ok {
 				actorFilters.IRI = vocab.IRI(iri)
 			}
+		case osin.REFRESH_TOKEN:
+			if i.refreshExpired(ar.AccessData) {
+				resp.SetError(osin.E_INVALID_GRANT, "refresh token has expired")
+				redirectOrOutput(resp, w, r)
+				return
+			}
+			if ar.AccessData != nil {
+				actorFilters.IRI = userDataIRI(ar.AccessData.UserData)
+			}
+		}
+		if ar.Type == osin.PASSWORD || ar.Type == osin.AUTHORIZATION_CODE {
+			ar.GenerateRefresh = true
 		}
 		actor, err := i.storage.Load(actorFilters.GetLink())
 		if err != nil {
@@ -390,7 +446,7 @@ func (i *authService) Token(w http.ResponseWriter, r *http.Request) {
 			ar.Authorized = acc.IsLogged()
 			ar.UserData = acc.actor.GetLink()
 		}
-		if ar.Type == osin.AUTHORIZATION_CODE {
+		if ar.Type == osin.AUTHORIZATION_CODE || ar.Type == osin.REFRESH_TOKEN {
 			vocab.OnActor(actor, func(p *vocab.Actor) error {
 				acc = new(account)
 				acc.FromActor(p)
@@ -404,6 +460,223 @@ func (i *authService) Token(w http.ResponseWriter, r *http.Request) {
 	redirectOrOutput(resp, w, r)
 }
 
+const (
+	tokenKey         = "token"
+	tokenTypeHintKey = "token_type_hint"
+	refreshTokenHint = "refresh_token"
+	clientSecretKey  = "client_secret"
+)
+
+// allowClientSecretInParams checks if the OAuth2 server accepts the client credentials in the request parameters
+func (i *authService) allowClientSecretInParams() bool {
+	return i.auth.Server != nil && i.auth.Config != nil && i.auth.Config.AllowClientSecretInParams
+}
+
+// authenticateClient loads the OAuth2 client from the credentials sent in the request.
+// We look first for the HTTP Basic authorization header, and, like the OAuth2 server does for the token requests,
+// we fallback to the client_id and client_secret POST parameters only if its configuration allows them.
+func (i *authService) authenticateClient(r *http.Request) (osin.Client, error) {
+	var id, secret string
+	if ba, err := osin.CheckBasicAuth(r); err == nil && ba != nil {
+		id, secret = ba.Username, ba.Password
+	} else if i.allowClientSecretInParams() {
+		id, secret = r.PostFormValue(clientIdKey), r.PostFormValue(clientSecretKey)
+	}
+	if len(id) == 0 {
+		return nil, errors.Unauthorizedf("missing client credentials")
+	}
+	cl, err := i.storage.GetClient(id)
+	if err != nil || cl == nil {
+		return nil, errors.Unauthorizedf("invalid client credentials")
+	}
+	if !osin.CheckClientSecret(cl, secret) {
+		return nil, errors.Unauthorizedf("invalid client credentials")
+	}
+	return cl, nil
+}
+
+// revokeAccess removes the access data identified by the token, if it belongs to the cl client.
+// When the token is a refresh token, we remove the access token that was generated with it too.
+func (i *authService) revokeAccess(cl osin.Client, token, hint string) error {
+	loaders := []func(string) (*osin.AccessData, error){i.storage.LoadAccess, i.storage.LoadRefresh}
+	if hint == refreshTokenHint {
+		loaders[0], loaders[1] = loaders[1], loaders[0]
+	}
+	for _, load := range loaders {
+		ad, err := load(token)
+		if err != nil || ad == nil {
+			continue
+		}
+		if ad.Client == nil || ad.Client.GetId() != cl.GetId() {
+			return errors.Forbiddenf("token was not issued to client %s", cl.GetId())
+		}
+		if len(ad.RefreshToken) > 0 {
+			if err = i.storage.RemoveRefresh(ad.RefreshToken); err != nil {
+				return err
+			}
+		}
+		return i.storage.RemoveAccess(ad.AccessToken)
+	}
+	// NOTE: unknown tokens are not considered an error, see RFC7009 section 2.2
+	return nil
+}
+
+// Revoke handles POST /oauth/revoke requests, invalidating access and refresh tokens as described in RFC7009
+func (i *authService) Revoke(w http.ResponseWriter, r *http.Request) {
+	cl, err := i.authenticateClient(r)
+	if err != nil {
+		i.logger.Errorf("%s", err)
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	token := r.PostFormValue(tokenKey)
+	if len(token) == 0 {
+		errors.HandleError(errors.BadRequestf("missing token")).ServeHTTP(w, r)
+		return
+	}
+	if err = i.revokeAccess(cl, token, r.PostFormValue(tokenTypeHintKey)); err != nil {
+		i.logger.Errorf("%s", err)
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+const redirectURISeparator = "\n"
+
+// clientRegistration represents the client metadata of a dynamic client registration request and response, as
+// described in RFC7591
+type clientRegistration struct {
+	ClientID              string   `json:"client_id,omitempty"`
+	ClientSecret          string   `json:"client_secret,omitempty"`
+	ClientIDIssuedAt      int64    `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt int64    `json:"client_secret_expires_at"`
+	ClientName            string   `json:"client_name,omitempty"`
+	RedirectURIs          []string `json:"redirect_uris"`
+	Scope                 string   `json:"scope,omitempty"`
+}
+
+// isLocalhost checks if the host of a redirect URI points to the loopback interface
+func isLocalhost(host string) bool {
+	switch host {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// validRedirectURI checks that the redirect URI of a dynamically registered client is absolute, and that it uses
+// the https scheme, unless it points to localhost.
+func validRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return errors.NewBadRequest(err, "invalid redirect URI %q", uri)
+	}
+	if !u.IsAbs() || len(u.Host) == 0 {
+		return errors.BadRequestf("redirect URI %q is not absolute", uri)
+	}
+	if len(u.Fragment) > 0 {
+		return errors.BadRequestf("redirect URI %q must not contain a fragment", uri)
+	}
+	if u.Scheme == "https" || (u.Scheme == "http" && isLocalhost(u.Hostname())) {
+		return nil
+	}
+	return errors.BadRequestf("redirect URI %q must use https or point to localhost", uri)
+}
+
+func generateClientSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// registerClient creates the Application actor and the OAuth2 client corresponding to the req registration request.
+func (i *authService) registerClient(req clientRegistration) (*clientRegistration, error) {
+	if len(req.RedirectURIs) == 0 {
+		return nil, errors.BadRequestf("missing redirect_uris")
+	}
+	for _, uri := range req.RedirectURIs {
+		if err := validRedirectURI(uri); err != nil {
+			return nil, err
+		}
+	}
+	if len(req.ClientName) == 0 {
+		u, _ := url.Parse(req.RedirectURIs[0])
+		req.ClientName = u.Host
+	}
+
+	self := ap.Self(i.baseIRI)
+	now := time.Now().UTC()
+	app := &vocab.Actor{
+		Type:         vocab.ApplicationType,
+		AttributedTo: self.GetLink(),
+		Audience:     vocab.ItemCollection{vocab.PublicNS},
+		Generator:    self.GetLink(),
+		Published:    now,
+		Updated:      now,
+		PreferredUsername: vocab.NaturalLanguageValues{
+			{vocab.NilLangRef, vocab.Content(req.ClientName)},
+		},
+		URL: vocab.IRI(req.RedirectURIs[0]),
+	}
+	if i.genID != nil {
+		id, err := i.genID(app, vocab.Outbox.IRI(self), self)
+		if err != nil {
+			return nil, err
+		}
+		app.ID = id
+	}
+	saved, err := i.storage.Save(app)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to save the client application")
+	}
+	id := path.Base(saved.GetLink().String())
+	if len(id) == 0 || id == "." {
+		return nil, errors.Newf("invalid client application saved, id is null")
+	}
+
+	secret, err := generateClientSecret()
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to generate client secret")
+	}
+	userData, _ := json.Marshal(saved.GetLink())
+	cl := osin.DefaultClient{
+		Id:          id,
+		Secret:      secret,
+		RedirectUri: strings.Join(req.RedirectURIs, redirectURISeparator),
+		UserData:    userData,
+	}
+	if err = i.storage.CreateClient(&cl); err != nil {
+		return nil, errors.Annotatef(err, "unable to save the OAuth2 client")
+	}
+	req.ClientID = id
+	req.ClientSecret = secret
+	req.ClientIDIssuedAt = now.Unix()
+	req.ClientSecretExpiresAt = 0
+	return &req, nil
+}
+
+// Register serves POST /oauth/register requests, for the dynamic registration of OAuth2 clients
+func (i *authService) Register(w http.ResponseWriter, r *http.Request) {
+	req := clientRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.HandleError(errors.NewBadRequest(err, "invalid client metadata")).ServeHTTP(w, r)
+		return
+	}
+	res, err := i.registerClient(req)
+	if err != nil {
+		i.logger.Errorf("%s", err)
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
 func annotatedRsError(status int, old error, msg string, args ...interface{}) error {
 	var err error
 	switch status {
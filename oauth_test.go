@@ -0,0 +1,48 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ap/auth"
+	"github.com/openshift/osin"
+)
+
+func TestConfigureOAuth2Server(t *testing.T) {
+	s := &auth.Server{Server: &osin.Server{Config: &osin.ServerConfig{RetainTokenAfterRefresh: true}}}
+	configureOAuth2Server(s, time.Hour)
+	if s.Config.AccessExpiration != int32(time.Hour.Seconds()) {
+		t.Errorf("the access tokens expire after %ds, expected %s", s.Config.AccessExpiration, time.Hour)
+	}
+	if s.Config.RetainTokenAfterRefresh {
+		t.Errorf("the refresh tokens should be rotated")
+	}
+	if !s.Config.RequirePKCEForPublicClients {
+		t.Errorf("the public clients should be required to use PKCE")
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		wantErr bool
+	}{
+		{uri: "https://example.com/callback"},
+		{uri: "http://localhost:8080/callback"},
+		{uri: "http://127.0.0.1/callback"},
+		{uri: "http://[::1]:3000/callback"},
+		{uri: "http://example.com/callback", wantErr: true},
+		{uri: "/callback", wantErr: true},
+		{uri: "example.com/callback", wantErr: true},
+		{uri: "https://example.com/callback#fragment", wantErr: true},
+		{uri: "custom-scheme://callback", wantErr: true},
+		{uri: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if err := validRedirectURI(tt.uri); (err != nil) != tt.wantErr {
+				t.Errorf("validRedirectURI() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/processing"
+)
+
+// outboxStore is the storage used when processing the activities that the clients post to the outboxes.
+// The items the clients send are saved only if they belong to the base service: the ones without an IRI get one
+// generated, the ones with an IRI that isn't local are refused, and so is overwriting an existing item with one of
+// a different type.
+// The other methods of the storage are passed through, so the outboxStore can be serialized like the other ones.
+type outboxStore struct {
+	wrappedStorage
+	base  vocab.IRI
+	genID processing.IDGenerator
+	by    vocab.Item
+}
+
+func newOutboxStore(s FullStorage, base vocab.IRI, gen ap.IDGenerator, by vocab.Item) outboxStore {
+	return outboxStore{wrappedStorage: wrappedStorage{FullStorage: s}, base: base, genID: GenerateIDWith(base, gen), by: by}
+}
+
+// storedItem returns the item with the iri, if it exists in the storage
+func (s outboxStore) storedItem(iri vocab.IRI) vocab.Item {
+	it, err := s.Load(iri)
+	if err != nil {
+		return nil
+	}
+	// NOTE(marius): the storage can return a collection for an IRI it doesn't have
+	if it = firstItem(it); vocab.IsNil(it) || !it.GetLink().Equals(iri, false) {
+		return nil
+	}
+	return it
+}
+
+// validate checks that the it item can be saved, generating its IRI when it's missing
+func (s outboxStore) validate(it vocab.Item) error {
+	iri := it.GetLink()
+	if len(iri) == 0 {
+		id, err := s.genID(it, nil, s.by)
+		if err != nil {
+			return errors.Annotatef(err, "unable to generate the IRI of the %s", it.GetType())
+		}
+		return vocab.OnObject(it, func(o *vocab.Object) error {
+			o.ID = id
+			return nil
+		})
+	}
+	if !iri.Contains(s.base, false) {
+		return errors.BadRequestf("unable to save %s, it's not a local IRI", iri)
+	}
+	// NOTE(marius): the deleted items are replaced by Tombstones
+	if prev := s.storedItem(iri); prev != nil && prev.GetType() != it.GetType() && it.GetType() != vocab.TombstoneType {
+		return errors.Conflictf("unable to save %s as %s, it already exists as %s", iri, it.GetType(), prev.GetType())
+	}
+	return nil
+}
+
+// Save saves the it item, after validating it
+func (s outboxStore) Save(it vocab.Item) (vocab.Item, error) {
+	if vocab.IsNil(it) {
+		return it, errors.NotValidf("unable to save an empty item")
+	}
+	if err := s.validate(it); err != nil {
+		return it, err
+	}
+	return s.FullStorage.Save(it)
+}
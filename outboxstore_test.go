@@ -0,0 +1,91 @@
+package fedbox
+
+import (
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+)
+
+func TestOutboxStoreSave(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	existing := &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType}
+
+	newStore := func() (mockStore, outboxStore) {
+		db := mockStore{}
+		db.Save(existing)
+		return db, newOutboxStore(mockFullStorage{mockCollectionStore: mockCollectionStore{db}}, base, nil, johnDoe)
+	}
+
+	t.Run("generates the missing IRI", func(t *testing.T) {
+		db, s := newStore()
+		saved, err := s.Save(&vocab.Object{Type: vocab.NoteType})
+		if err != nil {
+			t.Fatalf("Save() error = %s", err)
+		}
+		iri := saved.GetLink()
+		if !strings.HasPrefix(iri.String(), "https://fedbox.local/objects/") {
+			t.Errorf("Save() generated the IRI %q, expected one in the objects collection", iri)
+		}
+		if _, ok := db[iri]; !ok {
+			t.Errorf("the object hasn't been saved with the generated IRI %s", iri)
+		}
+	})
+	t.Run("refuses foreign IRIs", func(t *testing.T) {
+		db, s := newStore()
+		foreign := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType}
+		if _, err := s.Save(foreign); !errors.IsBadRequest(err) {
+			t.Errorf("Save() error = %v, expected a bad request", err)
+		}
+		if _, ok := db[foreign.ID]; ok {
+			t.Errorf("the foreign object has been saved")
+		}
+	})
+	t.Run("refuses type collisions", func(t *testing.T) {
+		db, s := newStore()
+		collision := &vocab.Object{ID: existing.ID, Type: vocab.ImageType}
+		if _, err := s.Save(collision); !errors.IsConflict(err) {
+			t.Errorf("Save() error = %v, expected a conflict", err)
+		}
+		if db[existing.ID].GetType() != vocab.NoteType {
+			t.Errorf("the %s has been overwritten by a %s", existing.GetType(), db[existing.ID].GetType())
+		}
+	})
+	t.Run("updates items of the same type", func(t *testing.T) {
+		db, s := newStore()
+		updated := &vocab.Object{ID: existing.ID, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguageValue("updated")}
+		if _, err := s.Save(updated); err != nil {
+			t.Fatalf("Save() error = %s", err)
+		}
+		if db[existing.ID] != updated {
+			t.Errorf("the object hasn't been updated")
+		}
+	})
+	t.Run("replaces deleted items with tombstones", func(t *testing.T) {
+		db, s := newStore()
+		tombstone := &vocab.Tombstone{ID: existing.ID, Type: vocab.TombstoneType}
+		if _, err := s.Save(tombstone); err != nil {
+			t.Fatalf("Save() error = %s", err)
+		}
+		if db[existing.ID].GetType() != vocab.TombstoneType {
+			t.Errorf("the object hasn't been replaced by a Tombstone")
+		}
+	})
+	t.Run("passes through the collections", func(t *testing.T) {
+		_, s := newStore()
+		repo := st.Serialize(s, nil)
+		outbox := vocab.Outbox.IRI(johnDoe)
+		if _, err := repo.Create(&vocab.OrderedCollection{ID: outbox, Type: vocab.OrderedCollectionType}); err != nil {
+			t.Fatalf("Create() error = %s", err)
+		}
+		if err := repo.AddTo(outbox, existing.ID); err != nil {
+			t.Fatalf("AddTo() error = %s", err)
+		}
+		if !collectionContains(repo, outbox, existing.ID) {
+			t.Errorf("the %s should have been added to %s", existing.ID, outbox)
+		}
+	})
+}
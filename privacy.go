@@ -0,0 +1,142 @@
+package fedbox
+
+import (
+	"encoding/pem"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+)
+
+// privateCollections are the actor collections which the owner can choose to hide from everybody else
+var privateCollections = vocab.CollectionPaths{vocab.Liked}
+
+const (
+	// preferencesBlockType is the type of the PEM block whose headers are the preferences of an actor
+	preferencesBlockType = "PREFERENCES"
+
+	collectionPrivate = "private"
+	collectionPublic  = "public"
+)
+
+// preferencesIRI returns the IRI under which we keep the metadata with the preferences of the actor.
+//
+// NOTE(marius): processing.Metadata has no place for them, so we keep them as the headers of a PEM block,
+// like the time of the rotation of the previous keys.
+func preferencesIRI(actor vocab.IRI) vocab.IRI {
+	return actorMetadataIRI(actor, "preferences")
+}
+
+// loadPreferences returns the preferences of the actor, which are empty if it didn't set any
+func loadPreferences(m st.MetadataTyper, actor vocab.IRI) map[string]string {
+	meta, _ := m.LoadMetadata(preferencesIRI(actor))
+	if meta == nil {
+		return nil
+	}
+	b, _ := pem.Decode(meta.PrivateKey)
+	if b == nil || b.Type != preferencesBlockType {
+		return nil
+	}
+	return b.Headers
+}
+
+// SetCollectionVisibility saves in the metadata of the actor if its typ collection is private, visible only to
+// the actor, or public.
+func SetCollectionVisibility(m st.MetadataTyper, actor vocab.IRI, typ vocab.CollectionPath, private bool) error {
+	if !privateCollections.Contains(typ) {
+		return errors.NotValidf("the %s collection can't be private", typ)
+	}
+	prefs := make(map[string]string)
+	for k, v := range loadPreferences(m, actor) {
+		prefs[k] = v
+	}
+	prefs[string(typ)] = collectionPublic
+	if private {
+		prefs[string(typ)] = collectionPrivate
+	}
+	meta := processing.Metadata{PrivateKey: pem.EncodeToMemory(&pem.Block{Type: preferencesBlockType, Headers: prefs})}
+	if err := m.SaveMetadata(meta, preferencesIRI(actor)); err != nil {
+		return errors.Annotatef(err, "failed saving the preferences of actor: %s", actor)
+	}
+	return nil
+}
+
+// collectionIsPublic checks if the owner made its typ collection public.
+// When the owner didn't express a preference, or the storage doesn't support metadata, we fall back to the
+// instance wide def value.
+func collectionIsPublic(db processing.ReadStore, owner vocab.IRI, typ vocab.CollectionPath, def bool) bool {
+	m, ok := db.(st.MetadataTyper)
+	if !ok {
+		return def
+	}
+	switch loadPreferences(m, owner)[string(typ)] {
+	case collectionPrivate:
+		return false
+	case collectionPublic:
+		return true
+	}
+	return def
+}
+
+// isAnonymous checks if the act actor corresponds to a request that hasn't been authorized
+func isAnonymous(act vocab.Actor) bool {
+	return len(act.ID) == 0 || act.ID.Equals(auth.AnonymousActor.ID, true)
+}
+
+// collectionOwner returns the IRI of the actor or object owning the iri collection, and the type of the collection
+func collectionOwner(iri vocab.IRI) (vocab.IRI, vocab.CollectionPath) {
+	if u, err := iri.URL(); err == nil {
+		// NOTE: the collections we serve have the filtering parameters in their IRI
+		u.RawQuery = ""
+		iri = vocab.IRI(u.String())
+	}
+	return vocab.Split(iri)
+}
+
+// checkCollectionAccess verifies if the "by" actor can see the contents of the col collection.
+// Collections that are not part of the privateCollections are always visible, the others are
+// visible to everyone only if their owners made them public, and to their owners always.
+func checkCollectionAccess(db processing.ReadStore, col vocab.Item, by vocab.Actor, def bool) error {
+	if vocab.IsNil(col) {
+		return nil
+	}
+	owner, typ := collectionOwner(col.GetLink())
+	if !privateCollections.Contains(typ) || collectionIsPublic(db, owner, typ, def) {
+		return nil
+	}
+	if isAnonymous(by) {
+		return errors.Unauthorizedf("%s collection is private", typ)
+	}
+	if len(owner) == 0 || !owner.Equals(by.GetLink(), true) {
+		return errors.Forbiddenf("%s collection is private", typ)
+	}
+	return nil
+}
+
+// itemIsPublic checks if the it item is addressed to the Public namespace
+func itemIsPublic(it vocab.Item) bool {
+	public := false
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		for _, recipients := range []vocab.ItemCollection{o.To, o.CC, o.Audience} {
+			if recipients.Contains(vocab.PublicNS) {
+				public = true
+			}
+		}
+		return nil
+	})
+	return public
+}
+
+// checkAuthorizedFetch verifies, when the instance requires authorized fetch, that the it item is public,
+// or that the request has been signed by an actor we could resolve.
+func checkAuthorizedFetch(it vocab.Item, by vocab.Actor, required bool) error {
+	if !required || vocab.IsNil(it) || itemIsPublic(it) {
+		return nil
+	}
+	if isAnonymous(by) {
+		return errors.Unauthorizedf("%s can only be fetched with a signed request", it.GetLink())
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/auth"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// mockMetadataStore is a storage which supports metadata
+type mockMetadataStore struct {
+	mockStore
+	mockMetadata
+}
+
+func TestCheckCollectionAccess(t *testing.T) {
+	owner := vocab.Actor{ID: "https://example.com/actors/jdoe", Type: vocab.PersonType}
+	other := vocab.Actor{ID: "https://example.com/actors/alice", Type: vocab.PersonType}
+	public := vocab.Actor{ID: "https://example.com/actors/bob", Type: vocab.PersonType}
+
+	keys := processing.Metadata{Pw: []byte("password hash"), PrivateKey: []byte("private key")}
+	db := mockMetadataStore{mockStore: mockStore{}, mockMetadata: mockMetadata{owner.ID: keys}}
+	if err := SetCollectionVisibility(db, owner.ID, vocab.Liked, true); err != nil {
+		t.Fatalf("SetCollectionVisibility() returned error %s", err)
+	}
+	if err := SetCollectionVisibility(db, public.ID, vocab.Liked, false); err != nil {
+		t.Fatalf("SetCollectionVisibility() returned error %s", err)
+	}
+	if err := SetCollectionVisibility(db, owner.ID, vocab.Outbox, true); !errors.IsNotValid(err) {
+		t.Errorf("SetCollectionVisibility() for the outbox returned %v, expected a not valid error", err)
+	}
+	if m := db.mockMetadata[owner.ID]; string(m.Pw) != string(keys.Pw) || string(m.PrivateKey) != string(keys.PrivateKey) {
+		t.Errorf("the preferences shouldn't change the metadata with the keys of the actor, got %v", m)
+	}
+
+	privateLiked := &vocab.OrderedCollection{
+		ID:   vocab.Liked.IRI(owner),
+		Type: vocab.OrderedCollectionType,
+	}
+	publicLiked := &vocab.OrderedCollection{
+		ID:   vocab.Liked.IRI(public),
+		Type: vocab.OrderedCollectionType,
+	}
+	unsetLiked := &vocab.OrderedCollection{
+		ID:   vocab.IRI("https://example.com/actors/alice/liked?maxItems=10"),
+		Type: vocab.OrderedCollectionType,
+	}
+	outbox := &vocab.OrderedCollection{
+		ID:   vocab.Outbox.IRI(owner),
+		Type: vocab.OrderedCollectionType,
+	}
+
+	tests := []struct {
+		name    string
+		col     vocab.Item
+		by      vocab.Actor
+		def     bool
+		wantErr func(error) bool
+	}{
+		{
+			name:    "private liked is hidden from anonymous",
+			col:     privateLiked,
+			by:      auth.AnonymousActor,
+			def:     true,
+			wantErr: errors.IsUnauthorized,
+		},
+		{
+			name:    "private liked is hidden from other actors",
+			col:     privateLiked,
+			by:      other,
+			def:     true,
+			wantErr: errors.IsForbidden,
+		},
+		{
+			name: "private liked is visible to the owner",
+			col:  privateLiked,
+			by:   owner,
+			def:  true,
+		},
+		{
+			name: "public liked is visible to anonymous",
+			col:  publicLiked,
+			by:   auth.AnonymousActor,
+			def:  false,
+		},
+		{
+			name: "liked without preference uses the default",
+			col:  unsetLiked,
+			by:   auth.AnonymousActor,
+			def:  true,
+		},
+		{
+			name:    "liked without preference is hidden when private by default",
+			col:     unsetLiked,
+			by:      auth.AnonymousActor,
+			def:     false,
+			wantErr: errors.IsUnauthorized,
+		},
+		{
+			name: "liked without preference is visible to the owner when private by default",
+			col:  unsetLiked,
+			by:   other,
+			def:  false,
+		},
+		{
+			name: "other collections are not affected",
+			col:  outbox,
+			by:   auth.AnonymousActor,
+			def:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCollectionAccess(db, tt.col, tt.by, tt.def)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("checkCollectionAccess() returned unexpected error %s", err)
+				}
+				return
+			}
+			if !tt.wantErr(err) {
+				t.Errorf("checkCollectionAccess() returned invalid error %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckAuthorizedFetch(t *testing.T) {
+	signer := vocab.Actor{ID: "https://example.com/actors/jdoe", Type: vocab.PersonType}
+	public := &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType, To: vocab.ItemCollection{vocab.PublicNS}}
+	private := &vocab.Object{ID: "https://fedbox.local/objects/2", Type: vocab.NoteType, To: vocab.ItemCollection{vocab.IRI("https://fedbox.local/actors/johndoe/followers")}}
+
+	tests := []struct {
+		name     string
+		it       vocab.Item
+		by       vocab.Actor
+		required bool
+		wantErr  bool
+	}{
+		{name: "unsigned fetch of a private object", it: private, by: auth.AnonymousActor, required: true, wantErr: true},
+		{name: "signed fetch of a private object", it: private, by: signer, required: true},
+		{name: "unsigned fetch of a public object", it: public, by: auth.AnonymousActor, required: true},
+		{name: "unsigned fetch without authorized fetch", it: private, by: auth.AnonymousActor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAuthorizedFetch(tt.it, tt.by, tt.required)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkAuthorizedFetch() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.IsUnauthorized(err) {
+				t.Errorf("checkAuthorizedFetch() should return an unauthorized error, received %v", err)
+			}
+		})
+	}
+}
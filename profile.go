@@ -0,0 +1,242 @@
+package fedbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/processing"
+	"github.com/pborman/uuid"
+)
+
+const profileChangesPath = "profile-changes"
+
+// PropertyValueType is the type of the profile metadata fields, from the schema.org vocabulary
+const PropertyValueType vocab.ActivityVocabularyType = "PropertyValue"
+
+// profileSnapshot returns the tracked properties of the it actor's profile: the name, the summary and the avatar.
+// It returns nil if it is not an actor.
+func profileSnapshot(it vocab.Item) *vocab.Actor {
+	if vocab.IsNil(it) || !vocab.ActorTypes.Contains(it.GetType()) {
+		return nil
+	}
+	var snap *vocab.Actor
+	vocab.OnActor(it, func(act *vocab.Actor) error {
+		snap = &vocab.Actor{
+			ID:      act.ID,
+			Type:    act.Type,
+			Name:    act.Name,
+			Summary: act.Summary,
+			Icon:    act.Icon,
+		}
+		return nil
+	})
+	return snap
+}
+
+// loadProfile returns the snapshot of the stored profile of the actor which the it Update activity modifies,
+// before the activity gets processed.
+func loadProfile(db processing.ReadStore, it vocab.Item) *vocab.Actor {
+	var snap *vocab.Actor
+	vocab.OnActivity(it, func(a *vocab.Activity) error {
+		if a.GetType() != vocab.UpdateType || vocab.IsNil(a.Object) {
+			return nil
+		}
+		if ob, err := db.Load(a.Object.GetLink()); err == nil {
+			snap = profileSnapshot(firstItem(ob))
+		}
+		return nil
+	})
+	return snap
+}
+
+// sameValue checks if the a and b properties have the same JSON representation
+func sameValue(a, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+// profileDiff returns the previous and the current values of the profile properties which differ between
+// the prev and cur snapshots. Both are nil when nothing has changed.
+func profileDiff(prev, cur *vocab.Actor) (*vocab.Actor, *vocab.Actor) {
+	if prev == nil || cur == nil {
+		return nil, nil
+	}
+	from := vocab.Actor{ID: cur.ID, Type: cur.Type}
+	to := vocab.Actor{ID: cur.ID, Type: cur.Type}
+	changed := false
+	if (len(prev.Name) > 0 || len(cur.Name) > 0) && !sameValue(prev.Name, cur.Name) {
+		from.Name, to.Name = prev.Name, cur.Name
+		changed = true
+	}
+	if (len(prev.Summary) > 0 || len(cur.Summary) > 0) && !sameValue(prev.Summary, cur.Summary) {
+		from.Summary, to.Summary = prev.Summary, cur.Summary
+		changed = true
+	}
+	if (!vocab.IsNil(prev.Icon) || !vocab.IsNil(cur.Icon)) && !sameValue(prev.Icon, cur.Icon) {
+		from.Icon, to.Icon = prev.Icon, cur.Icon
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+	return &from, &to
+}
+
+// recordProfileChange appends an entry to the changelog of the actor modified by the update activity, when its
+// name, summary or avatar have changed compared to the prev snapshot.
+// The entries are Update activities with the changed properties, having their previous values as origin and
+// the new ones as object. The function returns the IRIs of the entry and of the changelog collection.
+func recordProfileChange(db collectionStore, prev *vocab.Actor, update *vocab.Activity, now time.Time) (vocab.IRIs, error) {
+	if prev == nil || update == nil || update.GetType() != vocab.UpdateType || vocab.IsNil(update.Object) {
+		return nil, nil
+	}
+	it, err := db.Load(update.Object.GetLink())
+	if err != nil {
+		return nil, err
+	}
+	from, to := profileDiff(prev, profileSnapshot(firstItem(it)))
+	if to == nil {
+		return nil, nil
+	}
+
+	col := vocab.IRI(to.ID).AddPath(profileChangesPath)
+	entry := &vocab.Activity{
+		ID:        col.AddPath(uuid.New()),
+		Type:      vocab.UpdateType,
+		Actor:     update.Actor,
+		Object:    to,
+		Origin:    from,
+		Published: now,
+	}
+	if _, err = db.Load(col); errors.IsNotFound(err) {
+		if _, err = db.Create(&vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType}); err != nil {
+			return nil, errors.Annotatef(err, "unable to create the profile changelog %s", col)
+		}
+	}
+	if _, err = db.Save(entry); err != nil {
+		return nil, errors.Annotatef(err, "unable to save the profile change for %s", to.ID)
+	}
+	if err = db.AddTo(col, entry.GetLink()); err != nil {
+		return nil, errors.Annotatef(err, "unable to add the profile change to %s", col)
+	}
+	return vocab.IRIs{entry.GetLink(), col}, nil
+}
+
+// loadProfileChanges returns the entries of the col profile changelog, with the most recent first
+func loadProfileChanges(db processing.ReadStore, col vocab.IRI) vocab.ItemCollection {
+	entries := make(vocab.ItemCollection, 0)
+	loaded, err := db.Load(col)
+	if err != nil {
+		return entries
+	}
+	vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if vocab.IsIRI(it) {
+				if it, err = db.Load(it.GetLink()); err != nil {
+					continue
+				}
+				it = firstItem(it)
+			}
+			if !vocab.IsNil(it) && it.GetType() == vocab.UpdateType && it.GetLink().Contains(col, false) {
+				entries = append(entries, it)
+			}
+		}
+		return nil
+	})
+	return orderItems(entries, config.OrderTieBreakNone)
+}
+
+func handleProfileChanges(db processing.ReadStore, secure bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := url.Parse(reqURL(r, secure))
+		if err != nil {
+			errors.HandleError(errors.NewBadRequest(err, "invalid request URL")).ServeHTTP(w, r)
+			return
+		}
+		u.RawQuery = ""
+		colIRI := vocab.IRI(strings.TrimSuffix(u.String(), "/"))
+		actor := vocab.IRI(strings.TrimSuffix(colIRI.String(), "/"+profileChangesPath))
+
+		it, err := db.Load(actor)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if it = firstItem(it); vocab.IsNil(it) || !vocab.ActorTypes.Contains(it.GetType()) {
+			errors.HandleError(errors.NotFoundf("%s not found", actor)).ServeHTTP(w, r)
+			return
+		}
+		items := loadProfileChanges(db, colIRI)
+		col := vocab.OrderedCollection{
+			ID:           colIRI,
+			Type:         vocab.OrderedCollectionType,
+			OrderedItems: items,
+			TotalItems:   uint(len(items)),
+		}
+		data, err := vocab.MarshalJSON(&col)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// HandleProfileChanges serves the changelog of an actor's profile, with the changes of its name, summary
+// and avatar, when the tracking is enabled.
+func HandleProfileChanges(fb FedBOX) http.HandlerFunc {
+	if !fb.Config().ProfileHistory {
+		return errors.NotFound.ServeHTTP
+	}
+	return handleProfileChanges(fb.storage, fb.Config().Secure)
+}
+
+// profileFieldsCount returns the number of PropertyValue pairs in the attachments of the act actor
+func profileFieldsCount(act *vocab.Actor) int {
+	if vocab.IsNil(act.Attachment) {
+		return 0
+	}
+	attachments := vocab.ItemCollection{act.Attachment}
+	if vocab.IsItemCollection(act.Attachment) {
+		vocab.OnCollectionIntf(act.Attachment, func(c vocab.CollectionInterface) error {
+			attachments = c.Collection()
+			return nil
+		})
+	}
+	cnt := 0
+	for _, it := range attachments {
+		if !vocab.IsNil(it) && it.GetType() == PropertyValueType {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// ValidateProfileFields checks that the actor in the object of the a Update activity doesn't have more than max
+// profile metadata fields. A zero max means no limit.
+// It applies only to the activities of the local actors, the remote profiles are limited by their own instances.
+func ValidateProfileFields(a *vocab.Activity, max int) error {
+	if a == nil || max <= 0 || a.GetType() != vocab.UpdateType || vocab.IsNil(a.Object) {
+		return nil
+	}
+	if !vocab.ActorTypes.Contains(a.Object.GetType()) {
+		return nil
+	}
+	return vocab.OnActor(a.Object, func(act *vocab.Actor) error {
+		if cnt := profileFieldsCount(act); cnt > max {
+			return errors.BadRequestf("the profile has %d fields, the maximum allowed is %d", cnt, max)
+		}
+		return nil
+	})
+}
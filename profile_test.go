@@ -0,0 +1,141 @@
+package fedbox
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestRecordProfileChange(t *testing.T) {
+	johnDoe := &vocab.Actor{
+		ID:      "https://fedbox.local/actors/johndoe",
+		Type:    vocab.PersonType,
+		Name:    vocab.DefaultNaturalLanguageValue("John Doe"),
+		Summary: vocab.DefaultNaturalLanguageValue("first summary"),
+	}
+	db := mockCollectionStore{mockStore: mockStore{johnDoe.ID: johnDoe}}
+
+	now := time.Now().UTC()
+	for i, summary := range []string{"second summary", "third summary"} {
+		update := &vocab.Activity{
+			ID:     vocab.IRI("https://fedbox.local/activities/update").AddPath(summary),
+			Type:   vocab.UpdateType,
+			Actor:  johnDoe.ID,
+			Object: johnDoe.ID,
+		}
+		prev := loadProfile(db, update)
+		// NOTE(marius): the processing of the Update replaces the stored actor
+		db.mockStore[johnDoe.ID] = &vocab.Actor{
+			ID:      johnDoe.ID,
+			Type:    vocab.PersonType,
+			Name:    johnDoe.Name,
+			Summary: vocab.DefaultNaturalLanguageValue(summary),
+		}
+		modified, err := recordProfileChange(db, prev, update, now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatalf("recordProfileChange() error = %s", err)
+		}
+		if len(modified) != 2 {
+			t.Errorf("recordProfileChange() modified %v, expected the entry and the changelog", modified)
+		}
+	}
+
+	noop := &vocab.Activity{ID: "https://fedbox.local/activities/noop", Type: vocab.UpdateType, Actor: johnDoe.ID, Object: johnDoe.ID}
+	if modified, _ := recordProfileChange(db, loadProfile(db, noop), noop, now); len(modified) > 0 {
+		t.Errorf("recordProfileChange() recorded an Update which didn't change the profile")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/actors/johndoe/"+profileChangesPath, nil)
+	r.Host = "fedbox.local"
+	w := httptest.NewRecorder()
+	handleProfileChanges(db, true).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("profile changes returned status %d, expected %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	body, _ := io.ReadAll(w.Body)
+	it, err := vocab.UnmarshalJSON(body)
+	if err != nil {
+		t.Fatalf("unable to unmarshal the profile changes: %s", err)
+	}
+	var entries vocab.ItemCollection
+	vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+		entries = c.Collection()
+		return nil
+	})
+	if len(entries) != 2 {
+		t.Fatalf("profile changes returned %d entries, expected 2", len(entries))
+	}
+	expected := []struct{ from, to string }{
+		{from: "second summary", to: "third summary"},
+		{from: "first summary", to: "second summary"},
+	}
+	for i, entry := range entries {
+		vocab.OnActivity(entry, func(a *vocab.Activity) error {
+			vocab.OnActor(a.Origin, func(from *vocab.Actor) error {
+				if got := from.Summary.String(); got != expected[i].from {
+					t.Errorf("entry %d has the previous summary %q, expected %q", i, got, expected[i].from)
+				}
+				if len(from.Name) > 0 {
+					t.Errorf("entry %d contains the unchanged name", i)
+				}
+				return nil
+			})
+			vocab.OnActor(a.Object, func(to *vocab.Actor) error {
+				if got := to.Summary.String(); got != expected[i].to {
+					t.Errorf("entry %d has the summary %q, expected %q", i, got, expected[i].to)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+}
+
+func profileUpdate(fields int) *vocab.Activity {
+	attachments := make(vocab.ItemCollection, 0, fields+1)
+	for i := 0; i < fields; i++ {
+		attachments = append(attachments, &vocab.Object{
+			Type:    PropertyValueType,
+			Name:    vocab.DefaultNaturalLanguageValue("field"),
+			Content: vocab.DefaultNaturalLanguageValue("value"),
+		})
+	}
+	// NOTE(marius): the other attachments are not counted as profile fields
+	attachments = append(attachments, &vocab.Object{Type: vocab.ImageType, URL: vocab.IRI("https://fedbox.local/media/1.png")})
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	return &vocab.Activity{
+		Type:   vocab.UpdateType,
+		Actor:  johnDoe,
+		Object: &vocab.Actor{ID: johnDoe, Type: vocab.PersonType, Attachment: attachments},
+	}
+}
+
+func TestValidateProfileFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  int
+		max     int
+		wantErr bool
+	}{
+		{name: "under the limit", fields: 2, max: 4},
+		{name: "at the limit", fields: 4, max: 4},
+		{name: "over the limit", fields: 5, max: 4, wantErr: true},
+		{name: "no limit", fields: 50, max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateProfileFields(profileUpdate(tt.fields), tt.max)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateProfileFields() error = %v, expected error %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.IsBadRequest(err) {
+				t.Errorf("ValidateProfileFields() error = %s, expected a bad request", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+const purgeActorPath = "actor"
+
+// purgeResult is the summary of the removal of a remote actor's content
+type purgeResult struct {
+	Actor vocab.IRI `json:"actor"`
+	Items int       `json:"items"`
+}
+
+// purgeCreatedBy removes from storage the items in the col collection which have been created by the actor,
+// together with their membership in the collection. We load the collection until no more items are found,
+// in case the storage returns them paginated.
+func purgeCreatedBy(db collectionStore, col vocab.IRI, actor vocab.IRI) (vocab.IRIs, error) {
+	purged := make(vocab.IRIs, 0)
+	for {
+		loaded, err := db.Load(col)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return purged, nil
+			}
+			return purged, err
+		}
+		toRemove := make(vocab.ItemCollection, 0)
+		vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+			for _, it := range c.Collection() {
+				if !purged.Contains(it.GetLink()) && createdBy(it, actor) {
+					toRemove = append(toRemove, it)
+				}
+			}
+			return nil
+		})
+		if len(toRemove) == 0 {
+			return purged, nil
+		}
+		for _, it := range toRemove {
+			if err = db.RemoveFrom(col, it.GetLink()); err != nil {
+				return purged, errors.Annotatef(err, "unable to remove %s from %s", it.GetLink(), col)
+			}
+			if err = db.Delete(it); err != nil && !errors.IsNotFound(err) {
+				return purged, errors.Annotatef(err, "unable to remove %s", it.GetLink())
+			}
+			purged = append(purged, it.GetLink())
+		}
+	}
+}
+
+// purgeFromInboxes removes the purged items from the inboxes of the local actors of the base service.
+// It returns the IRIs of the inboxes that have been modified.
+func purgeFromInboxes(db collectionStore, base vocab.IRI, purged vocab.IRIs) (vocab.IRIs, error) {
+	actors, err := db.Load(filters.ActorsType.IRI(base))
+	if err != nil {
+		return nil, err
+	}
+	modified := make(vocab.IRIs, 0)
+	err = vocab.OnCollectionIntf(actors, func(c vocab.CollectionInterface) error {
+		for _, local := range c.Collection() {
+			if !vocab.ActorTypes.Contains(local.GetType()) || !local.GetLink().Contains(base, false) {
+				continue
+			}
+			inbox := vocab.Inbox.IRI(local)
+			loaded, err := db.Load(inbox)
+			if err != nil {
+				continue
+			}
+			toRemove := make(vocab.IRIs, 0)
+			vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+				for _, it := range c.Collection() {
+					if purged.Contains(it.GetLink()) {
+						toRemove = append(toRemove, it.GetLink())
+					}
+				}
+				return nil
+			})
+			for _, iri := range toRemove {
+				if err := db.RemoveFrom(inbox, iri); err != nil {
+					return errors.Annotatef(err, "unable to remove %s from %s", iri, inbox)
+				}
+			}
+			if len(toRemove) > 0 {
+				modified = append(modified, inbox)
+			}
+		}
+		return nil
+	})
+	return modified, err
+}
+
+// purgeRemoteActor removes the remote actor from storage, together with the objects attributed to it and
+// the activities it has sent, and removes it from the collections of the local actors of the base service.
+// Unlike the handling of the Delete of an actor, no Tombstones are kept, and nothing gets federated.
+//
+// NOTE(marius): the activities of the actor can still be referenced by the likes and shares collections
+// of the local objects, from where the storage drops them when they can't be loaded anymore.
+//
+// The function returns the IRIs of the items and collections that have been modified.
+func purgeRemoteActor(db collectionStore, base vocab.IRI, actor vocab.IRI) (purgeResult, vocab.IRIs, error) {
+	res := purgeResult{Actor: actor}
+	if len(actor) == 0 {
+		return res, nil, errors.BadRequestf("missing actor IRI")
+	}
+	if actor.Contains(base, false) {
+		return res, nil, errors.BadRequestf("%s is a local actor", actor)
+	}
+	modified := make(vocab.IRIs, 0)
+	purged := make(vocab.IRIs, 0)
+	for _, col := range []vocab.IRI{filters.ObjectsType.IRI(base), filters.ActivitiesType.IRI(base)} {
+		items, err := purgeCreatedBy(db, col, actor)
+		purged = append(purged, items...)
+		if len(items) > 0 {
+			modified = append(modified, col)
+		}
+		if err != nil {
+			return res, append(modified, purged...), err
+		}
+	}
+	res.Items = len(purged)
+	modified = append(modified, purged...)
+
+	inboxes, err := purgeFromInboxes(db, base, purged)
+	modified = append(modified, inboxes...)
+	if err != nil {
+		return res, modified, err
+	}
+	cols, err := removeRelationships(db, base, actor)
+	modified = append(modified, cols...)
+	if err != nil {
+		return res, modified, err
+	}
+
+	actors := filters.ActorsType.IRI(base)
+	if collectionContains(db, actors, actor) {
+		if err = db.RemoveFrom(actors, actor); err != nil {
+			return res, modified, errors.Annotatef(err, "unable to remove %s from %s", actor, actors)
+		}
+		modified = append(modified, actors)
+	}
+	if it, err := db.Load(actor); err == nil && !vocab.IsNil(firstItem(it)) && firstItem(it).GetLink().Equals(actor, false) {
+		if err = db.Delete(firstItem(it)); err != nil {
+			return res, modified, errors.Annotatef(err, "unable to remove %s", actor)
+		}
+		res.Items++
+		modified = append(modified, actor)
+	}
+	return res, modified, nil
+}
+
+// HandlePurgeActor serves the administrative end-point which removes a remote actor and all its content
+// from storage, for when the instance defederates from its server: DELETE /admin/actor?iri=...
+func HandlePurgeActor(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(fb.actorFromRequest(r), fb.self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		db, ok := fb.storage.(collectionStore)
+		if !ok {
+			errors.HandleError(errors.NotImplementedf("purging actors is not supported by the storage")).ServeHTTP(w, r)
+			return
+		}
+		actor := vocab.IRI(r.URL.Query().Get("iri"))
+		res, modified, err := purgeRemoteActor(db, vocab.IRI(fb.Config().BaseURL), actor)
+		if len(modified) > 0 {
+			fb.caches.Remove(modified...)
+		}
+		fb.keys.invalidate(actor)
+		if err != nil {
+			fb.errFn("unable to purge %s: %+s", actor, err)
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		fb.infFn("purged %s: %d items removed", actor, res.Items)
+		data, _ := json.Marshal(res)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
@@ -0,0 +1,94 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/filters"
+)
+
+func TestPurgeRemoteActor(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	objects := filters.ObjectsType.IRI(base)
+	activities := filters.ActivitiesType.IRI(base)
+	actors := filters.ActorsType.IRI(base)
+
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	bob := &vocab.Actor{ID: "https://example.com/users/bob", Type: vocab.PersonType}
+	localNote := &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType, AttributedTo: johnDoe.ID}
+	localCreate := &vocab.Activity{ID: "https://fedbox.local/activities/1", Type: vocab.CreateType, Actor: johnDoe.ID, Object: localNote.ID}
+	remoteNote := &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType, AttributedTo: bob.ID}
+	remoteCreate := &vocab.Activity{ID: "https://example.com/activities/1", Type: vocab.CreateType, Actor: bob.ID, Object: remoteNote.ID}
+	remoteLike := &vocab.Activity{ID: "https://example.com/activities/2", Type: vocab.LikeType, Actor: bob.ID, Object: localNote.ID}
+
+	inbox := vocab.Inbox.IRI(johnDoe)
+	followers := vocab.Followers.IRI(johnDoe)
+
+	db := mockCollectionStore{mockStore{}}
+	for _, it := range []vocab.Item{johnDoe, bob, localNote, localCreate, remoteNote, remoteCreate, remoteLike} {
+		db.Save(it)
+	}
+	db.AddTo(actors, johnDoe.ID)
+	db.AddTo(actors, bob.ID)
+	for _, it := range []vocab.Item{localNote, remoteNote} {
+		db.AddTo(objects, it)
+	}
+	for _, it := range []vocab.Item{localCreate, remoteCreate, remoteLike} {
+		db.AddTo(activities, it)
+	}
+	// NOTE(marius): the mock storage returns the IRIs of the collection members, so we keep the items embedded
+	for _, col := range []vocab.IRI{actors, objects, activities} {
+		c := db.mockStore[col].(*vocab.OrderedCollection)
+		for i, it := range c.OrderedItems {
+			c.OrderedItems[i] = db.mockStore[it.GetLink()]
+		}
+	}
+	db.AddTo(inbox, remoteCreate)
+	db.AddTo(inbox, remoteLike)
+	db.AddTo(followers, bob.ID)
+	db.Create(&vocab.OrderedCollection{ID: vocab.Following.IRI(johnDoe), Type: vocab.OrderedCollectionType})
+
+	if _, _, err := purgeRemoteActor(db, base, johnDoe.ID); err == nil {
+		t.Errorf("purgeRemoteActor() should refuse to purge a local actor")
+	}
+	if _, ok := db.mockStore[johnDoe.ID]; !ok {
+		t.Fatalf("the local actor %s has been removed", johnDoe.ID)
+	}
+
+	res, modified, err := purgeRemoteActor(db, base, bob.ID)
+	if err != nil {
+		t.Fatalf("purgeRemoteActor() returned error %s", err)
+	}
+	if res.Items != 4 {
+		t.Errorf("purgeRemoteActor() removed %d items, expected 4", res.Items)
+	}
+	for _, iri := range []vocab.IRI{bob.ID, remoteNote.ID, remoteCreate.ID, remoteLike.ID} {
+		if _, ok := db.mockStore[iri]; ok {
+			t.Errorf("%s should have been removed", iri)
+		}
+		if !modified.Contains(iri) {
+			t.Errorf("%s should be in the modified IRIs %v", iri, modified)
+		}
+	}
+	for _, iri := range []vocab.IRI{johnDoe.ID, localNote.ID, localCreate.ID} {
+		if _, ok := db.mockStore[iri]; !ok {
+			t.Errorf("the local item %s should not have been removed", iri)
+		}
+	}
+	for col, iris := range map[vocab.IRI]vocab.IRIs{
+		objects:    {remoteNote.ID},
+		activities: {remoteCreate.ID, remoteLike.ID},
+		actors:     {bob.ID},
+		inbox:      {remoteCreate.ID, remoteLike.ID},
+		followers:  {bob.ID},
+	} {
+		for _, iri := range iris {
+			if collectionContains(db, col, iri) {
+				t.Errorf("%s should have been removed from %s", iri, col)
+			}
+		}
+	}
+	if !collectionContains(db, objects, localNote.ID) || !collectionContains(db, activities, localCreate.ID) {
+		t.Errorf("the local items should still be in their collections")
+	}
+}
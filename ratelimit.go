@@ -0,0 +1,149 @@
+package fedbox
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// tokenBucket keeps a token bucket for every client, each of them being refilled with limit.Requests
+// tokens over the limit.Window duration.
+type tokenBucket struct {
+	m         sync.Mutex
+	limit     config.RateLimit
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func newTokenBucket(limit config.RateLimit) *tokenBucket {
+	if limit.Requests <= 0 || limit.Window <= 0 {
+		return nil
+	}
+	return &tokenBucket{limit: limit, buckets: make(map[string]*bucket)}
+}
+
+func (t *tokenBucket) rate() float64 {
+	return float64(t.limit.Requests) / t.limit.Window.Seconds()
+}
+
+// take consumes a token from the key client's bucket. If there's none available it returns false
+// and the duration after which a new token will be available.
+func (t *tokenBucket) take(key string, now time.Time) (bool, time.Duration) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.prune(now)
+
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(t.limit.Requests), last: now}
+		t.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(t.limit.Requests), b.tokens+elapsed*t.rate())
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / t.rate() * float64(time.Second))
+	return false, wait
+}
+
+// prune removes the buckets which have been refilled completely, as they're equivalent to new ones.
+func (t *tokenBucket) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < t.limit.Window {
+		return
+	}
+	for key, b := range t.buckets {
+		if now.Sub(b.last) >= t.limit.Window {
+			delete(t.buckets, key)
+		}
+	}
+	t.lastPrune = now
+}
+
+// rateLimiter limits the number of requests coming from the same IP address, with different limits for reading
+// and for writing requests, like the POSTs to the inboxes and the OAuth2 end-points.
+type rateLimiter struct {
+	read  *tokenBucket
+	write *tokenBucket
+	allow []*net.IPNet
+	now   func() time.Time
+}
+
+func newRateLimiter(read, write config.RateLimit, allow []string) (*rateLimiter, error) {
+	l := rateLimiter{
+		read:  newTokenBucket(read),
+		write: newTokenBucket(write),
+		allow: make([]*net.IPNet, 0, len(allow)),
+		now:   time.Now,
+	}
+	for _, addr := range allow {
+		n, err := parseIPNet(addr)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid rate limit allowed address")
+		}
+		l.allow = append(l.allow, n)
+	}
+	return &l, nil
+}
+
+func (l *rateLimiter) isAllowed(ip net.IP) bool {
+	for _, n := range l.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isReadRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// remoteIP returns the IP address of the client, as set by the trustedProxies middleware,
+// or the one of the socket peer, when the request didn't pass through it.
+func remoteIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// Middleware responds with a 429 Too Many Requests status to the requests over the limits,
+// informing the client when to retry in the Retry-After header.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	if l == nil || (l.read == nil && l.write == nil) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := l.write
+		if isReadRequest(r) {
+			limit = l.read
+		}
+		ip := remoteIP(r)
+		if limit == nil || l.isAllowed(net.ParseIP(ip)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := limit.take(ip, l.now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,102 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+type mockClock struct {
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	return c.now
+}
+
+func limitedRequest(h http.Handler, method, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/inbox", nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimiter_Middleware(t *testing.T) {
+	read := config.RateLimit{Requests: 3, Window: 3 * time.Second}
+	write := config.RateLimit{Requests: 1, Window: 10 * time.Second}
+	l, err := newRateLimiter(read, write, []string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatalf("newRateLimiter() returned error %s", err)
+	}
+	clock := mockClock{now: time.Now()}
+	l.now = clock.Now
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	const remote = "127.0.0.1:4321"
+	for i := 0; i < read.Requests; i++ {
+		if w := limitedRequest(h, http.MethodGet, remote); w.Code != http.StatusOK {
+			t.Fatalf("GET request %d returned status %d, expected %d", i, w.Code, http.StatusOK)
+		}
+	}
+	w := limitedRequest(h, http.MethodGet, remote)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("GET request over the limit returned status %d, expected %d", w.Code, http.StatusTooManyRequests)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "1" {
+		t.Errorf("Retry-After header %q, expected %q", ra, "1")
+	}
+
+	// NOTE(marius): the write requests have a separate limit
+	if w = limitedRequest(h, http.MethodPost, remote); w.Code != http.StatusOK {
+		t.Errorf("POST request returned status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if w = limitedRequest(h, http.MethodPost, remote); w.Code != http.StatusTooManyRequests {
+		t.Errorf("POST request over the limit returned status %d, expected %d", w.Code, http.StatusTooManyRequests)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "10" {
+		t.Errorf("Retry-After header %q, expected %q", ra, "10")
+	}
+
+	// NOTE(marius): other clients are not affected
+	if w = limitedRequest(h, http.MethodGet, "127.0.0.2:4321"); w.Code != http.StatusOK {
+		t.Errorf("GET request from a different client returned status %d, expected %d", w.Code, http.StatusOK)
+	}
+
+	// NOTE(marius): the allowed addresses are never limited
+	for _, addr := range []string{"10.0.0.1:4321", "192.168.1.1:4321"} {
+		for i := 0; i < 5; i++ {
+			if w = limitedRequest(h, http.MethodPost, addr); w.Code != http.StatusOK {
+				t.Errorf("POST request %d from allowed address %s returned status %d, expected %d", i, addr, w.Code, http.StatusOK)
+			}
+		}
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if w = limitedRequest(h, http.MethodGet, remote); w.Code != http.StatusOK {
+		t.Errorf("GET request after the tokens were refilled returned status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if w = limitedRequest(h, http.MethodGet, remote); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second GET request after refilling one token returned status %d, expected %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	clock.now = clock.now.Add(write.Window)
+	if w = limitedRequest(h, http.MethodPost, remote); w.Code != http.StatusOK {
+		t.Errorf("POST request after the window passed returned status %d, expected %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	if _, err := newRateLimiter(config.RateLimit{}, config.RateLimit{}, []string{"not-an-ip"}); err == nil {
+		t.Errorf("newRateLimiter() should return an error for an invalid allowed address")
+	}
+	if _, err := newRateLimiter(config.RateLimit{}, config.RateLimit{}, []string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("newRateLimiter() should return an error for an invalid allowed range")
+	}
+}
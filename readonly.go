@@ -0,0 +1,91 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/go-ap/errors"
+)
+
+const readOnlyPath = "/admin/maintenance"
+
+// readOnlyRetryAfter is the number of seconds after which we advise the clients to retry the rejected writes
+const readOnlyRetryAfter = 300
+
+// readOnlyMode is the instance-wide maintenance mode, in which the requests that modify the storage are rejected
+// while the reads continue to be served.
+type readOnlyMode struct {
+	on int32
+}
+
+func newReadOnlyMode(enabled bool) *readOnlyMode {
+	m := new(readOnlyMode)
+	m.set(enabled)
+	return m
+}
+
+func (m *readOnlyMode) enabled() bool {
+	return m != nil && atomic.LoadInt32(&m.on) == 1
+}
+
+func (m *readOnlyMode) set(enabled bool) {
+	if m == nil {
+		return
+	}
+	var on int32
+	if enabled {
+		on = 1
+	}
+	atomic.StoreInt32(&m.on, on)
+}
+
+// isWriteRequest checks if the r request can modify the storage
+func isWriteRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// Middleware rejects with 503 Service Unavailable the write requests while the maintenance mode is enabled.
+// The requests for toggling the maintenance mode are allowed.
+func (m *readOnlyMode) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.enabled() && isWriteRequest(r) && r.URL.Path != readOnlyPath {
+			w.Header().Set("Retry-After", strconv.Itoa(readOnlyRetryAfter))
+			http.Error(w, "the instance is in maintenance mode, writes are disabled", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleReadOnly serves the administrative end-point which shows the state of the maintenance mode,
+// and, for POST requests, enables or disables it using the "enabled" parameter.
+func HandleReadOnly(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(fb.actorFromRequest(r), fb.self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodPost {
+			enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+			if err != nil {
+				errors.HandleError(errors.NewBadRequest(err, "invalid enabled value")).ServeHTTP(w, r)
+				return
+			}
+			fb.readOnly.set(enabled)
+			fb.infFn("maintenance mode enabled: %t", enabled)
+		}
+		data, _ := json.Marshal(struct {
+			Enabled bool `json:"enabled"`
+		}{Enabled: fb.readOnly.enabled()})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
@@ -0,0 +1,48 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnlyMode(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	m := newReadOnlyMode(true)
+	h := m.Middleware(ok)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{method: http.MethodGet, path: "/actors/johndoe/outbox", want: http.StatusOK},
+		{method: http.MethodHead, path: "/actors/johndoe", want: http.StatusOK},
+		{method: http.MethodPost, path: "/actors/johndoe/outbox", want: http.StatusServiceUnavailable},
+		{method: http.MethodPost, path: "/actors/johndoe/inbox", want: http.StatusServiceUnavailable},
+		{method: http.MethodPost, path: "/admin/blocklist", want: http.StatusServiceUnavailable},
+		{method: http.MethodPut, path: "/actors/johndoe/featuredTags/go", want: http.StatusServiceUnavailable},
+		{method: http.MethodPost, path: readOnlyPath, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("status %d, expected %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+				t.Errorf("the rejected writes should have a Retry-After header")
+			}
+		})
+	}
+
+	m.set(false)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/actors/johndoe/outbox", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("writes should be allowed after disabling the maintenance mode, status %d", w.Code)
+	}
+}
@@ -0,0 +1,348 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+	"github.com/go-chi/chi/v5"
+	"github.com/pborman/uuid"
+)
+
+const (
+	registerPath      = "register"
+	registrationsPath = "registrations"
+)
+
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,63}$`)
+
+// registrationStore is the storage functionality needed for registering accounts
+type registrationStore interface {
+	processing.Store
+	processing.CollectionStore
+	st.PasswordChanger
+	st.MetadataTyper
+}
+
+// registration is the body of an account registration request
+type registration struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// pendingRegistrations returns the IRI of the collection where we keep the registrations waiting for approval.
+// It's not one of the collections we serve, so the pending registrations are only visible to the administrators.
+func pendingRegistrations(self vocab.Item) vocab.IRI {
+	return self.GetLink().AddPath(registrationsPath)
+}
+
+// usernameTaken checks if the name is the username of one of the local actors, or of a pending registration
+func usernameTaken(db processing.ReadStore, base vocab.IRI, self vocab.Item, name string) bool {
+	taken := false
+	for _, col := range []vocab.IRI{filters.ActorsType.IRI(base), pendingRegistrations(self)} {
+		loaded, err := db.Load(col)
+		if err != nil {
+			continue
+		}
+		vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+			for _, it := range c.Collection() {
+				if vocab.IsIRI(it) {
+					if it, err = db.Load(it.GetLink()); err != nil {
+						continue
+					}
+					it = firstItem(it)
+				}
+				vocab.OnActor(it, func(act *vocab.Actor) error {
+					taken = taken || strings.EqualFold(act.PreferredUsername.First().String(), name)
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+	return taken
+}
+
+// validateRegistration checks that the reg registration has a valid username, which isn't already in use,
+// and a password.
+func validateRegistration(db processing.ReadStore, base vocab.IRI, self vocab.Item, reg registration) error {
+	if !validUsername.MatchString(reg.Username) {
+		return errors.BadRequestf("invalid username %q", reg.Username)
+	}
+	if len(reg.Password) == 0 {
+		return errors.BadRequestf("missing password")
+	}
+	if usernameTaken(db, base, self, reg.Username) {
+		return errors.Conflictf("the username %q is already in use", reg.Username)
+	}
+	return nil
+}
+
+// createAccount saves the p person as a local actor of the base service, together with its collections, and
+// adds it to the actors collection. The keyGen function, when present, generates the actor's keys.
+//
+// NOTE(marius): this mirrors what CreateService does for the self service, which isn't available for other actors.
+func createAccount(db registrationStore, base vocab.IRI, self vocab.Item, p *vocab.Person, keyGen func(*vocab.Actor) error, now time.Time) (vocab.Item, error) {
+	id, err := GenerateID(base)(p, filters.ActorsType.IRI(base), self)
+	if err != nil {
+		return nil, err
+	}
+	p.ID = id
+	p.Published = now
+	p.Updated = now
+	p.Inbox = vocab.Inbox.IRI(p)
+	p.Outbox = vocab.Outbox.IRI(p)
+	p.Followers = vocab.Followers.IRI(p)
+	p.Following = vocab.Following.IRI(p)
+	p.Liked = vocab.Liked.IRI(p)
+	collections := vocab.IRIs{p.Inbox.GetLink(), p.Outbox.GetLink(), p.Followers.GetLink(), p.Following.GetLink(), p.Liked.GetLink()}
+	for _, col := range collections {
+		if _, err = db.Create(&vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType, AttributedTo: p.ID, Published: now}); err != nil {
+			return nil, errors.Annotatef(err, "unable to create %s", col)
+		}
+	}
+	if keyGen != nil {
+		if err = keyGen(p); err != nil {
+			return nil, errors.Annotatef(err, "unable to generate the keys of %s", p.ID)
+		}
+	}
+	saved, err := db.Save(p)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to save the actor %s", p.ID)
+	}
+	if err = db.AddTo(filters.ActorsType.IRI(base), saved.GetLink()); err != nil {
+		return nil, errors.Annotatef(err, "unable to add %s to the actors collection", saved.GetLink())
+	}
+	return saved, nil
+}
+
+// registerAccount creates the account of the reg registration right away, which is what the open registrations do
+func registerAccount(db registrationStore, base vocab.IRI, self vocab.Item, reg registration, keyGen func(*vocab.Actor) error, now time.Time) (vocab.Item, error) {
+	p := &vocab.Person{
+		Type:              vocab.PersonType,
+		PreferredUsername: vocab.DefaultNaturalLanguageValue(reg.Username),
+		AttributedTo:      self.GetLink(),
+	}
+	it, err := createAccount(db, base, self, p, keyGen, now)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PasswordSet(it.GetLink(), []byte(reg.Password)); err != nil {
+		return nil, errors.Annotatef(err, "unable to set the password of %s", it.GetLink())
+	}
+	return it, nil
+}
+
+// queueRegistration saves the reg registration in the pending registrations collection, where it waits for an
+// administrator to approve it. The reason of the registration is kept in the content of the pending actor.
+func queueRegistration(db registrationStore, self vocab.Item, reg registration, now time.Time) (vocab.Item, error) {
+	col := pendingRegistrations(self)
+	p := &vocab.Person{
+		ID:                col.AddPath(uuid.New()),
+		Type:              vocab.PersonType,
+		PreferredUsername: vocab.DefaultNaturalLanguageValue(reg.Username),
+		Published:         now,
+	}
+	if len(reg.Reason) > 0 {
+		p.Content = vocab.DefaultNaturalLanguageValue(reg.Reason)
+	}
+	if _, err := db.Load(col); errors.IsNotFound(err) {
+		if _, err = db.Create(&vocab.OrderedCollection{ID: col, Type: vocab.OrderedCollectionType}); err != nil {
+			return nil, errors.Annotatef(err, "unable to create %s", col)
+		}
+	}
+	saved, err := db.Save(p)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to save the registration of %q", reg.Username)
+	}
+	if err = db.PasswordSet(saved.GetLink(), []byte(reg.Password)); err != nil {
+		return nil, errors.Annotatef(err, "unable to set the password of the registration %s", saved.GetLink())
+	}
+	if err = db.AddTo(col, saved.GetLink()); err != nil {
+		return nil, errors.Annotatef(err, "unable to add %s to %s", saved.GetLink(), col)
+	}
+	return saved, nil
+}
+
+// loadRegistration returns the pending registration with the iri
+func loadRegistration(db processing.ReadStore, self vocab.Item, iri vocab.IRI) (*vocab.Person, error) {
+	if !collectionContains(db, pendingRegistrations(self), iri) {
+		return nil, errors.NotFoundf("registration %s not found", iri)
+	}
+	it, err := db.Load(iri)
+	if err != nil {
+		return nil, err
+	}
+	return vocab.ToActor(firstItem(it))
+}
+
+// approveRegistration creates the account of the pending registration with the iri, with the password it was
+// registered with, and removes it from the pending registrations.
+func approveRegistration(db registrationStore, base vocab.IRI, self vocab.Item, iri vocab.IRI, keyGen func(*vocab.Actor) error, now time.Time) (vocab.Item, error) {
+	pending, err := loadRegistration(db, self, iri)
+	if err != nil {
+		return nil, err
+	}
+	p := &vocab.Person{
+		Type:              vocab.PersonType,
+		PreferredUsername: pending.PreferredUsername,
+		AttributedTo:      self.GetLink(),
+	}
+	it, err := createAccount(db, base, self, p, keyGen, now)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE(marius): the storage keeps only the hash of the password, so we copy it from the metadata of the
+	// pending registration, next to the actor's keys.
+	if pm, err := db.LoadMetadata(iri); err == nil && pm != nil {
+		m, _ := db.LoadMetadata(it.GetLink())
+		if m == nil {
+			m = new(processing.Metadata)
+		}
+		m.Pw = pm.Pw
+		if err = db.SaveMetadata(*m, it.GetLink()); err != nil {
+			return nil, errors.Annotatef(err, "unable to set the password of %s", it.GetLink())
+		}
+	}
+	return it, rejectRegistration(db, self, iri)
+}
+
+// rejectRegistration removes the pending registration with the iri
+func rejectRegistration(db registrationStore, self vocab.Item, iri vocab.IRI) error {
+	col := pendingRegistrations(self)
+	if !collectionContains(db, col, iri) {
+		return errors.NotFoundf("registration %s not found", iri)
+	}
+	if err := db.RemoveFrom(col, iri); err != nil {
+		return errors.Annotatef(err, "unable to remove %s from %s", iri, col)
+	}
+	if err := db.Delete(iri); err != nil && !errors.IsNotFound(err) {
+		return errors.Annotatef(err, "unable to delete the registration %s", iri)
+	}
+	return nil
+}
+
+// writeItem writes the JSON representation of the it item with the status code
+func writeItem(w http.ResponseWriter, r *http.Request, status int, it vocab.Item) {
+	data, err := vocab.MarshalJSON(it)
+	if err != nil {
+		errors.HandleError(err).ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func handleRegister(db registrationStore, base vocab.IRI, self vocab.Item, mode config.RegistrationMode, keyGen func(*vocab.Actor) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mode != config.RegistrationOpen && mode != config.RegistrationApproval {
+			errors.HandleError(errors.Forbiddenf("registrations are closed")).ServeHTTP(w, r)
+			return
+		}
+		reg := registration{}
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			errors.HandleError(errors.NewBadRequest(err, "invalid registration")).ServeHTTP(w, r)
+			return
+		}
+		if err := validateRegistration(db, base, self, reg); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		now := time.Now().UTC()
+		if mode == config.RegistrationApproval {
+			it, err := queueRegistration(db, self, reg, now)
+			if err != nil {
+				errors.HandleError(err).ServeHTTP(w, r)
+				return
+			}
+			writeItem(w, r, http.StatusAccepted, it)
+			return
+		}
+		it, err := registerAccount(db, base, self, reg, keyGen, now)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Location", it.GetLink().String())
+		writeItem(w, r, http.StatusCreated, it)
+	}
+}
+
+func handleRegistrations(db registrationStore, base vocab.IRI, self vocab.Item, keyGen func(*vocab.Actor) error, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(actorFn(r), self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		col := pendingRegistrations(self)
+		id := chi.URLParam(r, "id")
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			items := make(vocab.ItemCollection, 0)
+			if loaded, err := db.Load(col); err == nil {
+				vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+					for _, it := range c.Collection() {
+						if p, err := loadRegistration(db, self, it.GetLink()); err == nil {
+							items = append(items, p)
+						}
+					}
+					return nil
+				})
+			}
+			writeItem(w, r, http.StatusOK, &vocab.OrderedCollection{
+				ID:           col,
+				Type:         vocab.OrderedCollectionType,
+				OrderedItems: items,
+				TotalItems:   uint(len(items)),
+			})
+		case r.Method == http.MethodPost && id != "":
+			it, err := approveRegistration(db, base, self, col.AddPath(id), keyGen, time.Now().UTC())
+			if err != nil {
+				errors.HandleError(err).ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Location", it.GetLink().String())
+			writeItem(w, r, http.StatusCreated, it)
+		case r.Method == http.MethodDelete && id != "":
+			if err := rejectRegistration(db, self, col.AddPath(id)); err != nil {
+				errors.HandleError(err).ServeHTTP(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			errors.HandleError(errors.MethodNotAllowedf("method not allowed")).ServeHTTP(w, r)
+		}
+	}
+}
+
+// HandleRegister serves the account registrations, depending on the registration mode of the instance: with open
+// registrations the account is created right away, with approval the registration is kept pending until an
+// administrator approves it, and with closed registrations the requests are rejected.
+func HandleRegister(fb FedBOX) http.HandlerFunc {
+	db, ok := fb.storage.(registrationStore)
+	if !ok {
+		return errors.HandleError(errors.NotImplementedf("registrations are not supported by the storage")).ServeHTTP
+	}
+	return handleRegister(db, vocab.IRI(fb.Config().BaseURL), fb.self, fb.Config().RegistrationMode, fb.keyGenerator)
+}
+
+// HandleRegistrations serves the administrative end-points for the pending registrations: listing them,
+// approving one with a POST, or rejecting it with a DELETE.
+func HandleRegistrations(fb FedBOX) http.HandlerFunc {
+	db, ok := fb.storage.(registrationStore)
+	if !ok {
+		return errors.HandleError(errors.NotImplementedf("registrations are not supported by the storage")).ServeHTTP
+	}
+	return handleRegistrations(db, vocab.IRI(fb.Config().BaseURL), fb.self, fb.keyGenerator, fb.actorFromRequest)
+}
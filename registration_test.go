@@ -0,0 +1,140 @@
+package fedbox
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/filters"
+	"github.com/go-chi/chi/v5"
+)
+
+type mockRegistrationStore struct {
+	mockCollectionStore
+	mockMetadata
+}
+
+func (m mockRegistrationStore) PasswordSet(it vocab.Item, pw []byte) error {
+	meta := m.mockMetadata[it.GetLink()]
+	meta.Pw = pw
+	m.mockMetadata[it.GetLink()] = meta
+	return nil
+}
+
+func (m mockRegistrationStore) PasswordCheck(it vocab.Item, pw []byte) error {
+	if meta, ok := m.mockMetadata[it.GetLink()]; ok && bytes.Equal(meta.Pw, pw) {
+		return nil
+	}
+	return errors.Unauthorizedf("invalid password")
+}
+
+func TestHandleRegister(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	self := &vocab.Service{ID: base, Type: vocab.ServiceType}
+	actors := filters.ActorsType.IRI(base)
+	body := `{"username":"johndoe","password":"s3cr3t","reason":"hello"}`
+
+	newStore := func() mockRegistrationStore {
+		return mockRegistrationStore{mockCollectionStore{mockStore{}}, mockMetadata{}}
+	}
+	register := func(db mockRegistrationStore, mode config.RegistrationMode, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/"+registerPath, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handleRegister(db, base, self, mode, nil).ServeHTTP(w, req)
+		return w
+	}
+	countActors := func(db mockRegistrationStore) int {
+		col, ok := db.mockStore[actors].(*vocab.OrderedCollection)
+		if !ok {
+			return 0
+		}
+		return len(col.OrderedItems)
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		db := newStore()
+		if w := register(db, config.RegistrationClosed, body); w.Code != http.StatusForbidden {
+			t.Errorf("a closed instance returned %d, expected %d", w.Code, http.StatusForbidden)
+		}
+		if countActors(db) != 0 {
+			t.Errorf("a closed instance should not create accounts")
+		}
+	})
+	t.Run("open", func(t *testing.T) {
+		db := newStore()
+		w := register(db, config.RegistrationOpen, body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("an open instance returned %d, expected %d: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		iri := vocab.IRI(w.Header().Get("Location"))
+		if !collectionContains(db, actors, iri) {
+			t.Errorf("the new account %s should be in the actors collection", iri)
+		}
+		if _, err := db.Load(vocab.Inbox.IRI(iri)); err != nil {
+			t.Errorf("the inbox of the new account should have been created: %s", err)
+		}
+		if err := db.PasswordCheck(iri, []byte("s3cr3t")); err != nil {
+			t.Errorf("the password of the new account should be set: %s", err)
+		}
+		if w = register(db, config.RegistrationOpen, body); w.Code != http.StatusConflict {
+			t.Errorf("registering the same username returned %d, expected %d", w.Code, http.StatusConflict)
+		}
+	})
+	t.Run("approval", func(t *testing.T) {
+		db := newStore()
+		w := register(db, config.RegistrationApproval, body)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("an instance with approval returned %d, expected %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+		}
+		if countActors(db) != 0 {
+			t.Fatalf("the registration should be pending, not create the account")
+		}
+
+		admin := func(r *http.Request) vocab.Actor { return *self }
+		h := handleRegistrations(db, base, self, nil, admin)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/"+registrationsPath, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "johndoe") {
+			t.Fatalf("the pending registrations should list johndoe, got %d: %s", w.Code, w.Body.String())
+		}
+
+		pending, _ := db.Load(pendingRegistrations(self))
+		var id string
+		vocab.OnCollectionIntf(pending, func(c vocab.CollectionInterface) error {
+			id = strings.TrimPrefix(c.Collection()[0].GetLink().String(), pendingRegistrations(self).String()+"/")
+			return nil
+		})
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req := httptest.NewRequest(http.MethodPost, "/admin/"+registrationsPath+"/"+id, nil)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+		w = httptest.NewRecorder()
+		handleRegistrations(db, base, self, nil, func(r *http.Request) vocab.Actor { return vocab.Actor{} }).ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("approving anonymously returned %d, expected %d", w.Code, http.StatusUnauthorized)
+		}
+
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("approving the registration returned %d, expected %d: %s", w.Code, http.StatusCreated, w.Body.String())
+		}
+		iri := vocab.IRI(w.Header().Get("Location"))
+		if !collectionContains(db, actors, iri) {
+			t.Errorf("the approved account %s should be in the actors collection", iri)
+		}
+		if err := db.PasswordCheck(iri, []byte("s3cr3t")); err != nil {
+			t.Errorf("the approved account should keep the registration password: %s", err)
+		}
+		if collectionContains(db, pendingRegistrations(self), pendingRegistrations(self).AddPath(id)) {
+			t.Errorf("the approved registration should not be pending anymore")
+		}
+	})
+}
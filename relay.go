@@ -0,0 +1,259 @@
+package fedbox
+
+import (
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// relayIRIs returns the IRIs of the relays inboxes from the configuration values
+func relayIRIs(inboxes []string) vocab.IRIs {
+	relays := make(vocab.IRIs, 0, len(inboxes))
+	for _, inbox := range inboxes {
+		relays = append(relays, vocab.IRI(inbox))
+	}
+	return relays
+}
+
+// inboxOf returns the inbox where the it actor receives activities, its shared inbox when it has one
+func inboxOf(it vocab.Item) vocab.IRI {
+	var inbox vocab.IRI
+	vocab.OnActor(it, func(act *vocab.Actor) error {
+		if act.Endpoints != nil && !vocab.IsNil(act.Endpoints.SharedInbox) {
+			inbox = act.Endpoints.SharedInbox.GetLink()
+		} else if !vocab.IsNil(act.Inbox) {
+			inbox = act.Inbox.GetLink()
+		}
+		return nil
+	})
+	return inbox
+}
+
+// relayFollow returns the Follow activity of the self service for subscribing to a relay.
+// By convention, the object of the Follow is the Public namespace.
+func relayFollow(self vocab.Item) *vocab.Activity {
+	return &vocab.Activity{
+		Type:      vocab.FollowType,
+		Actor:     self.GetLink(),
+		Object:    vocab.PublicNS,
+		Published: time.Now().UTC(),
+	}
+}
+
+// isRelayFollow checks if the it item is a Follow activity of the self service for subscribing to a relay
+func isRelayFollow(it vocab.Item, self vocab.Item) bool {
+	ok := false
+	vocab.OnActivity(it, func(a *vocab.Activity) error {
+		ok = a.GetType() == vocab.FollowType && !vocab.IsNil(a.Actor) && a.Actor.GetLink().Equals(self.GetLink(), false) &&
+			!vocab.IsNil(a.Object) && a.Object.GetLink().Equals(vocab.PublicNS, false)
+		return nil
+	})
+	return ok
+}
+
+// subscribeRelay sends a Follow activity of the self service to the inbox of a relay.
+// The Follow is saved, so we can recognize it in the Accept sent by the relay.
+func subscribeRelay(cl activityDeliverer, db processing.WriteStore, self vocab.Item, inbox vocab.IRI) (vocab.Item, error) {
+	follow := relayFollow(self)
+	if _, err := GenerateID(self.GetLink())(follow, vocab.Outbox.IRI(self), self); err != nil {
+		return nil, err
+	}
+	if _, err := db.Save(follow); err != nil {
+		return nil, errors.Annotatef(err, "unable to save the Follow for relay %s", inbox)
+	}
+	if _, _, err := cl.ToCollection(inbox, follow); err != nil {
+		return nil, errors.Annotatef(err, "unable to send the Follow to relay %s", inbox)
+	}
+	return follow, nil
+}
+
+// unsubscribeRelay sends an Undo of the subscription Follow to the relay actor, and removes it
+// from the following collection of the self service.
+func unsubscribeRelay(cl activityDeliverer, db collectionStore, self vocab.Item, relay vocab.Item) error {
+	inbox := inboxOf(relay)
+	if inbox == "" {
+		return errors.NotFoundf("no inbox found for relay %s", relay.GetLink())
+	}
+	undo := &vocab.Activity{
+		Type:      vocab.UndoType,
+		Actor:     self.GetLink(),
+		Object:    relayFollow(self),
+		Published: time.Now().UTC(),
+	}
+	if _, err := GenerateID(self.GetLink())(undo, vocab.Outbox.IRI(self), self); err != nil {
+		return err
+	}
+	if _, _, err := cl.ToCollection(inbox, undo); err != nil {
+		return errors.Annotatef(err, "unable to send the Undo to relay %s", inbox)
+	}
+	return db.RemoveFrom(vocab.Following.IRI(self), relay.GetLink())
+}
+
+// relayActors returns the relay actors which have accepted the subscription of the self service,
+// which we keep in its following collection.
+func relayActors(db processing.ReadStore, self vocab.Item) vocab.ItemCollection {
+	relays := make(vocab.ItemCollection, 0)
+	following, err := db.Load(vocab.Following.IRI(self))
+	if err != nil {
+		return relays
+	}
+	vocab.OnCollectionIntf(following, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if vocab.IsIRI(it) {
+				if it, err = db.Load(it.GetLink()); err != nil {
+					continue
+				}
+			}
+			if vocab.ActorTypes.Contains(it.GetType()) && inboxOf(it) != "" {
+				relays = append(relays, it)
+			}
+		}
+		return nil
+	})
+	return relays
+}
+
+// syncRelays subscribes the self service to the relays in the inboxes list which haven't accepted a subscription yet,
+// and unsubscribes it from the ones which are no longer in the list.
+//
+// NOTE(marius): the Follow is sent again on every call until the relay accepts it, which the relays handle fine,
+// as they keep a single subscription per server.
+func syncRelays(cl activityDeliverer, db collectionStore, self vocab.Item, inboxes vocab.IRIs) error {
+	subscribed := make(vocab.IRIs, 0)
+	var errs []error
+	for _, relay := range relayActors(db, self) {
+		inbox := inboxOf(relay)
+		if inboxes.Contains(inbox) {
+			subscribed = append(subscribed, inbox)
+			continue
+		}
+		if err := unsubscribeRelay(cl, db, self, relay); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, inbox := range inboxes {
+		if subscribed.Contains(inbox) {
+			continue
+		}
+		if _, err := subscribeRelay(cl, db, self, inbox); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Annotatef(errs[0], "%d of the relays subscriptions failed", len(errs))
+	}
+	return nil
+}
+
+// acceptRelay handles an Accept received by the self service for the Follow it sent to one of the inboxes relays.
+// The relay actor is saved and added to the following collection of the self service, which marks the
+// subscription as active. The function returns the IRIs of the items and collections that have been modified.
+func acceptRelay(db collectionStore, cl iriLoader, self vocab.Item, inboxes vocab.IRIs, accept *vocab.Activity) (vocab.IRIs, error) {
+	if accept == nil || accept.GetType() != vocab.AcceptType || vocab.IsNil(accept.Actor) {
+		return nil, nil
+	}
+	if follow, err := loadFollow(db, accept.Object); err != nil || !isRelayFollow(follow, self) {
+		return nil, nil
+	}
+	relay, err := cl.LoadIRI(accept.Actor.GetLink())
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load the relay actor %s", accept.Actor.GetLink())
+	}
+	if inbox := inboxOf(relay); !inboxes.Contains(inbox) {
+		return nil, errors.NotValidf("%s is not the actor of a configured relay", relay.GetLink())
+	}
+	following := vocab.Following.IRI(self)
+	if collectionContains(db, following, relay) {
+		return nil, nil
+	}
+	if _, err = db.Save(relay); err != nil {
+		return nil, errors.Annotatef(err, "unable to save the relay actor %s", relay.GetLink())
+	}
+	if err = db.AddTo(following, relay.GetLink()); err != nil {
+		return nil, errors.Annotatef(err, "unable to add the relay actor %s to %s", relay.GetLink(), following)
+	}
+	return vocab.IRIs{relay.GetLink(), following}, nil
+}
+
+// ingestRelayAnnounce saves the activity, or object, announced by a relay the self service is subscribed to,
+// loading it from its origin server when the Announce contains only its IRI.
+// The function returns the IRIs of the items that have been saved.
+func ingestRelayAnnounce(db collectionStore, cl iriLoader, self vocab.Item, announce *vocab.Activity) (vocab.IRIs, error) {
+	if announce == nil || announce.GetType() != vocab.AnnounceType || vocab.IsNil(announce.Actor) || vocab.IsNil(announce.Object) {
+		return nil, nil
+	}
+	if !collectionContains(db, vocab.Following.IRI(self), announce.Actor) {
+		return nil, nil
+	}
+	it := announce.Object
+	if vocab.IsIRI(it) {
+		var err error
+		if it, err = cl.LoadIRI(it.GetLink()); err != nil {
+			return nil, errors.Annotatef(err, "unable to load %s announced by relay %s", announce.Object.GetLink(), announce.Actor.GetLink())
+		}
+	}
+	toSave := vocab.ItemCollection{it}
+	vocab.OnActivity(it, func(a *vocab.Activity) error {
+		if !vocab.IsNil(a.Object) && !vocab.IsIRI(a.Object) {
+			toSave = append(toSave, a.Object)
+		}
+		return nil
+	})
+	saved := make(vocab.IRIs, 0)
+	for _, ob := range toSave {
+		if _, err := db.Save(ob); err != nil {
+			return saved, errors.Annotatef(err, "unable to save %s announced by relay %s", ob.GetLink(), announce.Actor.GetLink())
+		}
+		saved = append(saved, ob.GetLink())
+	}
+	return saved, nil
+}
+
+// isPublic checks if the a activity is addressed to the Public namespace
+func isPublic(a *vocab.Activity) bool {
+	for _, rec := range a.Recipients() {
+		if !vocab.IsNil(rec) && rec.GetLink().Equals(vocab.PublicNS, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardToRelays delivers the public Create activities of the local actors to the relays which accepted the
+// subscription of the self service. It returns the inboxes it has been delivered to.
+func forwardToRelays(cl activityDeliverer, db processing.ReadStore, self vocab.Item, a *vocab.Activity) (vocab.IRIs, error) {
+	if a == nil || a.GetType() != vocab.CreateType || !isPublic(a) {
+		return nil, nil
+	}
+	inboxes := make(vocab.IRIs, 0)
+	var errs []error
+	for _, relay := range relayActors(db, self) {
+		inbox := inboxOf(relay)
+		if inboxes.Contains(inbox) {
+			continue
+		}
+		if _, _, err := cl.ToCollection(inbox, a); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to forward %s to relay %s", a.GetLink(), inbox))
+			continue
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	if len(errs) > 0 {
+		return inboxes, errors.Annotatef(errs[0], "%d of the deliveries to relays failed", len(errs))
+	}
+	return inboxes, nil
+}
+
+// subscribeToRelays synchronizes the subscriptions of the self service with the relays in the configuration
+func (f *FedBOX) subscribeToRelays() {
+	db, ok := f.storage.(collectionStore)
+	if !ok {
+		f.errFn("unable to subscribe to relays with the %T storage", f.storage)
+		return
+	}
+	if err := syncRelays(&f.client, db, &f.self, relayIRIs(f.conf.Relays)); err != nil {
+		f.errFn("unable to synchronize the relays subscriptions: %+s", err)
+	}
+}
@@ -0,0 +1,131 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestRelaySubscription(t *testing.T) {
+	self := &vocab.Actor{ID: "https://fedbox.local/", Type: vocab.ServiceType}
+	relay := &vocab.Actor{
+		ID:    "https://relay.example.com/actor",
+		Type:  vocab.ApplicationType,
+		Inbox: vocab.IRI("https://relay.example.com/inbox"),
+	}
+	note := &vocab.Object{ID: "https://example.com/notes/1", Type: vocab.NoteType}
+	relays := vocab.IRIs{relay.Inbox.GetLink()}
+
+	db := mockCollectionStore{mockStore: mockStore{}}
+	db.Create(&vocab.OrderedCollection{ID: vocab.Following.IRI(self), Type: vocab.OrderedCollectionType})
+	cl := &mockDeliverer{
+		mockLoader: mockLoader{relay.ID: relay, note.ID: note},
+		delivered:  make(map[vocab.IRI]vocab.IRIs),
+	}
+
+	if err := syncRelays(cl, db, self, relays); err != nil {
+		t.Fatalf("syncRelays() error = %s", err)
+	}
+	sent := cl.delivered[relay.Inbox.GetLink()]
+	if len(sent) != 1 {
+		t.Fatalf("syncRelays() sent %d activities to the relay, expected 1", len(sent))
+	}
+	follow, ok := db.mockStore[sent[0]]
+	if !ok || !isRelayFollow(follow, self) {
+		t.Fatalf("the activity sent to the relay is not a saved relay Follow: %v", follow)
+	}
+
+	create := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/1",
+		Type:   vocab.CreateType,
+		Actor:  vocab.IRI("https://fedbox.local/actors/johndoe"),
+		To:     vocab.ItemCollection{vocab.PublicNS},
+		Object: vocab.IRI("https://fedbox.local/objects/1"),
+	}
+	if inboxes, _ := forwardToRelays(cl, db, self, create); len(inboxes) > 0 {
+		t.Errorf("forwardToRelays() forwarded to %v before the relay accepted the subscription", inboxes)
+	}
+
+	accept := &vocab.Activity{
+		ID:     "https://relay.example.com/activities/accept",
+		Type:   vocab.AcceptType,
+		Actor:  relay.ID,
+		Object: follow.GetLink(),
+	}
+	modified, err := acceptRelay(db, cl, self, relays, accept)
+	if err != nil {
+		t.Fatalf("acceptRelay() error = %s", err)
+	}
+	if !modified.Contains(vocab.Following.IRI(self)) {
+		t.Errorf("acceptRelay() modified %v, expected it to contain the following collection", modified)
+	}
+	if !collectionContains(db, vocab.Following.IRI(self), relay) {
+		t.Fatalf("the relay actor has not been added to the following collection")
+	}
+	if err = syncRelays(cl, db, self, relays); err != nil {
+		t.Fatalf("syncRelays() error = %s", err)
+	}
+	if sent = cl.delivered[relay.Inbox.GetLink()]; len(sent) != 1 {
+		t.Errorf("syncRelays() subscribed again to a relay which accepted the subscription")
+	}
+
+	inboxes, err := forwardToRelays(cl, db, self, create)
+	if err != nil {
+		t.Fatalf("forwardToRelays() error = %s", err)
+	}
+	if len(inboxes) != 1 || !inboxes.Contains(relay.Inbox.GetLink()) {
+		t.Errorf("forwardToRelays() delivered to %v, expected %v", inboxes, relays)
+	}
+	private := *create
+	private.ID = "https://fedbox.local/activities/2"
+	private.To = vocab.ItemCollection{vocab.IRI("https://example.com/users/bob")}
+	if inboxes, _ = forwardToRelays(cl, db, self, &private); len(inboxes) > 0 {
+		t.Errorf("forwardToRelays() forwarded a non public activity to %v", inboxes)
+	}
+
+	announce := &vocab.Activity{
+		ID:     "https://relay.example.com/activities/announce",
+		Type:   vocab.AnnounceType,
+		Actor:  relay.ID,
+		Object: note.ID,
+	}
+	saved, err := ingestRelayAnnounce(db, cl, self, announce)
+	if err != nil {
+		t.Fatalf("ingestRelayAnnounce() error = %s", err)
+	}
+	if !saved.Contains(note.ID) || db.mockStore[note.ID] == nil {
+		t.Errorf("ingestRelayAnnounce() didn't save the announced %s", note.ID)
+	}
+	other := *announce
+	other.Actor = vocab.IRI("https://other.example.com/actor")
+	if saved, _ = ingestRelayAnnounce(db, cl, self, &other); len(saved) > 0 {
+		t.Errorf("ingestRelayAnnounce() saved %v announced by an unknown relay", saved)
+	}
+
+	if err = syncRelays(cl, db, self, nil); err != nil {
+		t.Fatalf("syncRelays() error = %s", err)
+	}
+	if sent = cl.delivered[relay.Inbox.GetLink()]; len(sent) != 3 {
+		t.Errorf("syncRelays() didn't send an Undo to the removed relay")
+	}
+	if collectionContains(db, vocab.Following.IRI(self), relay) {
+		t.Errorf("the removed relay is still in the following collection")
+	}
+}
+
+func TestAcceptRelayIgnoresOtherActivities(t *testing.T) {
+	self := &vocab.Actor{ID: "https://fedbox.local/", Type: vocab.ServiceType}
+	relay := &vocab.Actor{ID: "https://relay.example.com/actor", Type: vocab.ApplicationType, Inbox: vocab.IRI("https://relay.example.com/inbox")}
+	follow := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/follow",
+		Type:   vocab.FollowType,
+		Actor:  vocab.IRI("https://fedbox.local/actors/johndoe"),
+		Object: relay.ID,
+	}
+	db := mockCollectionStore{mockStore: mockStore{follow.ID: follow}}
+	accept := &vocab.Activity{Type: vocab.AcceptType, Actor: relay.ID, Object: follow.ID}
+	modified, err := acceptRelay(db, mockLoader{relay.ID: relay}, self, vocab.IRIs{relay.Inbox.GetLink()}, accept)
+	if err != nil || len(modified) > 0 {
+		t.Errorf("acceptRelay() = %v, %v for the Accept of a regular Follow", modified, err)
+	}
+}
@@ -0,0 +1,406 @@
+package fedbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+const (
+	fetchPath  = "fetch"
+	robotsPath = "/robots.txt"
+	// remoteObjectMaxSize is the maximum size of the remote documents we accept
+	remoteObjectMaxSize = 1 << 20
+	// acceptActivityPub is the Accept header of the requests fetching the remote objects
+	acceptActivityPub = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+	// robotsTTL is the duration we keep the robots.txt rules of a host
+	robotsTTL = 24 * time.Hour
+	// fetchFailureTTL is the duration we don't retry the remote objects, or the robots.txt files, which we
+	// failed to fetch
+	fetchFailureTTL = 5 * time.Minute
+	// remoteCacheSize is the maximum number of hosts whose robots.txt rules we keep, and of IRIs we failed to fetch
+	remoteCacheSize = 10000
+)
+
+// robotsRules are the paths disallowed to all user agents by the robots.txt file of a host
+type robotsRules []string
+
+// parseRobots returns the Disallow rules of the groups which apply to all the user agents.
+//
+// NOTE(marius): we don't support the Allow rules or the wildcards in the paths, so we're a bit more strict
+// than the hosts require.
+func parseRobots(r io.Reader) robotsRules {
+	rules := make(robotsRules, 0)
+	applies := false
+	inAgents := false
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "user-agent":
+			// NOTE(marius): consecutive User-agent lines are part of the same group
+			if !inAgents {
+				applies = false
+			}
+			inAgents = true
+			applies = applies || val == "*"
+		case "disallow":
+			inAgents = false
+			if applies && len(val) > 0 {
+				rules = append(rules, val)
+			}
+		default:
+			inAgents = false
+		}
+	}
+	return rules
+}
+
+func (r robotsRules) allowed(p string) bool {
+	if len(p) == 0 {
+		p = "/"
+	}
+	for _, prefix := range r {
+		if strings.HasPrefix(p, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// robotsEntry are the robots.txt rules of a host, which we keep until they expire
+type robotsEntry struct {
+	rules   robotsRules
+	expires time.Time
+}
+
+// remoteFetcher loads the remote objects which we don't have locally, and stores them in the db storage,
+// so the subsequent loads don't leave the instance.
+// It respects the robots.txt files of the remote hosts, which it keeps in memory, and the requests time out
+// after the timeout duration. The IRIs it fails to fetch aren't retried for the fetchFailureTTL duration, and
+// the hosts resolving to private or loopback addresses are refused.
+type remoteFetcher struct {
+	cl       httpDoer
+	db       processing.Store
+	base     vocab.IRI
+	timeout  time.Duration
+	now      func() time.Time
+	lookupIP func(context.Context, string) ([]net.IPAddr, error)
+	mu       sync.RWMutex
+	robots   map[string]robotsEntry
+	failed   map[vocab.IRI]time.Time
+}
+
+func newRemoteFetcher(cl httpDoer, db processing.Store, base vocab.IRI, timeout time.Duration) *remoteFetcher {
+	return &remoteFetcher{
+		cl:       cl,
+		db:       db,
+		base:     base,
+		timeout:  timeout,
+		now:      time.Now,
+		lookupIP: net.DefaultResolver.LookupIPAddr,
+		robots:   make(map[string]robotsEntry),
+		failed:   make(map[vocab.IRI]time.Time),
+	}
+}
+
+// isPublicIP checks if the ip address is reachable on the internet, and not one of the loopback, private,
+// or link-local addresses of the network the instance runs in
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// checkHost refuses the hosts which resolve to addresses that aren't public, so the requests to the instance
+// can't be used to reach the services of its network.
+//
+// NOTE(marius): the client resolves the host again, so a host changing its DNS records in between can still
+// get through. We can't do better without controlling the dialer of the client.
+func (f *remoteFetcher) checkHost(ctx context.Context, u *url.URL) error {
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return errors.Forbiddenf("fetching from the %s address is not allowed", host)
+		}
+		return nil
+	}
+	addrs, err := f.lookupIP(ctx, host)
+	if err != nil {
+		return errors.NewBadGateway(err, "unable to resolve %s", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return errors.Forbiddenf("fetching from %s is not allowed, it resolves to the %s address", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// get returns the body and the Content-Type of the response to the GET request for u
+func (f *remoteFetcher) get(ctx context.Context, u string, accept string) ([]byte, string, error) {
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", errors.NewBadRequest(err, "invalid request for %s", u)
+	}
+	req.Header.Set("Accept", accept)
+	res, err := f.cl.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, "", errors.NewTimeout(err, "fetching %s timed out", u)
+		}
+		return nil, "", errors.NewBadGateway(err, "unable to fetch %s", u)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, "", errors.NotFoundf("%s not found", u)
+	case res.StatusCode == http.StatusGone:
+		return nil, "", errors.Gonef("%s is gone", u)
+	case res.StatusCode != http.StatusOK:
+		return nil, "", errors.BadGatewayf("fetching %s returned status %d", u, res.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, remoteObjectMaxSize+1))
+	if err != nil {
+		return nil, "", errors.NewBadGateway(err, "unable to read %s", u)
+	}
+	if len(data) > remoteObjectMaxSize {
+		return nil, "", errors.NotValidf("%s is larger than %d bytes", u, remoteObjectMaxSize)
+	}
+	return data, res.Header.Get("Content-Type"), nil
+}
+
+// robotsAllowed checks if the robots.txt of the host of u allows us to fetch it. The hosts which
+// don't have a robots.txt file allow everything, and so do the ones for which we can't load it, but we try
+// again after the fetchFailureTTL duration.
+func (f *remoteFetcher) robotsAllowed(ctx context.Context, u *url.URL) bool {
+	host := u.Scheme + "://" + u.Host
+	now := f.now()
+	f.mu.RLock()
+	entry, ok := f.robots[host]
+	f.mu.RUnlock()
+	if !ok || now.After(entry.expires) {
+		entry = robotsEntry{rules: make(robotsRules, 0), expires: now.Add(robotsTTL)}
+		data, _, err := f.get(ctx, host+robotsPath, "text/plain")
+		switch {
+		case err == nil:
+			entry.rules = parseRobots(bytes.NewReader(data))
+		case !errors.IsNotFound(err) && !errors.IsGone(err):
+			entry.expires = now.Add(fetchFailureTTL)
+		}
+		f.mu.Lock()
+		if len(f.robots) >= remoteCacheSize {
+			for h, e := range f.robots {
+				if now.After(e.expires) {
+					delete(f.robots, h)
+				}
+			}
+		}
+		// NOTE(marius): when all the entries are still valid, we drop a random one
+		for h := range f.robots {
+			if len(f.robots) < remoteCacheSize {
+				break
+			}
+			delete(f.robots, h)
+		}
+		f.robots[host] = entry
+		f.mu.Unlock()
+	}
+	return entry.rules.allowed(u.EscapedPath())
+}
+
+// recentlyFailed checks if we failed to fetch the iri in the last fetchFailureTTL duration
+func (f *remoteFetcher) recentlyFailed(iri vocab.IRI) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	expires, ok := f.failed[iri]
+	return ok && f.now().Before(expires)
+}
+
+// setFailed remembers that fetching the iri failed, or forgets it if ok
+func (f *remoteFetcher) setFailed(iri vocab.IRI, ok bool) {
+	now := f.now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ok {
+		delete(f.failed, iri)
+		return
+	}
+	if len(f.failed) >= remoteCacheSize {
+		for i, expires := range f.failed {
+			if now.After(expires) {
+				delete(f.failed, i)
+			}
+		}
+	}
+	for i := range f.failed {
+		if len(f.failed) < remoteCacheSize {
+			break
+		}
+		delete(f.failed, i)
+	}
+	f.failed[iri] = now.Add(fetchFailureTTL)
+}
+
+// fetchJSON returns the raw ActivityPub document of the remote iri, from a host with a public address which
+// allows us to fetch it.
+func (f *remoteFetcher) fetchJSON(ctx context.Context, iri vocab.IRI) ([]byte, error) {
+	u, err := iri.URL()
+	if err != nil || !u.IsAbs() || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, errors.BadRequestf("invalid IRI %q", iri)
+	}
+	if iri.Contains(f.base, false) {
+		return nil, errors.BadRequestf("%s is a local IRI", iri)
+	}
+	if err = f.checkHost(ctx, u); err != nil {
+		return nil, err
+	}
+	if !f.robotsAllowed(ctx, u) {
+		return nil, errors.Forbiddenf("fetching %s is disallowed by the robots.txt of its host", iri)
+	}
+	data, typ, err := f.get(ctx, iri.String(), acceptActivityPub)
+	if err != nil {
+		return nil, err
+	}
+	if !isActivityPubContentType(typ) {
+		return nil, errors.NotValidf("%s returned the %q content type instead of an ActivityPub document", iri, typ)
+	}
+	return data, nil
+}
+
+// Fetch loads the remote object identified by the iri from its host and stores it. The object is valid
+// only if it's an ActivityPub document and its ID is the iri we requested, so the hosts can't inject
+// objects for other IRIs.
+func (f *remoteFetcher) Fetch(ctx context.Context, iri vocab.IRI) (vocab.Item, error) {
+	data, err := f.fetchJSON(ctx, iri)
+	if err != nil {
+		return nil, err
+	}
+	it, err := vocab.UnmarshalJSON(data)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "%s returned an invalid ActivityPub document", iri)
+	}
+	if vocab.IsNil(it) || vocab.IsIRI(it) || !it.GetLink().Equals(iri, false) {
+		return nil, errors.NotValidf("the document returned by %s is not the object with the requested IRI", iri)
+	}
+	return f.db.Save(it)
+}
+
+// Load returns the item identified by the iri. The local items, and the remote ones we have already fetched,
+// are loaded from the storage, the other remote ones are fetched from their hosts.
+func (f *remoteFetcher) Load(iri vocab.IRI) (vocab.Item, error) {
+	return f.load(context.Background(), iri)
+}
+
+func (f *remoteFetcher) load(ctx context.Context, iri vocab.IRI) (vocab.Item, error) {
+	if iri.Contains(f.base, false) {
+		return f.db.Load(iri)
+	}
+	it, err := f.db.Load(iri)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if it = firstItem(it); !vocab.IsNil(it) && it.GetLink().Equals(iri, false) {
+		return it, nil
+	}
+	if f.recentlyFailed(iri) {
+		return nil, errors.NotFoundf("unable to load %s, it failed recently", iri)
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, errors.NewNotFound(err, "unable to load %s", iri)
+	}
+	it, err = f.Fetch(ctx, iri)
+	// NOTE(marius): the failures caused by the request running out of time aren't the fault of the remote host
+	if ctx.Err() == nil {
+		f.setFailed(iri, err == nil)
+	}
+	if err != nil {
+		// NOTE(marius): the rendering of the items continues without the objects we couldn't fetch
+		return nil, errors.NewNotFound(err, "unable to load %s", iri)
+	}
+	return it, nil
+}
+
+// LoadIRI makes the remoteFetcher usable in place of the client when embedding the remote items.
+func (f *remoteFetcher) LoadIRI(iri vocab.IRI) (vocab.Item, error) {
+	return f.Load(iri)
+}
+
+// remoteLoader loads the remote items for a request, and stops fetching them when the request is done
+type remoteLoader struct {
+	f   *remoteFetcher
+	ctx context.Context
+}
+
+// forRequest returns the loader for the remote items needed by the r request, whose fetches take together at
+// most the timeout of the fetcher, so a request needing many unreachable objects doesn't wait for each of them.
+func (f *remoteFetcher) forRequest(r *http.Request) (remoteLoader, context.CancelFunc) {
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if f.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+	}
+	return remoteLoader{f: f, ctx: ctx}, cancel
+}
+
+func (l remoteLoader) Load(iri vocab.IRI) (vocab.Item, error) {
+	return l.f.load(l.ctx, iri)
+}
+
+func (l remoteLoader) LoadIRI(iri vocab.IRI) (vocab.Item, error) {
+	return l.f.load(l.ctx, iri)
+}
+
+func handleFetch(f *remoteFetcher, self vocab.Item, onChange func(...vocab.IRI) bool, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(actorFn(r), self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		iri := vocab.IRI(r.FormValue(iriKey))
+		if len(iri) == 0 {
+			errors.HandleError(errors.BadRequestf("missing %q parameter", iriKey)).ServeHTTP(w, r)
+			return
+		}
+		it, err := f.Fetch(r.Context(), iri)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		onChange(iri)
+		if s, ok := it.(vocab.HasRecipients); ok {
+			s.Clean()
+		}
+		writeItem(w, r, http.StatusOK, it)
+	}
+}
+
+// HandleFetch serves the administrative end-point which fetches the remote object with the "iri" parameter
+// and stores it, replacing the copy we already had.
+func HandleFetch(fb FedBOX) http.HandlerFunc {
+	return handleFetch(fb.remote, &fb.self, fb.caches.Remove, fb.actorFromRequest)
+}
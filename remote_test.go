@@ -0,0 +1,255 @@
+package fedbox
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+)
+
+func TestParseRobots(t *testing.T) {
+	rules := parseRobots(strings.NewReader(`
+User-agent: GPTBot
+Disallow: /
+
+# everyone else
+User-agent: Googlebot
+User-agent: *
+Disallow: /private # the private objects
+Disallow:
+`))
+	tests := map[string]bool{
+		"/":                true,
+		"/objects/1":       true,
+		"/private":         false,
+		"/private/objects": false,
+	}
+	for p, allowed := range tests {
+		if rules.allowed(p) != allowed {
+			t.Errorf("robots allowed(%s) = %t, expected %t", p, !allowed, allowed)
+		}
+	}
+}
+
+func TestRemoteFetcher(t *testing.T) {
+	var hits int32
+	srv := httptest.NewUnstartedServer(nil)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	remote := vocab.IRI("http://remote.example:" + port)
+	note := &vocab.Object{ID: remote.AddPath("objects/1"), Type: vocab.NoteType, To: vocab.ItemCollection{vocab.PublicNS}}
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case robotsPath:
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/objects/1", "/objects/spoofed":
+			if !strings.Contains(r.Header.Get("Accept"), client.ContentTypeActivityJson) {
+				http.Error(w, "not acceptable", http.StatusNotAcceptable)
+				return
+			}
+			ob := *note
+			if r.URL.Path == "/objects/spoofed" {
+				ob.ID = remote.AddPath("objects/other")
+			}
+			data, _ := vocab.MarshalJSON(&ob)
+			w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+			w.Write(data)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv.Start()
+	defer srv.Close()
+
+	// NOTE(marius): the test server listens on a loopback address, which the fetcher refuses, so we pretend
+	// that remote.example resolves to a public address, and connect to the test server instead
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	db := mockStore{}
+	f := newRemoteFetcher(&http.Client{Transport: tr}, db, "https://fedbox.local", 50*time.Millisecond)
+	f.lookupIP = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if host == "internal.example" {
+			return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("203.0.113.1")}}, nil
+	}
+
+	it, err := f.Load(note.ID)
+	if err != nil {
+		t.Fatalf("Load() returned error %s", err)
+	}
+	if !it.GetLink().Equals(note.ID, false) {
+		t.Errorf("Load() returned %s, expected %s", it.GetLink(), note.ID)
+	}
+	if cached, ok := db[note.ID]; !ok || !cached.GetLink().Equals(note.ID, false) {
+		t.Fatalf("the remote object should have been cached in the storage")
+	}
+	before := atomic.LoadInt32(&hits)
+	if _, err = f.Load(note.ID); err != nil {
+		t.Errorf("loading the cached object returned error %s", err)
+	}
+	if after := atomic.LoadInt32(&hits); after != before {
+		t.Errorf("loading the cached object made %d requests to the remote server", after-before)
+	}
+
+	tests := []struct {
+		name  string
+		iri   vocab.IRI
+		errFn func(error) bool
+	}{
+		{name: "disallowed by robots.txt", iri: remote.AddPath("private/1"), errFn: errors.IsForbidden},
+		{name: "object with a different ID", iri: remote.AddPath("objects/spoofed"), errFn: errors.IsNotValid},
+		{name: "not an ActivityPub document", iri: remote.AddPath("page"), errFn: errors.IsNotValid},
+		{name: "missing object", iri: remote.AddPath("objects/666"), errFn: errors.IsNotFound},
+		{name: "timeout", iri: remote.AddPath("slow"), errFn: errors.IsTimeout},
+		{name: "local IRI", iri: "https://fedbox.local/objects/1", errFn: errors.IsBadRequest},
+		{name: "loopback address", iri: "http://127.0.0.1/objects/1", errFn: errors.IsForbidden},
+		{name: "host with a private address", iri: "http://internal.example/objects/1", errFn: errors.IsForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := f.Fetch(context.Background(), tt.iri); !tt.errFn(err) {
+				t.Errorf("Fetch(%s) returned unexpected error %v", tt.iri, err)
+			}
+			if _, ok := db[tt.iri]; ok {
+				t.Errorf("the invalid object %s shouldn't have been cached", tt.iri)
+			}
+		})
+	}
+
+	t.Run("remembers the failures", func(t *testing.T) {
+		clock := time.Now()
+		f.now = func() time.Time { return clock }
+		defer func() { f.now = time.Now }()
+
+		missing := remote.AddPath("objects/missing")
+		if _, err := f.Load(missing); !errors.IsNotFound(err) {
+			t.Fatalf("Load(%s) returned unexpected error %v", missing, err)
+		}
+		before := atomic.LoadInt32(&hits)
+		if _, err := f.Load(missing); !errors.IsNotFound(err) {
+			t.Errorf("Load(%s) returned unexpected error %v", missing, err)
+		}
+		if after := atomic.LoadInt32(&hits); after != before {
+			t.Errorf("loading the recently failed object made %d requests to the remote server", after-before)
+		}
+		clock = clock.Add(fetchFailureTTL + time.Second)
+		f.Load(missing)
+		if after := atomic.LoadInt32(&hits); after == before {
+			t.Errorf("the failed object should be retried after %s", fetchFailureTTL)
+		}
+	})
+	t.Run("stops fetching when the request is done", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx, cancel := context.WithCancel(r.Context())
+		l, done := f.forRequest(r.WithContext(ctx))
+		defer done()
+		cancel()
+
+		before := atomic.LoadInt32(&hits)
+		iri := remote.AddPath("objects/2")
+		if _, err := l.LoadIRI(iri); !errors.IsNotFound(err) {
+			t.Errorf("LoadIRI(%s) returned unexpected error %v", iri, err)
+		}
+		if after := atomic.LoadInt32(&hits); after != before {
+			t.Errorf("the loader of a finished request made %d requests to the remote server", after-before)
+		}
+		if f.recentlyFailed(iri) {
+			t.Errorf("the objects skipped for a finished request shouldn't be remembered as failed")
+		}
+	})
+
+	self := &vocab.Service{ID: "https://fedbox.local", Type: vocab.ServiceType}
+	for _, tt := range []struct {
+		name   string
+		by     vocab.Actor
+		status int
+	}{
+		{name: "anonymous", status: http.StatusUnauthorized},
+		{name: "not an administrator", by: vocab.Actor{ID: "https://fedbox.local/actors/johndoe"}, status: http.StatusForbidden},
+		{name: "administrator", by: *self, status: http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			removed := vocab.IRIs{}
+			onChange := func(iris ...vocab.IRI) bool {
+				removed = append(removed, iris...)
+				return true
+			}
+			delete(db, note.ID)
+			h := handleFetch(f, self, onChange, func(*http.Request) vocab.Actor { return tt.by })
+			r := httptest.NewRequest(http.MethodPost, "/admin/"+fetchPath+"?"+iriKey+"="+url.QueryEscape(note.ID.String()), nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("POST /admin/%s returned status %d, expected %d", fetchPath, w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if _, ok := db[note.ID]; !ok {
+				t.Errorf("the fetched object should have been cached in the storage")
+			}
+			if !removed.Contains(note.ID) {
+				t.Errorf("the fetched object should have been removed from the cache")
+			}
+		})
+	}
+}
+
+func TestRemoteFetcherRobotsExpire(t *testing.T) {
+	var robots int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == robotsPath {
+			if atomic.AddInt32(&robots, 1) == 1 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	clock := time.Now()
+	f := newRemoteFetcher(srv.Client(), mockStore{}, "https://fedbox.local", time.Second)
+	f.now = func() time.Time { return clock }
+	u, _ := url.Parse(srv.URL + "/private/1")
+
+	if !f.robotsAllowed(context.Background(), u) {
+		t.Errorf("the host whose robots.txt failed to load should allow everything")
+	}
+	if !f.robotsAllowed(context.Background(), u) || atomic.LoadInt32(&robots) != 1 {
+		t.Errorf("the failed robots.txt should be cached for %s", fetchFailureTTL)
+	}
+	clock = clock.Add(fetchFailureTTL + time.Second)
+	if f.robotsAllowed(context.Background(), u) {
+		t.Errorf("the robots.txt should be loaded again after the failure expired")
+	}
+	clock = clock.Add(robotsTTL - time.Minute)
+	f.robotsAllowed(context.Background(), u)
+	if cnt := atomic.LoadInt32(&robots); cnt != 2 {
+		t.Errorf("the robots.txt should be cached for %s, it was loaded %d times", robotsTTL, cnt)
+	}
+	clock = clock.Add(2 * time.Minute)
+	f.robotsAllowed(context.Background(), u)
+	if cnt := atomic.LoadInt32(&robots); cnt != 3 {
+		t.Errorf("the robots.txt should be loaded again after %s, it was loaded %d times", robotsTTL, cnt)
+	}
+}
@@ -0,0 +1,23 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/processing"
+)
+
+// isReplayed checks if the it activity has already been received in the inbox collection, which happens when
+// the remote servers deliver it again, as the federation delivery is done at least once.
+// The activity is looked up first by its IRI, so we load the inbox only for the activities we already have.
+func isReplayed(db processing.ReadStore, inbox vocab.IRI, it vocab.Item) bool {
+	if vocab.IsNil(it) || len(it.GetLink()) == 0 {
+		return false
+	}
+	iri := it.GetLink()
+	stored, err := db.Load(iri)
+	if stored = firstItem(stored); err != nil || vocab.IsNil(stored) || !stored.GetLink().Equals(iri, false) {
+		return false
+	}
+	// NOTE(marius): the same activity can be delivered to the inboxes of multiple local actors,
+	// so we check that it has been added to this one
+	return collectionContains(db, inbox, iri)
+}
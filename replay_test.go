@@ -0,0 +1,43 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestIsReplayed(t *testing.T) {
+	inbox := vocab.IRI("https://fedbox.local/actors/johndoe/inbox")
+	other := vocab.IRI("https://fedbox.local/actors/janedoe/inbox")
+	announce := &vocab.Activity{
+		ID:     "https://example.com/activities/announce",
+		Type:   vocab.AnnounceType,
+		Actor:  vocab.IRI("https://example.com/users/bob"),
+		Object: vocab.IRI("https://fedbox.local/objects/1"),
+	}
+	db := mockCollectionStore{mockStore: mockStore{}}
+	db.Create(&vocab.OrderedCollection{ID: inbox, Type: vocab.OrderedCollectionType})
+	db.Create(&vocab.OrderedCollection{ID: other, Type: vocab.OrderedCollectionType})
+
+	// NOTE(marius): receive mimics the processing of an activity received in an inbox
+	receive := func(inbox vocab.IRI, it vocab.Item) bool {
+		if isReplayed(db, inbox, it) {
+			return false
+		}
+		db.Save(it)
+		db.AddTo(inbox, it)
+		return true
+	}
+	if !receive(inbox, announce) {
+		t.Fatalf("the first delivery of %s has been considered a replay", announce.ID)
+	}
+	if receive(inbox, announce) {
+		t.Errorf("the second delivery of %s has been processed again", announce.ID)
+	}
+	if cnt := len(db.mockStore[inbox].(*vocab.OrderedCollection).OrderedItems); cnt != 1 {
+		t.Errorf("the inbox has %d entries, expected 1", cnt)
+	}
+	if !receive(other, announce) {
+		t.Errorf("the delivery of %s to another inbox has been considered a replay", announce.ID)
+	}
+}
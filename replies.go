@@ -0,0 +1,49 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// inReplyTo returns the IRIs of the items the it object is a reply to
+func inReplyTo(it vocab.Item) vocab.IRIs {
+	parents := make(vocab.IRIs, 0)
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		if vocab.IsNil(o.InReplyTo) {
+			return nil
+		}
+		if vocab.IsItemCollection(o.InReplyTo) {
+			return vocab.OnItemCollection(o.InReplyTo, func(col *vocab.ItemCollection) error {
+				for _, p := range col.Collection() {
+					parents = append(parents, p.GetLink())
+				}
+				return nil
+			})
+		}
+		parents = append(parents, o.InReplyTo.GetLink())
+		return nil
+	})
+	return parents
+}
+
+// unlinkReplies removes the object of the create activity from the replies collections of the objects
+// it is in reply to, which the activity processing populates. The reply itself is kept in storage.
+// It returns the IRIs of the replies collections that have been modified.
+func unlinkReplies(db processing.CollectionStore, create *vocab.Activity) (vocab.IRIs, error) {
+	if create == nil || create.GetType() != vocab.CreateType || vocab.IsNil(create.Object) {
+		return nil, nil
+	}
+	modified := make(vocab.IRIs, 0)
+	for _, parent := range inReplyTo(create.Object) {
+		replies := vocab.Replies.IRI(parent)
+		if err := db.RemoveFrom(replies, create.Object.GetLink()); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return modified, errors.Annotatef(err, "unable to remove %s from %s", create.Object.GetLink(), replies)
+		}
+		modified = append(modified, replies)
+	}
+	return modified, nil
+}
@@ -0,0 +1,56 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestUnlinkReplies(t *testing.T) {
+	parent := &vocab.Object{ID: "https://fedbox.local/objects/parent", Type: vocab.NoteType}
+	other := &vocab.Object{ID: "https://fedbox.local/objects/other", Type: vocab.NoteType}
+	reply := &vocab.Object{
+		ID:        "https://fedbox.local/objects/reply",
+		Type:      vocab.NoteType,
+		InReplyTo: vocab.ItemCollection{parent.ID, other.ID},
+	}
+	create := &vocab.Activity{
+		ID:     "https://fedbox.local/activities/create",
+		Type:   vocab.CreateType,
+		Actor:  vocab.IRI("https://fedbox.local/actors/johndoe"),
+		Object: reply,
+	}
+
+	db := mockCollectionStore{mockStore{}}
+	for _, it := range []vocab.Item{parent, other, reply, create} {
+		db.Save(it)
+	}
+	// NOTE(marius): this is what the activity processing does for replies
+	for _, p := range []vocab.Item{parent, other} {
+		db.AddTo(vocab.Replies.IRI(p), reply)
+	}
+
+	modified, err := unlinkReplies(db, create)
+	if err != nil {
+		t.Fatalf("unlinkReplies() returned error %s", err)
+	}
+	for _, p := range []vocab.Item{parent, other} {
+		replies := vocab.Replies.IRI(p)
+		if !modified.Contains(replies) {
+			t.Errorf("unlinkReplies() modified %v, missing %s", modified, replies)
+		}
+		if collectionContains(db, replies, reply.ID) {
+			t.Errorf("%s should not contain the reply %s", replies, reply.ID)
+		}
+	}
+	if _, ok := db.mockStore[reply.ID]; !ok {
+		t.Errorf("the reply %s should still be stored", reply.ID)
+	}
+
+	t.Run("not a reply", func(t *testing.T) {
+		create := &vocab.Activity{Type: vocab.CreateType, Object: parent}
+		if modified, err := unlinkReplies(db, create); err != nil || len(modified) > 0 {
+			t.Errorf("unlinkReplies() = %v, %v, expected nothing to be modified", modified, err)
+		}
+	})
+}
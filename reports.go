@@ -0,0 +1,236 @@
+package fedbox
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+const (
+	reportsPath         = "reports"
+	resolvedReportsPath = "reports-resolved"
+)
+
+// reports returns the IRI of the collection where we keep the open reports, the Flag activities received by the
+// instance. Like the pending registrations, it's not one of the collections we serve, so the reports are only
+// visible to the administrators.
+func reports(self vocab.Item) vocab.IRI {
+	return self.GetLink().AddPath(reportsPath)
+}
+
+// resolvedReports returns the IRI of the collection where we keep the reports that have been resolved
+func resolvedReports(self vocab.Item) vocab.IRI {
+	return self.GetLink().AddPath(resolvedReportsPath)
+}
+
+// reportedItems returns the IRIs of the objects and actors reported by the flag
+func reportedItems(flag *vocab.Activity) vocab.IRIs {
+	reported := make(vocab.IRIs, 0)
+	if vocab.IsNil(flag.Object) {
+		return reported
+	}
+	add := func(it vocab.Item) {
+		if !vocab.IsNil(it) && !reported.Contains(it.GetLink()) {
+			reported = append(reported, it.GetLink())
+		}
+	}
+	if vocab.IsItemCollection(flag.Object) {
+		vocab.OnItemCollection(flag.Object, func(col *vocab.ItemCollection) error {
+			for _, it := range *col {
+				add(it)
+			}
+			return nil
+		})
+	} else {
+		add(flag.Object)
+	}
+	return reported
+}
+
+// checkReceivedFlagID checks that the ID of the flag received in an inbox belongs to the server of its signer.
+// The reports are saved as they are received, so a local ID, or one from another server, would overwrite the
+// item stored with it.
+func checkReceivedFlagID(base vocab.IRI, flag *vocab.Activity, signer vocab.Item) error {
+	if len(flag.ID) == 0 {
+		return errors.BadRequestf("the Flag is missing its ID")
+	}
+	if flag.ID.Contains(base, false) {
+		return errors.Forbiddenf("the received Flag can't have the local ID %s", flag.ID)
+	}
+	if vocab.IsNil(signer) || !sameHost(flag.ID, signer.GetLink()) {
+		return errors.Forbiddenf("the Flag ID %s doesn't belong to the server of the signer", flag.ID)
+	}
+	return nil
+}
+
+// receiveFlag stores the flag in the reports collection of the self service, instead of delivering it to the
+// inboxes of the reported actors. The actor of the flag is the reporter, its content is the reason of the report,
+// and its object holds the reported objects and actors.
+// When notify is set, the flag is also added to the inbox of the self service, for notifying the administrators.
+// It returns the IRIs of the collections that have been modified.
+func receiveFlag(db collectionStore, self vocab.Item, flag *vocab.Activity, notify bool, now time.Time) (vocab.IRIs, error) {
+	if flag == nil || flag.GetType() != vocab.FlagType {
+		return nil, errors.BadRequestf("invalid Flag activity")
+	}
+	if vocab.IsNil(flag.Actor) {
+		return nil, errors.BadRequestf("the Flag is missing the reporting actor")
+	}
+	if len(reportedItems(flag)) == 0 {
+		return nil, errors.BadRequestf("the Flag is missing the reported objects")
+	}
+	if flag.Published.IsZero() {
+		flag.Published = now
+	}
+	if _, err := db.Save(flag); err != nil {
+		return nil, errors.Annotatef(err, "unable to save the report %s", flag.GetLink())
+	}
+	modified := make(vocab.IRIs, 0)
+	cols := vocab.IRIs{reports(self)}
+	if notify {
+		cols = append(cols, vocab.Inbox.IRI(self))
+	}
+	for _, col := range cols {
+		added, err := setMembership(db, col, flag.GetLink(), true)
+		if err != nil {
+			return modified, err
+		}
+		if added {
+			modified = append(modified, col)
+		}
+	}
+	return modified, nil
+}
+
+// receiveReport stores the Flag received in the typ collection, the outbox of a local actor, or an inbox, as a report
+func (f *FedBOX) receiveReport(repo processing.Store, typ vocab.CollectionPath, it vocab.Item, author vocab.Actor) (vocab.Item, int, error) {
+	db, ok := repo.(collectionStore)
+	if !ok {
+		err := errors.NotImplementedf("reports are not supported by the storage")
+		return it, errors.HttpStatus(err), err
+	}
+	status := http.StatusAccepted
+	var modified vocab.IRIs
+	err := vocab.OnActivity(it, func(flag *vocab.Activity) error {
+		if typ == vocab.Outbox {
+			if vocab.IsNil(flag.Actor) {
+				flag.Actor = author.GetLink()
+			}
+			if !flag.Actor.GetLink().Equals(author.GetLink(), false) {
+				return errors.Forbiddenf("the Flag must be sent by the authenticated actor")
+			}
+			// NOTE(marius): the reports don't go through the processing, which generates the IDs of the outbox
+			// activities, so we generate it here, as the one sent by the client could be the ID of any stored item
+			id, err := GenerateIDWith(vocab.IRI(f.Config().BaseURL), f.idGenerator)(flag, vocab.Outbox.IRI(author), author)
+			if err != nil {
+				return err
+			}
+			flag.ID = id
+			status = http.StatusCreated
+		} else if err := checkReceivedFlagID(vocab.IRI(f.Config().BaseURL), flag, author); err != nil {
+			return err
+		}
+		var err error
+		modified, err = receiveFlag(db, &f.self, flag, f.Config().NotifyReports, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		f.errFn("unable to store the report: %+s", err)
+		return it, errors.HttpStatus(err), err
+	}
+	if len(modified) > 0 {
+		f.caches.Remove(modified...)
+	}
+	f.infFn("stored report %s", it.GetLink())
+	return it, status, nil
+}
+
+// resolveReport moves the report with the iri from the open reports of the self service to the resolved ones
+func resolveReport(db collectionStore, self vocab.Item, iri vocab.IRI) (vocab.IRIs, error) {
+	open := reports(self)
+	if !collectionContains(db, open, iri) {
+		return nil, errors.NotFoundf("report %s not found", iri)
+	}
+	if _, err := setMembership(db, resolvedReports(self), iri, true); err != nil {
+		return nil, err
+	}
+	if _, err := setMembership(db, open, iri, false); err != nil {
+		return nil, err
+	}
+	return vocab.IRIs{open, resolvedReports(self)}, nil
+}
+
+// loadReports returns the Flag activities in the col reports collection
+func loadReports(db processing.ReadStore, col vocab.IRI) vocab.ItemCollection {
+	items := make(vocab.ItemCollection, 0)
+	loaded, err := db.Load(col)
+	if err != nil {
+		return items
+	}
+	vocab.OnCollectionIntf(loaded, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if vocab.IsIRI(it) {
+				if it, err = db.Load(it.GetLink()); err != nil {
+					continue
+				}
+				it = firstItem(it)
+			}
+			if !vocab.IsNil(it) && it.GetType() == vocab.FlagType {
+				items = append(items, it)
+			}
+		}
+		return nil
+	})
+	return items
+}
+
+func handleReports(db collectionStore, self vocab.Item, onChange func(...vocab.IRI) bool, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(actorFn(r), self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			col := reports(self)
+			if resolved, _ := strconv.ParseBool(r.URL.Query().Get("resolved")); resolved {
+				col = resolvedReports(self)
+			}
+			items := loadReports(db, col)
+			writeItem(w, r, http.StatusOK, &vocab.OrderedCollection{
+				ID:           col,
+				Type:         vocab.OrderedCollectionType,
+				OrderedItems: items,
+				TotalItems:   uint(len(items)),
+			})
+		case http.MethodPost:
+			iri := vocab.IRI(r.FormValue("iri"))
+			if len(iri) == 0 {
+				errors.HandleError(errors.BadRequestf("missing report IRI")).ServeHTTP(w, r)
+				return
+			}
+			modified, err := resolveReport(db, self, iri)
+			if err != nil {
+				errors.HandleError(err).ServeHTTP(w, r)
+				return
+			}
+			onChange(modified...)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			errors.HandleError(errors.MethodNotAllowedf("method not allowed")).ServeHTTP(w, r)
+		}
+	}
+}
+
+// HandleReports serves the administrative end-point which lists the reports received by the instance, or, with
+// the "resolved" parameter, the ones that have been resolved. The POST requests resolve the report with the "iri".
+func HandleReports(fb FedBOX) http.HandlerFunc {
+	db, ok := fb.storage.(collectionStore)
+	if !ok {
+		return errors.HandleError(errors.NotImplementedf("reports are not supported by the storage")).ServeHTTP
+	}
+	return handleReports(db, &fb.self, fb.caches.Remove, fb.actorFromRequest)
+}
@@ -0,0 +1,197 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+func TestReceiveFlag(t *testing.T) {
+	now := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	self := &vocab.Service{ID: "https://fedbox.local", Type: vocab.ServiceType}
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	mallory := vocab.IRI("https://evil.example.com/users/mallory")
+	spam := vocab.IRI("https://evil.example.com/objects/1")
+
+	setup := func() mockCollectionStore {
+		return mockCollectionStore{mockStore{
+			reports(self):            &vocab.OrderedCollection{ID: reports(self), Type: vocab.OrderedCollectionType},
+			resolvedReports(self):    &vocab.OrderedCollection{ID: resolvedReports(self), Type: vocab.OrderedCollectionType},
+			vocab.Inbox.IRI(self):    &vocab.OrderedCollection{ID: vocab.Inbox.IRI(self), Type: vocab.OrderedCollectionType},
+			vocab.Inbox.IRI(mallory): &vocab.OrderedCollection{ID: vocab.Inbox.IRI(mallory), Type: vocab.OrderedCollectionType},
+			vocab.Outbox.IRI(spam):   &vocab.OrderedCollection{ID: vocab.Outbox.IRI(spam), Type: vocab.OrderedCollectionType},
+		}}
+	}
+	newFlag := func() *vocab.Activity {
+		return &vocab.Activity{
+			ID:      "https://fedbox.local/activities/flag",
+			Type:    vocab.FlagType,
+			Actor:   johnDoe,
+			Object:  vocab.ItemCollection{mallory, spam},
+			Content: vocab.NaturalLanguageValues{{Ref: vocab.NilLangRef, Value: vocab.Content("spam")}},
+		}
+	}
+
+	t.Run("stored as report", func(t *testing.T) {
+		db := setup()
+		flag := newFlag()
+		modified, err := receiveFlag(db, self, flag, false, now)
+		if err != nil {
+			t.Fatalf("receiveFlag() returned error %s", err)
+		}
+		if !collectionContains(db, reports(self), flag.ID) {
+			t.Errorf("the Flag should be in the reports collection")
+		}
+		if collectionContains(db, vocab.Inbox.IRI(mallory), flag.ID) {
+			t.Errorf("the Flag shouldn't be in the inbox of the reported actor")
+		}
+		if collectionContains(db, vocab.Inbox.IRI(self), flag.ID) {
+			t.Errorf("the Flag shouldn't notify the administrators when not enabled")
+		}
+		if !modified.Contains(reports(self)) {
+			t.Errorf("the reports collection should be reported as modified, got %v", modified)
+		}
+		saved, ok := db.mockStore[flag.ID].(*vocab.Activity)
+		if !ok {
+			t.Fatalf("the Flag should have been saved")
+		}
+		if !saved.Actor.GetLink().Equals(johnDoe, false) || saved.Content.First().Value.String() != "spam" {
+			t.Errorf("the report should keep its reporter and reason, got %v: %s", saved.Actor, saved.Content)
+		}
+		if !saved.Published.Equal(now) {
+			t.Errorf("the published time of the report is %s, expected %s", saved.Published, now)
+		}
+	})
+	t.Run("notify administrators", func(t *testing.T) {
+		db := setup()
+		flag := newFlag()
+		if _, err := receiveFlag(db, self, flag, true, now); err != nil {
+			t.Fatalf("receiveFlag() returned error %s", err)
+		}
+		if !collectionContains(db, vocab.Inbox.IRI(self), flag.ID) {
+			t.Errorf("the Flag should be in the inbox of the service for notifying the administrators")
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		noObject := newFlag()
+		noObject.Object = nil
+		if _, err := receiveFlag(setup(), self, noObject, false, now); !errors.IsBadRequest(err) {
+			t.Errorf("a Flag without objects returned %v, expected a bad request error", err)
+		}
+		noActor := newFlag()
+		noActor.Actor = nil
+		if _, err := receiveFlag(setup(), self, noActor, false, now); !errors.IsBadRequest(err) {
+			t.Errorf("a Flag without an actor returned %v, expected a bad request error", err)
+		}
+	})
+	t.Run("list and resolve", func(t *testing.T) {
+		db := setup()
+		flag := newFlag()
+		if _, err := receiveFlag(db, self, flag, false, now); err != nil {
+			t.Fatalf("receiveFlag() returned error %s", err)
+		}
+		admin := func(r *http.Request) vocab.Actor { return *self }
+		onChange := func(...vocab.IRI) bool { return true }
+		h := handleReports(db, self, onChange, admin)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/"+reportsPath, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), flag.ID.String()) {
+			t.Fatalf("the reports should list the Flag, got %d: %s", w.Code, w.Body.String())
+		}
+
+		resolve := func(actorFn func(*http.Request) vocab.Actor, iri vocab.IRI) int {
+			req := httptest.NewRequest(http.MethodPost, "/admin/"+reportsPath+"?iri="+iri.String(), nil)
+			w := httptest.NewRecorder()
+			handleReports(db, self, onChange, actorFn).ServeHTTP(w, req)
+			return w.Code
+		}
+		if code := resolve(func(r *http.Request) vocab.Actor { return vocab.Actor{} }, flag.ID); code != http.StatusUnauthorized {
+			t.Errorf("resolving anonymously returned %d, expected %d", code, http.StatusUnauthorized)
+		}
+		if code := resolve(admin, flag.ID); code != http.StatusNoContent {
+			t.Fatalf("resolving the report returned %d, expected %d", code, http.StatusNoContent)
+		}
+		if collectionContains(db, reports(self), flag.ID) {
+			t.Errorf("the resolved report should not be open anymore")
+		}
+		if !collectionContains(db, resolvedReports(self), flag.ID) {
+			t.Errorf("the resolved report should be in the resolved collection")
+		}
+		if code := resolve(admin, flag.ID); code != http.StatusNotFound {
+			t.Errorf("resolving the report again returned %d, expected %d", code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestFedBOX_receiveReport(t *testing.T) {
+	self := vocab.Service{ID: "https://fedbox.local", Type: vocab.ServiceType}
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	mallory := &vocab.Actor{ID: "https://evil.example.com/users/mallory", Type: vocab.PersonType}
+	note := &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType, AttributedTo: johnDoe.ID}
+
+	gen, _ := ap.NewIDGenerator("uuid")
+	conf := config.Options{BaseURL: "https://fedbox.local"}
+	f := FedBOX{conf: conf, self: self, idGenerator: gen, caches: newRequestCache(conf, lw.Dev())}
+	setup := func() mockCollectionStore {
+		return mockCollectionStore{mockStore{
+			note.ID:               note,
+			reports(&self):        &vocab.OrderedCollection{ID: reports(&self), Type: vocab.OrderedCollectionType},
+			vocab.Inbox.IRI(self): &vocab.OrderedCollection{ID: vocab.Inbox.IRI(self), Type: vocab.OrderedCollectionType},
+		}}
+	}
+	newFlag := func(id vocab.IRI, actor vocab.Item) *vocab.Activity {
+		return &vocab.Activity{ID: id, Type: vocab.FlagType, Actor: actor.GetLink(), Object: johnDoe.ID}
+	}
+
+	t.Run("received with a local ID", func(t *testing.T) {
+		db := setup()
+		_, _, err := f.receiveReport(db, vocab.Inbox, newFlag(note.ID, mallory), *mallory)
+		if !errors.IsForbidden(err) {
+			t.Errorf("receiving a Flag with a local ID returned %v, expected a forbidden error", err)
+		}
+		if it := db.mockStore[note.ID]; it != note {
+			t.Errorf("the Flag with the local ID shouldn't replace the stored %s, got %v", note.ID, it)
+		}
+		if collectionContains(db, reports(&self), note.ID) {
+			t.Errorf("the Flag with the local ID shouldn't be stored as report")
+		}
+	})
+	t.Run("received with the ID of another server", func(t *testing.T) {
+		_, _, err := f.receiveReport(setup(), vocab.Inbox, newFlag("https://example.com/flags/1", mallory), *mallory)
+		if !errors.IsForbidden(err) {
+			t.Errorf("receiving a Flag with the ID of another server returned %v, expected a forbidden error", err)
+		}
+	})
+	t.Run("received from the server of the signer", func(t *testing.T) {
+		db := setup()
+		flag := newFlag("https://evil.example.com/flags/1", mallory)
+		if _, _, err := f.receiveReport(db, vocab.Inbox, flag, *mallory); err != nil {
+			t.Fatalf("receiveReport() returned error %s", err)
+		}
+		if !collectionContains(db, reports(&self), flag.ID) {
+			t.Errorf("the Flag should be in the reports collection")
+		}
+	})
+	t.Run("sent with a local ID", func(t *testing.T) {
+		db := setup()
+		flag := newFlag(note.ID, johnDoe)
+		if _, _, err := f.receiveReport(db, vocab.Outbox, flag, *johnDoe); err != nil {
+			t.Fatalf("receiveReport() returned error %s", err)
+		}
+		if flag.ID.Equals(note.ID, false) {
+			t.Errorf("the Flag sent to the outbox should get a new ID instead of %s", note.ID)
+		}
+		if it := db.mockStore[note.ID]; it != note {
+			t.Errorf("the Flag sent to the outbox shouldn't replace the stored %s, got %v", note.ID, it)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package fedbox
+
+import (
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+const iriKey = "iri"
+
+// resolveIRI returns the object identified by the iri. The local objects are loaded from the db storage,
+// and are returned only if the "by" actor is allowed to see them. The remote objects are loaded, only for
+// authorized actors, with the cl loader, which must check the remote hosts and the IDs of the fetched objects.
+func resolveIRI(db processing.ReadStore, cl iriLoader, base, iri vocab.IRI, by vocab.Actor) (vocab.Item, error) {
+	u, err := iri.URL()
+	if err != nil || !u.IsAbs() || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, errors.BadRequestf("invalid IRI %q", iri)
+	}
+	if iri.Contains(base, false) {
+		it, err := db.Load(iri)
+		if err != nil {
+			return nil, err
+		}
+		if it = firstItem(it); vocab.IsNil(it) || !isVisibleTo(it, by) {
+			return nil, errors.NotFoundf("%s not found", iri)
+		}
+		return it, nil
+	}
+
+	if isAnonymous(by) {
+		return nil, errors.Unauthorizedf("authorization required for resolving remote objects")
+	}
+	it, err := cl.LoadIRI(iri)
+	if err != nil {
+		return nil, errors.NewNotFound(err, "unable to load %s", iri)
+	}
+	if vocab.IsNil(it) {
+		return nil, errors.NotFoundf("%s not found", iri)
+	}
+	return it, nil
+}
+
+func handleResolve(db processing.ReadStore, f *remoteFetcher, base vocab.IRI, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		iri := vocab.IRI(r.URL.Query().Get(iriKey))
+		if len(iri) == 0 {
+			errors.HandleError(errors.BadRequestf("missing %q parameter", iriKey)).ServeHTTP(w, r)
+			return
+		}
+		cl, cancel := f.forRequest(r)
+		defer cancel()
+		it, err := resolveIRI(db, cl, base, iri, actorFn(r))
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if s, ok := it.(vocab.HasRecipients); ok {
+			s.Clean()
+		}
+		data, err := vocab.MarshalJSON(it)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// HandleResolve serves the end-point which returns the object identified by the "iri" query parameter, regardless
+// if it's local or remote, so the clients don't have to dereference the remote objects themselves.
+// The remote objects go through the remote fetcher, which refuses the private addresses and the objects
+// with a different ID than the one requested, and stores the ones it fetches.
+func HandleResolve(fb FedBOX) http.HandlerFunc {
+	return handleResolve(fb.storage, fb.remote, vocab.IRI(fb.Config().BaseURL), fb.actorFromRequest)
+}
@@ -0,0 +1,104 @@
+package fedbox
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+)
+
+func TestHandleResolve(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	public := &vocab.Object{ID: "https://fedbox.local/objects/public", Type: vocab.NoteType, To: vocab.ItemCollection{vocab.PublicNS}}
+	private := &vocab.Object{ID: "https://fedbox.local/objects/private", Type: vocab.NoteType, To: vocab.ItemCollection{johnDoe.ID}}
+
+	srv := httptest.NewUnstartedServer(nil)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	host := vocab.IRI("http://remote.example:" + port)
+	remote := &vocab.Object{ID: host.AddPath("objects/1"), Type: vocab.NoteType, To: vocab.ItemCollection{vocab.PublicNS}}
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ob := *remote
+		switch r.URL.Path {
+		case "/objects/1":
+		case "/objects/spoofed":
+			// NOTE(marius): the remote host claims to serve an object from another host
+			ob.ID = "https://fedbox.local/objects/public"
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		data, _ := vocab.MarshalJSON(&ob)
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.Write(data)
+	})
+	srv.Start()
+	defer srv.Close()
+
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	db := mockStore{}
+	db.Save(public)
+	db.Save(private)
+	f := newRemoteFetcher(&http.Client{Transport: tr}, db, base, 100*time.Millisecond)
+	f.lookupIP = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("203.0.113.1")}}, nil
+	}
+
+	tests := []struct {
+		name   string
+		iri    vocab.IRI
+		by     vocab.Actor
+		status int
+	}{
+		{name: "local public object", iri: public.ID, status: http.StatusOK},
+		{name: "local private object for anonymous", iri: private.ID, status: http.StatusNotFound},
+		{name: "local private object for recipient", iri: private.ID, by: johnDoe, status: http.StatusOK},
+		{name: "remote object for anonymous", iri: remote.ID, status: http.StatusUnauthorized},
+		{name: "remote object", iri: remote.ID, by: johnDoe, status: http.StatusOK},
+		{name: "missing remote object", iri: host.AddPath("objects/666"), by: johnDoe, status: http.StatusNotFound},
+		{name: "remote object with a different ID", iri: host.AddPath("objects/spoofed"), by: johnDoe, status: http.StatusNotFound},
+		{name: "remote object on a loopback address", iri: "http://127.0.0.1/objects/1", by: johnDoe, status: http.StatusNotFound},
+		{name: "invalid IRI", iri: "/objects/1", by: johnDoe, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := handleResolve(db, f, base, func(*http.Request) vocab.Actor { return tt.by })
+			r := httptest.NewRequest(http.MethodGet, "/resolve?"+iriKey+"="+url.QueryEscape(tt.iri.String()), nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("resolving %s returned status %d, expected %d", tt.iri, w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			it, err := vocab.UnmarshalJSON(w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("unable to unmarshal response: %s", err)
+			}
+			if !it.GetLink().Equals(tt.iri, false) {
+				t.Errorf("resolved %s, expected %s", it.GetLink(), tt.iri)
+			}
+		})
+	}
+
+	if cached, ok := db[remote.ID]; !ok || !cached.GetLink().Equals(remote.ID, false) {
+		t.Errorf("the remote object should have been stored")
+	}
+	if it, _ := db.Load("https://fedbox.local/objects/public"); !it.GetLink().Equals(public.ID, false) {
+		t.Errorf("the spoofed remote object should not have replaced the local one")
+	}
+	srv.Close()
+	if _, err := resolveIRI(db, f, base, remote.ID, johnDoe); err != nil {
+		t.Errorf("resolving a stored remote object returned error %s", err)
+	}
+}
@@ -11,14 +11,22 @@ import (
 func (f FedBOX) CollectionRoutes(descend bool) func(chi.Router) {
 	return func(r chi.Router) {
 		r.Group(func(r chi.Router) {
-			r.Method(http.MethodGet, "/", HandleCollection(f))
+			r.With(JSONLDFormat, ContentNegotiation(f)).Method(http.MethodGet, "/", HandleCollection(f))
 			r.Method(http.MethodHead, "/", HandleCollection(f))
-			r.Method(http.MethodPost, "/", HandleActivity(f))
+			r.With(MaxBodySize(f.conf.MaxInboxBody)).Method(http.MethodPost, "/", HandleActivity(f))
 
 			r.Route("/{id}", func(r chi.Router) {
 				r.Group(f.OAuthRoutes())
-				r.Method(http.MethodGet, "/", HandleItem(f))
+				r.With(ConditionalGet, JSONLDFormat, ContentNegotiation(f), FieldSelection, RedirectMovedActors(f)).Method(http.MethodGet, "/", HandleItem(f))
 				r.Method(http.MethodHead, "/", HandleItem(f))
+				r.Get("/"+countsPath, HandleInteractionCounts(f))
+				r.Get("/"+threadPath, HandleThread(f))
+				r.Get("/"+profileChangesPath, HandleProfileChanges(f))
+				r.Get("/"+featuredTagsPath, HandleFeaturedTags(f))
+				r.Put("/"+featuredTagsPath+"/{tag}", HandlePinTag(f, true))
+				r.Delete("/"+featuredTagsPath+"/{tag}", HandlePinTag(f, false))
+				r.Get("/"+followRequestsPath, HandleFollowRequests(f))
+				r.Post("/"+followRequestsPath+"/{action}", HandleFollowRequests(f))
 				if descend {
 					r.Route("/{collection}", f.CollectionRoutes(false))
 				}
@@ -27,24 +35,44 @@ func (f FedBOX) CollectionRoutes(descend bool) func(chi.Router) {
 	}
 }
 
-func SetCORSHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
 func (f FedBOX) Routes() func(chi.Router) {
 	return func(r chi.Router) {
-		r.Use(middleware.RealIP)
+		r.Use(FeedSuffix)
 		r.Use(CleanRequestPath)
-		r.Use(SetCORSHeaders)
+		r.Use(CORS(corsOrigins(f.conf)))
+		r.Use(RequestTimeout(f.conf.RequestTimeout, "/"+streamPath))
+
+		// NOTE: the liveness and readiness probes don't require authorization
+		r.Get("/healthz", HandleHealthz)
+		r.Get("/readyz", HandleReadyz(f))
+		r.Get("/metrics", HandleMetrics(f))
+
+		r.Get("/resolve", HandleResolve(f))
+		r.Get("/"+searchPath, HandleSearch(f))
+		r.Get("/"+streamPath, HandleStream(f))
+		r.Get("/"+activityPath+"/{hash}", HandleActivityByID(f))
+		r.Get(instanceActorPath, HandleInstanceActor(f))
+		r.Post("/"+uploadPath, HandleUpload(f))
+		r.Post("/"+registerPath, HandleRegister(f))
+		r.Get("/"+mediaPath+"/{file}", HandleMedia(f))
+		r.Head("/"+mediaPath+"/{file}", HandleMedia(f))
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Get("/resolve", HandleResolveHandle(f))
+			r.Post("/blocklist", HandleBlocklistImport(f))
+			r.Get("/maintenance", HandleReadOnly(f))
+			r.Get("/stats", HandleStats(f))
+			r.Post("/maintenance", HandleReadOnly(f))
+			r.Get("/"+registrationsPath, HandleRegistrations(f))
+			r.Post("/"+registrationsPath+"/{id}", HandleRegistrations(f))
+			r.Delete("/"+registrationsPath+"/{id}", HandleRegistrations(f))
+			r.Delete("/"+purgeActorPath, HandlePurgeActor(f))
+			r.Get("/"+reportsPath, HandleReports(f))
+			r.Post("/"+reportsPath, HandleReports(f))
+			r.Post("/"+fetchPath, HandleFetch(f))
+		})
 
-		r.Method(http.MethodGet, "/", HandleItem(f))
+		r.With(JSONLDFormat, ContentNegotiation(f), FieldSelection).Method(http.MethodGet, "/", HandleItem(f))
 		r.Method(http.MethodHead, "/", HandleItem(f))
 		// TODO(marius): we can separate here the FedBOX specific collections from the ActivityPub spec ones
 		// using some regular expressions
@@ -73,6 +101,10 @@ func (f *FedBOX) OAuthRoutes() func(router chi.Router) {
 			r.Post("/authorize", h.Authorize)
 			// Access token endpoint
 			r.Post("/token", h.Token)
+			// Token revocation endpoint
+			r.Post("/revoke", h.Revoke)
+			// Dynamic client registration endpoint
+			r.Post("/register", h.Register)
 
 			r.Group(func(r chi.Router) {
 				r.Get("/login", h.ShowLogin)
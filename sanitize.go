@@ -0,0 +1,183 @@
+package fedbox
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"golang.org/x/net/html"
+)
+
+// htmlPolicy is the allowlist of the HTML elements, and of their attributes, which are kept in the content,
+// summary and name of the items. The rest of the elements are removed, keeping their text.
+type htmlPolicy map[string][]string
+
+// defaultHTMLPolicy is modeled after the elements that Mastodon keeps in the remote content
+var defaultHTMLPolicy = htmlPolicy{
+	"p":          nil,
+	"br":         nil,
+	"span":       {"class"},
+	"a":          {"href", "rel", "class"},
+	"del":        nil,
+	"pre":        nil,
+	"code":       nil,
+	"em":         nil,
+	"strong":     nil,
+	"b":          nil,
+	"i":          nil,
+	"u":          nil,
+	"ul":         nil,
+	"ol":         {"start", "reversed"},
+	"li":         {"value"},
+	"blockquote": nil,
+}
+
+// htmlDropped are the elements which are removed together with their content
+var htmlDropped = []string{"script", "style", "iframe", "object", "embed", "template", "noscript", "textarea", "title"}
+
+// htmlURLAttrs are the attributes which contain URLs, for which we allow only the safe schemes
+var htmlURLAttrs = []string{"href", "src", "cite"}
+
+var htmlSafeSchemes = []string{"http", "https", "mailto"}
+
+// parseHTMLPolicy returns the policy from the allowed elements, which have the form "tag" or
+// "tag:attr1:attr2" for allowing some of their attributes, eg: "p", "a:href:rel".
+// When there are no allowed elements, it returns the default policy.
+func parseHTMLPolicy(allowed []string) htmlPolicy {
+	if len(allowed) == 0 {
+		return defaultHTMLPolicy
+	}
+	p := make(htmlPolicy, len(allowed))
+	for _, el := range allowed {
+		parts := strings.Split(strings.ToLower(strings.TrimSpace(el)), ":")
+		if len(parts[0]) == 0 {
+			continue
+		}
+		attrs := make([]string, 0, len(parts)-1)
+		for _, attr := range parts[1:] {
+			if attr = strings.TrimSpace(attr); len(attr) > 0 {
+				attrs = append(attrs, attr)
+			}
+		}
+		p[parts[0]] = attrs
+	}
+	return p
+}
+
+func stringsContain(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL checks if the u URL has one of the safe schemes
+func safeURL(u string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+	return stringsContain(htmlSafeSchemes, strings.ToLower(parsed.Scheme))
+}
+
+// writeTag writes the t start tag, with the attributes allowed by the p policy
+func (p htmlPolicy) writeTag(w *bytes.Buffer, t html.Token) {
+	allowed := p[t.Data]
+	w.WriteByte('<')
+	w.WriteString(t.Data)
+	for _, attr := range t.Attr {
+		name := strings.ToLower(attr.Key)
+		if len(attr.Namespace) > 0 || !stringsContain(allowed, name) {
+			continue
+		}
+		if stringsContain(htmlURLAttrs, name) && !safeURL(attr.Val) {
+			continue
+		}
+		w.WriteByte(' ')
+		w.WriteString(name)
+		w.WriteString(`="`)
+		w.WriteString(html.EscapeString(attr.Val))
+		w.WriteByte('"')
+	}
+	w.WriteByte('>')
+}
+
+// sanitize returns the s HTML keeping only the elements and the attributes allowed by the p policy.
+// The scripts, the styles and the embedded documents are removed together with their content,
+// and the links can have only the http, https and mailto schemes.
+func (p htmlPolicy) sanitize(s string) string {
+	w := bytes.Buffer{}
+	z := html.NewTokenizer(strings.NewReader(s))
+	dropping := ""
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			// NOTE(marius): the tokenizer doesn't fail on invalid HTML, so this is the end of the input
+			return w.String()
+		}
+		t := z.Token()
+		if len(dropping) > 0 {
+			if tt == html.EndTagToken && t.Data == dropping {
+				dropping = ""
+			}
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			w.WriteString(html.EscapeString(t.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if stringsContain(htmlDropped, t.Data) {
+				if tt == html.StartTagToken {
+					dropping = t.Data
+				}
+				continue
+			}
+			if _, ok := p[t.Data]; ok {
+				p.writeTag(&w, t)
+			}
+		case html.EndTagToken:
+			if _, ok := p[t.Data]; ok && t.Data != "br" {
+				w.WriteString("</" + t.Data + ">")
+			}
+		}
+	}
+}
+
+// sanitizeValues runs the values of all the languages through the p policy
+func (p htmlPolicy) sanitizeValues(nlv vocab.NaturalLanguageValues) {
+	for i, v := range nlv {
+		nlv[i].Value = vocab.Content(p.sanitize(string(v.Value)))
+	}
+}
+
+// sanitizeItem runs the content, summary and name of the it item through the p policy, and for activities,
+// the ones of their embedded objects.
+func sanitizeItem(p htmlPolicy, it vocab.Item) {
+	if vocab.IsNil(it) || vocab.IsIRI(it) {
+		return
+	}
+	if vocab.IsItemCollection(it) {
+		vocab.OnItemCollection(it, func(col *vocab.ItemCollection) error {
+			for _, it := range *col {
+				sanitizeItem(p, it)
+			}
+			return nil
+		})
+		return
+	}
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		p.sanitizeValues(o.Content)
+		p.sanitizeValues(o.Summary)
+		p.sanitizeValues(o.Name)
+		return nil
+	})
+	if vocab.ActivityTypes.Contains(it.GetType()) {
+		vocab.OnActivity(it, func(a *vocab.Activity) error {
+			sanitizeItem(p, a.Object)
+			return nil
+		})
+	}
+}
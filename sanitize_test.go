@@ -0,0 +1,137 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestHTMLPolicySanitize(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy htmlPolicy
+		html   string
+		want   string
+	}{
+		{
+			name:   "plain text",
+			policy: defaultHTMLPolicy,
+			html:   "hello world",
+			want:   "hello world",
+		},
+		{
+			name:   "script",
+			policy: defaultHTMLPolicy,
+			html:   `<p>hello<script>alert("pwned")</script> world</p>`,
+			want:   `<p>hello world</p>`,
+		},
+		{
+			name:   "link",
+			policy: defaultHTMLPolicy,
+			html:   `<a href="https://example.com/@bob" rel="nofollow noopener" class="u-url mention" target="_blank">@bob</a>`,
+			want:   `<a href="https://example.com/@bob" rel="nofollow noopener" class="u-url mention">@bob</a>`,
+		},
+		{
+			name:   "event handlers",
+			policy: defaultHTMLPolicy,
+			html:   `<p onclick="alert(1)">hello <span class="h-card" onmouseover="alert(2)">bob</span></p>`,
+			want:   `<p>hello <span class="h-card">bob</span></p>`,
+		},
+		{
+			name:   "javascript link",
+			policy: defaultHTMLPolicy,
+			html:   `<a href="javascript:alert(1)">click</a>`,
+			want:   `<a>click</a>`,
+		},
+		{
+			name:   "disallowed elements keep their text",
+			policy: defaultHTMLPolicy,
+			html:   `<div><img src="https://example.com/a.png" onerror="alert(1)"/>hello <h1>world</h1></div>`,
+			want:   `hello world`,
+		},
+		{
+			name:   "formatting",
+			policy: defaultHTMLPolicy,
+			html:   `<p>line<br/>next <strong>bold</strong> <em>&amp; &lt;em&gt;</em></p><style>p{}</style>`,
+			want:   `<p>line<br>next <strong>bold</strong> <em>&amp; &lt;em&gt;</em></p>`,
+		},
+		{
+			name:   "custom policy",
+			policy: parseHTMLPolicy([]string{"p", "a:href"}),
+			html:   `<p><a href="https://example.com" rel="nofollow">link</a> <em>text</em></p>`,
+			want:   `<p><a href="https://example.com">link</a> text</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.sanitize(tt.html); got != tt.want {
+				t.Errorf("sanitize() = %q, expected %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHTMLPolicy(t *testing.T) {
+	if p := parseHTMLPolicy(nil); len(p) != len(defaultHTMLPolicy) {
+		t.Errorf("parseHTMLPolicy() without elements should return the default policy, got %v", p)
+	}
+	p := parseHTMLPolicy([]string{" P ", "a:href:rel", "", "span:"})
+	want := htmlPolicy{"p": {}, "a": {"href", "rel"}, "span": {}}
+	if len(p) != len(want) {
+		t.Fatalf("parseHTMLPolicy() = %v, expected %v", p, want)
+	}
+	for tag, attrs := range want {
+		got, ok := p[tag]
+		if !ok || len(got) != len(attrs) {
+			t.Errorf("parseHTMLPolicy() allowed %s%v, expected %s%v", tag, got, tag, attrs)
+			continue
+		}
+		for i := range attrs {
+			if got[i] != attrs[i] {
+				t.Errorf("parseHTMLPolicy() allowed %s%v, expected %s%v", tag, got, tag, attrs)
+			}
+		}
+	}
+}
+
+func TestSanitizeItem(t *testing.T) {
+	note := &vocab.Object{
+		ID:      "https://example.com/objects/1",
+		Type:    vocab.NoteType,
+		Name:    vocab.NaturalLanguageValues{{Ref: vocab.NilLangRef, Value: vocab.Content(`<b>title</b><script>x</script>`)}},
+		Summary: vocab.NaturalLanguageValues{{Ref: vocab.NilLangRef, Value: vocab.Content(`<p onclick="x()">cw</p>`)}},
+		Content: vocab.NaturalLanguageValues{
+			{Ref: "en", Value: vocab.Content(`<p>hi <a href="https://example.com">there</a><script>alert(1)</script></p>`)},
+			{Ref: "fr", Value: vocab.Content(`<p>salut<iframe src="https://evil.example.com"></iframe></p>`)},
+		},
+	}
+	create := &vocab.Activity{
+		ID:      "https://example.com/activities/1",
+		Type:    vocab.CreateType,
+		Actor:   vocab.IRI("https://example.com/users/bob"),
+		Summary: vocab.NaturalLanguageValues{{Ref: vocab.NilLangRef, Value: vocab.Content(`<script>x</script>bob created a note`)}},
+		Object:  note,
+	}
+
+	sanitizeItem(defaultHTMLPolicy, create)
+
+	want := map[string]string{
+		"activity summary": "bob created a note",
+		"name":             "<b>title</b>",
+		"summary":          "<p>cw</p>",
+		"content en":       `<p>hi <a href="https://example.com">there</a></p>`,
+		"content fr":       "<p>salut</p>",
+	}
+	got := map[string]string{
+		"activity summary": create.Summary.First().Value.String(),
+		"name":             note.Name.First().Value.String(),
+		"summary":          note.Summary.First().Value.String(),
+		"content en":       note.Content.Get("en").String(),
+		"content fr":       note.Content.Get("fr").String(),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("sanitized %s = %q, expected %q", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package fedbox
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+)
+
+const (
+	searchPath = "search"
+	searchKey  = "q"
+)
+
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+
+// searchIndex is the inverted index of the tokens in the name, summary and content of the local objects
+type searchIndex struct {
+	base   vocab.IRI
+	m      sync.RWMutex
+	tokens map[string]map[vocab.IRI]struct{}
+	// objects maps the indexed objects to their tokens, so we can remove them from the index
+	objects map[vocab.IRI][]string
+}
+
+func newSearchIndex(base vocab.IRI) *searchIndex {
+	return &searchIndex{
+		base:    base,
+		tokens:  make(map[string]map[vocab.IRI]struct{}),
+		objects: make(map[vocab.IRI][]string),
+	}
+}
+
+// tokenize returns the lower case words of the s text, ignoring the HTML markup
+func tokenize(s string) []string {
+	words := strings.FieldsFunc(htmlTags.ReplaceAllString(s, " "), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		tokens = append(tokens, strings.ToLower(w))
+	}
+	return tokens
+}
+
+// objectTokens returns the distinct tokens of the name, summary and content of the it object, in all the languages
+func objectTokens(it vocab.Item) []string {
+	seen := make(map[string]struct{})
+	tokens := make([]string, 0)
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		for _, nlv := range []vocab.NaturalLanguageValues{o.Name, o.Summary, o.Content} {
+			for _, v := range nlv {
+				for _, tok := range tokenize(v.Value.String()) {
+					if _, ok := seen[tok]; !ok {
+						seen[tok] = struct{}{}
+						tokens = append(tokens, tok)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return tokens
+}
+
+// index adds the it object to the index, replacing its previous tokens. Only the local objects are indexed.
+func (s *searchIndex) index(it vocab.Item) {
+	if s == nil || vocab.IsNil(it) || !vocab.ObjectTypes.Contains(it.GetType()) {
+		return
+	}
+	iri := it.GetLink()
+	if !iri.Contains(s.base, false) {
+		return
+	}
+	tokens := objectTokens(it)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.remove(iri)
+	for _, tok := range tokens {
+		if s.tokens[tok] == nil {
+			s.tokens[tok] = make(map[vocab.IRI]struct{})
+		}
+		s.tokens[tok][iri] = struct{}{}
+	}
+	s.objects[iri] = tokens
+}
+
+// delete removes the iri object from the index
+func (s *searchIndex) delete(iri vocab.IRI) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.remove(iri)
+}
+
+// remove deletes the tokens of the iri object, it must be called with the write lock held
+func (s *searchIndex) remove(iri vocab.IRI) {
+	for _, tok := range s.objects[iri] {
+		delete(s.tokens[tok], iri)
+		if len(s.tokens[tok]) == 0 {
+			delete(s.tokens, tok)
+		}
+	}
+	delete(s.objects, iri)
+}
+
+// search returns the IRIs of the objects which contain all the words of the q query
+func (s *searchIndex) search(q string) vocab.IRIs {
+	result := make(vocab.IRIs, 0)
+	tokens := tokenize(q)
+	if s == nil || len(tokens) == 0 {
+		return result
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for iri := range s.tokens[tokens[0]] {
+		found := true
+		for _, tok := range tokens[1:] {
+			if _, ok := s.tokens[tok][iri]; !ok {
+				found = false
+				break
+			}
+		}
+		if found {
+			result = append(result, iri)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	return result
+}
+
+// update changes the index with the effects of the a activity: the objects which are created or updated are indexed
+// again, and the deleted ones are removed.
+func (s *searchIndex) update(db processing.ReadStore, a *vocab.Activity) {
+	if s == nil || a == nil || vocab.IsNil(a.Object) {
+		return
+	}
+	switch a.GetType() {
+	case vocab.CreateType, vocab.UpdateType:
+		// NOTE: we load the stored object, as an Update can contain only the changed properties
+		if it, err := db.Load(a.Object.GetLink()); err == nil {
+			s.index(firstItem(it))
+		}
+	case vocab.DeleteType:
+		s.delete(a.Object.GetLink())
+	}
+}
+
+// build indexes the objects in the objects collection of the base service
+func (s *searchIndex) build(db processing.ReadStore) error {
+	if s == nil {
+		return nil
+	}
+	objects, err := db.Load(filters.ObjectsType.IRI(s.base))
+	if err != nil {
+		return err
+	}
+	return vocab.OnCollectionIntf(objects, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if vocab.IsIRI(it) {
+				if it, err = db.Load(it.GetLink()); err != nil {
+					continue
+				}
+				it = firstItem(it)
+			}
+			s.index(it)
+		}
+		return nil
+	})
+}
+
+func handleSearch(idx *searchIndex, base vocab.IRI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get(searchKey)
+		if strings.TrimSpace(q) == "" {
+			errors.HandleError(errors.BadRequestf("missing the %q search parameter", searchKey)).ServeHTTP(w, r)
+			return
+		}
+		iris := idx.search(q)
+		items := make(vocab.ItemCollection, 0, len(iris))
+		for _, iri := range iris {
+			items = append(items, iri)
+		}
+		col := vocab.OrderedCollection{
+			ID:           vocab.IRI(base.String() + "/" + searchPath + "?" + r.URL.RawQuery),
+			Type:         vocab.OrderedCollectionType,
+			OrderedItems: items,
+			TotalItems:   uint(len(items)),
+		}
+		data, err := vocab.MarshalJSON(&col)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
+// HandleSearch serves the local objects whose name, summary or content match all the words of the "q" parameter
+func HandleSearch(fb FedBOX) http.HandlerFunc {
+	return handleSearch(fb.search, vocab.IRI(fb.Config().BaseURL))
+}
+
+// indexObjects builds the search index from the objects in the storage
+func (f *FedBOX) indexObjects() {
+	if err := f.search.build(f.storage); err != nil {
+		f.errFn("unable to build the search index: %+s", err)
+	}
+}
@@ -0,0 +1,105 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/filters"
+)
+
+func TestSearchIndex(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	note := func(id, name, content string) *vocab.Object {
+		return &vocab.Object{
+			ID:      vocab.IRI(id),
+			Type:    vocab.NoteType,
+			Name:    vocab.DefaultNaturalLanguageValue(name),
+			Content: vocab.DefaultNaturalLanguageValue(content),
+		}
+	}
+	cats := note("https://fedbox.local/objects/1", "Cats", "<p>My <b>cat</b> likes the garden</p>")
+	garden := note("https://fedbox.local/objects/2", "", "Spring in the GARDEN")
+	dogs := note("https://fedbox.local/objects/3", "Dogs", "The dog likes walks")
+	remote := note("https://example.com/objects/1", "", "A remote garden")
+
+	objects := filters.ObjectsType.IRI(base)
+	db := mockCollectionStore{mockStore{}}
+	db.Create(&vocab.OrderedCollection{ID: objects, Type: vocab.OrderedCollectionType})
+	for _, ob := range []*vocab.Object{cats, garden, dogs, remote} {
+		db.Save(ob)
+		db.AddTo(objects, ob)
+	}
+
+	idx := newSearchIndex(base)
+	if err := idx.build(db); err != nil {
+		t.Fatalf("build() returned error %s", err)
+	}
+
+	tests := []struct {
+		q    string
+		want vocab.IRIs
+	}{
+		{q: "garden", want: vocab.IRIs{cats.ID, garden.ID}},
+		{q: "Garden likes", want: vocab.IRIs{cats.ID}},
+		{q: "likes", want: vocab.IRIs{cats.ID, dogs.ID}},
+		{q: "cats", want: vocab.IRIs{cats.ID}},
+		{q: "p", want: vocab.IRIs{}},
+		{q: "remote", want: vocab.IRIs{}},
+		{q: "", want: vocab.IRIs{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			got := idx.search(tt.q)
+			if len(got) != len(tt.want) {
+				t.Fatalf("search(%q) = %v, expected %v", tt.q, got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("search(%q) = %v, expected %v", tt.q, got, tt.want)
+				}
+			}
+		})
+	}
+
+	updated := note(garden.ID.String(), "", "Autumn leaves")
+	db.Save(updated)
+	idx.update(db, &vocab.Activity{Type: vocab.UpdateType, Object: updated.ID})
+	if got := idx.search("garden"); len(got) != 1 || got[0] != cats.ID {
+		t.Errorf("search() after an Update = %v, expected only %s", got, cats.ID)
+	}
+	idx.update(db, &vocab.Activity{Type: vocab.DeleteType, Object: cats.ID})
+	if got := idx.search("garden"); len(got) != 0 {
+		t.Errorf("search() after a Delete = %v, expected no results", got)
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	idx := newSearchIndex(base)
+	idx.index(&vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguageValue("hello world")})
+	idx.index(&vocab.Object{ID: "https://fedbox.local/objects/2", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguageValue("goodbye world")})
+
+	w := httptest.NewRecorder()
+	handleSearch(idx, base)(w, httptest.NewRequest(http.MethodGet, "/search?q=hello", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handleSearch() status %d, expected %d", w.Code, http.StatusOK)
+	}
+	it, err := vocab.UnmarshalJSON(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("unable to unmarshal the response: %s", err)
+	}
+	vocab.OnOrderedCollection(it, func(col *vocab.OrderedCollection) error {
+		if col.TotalItems != 1 || !col.OrderedItems.Contains(vocab.IRI("https://fedbox.local/objects/1")) {
+			t.Errorf("handleSearch() returned %v, expected only the first object", col.OrderedItems)
+		}
+		return nil
+	})
+
+	w = httptest.NewRecorder()
+	handleSearch(idx, base)(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("handleSearch() without a query status %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,42 @@
+package fedbox
+
+import (
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// isSelfInbox checks if the iri collection is the inbox of the self service actor
+func isSelfInbox(iri vocab.IRI, self vocab.Item) bool {
+	return !vocab.IsNil(self) && iri.Equals(vocab.Inbox.IRI(self), false)
+}
+
+// selfInboxActivity returns the activity in the body of the r request posted to the service's inbox, which we accept
+// only from federation peers: the request must have an HTTP signature from the signer actor, which must be the
+// actor of the activity.
+func selfInboxActivity(r *http.Request, body []byte, signer vocab.Actor) (vocab.Item, error) {
+	if r.Header.Get("Signature") == "" || isAnonymous(signer) {
+		return nil, errors.Forbiddenf("the service's inbox accepts only signed federation requests")
+	}
+	it, err := vocab.UnmarshalJSON(body)
+	if err != nil || vocab.IsNil(it) {
+		return nil, errors.NewBadRequest(err, "unable to unmarshal the activity")
+	}
+	if typ := it.GetType(); !vocab.ActivityTypes.Contains(typ) && !vocab.IntransitiveActivityTypes.Contains(typ) {
+		return nil, errors.BadRequestf("invalid activity type %q", typ)
+	}
+	err = vocab.OnIntransitiveActivity(it, func(a *vocab.IntransitiveActivity) error {
+		if vocab.IsNil(a.Actor) {
+			return errors.BadRequestf("missing actor for activity")
+		}
+		if !a.Actor.GetLink().Equals(signer.ID, false) {
+			return errors.Forbiddenf("the activity's actor %s is not the signer of the request", a.Actor.GetLink())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
@@ -0,0 +1,71 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestIsSelfInbox(t *testing.T) {
+	self := &vocab.Service{ID: "https://fedbox.local/", Type: vocab.ServiceType}
+	if !isSelfInbox("https://fedbox.local/inbox", self) {
+		t.Errorf("isSelfInbox() = false for the service's inbox")
+	}
+	if isSelfInbox("https://fedbox.local/actors/johndoe/inbox", self) {
+		t.Errorf("isSelfInbox() = true for an actor's inbox")
+	}
+}
+
+func TestSelfInboxActivity(t *testing.T) {
+	remote := vocab.Actor{ID: "https://example.com/users/bob", Type: vocab.PersonType}
+	follow := `{"id":"https://example.com/activities/1","type":"Follow","actor":"https://example.com/users/bob","object":"https://fedbox.local/"}`
+
+	tests := []struct {
+		name   string
+		body   string
+		signed bool
+		signer vocab.Actor
+		status int
+	}{
+		{name: "signed activity", body: follow, signed: true, signer: remote},
+		{name: "unsigned activity", body: follow, signer: remote, status: http.StatusForbidden},
+		{name: "anonymous signer", body: follow, signed: true, status: http.StatusForbidden},
+		{name: "malformed body", body: `{"type":"Follow",`, signed: true, signer: remote, status: http.StatusBadRequest},
+		{name: "not an activity", body: `{"id":"https://example.com/notes/1","type":"Note","content":"spam"}`, signed: true, signer: remote, status: http.StatusBadRequest},
+		{
+			name:   "activity of another actor",
+			body:   strings.Replace(follow, `"actor":"https://example.com/users/bob"`, `"actor":"https://example.com/users/alice"`, 1),
+			signed: true,
+			signer: remote,
+			status: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/inbox", strings.NewReader(tt.body))
+			if tt.signed {
+				r.Header.Set("Signature", `keyId="https://example.com/users/bob#main-key",signature="..."`)
+			}
+			it, err := selfInboxActivity(r, []byte(tt.body), tt.signer)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("selfInboxActivity() returned error %s", err)
+				}
+				if it.GetType() != vocab.FollowType {
+					t.Errorf("selfInboxActivity() returned %s, expected %s", it.GetType(), vocab.FollowType)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("selfInboxActivity() expected error")
+			}
+			if status := errors.HttpStatus(err); status != tt.status {
+				t.Errorf("selfInboxActivity() error status %d, expected %d: %s", status, tt.status, err)
+			}
+		})
+	}
+}
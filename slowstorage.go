@@ -0,0 +1,139 @@
+package fedbox
+
+import (
+	"crypto"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+)
+
+// slowStorage logs the storage operations which take longer than the threshold, together with the IRI they
+// operate on and their duration.
+//
+// NOTE(marius): the boltdb transactions are run by the storage-boltdb package, so we time the operations of
+// the storage, which includes the encoding and decoding of the items, not the individual transactions.
+type slowStorage struct {
+	FullStorage
+	threshold time.Duration
+	logFn     func(lw.Ctx, string, ...interface{})
+	now       func() time.Time
+}
+
+// withSlowLog returns the db storage logging with logFn the operations taking longer than threshold.
+// When the threshold is zero the db storage is returned unchanged.
+func withSlowLog(db FullStorage, threshold time.Duration, logFn func(lw.Ctx, string, ...interface{})) FullStorage {
+	if threshold <= 0 || logFn == nil {
+		return db
+	}
+	return slowStorage{FullStorage: db, threshold: threshold, logFn: logFn, now: time.Now}
+}
+
+// timed returns the function which logs the op operation on the iri, if it took longer than the threshold
+func (s slowStorage) timed(op string, iri vocab.IRI) func() {
+	start := s.now()
+	return func() {
+		if d := s.now().Sub(start); d >= s.threshold {
+			s.logFn(lw.Ctx{"iri": iri.String(), "duration": d.String()}, "slow storage %s operation", op)
+		}
+	}
+}
+
+func (s slowStorage) Load(iri vocab.IRI) (vocab.Item, error) {
+	defer s.timed("load", iri)()
+	return s.FullStorage.Load(iri)
+}
+
+func (s slowStorage) Save(it vocab.Item) (vocab.Item, error) {
+	if !vocab.IsNil(it) {
+		defer s.timed("save", it.GetLink())()
+	}
+	return s.FullStorage.Save(it)
+}
+
+func (s slowStorage) Delete(it vocab.Item) error {
+	if !vocab.IsNil(it) {
+		defer s.timed("delete", it.GetLink())()
+	}
+	return s.FullStorage.Delete(it)
+}
+
+func (s slowStorage) collections() (processing.CollectionStore, error) {
+	cs, ok := s.FullStorage.(processing.CollectionStore)
+	if !ok {
+		return nil, errors.NotImplementedf("collections are not supported by the %T storage", s.FullStorage)
+	}
+	return cs, nil
+}
+
+func (s slowStorage) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	cs, err := s.collections()
+	if err != nil {
+		return nil, err
+	}
+	defer s.timed("create collection", col.GetLink())()
+	return cs.Create(col)
+}
+
+func (s slowStorage) AddTo(col vocab.IRI, it vocab.Item) error {
+	cs, err := s.collections()
+	if err != nil {
+		return err
+	}
+	defer s.timed("add to collection", col)()
+	return cs.AddTo(col, it)
+}
+
+func (s slowStorage) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	cs, err := s.collections()
+	if err != nil {
+		return err
+	}
+	defer s.timed("remove from collection", col)()
+	return cs.RemoveFrom(col, it)
+}
+
+func (s slowStorage) LoadMetadata(iri vocab.IRI) (*processing.Metadata, error) {
+	m, ok := s.FullStorage.(st.MetadataTyper)
+	if !ok {
+		return nil, errors.NotImplementedf("metadata is not supported by the %T storage", s.FullStorage)
+	}
+	defer s.timed("load metadata", iri)()
+	return m.LoadMetadata(iri)
+}
+
+func (s slowStorage) SaveMetadata(meta processing.Metadata, iri vocab.IRI) error {
+	m, ok := s.FullStorage.(st.MetadataTyper)
+	if !ok {
+		return errors.NotImplementedf("metadata is not supported by the %T storage", s.FullStorage)
+	}
+	defer s.timed("save metadata", iri)()
+	return m.SaveMetadata(meta, iri)
+}
+
+func (s slowStorage) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
+	k, ok := s.FullStorage.(processing.KeyLoader)
+	if !ok {
+		return nil, errors.NotImplementedf("keys are not supported by the %T storage", s.FullStorage)
+	}
+	defer s.timed("load key", iri)()
+	return k.LoadKey(iri)
+}
+
+func (s slowStorage) CollectionContains(col vocab.IRI, member vocab.IRI) (bool, error) {
+	defer s.timed("collection membership check", col)()
+	return st.CollectionContains(s.FullStorage, col, member)
+}
+
+func (s slowStorage) CountItems(col vocab.IRI) (uint, error) {
+	defer s.timed("count items", col)()
+	// NOTE(marius): for the backends which can't count the items, the callers fall back to loading the collection
+	return st.CountItems(s.FullStorage, col)
+}
+
+func (s slowStorage) IsLocalIRI(iri vocab.IRI) bool {
+	return st.IsLocalIRI(s.FullStorage)(iri)
+}
@@ -0,0 +1,79 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+)
+
+// slowStore advances the clock by the delay of the IRIs it loads
+type slowStore struct {
+	mockFullStorage
+	clock  *time.Time
+	delays map[vocab.IRI]time.Duration
+}
+
+func (s slowStore) Load(iri vocab.IRI) (vocab.Item, error) {
+	*s.clock = s.clock.Add(s.delays[iri])
+	return s.mockFullStorage.Load(iri)
+}
+
+func (s slowStore) AddTo(col vocab.IRI, it vocab.Item) error {
+	*s.clock = s.clock.Add(s.delays[col])
+	return s.mockFullStorage.AddTo(col, it)
+}
+
+func TestSlowStorage(t *testing.T) {
+	clock := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	fast := vocab.IRI("https://fedbox.local/actors/johndoe")
+	slow := vocab.IRI("https://fedbox.local/actors/janedoe")
+	outbox := vocab.Outbox.IRI(slow)
+
+	mock := mockFullStorage{mockCollectionStore: mockCollectionStore{mockStore{
+		fast: &vocab.Actor{ID: fast, Type: vocab.PersonType},
+		slow: &vocab.Actor{ID: slow, Type: vocab.PersonType},
+	}}}
+	store := slowStore{
+		mockFullStorage: mock,
+		clock:           &clock,
+		delays:          map[vocab.IRI]time.Duration{fast: time.Millisecond, slow: time.Second, outbox: 2 * time.Second},
+	}
+
+	type logged struct {
+		ctx lw.Ctx
+		msg string
+	}
+	logs := make([]logged, 0)
+	logFn := func(ctx lw.Ctx, s string, _ ...interface{}) {
+		logs = append(logs, logged{ctx: ctx, msg: s})
+	}
+	if _, ok := withSlowLog(store, 0, logFn).(slowStorage); ok {
+		t.Errorf("withSlowLog() without a threshold should return the storage unchanged")
+	}
+	db := withSlowLog(store, 500*time.Millisecond, logFn).(slowStorage)
+	db.now = func() time.Time { return clock }
+
+	if _, err := db.Load(fast); err != nil {
+		t.Fatalf("Load() returned error %s", err)
+	}
+	if len(logs) != 0 {
+		t.Errorf("the fast load shouldn't be logged: %v", logs)
+	}
+	if _, err := db.Load(slow); err != nil {
+		t.Fatalf("Load() returned error %s", err)
+	}
+	if err := db.AddTo(outbox, fast); err != nil {
+		t.Fatalf("AddTo() returned error %s", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("the slow operations should be logged, got %d logs: %v", len(logs), logs)
+	}
+	if logs[0].ctx["iri"] != slow.String() || logs[0].ctx["duration"] != time.Second.String() {
+		t.Errorf("the slow load should be logged with its IRI and duration, got %v", logs[0].ctx)
+	}
+	if logs[1].ctx["iri"] != outbox.String() || logs[1].ctx["duration"] != (2*time.Second).String() {
+		t.Errorf("the slow add to collection should be logged with its IRI and duration, got %v", logs[1].ctx)
+	}
+}
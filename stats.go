@@ -0,0 +1,95 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/cache"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+)
+
+// cacheStats is implemented by the caches which count their lookups
+type cacheStats interface {
+	Stats() (hits uint64, misses uint64)
+}
+
+// instanceStats is the summary of the state of a running instance, used for debugging
+type instanceStats struct {
+	Actors      uint   `json:"actors"`
+	Activities  uint   `json:"activities"`
+	Objects     uint   `json:"objects"`
+	CacheHits   uint64 `json:"cacheHits"`
+	CacheMisses uint64 `json:"cacheMisses"`
+	Goroutines  int    `json:"goroutines"`
+	HeapAlloc   uint64 `json:"heapAlloc"`
+	HeapObjects uint64 `json:"heapObjects"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"numGC"`
+}
+
+// collectStats gathers the number of items in the storage collections of the self service, the lookups
+// of the c cache, and the runtime statistics.
+func collectStats(db processing.ReadStore, self vocab.IRI, c cache.CanStore) instanceStats {
+	s := instanceStats{Goroutines: runtime.NumGoroutine()}
+	s.Actors, _ = countItems(db, filters.ActorsType.IRI(self))
+	s.Activities, _ = countItems(db, filters.ActivitiesType.IRI(self))
+	s.Objects, _ = countItems(db, filters.ObjectsType.IRI(self))
+	if cs, ok := c.(cacheStats); ok {
+		s.CacheHits, s.CacheMisses = cs.Stats()
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.HeapAlloc = mem.HeapAlloc
+	s.HeapObjects = mem.HeapObjects
+	s.Sys = mem.Sys
+	s.NumGC = mem.NumGC
+	return s
+}
+
+// ctx returns the statistics as logging context
+func (s instanceStats) ctx() lw.Ctx {
+	return lw.Ctx{
+		"actors":      s.Actors,
+		"activities":  s.Activities,
+		"objects":     s.Objects,
+		"cacheHits":   s.CacheHits,
+		"cacheMisses": s.CacheMisses,
+		"goroutines":  s.Goroutines,
+		"heapAlloc":   s.HeapAlloc,
+		"heapObjects": s.HeapObjects,
+		"sys":         s.Sys,
+		"numGC":       s.NumGC,
+	}
+}
+
+// logStats writes the instance statistics to the log
+func (f *FedBOX) logStats() {
+	if f.logger == nil {
+		return
+	}
+	f.logger.WithContext(collectStats(f.storage, f.self.GetLink(), f.caches).ctx()).Infof("Instance statistics")
+}
+
+// HandleStats serves the administrative end-point with the instance statistics
+func HandleStats(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(fb.actorFromRequest(r), fb.self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		data, err := json.Marshal(collectStats(fb.storage, fb.self.GetLink(), fb.caches))
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
@@ -0,0 +1,40 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/cache"
+	"github.com/go-ap/filters"
+)
+
+func TestCollectStats(t *testing.T) {
+	self := vocab.IRI("https://fedbox.local")
+	db := mockCollectionStore{mockStore{}}
+	for _, col := range []vocab.CollectionPath{filters.ActorsType, filters.ActivitiesType, filters.ObjectsType} {
+		db.Create(&vocab.OrderedCollection{ID: col.IRI(self), Type: vocab.OrderedCollectionType})
+	}
+	db.AddTo(filters.ActorsType.IRI(self), vocab.IRI("https://fedbox.local/actors/johndoe"))
+	db.AddTo(filters.ObjectsType.IRI(self), vocab.IRI("https://fedbox.local/objects/1"))
+	db.AddTo(filters.ObjectsType.IRI(self), vocab.IRI("https://fedbox.local/objects/2"))
+
+	c := cache.New(true, 10, 0)
+	c.Set("https://fedbox.local/objects/1", &vocab.Object{ID: "https://fedbox.local/objects/1"})
+	c.Get("https://fedbox.local/objects/1")
+	c.Get("https://fedbox.local/objects/1")
+	c.Get("https://fedbox.local/objects/3")
+
+	s := collectStats(db, self, c)
+	if s.Actors != 1 || s.Activities != 0 || s.Objects != 2 {
+		t.Errorf("collectStats() counted %d actors, %d activities, %d objects, expected 1, 0, 2", s.Actors, s.Activities, s.Objects)
+	}
+	if s.CacheHits != 2 || s.CacheMisses != 1 {
+		t.Errorf("collectStats() cache hits %d, misses %d, expected 2, 1", s.CacheHits, s.CacheMisses)
+	}
+	if s.Goroutines == 0 || s.HeapAlloc == 0 || s.Sys == 0 {
+		t.Errorf("collectStats() should include the runtime statistics, received %+v", s)
+	}
+	if ctx := s.ctx(); ctx["objects"] != uint(2) {
+		t.Errorf("ctx() objects = %v, expected 2", ctx["objects"])
+	}
+}
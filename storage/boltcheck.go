@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"github.com/go-ap/errors"
+)
+
+// BoltDBFile is the name of the file where the boltdb storage keeps its data, in the storage path
+const BoltDBFile = "storage.bdb"
+
+// NOTE(marius): the layout of the boltdb meta pages, from go.etcd.io/bbolt. It writes them in the native byte
+// order of the host, and we read them as little-endian, so the files created on big-endian hosts are not supported.
+const (
+	boltMagic        uint32 = 0xED0CDAED
+	boltVersion      uint32 = 2
+	boltMetaPageFlag uint16 = 0x04
+	// boltPageHeaderSize is the size of the page header: the page id, flags, count and overflow
+	boltPageHeaderSize = 16
+	// boltMetaSize is the size of the meta: magic, version, page size, flags, root bucket, freelist, the high
+	// water mark page id, the transaction id and the checksum of the previous fields
+	boltMetaSize = 64
+	// boltMinPageSize is the smallest page size we accept, the boltdb default is the OS page size
+	boltMinPageSize = 512
+)
+
+// CorruptedError is returned for a storage file which can't be opened because it's corrupted, usually after
+// a power loss or a full disk. The data in its valid pages can still be recovered into a new storage file.
+type CorruptedError struct {
+	Path   string
+	Reason string
+}
+
+func (e CorruptedError) Error() string {
+	return fmt.Sprintf("the storage file %s is corrupted: %s; "+
+		"run \"fedboxctl storage repair\" to recover its data into a new storage file", e.Path, e.Reason)
+}
+
+// IsCorrupted checks if the err error is a CorruptedError
+func IsCorrupted(err error) bool {
+	var c CorruptedError
+	return errors.As(err, &c)
+}
+
+type boltMeta struct {
+	pageSize uint32
+	pgid     uint64
+	txid     uint64
+}
+
+// readBoltMeta reads and validates the meta page at the off offset of the raw bytes
+func readBoltMeta(raw []byte, off int) (boltMeta, error) {
+	m := boltMeta{}
+	if len(raw) < off+boltPageHeaderSize+boltMetaSize {
+		return m, errors.Newf("the meta page at offset %d is truncated", off)
+	}
+	page := raw[off:]
+	if flags := binary.LittleEndian.Uint16(page[8:10]); flags&boltMetaPageFlag == 0 {
+		return m, errors.Newf("the page at offset %d is not a meta page", off)
+	}
+	meta := page[boltPageHeaderSize : boltPageHeaderSize+boltMetaSize]
+	if magic := binary.LittleEndian.Uint32(meta[0:4]); magic != boltMagic {
+		return m, errors.Newf("invalid magic number %x in the meta page at offset %d", magic, off)
+	}
+	if version := binary.LittleEndian.Uint32(meta[4:8]); version != boltVersion {
+		return m, errors.Newf("unsupported version %d in the meta page at offset %d", version, off)
+	}
+	h := fnv.New64a()
+	h.Write(meta[:56])
+	if checksum := binary.LittleEndian.Uint64(meta[56:64]); checksum != h.Sum64() {
+		return m, errors.Newf("invalid checksum of the meta page at offset %d", off)
+	}
+	m.pageSize = binary.LittleEndian.Uint32(meta[8:12])
+	m.pgid = binary.LittleEndian.Uint64(meta[40:48])
+	m.txid = binary.LittleEndian.Uint64(meta[48:56])
+	if m.pageSize < boltMinPageSize {
+		return m, errors.Newf("invalid page size %d in the meta page at offset %d", m.pageSize, off)
+	}
+	return m, nil
+}
+
+// CheckBoltDBFile checks that the boltdb file at path has a valid meta page, and that it's not shorter than the
+// pages the meta page references. A missing or empty file is valid, as boltdb initializes it when opening.
+// It returns a CorruptedError for the files which can't be opened.
+//
+// NOTE(marius): this is not a replacement for the consistency check of boltdb, which needs to walk all the pages,
+// but it catches the truncated and the overwritten files, which boltdb refuses to open, or worse, opens and then
+// panics when accessing the missing pages.
+func CheckBoltDBFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Annotatef(err, "unable to open the storage file %s", path)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Annotatef(err, "unable to open the storage file %s", path)
+	}
+	size := fi.Size()
+	if size == 0 {
+		return nil
+	}
+	// NOTE(marius): the second meta page is at the offset of the page size, which we read from the first one,
+	// falling back to the OS page size when the first one is invalid
+	first := make([]byte, boltPageHeaderSize+boltMetaSize)
+	if _, err = io.ReadFull(f, first); err != nil {
+		return CorruptedError{Path: path, Reason: fmt.Sprintf("the file is truncated to %d bytes", size)}
+	}
+	var meta boltMeta
+	m0, err0 := readBoltMeta(first, 0)
+	pageSize := int64(os.Getpagesize())
+	if err0 == nil {
+		pageSize = int64(m0.pageSize)
+		meta = m0
+	}
+	second := make([]byte, boltPageHeaderSize+boltMetaSize)
+	_, err1 := f.ReadAt(second, pageSize)
+	var m1 boltMeta
+	if err1 == nil {
+		m1, err1 = readBoltMeta(second, 0)
+	}
+	if err1 == nil && (err0 != nil || m1.txid > m0.txid) {
+		meta = m1
+	}
+	if err0 != nil && err1 != nil {
+		return CorruptedError{Path: path, Reason: fmt.Sprintf("both meta pages are invalid: %s, %s", err0, err1)}
+	}
+	if expected := int64(meta.pgid) * int64(meta.pageSize); size < expected {
+		return CorruptedError{Path: path, Reason: fmt.Sprintf("the file is truncated to %d bytes, expected at least %d", size, expected)}
+	}
+	return nil
+}
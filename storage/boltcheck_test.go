@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBoltFile writes a boltdb file of size bytes, whose meta pages reference the number of pages
+func writeBoltFile(t *testing.T, pages int, size int) string {
+	const pageSize = 4096
+	raw := make([]byte, size)
+	for i, txid := range []uint64{2, 3} {
+		if (i+1)*pageSize > size {
+			break
+		}
+		page := raw[i*pageSize:]
+		binary.LittleEndian.PutUint64(page[0:8], uint64(i))
+		binary.LittleEndian.PutUint16(page[8:10], boltMetaPageFlag)
+		meta := page[boltPageHeaderSize:]
+		binary.LittleEndian.PutUint32(meta[0:4], boltMagic)
+		binary.LittleEndian.PutUint32(meta[4:8], boltVersion)
+		binary.LittleEndian.PutUint32(meta[8:12], pageSize)
+		binary.LittleEndian.PutUint64(meta[16:24], 3)
+		binary.LittleEndian.PutUint64(meta[32:40], 2)
+		binary.LittleEndian.PutUint64(meta[40:48], uint64(pages))
+		binary.LittleEndian.PutUint64(meta[48:56], txid)
+		h := fnv.New64a()
+		h.Write(meta[:56])
+		binary.LittleEndian.PutUint64(meta[56:64], h.Sum64())
+	}
+	path := filepath.Join(t.TempDir(), BoltDBFile)
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		t.Fatalf("unable to write the storage file: %s", err)
+	}
+	return path
+}
+
+func TestCheckBoltDBFile(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		if err := CheckBoltDBFile(writeBoltFile(t, 4, 4*4096)); err != nil {
+			t.Errorf("CheckBoltDBFile() for a valid file returned %s", err)
+		}
+	})
+	t.Run("missing", func(t *testing.T) {
+		if err := CheckBoltDBFile(filepath.Join(t.TempDir(), BoltDBFile)); err != nil {
+			t.Errorf("CheckBoltDBFile() for a missing file returned %s", err)
+		}
+	})
+	t.Run("truncated", func(t *testing.T) {
+		err := CheckBoltDBFile(writeBoltFile(t, 4, 2*4096))
+		if !IsCorrupted(err) {
+			t.Errorf("CheckBoltDBFile() for a truncated file returned %v, expected a corrupted error", err)
+		}
+	})
+	t.Run("truncated meta", func(t *testing.T) {
+		err := CheckBoltDBFile(writeBoltFile(t, 4, 40))
+		if !IsCorrupted(err) {
+			t.Errorf("CheckBoltDBFile() for a file truncated inside the meta page returned %v, expected a corrupted error", err)
+		}
+	})
+	t.Run("one invalid meta page", func(t *testing.T) {
+		path := writeBoltFile(t, 4, 4*4096)
+		raw, _ := os.ReadFile(path)
+		raw[boltPageHeaderSize+20]++
+		os.WriteFile(path, raw, 0600)
+		if err := CheckBoltDBFile(path); err != nil {
+			t.Errorf("CheckBoltDBFile() for a file with a valid meta page returned %s", err)
+		}
+	})
+	t.Run("invalid meta pages", func(t *testing.T) {
+		path := writeBoltFile(t, 4, 4*4096)
+		raw, _ := os.ReadFile(path)
+		raw[boltPageHeaderSize+20]++
+		raw[4096+boltPageHeaderSize+20]++
+		os.WriteFile(path, raw, 0600)
+		err := CheckBoltDBFile(path)
+		if !IsCorrupted(err) {
+			t.Errorf("CheckBoltDBFile() for a file without valid meta pages returned %v, expected a corrupted error", err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+// boltLockRetry is the interval between the attempts to acquire the lock on the boltdb file
+const boltLockRetry = 50 * time.Millisecond
+
+// WaitBoltDBLock waits for the other processes to release the lock they hold on the boltdb file at path,
+// and returns an error if that doesn't happen in the timeout duration.
+// A missing file isn't locked, so it returns immediately.
+//
+// NOTE(marius): boltdb holds a flock on its file while it's open, exclusive for the writers and shared for
+// the readers, and waits indefinitely for it when opening the file. We take a shared lock, which conflicts only
+// with the writers, and release it right away.
+func WaitBoltDBLock(path string, timeout time.Duration) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+		if err == nil {
+			return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		}
+		if err != syscall.EWOULDBLOCK {
+			return errors.Annotatef(err, "unable to lock the storage file %s", path)
+		}
+		if time.Now().After(deadline) {
+			return errors.Timeoutf("unable to open the storage in %s, the file %s is locked by another process", timeout, path)
+		}
+		time.Sleep(boltLockRetry)
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+func TestWaitBoltDBLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), BoltDBFile)
+	if err := WaitBoltDBLock(path, 50*time.Millisecond); err != nil {
+		t.Errorf("WaitBoltDBLock() for a missing file returned %s", err)
+	}
+	if err := os.WriteFile(path, []byte{}, 0600); err != nil {
+		t.Fatalf("unable to create the storage file: %s", err)
+	}
+	if err := WaitBoltDBLock(path, 50*time.Millisecond); err != nil {
+		t.Errorf("WaitBoltDBLock() for an unlocked file returned %s", err)
+	}
+
+	// NOTE(marius): the flocks of different open files conflict even inside the same process
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open the storage file: %s", err)
+	}
+	defer f.Close()
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatalf("unable to lock the storage file: %s", err)
+	}
+
+	start := time.Now()
+	if err = WaitBoltDBLock(path, 100*time.Millisecond); !errors.IsTimeout(err) {
+		t.Errorf("WaitBoltDBLock() for a locked file returned %v, expected a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitBoltDBLock() for a locked file returned after %s", elapsed)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}()
+	if err = WaitBoltDBLock(path, time.Second); err != nil {
+		t.Errorf("WaitBoltDBLock() for a file released while waiting returned %s", err)
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"crypto"
+	"sync"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// CollectionLocks serializes the changes to the collections: the changes to the same collection wait for each
+// other, while the ones to different collections run concurrently.
+type CollectionLocks struct {
+	m     sync.Mutex
+	locks map[vocab.IRI]*collectionLock
+}
+
+type collectionLock struct {
+	sync.Mutex
+	// refs is the number of callers holding, or waiting for, the lock
+	refs int
+}
+
+func NewCollectionLocks() *CollectionLocks {
+	return &CollectionLocks{locks: make(map[vocab.IRI]*collectionLock)}
+}
+
+// Lock locks the col collection, and returns the function which unlocks it.
+// The locks are dropped when nobody holds them, so they don't accumulate for all the collections ever changed.
+func (l *CollectionLocks) Lock(col vocab.IRI) func() {
+	if l == nil {
+		return func() {}
+	}
+	l.m.Lock()
+	cl, ok := l.locks[col]
+	if !ok {
+		cl = new(collectionLock)
+		l.locks[col] = cl
+	}
+	cl.refs++
+	l.m.Unlock()
+
+	cl.Lock()
+	return func() {
+		cl.Unlock()
+		l.m.Lock()
+		if cl.refs--; cl.refs == 0 {
+			delete(l.locks, col)
+		}
+		l.m.Unlock()
+	}
+}
+
+// SerializedStore is a storage whose AddTo and RemoveFrom calls are serialized for each collection.
+// The backends which load the items of a collection, change them, and write them back, would otherwise lose one of
+// two concurrent updates to the same collection.
+// The keys, metadata, counting and local IRI checks of the wrapped storage are passed through, so the processing
+// of the activities can use them.
+type SerializedStore struct {
+	processing.Store
+	locks *CollectionLocks
+}
+
+// Serialize returns the s storage with the changes to its collections serialized using the locks
+func Serialize(s processing.Store, locks *CollectionLocks) SerializedStore {
+	return SerializedStore{Store: s, locks: locks}
+}
+
+func (s SerializedStore) collections() (processing.CollectionStore, error) {
+	cs, ok := s.Store.(processing.CollectionStore)
+	if !ok {
+		return nil, errors.NotImplementedf("collections are not supported by the %T storage", s.Store)
+	}
+	return cs, nil
+}
+
+// Create creates the col collection
+func (s SerializedStore) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	cs, err := s.collections()
+	if err != nil {
+		return nil, err
+	}
+	defer s.locks.Lock(col.GetLink())()
+	return cs.Create(col)
+}
+
+// AddTo adds the it item to the col collection, after the other changes to the collection have finished
+func (s SerializedStore) AddTo(col vocab.IRI, it vocab.Item) error {
+	cs, err := s.collections()
+	if err != nil {
+		return err
+	}
+	defer s.locks.Lock(col)()
+	return cs.AddTo(col, it)
+}
+
+// RemoveFrom removes the it item from the col collection, after the other changes to the collection have finished
+func (s SerializedStore) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	cs, err := s.collections()
+	if err != nil {
+		return err
+	}
+	defer s.locks.Lock(col)()
+	return cs.RemoveFrom(col, it)
+}
+
+// CollectionContains checks if the member IRI is in the col collection, without waiting for the changes to it
+func (s SerializedStore) CollectionContains(col vocab.IRI, member vocab.IRI) (bool, error) {
+	return CollectionContains(s.Store, col, member)
+}
+
+// CountItems returns the number of items in the col collection, without waiting for the changes to it
+func (s SerializedStore) CountItems(col vocab.IRI) (uint, error) {
+	return CountItems(s.Store, col)
+}
+
+// LoadKey loads the private key of the actor with the iri
+func (s SerializedStore) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
+	k, ok := s.Store.(processing.KeyLoader)
+	if !ok {
+		return nil, errors.NotImplementedf("keys are not supported by the %T storage", s.Store)
+	}
+	return k.LoadKey(iri)
+}
+
+// LoadMetadata loads the metadata of the item with the iri
+func (s SerializedStore) LoadMetadata(iri vocab.IRI) (*processing.Metadata, error) {
+	m, ok := s.Store.(MetadataTyper)
+	if !ok {
+		return nil, errors.NotImplementedf("metadata is not supported by the %T storage", s.Store)
+	}
+	return m.LoadMetadata(iri)
+}
+
+// SaveMetadata saves the metadata of the item with the iri
+func (s SerializedStore) SaveMetadata(meta processing.Metadata, iri vocab.IRI) error {
+	m, ok := s.Store.(MetadataTyper)
+	if !ok {
+		return errors.NotImplementedf("metadata is not supported by the %T storage", s.Store)
+	}
+	return m.SaveMetadata(meta, iri)
+}
+
+// IsLocalIRI checks if the iri belongs to the wrapped storage
+func (s SerializedStore) IsLocalIRI(iri vocab.IRI) bool {
+	return IsLocalIRI(s.Store)(iri)
+}
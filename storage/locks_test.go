@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"crypto"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// racyStore changes the collections like the backends which read the list of items, change it and write it
+// back, leaving a window for the concurrent changes to be lost.
+type racyStore struct {
+	m     sync.Mutex
+	items map[vocab.IRI]vocab.IRIs
+}
+
+func (r *racyStore) read(col vocab.IRI) vocab.IRIs {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append(vocab.IRIs{}, r.items[col]...)
+}
+
+func (r *racyStore) write(col vocab.IRI, iris vocab.IRIs) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.items[col] = iris
+}
+
+func (r *racyStore) Load(iri vocab.IRI) (vocab.Item, error) {
+	iris := r.read(iri)
+	col := vocab.OrderedCollection{ID: iri, Type: vocab.OrderedCollectionType}
+	for _, it := range iris {
+		col.OrderedItems = append(col.OrderedItems, it)
+	}
+	return &col, nil
+}
+
+func (r *racyStore) Save(it vocab.Item) (vocab.Item, error) { return it, nil }
+func (r *racyStore) Delete(it vocab.Item) error             { return errors.NotImplementedf("delete") }
+
+func (r *racyStore) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	r.write(col.GetLink(), vocab.IRIs{})
+	return col, nil
+}
+
+func (r *racyStore) AddTo(col vocab.IRI, it vocab.Item) error {
+	iris := r.read(col)
+	runtime.Gosched()
+	r.write(col, append(iris, it.GetLink()))
+	return nil
+}
+
+func (r *racyStore) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	iris := r.read(col)
+	runtime.Gosched()
+	kept := make(vocab.IRIs, 0, len(iris))
+	for _, iri := range iris {
+		if !iri.Equals(it.GetLink(), false) {
+			kept = append(kept, iri)
+		}
+	}
+	r.write(col, kept)
+	return nil
+}
+
+func TestSerializedStoreConcurrentAddTo(t *testing.T) {
+	col := vocab.IRI("https://fedbox.local/actors/johndoe/inbox")
+	db := Serialize(&racyStore{items: make(map[vocab.IRI]vocab.IRIs)}, NewCollectionLocks())
+
+	const count = 100
+	wg := sync.WaitGroup{}
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- db.AddTo(col, vocab.IRI(fmt.Sprintf("https://example.com/activities/%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("AddTo() returned error %s", err)
+		}
+	}
+
+	it, _ := db.Load(col)
+	items := vocab.ItemCollection{}
+	vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+		items = c.Collection()
+		return nil
+	})
+	if len(items) != count {
+		t.Fatalf("the collection has %d items, expected %d", len(items), count)
+	}
+	for i := 0; i < count; i++ {
+		if iri := vocab.IRI(fmt.Sprintf("https://example.com/activities/%d", i)); !items.Contains(iri) {
+			t.Errorf("the collection is missing %s", iri)
+		}
+	}
+	if len(db.locks.locks) != 0 {
+		t.Errorf("the unused locks should be dropped, %d left", len(db.locks.locks))
+	}
+}
+
+// metaStore is a racyStore which supports the optional storage interfaces
+type metaStore struct {
+	*racyStore
+	meta map[vocab.IRI]processing.Metadata
+}
+
+func (m metaStore) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
+	return nil, errors.NotFoundf("no key for %s", iri)
+}
+
+func (m metaStore) LoadMetadata(iri vocab.IRI) (*processing.Metadata, error) {
+	meta, ok := m.meta[iri]
+	if !ok {
+		return nil, errors.NotFoundf("no metadata for %s", iri)
+	}
+	return &meta, nil
+}
+
+func (m metaStore) SaveMetadata(meta processing.Metadata, iri vocab.IRI) error {
+	m.meta[iri] = meta
+	return nil
+}
+
+func (m metaStore) CountItems(col vocab.IRI) (uint, error) {
+	return uint(len(m.read(col))), nil
+}
+
+func (m metaStore) IsLocalIRI(iri vocab.IRI) bool {
+	return iri.Contains("https://fedbox.local", false)
+}
+
+func TestSerializedStoreForwards(t *testing.T) {
+	actor := vocab.IRI("https://fedbox.local/actors/johndoe")
+	inbox := vocab.Inbox.IRI(actor)
+
+	plain := Serialize(&racyStore{items: make(map[vocab.IRI]vocab.IRIs)}, nil)
+	if _, err := plain.LoadKey(actor); !errors.IsNotImplemented(err) {
+		t.Errorf("LoadKey() for a storage without keys returned %v, expected a not implemented error", err)
+	}
+	if _, err := plain.LoadMetadata(actor); !errors.IsNotImplemented(err) {
+		t.Errorf("LoadMetadata() for a storage without metadata returned %v, expected a not implemented error", err)
+	}
+	if _, err := plain.CountItems(inbox); !errors.IsNotImplemented(err) {
+		t.Errorf("CountItems() for a storage which can't count returned %v, expected a not implemented error", err)
+	}
+	if plain.IsLocalIRI(actor) {
+		t.Errorf("IsLocalIRI() for a storage which can't check the IRIs should be false")
+	}
+
+	db := Serialize(metaStore{
+		racyStore: &racyStore{items: map[vocab.IRI]vocab.IRIs{inbox: {"https://example.com/1", "https://example.com/2"}}},
+		meta:      make(map[vocab.IRI]processing.Metadata),
+	}, nil)
+	var _ processing.KeyLoader = db
+	var _ MetadataTyper = db
+	var _ ItemCounter = db
+	var _ IRIChecker = db
+
+	if _, err := db.LoadKey(actor); !errors.IsNotFound(err) {
+		t.Errorf("LoadKey() returned %v, expected the not found error of the storage", err)
+	}
+	if err := db.SaveMetadata(processing.Metadata{Pw: []byte("dsa")}, actor); err != nil {
+		t.Fatalf("SaveMetadata() returned error %s", err)
+	}
+	if meta, err := db.LoadMetadata(actor); err != nil || string(meta.Pw) != "dsa" {
+		t.Errorf("LoadMetadata() returned %v, %v, expected the saved metadata", meta, err)
+	}
+	if cnt, err := db.CountItems(inbox); err != nil || cnt != 2 {
+		t.Errorf("CountItems() returned %d, %v, expected 2", cnt, err)
+	}
+	if !db.IsLocalIRI(actor) || db.IsLocalIRI("https://example.com/1") {
+		t.Errorf("IsLocalIRI() should use the check of the storage")
+	}
+}
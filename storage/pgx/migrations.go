@@ -0,0 +1,109 @@
+package pgx
+
+import (
+	"context"
+
+	"github.com/go-ap/errors"
+	"github.com/jackc/pgx/v5"
+)
+
+// migrations are the schema changes of the database, applied in order. Their index in the slice, starting with 1,
+// is their version, which we keep in the schema_migrations table. Existing migrations must never be modified,
+// changes to the schema go in new ones appended at the end.
+var migrations = []string{
+	// 1: the ActivityPub objects, the collections membership, the actors' metadata and the OAuth2 tables
+	`CREATE TABLE objects (
+	iri text PRIMARY KEY,
+	type text NOT NULL DEFAULT '',
+	raw jsonb NOT NULL,
+	published timestamptz NOT NULL DEFAULT now(),
+	updated timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX objects_raw_idx ON objects USING GIN (raw jsonb_path_ops);
+CREATE INDEX objects_type_idx ON objects (type);
+
+CREATE TABLE collection_items (
+	collection text NOT NULL REFERENCES objects(iri) ON DELETE CASCADE,
+	item text NOT NULL,
+	added timestamptz NOT NULL DEFAULT clock_timestamp(),
+	PRIMARY KEY (collection, item)
+);
+CREATE INDEX collection_items_item_idx ON collection_items (item);
+CREATE INDEX collection_items_added_idx ON collection_items (collection, added DESC);
+
+CREATE TABLE meta (
+	iri text PRIMARY KEY,
+	raw jsonb NOT NULL
+);
+
+CREATE TABLE clients (
+	code text PRIMARY KEY,
+	secret text NOT NULL,
+	redirect_uri text NOT NULL,
+	extra jsonb
+);
+
+CREATE TABLE authorize (
+	code text PRIMARY KEY,
+	client text NOT NULL REFERENCES clients(code) ON DELETE CASCADE,
+	expires_in integer NOT NULL,
+	scope text,
+	redirect_uri text NOT NULL,
+	state text,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	extra jsonb,
+	code_challenge text,
+	code_challenge_method text
+);
+
+CREATE TABLE access (
+	token text PRIMARY KEY,
+	client text NOT NULL REFERENCES clients(code) ON DELETE CASCADE,
+	authorize text,
+	previous text,
+	refresh_token text,
+	expires_in integer NOT NULL,
+	scope text,
+	redirect_uri text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	extra jsonb
+);
+
+CREATE TABLE refresh (
+	token text PRIMARY KEY,
+	access text NOT NULL REFERENCES access(token) ON DELETE CASCADE
+);`,
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	applied_at timestamptz NOT NULL DEFAULT now()
+);`
+
+// migrate applies the migrations which haven't been applied yet to the database, each of them in its own transaction.
+func migrate(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, createMigrationsTable); err != nil {
+		return errors.Annotatef(err, "unable to create the migrations table")
+	}
+	var current int
+	if err := conn.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return errors.Annotatef(err, "unable to load the current schema version")
+	}
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, migrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+			return err
+		})
+		if err != nil {
+			return errors.Annotatef(err, "unable to apply migration %d", version)
+		}
+	}
+	return nil
+}
+
+// dropTables are the tables removed by Clean, in the order which respects their references.
+var dropTables = []string{"refresh", "access", "authorize", "clients", "meta", "collection_items", "objects", "schema_migrations"}
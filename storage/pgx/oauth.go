@@ -0,0 +1,333 @@
+package pgx
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-ap/errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/openshift/osin"
+)
+
+func marshalUserData(d interface{}) ([]byte, error) {
+	if d == nil {
+		return nil, nil
+	}
+	return json.Marshal(d)
+}
+
+func unmarshalUserData(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	var d interface{}
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil
+	}
+	return d
+}
+
+// Clone returns the same repo, as the connection pool is safe for concurrent use
+func (r *repo) Clone() osin.Storage {
+	return r
+}
+
+// ListClients returns all the OAuth2 clients
+func (r *repo) ListClients() ([]osin.Client, error) {
+	conn, err := r.pool()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(context.Background(), `SELECT code, secret, redirect_uri, extra FROM clients`)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load clients")
+	}
+	defer rows.Close()
+
+	clients := make([]osin.Client, 0)
+	for rows.Next() {
+		c := osin.DefaultClient{}
+		var extra []byte
+		if err = rows.Scan(&c.Id, &c.Secret, &c.RedirectUri, &extra); err != nil {
+			return nil, err
+		}
+		c.UserData = unmarshalUserData(extra)
+		clients = append(clients, &c)
+	}
+	return clients, rows.Err()
+}
+
+// GetClient loads the client by id
+func (r *repo) GetClient(id string) (osin.Client, error) {
+	if id == "" {
+		return nil, errors.NotFoundf("empty client id")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return nil, err
+	}
+	c := osin.DefaultClient{}
+	var extra []byte
+	err = conn.QueryRow(context.Background(), `SELECT code, secret, redirect_uri, extra FROM clients WHERE code = $1`, id).
+		Scan(&c.Id, &c.Secret, &c.RedirectUri, &extra)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.NotFoundf("client %s not found", id)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load client %s", id)
+	}
+	c.UserData = unmarshalUserData(extra)
+	return &c, nil
+}
+
+// UpdateClient updates the client identified by its id
+func (r *repo) UpdateClient(c osin.Client) error {
+	if c == nil {
+		return errors.NotValidf("invalid nil client")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	extra, err := marshalUserData(c.GetUserData())
+	if err != nil {
+		return errors.Annotatef(err, "unable to marshal client data")
+	}
+	tag, err := conn.Exec(context.Background(), `UPDATE clients SET secret = $2, redirect_uri = $3, extra = $4 WHERE code = $1`,
+		c.GetId(), c.GetSecret(), c.GetRedirectUri(), extra)
+	if err != nil {
+		return errors.Annotatef(err, "unable to update client %s", c.GetId())
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.NotFoundf("client %s not found", c.GetId())
+	}
+	return nil
+}
+
+// CreateClient saves the c client
+func (r *repo) CreateClient(c osin.Client) error {
+	if c == nil {
+		return errors.NotValidf("invalid nil client")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	extra, err := marshalUserData(c.GetUserData())
+	if err != nil {
+		return errors.Annotatef(err, "unable to marshal client data")
+	}
+	_, err = conn.Exec(context.Background(), `INSERT INTO clients (code, secret, redirect_uri, extra) VALUES ($1, $2, $3, $4)`,
+		c.GetId(), c.GetSecret(), c.GetRedirectUri(), extra)
+	if err != nil {
+		return errors.Annotatef(err, "unable to save client %s", c.GetId())
+	}
+	return nil
+}
+
+// RemoveClient removes the client identified by id
+func (r *repo) RemoveClient(id string) error {
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Exec(context.Background(), `DELETE FROM clients WHERE code = $1`, id); err != nil {
+		return errors.Annotatef(err, "unable to remove client %s", id)
+	}
+	return nil
+}
+
+// SaveAuthorize saves the authorization data
+func (r *repo) SaveAuthorize(d *osin.AuthorizeData) error {
+	if d == nil || d.Client == nil {
+		return errors.NotValidf("invalid authorize data")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	extra, err := marshalUserData(d.UserData)
+	if err != nil {
+		return errors.Annotatef(err, "unable to marshal authorize data")
+	}
+	_, err = conn.Exec(context.Background(), `INSERT INTO authorize
+(code, client, expires_in, scope, redirect_uri, state, created_at, extra, code_challenge, code_challenge_method)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		d.Code, d.Client.GetId(), d.ExpiresIn, d.Scope, d.RedirectUri, d.State, d.CreatedAt.UTC(), extra,
+		d.CodeChallenge, d.CodeChallengeMethod)
+	if err != nil {
+		return errors.Annotatef(err, "unable to save authorize data")
+	}
+	return nil
+}
+
+// LoadAuthorize loads the authorization data identified by the code
+func (r *repo) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	if code == "" {
+		return nil, errors.NotFoundf("empty authorize code")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return nil, err
+	}
+	d := osin.AuthorizeData{}
+	var client string
+	var extra []byte
+	err = conn.QueryRow(context.Background(), `SELECT code, client, expires_in, COALESCE(scope, ''), redirect_uri,
+COALESCE(state, ''), created_at, extra, COALESCE(code_challenge, ''), COALESCE(code_challenge_method, '')
+FROM authorize WHERE code = $1`, code).
+		Scan(&d.Code, &client, &d.ExpiresIn, &d.Scope, &d.RedirectUri, &d.State, &d.CreatedAt, &extra,
+			&d.CodeChallenge, &d.CodeChallengeMethod)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.NotFoundf("authorize code %s not found", code)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load authorize data")
+	}
+	d.UserData = unmarshalUserData(extra)
+	if d.Client, err = r.GetClient(client); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RemoveAuthorize removes the authorization data identified by the code
+func (r *repo) RemoveAuthorize(code string) error {
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Exec(context.Background(), `DELETE FROM authorize WHERE code = $1`, code); err != nil {
+		return errors.Annotatef(err, "unable to remove authorize data")
+	}
+	return nil
+}
+
+// SaveAccess saves the access data, and its refresh token if it has one
+func (r *repo) SaveAccess(d *osin.AccessData) error {
+	if d == nil || d.Client == nil {
+		return errors.NotValidf("invalid access data")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	extra, err := marshalUserData(d.UserData)
+	if err != nil {
+		return errors.Annotatef(err, "unable to marshal access data")
+	}
+	var authorize, previous string
+	if d.AuthorizeData != nil {
+		authorize = d.AuthorizeData.Code
+	}
+	if d.AccessData != nil {
+		previous = d.AccessData.AccessToken
+	}
+	ctx := context.Background()
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO access
+(token, client, authorize, previous, refresh_token, expires_in, scope, redirect_uri, created_at, extra)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			d.AccessToken, d.Client.GetId(), authorize, previous, d.RefreshToken, d.ExpiresIn, d.Scope,
+			d.RedirectUri, d.CreatedAt.UTC(), extra)
+		if err != nil {
+			return errors.Annotatef(err, "unable to save access data")
+		}
+		if d.RefreshToken == "" {
+			return nil
+		}
+		if _, err = tx.Exec(ctx, `INSERT INTO refresh (token, access) VALUES ($1, $2)`, d.RefreshToken, d.AccessToken); err != nil {
+			return errors.Annotatef(err, "unable to save refresh token")
+		}
+		return nil
+	})
+}
+
+// LoadAccess loads the access data identified by the token
+func (r *repo) LoadAccess(token string) (*osin.AccessData, error) {
+	return r.loadAccess(token, true)
+}
+
+func (r *repo) loadAccess(token string, loadPrevious bool) (*osin.AccessData, error) {
+	if token == "" {
+		return nil, errors.NotFoundf("empty access token")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return nil, err
+	}
+	d := osin.AccessData{}
+	var client, authorize, previous string
+	var extra []byte
+	err = conn.QueryRow(context.Background(), `SELECT token, client, COALESCE(authorize, ''), COALESCE(previous, ''),
+COALESCE(refresh_token, ''), expires_in, COALESCE(scope, ''), redirect_uri, created_at, extra
+FROM access WHERE token = $1`, token).
+		Scan(&d.AccessToken, &client, &authorize, &previous, &d.RefreshToken, &d.ExpiresIn, &d.Scope,
+			&d.RedirectUri, &d.CreatedAt, &extra)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.NotFoundf("access token not found")
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load access data")
+	}
+	d.UserData = unmarshalUserData(extra)
+	if d.Client, err = r.GetClient(client); err != nil {
+		return nil, err
+	}
+	if authorize != "" {
+		if d.AuthorizeData, err = r.LoadAuthorize(authorize); err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	if previous != "" && loadPrevious {
+		// NOTE(marius): we load only the direct predecessor of the access data, as the older ones are not needed
+		if d.AccessData, err = r.loadAccess(previous, false); err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return &d, nil
+}
+
+// RemoveAccess removes the access data identified by the token
+func (r *repo) RemoveAccess(token string) error {
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Exec(context.Background(), `DELETE FROM access WHERE token = $1`, token); err != nil {
+		return errors.Annotatef(err, "unable to remove access data")
+	}
+	return nil
+}
+
+// LoadRefresh loads the access data corresponding to the refresh token
+func (r *repo) LoadRefresh(token string) (*osin.AccessData, error) {
+	if token == "" {
+		return nil, errors.NotFoundf("empty refresh token")
+	}
+	conn, err := r.pool()
+	if err != nil {
+		return nil, err
+	}
+	var access string
+	err = conn.QueryRow(context.Background(), `SELECT access FROM refresh WHERE token = $1`, token).Scan(&access)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errors.NotFoundf("refresh token not found")
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load refresh token")
+	}
+	return r.LoadAccess(access)
+}
+
+// RemoveRefresh removes the refresh token
+func (r *repo) RemoveRefresh(token string) error {
+	conn, err := r.pool()
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Exec(context.Background(), `DELETE FROM refresh WHERE token = $1`, token); err != nil {
+		return errors.Annotatef(err, "unable to remove refresh token")
+	}
+	return nil
+}
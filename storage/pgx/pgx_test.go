@@ -0,0 +1,368 @@
+package pgx
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+	"github.com/openshift/osin"
+)
+
+// testDSNKey is the environment variable containing the connection string of the database we run the tests against.
+// All the tables in that database get dropped.
+const testDSNKey = "FEDBOX_PGX_TEST_DSN"
+
+func testRepo(t *testing.T) *repo {
+	dsn := os.Getenv(testDSNKey)
+	if dsn == "" {
+		t.Skipf("%s is not set, skipping PostgreSQL tests", testDSNKey)
+	}
+	c := Config{DSN: dsn, ErrFn: t.Logf}
+	if err := Clean(c); err != nil {
+		t.Fatalf("unable to clean the test database: %s", err)
+	}
+	r, err := New(c)
+	if err != nil {
+		t.Fatalf("unable to initialize the repo: %s", err)
+	}
+	if err = r.Open(); err != nil {
+		t.Fatalf("unable to open the repo: %s", err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+const base = vocab.IRI("https://example.com")
+
+func testActor() *vocab.Actor {
+	id := base.AddPath("actors", "jdoe")
+	return &vocab.Actor{
+		ID:                id,
+		Type:              vocab.PersonType,
+		PreferredUsername: vocab.DefaultNaturalLanguageValue("jdoe"),
+		Inbox:             vocab.Inbox.IRI(id),
+		Outbox:            vocab.Outbox.IRI(id),
+		Followers:         vocab.Followers.IRI(id),
+		Following:         vocab.Following.IRI(id),
+		Liked:             vocab.Liked.IRI(id),
+	}
+}
+
+func TestCleanIRI(t *testing.T) {
+	tests := []struct {
+		iri  vocab.IRI
+		want vocab.IRI
+	}{
+		{iri: "https://example.com/actors", want: "https://example.com/actors"},
+		{iri: "https://example.com/actors?type=Person", want: "https://example.com/actors"},
+		{iri: "https://example.com/actors/jdoe/outbox?maxItems=10&after=123", want: "https://example.com/actors/jdoe/outbox"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.iri.String(), func(t *testing.T) {
+			if got := cleanIRI(tt.iri); got != tt.want {
+				t.Errorf("cleanIRI() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithoutItems(t *testing.T) {
+	col := vocab.OrderedCollection{
+		ID:           base.AddPath("outbox"),
+		Type:         vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{base.AddPath("1"), base.AddPath("2")},
+		TotalItems:   2,
+	}
+	stripped, ok := withoutItems(&col).(*vocab.OrderedCollection)
+	if !ok {
+		t.Fatalf("withoutItems() returned %T, expected %T", stripped, &col)
+	}
+	if len(stripped.OrderedItems) != 0 || stripped.TotalItems != 0 {
+		t.Errorf("withoutItems() kept the items %v", stripped.OrderedItems)
+	}
+	if len(col.OrderedItems) != 2 {
+		t.Errorf("withoutItems() modified the original collection")
+	}
+}
+
+func TestOwnedCollections(t *testing.T) {
+	act := testActor()
+	got := ownedCollections(act)
+	for _, want := range []vocab.Item{act.Inbox, act.Outbox, act.Followers, act.Following, act.Liked} {
+		if !got.Contains(want.GetLink()) {
+			t.Errorf("ownedCollections() = %v, missing %s", got, want.GetLink())
+		}
+	}
+	ob := vocab.Object{ID: base.AddPath("objects", "1"), Type: vocab.NoteType, Replies: base.AddPath("objects", "1", "replies")}
+	if got = ownedCollections(&ob); len(got) != 1 || !got.Contains(ob.Replies.GetLink()) {
+		t.Errorf("ownedCollections() = %v, expected only %s", got, ob.Replies.GetLink())
+	}
+}
+
+func TestRepo_SaveLoadDelete(t *testing.T) {
+	r := testRepo(t)
+
+	act := testActor()
+	if _, err := r.Save(act); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+	it, err := r.Load(act.GetLink())
+	if err != nil {
+		t.Fatalf("Load() error = %s", err)
+	}
+	if !it.GetLink().Equals(act.GetLink(), false) || it.GetType() != vocab.PersonType {
+		t.Errorf("Load() = %s %s, expected %s %s", it.GetType(), it.GetLink(), vocab.PersonType, act.GetLink())
+	}
+	outbox, err := r.Load(act.Outbox.GetLink())
+	if err != nil {
+		t.Fatalf("Load() of the actor's outbox error = %s", err)
+	}
+	if !outbox.IsCollection() {
+		t.Errorf("Load() of the actor's outbox returned %T, expected a collection", outbox)
+	}
+
+	actors, err := r.Load(base.AddPath("actors"))
+	if err != nil {
+		t.Fatalf("Load() of the actors collection error = %s", err)
+	}
+	vocab.OnCollectionIntf(actors, func(col vocab.CollectionInterface) error {
+		if !col.Contains(act.GetLink()) {
+			t.Errorf("Load() of the actors collection doesn't contain %s", act.GetLink())
+		}
+		return nil
+	})
+
+	if err = r.Delete(act); err != nil {
+		t.Fatalf("Delete() error = %s", err)
+	}
+	if _, err = r.Load(act.GetLink()); !errors.IsNotFound(err) {
+		t.Errorf("Load() after Delete() error = %v, expected not found", err)
+	}
+	if err = r.Delete(act); !errors.IsNotFound(err) {
+		t.Errorf("Delete() of missing item error = %v, expected not found", err)
+	}
+}
+
+func TestLikeEscaper(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/actors":     "https://example.com/actors",
+		"https://example.com/act_rs":     `https://example.com/act\_rs`,
+		"https://example.com/100%25":     `https://example.com/100\%25`,
+		`https://example.com/back\slash`: `https://example.com/back\\slash`,
+	}
+	for in, want := range tests {
+		if got := likeEscaper.Replace(in); got != want {
+			t.Errorf("likeEscaper.Replace(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestRepo_LoadFiltered(t *testing.T) {
+	r := testRepo(t)
+
+	act := testActor()
+	other := vocab.Actor{ID: base.AddPath("actors", "alice"), Type: vocab.PersonType, Name: vocab.DefaultNaturalLanguageValue("alice")}
+	act.Name = vocab.DefaultNaturalLanguageValue("jdoe")
+	for _, it := range []vocab.Item{act, &other} {
+		if _, err := r.Save(it); err != nil {
+			t.Fatalf("Save() error = %s", err)
+		}
+	}
+
+	// NOTE(marius): this is how the OAuth2 password grant finds the actor logging in
+	it, err := r.Load(base.AddPath("actors") + "?name=jdoe")
+	if err != nil {
+		t.Fatalf("Load() error = %s", err)
+	}
+	vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		if col.Count() != 1 || !col.Contains(act.GetLink()) {
+			t.Errorf("Load() with the name filter returned %v, expected only %s", col.Collection(), act.GetLink())
+		}
+		return nil
+	})
+	if _, err = r.Load(act.GetLink() + "?type=Note"); !errors.IsNotFound(err) {
+		t.Errorf("Load() with a type filter not matching the item error = %v, expected not found", err)
+	}
+	// NOTE(marius): the LIKE wildcards in the IRI must not match other paths
+	if _, err = r.Load(base.AddPath("act_rs")); !errors.IsNotFound(err) {
+		t.Errorf("Load() of the children of a path with a wildcard error = %v, expected not found", err)
+	}
+}
+
+func TestRepo_AddToRemoveFrom(t *testing.T) {
+	r := testRepo(t)
+
+	act := testActor()
+	if _, err := r.Save(act); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+	note := vocab.Object{ID: base.AddPath("objects", "1"), Type: vocab.NoteType}
+	if _, err := r.Save(&note); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+	remote := vocab.IRI("https://remote.example.com/objects/1")
+
+	outbox := act.Outbox.GetLink()
+	for _, it := range []vocab.Item{&note, remote, &note} {
+		if err := r.AddTo(outbox, it); err != nil {
+			t.Fatalf("AddTo() error = %s", err)
+		}
+	}
+	if cnt, err := r.CountItems(outbox); err != nil || cnt != 2 {
+		t.Errorf("CountItems() = %d, %v, expected 2", cnt, err)
+	}
+
+	it, err := r.Load(outbox)
+	if err != nil {
+		t.Fatalf("Load() error = %s", err)
+	}
+	col, ok := it.(*vocab.OrderedCollection)
+	if !ok {
+		t.Fatalf("Load() returned %T, expected %T", it, col)
+	}
+	if col.TotalItems != 2 || len(col.OrderedItems) != 2 {
+		t.Fatalf("Load() returned %d items, expected 2", len(col.OrderedItems))
+	}
+	// NOTE(marius): the items are ordered by the time they were added, newest first
+	if !col.OrderedItems[0].IsLink() || !col.OrderedItems[0].GetLink().Equals(remote, false) {
+		t.Errorf("first item is %v, expected the %s IRI", col.OrderedItems[0], remote)
+	}
+	if col.OrderedItems[1].IsLink() || col.OrderedItems[1].GetType() != vocab.NoteType {
+		t.Errorf("second item is %v, expected the loaded %s", col.OrderedItems[1], note.ID)
+	}
+
+	if err = r.RemoveFrom(outbox, remote); err != nil {
+		t.Fatalf("RemoveFrom() error = %s", err)
+	}
+	if cnt, _ := r.CountItems(outbox); cnt != 1 {
+		t.Errorf("CountItems() after RemoveFrom() = %d, expected 1", cnt)
+	}
+}
+
+func TestRepo_CollectionContains(t *testing.T) {
+	r := testRepo(t)
+
+	act := testActor()
+	if _, err := r.Save(act); err != nil {
+		t.Fatalf("Save() error = %s", err)
+	}
+	followers := act.Followers.GetLink()
+	for i := 0; i < 5000; i++ {
+		if err := r.AddTo(followers, vocab.IRI(fmt.Sprintf("https://remote.example.com/actors/%d", i))); err != nil {
+			t.Fatalf("AddTo() error = %s", err)
+		}
+	}
+	if found, err := r.CollectionContains(followers, "https://remote.example.com/actors/4321"); err != nil || !found {
+		t.Errorf("CollectionContains() for a member = %t, %v, expected true", found, err)
+	}
+	if found, err := r.CollectionContains(followers, "https://remote.example.com/actors/5000"); err != nil || found {
+		t.Errorf("CollectionContains() for a missing member = %t, %v, expected false", found, err)
+	}
+	if found, err := r.CollectionContains(act.Following.GetLink(), "https://remote.example.com/actors/1"); err != nil || found {
+		t.Errorf("CollectionContains() for an empty collection = %t, %v, expected false", found, err)
+	}
+}
+
+func TestRepo_Create(t *testing.T) {
+	r := testRepo(t)
+
+	col := vocab.OrderedCollection{
+		ID:           base.AddPath("collection"),
+		Type:         vocab.OrderedCollectionType,
+		OrderedItems: vocab.ItemCollection{base.AddPath("objects", "1"), base.AddPath("objects", "2")},
+	}
+	if _, err := r.Create(&col); err != nil {
+		t.Fatalf("Create() error = %s", err)
+	}
+	if cnt, err := r.CountItems(col.ID); err != nil || cnt != 2 {
+		t.Errorf("CountItems() = %d, %v, expected 2", cnt, err)
+	}
+}
+
+func TestRepo_Password(t *testing.T) {
+	r := testRepo(t)
+
+	act := testActor()
+	if err := r.PasswordSet(act, []byte("dsa")); err != nil {
+		t.Fatalf("PasswordSet() error = %s", err)
+	}
+	if err := r.PasswordCheck(act, []byte("dsa")); err != nil {
+		t.Errorf("PasswordCheck() error = %s", err)
+	}
+	if err := r.PasswordCheck(act, []byte("asd")); err == nil {
+		t.Errorf("PasswordCheck() with wrong password succeeded")
+	}
+}
+
+func TestRepo_LoadKey(t *testing.T) {
+	r := testRepo(t)
+
+	_, prv, _ := ed25519.GenerateKey(rand.Reader)
+	raw, err := x509.MarshalPKCS8PrivateKey(prv)
+	if err != nil {
+		t.Fatalf("unable to marshal the key: %s", err)
+	}
+	act := testActor()
+	m := processing.Metadata{PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: raw})}
+	if err = r.SaveMetadata(m, act.GetLink()); err != nil {
+		t.Fatalf("SaveMetadata() error = %s", err)
+	}
+	key, err := r.LoadKey(act.GetLink())
+	if err != nil {
+		t.Fatalf("LoadKey() error = %s", err)
+	}
+	if !prv.Equal(key) {
+		t.Errorf("LoadKey() returned a different key")
+	}
+}
+
+func TestRepo_OAuth(t *testing.T) {
+	r := testRepo(t)
+
+	cl := osin.DefaultClient{Id: "client", Secret: "secret", RedirectUri: "https://example.com/callback"}
+	if err := r.CreateClient(&cl); err != nil {
+		t.Fatalf("CreateClient() error = %s", err)
+	}
+	if c, err := r.GetClient(cl.Id); err != nil || c.GetSecret() != cl.Secret {
+		t.Fatalf("GetClient() = %v, %v", c, err)
+	}
+	if clients, err := r.ListClients(); err != nil || len(clients) != 1 {
+		t.Errorf("ListClients() = %v, %v, expected one client", clients, err)
+	}
+
+	auth := osin.AuthorizeData{Client: &cl, Code: "code", ExpiresIn: 60, RedirectUri: cl.RedirectUri}
+	if err := r.SaveAuthorize(&auth); err != nil {
+		t.Fatalf("SaveAuthorize() error = %s", err)
+	}
+	access := osin.AccessData{
+		Client:        &cl,
+		AuthorizeData: &auth,
+		AccessToken:   "access",
+		RefreshToken:  "refresh",
+		ExpiresIn:     60,
+		RedirectUri:   cl.RedirectUri,
+	}
+	if err := r.SaveAccess(&access); err != nil {
+		t.Fatalf("SaveAccess() error = %s", err)
+	}
+	loaded, err := r.LoadRefresh(access.RefreshToken)
+	if err != nil {
+		t.Fatalf("LoadRefresh() error = %s", err)
+	}
+	if loaded.AccessToken != access.AccessToken || loaded.AuthorizeData == nil || loaded.AuthorizeData.Code != auth.Code {
+		t.Errorf("LoadRefresh() = %v, expected %v", loaded, access)
+	}
+	if err = r.RemoveClient(cl.Id); err != nil {
+		t.Fatalf("RemoveClient() error = %s", err)
+	}
+	if _, err = r.LoadAccess(access.AccessToken); !errors.IsNotFound(err) {
+		t.Errorf("LoadAccess() after RemoveClient() error = %v, expected not found", err)
+	}
+}
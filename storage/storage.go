@@ -2,6 +2,7 @@ package storage
 
 import (
 	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
 	"github.com/go-ap/processing"
 )
 
@@ -41,4 +42,34 @@ func IsLocalIRI(s processing.Store) processing.IRIValidator {
 	}
 }
 
+// MembershipChecker is the interface for storage backends which can check if an IRI is a member of a collection
+// without loading all its items
+type MembershipChecker interface {
+	CollectionContains(col vocab.IRI, member vocab.IRI) (bool, error)
+}
+
+// CollectionContains checks if the member IRI is in the col collection of the s storage. It returns a not
+// implemented error for the storage backends which don't support the membership checks, which need to load
+// the collection's items instead.
+func CollectionContains(s processing.ReadStore, col vocab.IRI, member vocab.IRI) (bool, error) {
+	if c, ok := s.(MembershipChecker); ok {
+		return c.CollectionContains(col, member)
+	}
+	return false, errors.NotImplementedf("membership checks are not supported by the %T storage", s)
+}
+
+// ItemCounter is the interface for storage backends which can count the items of a collection without loading them
+type ItemCounter interface {
+	CountItems(col vocab.IRI) (uint, error)
+}
+
+// CountItems returns the number of items in the col collection of the s storage. It returns a not implemented
+// error for the storage backends which can't count them, which need to load the collection instead.
+func CountItems(s processing.ReadStore, col vocab.IRI) (uint, error) {
+	if c, ok := s.(ItemCounter); ok {
+		return c.CountItems(col)
+	}
+	return 0, errors.NotImplementedf("counting the items is not supported by the %T storage", s)
+}
+
 type OptionFn func(s processing.Store) error
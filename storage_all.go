@@ -1,4 +1,4 @@
-//go:build storage_all || (!storage_boltdb && !storage_fs && !storage_badger && !storage_sqlite)
+//go:build storage_all || (!storage_boltdb && !storage_fs && !storage_badger && !storage_sqlite && !storage_pgx)
 
 package fedbox
 
@@ -6,6 +6,7 @@ import (
 	"git.sr.ht/~mariusor/lw"
 	"github.com/go-ap/errors"
 	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/storage/pgx"
 	"github.com/go-ap/storage-badger"
 	"github.com/go-ap/storage-boltdb"
 	"github.com/go-ap/storage-fs"
@@ -76,6 +77,20 @@ func getSqliteStorage(c config.Options, l lw.Logger) (FullStorage, error) {
 	return db, nil
 }
 
+func getPgxStorage(c config.Options, l lw.Logger) (FullStorage, error) {
+	l = l.WithContext(lw.Ctx{"host": c.DB.Host, "db": c.DB.Name})
+	l.Debugf("Initializing PostgreSQL storage")
+	db, err := pgx.New(pgx.Config{
+		DSN:   c.DB.DSN(),
+		LogFn: l.Debugf,
+		ErrFn: l.Warnf,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to connect to PostgreSQL storage")
+	}
+	return db, nil
+}
+
 func Storage(c config.Options, l lw.Logger) (FullStorage, error) {
 	switch c.Storage {
 	case config.StorageBoltDB:
@@ -86,6 +101,8 @@ func Storage(c config.Options, l lw.Logger) (FullStorage, error) {
 		return getSqliteStorage(c, l)
 	case config.StorageFS:
 		return getFsStorage(c, l)
+	case config.StoragePostgres:
+		return getPgxStorage(c, l)
 	}
 	return nil, errors.NotImplementedf("Invalid storage type %s", c.Storage)
 }
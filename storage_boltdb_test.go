@@ -0,0 +1,81 @@
+//go:build storage_boltdb
+
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/env"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/go-ap/storage-boltdb"
+)
+
+// boltdbStorage returns a bootstrapped boltdb storage in a temporary folder, which keeps the metadata by the
+// path of the IRIs, dropping their fragment
+func boltdbStorage(t *testing.T) st.MetadataTyper {
+	c := config.Options{BaseURL: "https://fedbox.local", Storage: config.StorageBoltDB, StoragePath: t.TempDir(), Env: env.TEST}
+	if err := boltdb.Bootstrap(boltdb.Config{Path: c.BaseStoragePath()}, &vocab.Service{ID: vocab.IRI(c.BaseURL)}); err != nil {
+		t.Fatalf("unable to bootstrap the boltdb storage: %s", err)
+	}
+	db, err := Storage(c, lw.Dev())
+	if err != nil {
+		t.Fatalf("unable to open the boltdb storage: %s", err)
+	}
+	m, ok := db.(st.MetadataTyper)
+	if !ok {
+		t.Fatalf("the boltdb storage %T doesn't support metadata", db)
+	}
+	return m
+}
+
+func TestRotateKey_boltdb(t *testing.T) {
+	db := boltdbStorage(t)
+	act := vocab.Actor{ID: "https://fedbox.local/actors/jdoe", Type: vocab.PersonType}
+	if err := AddKeyToPerson(db, KeyTypeRSA, config.KeyEncodingPKIX)(&act); err != nil {
+		t.Fatalf("AddKeyToPerson() error = %s", err)
+	}
+	meta, err := db.LoadMetadata(act.ID)
+	if err != nil {
+		t.Fatalf("LoadMetadata() error = %s", err)
+	}
+	meta.Pw = []byte("password hash")
+	if err = db.SaveMetadata(*meta, act.ID); err != nil {
+		t.Fatalf("SaveMetadata() error = %s", err)
+	}
+	oldPem := act.PublicKey.PublicKeyPem
+
+	now := time.Now().UTC()
+	if err = RotateKey(db, &act, KeyTypeRSA, config.KeyEncodingPKIX, now); err != nil {
+		t.Fatalf("RotateKey() error = %s", err)
+	}
+	if meta, _ = db.LoadMetadata(act.ID); meta == nil || string(meta.Pw) != "password hash" {
+		t.Errorf("RotateKey() should keep the password of the actor, got %v", meta)
+	}
+	if prev := PreviousPublicKey(db, act.ID, config.KeyEncodingPKIX, now.Add(time.Hour)); prev != oldPem {
+		t.Errorf("PreviousPublicKey() = %q, expected the key from before the rotation %q", prev, oldPem)
+	}
+}
+
+func TestSetCollectionVisibility_boltdb(t *testing.T) {
+	db := boltdbStorage(t)
+	act := vocab.Actor{ID: "https://fedbox.local/actors/jdoe", Type: vocab.PersonType}
+	keys := processing.Metadata{Pw: []byte("password hash"), PrivateKey: []byte("private key")}
+	if err := db.SaveMetadata(keys, act.ID); err != nil {
+		t.Fatalf("SaveMetadata() error = %s", err)
+	}
+	if err := SetCollectionVisibility(db, act.ID, vocab.Liked, true); err != nil {
+		t.Fatalf("SetCollectionVisibility() error = %s", err)
+	}
+	if loadPreferences(db, act.ID)[string(vocab.Liked)] != collectionPrivate {
+		t.Errorf("the liked collection of %s should be private", act.ID)
+	}
+	meta, _ := db.LoadMetadata(act.ID)
+	if meta == nil || string(meta.Pw) != string(keys.Pw) || string(meta.PrivateKey) != string(keys.PrivateKey) {
+		t.Errorf("the preferences shouldn't change the metadata with the keys of the actor, got %v", meta)
+	}
+}
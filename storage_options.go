@@ -0,0 +1,295 @@
+package fedbox
+
+import (
+	"crypto"
+	"fmt"
+	"path/filepath"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/openshift/osin"
+)
+
+// checkStorageOpens waits for the other processes to release the lock on the storage file, and returns an error
+// if that doesn't happen in the timeout from the c configuration. When the timeout is zero we wait indefinitely,
+// when opening the storage.
+// Only the boltdb storage waits for the lock on its file, the other backends fail right away when it's locked.
+func checkStorageOpens(c config.Options) error {
+	if c.Storage != config.StorageBoltDB || c.StorageOpenTimeout <= 0 {
+		return nil
+	}
+	return st.WaitBoltDBLock(BoltDBStorageFile(c), c.StorageOpenTimeout)
+}
+
+// collectionStore is the storage functionality needed for managing the items and the collections they belong to,
+// eg: by the handlers which manage the collections, and for the side effects of the follow relationships
+type collectionStore interface {
+	processing.Store
+	processing.CollectionStore
+}
+
+// wrappedStorage is the base of the storage wrappers which change only some of the methods of the backend.
+// It passes through the collections, the metadata, the keys, the counting and the local IRI checks, which
+// a wrapper embedding only the FullStorage interface would hide from the processing of the activities.
+type wrappedStorage struct {
+	FullStorage
+}
+
+func (s wrappedStorage) collections() (processing.CollectionStore, error) {
+	cs, ok := s.FullStorage.(processing.CollectionStore)
+	if !ok {
+		return nil, errors.NotImplementedf("collections are not supported by the %T storage", s.FullStorage)
+	}
+	return cs, nil
+}
+
+func (s wrappedStorage) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	cs, err := s.collections()
+	if err != nil {
+		return nil, err
+	}
+	return cs.Create(col)
+}
+
+func (s wrappedStorage) AddTo(col vocab.IRI, it vocab.Item) error {
+	cs, err := s.collections()
+	if err != nil {
+		return err
+	}
+	return cs.AddTo(col, it)
+}
+
+func (s wrappedStorage) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	cs, err := s.collections()
+	if err != nil {
+		return err
+	}
+	return cs.RemoveFrom(col, it)
+}
+
+func (s wrappedStorage) LoadMetadata(iri vocab.IRI) (*processing.Metadata, error) {
+	m, ok := s.FullStorage.(st.MetadataTyper)
+	if !ok {
+		return nil, errors.NotImplementedf("metadata is not supported by the %T storage", s.FullStorage)
+	}
+	return m.LoadMetadata(iri)
+}
+
+func (s wrappedStorage) SaveMetadata(meta processing.Metadata, iri vocab.IRI) error {
+	m, ok := s.FullStorage.(st.MetadataTyper)
+	if !ok {
+		return errors.NotImplementedf("metadata is not supported by the %T storage", s.FullStorage)
+	}
+	return m.SaveMetadata(meta, iri)
+}
+
+func (s wrappedStorage) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
+	k, ok := s.FullStorage.(processing.KeyLoader)
+	if !ok {
+		return nil, errors.NotImplementedf("keys are not supported by the %T storage", s.FullStorage)
+	}
+	return k.LoadKey(iri)
+}
+
+func (s wrappedStorage) CollectionContains(col vocab.IRI, member vocab.IRI) (bool, error) {
+	return st.CollectionContains(s.FullStorage, col, member)
+}
+
+func (s wrappedStorage) CountItems(col vocab.IRI) (uint, error) {
+	return st.CountItems(s.FullStorage, col)
+}
+
+func (s wrappedStorage) IsLocalIRI(iri vocab.IRI) bool {
+	return st.IsLocalIRI(s.FullStorage)(iri)
+}
+
+// serializedStorage serializes the changes to each collection of the storage, for all its users: the processing of
+// the activities, the handlers and the background tasks. The backends which load the items of a collection, change
+// them, and write them back, would otherwise lose one of two concurrent updates to the same collection.
+type serializedStorage struct {
+	wrappedStorage
+	locks *st.CollectionLocks
+}
+
+// withSerializedCollections returns the db storage with the changes to its collections serialized using the locks
+func withSerializedCollections(db FullStorage, locks *st.CollectionLocks) FullStorage {
+	return serializedStorage{wrappedStorage: wrappedStorage{FullStorage: db}, locks: locks}
+}
+
+func (s serializedStorage) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	defer s.locks.Lock(col.GetLink())()
+	return s.wrappedStorage.Create(col)
+}
+
+func (s serializedStorage) AddTo(col vocab.IRI, it vocab.Item) error {
+	defer s.locks.Lock(col)()
+	return s.wrappedStorage.AddTo(col, it)
+}
+
+func (s serializedStorage) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	defer s.locks.Lock(col)()
+	return s.wrappedStorage.RemoveFrom(col, it)
+}
+
+// Reset passes through the resetting of the storage, which the tests use between runs
+func (s serializedStorage) Reset() {
+	if r, ok := s.FullStorage.(st.Resetter); ok {
+		r.Reset()
+	}
+}
+
+// errReadOnly is returned by the write methods of the read-only storage
+func errReadOnly(op string) error {
+	return errors.MethodNotAllowedf("unable to %s, the storage is in read-only mode", op)
+}
+
+// readOnlyStorage rejects all the write methods of the storage before they reach the backend,
+// which is used for read-only replicas of an instance.
+type readOnlyStorage struct {
+	FullStorage
+}
+
+func (s readOnlyStorage) Save(vocab.Item) (vocab.Item, error) {
+	return nil, errReadOnly("save")
+}
+
+func (s readOnlyStorage) Delete(vocab.Item) error {
+	return errReadOnly("delete")
+}
+
+func (s readOnlyStorage) Create(vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	return nil, errReadOnly("create collection")
+}
+
+func (s readOnlyStorage) AddTo(vocab.IRI, vocab.Item) error {
+	return errReadOnly("add to collection")
+}
+
+func (s readOnlyStorage) RemoveFrom(vocab.IRI, vocab.Item) error {
+	return errReadOnly("remove from collection")
+}
+
+func (s readOnlyStorage) PasswordSet(vocab.Item, []byte) error {
+	return errReadOnly("set password")
+}
+
+func (s readOnlyStorage) LoadMetadata(iri vocab.IRI) (*processing.Metadata, error) {
+	if m, ok := s.FullStorage.(st.MetadataTyper); ok {
+		return m.LoadMetadata(iri)
+	}
+	return nil, errors.NotImplementedf("metadata is not supported by the %T storage", s.FullStorage)
+}
+
+func (s readOnlyStorage) CollectionContains(col vocab.IRI, member vocab.IRI) (bool, error) {
+	return st.CollectionContains(s.FullStorage, col, member)
+}
+
+func (s readOnlyStorage) SaveMetadata(processing.Metadata, vocab.IRI) error {
+	return errReadOnly("save metadata")
+}
+
+func (s readOnlyStorage) CreateClient(osin.Client) error {
+	return errReadOnly("create client")
+}
+
+func (s readOnlyStorage) UpdateClient(osin.Client) error {
+	return errReadOnly("update client")
+}
+
+func (s readOnlyStorage) RemoveClient(string) error {
+	return errReadOnly("remove client")
+}
+
+func (s readOnlyStorage) SaveAuthorize(*osin.AuthorizeData) error {
+	return errReadOnly("save authorization")
+}
+
+func (s readOnlyStorage) RemoveAuthorize(string) error {
+	return errReadOnly("remove authorization")
+}
+
+func (s readOnlyStorage) SaveAccess(*osin.AccessData) error {
+	return errReadOnly("save access")
+}
+
+func (s readOnlyStorage) RemoveAccess(string) error {
+	return errReadOnly("remove access")
+}
+
+func (s readOnlyStorage) RemoveRefresh(string) error {
+	return errReadOnly("remove refresh token")
+}
+
+// Clone keeps the clones of the storage, which the OAuth2 server uses for each request, read-only
+func (s readOnlyStorage) Clone() osin.Storage {
+	if c, ok := s.FullStorage.Clone().(FullStorage); ok {
+		return readOnlyStorage{FullStorage: c}
+	}
+	return s
+}
+
+// BoltDBStorageFile returns the path of the file of the boltdb storage for the c configuration
+func BoltDBStorageFile(c config.Options) string {
+	return filepath.Join(c.BaseStoragePath(), st.BoltDBFile)
+}
+
+// checkStorageFile checks that the storage file isn't corrupted, for the backends which keep their data in a
+// single file, which is only boltdb for now. The corrupted files return a st.CorruptedError.
+func checkStorageFile(c config.Options) error {
+	if c.Storage != config.StorageBoltDB {
+		return nil
+	}
+	return st.CheckBoltDBFile(BoltDBStorageFile(c))
+}
+
+// recoveryStorage is a read-only storage for recovering the data of a corrupted storage file, whose reads
+// return errors instead of panicking when they reach the corrupted pages.
+type recoveryStorage struct {
+	readOnlyStorage
+}
+
+// RecoveryStorage returns the db storage opened on a corrupted file, from which we can copy the items which are
+// still readable to a new storage
+func RecoveryStorage(db FullStorage) FullStorage {
+	return recoveryStorage{readOnlyStorage{FullStorage: db}}
+}
+
+// recoverRead converts the panic of a read from a corrupted storage into the err error
+func recoverRead(what interface{}, err *error) {
+	if r := recover(); r != nil {
+		*err = errors.Newf("unable to read %v from the corrupted storage: %s", what, fmt.Sprint(r))
+	}
+}
+
+func (s recoveryStorage) Load(iri vocab.IRI) (it vocab.Item, err error) {
+	defer recoverRead(iri, &err)
+	return s.readOnlyStorage.Load(iri)
+}
+
+func (s recoveryStorage) LoadMetadata(iri vocab.IRI) (m *processing.Metadata, err error) {
+	defer recoverRead(iri, &err)
+	return s.readOnlyStorage.LoadMetadata(iri)
+}
+
+func (s recoveryStorage) ListClients() (cl []osin.Client, err error) {
+	defer recoverRead("the OAuth2 clients", &err)
+	return s.readOnlyStorage.ListClients()
+}
+
+// withStorageOptions checks that the file of the db storage isn't corrupted and that it can be opened, and,
+// when the read-only mode from the c configuration is enabled, returns the read-only storage.
+func withStorageOptions(db FullStorage, c config.Options) (FullStorage, error) {
+	if err := checkStorageFile(c); err != nil {
+		return nil, err
+	}
+	if err := checkStorageOpens(c); err != nil {
+		return nil, err
+	}
+	if c.StorageReadOnly {
+		return readOnlyStorage{FullStorage: db}, nil
+	}
+	return db, nil
+}
@@ -0,0 +1,223 @@
+package fedbox
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/openshift/osin"
+)
+
+// mockFullStorage implements the storage methods used by the tests, the rest of them panic
+type mockFullStorage struct {
+	FullStorage
+	mockCollectionStore
+}
+
+func (m mockFullStorage) Load(iri vocab.IRI) (vocab.Item, error) {
+	return m.mockCollectionStore.Load(iri)
+}
+
+func (m mockFullStorage) Save(it vocab.Item) (vocab.Item, error) {
+	return m.mockCollectionStore.Save(it)
+}
+
+func (m mockFullStorage) Delete(it vocab.Item) error {
+	return m.mockCollectionStore.Delete(it)
+}
+
+func (m mockFullStorage) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	return m.mockCollectionStore.Create(col)
+}
+
+func (m mockFullStorage) AddTo(col vocab.IRI, it vocab.Item) error {
+	return m.mockCollectionStore.AddTo(col, it)
+}
+
+func (m mockFullStorage) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	return m.mockCollectionStore.RemoveFrom(col, it)
+}
+
+func TestCheckStorageOpens(t *testing.T) {
+	c := config.Options{BaseURL: "https://fedbox.local", Storage: config.StorageBoltDB, StoragePath: t.TempDir(), Env: "test"}
+	path := BoltDBStorageFile(c)
+	if err := os.WriteFile(path, []byte{}, 0600); err != nil {
+		t.Fatalf("unable to create the storage file: %s", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unable to open the storage file: %s", err)
+	}
+	defer f.Close()
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatalf("unable to lock the storage file: %s", err)
+	}
+
+	c.StorageOpenTimeout = 50 * time.Millisecond
+	if err = checkStorageOpens(c); !errors.IsTimeout(err) {
+		t.Errorf("checkStorageOpens() for a locked storage returned %v, expected a timeout error", err)
+	}
+	c.StorageOpenTimeout = 0
+	if err = checkStorageOpens(c); err != nil {
+		t.Errorf("checkStorageOpens() without a timeout returned %s", err)
+	}
+	c.Storage, c.StorageOpenTimeout = config.StorageFS, 50*time.Millisecond
+	if err = checkStorageOpens(c); err != nil {
+		t.Errorf("checkStorageOpens() for a storage without a lock file returned %s", err)
+	}
+}
+
+func TestReadOnlyStorage(t *testing.T) {
+	johnDoe := &vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	note := &vocab.Object{ID: "https://fedbox.local/objects/1", Type: vocab.NoteType}
+	outbox := vocab.Outbox.IRI(johnDoe)
+
+	mock := mockFullStorage{mockCollectionStore: mockCollectionStore{mockStore{}}}
+	mock.Save(johnDoe)
+
+	db, err := withStorageOptions(mock, config.Options{BaseURL: "https://fedbox.local", StorageReadOnly: true})
+	if err != nil {
+		t.Fatalf("withStorageOptions() returned %s", err)
+	}
+
+	it, err := db.Load(johnDoe.ID)
+	if err != nil || !firstItem(it).GetLink().Equals(johnDoe.ID, false) {
+		t.Errorf("the read-only storage should load %s, got %v: %v", johnDoe.ID, it, err)
+	}
+
+	cols := db.(interface {
+		Create(vocab.CollectionInterface) (vocab.CollectionInterface, error)
+		AddTo(vocab.IRI, vocab.Item) error
+		RemoveFrom(vocab.IRI, vocab.Item) error
+	})
+	writes := map[string]func() error{
+		"Save":   func() error { _, err := db.Save(note); return err },
+		"Delete": func() error { return db.Delete(johnDoe) },
+		"Create": func() error {
+			_, err := cols.Create(&vocab.OrderedCollection{ID: outbox, Type: vocab.OrderedCollectionType})
+			return err
+		},
+		"AddTo":           func() error { return cols.AddTo(outbox, note) },
+		"RemoveFrom":      func() error { return cols.RemoveFrom(outbox, note) },
+		"PasswordSet":     func() error { return db.PasswordSet(johnDoe, []byte("secret")) },
+		"SaveMetadata":    func() error { return db.(st.MetadataTyper).SaveMetadata(processing.Metadata{}, johnDoe.ID) },
+		"CreateClient":    func() error { return db.CreateClient(&osin.DefaultClient{Id: "client"}) },
+		"UpdateClient":    func() error { return db.UpdateClient(&osin.DefaultClient{Id: "client"}) },
+		"RemoveClient":    func() error { return db.RemoveClient("client") },
+		"SaveAuthorize":   func() error { return db.SaveAuthorize(&osin.AuthorizeData{Code: "code"}) },
+		"RemoveAuthorize": func() error { return db.RemoveAuthorize("code") },
+		"SaveAccess":      func() error { return db.SaveAccess(&osin.AccessData{AccessToken: "token"}) },
+		"RemoveAccess":    func() error { return db.RemoveAccess("token") },
+		"RemoveRefresh":   func() error { return db.RemoveRefresh("token") },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.IsMethodNotAllowed(err) {
+			t.Errorf("%s() on the read-only storage returned %v, expected a method not allowed error", name, err)
+		}
+	}
+	if len(mock.mockStore) != 1 {
+		t.Errorf("the read-only storage has been modified: %v", mock.mockStore)
+	}
+	if _, ok := mock.mockStore[johnDoe.ID]; !ok {
+		t.Errorf("%s has been removed from the read-only storage", johnDoe.ID)
+	}
+
+	db, _ = withStorageOptions(mock, config.Options{BaseURL: "https://fedbox.local"})
+	if _, ok := db.(readOnlyStorage); ok {
+		t.Errorf("withStorageOptions() without the read-only mode should return the storage unchanged")
+	}
+}
+
+// corruptedStore panics when loading the items, like boltdb reaching the missing pages of a truncated file
+type corruptedStore struct {
+	mockFullStorage
+}
+
+func (c corruptedStore) Load(iri vocab.IRI) (vocab.Item, error) {
+	panic("page 42 already freed")
+}
+
+func TestCorruptedStorage(t *testing.T) {
+	c := config.Options{BaseURL: "https://fedbox.local", Storage: config.StorageBoltDB, StoragePath: t.TempDir(), Env: "test"}
+	path := BoltDBStorageFile(c)
+	if err := os.WriteFile(path, []byte("not a boltdb file, truncated by a power loss"), 0600); err != nil {
+		t.Fatalf("unable to write the storage file: %s", err)
+	}
+
+	mock := mockFullStorage{mockCollectionStore: mockCollectionStore{mockStore{}}}
+	if _, err := withStorageOptions(mock, c); !st.IsCorrupted(err) {
+		t.Fatalf("withStorageOptions() for a corrupted file returned %v, expected a corrupted storage error", err)
+	} else if !strings.Contains(err.Error(), "fedboxctl storage repair") {
+		t.Errorf("the corrupted storage error should suggest the repair command: %s", err)
+	}
+
+	db := RecoveryStorage(corruptedStore{mock})
+	if _, err := db.Load("https://fedbox.local/actors/johndoe"); err == nil {
+		t.Errorf("loading from the corrupted storage should return an error instead of panicking")
+	}
+	if _, err := db.Save(&vocab.Object{ID: "https://fedbox.local/objects/1"}); !errors.IsMethodNotAllowed(err) {
+		t.Errorf("the recovery storage should be read-only, Save() returned %v", err)
+	}
+}
+
+// racyCollections adds the items to the collections like the backends which read the list of items, change it
+// and write it back, leaving a window for the concurrent changes to be lost.
+type racyCollections struct {
+	FullStorage
+	m     sync.Mutex
+	items map[vocab.IRI]vocab.IRIs
+}
+
+func (r *racyCollections) AddTo(col vocab.IRI, it vocab.Item) error {
+	r.m.Lock()
+	iris := append(vocab.IRIs{}, r.items[col]...)
+	r.m.Unlock()
+	runtime.Gosched()
+	r.m.Lock()
+	r.items[col] = append(iris, it.GetLink())
+	r.m.Unlock()
+	return nil
+}
+
+func (r *racyCollections) Create(col vocab.CollectionInterface) (vocab.CollectionInterface, error) {
+	return col, nil
+}
+
+func (r *racyCollections) RemoveFrom(col vocab.IRI, it vocab.Item) error {
+	return errors.NotImplementedf("remove from")
+}
+
+func TestSerializedStorage(t *testing.T) {
+	col := vocab.IRI("https://fedbox.local/actors/johndoe/followers")
+	racy := &racyCollections{items: make(map[vocab.IRI]vocab.IRIs)}
+	db, ok := withSerializedCollections(racy, st.NewCollectionLocks()).(processing.CollectionStore)
+	if !ok {
+		t.Fatalf("the serialized storage should support the collections")
+	}
+
+	const count = 100
+	wg := sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.AddTo(col, vocab.IRI(fmt.Sprintf("https://example.com/actors/%d", i))); err != nil {
+				t.Errorf("AddTo() returned error %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(racy.items[col]) != count {
+		t.Errorf("the collection has %d items, expected %d", len(racy.items[col]), count)
+	}
+}
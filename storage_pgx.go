@@ -0,0 +1,24 @@
+//go:build storage_pgx
+
+package fedbox
+
+import (
+	"git.sr.ht/~mariusor/lw"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/storage/pgx"
+)
+
+func Storage(c config.Options, l lw.Logger) (FullStorage, error) {
+	l = l.WithContext(lw.Ctx{"host": c.DB.Host, "db": c.DB.Name})
+	l.Debugf("Initializing PostgreSQL storage")
+	db, err := pgx.New(pgx.Config{
+		DSN:   c.DB.DSN(),
+		LogFn: l.Debugf,
+		ErrFn: l.Warnf,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to connect to PostgreSQL storage")
+	}
+	return db, nil
+}
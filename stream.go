@@ -0,0 +1,175 @@
+package fedbox
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+const (
+	streamPath = "stream"
+
+	// streamBufferSize is the number of activities kept for a subscriber which doesn't read them fast enough,
+	// the ones that don't fit are dropped
+	streamBufferSize = 32
+	// streamKeepAlive is the interval at which we send comments on idle streams, so the proxies don't close them
+	streamKeepAlive = 30 * time.Second
+)
+
+// streamHub dispatches the activities delivered to the inboxes of the local actors to their subscribers
+type streamHub struct {
+	m    sync.RWMutex
+	subs map[vocab.IRI]map[chan vocab.Item]struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{subs: make(map[vocab.IRI]map[chan vocab.Item]struct{})}
+}
+
+// subscribe returns the channel on which the activities delivered to the actor's inbox are received,
+// and the function which cancels the subscription.
+func (h *streamHub) subscribe(actor vocab.IRI) (<-chan vocab.Item, func()) {
+	ch := make(chan vocab.Item, streamBufferSize)
+	h.m.Lock()
+	if h.subs[actor] == nil {
+		h.subs[actor] = make(map[chan vocab.Item]struct{})
+	}
+	h.subs[actor][ch] = struct{}{}
+	h.m.Unlock()
+
+	once := sync.Once{}
+	return ch, func() {
+		once.Do(func() {
+			h.m.Lock()
+			defer h.m.Unlock()
+			delete(h.subs[actor], ch)
+			if len(h.subs[actor]) == 0 {
+				delete(h.subs, actor)
+			}
+		})
+	}
+}
+
+// hasSubscribers checks if anybody is subscribed to the activities of the actor
+func (h *streamHub) hasSubscribers(actor vocab.IRI) bool {
+	if h == nil {
+		return false
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	return len(h.subs[actor]) > 0
+}
+
+// publish sends the it activity to the subscribers of the actor, without waiting for the slow ones
+func (h *streamHub) publish(actor vocab.IRI, it vocab.Item) {
+	if h == nil {
+		return
+	}
+	h.m.RLock()
+	defer h.m.RUnlock()
+	for ch := range h.subs[actor] {
+		select {
+		case ch <- it:
+		default:
+		}
+	}
+}
+
+// streamStore is a storage which publishes the activities added to the inboxes of the local actors to the
+// subscribers of their streams
+type streamStore struct {
+	wrappedStorage
+	hub *streamHub
+}
+
+// withStreams returns the db storage, publishing to hub the activities added to the inboxes
+func withStreams(db FullStorage, hub *streamHub) FullStorage {
+	if _, ok := db.(processing.CollectionStore); !ok || hub == nil {
+		return db
+	}
+	return streamStore{wrappedStorage: wrappedStorage{FullStorage: db}, hub: hub}
+}
+
+func (s streamStore) AddTo(col vocab.IRI, it vocab.Item) error {
+	if err := s.wrappedStorage.AddTo(col, it); err != nil {
+		return err
+	}
+	owner, typ := vocab.Split(col)
+	if typ != vocab.Inbox || !s.hub.hasSubscribers(owner) {
+		return nil
+	}
+	if vocab.IsIRI(it) {
+		loaded, err := s.Load(it.GetLink())
+		if err != nil {
+			return nil
+		}
+		if loaded = firstItem(loaded); vocab.IsNil(loaded) || !loaded.GetLink().Equals(it.GetLink(), false) {
+			return nil
+		}
+		it = loaded
+	}
+	s.hub.publish(owner, it)
+	return nil
+}
+
+// writeStreamEvent writes the it activity as a server-sent event
+func writeStreamEvent(w http.ResponseWriter, it vocab.Item) error {
+	data, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: activity\ndata: %s\n\n", it.GetLink(), data)
+	return err
+}
+
+func handleStream(hub *streamHub, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		act := actorFn(r)
+		if isAnonymous(act) {
+			errors.HandleError(errors.Unauthorizedf("authorization required")).ServeHTTP(w, r)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			errors.HandleError(errors.NotImplementedf("streaming is not supported")).ServeHTTP(w, r)
+			return
+		}
+		activities, unsubscribe := hub.subscribe(act.GetLink())
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case it := <-activities:
+				if err := writeStreamEvent(w, it); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// HandleStream serves the server-sent events stream of the activities delivered to the inbox of the
+// authorized actor, while the client stays connected.
+func HandleStream(fb FedBOX) http.HandlerFunc {
+	return handleStream(fb.streams, fb.actorFromRequest)
+}
@@ -0,0 +1,127 @@
+package fedbox
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	st "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+)
+
+func TestHandleStream(t *testing.T) {
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	inbox := vocab.Inbox.IRI(johnDoe)
+	create := &vocab.Activity{
+		ID:    "https://example.com/activities/1",
+		Type:  vocab.CreateType,
+		Actor: vocab.IRI("https://example.com/users/bob"),
+		To:    vocab.ItemCollection{johnDoe.ID},
+	}
+
+	hub := newStreamHub()
+	db := withStreams(mockFullStorage{mockCollectionStore: mockCollectionStore{mockStore{create.ID: create}}}, hub).(processing.CollectionStore)
+
+	srv := httptest.NewServer(handleStream(hub, func(r *http.Request) vocab.Actor {
+		if r.Header.Get("Authorization") == "" {
+			return vocab.Actor{}
+		}
+		return johnDoe
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unable to request the stream: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("the anonymous request returned status %d, expected %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unable to request the stream: %s", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("the stream has the Content-Type %q", ct)
+	}
+	if !hub.hasSubscribers(johnDoe.ID) {
+		t.Fatalf("there is no subscription for %s", johnDoe.ID)
+	}
+
+	if err = db.AddTo(inbox, create.ID); err != nil {
+		t.Fatalf("unable to add %s to %s: %s", create.ID, inbox, err)
+	}
+
+	event := make(map[string]string)
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			break
+		}
+		if i := strings.Index(line, ": "); i > 0 {
+			event[line[:i]] = line[i+2:]
+		}
+	}
+	if event["event"] != "activity" || event["id"] != create.ID.String() {
+		t.Fatalf("received the event %v, expected the activity %s", event, create.ID)
+	}
+	received, err := vocab.UnmarshalJSON([]byte(event["data"]))
+	if err != nil {
+		t.Fatalf("unable to unmarshal the received activity: %s", err)
+	}
+	if received.GetType() != vocab.CreateType || !received.GetLink().Equals(create.ID, false) {
+		t.Errorf("received %s %s, expected %s %s", received.GetType(), received.GetLink(), create.Type, create.ID)
+	}
+
+	cancel()
+	for i := 0; i < 100 && hub.hasSubscribers(johnDoe.ID); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hub.hasSubscribers(johnDoe.ID) {
+		t.Errorf("the subscription for %s has not been removed after the client disconnected", johnDoe.ID)
+	}
+}
+
+// mockKeyStorage is a storage with the metadata and the keys of the actors
+type mockKeyStorage struct {
+	mockFullStorage
+	mockKeyLoader
+}
+
+func TestStreamStoreForwards(t *testing.T) {
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	db := withStreams(mockKeyStorage{mockKeyLoader: mockKeyLoader{mockMetadata{}}}, newStreamHub())
+	if _, ok := db.(streamStore); !ok {
+		t.Fatalf("withStreams() returned %T, expected the streams storage", db)
+	}
+	m, ok := db.(st.MetadataTyper)
+	if !ok {
+		t.Fatalf("the streams storage should pass through the metadata")
+	}
+	if err := m.SaveMetadata(processing.Metadata{Pw: []byte("dsa")}, johnDoe); err != nil {
+		t.Fatalf("SaveMetadata() returned error %s", err)
+	}
+	if meta, err := m.LoadMetadata(johnDoe); err != nil || string(meta.Pw) != "dsa" {
+		t.Errorf("LoadMetadata() returned %v, %v, expected the saved metadata", meta, err)
+	}
+	if _, err := db.(processing.KeyLoader).LoadKey(johnDoe); !errors.IsNotFound(err) {
+		t.Errorf("LoadKey() returned %v, expected the not found error of the storage", err)
+	}
+	if _, err := st.CountItems(db, vocab.Inbox.IRI(johnDoe)); !errors.IsNotImplemented(err) {
+		t.Errorf("CountItems() for a storage which can't count returned %v, expected a not implemented error", err)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build integration && storage_all
+
+package tests
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/cmd"
+	"github.com/go-ap/fedbox/internal/config"
+	ls "github.com/go-ap/fedbox/storage"
+)
+
+func TestAddAndDeleteActor(t *testing.T) {
+	base := storagePath()
+	defer os.RemoveAll(base)
+
+	self := ap.Self(ap.DefaultServiceIRI("http://127.0.0.1:9998/"))
+	opt, db := openMigrationStorage(t, config.StorageBoltDB, base, &self)
+	ctl := cmd.New(db, opt, lw.Dev(lw.SetLevel(lw.NoLevel)))
+
+	now := time.Now().UTC()
+	alice := &vocab.Person{
+		Type:      vocab.PersonType,
+		Published: now,
+		Updated:   now,
+		PreferredUsername: vocab.NaturalLanguageValues{
+			{Ref: vocab.NilLangRef, Value: vocab.Content("alice")},
+		},
+	}
+	alice, err := ctl.AddActor(alice, []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("AddActor() error = %s", err)
+	}
+	iri := alice.GetLink()
+	if !iri.Contains(vocab.IRI(opt.BaseURL), false) {
+		t.Fatalf("the actor %s is not local to %s", iri, opt.BaseURL)
+	}
+	if err = ctl.AddKey(db.(ls.MetadataTyper), alice, fedbox.KeyTypeED25519); err != nil {
+		t.Fatalf("AddKey() error = %s", err)
+	}
+
+	saved, err := ap.LoadActor(db, iri)
+	if err != nil {
+		t.Fatalf("unable to load the added actor %s: %s", iri, err)
+	}
+	if saved.PublicKey.PublicKeyPem == "" {
+		t.Errorf("the added actor %s doesn't have a public key", iri)
+	}
+	if m, err := db.(ls.MetadataTyper).LoadMetadata(iri); err != nil || len(m.PrivateKey) == 0 {
+		t.Errorf("the added actor %s doesn't have a private key: %v", iri, err)
+	}
+	if err = db.PasswordCheck(alice, []byte("secret")); err != nil {
+		t.Errorf("the password of %s doesn't match: %s", iri, err)
+	}
+	cols := vocab.CollectionPaths{vocab.Inbox, vocab.Outbox, vocab.Followers, vocab.Following, vocab.Liked}
+	for _, col := range cols {
+		if _, err = db.Load(col.IRI(alice)); err != nil {
+			t.Errorf("unable to load the %s collection of %s: %s", col, iri, err)
+		}
+	}
+
+	if err = ctl.DeleteActor(iri); err != nil {
+		t.Fatalf("DeleteActor() error = %s", err)
+	}
+	if deleted, err := ap.LoadActor(db, iri); err == nil && deleted.GetLink().Equals(iri, false) {
+		t.Errorf("the actor %s has not been removed", iri)
+	}
+	for _, col := range cols {
+		if it, err := db.Load(col.IRI(alice)); err == nil && !vocab.IsNil(it) && it.GetLink().Equals(col.IRI(alice), false) {
+			t.Errorf("the %s collection of %s has not been removed", col, iri)
+		}
+	}
+	if err = ctl.DeleteActor(iri); err == nil {
+		t.Errorf("DeleteActor() of a removed actor should return an error")
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	base := storagePath()
+	defer os.RemoveAll(base)
+
+	self := ap.Self(ap.DefaultServiceIRI("http://127.0.0.1:9998/"))
+	opt, db := openMigrationStorage(t, config.StorageBoltDB, base, &self)
+	ctl := cmd.New(db, opt, lw.Dev(lw.SetLevel(lw.NoLevel)))
+
+	bob := &vocab.Person{
+		Type: vocab.PersonType,
+		PreferredUsername: vocab.NaturalLanguageValues{
+			{Ref: vocab.NilLangRef, Value: vocab.Content("bob")},
+		},
+	}
+	bob, err := ctl.AddActor(bob, nil, nil)
+	if err != nil {
+		t.Fatalf("AddActor() error = %s", err)
+	}
+
+	if err = ctl.SetPassword(bob.GetLink(), []byte("s3cr3t")); err != nil {
+		t.Fatalf("SetPassword() error = %s", err)
+	}
+	if err = db.PasswordCheck(bob, []byte("s3cr3t")); err != nil {
+		t.Errorf("the password of %s doesn't match: %s", bob.GetLink(), err)
+	}
+	if err = db.PasswordCheck(bob, []byte("wrong")); err == nil {
+		t.Errorf("a wrong password of %s should not match", bob.GetLink())
+	}
+
+	if err = ctl.SetPassword("https://example.com/actors/bob", []byte("s3cr3t")); err == nil {
+		t.Errorf("SetPassword() for a remote actor should return an error")
+	}
+	if err = ctl.SetPassword(vocab.Outbox.IRI(bob), []byte("s3cr3t")); err == nil {
+		t.Errorf("SetPassword() for a collection should return an error")
+	}
+	if err = ctl.SetPassword(bob.GetLink(), nil); err == nil {
+		t.Errorf("SetPassword() with an empty password should return an error")
+	}
+}
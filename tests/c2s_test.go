@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox/internal/config"
 )
 
 func ActorsURL() string {
@@ -1661,6 +1662,52 @@ var QuestionTests = testPairs{
 	},
 }
 
+var embedFirstPageConfig = func() config.Options {
+	c := C2SConfig
+	c.EmbedFirstPage = true
+	return c
+}()
+
+var EmbedFirstPageTests = testPairs{
+	{
+		name:    "ActorsCollectionWithFirstPage",
+		configs: []config.Options{embedFirstPageConfig},
+		tests: []testPair{
+			{
+				mocks: []string{
+					"mocks/c2s/actors/service.json",
+					"mocks/c2s/actors/actor-johndoe.json",
+				},
+				req: testReq{
+					met: http.MethodGet,
+					url: ActorsURL(),
+				},
+				res: testRes{
+					code: http.StatusOK,
+					val: &objectVal{
+						id:        ActorsURL(),
+						typ:       string(vocab.OrderedCollectionType),
+						itemCount: 2,
+						// NOTE(marius): the embedded page gets compared with the one fetched separately using its id
+						first: &objectVal{
+							typ:       string(vocab.OrderedCollectionPageType),
+							itemCount: 2,
+							items: map[string]*objectVal{
+								"e869bdca-dd5e-4de7-9c5d-37845eccc6a1": {
+									id:                "http://127.0.0.1:9998/actors/e869bdca-dd5e-4de7-9c5d-37845eccc6a1",
+									typ:               string(vocab.PersonType),
+									preferredUsername: "johndoe",
+									name:              "Johnathan Doe",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
 func Test_SingleItemLoad(t *testing.T) {
 	runTestSuite(t, SingleItemLoadTests)
 }
@@ -1677,6 +1724,10 @@ func Test_ActorsCollection(t *testing.T) {
 	runTestSuite(t, ActorsCollectionTests)
 }
 
+func Test_EmbedFirstPage(t *testing.T) {
+	runTestSuite(t, EmbedFirstPageTests)
+}
+
 func Test_C2S_CreateRequests(t *testing.T) {
 	runTestSuite(t, CreateTests)
 }
@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -51,6 +52,7 @@ var C2SConfig = config.Options{
 	LogLevel:    lw.NoLevel,
 	StoragePath: filepath.Join(storagePath(), "127.0.0.1:9998"),
 	Storage:     storageType(),
+	DB:          storageDB("fedbox_c2s"),
 }
 
 var S2SConfig = config.Options{
@@ -61,6 +63,7 @@ var S2SConfig = config.Options{
 	LogLevel:    lw.NoLevel,
 	StoragePath: filepath.Join(storagePath(), "127.0.2.1:9999"),
 	Storage:     storageType(),
+	DB:          storageDB("fedbox_s2s"),
 }
 
 var c2sConfigs = []config.Options{
@@ -180,12 +183,33 @@ var storagePath = func() string {
 
 var storageType = func() config.StorageType {
 	envStorage := config.Getval("STORAGE", "all")
+	if envStorage == "pgx" {
+		// NOTE(marius): the storage_pgx build tag corresponds to the postgres storage type
+		return config.StoragePostgres
+	}
 	if len(envStorage) > 0 {
 		return config.StorageType(envStorage)
 	}
 	return config.DefaultStorage
 }
 
+// storageDB returns the connection to the name PostgreSQL database, when running the tests against the
+// postgres storage. Each instance uses its own database, which needs to exist before running the tests.
+var storageDB = func(name string) config.BackendConfig {
+	if storageType() != config.StoragePostgres {
+		return config.BackendConfig{}
+	}
+	port, _ := strconv.ParseInt(config.Getval(config.KeyDBPort, "5432"), 10, 64)
+	return config.BackendConfig{
+		Enabled: true,
+		Host:    config.Getval(config.KeyDBHost, "localhost"),
+		Port:    port,
+		User:    config.Getval(config.KeyDBUser, "fedbox"),
+		Pw:      config.Getval(config.KeyDBPw, ""),
+		Name:    name,
+	}
+}
+
 func defaultC2SAccount() *testAccount {
 	return &defaultTestAccountC2S
 }
@@ -395,6 +419,10 @@ func errOnMapProp(t *testing.T) mapFieldAssertFn {
 						assertTrue(v1 == tt.id, "Invalid %q, %q expected in %#v", "id", v1, tt)
 					}
 					if okB {
+						if id, ok := v2["id"].(string); ok && tt.id == "" {
+							// the id was empty - probably an embedded object, which we can dereference later
+							tt.id = id
+						}
 						assertObjectProperties(v2, tt)
 					}
 				}
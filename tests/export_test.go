@@ -0,0 +1,123 @@
+//go:build integration && storage_all
+
+package tests
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/cmd"
+	"github.com/go-ap/fedbox/internal/config"
+	ls "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/openshift/osin"
+)
+
+func TestExportImport(t *testing.T) {
+	base := storagePath()
+	defer os.RemoveAll(base)
+
+	self := ap.Self(ap.DefaultServiceIRI("http://127.0.0.1:9998/"))
+	_, src := openMigrationStorage(t, config.StorageBoltDB, base, &self)
+
+	mocks := make(vocab.ItemCollection, 0)
+	for _, path := range []string{
+		"mocks/c2s/actors/actor-johndoe.json",
+		"mocks/c2s/activities/create-1.json",
+		"mocks/c2s/objects/note-1.json",
+	} {
+		mocks = append(mocks, loadMockFromDisk(path, nil))
+	}
+	if err := addMockObjects(src, mocks); err != nil {
+		t.Fatalf("unable to save the mock objects: %s", err)
+	}
+	johnDoe := mocks[0]
+	outbox := vocab.Outbox.IRI(johnDoe)
+	if err := src.(processing.CollectionStore).AddTo(outbox, mocks[1]); err != nil {
+		t.Fatalf("unable to add %s to %s: %s", mocks[1].GetLink(), outbox, err)
+	}
+	if err := src.PasswordSet(johnDoe, []byte("secret")); err != nil {
+		t.Fatalf("unable to set the password: %s", err)
+	}
+	client := osin.DefaultClient{Id: "export-client", Secret: "secret", RedirectUri: "http://127.0.0.1:9998/callback"}
+	if err := src.CreateClient(&client); err != nil {
+		t.Fatalf("unable to save the client: %s", err)
+	}
+
+	t.Run("without secrets", func(t *testing.T) {
+		buf := bytes.Buffer{}
+		stats, err := cmd.Export(src, &self, &buf, false)
+		if err != nil {
+			t.Fatalf("Export() error = %s", err)
+		}
+		if stats.Metadata > 0 || stats.Clients > 0 || strings.Contains(buf.String(), `"metadata"`) {
+			t.Errorf("Export() without secrets contains metadata or clients: %s", stats)
+		}
+	})
+
+	buf := bytes.Buffer{}
+	if _, err := cmd.Export(src, &self, &buf, true); err != nil {
+		t.Fatalf("Export() error = %s", err)
+	}
+	export := buf.String()
+
+	_, dst := openMigrationStorage(t, config.StorageFS, base, &self)
+	stats, err := cmd.Import(dst, strings.NewReader(export), t.Logf)
+	if err != nil {
+		t.Fatalf("Import() error = %s", err)
+	}
+	if stats.Errors > 0 {
+		t.Errorf("Import() reported %d errors", stats.Errors)
+	}
+
+	for _, it := range mocks {
+		want, err := src.Load(it.GetLink())
+		if err != nil {
+			t.Fatalf("unable to load %s from the source storage: %s", it.GetLink(), err)
+		}
+		got, err := dst.Load(it.GetLink())
+		if err != nil {
+			t.Errorf("unable to load imported %s: %s", it.GetLink(), err)
+			continue
+		}
+		if !bytes.Equal(marshalForCompare(t, want), marshalForCompare(t, got)) {
+			t.Errorf("imported %s is different:\n%s\nexpected:\n%s", it.GetLink(), marshalForCompare(t, got), marshalForCompare(t, want))
+		}
+	}
+	col, err := dst.Load(outbox)
+	if err != nil {
+		t.Fatalf("unable to load imported %s: %s", outbox, err)
+	}
+	vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+		if !c.Contains(mocks[1].GetLink()) {
+			t.Errorf("imported %s doesn't contain %s", outbox, mocks[1].GetLink())
+		}
+		return nil
+	})
+	if err = dst.PasswordCheck(johnDoe, []byte("secret")); err != nil {
+		t.Errorf("imported password doesn't match: %s", err)
+	}
+	wantMeta, _ := src.(ls.MetadataTyper).LoadMetadata(self.GetLink())
+	gotMeta, err := dst.(ls.MetadataTyper).LoadMetadata(self.GetLink())
+	if wantMeta != nil && (err != nil || !bytes.Equal(wantMeta.PrivateKey, gotMeta.PrivateKey)) {
+		t.Errorf("imported service private key is different: %v", err)
+	}
+	if _, err = dst.GetClient(client.Id); err != nil {
+		t.Errorf("unable to load the imported client: %s", err)
+	}
+
+	// NOTE(marius): exporting the imported storage results in the same items
+	again := bytes.Buffer{}
+	if _, err = cmd.Export(dst, &self, &again, true); err != nil {
+		t.Fatalf("Export() of the imported storage error = %s", err)
+	}
+	for _, it := range mocks {
+		if !strings.Contains(again.String(), `"id":"`+it.GetLink().String()+`"`) {
+			t.Errorf("second export doesn't contain %s", it.GetLink())
+		}
+	}
+}
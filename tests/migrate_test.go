@@ -0,0 +1,150 @@
+//go:build integration && storage_all
+
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/cmd"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/fedbox/internal/env"
+	ls "github.com/go-ap/fedbox/storage"
+	"github.com/go-ap/processing"
+	"github.com/openshift/osin"
+)
+
+func openMigrationStorage(t *testing.T, typ config.StorageType, base string, self vocab.Item) (config.Options, fedbox.FullStorage) {
+	opt := config.Options{
+		Env:         env.TEST,
+		BaseURL:     "http://127.0.0.1:9998/",
+		StoragePath: filepath.Join(base, string(typ)),
+		Storage:     typ,
+	}
+	if err := cmd.Bootstrap(opt, self); err != nil {
+		t.Fatalf("unable to bootstrap %s storage: %s", typ, err)
+	}
+	db, err := fedbox.Storage(opt, lw.Dev(lw.SetLevel(lw.NoLevel)))
+	if err != nil {
+		t.Fatalf("unable to open %s storage: %s", typ, err)
+	}
+	t.Cleanup(db.Close)
+	return opt, db
+}
+
+func marshalForCompare(t *testing.T, it vocab.Item) []byte {
+	if vocab.IsItemCollection(it) {
+		vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+			if col.Count() > 0 {
+				it = col.Collection().First()
+			}
+			return nil
+		})
+	}
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		t.Fatalf("unable to marshal %s: %s", it.GetLink(), err)
+	}
+	return raw
+}
+
+func TestMigrateStorage(t *testing.T) {
+	base := storagePath()
+	defer os.RemoveAll(base)
+
+	self := ap.Self(ap.DefaultServiceIRI("http://127.0.0.1:9998/"))
+	_, src := openMigrationStorage(t, config.StorageBoltDB, base, &self)
+
+	mocks := make(vocab.ItemCollection, 0)
+	for _, path := range []string{
+		"mocks/c2s/actors/actor-johndoe.json",
+		"mocks/c2s/activities/create-1.json",
+		"mocks/c2s/objects/note-1.json",
+	} {
+		mocks = append(mocks, loadMockFromDisk(path, nil))
+	}
+	if err := addMockObjects(src, mocks); err != nil {
+		t.Fatalf("unable to save the mock objects: %s", err)
+	}
+	johnDoe := mocks[0]
+	outbox := vocab.Outbox.IRI(johnDoe)
+	if err := src.(processing.CollectionStore).AddTo(outbox, mocks[1]); err != nil {
+		t.Fatalf("unable to add %s to %s: %s", mocks[1].GetLink(), outbox, err)
+	}
+	if err := saveMetadataForActor(testAccount{Id: johnDoe.GetLink().String(), PrivateKey: defaultTestAccountC2S.PrivateKey}, src.(ls.MetadataTyper)); err != nil {
+		t.Fatalf("unable to save the metadata: %s", err)
+	}
+	if err := src.PasswordSet(johnDoe, []byte("secret")); err != nil {
+		t.Fatalf("unable to set the password: %s", err)
+	}
+	client := osin.DefaultClient{Id: "migrate-client", Secret: "secret", RedirectUri: "http://127.0.0.1:9998/callback"}
+	if err := src.CreateClient(&client); err != nil {
+		t.Fatalf("unable to save the client: %s", err)
+	}
+
+	_, dst := openMigrationStorage(t, config.StorageFS, base, &self)
+
+	stats, err := cmd.MigrateStorage(src, dst, &self, t.Logf)
+	if err != nil {
+		t.Fatalf("MigrateStorage() error = %s", err)
+	}
+	if stats.Errors > 0 {
+		t.Errorf("MigrateStorage() reported %d errors", stats.Errors)
+	}
+
+	for _, it := range mocks {
+		want, err := src.Load(it.GetLink())
+		if err != nil {
+			t.Fatalf("unable to load %s from the source storage: %s", it.GetLink(), err)
+		}
+		got, err := dst.Load(it.GetLink())
+		if err != nil {
+			t.Errorf("unable to load %s from the destination storage: %s", it.GetLink(), err)
+			continue
+		}
+		if !bytes.Equal(marshalForCompare(t, want), marshalForCompare(t, got)) {
+			t.Errorf("migrated %s is different:\n%s\nexpected:\n%s", it.GetLink(), marshalForCompare(t, got), marshalForCompare(t, want))
+		}
+	}
+
+	col, err := dst.Load(outbox)
+	if err != nil {
+		t.Fatalf("unable to load %s from the destination storage: %s", outbox, err)
+	}
+	vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+		if !c.Contains(mocks[1].GetLink()) {
+			t.Errorf("migrated %s doesn't contain %s", outbox, mocks[1].GetLink())
+		}
+		return nil
+	})
+
+	wantMeta, _ := src.(ls.MetadataTyper).LoadMetadata(johnDoe.GetLink())
+	gotMeta, err := dst.(ls.MetadataTyper).LoadMetadata(johnDoe.GetLink())
+	if err != nil {
+		t.Fatalf("unable to load the migrated metadata: %s", err)
+	}
+	if !bytes.Equal(wantMeta.PrivateKey, gotMeta.PrivateKey) {
+		t.Errorf("migrated private key is different")
+	}
+	if err = dst.PasswordCheck(johnDoe, []byte("secret")); err != nil {
+		t.Errorf("migrated password doesn't match: %s", err)
+	}
+	if _, err = dst.GetClient(client.Id); err != nil {
+		t.Errorf("unable to load the migrated client: %s", err)
+	}
+
+	// NOTE(marius): running the migration again doesn't copy anything new
+	again, err := cmd.MigrateStorage(src, dst, &self, t.Logf)
+	if err != nil {
+		t.Fatalf("resumed MigrateStorage() error = %s", err)
+	}
+	if again.Items > 0 || again.Members > 0 || again.Clients > 0 {
+		t.Errorf("resumed MigrateStorage() copied again: %s", again)
+	}
+}
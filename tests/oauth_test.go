@@ -0,0 +1,390 @@
+//go:build integration
+
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"git.sr.ht/~mariusor/lw"
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/fedbox"
+	ap "github.com/go-ap/fedbox/activitypub"
+	"github.com/go-ap/fedbox/internal/cmd"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+func runOAuth2TestFedBOX(t *testing.T, options config.Options) *fedbox.FedBOX {
+	self := ap.Self(ap.DefaultServiceIRI(options.BaseURL))
+	if err := cmd.Bootstrap(options, self); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	app, err := RunTestFedBOX(options)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	go app.Run(context.TODO())
+
+	mocks := []string{
+		"mocks/c2s/actors/service.json",
+		"mocks/c2s/actors/actor-johndoe.json",
+		"mocks/c2s/actors/application.json",
+	}
+	l := lw.Dev(lw.SetLevel(lw.DebugLevel)).WithContext(lw.Ctx{"action": "seeding"})
+	if err := saveMocks(mocks, app, l); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return app
+}
+
+func revokeToken(t *testing.T, clientID, secret, token, hint string) int {
+	form := url.Values{}
+	form.Set("token", token)
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/oauth/revoke", apiURL), strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("unable to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", UserAgent)
+	if clientID != "" {
+		req.SetBasicAuth(clientID, secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("revoke request failed: %s", err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+// revokeTokenWithParams sends the client credentials as POST parameters, which the server doesn't allow
+func revokeTokenWithParams(t *testing.T, clientID, secret, token string) int {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("client_id", clientID)
+	form.Set("client_secret", secret)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/oauth/revoke", apiURL), strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("unable to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("revoke request failed: %s", err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func Test_OAuth2_RevokeToken(t *testing.T) {
+	options := C2SConfig
+	options.PrivateLiked = true
+	app := runOAuth2TestFedBOX(t, options)
+	defer cleanDB(t, options)
+
+	acc := defaultC2SAccount()
+	clientID := path.Base(defaultTestApp.Id)
+	likedURL := fmt.Sprintf("%s/liked", acc.Id)
+
+	// NOTE: the private liked collection is visible only to an authorized owner
+	errNotOKGetRequest(t)(likedURL, acc)
+
+	if st := revokeToken(t, "", "", acc.AuthToken, "access_token"); st != http.StatusUnauthorized {
+		t.Errorf("revoking without client credentials returned %d, expected %d", st, http.StatusUnauthorized)
+	}
+	if st := revokeToken(t, clientID, "wrong", acc.AuthToken, "access_token"); st != http.StatusUnauthorized {
+		t.Errorf("revoking with invalid client credentials returned %d, expected %d", st, http.StatusUnauthorized)
+	}
+	if st := revokeTokenWithParams(t, clientID, "hahah", acc.AuthToken); st != http.StatusUnauthorized {
+		t.Errorf("revoking with the client credentials in the parameters returned %d, expected %d", st, http.StatusUnauthorized)
+	}
+	if st := revokeToken(t, clientID, "hahah", acc.AuthToken, "access_token"); st != http.StatusOK {
+		t.Fatalf("revoking the token returned %d, expected %d", st, http.StatusOK)
+	}
+	if _, err := app.Storage().LoadAccess(acc.AuthToken); err == nil {
+		t.Errorf("access token %s should have been removed from storage", acc.AuthToken)
+	}
+	getRequest(t, http.StatusUnauthorized)(likedURL, acc)
+
+	if st := revokeToken(t, clientID, "hahah", "unknown-token", ""); st != http.StatusOK {
+		t.Errorf("revoking an unknown token returned %d, expected %d", st, http.StatusOK)
+	}
+}
+
+func tokenRequest(t *testing.T, clientID, secret string, form url.Values) (int, map[string]interface{}) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/oauth/token", apiURL), strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("unable to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", UserAgent)
+	req.SetBasicAuth(clientID, secret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("token request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	res := make(map[string]interface{})
+	if resp.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("unable to decode token response: %s", err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func Test_OAuth2_RefreshToken(t *testing.T) {
+	options := C2SConfig
+	app := runOAuth2TestFedBOX(t, options)
+	defer cleanDB(t, options)
+
+	acc := defaultC2SAccount()
+	clientID := path.Base(defaultTestApp.Id)
+	pw := "dsa"
+
+	db := app.Storage()
+	if err := db.PasswordSet(vocab.IRI(acc.Id), []byte(pw)); err != nil {
+		t.Fatalf("unable to set password for %s: %s", acc.Id, err)
+	}
+
+	st, res := tokenRequest(t, clientID, "hahah", url.Values{
+		"grant_type": {"password"},
+		"username":   {acc.Id},
+		"password":   {pw},
+	})
+	if st != http.StatusOK {
+		t.Fatalf("password grant returned %d, expected %d", st, http.StatusOK)
+	}
+	access, _ := res["access_token"].(string)
+	refresh, _ := res["refresh_token"].(string)
+	if access == "" || refresh == "" {
+		t.Fatalf("password grant should return both access and refresh tokens: %v", res)
+	}
+
+	st, res = tokenRequest(t, clientID, "hahah", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refresh},
+	})
+	if st != http.StatusOK {
+		t.Fatalf("refresh grant returned %d, expected %d", st, http.StatusOK)
+	}
+	newAccess, _ := res["access_token"].(string)
+	newRefresh, _ := res["refresh_token"].(string)
+	if newAccess == "" || newAccess == access {
+		t.Errorf("refresh grant should return a new access token, received %q", newAccess)
+	}
+	if newRefresh == "" || newRefresh == refresh {
+		t.Errorf("refresh grant should rotate the refresh token, received %q", newRefresh)
+	}
+
+	if _, err := db.LoadAccess(access); err == nil {
+		t.Errorf("old access token %s should have been removed", access)
+	}
+	if _, err := db.LoadRefresh(refresh); err == nil {
+		t.Errorf("old refresh token %s should have been removed", refresh)
+	}
+	if ad, err := db.LoadAccess(newAccess); err != nil || ad == nil {
+		t.Errorf("new access token %s should be valid: %v", newAccess, err)
+	}
+
+	if st, _ = tokenRequest(t, clientID, "hahah", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refresh},
+	}); st == http.StatusOK {
+		t.Errorf("the old refresh token should not be usable anymore")
+	}
+}
+
+const testClientRedirectURI = "http://127.0.0.1:9998/callback"
+
+func authorizationCodeRequest(t *testing.T, clientID, handle, pw string, q url.Values) string {
+	q.Set("response_type", "code")
+	q.Set("client_id", clientID)
+	q.Set("redirect_uri", testClientRedirectURI)
+	q.Set("state", "state")
+
+	form := url.Values{}
+	form.Set("handle", handle)
+	form.Set("pw", pw)
+	u := fmt.Sprintf("%s/oauth/authorize?%s", apiURL, q.Encode())
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("unable to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", UserAgent)
+
+	c := http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("authorize request failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize request returned %d, expected %d", resp.StatusCode, http.StatusFound)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect location: %s", err)
+	}
+	code := loc.Query().Get("code")
+	if code == "" {
+		t.Fatalf("authorize request didn't return an authorization code: %s", loc)
+	}
+	return code
+}
+
+func Test_OAuth2_PKCE(t *testing.T) {
+	options := C2SConfig
+	app := runOAuth2TestFedBOX(t, options)
+	defer cleanDB(t, options)
+
+	acc := defaultC2SAccount()
+	clientID := path.Base(defaultTestApp.Id)
+	pw := "dsa"
+	if err := app.Storage().PasswordSet(vocab.IRI(acc.Id), []byte(pw)); err != nil {
+		t.Fatalf("unable to set password for %s: %s", acc.Id, err)
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		verifier string
+		wantOK   bool
+	}{
+		{
+			name:     "S256 success",
+			verifier: verifier,
+			wantOK:   true,
+		},
+		{
+			name:     "verifier mismatch",
+			verifier: "Xrj3Y9YNt9QK7gCg0qb1OJ0Wn-f4YLs7jPYpYj1aj7BzBbt8gvNxQ",
+		},
+		{
+			name:     "missing verifier",
+			verifier: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := authorizationCodeRequest(t, clientID, acc.Handle, pw, url.Values{
+				"code_challenge":        {challenge},
+				"code_challenge_method": {"S256"},
+			})
+			form := url.Values{
+				"grant_type":   {"authorization_code"},
+				"code":         {code},
+				"redirect_uri": {testClientRedirectURI},
+			}
+			if tt.verifier != "" {
+				form.Set("code_verifier", tt.verifier)
+			}
+			st, res := tokenRequest(t, clientID, "hahah", form)
+			if tt.wantOK {
+				if st != http.StatusOK {
+					t.Fatalf("code exchange returned %d, expected %d", st, http.StatusOK)
+				}
+				if tok, _ := res["access_token"].(string); tok == "" {
+					t.Errorf("code exchange didn't return an access token: %v", res)
+				}
+				return
+			}
+			if st == http.StatusOK {
+				t.Errorf("code exchange should have been rejected, received %v", res)
+			}
+		})
+	}
+}
+
+func registerClient(t *testing.T, metadata map[string]interface{}) (int, map[string]interface{}) {
+	body, _ := json.Marshal(metadata)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/oauth/register", apiURL), bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("unable to create request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("registration request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	res := make(map[string]interface{})
+	if resp.StatusCode == http.StatusCreated {
+		if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("unable to decode registration response: %s", err)
+		}
+	}
+	return resp.StatusCode, res
+}
+
+func Test_OAuth2_RegisterClient(t *testing.T) {
+	options := C2SConfig
+	app := runOAuth2TestFedBOX(t, options)
+	defer cleanDB(t, options)
+
+	if st, _ := registerClient(t, map[string]interface{}{
+		"client_name":   "insecure",
+		"redirect_uris": []string{"http://example.com/callback"},
+	}); st != http.StatusBadRequest {
+		t.Errorf("registering a client with a non https redirect URI returned %d, expected %d", st, http.StatusBadRequest)
+	}
+
+	st, res := registerClient(t, map[string]interface{}{
+		"client_name":   "test-client",
+		"redirect_uris": []string{testClientRedirectURI},
+		"scope":         "read write",
+	})
+	if st != http.StatusCreated {
+		t.Fatalf("registering the client returned %d, expected %d", st, http.StatusCreated)
+	}
+	clientID, _ := res["client_id"].(string)
+	secret, _ := res["client_secret"].(string)
+	if clientID == "" || secret == "" {
+		t.Fatalf("registration response should contain the client credentials: %v", res)
+	}
+	if name, _ := res["client_name"].(string); name != "test-client" {
+		t.Errorf("registration response client_name %q, expected %q", name, "test-client")
+	}
+
+	acc := defaultC2SAccount()
+	pw := "dsa"
+	if err := app.Storage().PasswordSet(vocab.IRI(acc.Id), []byte(pw)); err != nil {
+		t.Fatalf("unable to set password for %s: %s", acc.Id, err)
+	}
+	code := authorizationCodeRequest(t, clientID, acc.Handle, pw, url.Values{})
+	st, res = tokenRequest(t, clientID, secret, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {testClientRedirectURI},
+	})
+	if st != http.StatusOK {
+		t.Fatalf("code exchange with the registered client returned %d, expected %d", st, http.StatusOK)
+	}
+	if tok, _ := res["access_token"].(string); tok == "" {
+		t.Errorf("code exchange didn't return an access token: %v", res)
+	}
+}
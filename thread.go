@@ -0,0 +1,192 @@
+package fedbox
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+const (
+	threadPath = "thread"
+	// threadByKey is the query parameter for choosing the property we follow when resolving the thread
+	threadByKey = "by"
+)
+
+// nextFn returns the item that the o object references in the chain we're resolving
+type nextFn func(o *vocab.Object) vocab.Item
+
+func byInReplyTo(o *vocab.Object) vocab.Item {
+	return o.InReplyTo
+}
+
+func byContext(o *vocab.Object) vocab.Item {
+	return o.Context
+}
+
+// firstItem returns the first element of an item collection, or the item itself otherwise.
+func firstItem(it vocab.Item) vocab.Item {
+	if vocab.IsNil(it) || !vocab.IsItemCollection(it) {
+		return it
+	}
+	var first vocab.Item
+	vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+		if items := col.Collection(); len(items) > 0 {
+			first = items[0]
+		}
+		return nil
+	})
+	return first
+}
+
+// loadChain walks the chain of objects referenced by the next property, starting from the it object,
+// and loads them from the db storage. It stops after maxDepth objects, when an object can't be loaded locally,
+// or when an object references one that we already visited, in which case the circular return value is true.
+// The returned chain doesn't contain the it object.
+func loadChain(db processing.ReadStore, it vocab.Item, next nextFn, maxDepth int) (chain vocab.ItemCollection, circular bool, err error) {
+	if vocab.IsNil(it) {
+		return nil, false, nil
+	}
+	visited := vocab.IRIs{it.GetLink()}
+	cur := it
+	for maxDepth <= 0 || len(chain) < maxDepth {
+		var parent vocab.Item
+		vocab.OnObject(cur, func(o *vocab.Object) error {
+			parent = firstItem(next(o))
+			return nil
+		})
+		if vocab.IsNil(parent) {
+			break
+		}
+		iri := parent.GetLink()
+		if visited.Contains(iri) {
+			return chain, true, nil
+		}
+		visited = append(visited, iri)
+		if parent.IsLink() {
+			loaded, err := db.Load(iri)
+			if err != nil && !errors.IsNotFound(err) {
+				return chain, false, err
+			}
+			if loaded = firstItem(loaded); vocab.IsNil(loaded) {
+				// NOTE(marius): we don't have the object locally, so the chain ends with its IRI
+				chain = append(chain, iri)
+				break
+			}
+			parent = loaded
+		}
+		chain = append(chain, parent)
+		cur = parent
+	}
+	return chain, false, nil
+}
+
+// LoadThread returns the objects that ob is replying to, starting with its direct parent, up to maxDepth of them.
+// Circular reply chains are cut at the first object which was already part of the thread, unless the reject
+// parameter is true, in which case an error is returned.
+func LoadThread(db processing.ReadStore, ob vocab.Item, maxDepth int, reject bool) (vocab.ItemCollection, error) {
+	return loadThread(db, ob, byInReplyTo, maxDepth, reject)
+}
+
+func loadThread(db processing.ReadStore, ob vocab.Item, next nextFn, maxDepth int, reject bool) (vocab.ItemCollection, error) {
+	chain, circular, err := loadChain(db, ob, next, maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	if circular && reject {
+		return nil, errors.NotValidf("%s is part of a circular thread", ob.GetLink())
+	}
+	return chain, nil
+}
+
+// isVisibleTo checks if the act actor can see the it object, which is true when the object is public, when it
+// doesn't have any recipients, like the actors, or if act is one of its recipients or authors.
+// The object is public when it's addressed to the Public collection, with any of the forms the clients use for it.
+func isVisibleTo(it vocab.Item, act vocab.Actor) bool {
+	if it.IsLink() {
+		return true
+	}
+	visible := false
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		recipients := allRecipients(o)
+		if len(recipients) == 0 || containsPublic(recipients) {
+			visible = true
+			return nil
+		}
+		if isAnonymous(act) {
+			return nil
+		}
+		visible = recipients.Contains(act.GetLink()) ||
+			(!vocab.IsNil(o.AttributedTo) && o.AttributedTo.GetLink().Equals(act.GetLink(), false))
+		return nil
+	})
+	return visible
+}
+
+// HandleThread serves the chain of objects which the requested object replies to, or, when the "by" query
+// parameter is "context", the chain of its contexts.
+func HandleThread(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, err := url.Parse(reqURL(r, fb.Config().Secure))
+		if err != nil {
+			errors.HandleError(errors.NewBadRequest(err, "invalid request URL")).ServeHTTP(w, r)
+			return
+		}
+		next := byInReplyTo
+		if r.URL.Query().Get(threadByKey) == "context" {
+			next = byContext
+		}
+		u.RawQuery = ""
+		threadIRI := vocab.IRI(strings.TrimSuffix(u.String(), "/"))
+		ob := vocab.IRI(strings.TrimSuffix(threadIRI.String(), "/"+threadPath))
+
+		it, err := fb.storage.Load(ob)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if it = firstItem(it); vocab.IsNil(it) {
+			errors.HandleError(errors.NotFoundf("%s not found", ob)).ServeHTTP(w, r)
+			return
+		}
+		act := fb.actorFromRequest(r)
+		if !isVisibleTo(it, act) {
+			errors.HandleError(errors.NotFoundf("%s not found", ob)).ServeHTTP(w, r)
+			return
+		}
+		remote, cancel := fb.remote.forRequest(r)
+		defer cancel()
+		chain, err := loadThread(remote, it, next, fb.conf.ThreadMaxDepth, fb.conf.RejectCircularThreads)
+		if err != nil {
+			fb.errFn("unable to load thread for %s: %+s", ob, err)
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		items := make(vocab.ItemCollection, 0, len(chain))
+		for _, parent := range chain {
+			if !isVisibleTo(parent, act) {
+				// NOTE(marius): the private objects are replaced by their IRIs
+				parent = parent.GetLink()
+			}
+			items = append(items, parent)
+		}
+		col := vocab.OrderedCollection{
+			ID:           threadIRI,
+			Type:         vocab.OrderedCollectionType,
+			OrderedItems: items,
+			TotalItems:   uint(len(items)),
+		}
+		data, err := vocab.MarshalJSON(&col)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
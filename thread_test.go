@@ -0,0 +1,115 @@
+package fedbox
+
+import (
+	"fmt"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func noteIRI(i int) vocab.IRI {
+	return vocab.IRI(fmt.Sprintf("https://fedbox.local/objects/note-%d", i))
+}
+
+// replyChain creates a chain of count notes, each of them replying to the previous one,
+// with the first one replying to the cycleTo note, when it's not negative.
+func replyChain(count, cycleTo int) mockStore {
+	db := mockStore{}
+	for i := 0; i < count; i++ {
+		n := &vocab.Object{ID: noteIRI(i), Type: vocab.NoteType, To: vocab.ItemCollection{vocab.PublicNS}}
+		if i > 0 {
+			n.InReplyTo = noteIRI(i - 1)
+			n.Context = noteIRI(i - 1)
+		} else if cycleTo >= 0 {
+			n.InReplyTo = noteIRI(cycleTo)
+			n.Context = noteIRI(cycleTo)
+		}
+		db.Save(n)
+	}
+	return db
+}
+
+func TestLoadThread(t *testing.T) {
+	t.Run("linear thread", func(t *testing.T) {
+		db := replyChain(4, -1)
+		thread, err := LoadThread(db, db[noteIRI(3)], 0, false)
+		if err != nil {
+			t.Fatalf("LoadThread() returned error %s", err)
+		}
+		want := vocab.IRIs{noteIRI(2), noteIRI(1), noteIRI(0)}
+		if len(thread) != len(want) {
+			t.Fatalf("LoadThread() returned %d items, expected %d", len(thread), len(want))
+		}
+		for i, it := range thread {
+			if !it.GetLink().Equals(want[i], false) {
+				t.Errorf("thread item %d is %s, expected %s", i, it.GetLink(), want[i])
+			}
+		}
+	})
+	t.Run("circular thread", func(t *testing.T) {
+		db := replyChain(4, 3)
+		thread, err := LoadThread(db, db[noteIRI(3)], 0, false)
+		if err != nil {
+			t.Fatalf("LoadThread() returned error %s", err)
+		}
+		want := vocab.IRIs{noteIRI(2), noteIRI(1), noteIRI(0)}
+		if len(thread) != len(want) {
+			t.Fatalf("LoadThread() returned %d items for a circular thread, expected %d", len(thread), len(want))
+		}
+		for i, it := range thread {
+			if !it.GetLink().Equals(want[i], false) {
+				t.Errorf("thread item %d is %s, expected %s", i, it.GetLink(), want[i])
+			}
+		}
+		if _, err = LoadThread(db, db[noteIRI(3)], 0, true); !errors.IsNotValid(err) {
+			t.Errorf("LoadThread() for a circular thread should return a not valid error, received %v", err)
+		}
+	})
+	t.Run("self reply", func(t *testing.T) {
+		db := replyChain(1, 0)
+		thread, err := LoadThread(db, db[noteIRI(0)], 0, false)
+		if err != nil {
+			t.Fatalf("LoadThread() returned error %s", err)
+		}
+		if len(thread) != 0 {
+			t.Errorf("LoadThread() returned %d items for an object replying to itself, expected none", len(thread))
+		}
+	})
+	t.Run("max depth", func(t *testing.T) {
+		db := replyChain(10, -1)
+		thread, err := LoadThread(db, db[noteIRI(9)], 3, false)
+		if err != nil {
+			t.Fatalf("LoadThread() returned error %s", err)
+		}
+		if len(thread) != 3 {
+			t.Errorf("LoadThread() returned %d items, expected the maximum depth %d", len(thread), 3)
+		}
+	})
+	t.Run("circular context", func(t *testing.T) {
+		db := replyChain(3, 1)
+		chain, err := loadThread(db, db[noteIRI(2)], byContext, 0, false)
+		if err != nil {
+			t.Fatalf("loadThread() returned error %s", err)
+		}
+		if len(chain) != 2 {
+			t.Errorf("loadThread() returned %d items for a circular context chain, expected %d", len(chain), 2)
+		}
+	})
+}
+
+func TestIsVisibleTo(t *testing.T) {
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	public := &vocab.Object{ID: noteIRI(0), To: vocab.ItemCollection{vocab.PublicNS}}
+	private := &vocab.Object{ID: noteIRI(1), To: vocab.ItemCollection{johnDoe.ID}}
+
+	if !isVisibleTo(public, vocab.Actor{}) {
+		t.Errorf("public objects should be visible to anonymous actors")
+	}
+	if isVisibleTo(private, vocab.Actor{}) {
+		t.Errorf("private objects should not be visible to anonymous actors")
+	}
+	if !isVisibleTo(private, johnDoe) {
+		t.Errorf("private objects should be visible to their recipients")
+	}
+}
@@ -0,0 +1,54 @@
+package fedbox
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/go-ap/errors"
+)
+
+// certReloader holds the TLS certificate served by the HTTPS server, allowing us to replace it with a renewed one
+// without restarting the server.
+type certReloader struct {
+	m        sync.RWMutex
+	certPath string
+	keyPath  string
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	c := certReloader{}
+	if err := c.reload(certPath, keyPath); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// reload loads the certificate and key from the certPath and keyPath files.
+// If they are not valid, we keep serving the previously loaded certificate.
+func (c *certReloader) reload(certPath, keyPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load TLS certificate %s", certPath)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.certPath = certPath
+	c.keyPath = keyPath
+	c.cert = &cert
+	return nil
+}
+
+// GetCertificate returns the current certificate, it is used as the tls.Config.GetCertificate callback.
+func (c *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.cert, nil
+}
+
+func (c *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
@@ -0,0 +1,103 @@
+package fedbox
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, certPath, keyPath string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "fedbox.local"},
+		DNSNames:     []string{"fedbox.local"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %s", err)
+	}
+	if err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("unable to write certificate: %s", err)
+	}
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("unable to write key: %s", err)
+	}
+}
+
+func servedSerial(t *testing.T, addr string) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("unable to connect to %s: %s", addr, err)
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		t.Fatalf("no certificate served by %s", addr)
+	}
+	return certs[0].SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certPath, keyPath, 1)
+
+	certs, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("newCertReloader() returned error %s", err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", certs.TLSConfig())
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	srv := http.Server{Handler: http.NotFoundHandler()}
+	go srv.Serve(l)
+	defer srv.Close()
+	addr := l.Addr().String()
+
+	if serial := servedSerial(t, addr); serial != 1 {
+		t.Errorf("served certificate serial %d, expected %d", serial, 1)
+	}
+
+	writeTestCert(t, certPath, keyPath, 2)
+	if err = certs.reload(certPath, keyPath); err != nil {
+		t.Fatalf("reload() returned error %s", err)
+	}
+	if serial := servedSerial(t, addr); serial != 2 {
+		t.Errorf("served certificate serial %d after reload, expected %d", serial, 2)
+	}
+
+	if err = os.WriteFile(certPath, []byte("invalid certificate"), 0600); err != nil {
+		t.Fatalf("unable to write certificate: %s", err)
+	}
+	if err = certs.reload(certPath, keyPath); err == nil {
+		t.Errorf("reload() should have returned an error for an invalid certificate")
+	}
+	if serial := servedSerial(t, addr); serial != 2 {
+		t.Errorf("served certificate serial %d after a failed reload, expected the previous %d", serial, 2)
+	}
+}
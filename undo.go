@@ -0,0 +1,89 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// undoneActivity returns the activity that the undo Undo activity reverses, loading it from storage when needed
+func undoneActivity(db collectionStore, undo *vocab.Activity) (*vocab.Activity, error) {
+	it := undo.Object
+	if vocab.IsIRI(it) {
+		var err error
+		if it, err = db.Load(it.GetLink()); err != nil {
+			return nil, err
+		}
+		it = firstItem(it)
+	}
+	var undone *vocab.Activity
+	err := vocab.OnActivity(it, func(a *vocab.Activity) error {
+		undone = a
+		return nil
+	})
+	if err != nil || undone == nil {
+		return nil, errors.NotValidf("%s is not an activity", undo.Object.GetLink())
+	}
+	return undone, nil
+}
+
+// removeIfContains removes the it item from the col collection when the collection contains it.
+// It reports if the collection has been modified.
+func removeIfContains(db collectionStore, col vocab.IRI, it vocab.IRI) (bool, error) {
+	if !collectionContains(db, col, it) {
+		return false, nil
+	}
+	if err := db.RemoveFrom(col, it); err != nil {
+		return false, errors.Annotatef(err, "unable to remove %s from %s", it, col)
+	}
+	return true, nil
+}
+
+// undoSideEffects reverses the side effects of the Like, Announce or Follow activity that the undo activity undoes:
+// the Like and its actor are removed from the likes of the object, and the object from the liked collection of the
+// actor, the Announce is removed from the shares of its object, and the follow relationship is removed from the
+// followers and following collections.
+//
+// Only the actor of the activity can undo it. The function returns the IRIs of the collections that have been modified.
+func undoSideEffects(db collectionStore, undo *vocab.Activity) (vocab.IRIs, error) {
+	if undo == nil || undo.GetType() != vocab.UndoType || vocab.IsNil(undo.Actor) || vocab.IsNil(undo.Object) {
+		return nil, nil
+	}
+	undone, err := undoneActivity(db, undo)
+	if err != nil {
+		return nil, err
+	}
+	if vocab.IsNil(undone.Actor) || vocab.IsNil(undone.Object) {
+		return nil, errors.NotValidf("invalid %s %s", undone.GetType(), undone.GetLink())
+	}
+	actor := undone.Actor.GetLink()
+	if !actor.Equals(undo.Actor.GetLink(), false) {
+		return nil, errors.Forbiddenf("only %s can undo %s", actor, undone.GetLink())
+	}
+	ob := undone.Object.GetLink()
+
+	type removal struct {
+		col vocab.IRI
+		it  vocab.IRI
+	}
+	var removals []removal
+	switch undone.GetType() {
+	case vocab.LikeType:
+		// NOTE: the likes collection can contain the Like activities or the actors that liked the object
+		removals = []removal{{vocab.Likes.IRI(ob), undone.GetLink()}, {vocab.Likes.IRI(ob), actor}, {vocab.Liked.IRI(actor), ob}}
+	case vocab.AnnounceType:
+		removals = []removal{{vocab.Shares.IRI(ob), undone.GetLink()}}
+	case vocab.FollowType:
+		removals = []removal{{vocab.Followers.IRI(ob), actor}, {vocab.Following.IRI(actor), ob}}
+	}
+	modified := make(vocab.IRIs, 0, len(removals))
+	for _, rm := range removals {
+		changed, err := removeIfContains(db, rm.col, rm.it)
+		if err != nil {
+			return modified, err
+		}
+		if changed && !modified.Contains(rm.col) {
+			modified = append(modified, rm.col)
+		}
+	}
+	return modified, nil
+}
@@ -0,0 +1,100 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestUndoSideEffects(t *testing.T) {
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	janeDoe := vocab.IRI("https://fedbox.local/actors/janedoe")
+	note := vocab.IRI("https://fedbox.local/objects/note")
+
+	undoOf := func(act vocab.IRI, it vocab.Item) *vocab.Activity {
+		return &vocab.Activity{
+			ID:     "https://fedbox.local/activities/undo",
+			Type:   vocab.UndoType,
+			Actor:  act,
+			Object: it,
+		}
+	}
+	isEmpty := func(db mockCollectionStore, col vocab.IRI) bool {
+		it, err := db.Load(col)
+		if err != nil {
+			return false
+		}
+		empty := false
+		vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+			empty = c.Count() == 0
+			return nil
+		})
+		return empty
+	}
+
+	t.Run("Like", func(t *testing.T) {
+		like := &vocab.Activity{ID: "https://fedbox.local/activities/like", Type: vocab.LikeType, Actor: johnDoe, Object: note}
+		db := mockCollectionStore{mockStore{like.ID: like}}
+		db.AddTo(vocab.Likes.IRI(note), like.ID)
+		db.AddTo(vocab.Liked.IRI(johnDoe), note)
+
+		modified, err := undoSideEffects(db, undoOf(johnDoe, like.ID))
+		if err != nil {
+			t.Fatalf("undoSideEffects() returned error %s", err)
+		}
+		if len(modified) != 2 {
+			t.Errorf("undoSideEffects() modified %v, expected the likes and liked collections", modified)
+		}
+		if !isEmpty(db, vocab.Likes.IRI(note)) {
+			t.Errorf("the likes collection of %s should be empty", note)
+		}
+		if !isEmpty(db, vocab.Liked.IRI(johnDoe)) {
+			t.Errorf("the liked collection of %s should be empty", johnDoe)
+		}
+
+		if modified, _ = undoSideEffects(db, undoOf(johnDoe, like.ID)); len(modified) != 0 {
+			t.Errorf("undoing the Like again should not modify anything, modified %v", modified)
+		}
+	})
+	t.Run("Announce", func(t *testing.T) {
+		announce := &vocab.Activity{ID: "https://fedbox.local/activities/announce", Type: vocab.AnnounceType, Actor: johnDoe, Object: note}
+		db := mockCollectionStore{mockStore{}}
+		db.AddTo(vocab.Shares.IRI(note), announce.ID)
+
+		if _, err := undoSideEffects(db, undoOf(johnDoe, announce)); err != nil {
+			t.Fatalf("undoSideEffects() returned error %s", err)
+		}
+		if !isEmpty(db, vocab.Shares.IRI(note)) {
+			t.Errorf("the shares collection of %s should be empty", note)
+		}
+	})
+	t.Run("Follow", func(t *testing.T) {
+		follow := &vocab.Activity{ID: "https://fedbox.local/activities/follow", Type: vocab.FollowType, Actor: johnDoe, Object: janeDoe}
+		db := mockCollectionStore{mockStore{follow.ID: follow}}
+		db.AddTo(vocab.Followers.IRI(janeDoe), johnDoe)
+		db.AddTo(vocab.Following.IRI(johnDoe), janeDoe)
+
+		if _, err := undoSideEffects(db, undoOf(johnDoe, follow.ID)); err != nil {
+			t.Fatalf("undoSideEffects() returned error %s", err)
+		}
+		if !isEmpty(db, vocab.Followers.IRI(janeDoe)) {
+			t.Errorf("the followers collection of %s should be empty", janeDoe)
+		}
+		if !isEmpty(db, vocab.Following.IRI(johnDoe)) {
+			t.Errorf("the following collection of %s should be empty", johnDoe)
+		}
+	})
+	t.Run("other actor", func(t *testing.T) {
+		like := &vocab.Activity{ID: "https://fedbox.local/activities/like", Type: vocab.LikeType, Actor: johnDoe, Object: note}
+		db := mockCollectionStore{mockStore{like.ID: like}}
+		db.AddTo(vocab.Likes.IRI(note), like.ID)
+
+		if _, err := undoSideEffects(db, undoOf(janeDoe, like.ID)); !errors.IsForbidden(err) {
+			t.Errorf("undoSideEffects() returned error %v, expected Forbidden", err)
+		}
+		if !collectionContains(db, vocab.Likes.IRI(note), like.ID) {
+			t.Errorf("the Like should still be in the likes collection of %s", note)
+		}
+	})
+}
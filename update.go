@@ -0,0 +1,70 @@
+package fedbox
+
+import (
+	"encoding/json"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/processing"
+)
+
+// updateKeptProperties are the properties of the stored objects which an Update can't change
+var updateKeptProperties = []string{"@context", "id", "type", "published", "attributedTo"}
+
+// mergeUpdate replaces the object of the a Update with the stored object, into which we merge the properties
+// present in the object of the raw request body, so the properties missing from the update are preserved.
+// The updated time of the merged object is set to now.
+//
+// The Updates with objects we don't have in storage are left unchanged.
+// NOTE(marius): the check that the actor of a remote Update owns the object happens in ValidateInboxActivity.
+func mergeUpdate(db processing.ReadStore, a *vocab.Activity, raw []byte, now time.Time) error {
+	if a == nil || a.GetType() != vocab.UpdateType || vocab.IsNil(a.Object) || vocab.IsIRI(a.Object) {
+		return nil
+	}
+	body := struct {
+		Object json.RawMessage `json:"object"`
+	}{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return errors.NewBadRequest(err, "unable to parse the Update")
+	}
+	update := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body.Object, &update); err != nil {
+		// NOTE(marius): the object is not embedded in the Update, or there are more than one
+		return nil
+	}
+
+	iri := a.Object.GetLink()
+	stored, err := db.Load(iri)
+	if stored = firstItem(stored); err != nil || vocab.IsNil(stored) || !stored.GetLink().Equals(iri, false) {
+		return nil
+	}
+	if stored.GetType() == vocab.TombstoneType {
+		return errors.Gonef("unable to update %s, it has been deleted", iri)
+	}
+	data, err := vocab.MarshalJSON(stored)
+	if err != nil {
+		return errors.Annotatef(err, "unable to marshal %s", iri)
+	}
+	merged := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(data, &merged); err != nil {
+		return errors.Annotatef(err, "unable to unmarshal %s", iri)
+	}
+	for prop, val := range update {
+		if stringsContain(updateKeptProperties, prop) {
+			continue
+		}
+		merged[prop] = val
+	}
+	merged["updated"], _ = json.Marshal(now.Format(time.RFC3339))
+
+	if data, err = json.Marshal(merged); err != nil {
+		return errors.Annotatef(err, "unable to marshal the updated %s", iri)
+	}
+	it, err := vocab.UnmarshalJSON(data)
+	if err != nil {
+		return errors.NewBadRequest(err, "unable to unmarshal the updated %s", iri)
+	}
+	a.Object = it
+	return nil
+}
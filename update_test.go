@@ -0,0 +1,96 @@
+package fedbox
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestMergeUpdate(t *testing.T) {
+	published := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := time.Date(2023, 2, 1, 10, 0, 0, 0, time.UTC)
+
+	bob := vocab.IRI("https://example.com/users/bob")
+	note := &vocab.Object{
+		ID:           "https://example.com/objects/1",
+		Type:         vocab.NoteType,
+		AttributedTo: bob,
+		Published:    published,
+		Summary:      vocab.NaturalLanguageValues{{Ref: vocab.NilLangRef, Value: vocab.Content("cw")}},
+		Content:      vocab.NaturalLanguageValues{{Ref: vocab.NilLangRef, Value: vocab.Content("first version")}},
+		InReplyTo:    vocab.IRI("https://fedbox.local/objects/1"),
+	}
+	deleted := &vocab.Object{ID: "https://example.com/objects/2", Type: vocab.TombstoneType}
+	db := mockStore{note.ID: note, deleted.ID: deleted}
+
+	raw := []byte(`{
+		"id": "https://example.com/activities/1",
+		"type": "Update",
+		"actor": "https://example.com/users/bob",
+		"object": {
+			"id": "https://example.com/objects/1",
+			"type": "Note",
+			"attributedTo": "https://example.com/users/mallory",
+			"content": "second version"
+		}
+	}`)
+	it, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		t.Fatalf("unable to unmarshal the Update: %s", err)
+	}
+	update, err := vocab.ToActivity(it)
+	if err != nil {
+		t.Fatalf("unable to load the Update: %s", err)
+	}
+
+	if err = mergeUpdate(db, update, raw, now); err != nil {
+		t.Fatalf("mergeUpdate() returned error %s", err)
+	}
+	merged, err := vocab.ToObject(update.Object)
+	if err != nil {
+		t.Fatalf("the merged object is not valid: %s", err)
+	}
+	if got := merged.Content.First().Value.String(); got != "second version" {
+		t.Errorf("the merged content is %q, expected %q", got, "second version")
+	}
+	if got := merged.Summary.First().Value.String(); got != "cw" {
+		t.Errorf("the summary missing from the update should be preserved, got %q", got)
+	}
+	if vocab.IsNil(merged.InReplyTo) || !merged.InReplyTo.GetLink().Equals(note.InReplyTo.GetLink(), false) {
+		t.Errorf("the inReplyTo missing from the update should be preserved, got %v", merged.InReplyTo)
+	}
+	if !merged.Published.Equal(published) {
+		t.Errorf("the published time should be preserved, got %s", merged.Published)
+	}
+	if !merged.Updated.Equal(now) {
+		t.Errorf("the updated time is %s, expected %s", merged.Updated, now)
+	}
+	if vocab.IsNil(merged.AttributedTo) || !merged.AttributedTo.GetLink().Equals(bob, false) {
+		t.Errorf("the update shouldn't change the attributedTo, got %v", merged.AttributedTo)
+	}
+
+	t.Run("deleted object", func(t *testing.T) {
+		raw := []byte(`{"type": "Update", "object": {"id": "https://example.com/objects/2", "type": "Note", "content": "zombie"}}`)
+		a := &vocab.Activity{Type: vocab.UpdateType, Object: &vocab.Object{ID: deleted.ID, Type: vocab.NoteType}}
+		if err := mergeUpdate(db, a, raw, now); !errors.IsGone(err) {
+			t.Errorf("mergeUpdate() for a deleted object returned %v, expected a gone error", err)
+		}
+	})
+	t.Run("unknown object", func(t *testing.T) {
+		raw := []byte(`{"type": "Update", "object": {"id": "https://example.com/objects/3", "type": "Note", "content": "new"}}`)
+		ob := &vocab.Object{ID: "https://example.com/objects/3", Type: vocab.NoteType}
+		a := &vocab.Activity{Type: vocab.UpdateType, Object: ob}
+		if err := mergeUpdate(mockStore{note.ID: note}, a, raw, now); err != nil || a.Object != vocab.Item(ob) {
+			t.Errorf("mergeUpdate() for an unknown object should leave it unchanged: %v", err)
+		}
+	})
+	t.Run("remote actor not owning the object", func(t *testing.T) {
+		mallory := vocab.Actor{ID: "https://evil.example.com/users/mallory", Type: vocab.PersonType}
+		a := &vocab.Activity{Type: vocab.UpdateType, Actor: mallory.ID, Object: &vocab.Object{ID: note.ID, Type: vocab.NoteType}}
+		if err := ValidateInboxActivity(db, "https://fedbox.local", a, mallory); !errors.IsForbidden(err) {
+			t.Errorf("the Update of an object by an actor which doesn't own it returned %v, expected a forbidden error", err)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package fedbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+	"github.com/go-ap/processing"
+	"github.com/pborman/uuid"
+)
+
+const (
+	uploadPath = "upload"
+	mediaPath  = "media"
+	// uploadFileKey is the name of the multipart form field containing the uploaded file
+	uploadFileKey = "file"
+	// sniffLen is the number of bytes used for detecting the type of the uploaded files
+	sniffLen = 512
+)
+
+// mediaUploader stores the media files uploaded by the clients and creates the objects describing them
+type mediaUploader struct {
+	db      processing.WriteStore
+	base    vocab.IRI
+	dir     string
+	maxSize int64
+	types   []string
+}
+
+// objectTypeForMedia returns the ActivityPub type of the object describing a file with the typ MIME type
+func objectTypeForMedia(typ string) vocab.ActivityVocabularyType {
+	switch {
+	case strings.HasPrefix(typ, "image/"):
+		return vocab.ImageType
+	case strings.HasPrefix(typ, "video/"):
+		return vocab.VideoType
+	case strings.HasPrefix(typ, "audio/"):
+		return vocab.AudioType
+	default:
+		return vocab.DocumentType
+	}
+}
+
+func (m mediaUploader) allowed(typ string) bool {
+	for _, t := range m.types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaExtension returns the file extension for the typ MIME type, so the media files can be served with the
+// right content type.
+func mediaExtension(typ string) string {
+	exts, _ := mime.ExtensionsByType(typ)
+	if len(exts) == 0 {
+		return ""
+	}
+	// NOTE(marius): the extensions are sorted alphabetically, we prefer the common ones
+	for _, ext := range exts {
+		if ext == ".jpg" || ext == ".png" || ext == ".gif" || ext == ".webp" {
+			return ext
+		}
+	}
+	return exts[0]
+}
+
+// save stores the content of the r file in the media directory and the object describing it in the storage.
+// The type of the file is detected from its content, the name is used only as the object's name.
+func (m mediaUploader) save(r io.Reader, name string, by vocab.Actor) (vocab.Item, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, errors.NewBadRequest(err, "unable to read the uploaded file")
+	}
+	head = head[:n]
+	typ, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !m.allowed(typ) {
+		return nil, errUnsupportedMedia{typ: typ}
+	}
+
+	if err = os.MkdirAll(m.dir, 0700); err != nil {
+		return nil, errors.Annotatef(err, "unable to create the media directory")
+	}
+	fileName := uuid.New() + mediaExtension(typ)
+	f, err := os.OpenFile(filepath.Join(m.dir, fileName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create the media file")
+	}
+	size, err := io.Copy(f, io.MultiReader(bytes.NewReader(head), r))
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil && size > m.maxSize {
+		err = errTooLarge{limit: m.maxSize}
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	ob := vocab.Object{
+		ID:           filters.ObjectsType.IRI(m.base).AddPath(strings.TrimSuffix(fileName, filepath.Ext(fileName))),
+		Type:         objectTypeForMedia(typ),
+		MediaType:    vocab.MimeType(typ),
+		URL:          m.base.AddPath(mediaPath, fileName),
+		AttributedTo: by.GetLink(),
+		Published:    time.Now().UTC(),
+	}
+	if name = filepath.Base(name); name != "." && name != string(filepath.Separator) {
+		ob.Name = vocab.DefaultNaturalLanguageValue(name)
+	}
+	it, err := m.db.Save(&ob)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, errors.Annotatef(err, "unable to save the media object")
+	}
+	return it, nil
+}
+
+type errTooLarge struct {
+	limit int64
+}
+
+func (e errTooLarge) Error() string {
+	return fmt.Sprintf("the uploaded file is larger than the maximum allowed size of %d bytes", e.limit)
+}
+
+type errUnsupportedMedia struct {
+	typ string
+}
+
+func (e errUnsupportedMedia) Error() string {
+	return "the uploaded file's type " + e.typ + " is not allowed"
+}
+
+func handleUpload(m mediaUploader, actorFn func(*http.Request) vocab.Actor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		by := actorFn(r)
+		if isAnonymous(by) {
+			errors.HandleError(errors.Unauthorizedf("authorization required for uploading media")).ServeHTTP(w, r)
+			return
+		}
+		// NOTE(marius): we allow some room for the multipart headers, the file size is checked when saving it
+		r.Body = http.MaxBytesReader(w, r.Body, m.maxSize+sniffLen*2)
+		file, header, err := r.FormFile(uploadFileKey)
+		if err != nil {
+			// NOTE(marius): the error returned by the http.MaxBytesReader doesn't have its own type in go1.18
+			if strings.Contains(err.Error(), "request body too large") {
+				http.Error(w, errTooLarge{limit: m.maxSize}.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			errors.HandleError(errors.NewBadRequest(err, "missing %q file", uploadFileKey)).ServeHTTP(w, r)
+			return
+		}
+		defer file.Close()
+		if header.Size > m.maxSize {
+			http.Error(w, errTooLarge{limit: m.maxSize}.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		it, err := m.save(file, header.Filename, by)
+		if err != nil {
+			switch err.(type) {
+			case errTooLarge:
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			case errUnsupportedMedia:
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			default:
+				errors.HandleError(err).ServeHTTP(w, r)
+			}
+			return
+		}
+		data, err := vocab.MarshalJSON(it)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.Header().Set("Location", it.GetLink().String())
+		w.WriteHeader(http.StatusCreated)
+		w.Write(data)
+	}
+}
+
+// HandleUpload serves the end-point where the clients upload the media files to attach to their posts.
+// It returns the Image, Video, Audio or Document object created for the file, with its url pointing to the file.
+func HandleUpload(fb FedBOX) http.HandlerFunc {
+	m := mediaUploader{
+		db:      fb.storage,
+		base:    vocab.IRI(fb.Config().BaseURL),
+		dir:     fb.Config().MediaPath,
+		maxSize: fb.Config().MediaMaxSize,
+		types:   fb.Config().MediaTypes,
+	}
+	return handleUpload(m, fb.actorFromRequest)
+}
@@ -0,0 +1,122 @@
+package fedbox
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func testPNG(t *testing.T) []byte {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatalf("unable to encode the test image: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func uploadRequest(t *testing.T, name string, content []byte) *http.Request {
+	body := bytes.Buffer{}
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(uploadFileKey, name)
+	if err != nil {
+		t.Fatalf("unable to create the multipart form: %s", err)
+	}
+	fw.Write(content)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/"+uploadPath, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestHandleUpload(t *testing.T) {
+	base := vocab.IRI("https://fedbox.local")
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	img := testPNG(t)
+
+	tests := []struct {
+		name    string
+		file    string
+		content []byte
+		by      vocab.Actor
+		maxSize int64
+		status  int
+	}{
+		{name: "image", file: "cat.png", content: img, by: johnDoe, maxSize: 1 << 20, status: http.StatusCreated},
+		{name: "anonymous", file: "cat.png", content: img, maxSize: 1 << 20, status: http.StatusUnauthorized},
+		{name: "oversized", file: "cat.png", content: img, by: johnDoe, maxSize: int64(len(img) - 1), status: http.StatusRequestEntityTooLarge},
+		{name: "way oversized", file: "big.png", content: append(img, make([]byte, 4096)...), by: johnDoe, maxSize: 16, status: http.StatusRequestEntityTooLarge},
+		{name: "disallowed type", file: "cat.png", content: []byte("<html><body>not an image</body></html>"), by: johnDoe, maxSize: 1 << 20, status: http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockStore{}
+			m := mediaUploader{
+				db:      db,
+				base:    base,
+				dir:     t.TempDir(),
+				maxSize: tt.maxSize,
+				types:   []string{"image/png", "image/jpeg"},
+			}
+			w := httptest.NewRecorder()
+			handleUpload(m, func(*http.Request) vocab.Actor { return tt.by }).ServeHTTP(w, uploadRequest(t, tt.file, tt.content))
+			if w.Code != tt.status {
+				t.Fatalf("upload returned status %d, expected %d: %s", w.Code, tt.status, w.Body.String())
+			}
+
+			files, _ := os.ReadDir(m.dir)
+			if tt.status != http.StatusCreated {
+				if len(files) > 0 || len(db) > 0 {
+					t.Errorf("rejected upload left %d files and %d objects", len(files), len(db))
+				}
+				return
+			}
+
+			it, err := vocab.UnmarshalJSON(w.Body.Bytes())
+			if err != nil {
+				t.Fatalf("unable to unmarshal response: %s", err)
+			}
+			if loc := w.Header().Get("Location"); loc != it.GetLink().String() {
+				t.Errorf("Location header %q, expected %q", loc, it.GetLink())
+			}
+			if _, ok := db[it.GetLink()]; !ok {
+				t.Errorf("the object %s was not saved", it.GetLink())
+			}
+			err = vocab.OnObject(it, func(o *vocab.Object) error {
+				if o.Type != vocab.ImageType || o.MediaType != "image/png" {
+					t.Errorf("uploaded object is %s %s, expected %s %s", o.Type, o.MediaType, vocab.ImageType, "image/png")
+				}
+				if !o.AttributedTo.GetLink().Equals(johnDoe.ID, false) {
+					t.Errorf("uploaded object is attributed to %s, expected %s", o.AttributedTo.GetLink(), johnDoe.ID)
+				}
+				if o.Name.First().Value.String() != tt.file {
+					t.Errorf("uploaded object's name is %s, expected %s", o.Name, tt.file)
+				}
+				url := o.URL.GetLink().String()
+				if !strings.HasPrefix(url, base.AddPath(mediaPath).String()+"/") {
+					t.Fatalf("uploaded object's url %s is not in the media path", url)
+				}
+				saved, err := os.ReadFile(filepath.Join(m.dir, filepath.Base(url)))
+				if err != nil {
+					t.Fatalf("unable to read the uploaded file: %s", err)
+				}
+				if !bytes.Equal(saved, tt.content) {
+					t.Errorf("the saved file is different from the uploaded one")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("the response is not an object: %s", err)
+			}
+		})
+	}
+}
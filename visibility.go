@@ -0,0 +1,240 @@
+package fedbox
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+	"github.com/go-ap/processing"
+)
+
+// publicAliases are the forms of the Public collection IRI that clients use for addressing
+var publicAliases = vocab.IRIs{vocab.PublicNS, "as:Public", "Public"}
+
+func isPublicAlias(it vocab.Item) bool {
+	if vocab.IsNil(it) {
+		return false
+	}
+	return publicAliases.Contains(it.GetLink())
+}
+
+func containsPublic(rec vocab.ItemCollection) bool {
+	for _, it := range rec {
+		if isPublicAlias(it) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripPublic removes all the aliases of the Public collection from the rec recipients
+func stripPublic(rec vocab.ItemCollection) vocab.ItemCollection {
+	if !containsPublic(rec) {
+		return rec
+	}
+	result := make(vocab.ItemCollection, 0, len(rec))
+	for _, it := range rec {
+		if !isPublicAlias(it) {
+			result = append(result, it)
+		}
+	}
+	return result
+}
+
+// allRecipients returns all the recipients of the ob object, including the blind ones
+func allRecipients(ob *vocab.Object) vocab.ItemCollection {
+	recipients := make(vocab.ItemCollection, 0)
+	recipients = append(recipients, ob.To...)
+	recipients = append(recipients, ob.Bto...)
+	recipients = append(recipients, ob.CC...)
+	recipients = append(recipients, ob.BCC...)
+	recipients = append(recipients, ob.Audience...)
+	return recipients
+}
+
+// visibilityChecker checks which items an actor can see. Besides the items that isVisibleTo allows, the actor
+// can see the activities it has sent, and the items addressed to a followers collection it's a member of.
+// The memberships are loaded once for every collection.
+type visibilityChecker struct {
+	db      processing.ReadStore
+	act     vocab.Actor
+	members map[vocab.IRI]bool
+}
+
+func newVisibilityChecker(db processing.ReadStore, act vocab.Actor) *visibilityChecker {
+	return &visibilityChecker{db: db, act: act, members: make(map[vocab.IRI]bool)}
+}
+
+// isMember checks if the actor is a member of the col collection
+func (v *visibilityChecker) isMember(col vocab.IRI) bool {
+	if member, ok := v.members[col]; ok {
+		return member
+	}
+	member := collectionContains(v.db, col, v.act.GetLink())
+	v.members[col] = member
+	return member
+}
+
+// visible checks if the actor can see the it item
+func (v *visibilityChecker) visible(it vocab.Item) bool {
+	if vocab.IsNil(it) {
+		return false
+	}
+	if isVisibleTo(it, v.act) {
+		return true
+	}
+	if isAnonymous(v.act) {
+		return false
+	}
+	visible := false
+	vocab.OnActivity(it, func(a *vocab.Activity) error {
+		visible = vocab.ActivityTypes.Contains(a.GetType()) && !vocab.IsNil(a.Actor) && a.Actor.GetLink().Equals(v.act.GetLink(), false)
+		return nil
+	})
+	if visible || v.db == nil {
+		return visible
+	}
+	vocab.OnObject(it, func(o *vocab.Object) error {
+		for _, rec := range allRecipients(o) {
+			if _, typ := vocab.Split(rec.GetLink()); typ == vocab.Followers && v.isMember(rec.GetLink()) {
+				visible = true
+				break
+			}
+		}
+		return nil
+	})
+	return visible
+}
+
+// filter returns the items the actor can see
+func (v *visibilityChecker) filter(items vocab.ItemCollection) vocab.ItemCollection {
+	result := make(vocab.ItemCollection, 0, len(items))
+	for _, it := range items {
+		if it.IsLink() || v.visible(it) {
+			result = append(result, it)
+		}
+	}
+	return result
+}
+
+// checkItemVisibility returns a NotFound error when the "by" actor can't see the it item, so the existence of the
+// items which are not addressed to it doesn't get disclosed.
+func checkItemVisibility(db processing.ReadStore, it vocab.Item, by vocab.Actor) error {
+	if newVisibilityChecker(db, by).visible(it) {
+		return nil
+	}
+	return errors.NotFoundf("%s not found", it.GetLink())
+}
+
+// isFollowersOnly checks if the ob object is addressed to the followers of the actor, and not to the Public
+// collection as primary recipient.
+func isFollowersOnly(ob *vocab.Object, actor vocab.Item) bool {
+	if vocab.IsNil(actor) || containsPublic(ob.To) {
+		return false
+	}
+	return ob.To.Contains(vocab.Followers.IRI(actor))
+}
+
+// enforceFollowersOnlyAddressing makes the addressing of a followers-only ob object consistent with its
+// visibility, so it doesn't get distributed publicly because of a stray Public recipient.
+func enforceFollowersOnlyAddressing(ob *vocab.Object, actor vocab.Item, mode config.PublicAddressingMode) error {
+	if !isFollowersOnly(ob, actor) {
+		return nil
+	}
+	if !containsPublic(ob.CC) && !containsPublic(ob.Bto) && !containsPublic(ob.BCC) && !containsPublic(ob.Audience) {
+		return nil
+	}
+	switch mode {
+	case config.PublicAddressingReject:
+		return errors.NotValidf("followers-only %s %s must not be addressed to the Public collection", ob.GetType(), ob.GetLink())
+	case config.PublicAddressingStrip:
+		ob.CC = stripPublic(ob.CC)
+		ob.Bto = stripPublic(ob.Bto)
+		ob.BCC = stripPublic(ob.BCC)
+		ob.Audience = stripPublic(ob.Audience)
+	}
+	return nil
+}
+
+// ValidateFollowersOnlyAddressing enforces the consistency between the visibility and the addressing of the a
+// activity and of its object, according to the mode configured for followers-only activities.
+func ValidateFollowersOnlyAddressing(a *vocab.Activity, mode config.PublicAddressingMode) error {
+	if a == nil || mode == config.PublicAddressingAllow || len(mode) == 0 {
+		return nil
+	}
+	err := vocab.OnObject(a, func(o *vocab.Object) error {
+		return enforceFollowersOnlyAddressing(o, a.Actor, mode)
+	})
+	if err != nil {
+		return err
+	}
+	if vocab.IsNil(a.Object) || vocab.IsIRI(a.Object) || vocab.IsItemCollection(a.Object) {
+		return nil
+	}
+	return vocab.OnObject(a.Object, func(o *vocab.Object) error {
+		return enforceFollowersOnlyAddressing(o, a.Actor, mode)
+	})
+}
+
+// dedupeRecipients removes the recipients of the ob object which appear more than once in its to, cc, bto and bcc
+// properties, keeping each of them in the first property where it appears, in that order. The aliases of the Public
+// collection are considered to be the same recipient.
+func dedupeRecipients(ob *vocab.Object) {
+	seen := make(vocab.IRIs, 0)
+	dedupe := func(rec vocab.ItemCollection) vocab.ItemCollection {
+		if len(rec) == 0 {
+			return rec
+		}
+		result := make(vocab.ItemCollection, 0, len(rec))
+		for _, it := range rec {
+			if vocab.IsNil(it) {
+				continue
+			}
+			iri := it.GetLink()
+			if isPublicAlias(iri) {
+				iri = vocab.PublicNS
+			}
+			if seen.Contains(iri) {
+				continue
+			}
+			seen = append(seen, iri)
+			result = append(result, it)
+		}
+		return result
+	}
+	ob.To = dedupe(ob.To)
+	ob.CC = dedupe(ob.CC)
+	ob.Bto = dedupe(ob.Bto)
+	ob.BCC = dedupe(ob.BCC)
+}
+
+// DedupeRecipients removes the duplicate recipients of the a activity, and of its object, so the activity
+// gets delivered only once to each of them.
+func DedupeRecipients(a *vocab.Activity) {
+	if a == nil {
+		return
+	}
+	vocab.OnObject(a, func(o *vocab.Object) error {
+		dedupeRecipients(o)
+		return nil
+	})
+	if vocab.IsNil(a.Object) || vocab.IsIRI(a.Object) || vocab.IsItemCollection(a.Object) {
+		return
+	}
+	vocab.OnObject(a.Object, func(o *vocab.Object) error {
+		dedupeRecipients(o)
+		return nil
+	})
+}
+
+// hideInvisibleItems removes from the c collection the items the act actor isn't allowed to see, and adjusts its
+// total count. The owners can see everything in their own collections.
+func hideInvisibleItems(db processing.ReadStore, c *vocab.OrderedCollection, act vocab.Actor) {
+	if owner, _ := collectionOwner(c.ID); owner.Equals(act.GetLink(), false) {
+		return
+	}
+	visible := newVisibilityChecker(db, act).filter(c.OrderedItems)
+	if hidden := uint(len(c.OrderedItems) - len(visible)); hidden > 0 && c.TotalItems >= hidden {
+		c.TotalItems -= hidden
+	}
+	c.OrderedItems = visible
+}
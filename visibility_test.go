@@ -0,0 +1,252 @@
+package fedbox
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/fedbox/internal/config"
+)
+
+func followersOnlyCreate(cc ...vocab.Item) *vocab.Activity {
+	actor := vocab.IRI("https://fedbox.local/actors/johndoe")
+	followers := vocab.Followers.IRI(actor)
+	return &vocab.Activity{
+		Type:  vocab.CreateType,
+		Actor: actor,
+		To:    vocab.ItemCollection{followers},
+		CC:    cc,
+		Object: &vocab.Object{
+			Type: vocab.NoteType,
+			To:   vocab.ItemCollection{followers},
+			CC:   cc,
+		},
+	}
+}
+
+func TestValidateFollowersOnlyAddressing(t *testing.T) {
+	mention := vocab.IRI("https://example.com/actors/jane")
+
+	t.Run("strip stray Public alias", func(t *testing.T) {
+		a := followersOnlyCreate(mention, vocab.IRI("as:Public"))
+		if err := ValidateFollowersOnlyAddressing(a, config.PublicAddressingStrip); err != nil {
+			t.Fatalf("ValidateFollowersOnlyAddressing() returned error %s", err)
+		}
+		if containsPublic(a.CC) {
+			t.Errorf("the Public alias should have been removed from the activity's cc: %v", a.CC)
+		}
+		if !a.CC.Contains(mention) {
+			t.Errorf("the other recipients should have been retained in the activity's cc: %v", a.CC)
+		}
+		vocab.OnObject(a.Object, func(o *vocab.Object) error {
+			if containsPublic(o.CC) {
+				t.Errorf("the Public alias should have been removed from the object's cc: %v", o.CC)
+			}
+			return nil
+		})
+	})
+	t.Run("reject stray Public", func(t *testing.T) {
+		a := followersOnlyCreate(vocab.PublicNS)
+		if err := ValidateFollowersOnlyAddressing(a, config.PublicAddressingReject); !errors.IsNotValid(err) {
+			t.Errorf("ValidateFollowersOnlyAddressing() should have returned a not valid error, received %v", err)
+		}
+	})
+	t.Run("allow leaves addressing unchanged", func(t *testing.T) {
+		a := followersOnlyCreate(vocab.PublicNS)
+		if err := ValidateFollowersOnlyAddressing(a, config.PublicAddressingAllow); err != nil {
+			t.Fatalf("ValidateFollowersOnlyAddressing() returned error %s", err)
+		}
+		if !a.CC.Contains(vocab.PublicNS) {
+			t.Errorf("the Public collection should have been retained in cc: %v", a.CC)
+		}
+	})
+	t.Run("public activity is not changed", func(t *testing.T) {
+		a := followersOnlyCreate(vocab.PublicNS)
+		a.To = vocab.ItemCollection{vocab.PublicNS}
+		vocab.OnObject(a.Object, func(o *vocab.Object) error {
+			o.To = vocab.ItemCollection{vocab.PublicNS}
+			return nil
+		})
+		if err := ValidateFollowersOnlyAddressing(a, config.PublicAddressingReject); err != nil {
+			t.Fatalf("ValidateFollowersOnlyAddressing() returned error %s", err)
+		}
+		if !a.CC.Contains(vocab.PublicNS) {
+			t.Errorf("the Public collection should have been retained in cc: %v", a.CC)
+		}
+	})
+}
+
+func TestDedupeRecipients(t *testing.T) {
+	bob := &vocab.Actor{
+		ID:        "https://example.com/users/bob",
+		Type:      vocab.PersonType,
+		Inbox:     vocab.IRI("https://example.com/users/bob/inbox"),
+		Followers: vocab.IRI("https://example.com/users/bob/followers"),
+		Endpoints: &vocab.Endpoints{SharedInbox: vocab.IRI("https://example.com/inbox")},
+	}
+	carol := &vocab.Actor{
+		ID:        "https://social.example.org/carol",
+		Type:      vocab.PersonType,
+		Inbox:     vocab.IRI("https://social.example.org/carol/inbox"),
+		Followers: vocab.IRI("https://social.example.org/carol/followers"),
+	}
+	a := followersOnlyCreate(vocab.PublicNS, bob.Followers, carol.Followers, bob.Followers)
+	a.ID = "https://fedbox.local/activities/1"
+	a.To = append(a.To, bob.Followers, vocab.IRI("as:Public"))
+	a.BCC = vocab.ItemCollection{carol.Followers, bob.ID}
+	vocab.OnObject(a.Object, func(o *vocab.Object) error {
+		o.To = append(o.To, carol.Followers)
+		return nil
+	})
+
+	DedupeRecipients(a)
+
+	expected := map[string]vocab.ItemCollection{
+		"to":  {vocab.Followers.IRI(a.Actor), bob.Followers, vocab.IRI("as:Public")},
+		"cc":  {carol.Followers},
+		"bcc": {bob.ID},
+	}
+	for name, rec := range map[string]vocab.ItemCollection{"to": a.To, "cc": a.CC, "bcc": a.BCC} {
+		if len(rec) != len(expected[name]) {
+			t.Errorf("%s = %v, expected %v", name, rec, expected[name])
+			continue
+		}
+		for i, it := range rec {
+			if !it.GetLink().Equals(expected[name][i].GetLink(), false) {
+				t.Errorf("%s = %v, expected %v", name, rec, expected[name])
+				break
+			}
+		}
+	}
+	vocab.OnObject(a.Object, func(o *vocab.Object) error {
+		if o.CC.Contains(carol.Followers) {
+			t.Errorf("the object's cc %v should not contain %s, which is in its to", o.CC, carol.Followers)
+		}
+		return nil
+	})
+
+	cl := &mockDeliverer{
+		mockLoader: mockLoader{bob.ID: bob, carol.ID: carol},
+		delivered:  make(map[vocab.IRI]vocab.IRIs),
+	}
+	if _, err := deliverToRemoteFollowers(cl, "https://fedbox.local", a); err != nil {
+		t.Fatalf("deliverToRemoteFollowers() error = %s", err)
+	}
+	for _, inbox := range []vocab.IRI{"https://example.com/inbox", carol.Inbox.GetLink()} {
+		if cnt := len(cl.delivered[inbox]); cnt != 1 {
+			t.Errorf("%s received %d deliveries, expected 1", inbox, cnt)
+		}
+	}
+}
+
+func TestCheckItemVisibility(t *testing.T) {
+	johnDoe := vocab.IRI("https://fedbox.local/actors/johndoe")
+	follower := vocab.Actor{ID: "https://example.com/actors/jane", Type: vocab.PersonType}
+	stranger := vocab.Actor{ID: "https://example.com/actors/bob", Type: vocab.PersonType}
+	followers := vocab.Followers.IRI(johnDoe)
+
+	public := &vocab.Object{
+		ID:           "https://fedbox.local/objects/public",
+		Type:         vocab.NoteType,
+		AttributedTo: johnDoe,
+		To:           vocab.ItemCollection{vocab.PublicNS},
+		CC:           vocab.ItemCollection{followers},
+	}
+	publicAlias := &vocab.Object{
+		ID:           "https://fedbox.local/objects/public-alias",
+		Type:         vocab.NoteType,
+		AttributedTo: johnDoe,
+		To:           vocab.ItemCollection{vocab.IRI("as:Public")},
+	}
+	followersOnly := &vocab.Object{
+		ID:           "https://fedbox.local/objects/followers-only",
+		Type:         vocab.NoteType,
+		AttributedTo: johnDoe,
+		To:           vocab.ItemCollection{followers},
+	}
+	db := mockCollectionStore{mockStore{}}
+	db.AddTo(followers, follower.ID)
+
+	tests := []struct {
+		name    string
+		it      vocab.Item
+		by      vocab.Actor
+		visible bool
+	}{
+		{name: "public Note for anonymous", it: public, by: vocab.Actor{}, visible: true},
+		{name: "Note addressed to as:Public for anonymous", it: publicAlias, by: vocab.Actor{}, visible: true},
+		{name: "followers-only Note for anonymous", it: followersOnly, by: vocab.Actor{}},
+		{name: "followers-only Note for a follower", it: followersOnly, by: follower, visible: true},
+		{name: "followers-only Note for a stranger", it: followersOnly, by: stranger},
+		{name: "followers-only Note for its author", it: followersOnly, by: vocab.Actor{ID: johnDoe}, visible: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkItemVisibility(db, tt.it, tt.by)
+			if tt.visible && err != nil {
+				t.Errorf("checkItemVisibility() returned error %s, expected the item to be visible", err)
+			}
+			if !tt.visible && !errors.IsNotFound(err) {
+				t.Errorf("checkItemVisibility() returned %v, expected a NotFound error", err)
+			}
+		})
+	}
+
+	t.Run("collection", func(t *testing.T) {
+		got := newVisibilityChecker(db, vocab.Actor{}).filter(vocab.ItemCollection{public, followersOnly, publicAlias})
+		want := vocab.IRIs{public.ID, publicAlias.ID}
+		if len(got) != len(want) {
+			t.Fatalf("filter() returned %d items, expected %d", len(got), len(want))
+		}
+		for i, it := range got {
+			if !it.GetLink().Equals(want[i], false) {
+				t.Errorf("item %d is %s, expected %s", i, it.GetLink(), want[i])
+			}
+		}
+	})
+}
+
+func TestHideInvisibleItems(t *testing.T) {
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	stranger := vocab.Actor{ID: "https://example.com/actors/bob", Type: vocab.PersonType}
+	jane := vocab.IRI("https://example.com/actors/jane")
+	public := &vocab.Object{ID: "https://example.com/objects/public", Type: vocab.NoteType, To: vocab.ItemCollection{vocab.PublicNS}}
+	// NOTE(marius): johndoe isn't an author, nor a recipient of this Note, only its owner through the collection
+	followersOnly := &vocab.Object{
+		ID:           "https://example.com/objects/followers-only",
+		Type:         vocab.NoteType,
+		AttributedTo: jane,
+		To:           vocab.ItemCollection{vocab.Followers.IRI(jane)},
+	}
+	db := mockCollectionStore{mockStore{}}
+
+	tests := []struct {
+		name string
+		by   vocab.Actor
+		want vocab.IRIs
+	}{
+		{name: "owner", by: johnDoe, want: vocab.IRIs{public.ID, followersOnly.ID}},
+		{name: "stranger", by: stranger, want: vocab.IRIs{public.ID}},
+		{name: "anonymous", by: vocab.Actor{}, want: vocab.IRIs{public.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// NOTE(marius): the collections we serve have the pagination parameters in their IRI
+			c := &vocab.OrderedCollection{
+				ID:           vocab.Liked.IRI(johnDoe).GetLink() + "?maxItems=10",
+				Type:         vocab.OrderedCollectionType,
+				OrderedItems: vocab.ItemCollection{public, followersOnly},
+				TotalItems:   2,
+			}
+			hideInvisibleItems(db, c, tt.by)
+			if len(c.OrderedItems) != len(tt.want) || c.TotalItems != uint(len(tt.want)) {
+				t.Fatalf("hideInvisibleItems() kept %d items, total %d, expected %d", len(c.OrderedItems), c.TotalItems, len(tt.want))
+			}
+			for i, it := range c.OrderedItems {
+				if !it.GetLink().Equals(tt.want[i], false) {
+					t.Errorf("item %d is %s, expected %s", i, it.GetLink(), tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package fedbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+)
+
+const handleKey = "handle"
+
+// httpDoer is the functionality we need from the HTTP client for resolving remote actors
+type httpDoer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+type webFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href,omitempty"`
+}
+
+type webFingerResource struct {
+	Subject string          `json:"subject"`
+	Links   []webFingerLink `json:"links"`
+}
+
+// splitHandle returns the user and host parts of a "@user@host" or "acct:user@host" handle
+func splitHandle(handle string) (string, string, error) {
+	h := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(handle), "acct:"), "@")
+	parts := strings.Split(h, "@")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", errors.BadRequestf("invalid handle %q, expected @user@host", handle)
+	}
+	return parts[0], parts[1], nil
+}
+
+func isActivityPubType(typ string) bool {
+	return typ == client.ContentTypeActivityJson || strings.HasPrefix(typ, "application/ld+json")
+}
+
+// fetch executes a GET request for the u URL, bypassing any HTTP caches on the way
+func fetch(ctx context.Context, cl httpDoer, u string, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, errors.NewNotFound(err, "unable to fetch %s", u)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NotFoundf("unable to fetch %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// resolveHandle finds the actor corresponding to the handle, using WebFinger to obtain its IRI, and then
+// fetching it from its origin server.
+func resolveHandle(ctx context.Context, cl httpDoer, handle string) (*vocab.Actor, error) {
+	user, host, err := splitHandle(handle)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("resource", fmt.Sprintf("acct:%s@%s", user, host))
+	wfURL := fmt.Sprintf("https://%s/.well-known/webfinger?%s", host, q.Encode())
+
+	data, err := fetch(ctx, cl, wfURL, "application/jrd+json, application/json")
+	if err != nil {
+		return nil, err
+	}
+	res := webFingerResource{}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return nil, errors.NewNotValid(err, "invalid WebFinger response for %s", handle)
+	}
+	var actorIRI string
+	for _, l := range res.Links {
+		if l.Rel == "self" && isActivityPubType(l.Type) && len(l.Href) > 0 {
+			actorIRI = l.Href
+			break
+		}
+	}
+	if len(actorIRI) == 0 {
+		return nil, errors.NotFoundf("no ActivityPub actor found for %s", handle)
+	}
+
+	if data, err = fetch(ctx, cl, actorIRI, client.ContentTypeActivityJson); err != nil {
+		return nil, err
+	}
+	it, err := vocab.UnmarshalJSON(data)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "invalid actor %s", actorIRI)
+	}
+	if vocab.IsNil(it) {
+		return nil, errors.NotValidf("invalid actor %s", actorIRI)
+	}
+	act, err := vocab.ToActor(it)
+	if err != nil {
+		return nil, errors.NewNotValid(err, "%s is not an actor", actorIRI)
+	}
+	return act, nil
+}
+
+// HandleResolveHandle serves the administrative end-point that resolves a "@user@host" handle to its actor,
+// using WebFinger and the actor's origin server, without using any caches.
+func HandleResolveHandle(fb FedBOX) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkAdmin(fb.actorFromRequest(r), fb.self); err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		act, err := resolveHandle(r.Context(), &fb.client, r.URL.Query().Get(handleKey))
+		if err != nil {
+			fb.errFn("unable to resolve handle: %+s", err)
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		data, err := vocab.MarshalJSON(act)
+		if err != nil {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", client.ContentTypeActivityJson)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
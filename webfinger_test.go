@@ -0,0 +1,79 @@
+package fedbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func mockWebFingerServer(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimPrefix(srv.URL, "https://")
+		switch r.URL.Path {
+		case "/.well-known/webfinger":
+			if r.URL.Query().Get("resource") != fmt.Sprintf("acct:johndoe@%s", host) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/jrd+json")
+			fmt.Fprintf(w, `{"subject":"acct:johndoe@%s","links":[`+
+				`{"rel":"http://webfinger.net/rel/profile-page","type":"text/html","href":"%s/@johndoe"},`+
+				`{"rel":"self","type":"application/activity+json","href":"%s/users/johndoe"}]}`, host, srv.URL, srv.URL)
+		case "/users/johndoe":
+			if r.Header.Get("Cache-Control") != "no-cache" {
+				t.Errorf("the actor request should bypass the caches")
+			}
+			w.Header().Set("Content-Type", "application/activity+json")
+			fmt.Fprintf(w, `{"id":"%s/users/johndoe","type":"Person","preferredUsername":"johndoe",`+
+				`"inbox":"%s/users/johndoe/inbox"}`, srv.URL, srv.URL)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv
+}
+
+func TestResolveHandle(t *testing.T) {
+	srv := mockWebFingerServer(t)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	act, err := resolveHandle(context.Background(), srv.Client(), fmt.Sprintf("@johndoe@%s", host))
+	if err != nil {
+		t.Fatalf("resolveHandle() returned error %s", err)
+	}
+	if want := vocab.IRI(srv.URL + "/users/johndoe"); !act.ID.Equals(want, false) {
+		t.Errorf("resolved actor %s, expected %s", act.ID, want)
+	}
+	if want := vocab.IRI(srv.URL + "/users/johndoe/inbox"); !act.Inbox.GetLink().Equals(want, false) {
+		t.Errorf("resolved actor inbox %s, expected %s", act.Inbox.GetLink(), want)
+	}
+
+	if _, err = resolveHandle(context.Background(), srv.Client(), fmt.Sprintf("@janedoe@%s", host)); !errors.IsNotFound(err) {
+		t.Errorf("resolveHandle() for an unknown handle should return a not found error, received %v", err)
+	}
+	if _, err = resolveHandle(context.Background(), srv.Client(), "johndoe"); !errors.IsBadRequest(err) {
+		t.Errorf("resolveHandle() for an invalid handle should return a bad request error, received %v", err)
+	}
+}
+
+func TestCheckAdmin(t *testing.T) {
+	self := &vocab.Actor{ID: "https://fedbox.local/", Type: vocab.ServiceType}
+	if err := checkAdmin(*self, self); err != nil {
+		t.Errorf("checkAdmin() for the self service returned error %s", err)
+	}
+	if err := checkAdmin(vocab.Actor{}, self); !errors.IsUnauthorized(err) {
+		t.Errorf("checkAdmin() for an anonymous actor should return an unauthorized error, received %v", err)
+	}
+	johnDoe := vocab.Actor{ID: "https://fedbox.local/actors/johndoe", Type: vocab.PersonType}
+	if err := checkAdmin(johnDoe, self); !errors.IsForbidden(err) {
+		t.Errorf("checkAdmin() for a regular actor should return a forbidden error, received %v", err)
+	}
+}